@@ -0,0 +1,104 @@
+package client
+
+import (
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// dcmtkAssociateAC is an A-ASSOCIATE-AC as sent by DCMTK's storescp,
+// answering contexts 1 (Verification), 3 (MR, abstract syntax not supported)
+// and 5 (CT). Each Presentation Context item (0x21) is laid out per PS3.8
+// Table 9-18: context ID, reserved, result/reason, reserved, then the
+// Transfer Syntax sub-item (0x40).
+var dcmtkAssociateAC = "0200000000e3" + // PDU header
+	"0001000053544f5245534350202020202020202046494e44534355202020202020202020" + // Version, reserved, AE titles
+	"0000000000000000000000000000000000000000000000000000000000000000" + // Reserved
+	"10000015312e322e3834302e31303030382e332e312e312e31" + // Application Context
+	"210000190100000040000011312e322e3834302e31303030382e312e32" + // Context 1: acceptance, Implicit VR LE
+	"210000080300030040000000" + // Context 3: abstract syntax not supported
+	"2100001b0500000040000013312e322e3834302e31303030382e312e322e31" + // Context 5: acceptance, Explicit VR LE
+	"5000003a51000004000040005200001b312e322e3237362e302e373233303031302e332e302e332e362e38" +
+	"5500000f4f464649535f44434d544b5f333638" // User Information
+
+func TestReceiveAssociateAC_DCMTK(t *testing.T) {
+	raw, err := hex.DecodeString(dcmtkAssociateAC)
+	if err != nil {
+		t.Fatalf("Invalid capture: %v", err)
+	}
+
+	conn := newMockConn()
+	conn.readBuf.Write(raw)
+	assoc := &Association{
+		conn:             conn,
+		presentationCtxs: make(map[byte]*PresentationContext),
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		preferredTransferSyntaxes: []string{
+			types.ExplicitVRLittleEndian,
+			types.ImplicitVRLittleEndian,
+		},
+	}
+	assoc.addPresentationContext(nil, 1, types.VerificationSOPClass, assoc.preferredTransferSyntaxes)
+	assoc.addPresentationContext(nil, 3, types.MRImageStorage, assoc.preferredTransferSyntaxes)
+	assoc.addPresentationContext(nil, 5, types.CTImageStorage, assoc.preferredTransferSyntaxes)
+
+	if err := assoc.receiveAssociateAC(); err != nil {
+		t.Fatalf("receiveAssociateAC() error = %v", err)
+	}
+
+	tests := []struct {
+		id             byte
+		accepted       bool
+		transferSyntax string
+	}{
+		{1, true, types.ImplicitVRLittleEndian},
+		{3, false, ""},
+		{5, true, types.ExplicitVRLittleEndian},
+	}
+	for _, tt := range tests {
+		pc := assoc.presentationCtxs[tt.id]
+		if pc.Accepted != tt.accepted {
+			t.Errorf("Context %d accepted = %v, want %v", tt.id, pc.Accepted, tt.accepted)
+		}
+		if tt.accepted && pc.TransferSyntax != tt.transferSyntax {
+			t.Errorf("Context %d transfer syntax = %s, want %s", tt.id, pc.TransferSyntax, tt.transferSyntax)
+		}
+	}
+}
+
+func TestReceiveAssociateAC_ReservedBytesIgnored(t *testing.T) {
+	raw, err := hex.DecodeString(dcmtkAssociateAC)
+	if err != nil {
+		t.Fatalf("Invalid capture: %v", err)
+	}
+	// Fill the reserved bytes after each context ID and result with
+	// non-zero values; only the result/reason byte may be read
+	for _, item := range []int{6 + 68 + 25, 6 + 68 + 25 + 29, 6 + 68 + 25 + 29 + 12} {
+		raw[item+5] = 0xff
+		raw[item+7] = 0xff
+	}
+
+	conn := newMockConn()
+	conn.readBuf.Write(raw)
+	assoc := &Association{
+		conn:             conn,
+		presentationCtxs: make(map[byte]*PresentationContext),
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	assoc.addPresentationContext(nil, 1, types.VerificationSOPClass, assoc.preferredTransferSyntaxes)
+	assoc.addPresentationContext(nil, 3, types.MRImageStorage, assoc.preferredTransferSyntaxes)
+	assoc.addPresentationContext(nil, 5, types.CTImageStorage, assoc.preferredTransferSyntaxes)
+
+	if err := assoc.receiveAssociateAC(); err != nil {
+		t.Fatalf("receiveAssociateAC() error = %v", err)
+	}
+	if !assoc.presentationCtxs[1].Accepted || !assoc.presentationCtxs[5].Accepted {
+		t.Error("Accepted contexts read as rejected")
+	}
+	if assoc.presentationCtxs[3].Accepted {
+		t.Error("Rejected context read as accepted")
+	}
+}
@@ -2,13 +2,18 @@ package client
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/caio-sobreiro/dicomnet/dimse"
 	"github.com/caio-sobreiro/dicomnet/pdu"
 	"github.com/caio-sobreiro/dicomnet/types"
 )
@@ -23,8 +28,61 @@ type Association struct {
 	logger                    *slog.Logger
 	preferredTransferSyntaxes []string
 	sopClasses                []string
+
+	// proposeRelational requests relational queries for Query/Retrieve SOP
+	// classes; relationalQueries records, per SOP class, what the SCP agreed
+	proposeRelational bool
+	relationalQueries map[string]bool
+
+	// contextPerTransferSyntax proposes each transfer syntax in its own context
+	contextPerTransferSyntax bool
+
+	// proposals, when set, replaces the SOP classes × transfer syntaxes
+	// proposal with explicit presentation contexts
+	proposals []PresentationContextProposal
+
+	// state is where the association is in its lifecycle; operations
+	// require StateEstablished
+	state AssociationState
+
+	// address and config are what Connect was called with, for Renegotiate
+	address string
+	config  Config
+
+	// readTimeout and writeTimeout are the deadlines set at connect, which
+	// refreshDeadlines renews for long-lived (pooled) associations
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// counter counts the bytes carried by the transport, for Stats
+	counter *pdu.CountingConn
+
+	// proposeOperationsInvoked is the Asynchronous Operations Window
+	// proposed; maxOperationsInvoked is how many operations the SCP lets
+	// this association keep outstanding (0 when not negotiated)
+	proposeOperationsInvoked int
+	maxOperationsInvoked     int
+}
+
+// Stats returns the bytes read and written on the association's transport so
+// far, including the association negotiation.
+func (a *Association) Stats() pdu.ConnStats {
+	return a.counter.Stats()
 }
 
+// ErrPeerReleased is returned by operations when the SCP released the
+// association instead of responding. Close then only closes the connection.
+var ErrPeerReleased = dimse.ErrPeerReleased
+
+// ErrInstanceUIDMismatch is returned, together with the response, when a
+// C-STORE-RSP names a different SOP instance than the one sent.
+var ErrInstanceUIDMismatch = dimse.ErrInstanceUIDMismatch
+
+// ErrNoContextsAccepted is returned by Connect when the SCP accepted the
+// association but none of the proposed presentation contexts, leaving it
+// unable to carry any operation.
+var ErrNoContextsAccepted = errors.New("no presentation contexts accepted")
+
 // PresentationContext holds negotiated presentation context info
 type PresentationContext struct {
 	ID             byte
@@ -33,6 +91,34 @@ type PresentationContext struct {
 	Accepted       bool
 }
 
+// PresentationContextProposal is one presentation context to propose: an
+// abstract syntax with its transfer syntaxes in order of preference. With no
+// transfer syntaxes, Config.PreferredTransferSyntaxes are proposed.
+type PresentationContextProposal struct {
+	ID               byte // Presentation context ID: odd and unique, or 0 to number it automatically
+	AbstractSyntax   string
+	TransferSyntaxes []string
+}
+
+// validatePresentationContexts checks that explicit context IDs are odd, as
+// PS3.8 requires of the association requestor, and not used twice
+func validatePresentationContexts(contexts []PresentationContextProposal) error {
+	seen := make(map[byte]bool)
+	for _, pc := range contexts {
+		if pc.ID == 0 {
+			continue
+		}
+		if pc.ID%2 == 0 {
+			return fmt.Errorf("presentation context ID %d for %s is even; requestor context IDs must be odd", pc.ID, pc.AbstractSyntax)
+		}
+		if seen[pc.ID] {
+			return fmt.Errorf("presentation context ID %d is proposed more than once", pc.ID)
+		}
+		seen[pc.ID] = true
+	}
+	return nil
+}
+
 // Config holds client configuration
 type Config struct {
 	CallingAETitle            string
@@ -44,21 +130,36 @@ type Config struct {
 	Logger                    *slog.Logger  // Logger for the association (default: slog.Default())
 	PreferredTransferSyntaxes []string      // Transfer syntaxes to propose (default: Explicit VR, Implicit VR)
 	SOPClasses                []string      // SOP Classes to propose (default: common storage + query/retrieve classes)
+	KeepAlive                 time.Duration // TCP keep-alive period (default: 30s, negative disables)
+	DisableNoDelay            bool          // Re-enable Nagle's algorithm (TCP_NODELAY is set by default)
+	ReadBufferSize            int           // Size of the socket read buffer in bytes (default: unbuffered)
+	WriteBufferSize           int           // Size of the socket write buffer in bytes, flushed once per DIMSE message (default: unbuffered)
+	RelationalQueries         bool          // Propose relational queries/retrieval for Query/Retrieve SOP classes
+	ContextPerTransferSyntax  bool          // Propose one context per SOP class and transfer syntax, so several can be accepted
+	MaxOperationsInvoked      int           // Propose an Asynchronous Operations Window of this many outstanding operations (default: synchronous)
+	DefaultPort               int           // Port used when the address has none, e.g. 104 (default: a port is required)
+	RawPDULog                 io.Writer     // Receives a hex dump of each PDU read or written; wrap it in pdu.SyncWriter when associations share it (default: off)
+	RawPDULogMaxBytes         int           // Bytes of each PDU dumped to RawPDULog (default: whole PDUs)
+
+	// PresentationContexts, when set, are proposed instead of SOPClasses
+	// with PreferredTransferSyntaxes
+	PresentationContexts []PresentationContextProposal
 }
 
 // Connect establishes a DICOM association with a remote SCP
 func Connect(address string, config Config) (*Association, error) {
-	if config.MaxPDULength == 0 {
-		config.MaxPDULength = 16384 // Default 16KB
+	if err := validatePresentationContexts(config.PresentationContexts); err != nil {
+		return nil, err
+	}
+	address, err := resolveAddress(address, config.DefaultPort)
+	if err != nil {
+		return nil, err
 	}
 	if config.ConnectTimeout == 0 {
 		config.ConnectTimeout = 30 * time.Second
 	}
-	if config.ReadTimeout == 0 {
-		config.ReadTimeout = 60 * time.Second
-	}
-	if config.WriteTimeout == 0 {
-		config.WriteTimeout = 60 * time.Second
+	if config.KeepAlive == 0 {
+		config.KeepAlive = pdu.DefaultKeepAlive
 	}
 
 	// Establish TCP connection with timeout
@@ -70,6 +171,50 @@ func Connect(address string, config Config) (*Association, error) {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
+	if err := pdu.ConfigureConn(conn, config.KeepAlive, !config.DisableNoDelay); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newAssociation(conn, address, config)
+}
+
+// NewAssociation negotiates an association over conn instead of dialing, so
+// DICOM can run over any transport, e.g. a WebSocket or an SSH channel. The
+// association owns conn and closes it when released, aborted or when
+// negotiation fails. The TCP options and address settings of config are not
+// used; Renegotiate is not available on such associations.
+func NewAssociation(conn net.Conn, config Config) (*Association, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+	if err := validatePresentationContexts(config.PresentationContexts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newAssociation(conn, "", config)
+}
+
+// newAssociation negotiates an association over an established connection.
+// address is empty when the caller supplied the connection.
+func newAssociation(conn net.Conn, address string, config Config) (*Association, error) {
+	if config.MaxPDULength == 0 {
+		config.MaxPDULength = 16384 // Default 16KB
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = 60 * time.Second
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 60 * time.Second
+	}
+	counter := pdu.NewCountingConn(conn)
+	conn = counter
+	if config.RawPDULog != nil {
+		conn = pdu.NewRawPDULogConn(conn, config.RawPDULog, config.RawPDULogMaxBytes)
+	}
+	if config.ReadBufferSize > 0 || config.WriteBufferSize > 0 {
+		conn = pdu.NewBufferedConn(conn, config.ReadBufferSize, config.WriteBufferSize)
+	}
+
 	// Set initial read/write timeouts
 	if err := conn.SetReadDeadline(time.Now().Add(config.ReadTimeout)); err != nil {
 		conn.Close()
@@ -97,6 +242,12 @@ func Connect(address string, config Config) (*Association, error) {
 
 	// Set default SOP classes if not provided
 	sopClasses := config.SOPClasses
+	if len(config.PresentationContexts) > 0 {
+		sopClasses = nil
+		for _, pc := range config.PresentationContexts {
+			sopClasses = appendMissing(sopClasses, pc.AbstractSyntax)
+		}
+	}
 	if len(sopClasses) == 0 {
 		sopClasses = getDefaultSOPClasses()
 	}
@@ -110,6 +261,16 @@ func Connect(address string, config Config) (*Association, error) {
 		logger:                    logger,
 		preferredTransferSyntaxes: transferSyntaxes,
 		sopClasses:                sopClasses,
+		proposeRelational:         config.RelationalQueries,
+		relationalQueries:         make(map[string]bool),
+		contextPerTransferSyntax:  config.ContextPerTransferSyntax,
+		proposals:                 config.PresentationContexts,
+		readTimeout:               config.ReadTimeout,
+		writeTimeout:              config.WriteTimeout,
+		address:                   address,
+		config:                    config,
+		counter:                   counter,
+		proposeOperationsInvoked:  config.MaxOperationsInvoked,
 	}
 
 	// Send association request
@@ -123,17 +284,128 @@ func Connect(address string, config Config) (*Association, error) {
 		conn.Close()
 		return nil, fmt.Errorf("failed to receive A-ASSOCIATE-AC: %w", err)
 	}
+	assoc.state = StateEstablished
+
+	// An association without a usable context fails every operation, so
+	// release it right away
+	if !assoc.hasAcceptedContext() {
+		assoc.Close()
+		return nil, fmt.Errorf("%w: all %d proposed presentation contexts were rejected", ErrNoContextsAccepted, len(assoc.presentationCtxs))
+	}
 
 	logger.Info("DICOM association established",
-		"remote_addr", address,
+		"remote_addr", conn.RemoteAddr(),
 		"calling_ae", config.CallingAETitle,
 		"called_ae", config.CalledAETitle)
 
 	return assoc, nil
 }
 
-// Close gracefully closes the association
+// resolveAddress validates a "host:port" address, accepting IPv6 literals
+// with or without brackets, and adds defaultPort when the address has no port
+func resolveAddress(address string, defaultPort int) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port: a host name, an IPv4 address or an IPv6 literal
+		host = address
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+		if host == "" || strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+			return "", fmt.Errorf("invalid address %q: %v", address, err)
+		}
+		if defaultPort == 0 {
+			return "", fmt.Errorf("invalid address %q: missing port and no default port configured", address)
+		}
+		port = strconv.Itoa(defaultPort)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return "", fmt.Errorf("invalid address %q: port %q is not a number between 1 and 65535", address, port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ConnectQR establishes an association proposing Verification and the
+// Patient Root and Study Root FIND, MOVE and GET models, so a single
+// association can query and then retrieve. SOP classes already in
+// config.SOPClasses are proposed as well.
+func ConnectQR(address string, config Config) (*Association, error) {
+	config.SOPClasses = appendMissing(QueryRetrieveSOPClasses(), config.SOPClasses...)
+	return Connect(address, config)
+}
+
+// QueryRetrieveSOPClasses returns Verification and the standard Patient Root
+// and Study Root Query/Retrieve information models proposed by ConnectQR
+func QueryRetrieveSOPClasses() []string {
+	return []string{
+		types.VerificationSOPClass,
+		types.PatientRootQueryRetrieveInformationModelFind,
+		types.PatientRootQueryRetrieveInformationModelMove,
+		types.PatientRootQueryRetrieveInformationModelGet,
+		types.StudyRootQueryRetrieveInformationModelFind,
+		types.StudyRootQueryRetrieveInformationModelMove,
+		types.StudyRootQueryRetrieveInformationModelGet,
+	}
+}
+
+// appendMissing appends the UIDs not already in list
+func appendMissing(list []string, uids ...string) []string {
+	for _, uid := range uids {
+		if !slices.Contains(list, uid) {
+			list = append(list, uid)
+		}
+	}
+	return list
+}
+
+// Renegotiate releases the association and opens a new one to the same SCP,
+// with the same configuration but proposing contexts, e.g. an instance's
+// native transfer syntax after a C-STORE found it was not accepted. Unless
+// contexts are invalid, the receiver is unusable afterwards, whether or not
+// the new association could be established.
+func (a *Association) Renegotiate(contexts []PresentationContextProposal) (*Association, error) {
+	if len(contexts) == 0 {
+		return nil, fmt.Errorf("no presentation contexts to propose")
+	}
+	if err := validatePresentationContexts(contexts); err != nil {
+		return nil, err
+	}
+	if a.address == "" {
+		return nil, fmt.Errorf("cannot renegotiate an association created with NewAssociation")
+	}
+
+	if err := a.Close(); err != nil {
+		a.logger.Debug("Failed to close association before renegotiating", "error", err)
+	}
+
+	config := a.config
+	config.PresentationContexts = contexts
+	return Connect(a.address, config)
+}
+
+// refreshDeadlines restarts the read and write timeouts from now
+func (a *Association) refreshDeadlines() error {
+	if a.readTimeout > 0 {
+		if err := a.conn.SetReadDeadline(time.Now().Add(a.readTimeout)); err != nil {
+			return fmt.Errorf("failed to set read deadline: %w", err)
+		}
+	}
+	if a.writeTimeout > 0 {
+		if err := a.conn.SetWriteDeadline(time.Now().Add(a.writeTimeout)); err != nil {
+			return fmt.Errorf("failed to set write deadline: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close gracefully closes the association. Closing an association that was
+// released by the SCP or aborted only closes the connection.
 func (a *Association) Close() error {
+	if a.state == StateReleased || a.state == StateAborted {
+		return a.conn.Close()
+	}
+	a.state = StateReleasing
+
 	// Send release request
 	if err := a.sendReleaseRQ(); err != nil {
 		a.logger.Warn("Failed to send release request", "error", err)
@@ -141,6 +413,7 @@ func (a *Association) Close() error {
 
 	// Wait for release response (with timeout handled by TCP)
 	a.receiveReleaseRP()
+	a.state = StateReleased
 
 	return a.conn.Close()
 }
@@ -233,14 +506,30 @@ func (a *Association) sendAssociateRQ() error {
 	buf = append(buf, []byte(types.ApplicationContextUID)...) // Application Context UID
 
 	// Add Presentation Contexts for all SOP Classes
+	proposals := a.presentationContextProposals()
+	if len(proposals) > maxPresentationContexts {
+		return fmt.Errorf("%d presentation contexts proposed, at most %d are allowed",
+			len(proposals), maxPresentationContexts)
+	}
+	used := make(map[byte]bool)
+	for _, proposal := range proposals {
+		used[proposal.id] = true
+	}
 	contextID := byte(1)
-	for _, sopClass := range a.sopClasses {
-		buf = a.addPresentationContext(buf, contextID, sopClass)
-		contextID += 2 // Presentation context IDs must be odd
+	for _, proposal := range proposals {
+		id := proposal.id
+		if id == 0 {
+			for used[contextID] {
+				contextID += 2 // Presentation context IDs must be odd
+			}
+			id = contextID
+			contextID += 2
+		}
+		buf = a.addPresentationContext(buf, id, proposal.abstractSyntax, proposal.transferSyntaxes)
 	}
 
 	a.logger.Debug("Proposing presentation contexts",
-		"count", len(a.sopClasses),
+		"count", len(proposals),
 		"sop_classes", a.sopClasses)
 
 	// User Information Item
@@ -260,11 +549,49 @@ func (a *Association) sendAssociateRQ() error {
 		return err
 	}
 
-	return nil
+	return pdu.FlushConn(a.conn)
 }
 
 // addPresentationContext adds a presentation context to the buffer
-func (a *Association) addPresentationContext(buf []byte, contextID byte, abstractSyntax string) []byte {
+// maxPresentationContexts is the number of odd presentation context IDs
+const maxPresentationContexts = 128
+
+// contextProposal is one presentation context of the A-ASSOCIATE-RQ
+type contextProposal struct {
+	id               byte // 0 to take the next free odd ID
+	abstractSyntax   string
+	transferSyntaxes []string
+}
+
+// presentationContextProposals lists the contexts to propose: the explicit
+// proposals if any, else one per SOP class offering all preferred transfer
+// syntaxes, or one per SOP class and transfer syntax when
+// contextPerTransferSyntax is set
+func (a *Association) presentationContextProposals() []contextProposal {
+	var proposals []contextProposal
+	if len(a.proposals) > 0 {
+		for _, p := range a.proposals {
+			transferSyntaxes := p.TransferSyntaxes
+			if len(transferSyntaxes) == 0 {
+				transferSyntaxes = a.preferredTransferSyntaxes
+			}
+			proposals = append(proposals, contextProposal{p.ID, p.AbstractSyntax, transferSyntaxes})
+		}
+		return proposals
+	}
+	for _, sopClass := range a.sopClasses {
+		if !a.contextPerTransferSyntax {
+			proposals = append(proposals, contextProposal{0, sopClass, a.preferredTransferSyntaxes})
+			continue
+		}
+		for _, ts := range a.preferredTransferSyntaxes {
+			proposals = append(proposals, contextProposal{0, sopClass, []string{ts}})
+		}
+	}
+	return proposals
+}
+
+func (a *Association) addPresentationContext(buf []byte, contextID byte, abstractSyntax string, transferSyntaxes []string) []byte {
 	pcStart := len(buf)
 
 	// Presentation Context Item
@@ -281,7 +608,7 @@ func (a *Association) addPresentationContext(buf []byte, contextID byte, abstrac
 	buf = append(buf, []byte(abstractSyntax)...)
 
 	// Transfer Syntax Sub-Items - use configured transfer syntaxes (order matters - first is preferred)
-	for _, ts := range a.preferredTransferSyntaxes {
+	for _, ts := range transferSyntaxes {
 		buf = append(buf, 0x40)                // Item type
 		buf = append(buf, 0x00)                // Reserved
 		buf = append(buf, 0x00, byte(len(ts))) // Length
@@ -334,6 +661,38 @@ func (a *Association) addUserInformation(buf []byte) []byte {
 	buf = append(buf, 0x00, byte(len(implVersion))) // Length
 	buf = append(buf, []byte(implVersion)...)
 
+	// Asynchronous Operations Window Sub-Item: operations invoked by this
+	// side, operations it performs (none, as an SCU)
+	if a.proposeOperationsInvoked > 1 {
+		buf = append(buf, 0x53, 0x00, 0x00, 0x04) // Item type, reserved, length
+		buf = binary.BigEndian.AppendUint16(buf, uint16(a.proposeOperationsInvoked))
+		buf = binary.BigEndian.AppendUint16(buf, 1)
+	}
+
+	// SCP/SCU Role Selection Sub-Items taking both roles, so that C-GET
+	// sub-operations can be received on the storage contexts
+	for _, sopClass := range a.scpRoleSOPClasses() {
+		buf = append(buf, 0x54, 0x00) // Item type, reserved
+		buf = binary.BigEndian.AppendUint16(buf, uint16(2+len(sopClass)+2))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(sopClass)))
+		buf = append(buf, []byte(sopClass)...)
+		buf = append(buf, 0x01, 0x01) // SCU role, SCP role
+	}
+
+	// SOP Class Extended Negotiation Sub-Items requesting relational queries
+	if a.proposeRelational {
+		for _, sopClass := range a.sopClasses {
+			if !types.IsQueryRetrieveSOPClass(sopClass) {
+				continue
+			}
+			buf = append(buf, 0x56, 0x00) // Item type, reserved
+			buf = binary.BigEndian.AppendUint16(buf, uint16(2+len(sopClass)+1))
+			buf = binary.BigEndian.AppendUint16(buf, uint16(len(sopClass)))
+			buf = append(buf, []byte(sopClass)...)
+			buf = append(buf, 0x01) // Relational queries/retrieval supported
+		}
+	}
+
 	// Update User Information length
 	uiLength := len(buf) - uiStart - 4
 	binary.BigEndian.PutUint16(buf[uiStart+2:uiStart+4], uint16(uiLength))
@@ -341,6 +700,42 @@ func (a *Association) addUserInformation(buf []byte) []byte {
 	return buf
 }
 
+// scpRoleSOPClasses returns the storage SOP classes to take the SCP role for
+// (PS3.4 C.4.3.1.1): every proposed one when a retrieve model is proposed too,
+// else none
+func (a *Association) scpRoleSOPClasses() []string {
+	proposals := a.presentationContextProposals()
+	retrieve := false
+	for _, p := range proposals {
+		if types.IsRetrieveModel(p.abstractSyntax) {
+			retrieve = true
+			break
+		}
+	}
+	if !retrieve {
+		return nil
+	}
+
+	var sopClasses []string
+	for _, p := range proposals {
+		if types.IsStorageSOPClass(p.abstractSyntax) && !slices.Contains(sopClasses, p.abstractSyntax) {
+			sopClasses = append(sopClasses, p.abstractSyntax)
+		}
+	}
+	return sopClasses
+}
+
+// hasAcceptedContext reports whether the SCP accepted any proposed
+// presentation context
+func (a *Association) hasAcceptedContext() bool {
+	for _, pc := range a.presentationCtxs {
+		if pc.Accepted {
+			return true
+		}
+	}
+	return false
+}
+
 // receiveAssociateAC receives and parses A-ASSOCIATE-AC
 func (a *Association) receiveAssociateAC() error {
 	// Read PDU header
@@ -353,7 +748,15 @@ func (a *Association) receiveAssociateAC() error {
 	pduLength := binary.BigEndian.Uint32(header[2:6])
 
 	if pduType == pdu.TypeAssociateRJ {
-		return fmt.Errorf("association rejected by peer")
+		body := make([]byte, min(pduLength, 4))
+		if _, err := io.ReadFull(a.conn, body); err != nil {
+			return fmt.Errorf("association rejected by peer")
+		}
+		result, source, reason, err := pdu.ParseAssociateReject(append(header, body...))
+		if err != nil {
+			return fmt.Errorf("association rejected by peer: %v", err)
+		}
+		return fmt.Errorf("association rejected by peer (result %d, source %d, reason %d)", result, source, reason)
 	}
 
 	if pduType != pdu.TypeAssociateAC {
@@ -361,13 +764,13 @@ func (a *Association) receiveAssociateAC() error {
 	}
 
 	// Read PDU data
-	data := make([]byte, pduLength)
-	if _, err := io.ReadFull(a.conn, data); err != nil {
+	data, err := pdu.ReadPDUBody(a.conn, pduLength)
+	if err != nil {
 		return fmt.Errorf("failed to read PDU data: %w", err)
 	}
 
-	// Parse presentation context results (simplified)
-	// In production, you'd want to parse all items properly
+	// Parse presentation context results and user information; other
+	// items, such as the application context, are skipped
 	offset := 68 // Skip fixed fields and app context
 	for offset+4 <= len(data) {
 		itemType := data[offset]
@@ -378,30 +781,7 @@ func (a *Association) receiveAssociateAC() error {
 		}
 
 		if itemType == 0x21 { // Presentation Context Result
-			contextID := data[offset+4]
-			result := byte(0xff)
-			if itemLength >= 4 {
-				result = data[offset+7]
-			}
-
-			transferSyntax := ""
-			subOffset := offset + 8
-			for subOffset+4 <= itemEnd {
-				subItemType := data[subOffset]
-				subItemLength := binary.BigEndian.Uint16(data[subOffset+2 : subOffset+4])
-				subItemEnd := subOffset + 4 + int(subItemLength)
-				if subItemEnd > itemEnd {
-					break
-				}
-
-				if subItemType == 0x40 && subItemLength > 0 {
-					tsVal := string(data[subOffset+4 : subItemEnd])
-					transferSyntax = strings.TrimRight(tsVal, "\x00 ")
-				}
-
-				subOffset = subItemEnd
-			}
-
+			contextID, result, transferSyntax := parsePresentationContextAC(data[offset+4 : itemEnd])
 			if pc, ok := a.presentationCtxs[contextID]; ok {
 				pc.Accepted = (result == 0)
 				if pc.Accepted && transferSyntax != "" {
@@ -416,12 +796,102 @@ func (a *Association) receiveAssociateAC() error {
 			}
 		}
 
+		if itemType == 0x50 { // User Information
+			a.parseUserInformation(data[offset+4 : itemEnd])
+		}
+
 		offset = itemEnd
 	}
 
 	return nil
 }
 
+// parsePresentationContextAC decodes the value of a Presentation Context
+// item (0x21) of an A-ASSOCIATE-AC per PS3.8 Table 9-18: context ID, reserved,
+// result/reason, reserved, then sub-items. Only the Transfer Syntax sub-item
+// (0x40) is read; reserved bytes are ignored whatever the peer put there. A
+// truncated item yields result 0xff, which is treated as not accepted.
+func parsePresentationContextAC(item []byte) (contextID, result byte, transferSyntax string) {
+	if len(item) < 4 {
+		if len(item) > 0 {
+			contextID = item[0]
+		}
+		return contextID, 0xff, ""
+	}
+	contextID, result = item[0], item[2]
+
+	offset := 4
+	for offset+4 <= len(item) {
+		subItemType := item[offset]
+		subItemEnd := offset + 4 + int(binary.BigEndian.Uint16(item[offset+2:offset+4]))
+		if subItemEnd > len(item) {
+			break
+		}
+		if subItemType == 0x40 {
+			transferSyntax = strings.TrimRight(string(item[offset+4:subItemEnd]), "\x00 ")
+		}
+		offset = subItemEnd
+	}
+	return contextID, result, transferSyntax
+}
+
+// parseUserInformation records the SCP's replies to SOP Class Extended
+// Negotiation and the Asynchronous Operations Window. Other sub-items are
+// ignored.
+func (a *Association) parseUserInformation(data []byte) {
+	offset := 0
+	for offset+4 <= len(data) {
+		subItemType := data[offset]
+		subItemEnd := offset + 4 + int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+		if subItemEnd > len(data) {
+			return
+		}
+
+		if subItemType == 0x53 && subItemEnd-offset == 8 && a.proposeOperationsInvoked > 1 { // Asynchronous Operations Window
+			// The SCP's second field is how many operations it performs,
+			// i.e. how many this side may invoke; 0 means unlimited
+			performed := int(binary.BigEndian.Uint16(data[offset+6 : offset+8]))
+			if performed == 0 || performed > a.proposeOperationsInvoked {
+				performed = a.proposeOperationsInvoked
+			}
+			a.maxOperationsInvoked = performed
+		}
+
+		if subItemType == 0x56 { // SOP Class Extended Negotiation
+			value := data[offset+4 : subItemEnd]
+			if len(value) >= 2 {
+				uidEnd := 2 + int(binary.BigEndian.Uint16(value[0:2]))
+				if uidEnd <= len(value) {
+					sopClass := strings.TrimRight(string(value[2:uidEnd]), "\x00 ")
+					appInfo := value[uidEnd:]
+					if a.relationalQueries == nil {
+						a.relationalQueries = make(map[string]bool)
+					}
+					a.relationalQueries[sopClass] = len(appInfo) > 0 && appInfo[0] == 1
+				}
+			}
+		}
+
+		offset = subItemEnd
+	}
+}
+
+// MaxOperationsInvoked returns how many operations may be outstanding at
+// once, as negotiated in the Asynchronous Operations Window, or 1 when no
+// window was negotiated
+func (a *Association) MaxOperationsInvoked() int {
+	if a.maxOperationsInvoked < 1 {
+		return 1
+	}
+	return a.maxOperationsInvoked
+}
+
+// RelationalQueriesNegotiated reports whether the SCP agreed to relational
+// queries/retrieval for the given SOP class
+func (a *Association) RelationalQueriesNegotiated(sopClass string) bool {
+	return a.relationalQueries[sopClass]
+}
+
 // sendReleaseRQ sends an A-RELEASE-RQ PDU
 func (a *Association) sendReleaseRQ() error {
 	pduData := make([]byte, 6)
@@ -437,47 +907,126 @@ func (a *Association) sendReleaseRQ() error {
 		return err
 	}
 
-	return nil
+	return pdu.FlushConn(a.conn)
 }
 
-// receiveReleaseRP receives A-RELEASE-RP (or timeout)
-func (a *Association) receiveReleaseRP() error {
-	header := make([]byte, 6)
-	if _, err := io.ReadFull(a.conn, header); err != nil {
-		return err // Connection closed or timeout
+// receiveMessage reads the next DIMSE message, noting a release by the peer
+func (a *Association) receiveMessage() (*types.Message, []byte, error) {
+	msg, data, err := dimse.ReceiveDIMSEMessage(a.conn)
+	a.notePeerRelease(err)
+	return msg, data, err
+}
+
+// notePeerRelease records that err reports the association released by the peer
+func (a *Association) notePeerRelease(err error) {
+	if errors.Is(err, ErrPeerReleased) {
+		a.state = StateReleased
 	}
+}
 
-	pduType := header[0]
-	pduLength := binary.BigEndian.Uint32(header[2:6])
+// receiveReleaseRP receives A-RELEASE-RP (or timeout). On a release
+// collision, where the SCP sent its own A-RELEASE-RQ, the requestor answers
+// it first and then keeps waiting for the A-RELEASE-RP (PS3.8 7.2).
+func (a *Association) receiveReleaseRP() error {
+	for {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(a.conn, header); err != nil {
+			return err // Connection closed or timeout
+		}
 
-	if pduType != pdu.TypeReleaseRP {
-		return fmt.Errorf("unexpected PDU type: 0x%02x", pduType)
-	}
+		pduType := header[0]
+		pduLength := binary.BigEndian.Uint32(header[2:6])
 
-	// Read and discard PDU data
-	data := make([]byte, pduLength)
-	io.ReadFull(a.conn, data)
+		// Read and discard PDU data
+		io.CopyN(io.Discard, a.conn, int64(pduLength))
 
-	return nil
+		switch pduType {
+		case pdu.TypeReleaseRP:
+			return nil
+		case pdu.TypeReleaseRQ:
+			a.logger.Debug("Release collision, answering the SCP's A-RELEASE-RQ")
+			releaseRP := []byte{pdu.TypeReleaseRP, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+			if _, err := a.conn.Write(releaseRP); err != nil {
+				return err
+			}
+			if err := pdu.FlushConn(a.conn); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected PDU type: 0x%02x", pduType)
+		}
+	}
 }
 
 // GetPresentationContextID finds a presentation context for the given abstract syntax
 func (a *Association) GetPresentationContextID(abstractSyntax string) (byte, error) {
+	pc, err := a.GetPresentationContext(abstractSyntax)
+	if err != nil {
+		return 0, err
+	}
+	return pc.ID, nil
+}
+
+// GetPresentationContext returns the accepted presentation context for the given
+// SOP class (abstract syntax), including the transfer syntax negotiated for it.
+// Datasets sent on that context must be encoded with this transfer syntax.
+func (a *Association) GetPresentationContext(abstractSyntax string) (*PresentationContext, error) {
+	if contexts := a.acceptedContextsFor(abstractSyntax); len(contexts) > 0 {
+		return contexts[0], nil
+	}
+	return nil, fmt.Errorf("no accepted presentation context for abstract syntax: %s", abstractSyntax)
+}
+
+// PresentationContextFor returns the accepted presentation context for the
+// given SOP class that negotiated the given transfer syntax. The SCP can only
+// accept several transfer syntaxes for one SOP class when each was proposed
+// in its own context; see Config.ContextPerTransferSyntax.
+func (a *Association) PresentationContextFor(abstractSyntax, transferSyntax string) (*PresentationContext, error) {
+	for _, pc := range a.acceptedContextsFor(abstractSyntax) {
+		if pc.TransferSyntax == transferSyntax {
+			return pc, nil
+		}
+	}
+	return nil, fmt.Errorf("no accepted presentation context for abstract syntax %s with transfer syntax %s",
+		abstractSyntax, transferSyntax)
+}
+
+// acceptedContextsFor returns the accepted contexts for an abstract syntax,
+// ordered by context ID so the choice between them is stable
+func (a *Association) acceptedContextsFor(abstractSyntax string) []*PresentationContext {
+	var contexts []*PresentationContext
 	for _, pc := range a.presentationCtxs {
 		if pc.AbstractSyntax == abstractSyntax && pc.Accepted {
-			return pc.ID, nil
+			contexts = append(contexts, pc)
+		}
+	}
+	sort.Slice(contexts, func(i, j int) bool {
+		return contexts[i].ID < contexts[j].ID
+	})
+	return contexts
+}
+
+// AcceptedContexts returns copies of the presentation contexts the peer
+// accepted, ordered by context ID, with their negotiated transfer syntaxes.
+func (a *Association) AcceptedContexts() []PresentationContext {
+	var accepted []PresentationContext
+	for _, pc := range a.presentationCtxs {
+		if pc.Accepted {
+			accepted = append(accepted, *pc)
 		}
 	}
-	return 0, fmt.Errorf("no accepted presentation context for abstract syntax: %s", abstractSyntax)
+	sort.Slice(accepted, func(i, j int) bool {
+		return accepted[i].ID < accepted[j].ID
+	})
+	return accepted
 }
 
 // GetNegotiatedTransferSyntax returns the transfer syntax that was negotiated
 // for the given SOP class (abstract syntax)
 func (a *Association) GetNegotiatedTransferSyntax(abstractSyntax string) (string, error) {
-	for _, pc := range a.presentationCtxs {
-		if pc.AbstractSyntax == abstractSyntax && pc.Accepted {
-			return pc.TransferSyntax, nil
-		}
+	pc, err := a.GetPresentationContext(abstractSyntax)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("no accepted presentation context for abstract syntax: %s", abstractSyntax)
+	return pc.TransferSyntax, nil
 }
@@ -12,6 +12,10 @@ import (
 // C-CANCEL does not have a response - it's a notification to the SCP to stop sending
 // pending responses for the specified operation.
 func (a *Association) SendCCancel(messageID uint16, sopClassUID string) error {
+	if err := a.checkEstablished(); err != nil {
+		return err
+	}
+
 	if messageID == 0 {
 		return fmt.Errorf("messageID must be non-zero for C-CANCEL")
 	}
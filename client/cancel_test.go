@@ -2,9 +2,11 @@ package client
 
 import (
 	"encoding/binary"
+	"io"
 	"log/slog"
 	"testing"
 
+	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
 	"github.com/caio-sobreiro/dicomnet/types"
 )
@@ -12,6 +14,7 @@ import (
 func TestSendCCancel(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:          StateEstablished,
 		conn:           conn,
 		callingAETitle: "TEST_SCU",
 		calledAETitle:  "TEST_SCP",
@@ -116,6 +119,7 @@ func TestSendCCancel(t *testing.T) {
 func TestSendCCancelErrors(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:          StateEstablished,
 		conn:           conn,
 		callingAETitle: "TEST_SCU",
 		calledAETitle:  "TEST_SCP",
@@ -148,3 +152,86 @@ func TestSendCCancelErrors(t *testing.T) {
 		t.Fatal("Expected error for unsupported SOP class, got nil")
 	}
 }
+
+func TestCFindStream_Cancel(t *testing.T) {
+	const sopClass = types.StudyRootQueryRetrieveInformationModelFind
+	conn := newMockConn()
+	assoc := &Association{
+		state:          StateEstablished,
+		conn:           conn,
+		callingAETitle: "TEST_SCU",
+		calledAETitle:  "TEST_SCP",
+		maxPDULength:   16384,
+		presentationCtxs: map[byte]*PresentationContext{
+			1: {ID: 1, AbstractSyntax: sopClass, TransferSyntax: types.ImplicitVRLittleEndian, Accepted: true},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// The SCP has sent three matches before it sees the cancel, then ends
+	// the query with status Cancel
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+	matchData, _ := dicom.EncodeDatasetWithTransferSyntax(match, types.ImplicitVRLittleEndian)
+	for _, status := range []uint16{dimse.StatusPending, dimse.StatusPending, dimse.StatusPending, 0xFE00} {
+		response := &types.Message{
+			CommandField:              dimse.CFindRSP,
+			MessageIDBeingRespondedTo: 7,
+			AffectedSOPClassUID:       sopClass,
+			CommandDataSetType:        0x0101,
+			Status:                    status,
+		}
+		var data []byte
+		if status == dimse.StatusPending {
+			response.CommandDataSetType = 0x0000
+			data = matchData
+		}
+		if err := dimse.SendDIMSEMessage(conn.readBuf, 1, 16384, dimse.EncodeCommandSet(response), data); err != nil {
+			t.Fatalf("SendDIMSEMessage() error = %v", err)
+		}
+	}
+
+	stream, err := assoc.StartCFind(&CFindRequest{
+		SOPClassUID: sopClass,
+		MessageID:   7,
+		Dataset:     dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D}),
+	})
+	if err != nil {
+		t.Fatalf("StartCFind() error = %v", err)
+	}
+	first, err := stream.Next()
+	if err != nil || first.Status != dimse.StatusPending || first.Dataset == nil {
+		t.Fatalf("First Next() = %+v, %v; want a pending match", first, err)
+	}
+
+	if err := stream.Cancel(); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if err := stream.Cancel(); err != nil {
+		t.Fatalf("Second Cancel() error = %v", err)
+	}
+
+	final, err := stream.Next()
+	if err != nil || final.Status != 0xFE00 {
+		t.Fatalf("Next() after Cancel = %+v, %v; want the final Cancel status", final, err)
+	}
+	if resp, err := stream.Next(); err != io.EOF {
+		t.Errorf("Next() after the final response = %+v, %v; want io.EOF", resp, err)
+	}
+
+	// The C-FIND-RQ is followed by exactly one C-CANCEL-RQ naming it
+	written := conn.writeBuf
+	if msg, _, err := dimse.ReceiveDIMSEMessage(written); err != nil || msg.CommandField != dimse.CFindRQ {
+		t.Fatalf("First written message = %+v, %v; want the C-FIND-RQ", msg, err)
+	}
+	cancel, _, err := dimse.ReceiveDIMSEMessage(written)
+	if err != nil {
+		t.Fatalf("ReceiveDIMSEMessage() error = %v", err)
+	}
+	if cancel.CommandField != dimse.CCancelRQ || cancel.MessageIDBeingRespondedTo != 7 {
+		t.Errorf("Second written message = %+v, want C-CANCEL-RQ for message 7", cancel)
+	}
+	if written.Len() != 0 {
+		t.Errorf("%d bytes written after the C-CANCEL-RQ, want none", written.Len())
+	}
+}
@@ -3,9 +3,12 @@ package client
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -186,6 +189,7 @@ func TestTransferSyntaxNegotiation(t *testing.T) {
 func TestSendPDataTF_Fragmentation(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:            StateEstablished,
 		conn:             conn,
 		callingAETitle:   "TEST_SCU",
 		calledAETitle:    "TEST_SCP",
@@ -235,6 +239,7 @@ func TestSendPDataTF_Fragmentation(t *testing.T) {
 func TestSendDIMSEMessage(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:            StateEstablished,
 		conn:             conn,
 		callingAETitle:   "TEST_SCU",
 		calledAETitle:    "TEST_SCP",
@@ -243,7 +248,12 @@ func TestSendDIMSEMessage(t *testing.T) {
 		logger:           slog.Default(),
 	}
 
-	commandData := []byte{0x01, 0x02, 0x03, 0x04}
+	commandData := dimse.EncodeCommandSet(&types.Message{
+		CommandField:        dimse.CStoreRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.CTImageStorage,
+		CommandDataSetType:  0x0000,
+	})
 	datasetData := []byte{0x05, 0x06, 0x07, 0x08, 0x09, 0x0A}
 
 	err := dimse.SendDIMSEMessage(assoc.conn, 1, assoc.maxPDULength, commandData, datasetData)
@@ -284,6 +294,7 @@ func TestSendDIMSEMessage(t *testing.T) {
 func TestReceiveCStoreResponse_Abort(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:            StateEstablished,
 		conn:             conn,
 		callingAETitle:   "TEST_SCU",
 		calledAETitle:    "TEST_SCP",
@@ -412,6 +423,7 @@ func TestCStoreRequest(t *testing.T) {
 func TestClose(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:            StateEstablished,
 		conn:             conn,
 		callingAETitle:   "TEST_SCU",
 		calledAETitle:    "TEST_SCP",
@@ -429,3 +441,412 @@ func TestClose(t *testing.T) {
 		t.Error("Connection not closed")
 	}
 }
+
+func TestAcceptedContexts(t *testing.T) {
+	conn := newMockConn()
+	assoc := &Association{
+		state:            StateEstablished,
+		conn:             conn,
+		presentationCtxs: make(map[byte]*PresentationContext),
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		preferredTransferSyntaxes: []string{
+			types.ExplicitVRLittleEndian,
+			types.ImplicitVRLittleEndian,
+		},
+	}
+	assoc.addPresentationContext(nil, 1, types.VerificationSOPClass, assoc.preferredTransferSyntaxes)
+	assoc.addPresentationContext(nil, 3, types.CTImageStorage, assoc.preferredTransferSyntaxes)
+	assoc.addPresentationContext(nil, 5, types.MRImageStorage, assoc.preferredTransferSyntaxes)
+
+	// A-ASSOCIATE-AC accepting Verification and CT, rejecting MR
+	result := func(id, reason byte, ts string) []byte {
+		item := []byte{id, 0x00, reason, 0x00, 0x40, 0x00}
+		item = binary.BigEndian.AppendUint16(item, uint16(len(ts)))
+		item = append(item, ts...)
+		out := []byte{0x21, 0x00}
+		out = binary.BigEndian.AppendUint16(out, uint16(len(item)))
+		return append(out, item...)
+	}
+	data := make([]byte, 68)
+	data = append(data, result(5, 0x03, "")...)
+	data = append(data, result(3, 0x00, types.ImplicitVRLittleEndian)...)
+	data = append(data, result(1, 0x00, types.ExplicitVRLittleEndian)...)
+
+	conn.readBuf.Write([]byte{pdu.TypeAssociateAC, 0x00})
+	conn.readBuf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	conn.readBuf.Write(data)
+
+	if err := assoc.receiveAssociateAC(); err != nil {
+		t.Fatalf("receiveAssociateAC() error = %v", err)
+	}
+
+	want := []PresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntax: types.ExplicitVRLittleEndian, Accepted: true},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntax: types.ImplicitVRLittleEndian, Accepted: true},
+	}
+	got := assoc.AcceptedContexts()
+	if len(got) != len(want) {
+		t.Fatalf("AcceptedContexts() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("AcceptedContexts()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The result is a copy
+	got[0].TransferSyntax = "modified"
+	if ts, _ := assoc.GetNegotiatedTransferSyntax(types.VerificationSOPClass); ts != types.ExplicitVRLittleEndian {
+		t.Errorf("Modifying the returned slice changed the association: %s", ts)
+	}
+}
+
+func TestPeerRelease(t *testing.T) {
+	conn := newMockConn()
+	assoc := &Association{
+		state:          StateEstablished,
+		conn:           conn,
+		callingAETitle: "TEST_SCU",
+		calledAETitle:  "TEST_SCP",
+		maxPDULength:   16384,
+		presentationCtxs: map[byte]*PresentationContext{
+			1: {ID: 1, AbstractSyntax: types.VerificationSOPClass, Accepted: true},
+		},
+		logger: slog.Default(),
+	}
+
+	// The SCP releases the association instead of answering
+	conn.readBuf.Write([]byte{pdu.TypeReleaseRQ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00})
+
+	_, err := assoc.SendCEcho(1)
+	if !errors.Is(err, ErrPeerReleased) {
+		t.Fatalf("SendCEcho() error = %v, want ErrPeerReleased", err)
+	}
+
+	releaseRP := []byte{pdu.TypeReleaseRP, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.HasSuffix(conn.writeBuf.Bytes(), releaseRP) {
+		t.Errorf("Expected A-RELEASE-RP to be sent, wrote % X", conn.writeBuf.Bytes())
+	}
+
+	// The association is already released, so Close must not request it again
+	written := conn.writeBuf.Len()
+	if err := assoc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if conn.writeBuf.Len() != written {
+		t.Errorf("Close() wrote % X after peer release", conn.writeBuf.Bytes()[written:])
+	}
+	if !conn.closed {
+		t.Error("Expected connection to be closed")
+	}
+}
+
+func TestPresentationContextProposals(t *testing.T) {
+	assoc := &Association{
+		state:                     StateEstablished,
+		sopClasses:                []string{types.CTImageStorage, types.MRImageStorage},
+		preferredTransferSyntaxes: []string{types.ExplicitVRLittleEndian, types.JPEG2000},
+	}
+
+	if got := assoc.presentationContextProposals(); len(got) != 2 || len(got[0].transferSyntaxes) != 2 {
+		t.Errorf("Proposals = %+v, want one context per SOP class offering both syntaxes", got)
+	}
+
+	assoc.contextPerTransferSyntax = true
+	got := assoc.presentationContextProposals()
+	if len(got) != 4 {
+		t.Fatalf("Proposals = %+v, want one context per SOP class and transfer syntax", got)
+	}
+	if got[1].abstractSyntax != types.CTImageStorage || len(got[1].transferSyntaxes) != 1 || got[1].transferSyntaxes[0] != types.JPEG2000 {
+		t.Errorf("Second proposal = %+v, want CT Image Storage with JPEG 2000 only", got[1])
+	}
+}
+
+func TestConnectQR_ProposesQueryRetrieveModels(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	// Capture the A-ASSOCIATE-RQ and reject it
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			close(received)
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[2:6]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			close(received)
+			return
+		}
+		received <- body
+		conn.Write([]byte{pdu.TypeAssociateRJ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x01, 0x01, 0x01})
+	}()
+
+	if _, err := ConnectQR(listener.Addr().String(), Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "TEST_SCP",
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		SOPClasses:     []string{types.CTImageStorage},
+	}); err == nil {
+		t.Fatal("ConnectQR() succeeded against a rejecting SCP")
+	}
+
+	rq, ok := <-received
+	if !ok {
+		t.Fatal("SCP did not receive an A-ASSOCIATE-RQ")
+	}
+	for _, uid := range append(QueryRetrieveSOPClasses(), types.CTImageStorage) {
+		if !bytes.Contains(rq, []byte(uid)) {
+			t.Errorf("A-ASSOCIATE-RQ does not propose %s", uid)
+		}
+	}
+}
+
+func TestRenegotiate(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	listener := &countingListener{Listener: inner}
+	serveEchoSCP(t, listener, "TEST_SCP",
+		pdu.WithSupportedAbstractSyntaxes(types.VerificationSOPClass, types.CTImageStorage),
+		pdu.WithNoContextsPolicy(pdu.NoContextsAcceptWithResults))
+
+	assoc, err := Connect(listener.Addr().String(), Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "TEST_SCP",
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		PresentationContexts: []PresentationContextProposal{
+			{AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.JPEG2000}},
+			{AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ExplicitVRLittleEndian}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if accepted := assoc.AcceptedContexts(); len(accepted) != 1 || accepted[0].AbstractSyntax != types.VerificationSOPClass {
+		t.Fatalf("AcceptedContexts() = %+v, want JPEG 2000 rejected", accepted)
+	}
+
+	renegotiated, err := assoc.Renegotiate([]PresentationContextProposal{
+		{AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ExplicitVRLittleEndian}},
+	})
+	if err != nil {
+		t.Fatalf("Renegotiate() error = %v", err)
+	}
+	defer renegotiated.Close()
+
+	if renegotiated == assoc || listener.accepted.Load() != 2 {
+		t.Errorf("Renegotiate() reused the association (%d accepted), want a new one", listener.accepted.Load())
+	}
+	want := []PresentationContext{
+		{ID: 1, AbstractSyntax: types.CTImageStorage, TransferSyntax: types.ImplicitVRLittleEndian, Accepted: true},
+		{ID: 3, AbstractSyntax: types.VerificationSOPClass, TransferSyntax: types.ExplicitVRLittleEndian, Accepted: true},
+	}
+	if got := renegotiated.AcceptedContexts(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("AcceptedContexts() = %+v, want %+v", got, want)
+	}
+	if _, err := renegotiated.SendCEcho(1); err != nil {
+		t.Errorf("SendCEcho() on renegotiated association error = %v", err)
+	}
+	if _, err := assoc.SendCEcho(2); err == nil {
+		t.Error("SendCEcho() on the old association succeeded, want it closed")
+	}
+}
+
+func TestConnect_NoContextsAccepted(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	serveEchoSCP(t, listener, "TEST_SCP",
+		pdu.WithSupportedAbstractSyntaxes(types.VerificationSOPClass),
+		pdu.WithNoContextsPolicy(pdu.NoContextsAcceptWithResults))
+
+	assoc, err := Connect(listener.Addr().String(), Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "TEST_SCP",
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		SOPClasses:     []string{types.CTImageStorage, types.MRImageStorage},
+	})
+	if !errors.Is(err, ErrNoContextsAccepted) {
+		t.Fatalf("Connect() error = %v, want ErrNoContextsAccepted", err)
+	}
+	if assoc != nil {
+		t.Error("Connect() returned an association without accepted contexts")
+	}
+}
+
+func TestPresentationContextIDs(t *testing.T) {
+	for name, contexts := range map[string][]PresentationContextProposal{
+		"even": {{ID: 2, AbstractSyntax: types.CTImageStorage}},
+		"duplicate": {
+			{ID: 5, AbstractSyntax: types.CTImageStorage},
+			{ID: 5, AbstractSyntax: types.MRImageStorage},
+		},
+	} {
+		// The address is never dialled: invalid IDs are reported first
+		_, err := Connect("127.0.0.1:0", Config{PresentationContexts: contexts})
+		if err == nil || !strings.Contains(err.Error(), "presentation context ID") {
+			t.Errorf("Connect() with %s context IDs error = %v, want a context ID error", name, err)
+		}
+	}
+
+	// Automatic IDs skip the explicit ones
+	assoc := &Association{
+		state:            StateEstablished,
+		conn:             newMockConn(),
+		presentationCtxs: make(map[byte]*PresentationContext),
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		proposals: []PresentationContextProposal{
+			{AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ExplicitVRLittleEndian}},
+			{ID: 1, AbstractSyntax: types.MRImageStorage, TransferSyntaxes: []string{types.ExplicitVRLittleEndian}},
+		},
+	}
+	if err := assoc.sendAssociateRQ(); err != nil {
+		t.Fatalf("sendAssociateRQ() error = %v", err)
+	}
+	if pc := assoc.presentationCtxs[3]; pc == nil || pc.AbstractSyntax != types.CTImageStorage {
+		t.Errorf("Context 3 = %+v, want CT Image Storage", pc)
+	}
+	if pc := assoc.presentationCtxs[1]; pc == nil || pc.AbstractSyntax != types.MRImageStorage {
+		t.Errorf("Context 1 = %+v, want MR Image Storage", pc)
+	}
+}
+
+func TestResolveAddress(t *testing.T) {
+	tests := []struct {
+		address     string
+		defaultPort int
+		want        string // empty for an error
+	}{
+		{"[::1]:104", 0, "[::1]:104"},
+		{"127.0.0.1:11112", 104, "127.0.0.1:11112"},
+		{"pacs.example.org:104", 0, "pacs.example.org:104"},
+		{"host", 104, "host:104"},
+		{"host", 0, ""},
+		{"::1", 104, "[::1]:104"},
+		{"[::1]", 104, "[::1]:104"},
+		{"host:notaport", 104, ""},
+		{"host:70000", 0, ""},
+		{"host:0", 0, ""},
+		{"a:b:c", 104, ""},
+		{"", 104, ""},
+	}
+	for _, tt := range tests {
+		got, err := resolveAddress(tt.address, tt.defaultPort)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("resolveAddress(%q, %d) = %q, want an error", tt.address, tt.defaultPort, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveAddress(%q, %d) = %q, %v; want %q", tt.address, tt.defaultPort, got, err, tt.want)
+		}
+	}
+}
+
+func TestConnect_DefaultPort(t *testing.T) {
+	listener := startEchoSCP(t)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	defaultPort, _ := strconv.Atoi(port)
+
+	assoc, err := Connect("127.0.0.1", Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		SOPClasses:     []string{types.VerificationSOPClass},
+		DefaultPort:    defaultPort,
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+	if resp, err := assoc.SendCEcho(1); err != nil || resp.Status != types.StatusSuccess {
+		t.Errorf("SendCEcho() = %+v, %v; want success", resp, err)
+	}
+
+	if _, err := Connect("127.0.0.1:notaport", Config{}); err == nil || !strings.Contains(err.Error(), "notaport") {
+		t.Errorf("Connect() with a bad port error = %v, want it to name the port", err)
+	}
+}
+
+func TestParsePresentationContextAC(t *testing.T) {
+	tsItem := func(uid string) []byte {
+		return append([]byte{0x40, 0x00, 0x00, byte(len(uid))}, uid...)
+	}
+
+	tests := []struct {
+		name           string
+		item           []byte
+		wantID         byte
+		wantResult     byte
+		wantTransferTS string
+	}{
+		{
+			// pynetdicom: zeroed reserved bytes, unpadded UID
+			name:           "pynetdicom accepted",
+			item:           append([]byte{0x01, 0x00, 0x00, 0x00}, tsItem("1.2.840.10008.1.2.1")...),
+			wantID:         0x01,
+			wantResult:     0x00,
+			wantTransferTS: "1.2.840.10008.1.2.1",
+		},
+		{
+			// DCMTK: rejected contexts still carry a transfer syntax sub-item
+			name:           "DCMTK abstract syntax not supported",
+			item:           append([]byte{0x03, 0x00, 0x03, 0x00}, tsItem("1.2.840.10008.1.2")...),
+			wantID:         0x03,
+			wantResult:     0x03,
+			wantTransferTS: "1.2.840.10008.1.2",
+		},
+		{
+			// dcm4che: null-padded UID
+			name:           "dcm4che accepted",
+			item:           append([]byte{0x05, 0x00, 0x00, 0x00}, tsItem("1.2.840.10008.1.2.4.50\x00")...),
+			wantID:         0x05,
+			wantResult:     0x00,
+			wantTransferTS: "1.2.840.10008.1.2.4.50",
+		},
+		{
+			// Rejections sent without any sub-item
+			name:       "transfer syntaxes not supported, no sub-item",
+			item:       []byte{0x07, 0x00, 0x04, 0x00},
+			wantID:     0x07,
+			wantResult: 0x04,
+		},
+		{
+			// Non-zero reserved bytes must not be mistaken for the result
+			name:           "non-zero reserved bytes",
+			item:           append([]byte{0x09, 0xFF, 0x00, 0xFF}, tsItem("1.2.840.10008.1.2.1")...),
+			wantID:         0x09,
+			wantResult:     0x00,
+			wantTransferTS: "1.2.840.10008.1.2.1",
+		},
+		{
+			name:       "truncated",
+			item:       []byte{0x0B, 0x00},
+			wantID:     0x0B,
+			wantResult: 0xFF,
+		},
+	}
+
+	for _, tt := range tests {
+		id, result, transferSyntax := parsePresentationContextAC(tt.item)
+		if id != tt.wantID || result != tt.wantResult || transferSyntax != tt.wantTransferTS {
+			t.Errorf("%s: parsePresentationContextAC() = %d, 0x%02x, %q; want %d, 0x%02x, %q",
+				tt.name, id, result, transferSyntax, tt.wantID, tt.wantResult, tt.wantTransferTS)
+		}
+	}
+}
@@ -9,12 +9,19 @@ import (
 
 // CEchoResponse represents the result of a C-ECHO operation.
 type CEchoResponse struct {
-	Status    uint16
-	MessageID uint16
+	Status            uint16
+	MessageID         uint16
+	SOPClassUID       string      // Affected SOP Class UID, or Requested SOP Class UID when only that is sent
+	OffendingElements []types.Tag // Attributes the SCP reported as offending, if any
+	ErrorComment      string      // Free-text failure description, if any
 }
 
 // SendCEcho performs a DICOM C-ECHO (verification) request and returns the response status.
 func (a *Association) SendCEcho(messageID uint16) (*CEchoResponse, error) {
+	if err := a.checkEstablished(); err != nil {
+		return nil, err
+	}
+
 	if messageID == 0 {
 		messageID = 1
 	}
@@ -41,7 +48,7 @@ func (a *Association) SendCEcho(messageID uint16) (*CEchoResponse, error) {
 		return nil, fmt.Errorf("failed to send C-ECHO request: %w", err)
 	}
 
-	msg, _, err := dimse.ReceiveDIMSEMessage(a.conn)
+	msg, _, err := a.receiveMessage()
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +58,10 @@ func (a *Association) SendCEcho(messageID uint16) (*CEchoResponse, error) {
 	}
 
 	return &CEchoResponse{
-		Status:    msg.Status,
-		MessageID: msg.MessageIDBeingRespondedTo,
+		Status:            msg.Status,
+		MessageID:         msg.MessageIDBeingRespondedTo,
+		SOPClassUID:       msg.EffectiveSOPClassUID(),
+		OffendingElements: msg.OffendingElements,
+		ErrorComment:      msg.ErrorComment,
 	}, nil
 }
@@ -1,18 +1,23 @@
 package client
 
 import (
+	"bytes"
 	"encoding/binary"
+	"io"
 	"log/slog"
+	"strings"
 	"testing"
 
 	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/pdu"
 	"github.com/caio-sobreiro/dicomnet/types"
 )
 
 func TestSendCEcho(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:          StateEstablished,
 		conn:           conn,
 		callingAETitle: "TEST_SCU",
 		calledAETitle:  "TEST_SCP",
@@ -27,7 +32,7 @@ func TestSendCEcho(t *testing.T) {
 		logger: slog.Default(),
 	}
 
-	command := buildCommandDataset(&types.Message{
+	command := dimse.EncodeCommandSet(&types.Message{
 		CommandField:              dimse.CEchoRSP,
 		MessageIDBeingRespondedTo: 1,
 		CommandDataSetType:        0x0101,
@@ -58,6 +63,7 @@ func TestSendCEcho(t *testing.T) {
 func TestSendCFind(t *testing.T) {
 	conn := newMockConn()
 	assoc := &Association{
+		state:          StateEstablished,
 		conn:           conn,
 		callingAETitle: "TEST_SCU",
 		calledAETitle:  "TEST_SCP",
@@ -80,7 +86,7 @@ func TestSendCFind(t *testing.T) {
 	matchDataset.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0010}, dicom.VR_PN, "DOE^JOHN")
 	matchDatasetBytes := matchDataset.EncodeDataset()
 
-	pendingCommand := buildCommandDataset(&types.Message{
+	pendingCommand := dimse.EncodeCommandSet(&types.Message{
 		CommandField:              dimse.CFindRSP,
 		MessageIDBeingRespondedTo: 2,
 		CommandDataSetType:        0x0000,
@@ -88,7 +94,7 @@ func TestSendCFind(t *testing.T) {
 		AffectedSOPClassUID:       types.StudyRootQueryRetrieveInformationModelFind,
 	})
 
-	finalCommand := buildCommandDataset(&types.Message{
+	finalCommand := dimse.EncodeCommandSet(&types.Message{
 		CommandField:              dimse.CFindRSP,
 		MessageIDBeingRespondedTo: 2,
 		CommandDataSetType:        0x0101,
@@ -133,85 +139,73 @@ func TestSendCFind(t *testing.T) {
 	}
 }
 
-func buildCommandDataset(msg *types.Message) []byte {
-	var body []byte
-
-	if msg.AffectedSOPClassUID != "" {
-		value := []byte(msg.AffectedSOPClassUID)
-		if len(value)%2 == 1 {
-			value = append(value, 0x00)
-		}
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x0002, value)
-	}
-
-	if msg.RequestedSOPClassUID != "" {
-		value := []byte(msg.RequestedSOPClassUID)
-		if len(value)%2 == 1 {
-			value = append(value, 0x00)
-		}
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x0003, value)
-	}
-
-	if msg.CommandField != 0 {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, msg.CommandField)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x0100, buf)
+func TestSendCFind_UsesNegotiatedTransferSyntax(t *testing.T) {
+	conn := newMockConn()
+	assoc := &Association{
+		state:          StateEstablished,
+		conn:           conn,
+		callingAETitle: "TEST_SCU",
+		calledAETitle:  "TEST_SCP",
+		maxPDULength:   16384,
+		presentationCtxs: map[byte]*PresentationContext{
+			11: {
+				ID:             11,
+				AbstractSyntax: types.StudyRootQueryRetrieveInformationModelFind,
+				TransferSyntax: types.ImplicitVRLittleEndian,
+				Accepted:       true,
+			},
+		},
+		logger: slog.Default(),
 	}
 
-	if msg.MessageID != 0 {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, msg.MessageID)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x0110, buf)
-	}
+	requestDataset := dicom.NewDataset()
+	requestDataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	requestDataset.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "PID001")
 
-	if msg.MessageIDBeingRespondedTo != 0 {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, msg.MessageIDBeingRespondedTo)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x0120, buf)
+	// The SCP answers on the same context, so its identifier is Implicit VR too
+	matchDataset := dicom.NewDataset()
+	matchDataset.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "PID001")
+	matchBytes, err := dicom.EncodeDatasetWithTransferSyntax(matchDataset, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("failed to encode match dataset: %v", err)
 	}
 
-	buf := make([]byte, 2)
-	binary.LittleEndian.PutUint16(buf, msg.CommandDataSetType)
-	body = dimse.AppendImplicitElement(body, 0x0000, 0x0800, buf)
+	conn.readBuf.Write(buildPDataPDU(11, true, true, dimse.EncodeCommandSet(&types.Message{
+		CommandField:              dimse.CFindRSP,
+		MessageIDBeingRespondedTo: 1,
+		CommandDataSetType:        0x0000,
+		Status:                    dimse.StatusPending,
+	})))
+	conn.readBuf.Write(buildPDataPDU(11, false, true, matchBytes))
+	conn.readBuf.Write(buildPDataPDU(11, true, true, dimse.EncodeCommandSet(&types.Message{
+		CommandField:              dimse.CFindRSP,
+		MessageIDBeingRespondedTo: 1,
+		CommandDataSetType:        0x0101,
+		Status:                    dimse.StatusSuccess,
+	})))
 
-	if (msg.CommandField&0x8000) != 0 || msg.Status != 0 {
-		statusBuf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(statusBuf, msg.Status)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x0900, statusBuf)
+	responses, err := assoc.SendCFind(&CFindRequest{Dataset: requestDataset})
+	if err != nil {
+		t.Fatalf("SendCFind returned error: %v", err)
 	}
 
-	// C-GET/C-MOVE sub-operation counters
-	if msg.NumberOfRemainingSuboperations != nil {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, *msg.NumberOfRemainingSuboperations)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x1020, buf)
+	if len(responses) != 2 || responses[0].Dataset == nil {
+		t.Fatalf("expected a pending response with dataset, got %d responses", len(responses))
 	}
-
-	if msg.NumberOfCompletedSuboperations != nil {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, *msg.NumberOfCompletedSuboperations)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x1021, buf)
+	if pid := responses[0].Dataset.GetString(dicom.Tag{Group: 0x0010, Element: 0x0020}); pid != "PID001" {
+		t.Fatalf("patient ID = %q, want PID001", pid)
 	}
 
-	if msg.NumberOfFailedSuboperations != nil {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, *msg.NumberOfFailedSuboperations)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x1022, buf)
+	// Read back what the client sent: command PDV followed by the identifier
+	_, sentDataset, err := dimse.ReceiveDIMSEMessage(conn.writeBuf)
+	if err != nil {
+		t.Fatalf("failed to decode sent C-FIND-RQ: %v", err)
 	}
 
-	if msg.NumberOfWarningSuboperations != nil {
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, *msg.NumberOfWarningSuboperations)
-		body = dimse.AppendImplicitElement(body, 0x0000, 0x1023, buf)
+	expected, _ := dicom.EncodeDatasetWithTransferSyntax(requestDataset, types.ImplicitVRLittleEndian)
+	if !bytes.Equal(sentDataset, expected) {
+		t.Fatalf("request identifier was not Implicit VR encoded:\n got  % X\n want % X", sentDataset, expected)
 	}
-
-	groupLength := make([]byte, 4)
-	binary.LittleEndian.PutUint32(groupLength, uint32(len(body)))
-
-	command := dimse.AppendImplicitElement(nil, 0x0000, 0x0000, groupLength)
-	command = append(command, body...)
-
-	return command
 }
 
 func buildPDataPDU(contextID byte, isCommand bool, isLast bool, data []byte) []byte {
@@ -243,3 +237,220 @@ func buildPDataPDU(contextID byte, isCommand bool, isLast bool, data []byte) []b
 }
 
 const dimsePDataTF = byte(0x04)
+
+func TestValidateHierarchicalQuery(t *testing.T) {
+	identifier := func(level string, keys map[dicom.Tag]string) *dicom.Dataset {
+		ds := dicom.NewDataset()
+		if level != "" {
+			ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, level)
+		}
+		for tag, value := range keys {
+			ds.AddElement(tag, dicom.VR_UI, value)
+		}
+		return ds
+	}
+	patientID := dicom.Tag{Group: 0x0010, Element: 0x0020}
+	studyUID := dicom.Tag{Group: 0x0020, Element: 0x000D}
+	seriesUID := dicom.Tag{Group: 0x0020, Element: 0x000E}
+
+	tests := []struct {
+		name       string
+		sopClass   string
+		identifier *dicom.Dataset
+		wantErr    bool
+	}{
+		{"Study root STUDY", types.StudyRootQueryRetrieveInformationModelFind, identifier("STUDY", nil), false},
+		{"Study root IMAGE", types.StudyRootQueryRetrieveInformationModelFind,
+			identifier("IMAGE", map[dicom.Tag]string{studyUID: "1.2.3", seriesUID: "1.2.3.4"}), false},
+		{"Study root IMAGE without series", types.StudyRootQueryRetrieveInformationModelFind,
+			identifier("IMAGE", map[dicom.Tag]string{studyUID: "1.2.3"}), true},
+		{"Study root SERIES with wildcard study", types.StudyRootQueryRetrieveInformationModelFind,
+			identifier("SERIES", map[dicom.Tag]string{studyUID: "1.2.*"}), true},
+		{"Study root PATIENT", types.StudyRootQueryRetrieveInformationModelFind, identifier("PATIENT", nil), true},
+		{"Patient root STUDY without patient", types.PatientRootQueryRetrieveInformationModelFind, identifier("STUDY", nil), true},
+		{"Patient root SERIES", types.PatientRootQueryRetrieveInformationModelFind,
+			identifier("SERIES", map[dicom.Tag]string{patientID: "PID001", studyUID: "1.2.3"}), false},
+		{"Patient/study only SERIES", types.PatientStudyOnlyQueryRetrieveInformationModelFind,
+			identifier("SERIES", map[dicom.Tag]string{patientID: "PID001", studyUID: "1.2.3"}), true},
+		{"Missing level", types.StudyRootQueryRetrieveInformationModelFind, identifier("", nil), true},
+		{"Other SOP class", types.ModalityWorklistInformationModelFind, identifier("", nil), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHierarchicalQuery(tt.sopClass, tt.identifier)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateHierarchicalQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendCFind_HierarchicalValidation(t *testing.T) {
+	imageQuery := func(withSeries bool) *dicom.Dataset {
+		ds := dicom.NewDataset()
+		ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "IMAGE")
+		ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "")
+		ds.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+		if withSeries {
+			ds.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000E}, dicom.VR_UI, "1.2.3.4")
+		}
+		return ds
+	}
+
+	tests := []struct {
+		name       string
+		identifier *dicom.Dataset
+		relational bool
+		wantErr    bool
+	}{
+		{"Valid IMAGE level", imageQuery(true), false, false},
+		{"Missing Series Instance UID", imageQuery(false), false, true},
+		{"Missing Series Instance UID, relational", imageQuery(false), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newMockConn()
+			assoc := &Association{
+				state:        StateEstablished,
+				conn:         conn,
+				maxPDULength: 16384,
+				presentationCtxs: map[byte]*PresentationContext{
+					1: {ID: 1, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelFind, Accepted: true},
+				},
+				relationalQueries: map[string]bool{types.StudyRootQueryRetrieveInformationModelFind: tt.relational},
+				logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			conn.readBuf.Write(buildPDataPDU(1, true, true, dimse.EncodeCommandSet(&types.Message{
+				CommandField:              dimse.CFindRSP,
+				MessageIDBeingRespondedTo: 1,
+				CommandDataSetType:        0x0101,
+				Status:                    dimse.StatusSuccess,
+			})))
+
+			_, err := assoc.SendCFind(&CFindRequest{Dataset: tt.identifier})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendCFind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "Series Instance UID") {
+					t.Errorf("Error %q does not name the missing key", err)
+				}
+				if conn.writeBuf.Len() != 0 {
+					t.Error("Invalid query was sent to the network")
+				}
+			}
+		})
+	}
+}
+
+func TestRelationalQueriesNegotiation(t *testing.T) {
+	assoc := &Association{
+		state:             StateEstablished,
+		presentationCtxs:  make(map[byte]*PresentationContext),
+		sopClasses:        []string{types.VerificationSOPClass, types.StudyRootQueryRetrieveInformationModelFind},
+		proposeRelational: true,
+		logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// The request proposes relational queries for the Q/R class only
+	userInfo := assoc.addUserInformation(nil)
+	proposal := []byte{0x56, 0x00, 0x00, byte(3 + len(types.StudyRootQueryRetrieveInformationModelFind)), 0x00, byte(len(types.StudyRootQueryRetrieveInformationModelFind))}
+	proposal = append(proposal, types.StudyRootQueryRetrieveInformationModelFind...)
+	proposal = append(proposal, 0x01)
+	if !bytes.Contains(userInfo, proposal) {
+		t.Errorf("User information % x does not propose relational queries", userInfo)
+	}
+	if bytes.Contains(userInfo, []byte(types.VerificationSOPClass)) {
+		t.Error("Relational queries proposed for a non Query/Retrieve SOP class")
+	}
+
+	// The SCP agrees in its A-ASSOCIATE-AC
+	reply := []byte{0x56, 0x00, 0x00, byte(3 + len(types.StudyRootQueryRetrieveInformationModelFind)), 0x00, byte(len(types.StudyRootQueryRetrieveInformationModelFind))}
+	reply = append(reply, types.StudyRootQueryRetrieveInformationModelFind...)
+	reply = append(reply, 0x01)
+	data := make([]byte, 68)
+	data = append(data, 0x50, 0x00)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(reply)))
+	data = append(data, reply...)
+
+	conn := newMockConn()
+	conn.readBuf.Write([]byte{pdu.TypeAssociateAC, 0x00})
+	conn.readBuf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	conn.readBuf.Write(data)
+	assoc.conn = conn
+
+	if err := assoc.receiveAssociateAC(); err != nil {
+		t.Fatalf("receiveAssociateAC() error = %v", err)
+	}
+	if !assoc.RelationalQueriesNegotiated(types.StudyRootQueryRetrieveInformationModelFind) {
+		t.Error("RelationalQueriesNegotiated() = false after SCP agreed")
+	}
+	if assoc.RelationalQueriesNegotiated(types.PatientRootQueryRetrieveInformationModelFind) {
+		t.Error("RelationalQueriesNegotiated() = true for a SOP class that was not negotiated")
+	}
+}
+
+func TestCFindResponse_StudyRelatedCounts(t *testing.T) {
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x1206}, dicom.VR_IS, "3")
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x1208}, dicom.VR_IS, " 245")
+
+	// Parse the match as it would arrive, with IS space padding
+	parsed, err := dicom.ParseDataset(match.EncodeDataset())
+	if err != nil {
+		t.Fatalf("ParseDataset() error = %v", err)
+	}
+	resp := &CFindResponse{Status: dimse.StatusPending, Dataset: parsed}
+
+	if series, ok := resp.StudyRelatedSeries(); !ok || series != 3 {
+		t.Errorf("StudyRelatedSeries() = %d, %v; want 3, true", series, ok)
+	}
+	if instances, ok := resp.StudyRelatedInstances(); !ok || instances != 245 {
+		t.Errorf("StudyRelatedInstances() = %d, %v; want 245, true", instances, ok)
+	}
+
+	// Counts the SCP did not return, or a final response without a match
+	empty := &CFindResponse{Status: dimse.StatusPending, Dataset: dicom.NewDataset()}
+	if n, ok := empty.StudyRelatedSeries(); ok {
+		t.Errorf("StudyRelatedSeries() = %d, true; want 0, false when absent", n)
+	}
+	final := &CFindResponse{Status: dimse.StatusSuccess}
+	if n, ok := final.StudyRelatedInstances(); ok {
+		t.Errorf("StudyRelatedInstances() = %d, true; want 0, false without a dataset", n)
+	}
+}
+
+func TestSendCEcho_RequestedSOPClassOnly(t *testing.T) {
+	conn := newMockConn()
+	assoc := &Association{
+		state:          StateEstablished,
+		conn:           conn,
+		callingAETitle: "TEST_SCU",
+		calledAETitle:  "TEST_SCP",
+		maxPDULength:   16384,
+		presentationCtxs: map[byte]*PresentationContext{
+			1: {ID: 1, AbstractSyntax: types.VerificationSOPClass, Accepted: true},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// A response naming its SOP class in (0000,0003) instead of (0000,0002)
+	var command []byte
+	command = dimse.AppendImplicitElement(command, 0x0000, 0x0003, []byte(types.VerificationSOPClass+"\x00"))
+	command = dimse.AppendImplicitElement(command, 0x0000, 0x0100, []byte{0x30, 0x80})
+	command = dimse.AppendImplicitElement(command, 0x0000, 0x0120, []byte{0x01, 0x00})
+	command = dimse.AppendImplicitElement(command, 0x0000, 0x0800, []byte{0x01, 0x01})
+	command = dimse.AppendImplicitElement(command, 0x0000, 0x0900, []byte{0x00, 0x00})
+	conn.readBuf.Write(buildPDataPDU(1, true, true, command))
+
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if resp.SOPClassUID != types.VerificationSOPClass {
+		t.Errorf("SOPClassUID = %q, want %q from the Requested SOP Class UID", resp.SOPClassUID, types.VerificationSOPClass)
+	}
+}
@@ -2,6 +2,12 @@ package client
 
 import (
 	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
@@ -18,13 +24,105 @@ type CFindRequest struct {
 
 // CFindResponse represents a single C-FIND response from the SCP.
 type CFindResponse struct {
-	Status    uint16
-	MessageID uint16
-	Dataset   *dicom.Dataset
+	Status            uint16
+	MessageID         uint16
+	SOPClassUID       string // Affected SOP Class UID, or Requested SOP Class UID when only that is sent
+	Dataset           *dicom.Dataset
+	OffendingElements []types.Tag // Attributes the SCP reported as offending, if any
+	ErrorComment      string      // Free-text failure description, if any
+}
+
+var (
+	numberOfStudyRelatedSeriesTag    = dicom.Tag{Group: 0x0020, Element: 0x1206}
+	numberOfStudyRelatedInstancesTag = dicom.Tag{Group: 0x0020, Element: 0x1208}
+	retrieveAETitleTag               = dicom.Tag{Group: 0x0008, Element: 0x0054}
+)
+
+// RetrieveAETitles returns the Retrieve AE Title (0008,0054) values of a
+// match: the AEs the SCP says it can be retrieved from, or nil if absent.
+func (r *CFindResponse) RetrieveAETitles() []string {
+	if r.Dataset == nil {
+		return nil
+	}
+	var titles []string
+	for _, title := range r.Dataset.GetStrings(retrieveAETitleTag) {
+		if title != "" {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// StudyRelatedSeries returns the Number of Study Related Series (0020,1206)
+// of a study-level match, or false when the SCP did not return it.
+func (r *CFindResponse) StudyRelatedSeries() (int, bool) {
+	return r.integerString(numberOfStudyRelatedSeriesTag)
+}
+
+// StudyRelatedInstances returns the Number of Study Related Instances
+// (0020,1208) of a study-level match, or false when the SCP did not return it.
+func (r *CFindResponse) StudyRelatedInstances() (int, bool) {
+	return r.integerString(numberOfStudyRelatedInstancesTag)
+}
+
+// integerString parses the first value of an IS element in the match
+func (r *CFindResponse) integerString(tag dicom.Tag) (int, bool) {
+	if r.Dataset == nil {
+		return 0, false
+	}
+	values := r.Dataset.GetStrings(tag)
+	if len(values) == 0 || values[0] == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(values[0])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
 }
 
 // SendCFind performs a DICOM C-FIND query and returns all responses in order.
 func (a *Association) SendCFind(req *CFindRequest) ([]*CFindResponse, error) {
+	stream, err := a.StartCFind(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []*CFindResponse
+	for {
+		resp, err := stream.Next()
+		if err == io.EOF {
+			return responses, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, resp)
+	}
+}
+
+// CFindStream delivers the responses to a C-FIND query as they arrive, so
+// the caller can stop a long query with Cancel.
+type CFindStream struct {
+	assoc     *Association
+	messageID uint16
+	sopClass  string
+	presCtx   *PresentationContext
+
+	cancelOnce sync.Once
+	cancelErr  error
+	canceled   atomic.Bool
+	done       bool
+}
+
+// StartCFind sends a C-FIND query and returns a stream of its responses.
+// Read them with Next until it returns io.EOF; the association can only be
+// used for other operations after that.
+func (a *Association) StartCFind(req *CFindRequest) (*CFindStream, error) {
+	if err := a.checkEstablished(); err != nil {
+		return nil, err
+	}
+
 	if req == nil {
 		return nil, fmt.Errorf("c-find request cannot be nil")
 	}
@@ -38,6 +136,13 @@ func (a *Association) SendCFind(req *CFindRequest) ([]*CFindResponse, error) {
 		sopClass = types.StudyRootQueryRetrieveInformationModelFind
 	}
 
+	// Relational queries lift the unique key requirements of hierarchical ones
+	if !a.RelationalQueriesNegotiated(sopClass) {
+		if err := validateHierarchicalQuery(sopClass, req.Dataset); err != nil {
+			return nil, err
+		}
+	}
+
 	messageID := req.MessageID
 	if messageID == 0 {
 		messageID = 1
@@ -48,15 +153,21 @@ func (a *Association) SendCFind(req *CFindRequest) ([]*CFindResponse, error) {
 		priority = 0x0000 // Medium priority per DICOM PS3.7
 	}
 
-	presContextID, err := a.GetPresentationContextID(sopClass)
+	presCtx, err := a.GetPresentationContext(sopClass)
 	if err != nil {
 		return nil, err
 	}
 
+	// The identifier must use the transfer syntax accepted for this context
+	datasetData, err := dicom.EncodeDatasetWithTransferSyntax(req.Dataset, presCtx.TransferSyntax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode C-FIND identifier: %w", err)
+	}
+
 	command := &types.Message{
 		CommandField:        dimse.CFindRQ,
 		MessageID:           messageID,
-		CommandDataSetType:  0x0000, // Dataset present
+		CommandDataSetType:  dimse.CommandDataSetTypeFor(datasetData),
 		Priority:            priority,
 		AffectedSOPClassUID: sopClass,
 	}
@@ -66,27 +177,44 @@ func (a *Association) SendCFind(req *CFindRequest) ([]*CFindResponse, error) {
 		return nil, fmt.Errorf("failed to encode C-FIND command: %w", err)
 	}
 
-	datasetData := req.Dataset.EncodeDataset()
-
-	if err := dimse.SendDIMSEMessage(a.conn, presContextID, a.maxPDULength, commandData, datasetData); err != nil {
+	if err := dimse.SendDIMSEMessage(a.conn, presCtx.ID, a.maxPDULength, commandData, datasetData); err != nil {
 		return nil, fmt.Errorf("failed to send C-FIND request: %w", err)
 	}
 
-	var responses []*CFindResponse
+	return &CFindStream{assoc: a, messageID: messageID, sopClass: sopClass, presCtx: presCtx}, nil
+}
+
+// Next returns the next response, or io.EOF once the final (non-pending)
+// response has been returned. After Cancel, pending matches still in flight
+// are discarded and Next returns the final response, normally with status
+// Cancel (0xFE00).
+func (s *CFindStream) Next() (*CFindResponse, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	a := s.assoc
 
 	for {
-		msg, data, err := dimse.ReceiveDIMSEMessage(a.conn)
+		msg, data, err := a.receiveMessage()
 		if err != nil {
+			s.done = true
 			return nil, err
 		}
 
 		if msg.CommandField != dimse.CFindRSP {
+			s.done = true
 			return nil, fmt.Errorf("unexpected command: 0x%04x (expected C-FIND-RSP)", msg.CommandField)
 		}
 
+		pending := msg.Status == dimse.StatusPending || msg.Status == dimse.StatusPendingOptionalKeysNotSupported
+		if pending && s.canceled.Load() {
+			continue
+		}
+		s.done = !pending
+
 		var dataset *dicom.Dataset
 		if len(data) > 0 {
-			dataset, err = dicom.ParseDataset(data)
+			dataset, err = dicom.ParseDatasetWithTransferSyntax(data, s.presCtx.TransferSyntax)
 			if err != nil {
 				a.logger.Warn("Failed to parse C-FIND response dataset",
 					"error", err,
@@ -95,16 +223,72 @@ func (a *Association) SendCFind(req *CFindRequest) ([]*CFindResponse, error) {
 			}
 		}
 
-		responses = append(responses, &CFindResponse{
-			Status:    msg.Status,
-			MessageID: msg.MessageIDBeingRespondedTo,
-			Dataset:   dataset,
-		})
+		return &CFindResponse{
+			Status:            msg.Status,
+			MessageID:         msg.MessageIDBeingRespondedTo,
+			SOPClassUID:       msg.EffectiveSOPClassUID(),
+			Dataset:           dataset,
+			OffendingElements: msg.OffendingElements,
+			ErrorComment:      msg.ErrorComment,
+		}, nil
+	}
+}
 
-		if msg.Status != dimse.StatusPending {
-			break
-		}
+// Cancel sends a C-CANCEL-FIND-RQ for the query, asking the SCP to stop
+// sending matches. It may be called from another goroutine than Next and more
+// than once; only the first call sends the request. Keep calling Next until
+// io.EOF to read the final response.
+func (s *CFindStream) Cancel() error {
+	s.cancelOnce.Do(func() {
+		s.canceled.Store(true)
+		s.cancelErr = s.assoc.SendCCancel(s.messageID, s.sopClass)
+	})
+	return s.cancelErr
+}
+
+// uniqueKey names a unique key required above the query level
+type uniqueKey struct {
+	tag  dicom.Tag
+	name string
+}
+
+var (
+	patientIDKey         = uniqueKey{dicom.Tag{Group: 0x0010, Element: 0x0020}, "Patient ID"}
+	studyInstanceUIDKey  = uniqueKey{dicom.Tag{Group: 0x0020, Element: 0x000D}, "Study Instance UID"}
+	seriesInstanceUIDKey = uniqueKey{dicom.Tag{Group: 0x0020, Element: 0x000E}, "Series Instance UID"}
+)
+
+// levelKeys holds the unique key of each level that a hierarchical query
+// below it must specify (PS3.4 C.4.1.2.2.1)
+var levelKeys = map[types.QueryLevel]uniqueKey{
+	types.QueryLevelPatient: patientIDKey,
+	types.QueryLevelStudy:   studyInstanceUIDKey,
+	types.QueryLevelSeries:  seriesInstanceUIDKey,
+}
+
+// validateHierarchicalQuery checks that an identifier states a Query/Retrieve
+// Level valid for the information model and a single value for every unique
+// key above it. Identifiers for other SOP classes are not checked.
+func validateHierarchicalQuery(sopClass string, identifier *dicom.Dataset) error {
+	levels := types.QueryLevelsForModel(sopClass)
+	if levels == nil {
+		return nil
+	}
+
+	level := types.QueryLevel(identifier.GetString(dicom.Tag{Group: 0x0008, Element: 0x0052}))
+	if level == "" {
+		return fmt.Errorf("c-find identifier is missing Query/Retrieve Level (0008,0052)")
+	}
+	if !types.IsValidQueryLevel(sopClass, level) {
+		return fmt.Errorf("query/retrieve level %s is not valid for SOP class %s", level, sopClass)
 	}
 
-	return responses, nil
+	for _, above := range levels[:slices.Index(levels, level)] {
+		key := levelKeys[above]
+		value := identifier.GetString(key.tag)
+		if value == "" || strings.ContainsAny(value, "*?\\") {
+			return fmt.Errorf("%s-level hierarchical query requires a single %s %s, got %q", level, key.name, key.tag, value)
+		}
+	}
+	return nil
 }
@@ -16,14 +16,31 @@ type CGetRequest struct {
 	Dataset     *dicom.Dataset // Query identifying which instances to retrieve
 }
 
+// NewCGetRequest builds a Study Root C-GET request retrieving the study,
+// series or instance identified by the given UIDs at level (STUDY, SERIES or
+// IMAGE). See dicom.NewRetrieveIdentifier for the keys each level requires.
+func NewCGetRequest(level, studyUID, seriesUID, sopUID string) (*CGetRequest, error) {
+	identifier, err := dicom.NewRetrieveIdentifier(level, studyUID, seriesUID, sopUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build C-GET identifier: %w", err)
+	}
+	return &CGetRequest{
+		SOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+		Dataset:     identifier,
+	}, nil
+}
+
 // CGetResponse represents a single C-GET response from the SCP.
 type CGetResponse struct {
 	Status                         uint16
 	MessageID                      uint16
+	SOPClassUID                    string // Affected SOP Class UID, or Requested SOP Class UID when only that is sent
 	NumberOfRemainingSuboperations *uint16
 	NumberOfCompletedSuboperations *uint16
 	NumberOfFailedSuboperations    *uint16
 	NumberOfWarningSuboperations   *uint16
+	OffendingElements              []types.Tag // Attributes the SCP reported as offending, if any
+	ErrorComment                   string      // Free-text failure description, if any
 }
 
 // SendCGet performs a DICOM C-GET operation to retrieve instances.
@@ -32,6 +49,10 @@ type CGetResponse struct {
 // Returns responses indicating the progress and final status of the retrieval.
 // Note: The caller must handle incoming C-STORE requests on this association.
 func (a *Association) SendCGet(req *CGetRequest) ([]*CGetResponse, error) {
+	if err := a.checkEstablished(); err != nil {
+		return nil, err
+	}
+
 	if req == nil {
 		return nil, fmt.Errorf("c-get request cannot be nil")
 	}
@@ -55,13 +76,16 @@ func (a *Association) SendCGet(req *CGetRequest) ([]*CGetResponse, error) {
 		priority = 0x0000 // Medium priority per DICOM PS3.7
 	}
 
-	presContextID, err := a.GetPresentationContextID(sopClass)
+	presCtx, err := a.GetPresentationContext(sopClass)
 	if err != nil {
 		return nil, err
 	}
 
-	// Encode the query dataset
-	datasetBytes := req.Dataset.EncodeDataset()
+	// Encode the query dataset with the transfer syntax accepted for this context
+	datasetBytes, err := dicom.EncodeDatasetWithTransferSyntax(req.Dataset, presCtx.TransferSyntax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode C-GET identifier: %w", err)
+	}
 
 	// Build C-GET-RQ command
 	command := &types.Message{
@@ -69,7 +93,7 @@ func (a *Association) SendCGet(req *CGetRequest) ([]*CGetResponse, error) {
 		MessageID:           messageID,
 		Priority:            priority,
 		AffectedSOPClassUID: sopClass,
-		CommandDataSetType:  0x0000, // Dataset present
+		CommandDataSetType:  dimse.CommandDataSetTypeFor(datasetBytes),
 	}
 
 	commandData, err := dimse.EncodeCommand(command)
@@ -78,7 +102,7 @@ func (a *Association) SendCGet(req *CGetRequest) ([]*CGetResponse, error) {
 	}
 
 	// Send C-GET-RQ with dataset
-	if err := dimse.SendDIMSEMessage(a.conn, presContextID, a.maxPDULength, commandData, datasetBytes); err != nil {
+	if err := dimse.SendDIMSEMessage(a.conn, presCtx.ID, a.maxPDULength, commandData, datasetBytes); err != nil {
 		return nil, fmt.Errorf("failed to send C-GET request: %w", err)
 	}
 
@@ -86,7 +110,7 @@ func (a *Association) SendCGet(req *CGetRequest) ([]*CGetResponse, error) {
 	var responses []*CGetResponse
 
 	for {
-		responseCmd, _, err := dimse.ReceiveDIMSEMessage(a.conn)
+		responseCmd, _, err := a.receiveMessage()
 		if err != nil {
 			return responses, fmt.Errorf("failed to receive C-GET response: %w", err)
 		}
@@ -98,10 +122,13 @@ func (a *Association) SendCGet(req *CGetRequest) ([]*CGetResponse, error) {
 		response := &CGetResponse{
 			Status:                         responseCmd.Status,
 			MessageID:                      responseCmd.MessageIDBeingRespondedTo,
+			SOPClassUID:                    responseCmd.EffectiveSOPClassUID(),
 			NumberOfRemainingSuboperations: responseCmd.NumberOfRemainingSuboperations,
 			NumberOfCompletedSuboperations: responseCmd.NumberOfCompletedSuboperations,
 			NumberOfFailedSuboperations:    responseCmd.NumberOfFailedSuboperations,
 			NumberOfWarningSuboperations:   responseCmd.NumberOfWarningSuboperations,
+			OffendingElements:              responseCmd.OffendingElements,
+			ErrorComment:                   responseCmd.ErrorComment,
 		}
 
 		responses = append(responses, response)
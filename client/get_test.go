@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"io"
 	"log/slog"
 	"testing"
 
@@ -17,6 +18,7 @@ func TestSendCGet(t *testing.T) {
 	}
 
 	assoc := &Association{
+		state:          StateEstablished,
 		conn:           conn,
 		callingAETitle: "TEST_SCU",
 		calledAETitle:  "TEST_SCP",
@@ -41,7 +43,7 @@ func TestSendCGet(t *testing.T) {
 	failed := uint16(0)
 	warning := uint16(0)
 
-	pendingCommand := buildCommandDataset(&types.Message{
+	pendingCommand := dimse.EncodeCommandSet(&types.Message{
 		CommandField:                   dimse.CGetRSP,
 		MessageIDBeingRespondedTo:      1,
 		CommandDataSetType:             0x0101,
@@ -56,7 +58,7 @@ func TestSendCGet(t *testing.T) {
 	// Final response
 	remaining = 0
 	completed = 5
-	finalCommand := buildCommandDataset(&types.Message{
+	finalCommand := dimse.EncodeCommandSet(&types.Message{
 		CommandField:                   dimse.CGetRSP,
 		MessageIDBeingRespondedTo:      1,
 		CommandDataSetType:             0x0101,
@@ -114,6 +116,7 @@ func TestSendCGet_NilRequest(t *testing.T) {
 	}
 
 	assoc := &Association{
+		state:  StateEstablished,
 		conn:   conn,
 		logger: slog.Default(),
 	}
@@ -131,6 +134,7 @@ func TestSendCGet_NilDataset(t *testing.T) {
 	}
 
 	assoc := &Association{
+		state:  StateEstablished,
 		conn:   conn,
 		logger: slog.Default(),
 	}
@@ -145,3 +149,50 @@ func TestSendCGet_NilDataset(t *testing.T) {
 		t.Fatal("Expected error for nil dataset, got nil")
 	}
 }
+
+func TestNewCGetRequest(t *testing.T) {
+	req, err := NewCGetRequest("SERIES", "1.2", "1.2.3", "")
+	if err != nil {
+		t.Fatalf("NewCGetRequest() error = %v", err)
+	}
+	if req.SOPClassUID != types.StudyRootQueryRetrieveInformationModelGet {
+		t.Errorf("SOPClassUID = %s, want Study Root GET", req.SOPClassUID)
+	}
+	if got := req.Dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000E}); got != "1.2.3" {
+		t.Errorf("Series Instance UID = %q, want 1.2.3", got)
+	}
+
+	if _, err := NewCGetRequest("SERIES", "1.2", "", ""); err == nil {
+		t.Error("Expected error for a SERIES-level request without a Series Instance UID")
+	}
+}
+
+func TestRoleSelectionProposal(t *testing.T) {
+	roleSelection := func(sopClass string) []byte {
+		item := []byte{0x54, 0x00, 0x00, byte(4 + len(sopClass)), 0x00, byte(len(sopClass))}
+		item = append(item, sopClass...)
+		return append(item, 0x01, 0x01)
+	}
+
+	// With a C-GET model, both roles are taken for the storage classes only
+	assoc := &Association{
+		presentationCtxs: make(map[byte]*PresentationContext),
+		sopClasses:       []string{types.StudyRootQueryRetrieveInformationModelGet, types.CTImageStorage, types.MRImageStorage},
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	userInfo := assoc.addUserInformation(nil)
+	for _, sopClass := range []string{types.CTImageStorage, types.MRImageStorage} {
+		if !bytes.Contains(userInfo, roleSelection(sopClass)) {
+			t.Errorf("User information % x does not take the SCP role for %s", userInfo, sopClass)
+		}
+	}
+	if bytes.Contains(userInfo, []byte(types.StudyRootQueryRetrieveInformationModelGet)) {
+		t.Error("Role selection proposed for the C-GET model")
+	}
+
+	// Without one, no roles are proposed
+	assoc.sopClasses = []string{types.CTImageStorage}
+	if userInfo := assoc.addUserInformation(nil); bytes.Contains(userInfo, roleSelection(types.CTImageStorage)) {
+		t.Errorf("User information % x proposes role selection without a retrieve model", userInfo)
+	}
+}
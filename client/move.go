@@ -0,0 +1,246 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// CMoveRequest encapsulates the information required to perform a C-MOVE operation.
+type CMoveRequest struct {
+	SOPClassUID     string
+	MessageID       uint16
+	Priority        uint16
+	MoveDestination string         // AE title the SCP sends the instances to
+	Dataset         *dicom.Dataset // Identifier of the instances to move
+}
+
+// NewCMoveRequest builds a Study Root C-MOVE request sending the study,
+// series or instance identified by the given UIDs at level (STUDY, SERIES or
+// IMAGE) to destinationAE. See dicom.NewRetrieveIdentifier for the keys each
+// level requires.
+func NewCMoveRequest(destinationAE, level, studyUID, seriesUID, sopUID string) (*CMoveRequest, error) {
+	identifier, err := dicom.NewRetrieveIdentifier(level, studyUID, seriesUID, sopUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build C-MOVE identifier: %w", err)
+	}
+	return &CMoveRequest{
+		SOPClassUID:     types.StudyRootQueryRetrieveInformationModelMove,
+		MoveDestination: destinationAE,
+		Dataset:         identifier,
+	}, nil
+}
+
+// CMoveResponse represents a single C-MOVE response from the SCP.
+type CMoveResponse struct {
+	Status                         uint16
+	MessageID                      uint16
+	SOPClassUID                    string // Affected SOP Class UID, or Requested SOP Class UID when only that is sent
+	NumberOfRemainingSuboperations *uint16
+	NumberOfCompletedSuboperations *uint16
+	NumberOfFailedSuboperations    *uint16
+	NumberOfWarningSuboperations   *uint16
+	Dataset                        *dicom.Dataset // Failed SOP Instance UID List, if any
+	OffendingElements              []types.Tag    // Attributes the SCP reported as offending, if any
+	ErrorComment                   string         // Free-text failure description, if any
+}
+
+// SendCMove performs a DICOM C-MOVE operation, asking the SCP to send the
+// matching instances to req.MoveDestination on a separate association.
+//
+// Returns responses indicating the progress and final status of the move.
+func (a *Association) SendCMove(req *CMoveRequest) ([]*CMoveResponse, error) {
+	if err := a.checkEstablished(); err != nil {
+		return nil, err
+	}
+
+	if req == nil {
+		return nil, fmt.Errorf("c-move request cannot be nil")
+	}
+
+	if req.Dataset == nil {
+		return nil, fmt.Errorf("c-move request requires a dataset")
+	}
+
+	if req.MoveDestination == "" {
+		return nil, fmt.Errorf("c-move request requires a move destination")
+	}
+
+	sopClass := req.SOPClassUID
+	if sopClass == "" {
+		sopClass = types.StudyRootQueryRetrieveInformationModelMove
+	}
+
+	messageID := req.MessageID
+	if messageID == 0 {
+		messageID = 1
+	}
+
+	presCtx, err := a.GetPresentationContext(sopClass)
+	if err != nil {
+		return nil, err
+	}
+
+	datasetBytes, err := dicom.EncodeDatasetWithTransferSyntax(req.Dataset, presCtx.TransferSyntax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode C-MOVE identifier: %w", err)
+	}
+
+	command := &types.Message{
+		CommandField:        dimse.CMoveRQ,
+		MessageID:           messageID,
+		Priority:            req.Priority,
+		AffectedSOPClassUID: sopClass,
+		MoveDestination:     req.MoveDestination,
+		CommandDataSetType:  dimse.CommandDataSetTypeFor(datasetBytes),
+	}
+
+	commandData, err := dimse.EncodeCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode C-MOVE command: %w", err)
+	}
+
+	if err := dimse.SendDIMSEMessage(a.conn, presCtx.ID, a.maxPDULength, commandData, datasetBytes); err != nil {
+		return nil, fmt.Errorf("failed to send C-MOVE request: %w", err)
+	}
+
+	var responses []*CMoveResponse
+
+	for {
+		responseCmd, data, err := a.receiveMessage()
+		if err != nil {
+			return responses, fmt.Errorf("failed to receive C-MOVE response: %w", err)
+		}
+
+		if responseCmd.CommandField != dimse.CMoveRSP {
+			return responses, fmt.Errorf("unexpected response command: 0x%04X (expected C-MOVE-RSP)", responseCmd.CommandField)
+		}
+
+		response := &CMoveResponse{
+			Status:                         responseCmd.Status,
+			MessageID:                      responseCmd.MessageIDBeingRespondedTo,
+			SOPClassUID:                    responseCmd.EffectiveSOPClassUID(),
+			NumberOfRemainingSuboperations: responseCmd.NumberOfRemainingSuboperations,
+			NumberOfCompletedSuboperations: responseCmd.NumberOfCompletedSuboperations,
+			NumberOfFailedSuboperations:    responseCmd.NumberOfFailedSuboperations,
+			NumberOfWarningSuboperations:   responseCmd.NumberOfWarningSuboperations,
+			OffendingElements:              responseCmd.OffendingElements,
+			ErrorComment:                   responseCmd.ErrorComment,
+		}
+		if len(data) > 0 {
+			response.Dataset, err = dicom.ParseDatasetWithTransferSyntax(data, presCtx.TransferSyntax)
+			if err != nil {
+				a.logger.Warn("Failed to parse C-MOVE response dataset",
+					"error", err,
+					"message_id", responseCmd.MessageIDBeingRespondedTo,
+					"status", fmt.Sprintf("0x%04X", responseCmd.Status))
+			}
+		}
+
+		responses = append(responses, response)
+
+		if responseCmd.Status != dimse.StatusPending {
+			break
+		}
+	}
+
+	return responses, nil
+}
+
+// MoveResult summarizes a FindAndMove: the studies found and the
+// sub-operation counts of their moves, summed over all studies.
+type MoveResult struct {
+	StudyInstanceUIDs []string          // Studies matched by the query, in response order
+	Statuses          map[string]uint16 // Final C-MOVE status per study
+	Completed         int
+	Failed            int
+	Warning           int
+}
+
+// FindAndMove runs a Study Root C-FIND for query and then a C-MOVE of each
+// matching study to destinationAE, one after the other. The query is sent at
+// the STUDY level with Study Instance UID as a return key, without modifying
+// the caller's dataset. The association must have accepted both the Study
+// Root FIND and MOVE models, e.g. through ConnectQR.
+//
+// A study whose move fails is recorded in the result's Statuses and does not
+// stop the others; only query failures and association errors are returned.
+func (a *Association) FindAndMove(query *dicom.Dataset, destinationAE string) (*MoveResult, error) {
+	if query == nil {
+		return nil, fmt.Errorf("find and move requires a query dataset")
+	}
+	if destinationAE == "" {
+		return nil, fmt.Errorf("find and move requires a move destination")
+	}
+	for _, sopClass := range []string{types.StudyRootQueryRetrieveInformationModelFind, types.StudyRootQueryRetrieveInformationModelMove} {
+		if _, err := a.GetPresentationContext(sopClass); err != nil {
+			return nil, err
+		}
+	}
+
+	studyQuery := &dicom.Dataset{Elements: make(map[dicom.Tag]*dicom.Element, len(query.Elements)+2)}
+	for tag, element := range query.Elements {
+		studyQuery.Elements[tag] = element
+	}
+	studyQuery.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	if _, ok := studyQuery.GetElement(studyInstanceUIDKey.tag); !ok {
+		studyQuery.AddUniversalKey(studyInstanceUIDKey.tag)
+	}
+
+	responses, err := a.SendCFind(&CFindRequest{
+		SOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+		MessageID:   1,
+		Dataset:     studyQuery,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MoveResult{Statuses: make(map[string]uint16)}
+	for _, resp := range responses {
+		switch {
+		case resp.Status == dimse.StatusPending || resp.Status == dimse.StatusPendingOptionalKeysNotSupported:
+			uid := ""
+			if resp.Dataset != nil {
+				uid = resp.Dataset.GetString(studyInstanceUIDKey.tag)
+			}
+			if _, seen := result.Statuses[uid]; uid != "" && !seen {
+				result.StudyInstanceUIDs = append(result.StudyInstanceUIDs, uid)
+				result.Statuses[uid] = dimse.StatusPending
+			}
+		case resp.Status != dimse.StatusSuccess:
+			return nil, fmt.Errorf("c-find failed with status 0x%04x (%s)",
+				resp.Status, dimse.StatusText(dimse.CFindRSP, resp.Status))
+		}
+	}
+
+	for i, uid := range result.StudyInstanceUIDs {
+		req, err := NewCMoveRequest(destinationAE, "STUDY", uid, "", "")
+		if err != nil {
+			return nil, err
+		}
+		req.MessageID = uint16(i + 2)
+
+		moveResponses, err := a.SendCMove(req)
+		if err != nil {
+			return result, fmt.Errorf("c-move of study %s: %w", uid, err)
+		}
+		final := moveResponses[len(moveResponses)-1]
+		result.Statuses[uid] = final.Status
+		result.Completed += countOf(final.NumberOfCompletedSuboperations)
+		result.Failed += countOf(final.NumberOfFailedSuboperations)
+		result.Warning += countOf(final.NumberOfWarningSuboperations)
+	}
+
+	return result, nil
+}
+
+// countOf returns a sub-operation count, zero when absent
+func countOf(n *uint16) int {
+	if n == nil {
+		return 0
+	}
+	return int(*n)
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// queueResponse encodes a response the mock SCP will send
+func queueResponse(t *testing.T, conn *mockConn, contextID byte, msg *types.Message, dataset *dicom.Dataset) {
+	t.Helper()
+	var data []byte
+	msg.CommandDataSetType = 0x0101
+	if dataset != nil {
+		msg.CommandDataSetType = 0x0000
+		data, _ = dicom.EncodeDatasetWithTransferSyntax(dataset, types.ImplicitVRLittleEndian)
+	}
+	if err := dimse.SendDIMSEMessage(conn.readBuf, contextID, 16384, dimse.EncodeCommandSet(msg), data); err != nil {
+		t.Fatalf("SendDIMSEMessage() error = %v", err)
+	}
+}
+
+func TestFindAndMove(t *testing.T) {
+	studyUID := dicom.Tag{Group: 0x0020, Element: 0x000D}
+	patientID := dicom.Tag{Group: 0x0010, Element: 0x0020}
+	count := func(n uint16) *uint16 { return &n }
+
+	conn := newMockConn()
+	assoc := &Association{
+		state:          StateEstablished,
+		conn:           conn,
+		callingAETitle: "TEST_SCU",
+		calledAETitle:  "TEST_SCP",
+		maxPDULength:   16384,
+		presentationCtxs: map[byte]*PresentationContext{
+			1: {ID: 1, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelFind, TransferSyntax: types.ImplicitVRLittleEndian, Accepted: true},
+			3: {ID: 3, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelMove, TransferSyntax: types.ImplicitVRLittleEndian, Accepted: true},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	// One matching study, then a move of its two instances
+	match := dicom.NewDataset()
+	match.AddElement(studyUID, dicom.VR_UI, "1.2.3.4")
+	queueResponse(t, conn, 1, &types.Message{CommandField: dimse.CFindRSP, MessageIDBeingRespondedTo: 1, Status: dimse.StatusPending}, match)
+	queueResponse(t, conn, 1, &types.Message{CommandField: dimse.CFindRSP, MessageIDBeingRespondedTo: 1, Status: dimse.StatusSuccess}, nil)
+	queueResponse(t, conn, 3, &types.Message{
+		CommandField: dimse.CMoveRSP, MessageIDBeingRespondedTo: 2, Status: dimse.StatusPending,
+		NumberOfRemainingSuboperations: count(1), NumberOfCompletedSuboperations: count(1),
+		NumberOfFailedSuboperations: count(0), NumberOfWarningSuboperations: count(0),
+	}, nil)
+	queueResponse(t, conn, 3, &types.Message{
+		CommandField: dimse.CMoveRSP, MessageIDBeingRespondedTo: 2, Status: dimse.StatusSuccess,
+		NumberOfCompletedSuboperations: count(2), NumberOfFailedSuboperations: count(0), NumberOfWarningSuboperations: count(0),
+	}, nil)
+
+	query := dicom.NewDataset()
+	query.AddElement(patientID, dicom.VR_LO, "PAT-1")
+	result, err := assoc.FindAndMove(query, "ARCHIVE")
+	if err != nil {
+		t.Fatalf("FindAndMove() error = %v", err)
+	}
+	if len(result.StudyInstanceUIDs) != 1 || result.StudyInstanceUIDs[0] != "1.2.3.4" {
+		t.Errorf("Studies = %v, want [1.2.3.4]", result.StudyInstanceUIDs)
+	}
+	if result.Completed != 2 || result.Failed != 0 || result.Statuses["1.2.3.4"] != dimse.StatusSuccess {
+		t.Errorf("Result = %+v, want 2 completed with success", result)
+	}
+	if _, ok := query.GetElement(studyUID); ok {
+		t.Error("FindAndMove() modified the caller's query")
+	}
+
+	find, findData, err := dimse.ReceiveDIMSEMessage(conn.writeBuf)
+	if err != nil || find.CommandField != dimse.CFindRQ {
+		t.Fatalf("First request = %+v, %v; want C-FIND-RQ", find, err)
+	}
+	identifier, err := dicom.ParseDatasetWithTransferSyntax(findData, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	if identifier.GetString(patientID) != "PAT-1" || identifier.GetString(dicom.Tag{Group: 0x0008, Element: 0x0052}) != "STUDY" {
+		t.Errorf("C-FIND identifier = %v, want a STUDY query for PAT-1", identifier.Elements)
+	}
+
+	move, moveData, err := dimse.ReceiveDIMSEMessage(conn.writeBuf)
+	if err != nil || move.CommandField != dimse.CMoveRQ {
+		t.Fatalf("Second request = %+v, %v; want C-MOVE-RQ", move, err)
+	}
+	if move.MoveDestination != "ARCHIVE" || move.AffectedSOPClassUID != types.StudyRootQueryRetrieveInformationModelMove {
+		t.Errorf("C-MOVE-RQ = %+v, want Study Root MOVE to ARCHIVE", move)
+	}
+	identifier, err = dicom.ParseDatasetWithTransferSyntax(moveData, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	if identifier.GetString(studyUID) != "1.2.3.4" {
+		t.Errorf("C-MOVE identifier study = %q, want 1.2.3.4", identifier.GetString(studyUID))
+	}
+}
+
+func TestFindAndMove_RequiresMoveContext(t *testing.T) {
+	assoc := &Association{
+		state: StateEstablished,
+		conn:  newMockConn(),
+		presentationCtxs: map[byte]*PresentationContext{
+			1: {ID: 1, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelFind, TransferSyntax: types.ImplicitVRLittleEndian, Accepted: true},
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if _, err := assoc.FindAndMove(dicom.NewDataset(), "ARCHIVE"); err == nil {
+		t.Error("FindAndMove() without a MOVE context succeeded, want an error")
+	}
+}
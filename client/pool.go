@@ -0,0 +1,270 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// ErrPoolClosed is returned by Pool.Do once the pool has been closed.
+var ErrPoolClosed = errors.New("association pool is closed")
+
+// PoolConfig configures a Pool of associations to one SCP.
+type PoolConfig struct {
+	Config                            // Association configuration used for every connection
+	Size                int           // Maximum number of associations (default: 2)
+	HealthCheckInterval time.Duration // Period between C-ECHO checks of idle associations (default: 30s, negative disables)
+}
+
+// Pool keeps warm associations to one SCP and hands each to a single
+// operation at a time, e.g. for a bridge issuing many C-FINDs to one PACS.
+type Pool struct {
+	address string
+	config  PoolConfig
+	connect func() (*Association, error)
+
+	// slots holds one token per association in use, bounding them to Size
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*Association
+	open   int // associations idle or in use
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPool connects config.Size associations to address and starts checking
+// idle ones with C-ECHO. Verification must be among the proposed SOP
+// classes for health checks to succeed.
+func NewPool(address string, config PoolConfig) (*Pool, error) {
+	return newPool(address, config, func() (*Association, error) {
+		return Connect(address, config.Config)
+	})
+}
+
+func newPool(address string, config PoolConfig, connect func() (*Association, error)) (*Pool, error) {
+	if config.Size <= 0 {
+		config.Size = 2
+	}
+	if config.HealthCheckInterval == 0 {
+		config.HealthCheckInterval = 30 * time.Second
+	}
+
+	p := &Pool{
+		address: address,
+		config:  config,
+		connect: connect,
+		slots:   make(chan struct{}, config.Size),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	for i := 0; i < config.Size; i++ {
+		assoc, err := connect()
+		if err != nil {
+			for _, assoc := range p.idle {
+				assoc.Close()
+			}
+			return nil, fmt.Errorf("failed to warm association pool: %w", err)
+		}
+		p.idle = append(p.idle, assoc)
+		p.open++
+	}
+
+	if config.HealthCheckInterval > 0 {
+		go p.checkHealth()
+	} else {
+		close(p.done)
+	}
+	return p, nil
+}
+
+// Do runs fn with an association no other operation is using, waiting for
+// one to become free if all are busy. An association for which fn returns
+// an error is closed and replaced, since its state is unknown.
+func (p *Pool) Do(fn func(*Association) error) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	assoc, err := p.checkout()
+	if err != nil {
+		return err
+	}
+
+	err = fn(assoc)
+	p.checkin(assoc, err == nil && assoc.state == StateEstablished)
+	return err
+}
+
+// checkout takes an idle association, connecting a new one if there is none.
+// The caller must hold a slot.
+func (p *Pool) checkout() (*Association, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		assoc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		if err := assoc.refreshDeadlines(); err != nil {
+			p.checkin(assoc, false)
+			return nil, err
+		}
+		return assoc, nil
+	}
+	p.open++
+	p.mu.Unlock()
+
+	assoc, err := p.connect()
+	if err != nil {
+		p.mu.Lock()
+		p.open--
+		p.mu.Unlock()
+		return nil, fmt.Errorf("failed to connect to %s: %w", p.address, err)
+	}
+	return assoc, nil
+}
+
+// checkin returns an association to the idle list, or closes it if it is no
+// longer usable or the pool was closed meanwhile
+func (p *Pool) checkin(assoc *Association, reusable bool) {
+	p.mu.Lock()
+	if reusable && !p.closed {
+		p.idle = append(p.idle, assoc)
+		p.mu.Unlock()
+		return
+	}
+	p.open--
+	p.mu.Unlock()
+
+	if err := assoc.Close(); err != nil {
+		assoc.logger.Debug("Failed to close pooled association", "error", err)
+	}
+}
+
+// checkHealth periodically verifies idle associations until the pool closes
+func (p *Pool) checkHealth() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.verifyIdle()
+		}
+	}
+}
+
+// verifyIdle sends a C-ECHO on each idle association, replacing those that
+// fail, and reconnects associations lost since the last check. Associations
+// in use are left alone.
+func (p *Pool) verifyIdle() {
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+
+	for i := 0; i < idle; i++ {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			return // Busy; the associations are evidently in use
+		}
+
+		p.mu.Lock()
+		if p.closed || len(p.idle) == 0 {
+			p.mu.Unlock()
+			<-p.slots
+			return
+		}
+		// Oldest first, so the ones Do hands out most recently are not probed
+		assoc := p.idle[0]
+		p.idle = p.idle[1:]
+		p.mu.Unlock()
+
+		err := assoc.refreshDeadlines()
+		if err == nil {
+			err = echo(assoc)
+		}
+		if err != nil {
+			assoc.logger.Warn("Pooled association failed health check, replacing it",
+				"address", p.address,
+				"error", err)
+		}
+		p.checkin(assoc, err == nil)
+		<-p.slots
+	}
+
+	p.refill()
+}
+
+// refill reconnects associations until the pool holds Size again
+func (p *Pool) refill() {
+	for {
+		p.mu.Lock()
+		if p.closed || p.open >= p.config.Size {
+			p.mu.Unlock()
+			return
+		}
+		p.open++
+		p.mu.Unlock()
+
+		assoc, err := p.connect()
+		if err != nil {
+			p.mu.Lock()
+			p.open--
+			p.mu.Unlock()
+			return // Do connects on demand once the SCP is back
+		}
+		p.checkin(assoc, true)
+	}
+}
+
+// echo verifies an association with a C-ECHO
+func echo(assoc *Association) error {
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		return err
+	}
+	if resp.Status != types.StatusSuccess {
+		return fmt.Errorf("C-ECHO failed with status 0x%04x (%s)", resp.Status, dimse.StatusText(dimse.CEchoRSP, resp.Status))
+	}
+	return nil
+}
+
+// Close releases the idle associations and stops health checks. Associations
+// in use are released when their operation finishes.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	p.mu.Unlock()
+
+	close(p.stop)
+	<-p.done
+
+	var errs []error
+	for _, assoc := range idle {
+		if err := assoc.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
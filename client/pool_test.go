@@ -0,0 +1,205 @@
+package client
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/services"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// countingListener counts the connections it accepts
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// startEchoSCP serves C-ECHO on a local port until the test ends
+func startEchoSCP(t *testing.T) *countingListener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	listener := &countingListener{Listener: inner}
+	serveEchoSCP(t, listener, "ECHO_SCP")
+	return listener
+}
+
+// echoDIMSEHandler adapts a dimse.Service to the PDU layer
+type echoDIMSEHandler struct {
+	service *dimse.Service
+}
+
+func (h echoDIMSEHandler) HandleDIMSEMessage(presContextID byte, msgCtrlHeader byte, data []byte, layer *pdu.Layer) error {
+	return h.service.HandleDIMSEMessage(presContextID, msgCtrlHeader, data, layer)
+}
+
+// serveEchoSCP answers C-ECHO on each connection accepted by listener until
+// the test ends. The server package cannot be used here, as it imports this
+// package.
+func serveEchoSCP(t *testing.T, listener net.Listener, aeTitle string, opts ...pdu.LayerOption) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+		wg    sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handler := echoDIMSEHandler{service: dimse.NewService(services.NewEchoService(), logger)}
+				_ = pdu.NewLayer(conn, handler, aeTitle, logger, opts...).HandleConnection()
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+}
+
+func newTestPool(t *testing.T, listener net.Listener) *Pool {
+	t.Helper()
+	pool, err := NewPool(listener.Addr().String(), PoolConfig{
+		Config: Config{
+			CallingAETitle: "TEST_SCU",
+			CalledAETitle:  "ECHO_SCP",
+			SOPClasses:     []string{types.VerificationSOPClass},
+			Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+		Size:                2,
+		HealthCheckInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestPool_ReusesAssociations(t *testing.T) {
+	listener := startEchoSCP(t)
+	pool := newTestPool(t, listener)
+
+	used := make(map[*Association]bool)
+	for i := 0; i < 5; i++ {
+		err := pool.Do(func(assoc *Association) error {
+			used[assoc] = true
+			resp, err := assoc.SendCEcho(uint16(i + 1))
+			if err == nil && resp.Status != types.StatusSuccess {
+				t.Errorf("C-ECHO %d status = 0x%04X, want success", i, resp.Status)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Do() %d error = %v", i, err)
+		}
+	}
+
+	if n := listener.accepted.Load(); n != 2 {
+		t.Errorf("SCP accepted %d associations, want the 2 warm ones", n)
+	}
+	if len(used) > 2 {
+		t.Errorf("Operations used %d associations, want at most 2", len(used))
+	}
+}
+
+func TestPool_CheckoutIsExclusive(t *testing.T) {
+	listener := startEchoSCP(t)
+	pool := newTestPool(t, listener)
+
+	// Both operations hold their association until the other has one too
+	var (
+		mu    sync.Mutex
+		held  []*Association
+		ready sync.WaitGroup
+		wg    sync.WaitGroup
+	)
+	ready.Add(2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pool.Do(func(assoc *Association) error {
+				mu.Lock()
+				held = append(held, assoc)
+				mu.Unlock()
+				ready.Done()
+				ready.Wait()
+				_, err := assoc.SendCEcho(1)
+				return err
+			})
+			if err != nil {
+				t.Errorf("Do() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(held) != 2 || held[0] == held[1] {
+		t.Errorf("Concurrent operations shared an association: %v", held)
+	}
+}
+
+func TestPool_ReplacesDeadAssociations(t *testing.T) {
+	listener := startEchoSCP(t)
+	pool := newTestPool(t, listener)
+
+	// Break one idle association behind the pool's back
+	pool.mu.Lock()
+	pool.idle[0].conn.Close()
+	pool.mu.Unlock()
+
+	pool.verifyIdle()
+
+	if n := listener.accepted.Load(); n != 3 {
+		t.Errorf("SCP accepted %d associations, want 3 after replacing the dead one", n)
+	}
+	for i := 0; i < 4; i++ {
+		if err := pool.Do(func(assoc *Association) error {
+			_, err := assoc.SendCEcho(1)
+			return err
+		}); err != nil {
+			t.Fatalf("Do() after health check error = %v", err)
+		}
+	}
+
+	pool.Close()
+	if err := pool.Do(func(*Association) error { return nil }); err != ErrPoolClosed {
+		t.Errorf("Do() after Close error = %v, want ErrPoolClosed", err)
+	}
+}
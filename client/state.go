@@ -0,0 +1,77 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/caio-sobreiro/dicomnet/pdu"
+)
+
+// ErrAssociationClosed is returned by operations on an association that is
+// no longer established, e.g. after Close, Abort or a release by the SCP.
+var ErrAssociationClosed = errors.New("association closed")
+
+// AssociationState is the lifecycle state of an Association
+type AssociationState int
+
+const (
+	// StateIdle is an association whose negotiation has not completed
+	StateIdle AssociationState = iota
+	// StateEstablished is an association accepted by the SCP and usable
+	StateEstablished
+	// StateReleasing is an association waiting for its A-RELEASE-RP
+	StateReleasing
+	// StateReleased is an association released by either side
+	StateReleased
+	// StateAborted is an association ended by an A-ABORT
+	StateAborted
+)
+
+// String returns the state name
+func (s AssociationState) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateEstablished:
+		return "Established"
+	case StateReleasing:
+		return "Releasing"
+	case StateReleased:
+		return "Released"
+	case StateAborted:
+		return "Aborted"
+	}
+	return fmt.Sprintf("AssociationState(%d)", int(s))
+}
+
+// State returns the association's current state
+func (a *Association) State() AssociationState {
+	return a.state
+}
+
+// checkEstablished returns ErrAssociationClosed unless the association can
+// carry operations
+func (a *Association) checkEstablished() error {
+	if a.state != StateEstablished {
+		return fmt.Errorf("%w (state %s)", ErrAssociationClosed, a.state)
+	}
+	return nil
+}
+
+// Abort ends the association at once with an A-ABORT and closes the
+// connection, without waiting for outstanding operations.
+func (a *Association) Abort() error {
+	if a.state == StateReleased || a.state == StateAborted {
+		return nil
+	}
+	a.state = StateAborted
+
+	// Source 0 (service user), reason 0 (not significant)
+	abort := []byte{pdu.TypeAbort, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+	if _, err := a.conn.Write(abort); err != nil {
+		a.logger.Debug("Failed to send A-ABORT", "error", err)
+	} else if err := pdu.FlushConn(a.conn); err != nil {
+		a.logger.Debug("Failed to send A-ABORT", "error", err)
+	}
+	return a.conn.Close()
+}
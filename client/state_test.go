@@ -0,0 +1,78 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestAssociation_OperationsAfterEnd(t *testing.T) {
+	listener := startEchoSCP(t)
+
+	for name, end := range map[string]func(*Association) error{
+		"Close": (*Association).Close,
+		"Abort": (*Association).Abort,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assoc, err := Connect(listener.Addr().String(), Config{
+				CallingAETitle: "TEST_SCU",
+				CalledAETitle:  "ECHO_SCP",
+				SOPClasses:     []string{types.VerificationSOPClass, types.CTImageStorage},
+				Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			if state := assoc.State(); state != StateEstablished {
+				t.Fatalf("State() after Connect = %s, want Established", state)
+			}
+			if err := end(assoc); err != nil {
+				t.Fatalf("%s() error = %v", name, err)
+			}
+
+			want := StateReleased
+			if name == "Abort" {
+				want = StateAborted
+			}
+			if state := assoc.State(); state != want {
+				t.Errorf("State() after %s = %s, want %s", name, state, want)
+			}
+			if _, err := assoc.SendCEcho(1); !errors.Is(err, ErrAssociationClosed) {
+				t.Errorf("SendCEcho() error = %v, want ErrAssociationClosed", err)
+			}
+			if _, err := assoc.SendCStore(&CStoreRequest{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.3", Data: []byte{0x00}}); !errors.Is(err, ErrAssociationClosed) {
+				t.Errorf("SendCStore() error = %v, want ErrAssociationClosed", err)
+			}
+		})
+	}
+}
+
+func TestAssociation_ReleaseCollision(t *testing.T) {
+	conn := newMockConn()
+	assoc := &Association{
+		state:  StateEstablished,
+		conn:   conn,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	// The SCP's own A-RELEASE-RQ arrives before its A-RELEASE-RP
+	conn.readBuf.Write([]byte{pdu.TypeReleaseRQ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00})
+	conn.readBuf.Write([]byte{pdu.TypeReleaseRP, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00})
+
+	if err := assoc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	written := conn.writeBuf.Bytes()
+	if len(written) != 20 || written[0] != pdu.TypeReleaseRQ || written[10] != pdu.TypeReleaseRP {
+		t.Errorf("Written = % X, want an A-RELEASE-RQ and then an A-RELEASE-RP", written)
+	}
+	if conn.readBuf.Len() != 0 {
+		t.Errorf("%d bytes left unread, want the A-RELEASE-RP consumed", conn.readBuf.Len())
+	}
+	if state := assoc.State(); state != StateReleased {
+		t.Errorf("State() = %s, want Released", state)
+	}
+}
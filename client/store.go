@@ -1,57 +1,266 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
 )
 
 // CStoreRequest represents a C-STORE request
 type CStoreRequest struct {
 	SOPClassUID    string
 	SOPInstanceUID string
-	Data           []byte
+	Data           []byte // Raw dataset, or a complete Part 10 file
 	MessageID      uint16
+
+	// TransferSyntax is the transfer syntax Data is encoded in. It is read from
+	// the File Meta Information when Data is a Part 10 file; raw datasets should
+	// set it so the store can be checked against the negotiated context.
+	TransferSyntax string
+
+	// Progress, when set, is called after each P-DATA-TF PDU of the dataset
+	// is written with the bytes sent so far and the dataset size.
+	Progress func(sent, total int)
+}
+
+// storeContext picks the presentation context to store a dataset encoded in
+// transferSyntax on. Without a context for that syntax, a context whose
+// syntax the dataset can be transcoded to is used if transcode is set.
+func (a *Association) storeContext(sopClass, transferSyntax string, transcode bool) (*PresentationContext, error) {
+	presCtx, err := a.GetPresentationContext(sopClass)
+	if err != nil {
+		return nil, fmt.Errorf("no presentation context for SOP class %s: %w", sopClass, err)
+	}
+	if transferSyntax == "" {
+		return presCtx, nil
+	}
+
+	if match, err := a.PresentationContextFor(sopClass, transferSyntax); err == nil {
+		return match, nil
+	}
+
+	if transcode && isTranscodable(transferSyntax) {
+		for _, pc := range a.acceptedContextsFor(sopClass) {
+			if isTranscodable(pc.TransferSyntax) {
+				return pc, nil
+			}
+		}
+	}
+
+	// The dataset is sent as-is, so it must already be in the negotiated syntax
+	return nil, fmt.Errorf("dataset is encoded in %s but the presentation context for %s negotiated %s",
+		transferSyntax, sopClass, presCtx.TransferSyntax)
+}
+
+// isTranscodable reports whether dicom.Transcode converts to and from a syntax
+func isTranscodable(transferSyntax string) bool {
+	return transferSyntax == types.ExplicitVRLittleEndian || transferSyntax == types.ImplicitVRLittleEndian
 }
 
 // CStoreResponse represents a C-STORE response
 type CStoreResponse struct {
-	Status         uint16
-	MessageID      uint16
-	SOPClassUID    string
-	SOPInstanceUID string
+	Status            uint16
+	MessageID         uint16
+	SOPClassUID       string
+	SOPInstanceUID    string
+	OffendingElements []types.Tag // Attributes the SCP reported as offending, if any
+	ErrorComment      string      // Free-text failure description, if any
 }
 
-// SendCStore sends a C-STORE request and waits for response
+// SendCStore sends a C-STORE request and waits for response. When several
+// contexts were accepted for the SOP class, the one whose transfer syntax
+// matches the dataset is used. A response naming another SOP instance is
+// returned with ErrInstanceUIDMismatch.
 func (a *Association) SendCStore(req *CStoreRequest) (*CStoreResponse, error) {
-	// Find presentation context for this SOP Class
-	presContextID, err := a.GetPresentationContextID(req.SOPClassUID)
-	if err != nil {
-		return nil, fmt.Errorf("no presentation context for SOP class %s: %w", req.SOPClassUID, err)
+	return a.sendCStore(req, false)
+}
+
+// StoreBatch sends each request with C-STORE, in order. Every instance goes
+// on the presentation context whose transfer syntax matches its own; it is
+// transcoded only when no such context was accepted and both syntaxes are
+// uncompressed little endian.
+//
+// Responses are returned in request order, with nil for instances for which
+// no response was received. The error joins the failures of individual
+// instances.
+func (a *Association) StoreBatch(reqs []*CStoreRequest) ([]*CStoreResponse, error) {
+	responses := make([]*CStoreResponse, len(reqs))
+	var errs []error
+	for i, req := range reqs {
+		resp, err := a.sendCStore(req, true)
+		responses[i] = resp
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", req.SOPInstanceUID, err))
+			if a.state != StateEstablished {
+				break
+			}
+			continue
+		}
 	}
+	return responses, errors.Join(errs...)
+}
 
-	a.logger.Debug("Sending C-STORE-RQ",
-		"sop_class", req.SOPClassUID,
-		"sop_instance", req.SOPInstanceUID,
-		"data_size", len(req.Data))
+// StoreBatchPipelined sends the requests like StoreBatch, but keeps up to
+// MaxOperationsInvoked of them outstanding before reading responses, which
+// are matched to requests by Message ID. Without a negotiated Asynchronous
+// Operations Window it stores one instance at a time.
+//
+// Requests without a Message ID, or whose Message ID is already
+// outstanding, are given one.
+func (a *Association) StoreBatchPipelined(reqs []*CStoreRequest) ([]*CStoreResponse, error) {
+	if err := a.checkEstablished(); err != nil {
+		return nil, err
+	}
 
-	// Use shared dimse.SendCStore
-	dimseReq := &dimse.CStoreRequest{
-		SOPClassUID:    req.SOPClassUID,
-		SOPInstanceUID: req.SOPInstanceUID,
-		Data:           req.Data,
-		MessageID:      req.MessageID,
+	window := a.MaxOperationsInvoked()
+	responses := make([]*CStoreResponse, len(reqs))
+	outstanding := make(map[uint16]int, window) // Message ID -> request index
+	var errs []error
+	var nextID uint16
+	next := 0
+	for next < len(reqs) || len(outstanding) > 0 {
+		for next < len(reqs) && len(outstanding) < window {
+			i, req := next, reqs[next]
+			next++
+
+			dimseReq, presCtxID, err := a.prepareCStore(req, true)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("instance %s: %w", req.SOPInstanceUID, err))
+				continue
+			}
+			if _, busy := outstanding[dimseReq.MessageID]; busy || dimseReq.MessageID == 0 {
+				for {
+					nextID++
+					if _, busy := outstanding[nextID]; !busy && nextID != 0 {
+						break
+					}
+				}
+				dimseReq.MessageID = nextID
+			}
+
+			if err := dimse.SendCStoreRequest(a.conn, presCtxID, a.maxPDULength, dimseReq); err != nil {
+				errs = append(errs, fmt.Errorf("instance %s: %w", req.SOPInstanceUID, err))
+				return responses, errors.Join(errs...)
+			}
+			outstanding[dimseReq.MessageID] = i
+		}
+		if len(outstanding) == 0 {
+			continue
+		}
+
+		msg, _, err := a.receiveMessage()
+		if err != nil {
+			// receiveMessage has already marked a release by the peer in
+			// the association state
+			errs = append(errs, fmt.Errorf("failed to receive C-STORE-RSP: %w", err))
+			break
+		}
+		i, ok := outstanding[msg.MessageIDBeingRespondedTo]
+		if msg.CommandField != dimse.CStoreRSP || !ok {
+			errs = append(errs, fmt.Errorf("unexpected command 0x%04x responding to message %d",
+				msg.CommandField, msg.MessageIDBeingRespondedTo))
+			continue
+		}
+		delete(outstanding, msg.MessageIDBeingRespondedTo)
+
+		resp := cStoreResponse(msg)
+		responses[i] = resp
+		// The Affected SOP Instance UID is optional in the response
+		if resp.SOPInstanceUID != "" && resp.SOPInstanceUID != reqs[i].SOPInstanceUID {
+			errs = append(errs, fmt.Errorf("instance %s: %w: response names %s (status 0x%04x)",
+				reqs[i].SOPInstanceUID, ErrInstanceUIDMismatch, resp.SOPInstanceUID, resp.Status))
+		}
+	}
+	return responses, errors.Join(errs...)
+}
+
+// cStoreResponse converts a received C-STORE-RSP
+func cStoreResponse(msg *types.Message) *CStoreResponse {
+	return &CStoreResponse{
+		Status:            msg.Status,
+		MessageID:         msg.MessageIDBeingRespondedTo,
+		SOPClassUID:       msg.EffectiveSOPClassUID(),
+		SOPInstanceUID:    msg.AffectedSOPInstanceUID,
+		OffendingElements: msg.OffendingElements,
+		ErrorComment:      msg.ErrorComment,
 	}
+}
 
-	dimseResp, err := dimse.SendCStore(a.conn, presContextID, a.maxPDULength, dimseReq)
+// sendCStore sends one C-STORE, transcoding the dataset to the context's
+// transfer syntax if allowed and needed
+func (a *Association) sendCStore(req *CStoreRequest, transcode bool) (*CStoreResponse, error) {
+	if err := a.checkEstablished(); err != nil {
+		return nil, err
+	}
+
+	dimseReq, presCtxID, err := a.prepareCStore(req, transcode)
 	if err != nil {
 		return nil, err
 	}
 
+	dimseResp, err := dimse.SendCStore(a.conn, presCtxID, a.maxPDULength, dimseReq)
+	if dimseResp == nil {
+		a.notePeerRelease(err)
+		return nil, err
+	}
+
 	return &CStoreResponse{
-		Status:         dimseResp.Status,
-		MessageID:      dimseResp.MessageID,
-		SOPClassUID:    dimseResp.SOPClassUID,
-		SOPInstanceUID: dimseResp.SOPInstanceUID,
-	}, nil
+		Status:            dimseResp.Status,
+		MessageID:         dimseResp.MessageID,
+		SOPClassUID:       dimseResp.SOPClassUID,
+		SOPInstanceUID:    dimseResp.SOPInstanceUID,
+		OffendingElements: dimseResp.OffendingElements,
+		ErrorComment:      dimseResp.ErrorComment,
+	}, err
+}
+
+// prepareCStore picks the presentation context for a C-STORE and brings the
+// dataset into its transfer syntax
+func (a *Association) prepareCStore(req *CStoreRequest, transcode bool) (*dimse.CStoreRequest, byte, error) {
+	data := req.Data
+	transferSyntax := req.TransferSyntax
+	if dicom.HasPart10Header(data) {
+		dataset, fileTransferSyntax, err := dicom.SplitPart10(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read Part 10 data: %w", err)
+		}
+		data = dataset
+		if transferSyntax == "" {
+			transferSyntax = fileTransferSyntax
+		}
+	}
+
+	presCtx, err := a.storeContext(req.SOPClassUID, transferSyntax, transcode)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if transferSyntax != "" && presCtx.TransferSyntax != "" && transferSyntax != presCtx.TransferSyntax {
+		a.logger.Debug("Transcoding dataset for C-STORE",
+			"sop_instance", req.SOPInstanceUID,
+			"from", transferSyntax,
+			"to", presCtx.TransferSyntax)
+		data, err = dicom.Transcode(data, transferSyntax, presCtx.TransferSyntax)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to transcode dataset: %w", err)
+		}
+		transferSyntax = presCtx.TransferSyntax
+	}
+
+	a.logger.Debug("Sending C-STORE-RQ",
+		"sop_class", req.SOPClassUID,
+		"sop_instance", req.SOPInstanceUID,
+		"transfer_syntax", transferSyntax,
+		"data_size", len(data))
+
+	return &dimse.CStoreRequest{
+		SOPClassUID:    req.SOPClassUID,
+		SOPInstanceUID: req.SOPInstanceUID,
+		Data:           data,
+		MessageID:      req.MessageID,
+		Progress:       req.Progress,
+	}, presCtx.ID, nil
 }
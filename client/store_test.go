@@ -0,0 +1,451 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func newStoreTestAssociation(conn *mockConn, transferSyntax string) *Association {
+	return &Association{
+		state:          StateEstablished,
+		conn:           conn,
+		callingAETitle: "TEST_SCU",
+		calledAETitle:  "TEST_SCP",
+		maxPDULength:   16384,
+		presentationCtxs: map[byte]*PresentationContext{
+			3: {
+				ID:             3,
+				AbstractSyntax: types.CTImageStorage,
+				TransferSyntax: transferSyntax,
+				Accepted:       true,
+			},
+		},
+		logger: slog.Default(),
+	}
+}
+
+func queueCStoreResponse(conn *mockConn) {
+	conn.readBuf.Write(buildPDataPDU(3, true, true, dimse.EncodeCommandSet(&types.Message{
+		CommandField:              dimse.CStoreRSP,
+		MessageIDBeingRespondedTo: 1,
+		CommandDataSetType:        0x0101,
+		Status:                    dimse.StatusSuccess,
+		AffectedSOPClassUID:       types.CTImageStorage,
+	})))
+}
+
+// buildPart10 wraps a dataset in a minimal Part 10 header
+func buildPart10(dataset []byte, transferSyntax string) []byte {
+	meta := dicom.NewDataset()
+	meta.AddElement(dicom.Tag{Group: 0x0002, Element: 0x0010}, dicom.VR_UI, transferSyntax)
+
+	data := make([]byte, 128)
+	data = append(data, []byte("DICM")...)
+	data = append(data, meta.EncodeDataset()...)
+	return append(data, dataset...)
+}
+
+func TestSendCStore_Part10Data(t *testing.T) {
+	ds := dicom.NewDataset()
+	ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3.4.5")
+	raw, _ := dicom.EncodeDatasetWithTransferSyntax(ds, types.ImplicitVRLittleEndian)
+
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ImplicitVRLittleEndian)
+	queueCStoreResponse(conn)
+
+	resp, err := assoc.SendCStore(&CStoreRequest{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4.5",
+		Data:           buildPart10(raw, types.ImplicitVRLittleEndian),
+		MessageID:      1,
+	})
+	if err != nil {
+		t.Fatalf("SendCStore returned error: %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess {
+		t.Fatalf("C-STORE status = 0x%04X, want success", resp.Status)
+	}
+
+	_, sent, err := dimse.ReceiveDIMSEMessage(conn.writeBuf)
+	if err != nil {
+		t.Fatalf("failed to decode sent C-STORE-RQ: %v", err)
+	}
+	if !bytes.Equal(sent, raw) {
+		t.Fatalf("expected Part 10 header to be stripped before sending")
+	}
+}
+
+func TestSendCStore_RawDataset(t *testing.T) {
+	ds := dicom.NewDataset()
+	ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3.4.5")
+	raw := ds.EncodeDataset()
+
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ExplicitVRLittleEndian)
+	queueCStoreResponse(conn)
+
+	if _, err := assoc.SendCStore(&CStoreRequest{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4.5",
+		Data:           raw,
+		MessageID:      1,
+		TransferSyntax: types.ExplicitVRLittleEndian,
+	}); err != nil {
+		t.Fatalf("SendCStore returned error: %v", err)
+	}
+
+	_, sent, err := dimse.ReceiveDIMSEMessage(conn.writeBuf)
+	if err != nil {
+		t.Fatalf("failed to decode sent C-STORE-RQ: %v", err)
+	}
+	if !bytes.Equal(sent, raw) {
+		t.Fatalf("raw dataset was modified before sending")
+	}
+}
+
+func TestSendCStore_TransferSyntaxMismatch(t *testing.T) {
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ImplicitVRLittleEndian)
+
+	_, err := assoc.SendCStore(&CStoreRequest{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4.5",
+		Data:           []byte{0x08, 0x00, 0x18, 0x00},
+		MessageID:      1,
+		TransferSyntax: types.ExplicitVRLittleEndian,
+	})
+	if err == nil || !strings.Contains(err.Error(), "negotiated") {
+		t.Fatalf("expected transfer syntax mismatch error, got %v", err)
+	}
+	if conn.writeBuf.Len() != 0 {
+		t.Fatal("nothing should be sent when the transfer syntax does not match")
+	}
+}
+
+func TestSendCStore_FailureDetails(t *testing.T) {
+	offending := []types.Tag{
+		{Group: 0x0010, Element: 0x0010},
+		{Group: 0x0020, Element: 0x000D},
+	}
+	response, err := dimse.EncodeCommand(&types.Message{
+		CommandField:              dimse.CStoreRSP,
+		MessageIDBeingRespondedTo: 1,
+		CommandDataSetType:        0x0101,
+		Status:                    0xA900,
+		AffectedSOPClassUID:       types.CTImageStorage,
+		AffectedSOPInstanceUID:    "1.2.3.4.5",
+		OffendingElements:         offending,
+		ErrorComment:              "Patient Name missing",
+	})
+	if err != nil {
+		t.Fatalf("EncodeCommand returned error: %v", err)
+	}
+
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ImplicitVRLittleEndian)
+	conn.readBuf.Write(buildPDataPDU(3, true, true, response))
+
+	resp, err := assoc.SendCStore(&CStoreRequest{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4.5",
+		Data:           []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00},
+		MessageID:      1,
+	})
+	if err != nil {
+		t.Fatalf("SendCStore returned error: %v", err)
+	}
+
+	if resp.Status != 0xA900 {
+		t.Errorf("Status = 0x%04X, want 0xA900", resp.Status)
+	}
+	if len(resp.OffendingElements) != len(offending) {
+		t.Fatalf("OffendingElements = %v, want %v", resp.OffendingElements, offending)
+	}
+	for i, tag := range offending {
+		if resp.OffendingElements[i] != tag {
+			t.Errorf("OffendingElements[%d] = %s, want %s", i, resp.OffendingElements[i], tag)
+		}
+	}
+	if resp.ErrorComment != "Patient Name missing" {
+		t.Errorf("ErrorComment = %q, want %q", resp.ErrorComment, "Patient Name missing")
+	}
+}
+
+func TestSendCStore_InstanceUIDMismatch(t *testing.T) {
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ImplicitVRLittleEndian)
+	conn.readBuf.Write(buildPDataPDU(3, true, true, dimse.EncodeCommandSet(&types.Message{
+		CommandField:              dimse.CStoreRSP,
+		MessageIDBeingRespondedTo: 1,
+		CommandDataSetType:        0x0101,
+		Status:                    dimse.StatusSuccess,
+		AffectedSOPClassUID:       types.CTImageStorage,
+		AffectedSOPInstanceUID:    "9.9.9",
+	})))
+
+	resp, err := assoc.SendCStore(&CStoreRequest{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4.5",
+		Data:           []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00},
+		MessageID:      1,
+	})
+	if !errors.Is(err, ErrInstanceUIDMismatch) {
+		t.Fatalf("SendCStore() error = %v, want ErrInstanceUIDMismatch", err)
+	}
+	if !strings.Contains(err.Error(), "1.2.3.4.5") || !strings.Contains(err.Error(), "9.9.9") {
+		t.Errorf("Error %q does not name both instances", err)
+	}
+	if resp == nil || resp.Status != dimse.StatusSuccess || resp.SOPInstanceUID != "9.9.9" {
+		t.Errorf("Response = %+v, want the success response naming 9.9.9", resp)
+	}
+}
+
+func TestSendCStore_Progress(t *testing.T) {
+	data := bytes.Repeat([]byte{0x42}, 10000)
+
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ImplicitVRLittleEndian)
+	assoc.maxPDULength = 1024
+	queueCStoreResponse(conn)
+
+	var calls [][2]int
+	_, err := assoc.SendCStore(&CStoreRequest{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4.5",
+		Data:           data,
+		MessageID:      1,
+		Progress: func(sent, total int) {
+			calls = append(calls, [2]int{sent, total})
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendCStore returned error: %v", err)
+	}
+
+	// 10000 bytes in PDVs of at most 1012 bytes
+	if len(calls) != 10 {
+		t.Fatalf("Progress called %d times, want 10", len(calls))
+	}
+	prev := 0
+	for i, call := range calls {
+		sent, total := call[0], call[1]
+		if total != len(data) {
+			t.Errorf("Call %d total = %d, want %d", i, total, len(data))
+		}
+		if sent <= prev {
+			t.Errorf("Call %d sent = %d, not greater than previous %d", i, sent, prev)
+		}
+		prev = sent
+	}
+	if prev != len(data) {
+		t.Errorf("Final sent = %d, want %d", prev, len(data))
+	}
+}
+
+// sentCommandContexts returns the presentation context of each command the
+// client wrote
+func sentCommandContexts(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var contexts []byte
+	for offset := 0; offset < len(data); {
+		if offset+6 > len(data) {
+			t.Fatalf("truncated PDU at offset %d", offset)
+		}
+		length := int(binary.BigEndian.Uint32(data[offset+2 : offset+6]))
+		if data[offset] == pdu.TypePDataTF {
+			pdv := data[offset+6:]
+			if pdv[5]&0x03 == 0x03 {
+				contexts = append(contexts, pdv[4])
+			}
+		}
+		offset += 6 + length
+	}
+	return contexts
+}
+
+func TestStoreBatch_SelectsContextPerTransferSyntax(t *testing.T) {
+	conn := newMockConn()
+	assoc := newStoreTestAssociation(conn, types.ExplicitVRLittleEndian)
+	assoc.presentationCtxs[5] = &PresentationContext{
+		ID:             5,
+		AbstractSyntax: types.CTImageStorage,
+		TransferSyntax: types.JPEG2000,
+		Accepted:       true,
+	}
+
+	instance := dicom.NewDataset()
+	instance.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3.4.5")
+	explicit := instance.EncodeDataset()
+	implicit, _ := dicom.EncodeDatasetWithTransferSyntax(instance, types.ImplicitVRLittleEndian)
+	jpeg2000 := explicit // Compressed content is not inspected
+
+	reqs := []*CStoreRequest{
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.1", MessageID: 1, Data: jpeg2000, TransferSyntax: types.JPEG2000},
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2", MessageID: 1, Data: explicit, TransferSyntax: types.ExplicitVRLittleEndian},
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.3", MessageID: 1, Data: implicit, TransferSyntax: types.ImplicitVRLittleEndian},
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.4", MessageID: 1, Data: explicit, TransferSyntax: types.JPEGBaseline8Bit},
+	}
+	for range 3 {
+		queueCStoreResponse(conn)
+	}
+
+	responses, err := assoc.StoreBatch(reqs)
+	if err == nil || !strings.Contains(err.Error(), "instance 1.4") {
+		t.Errorf("StoreBatch() error = %v, want failure for instance 1.4 only", err)
+	}
+	if len(responses) != 4 || responses[3] != nil {
+		t.Fatalf("StoreBatch() responses = %v, want three responses and nil", responses)
+	}
+	for i, resp := range responses[:3] {
+		if resp == nil || resp.Status != dimse.StatusSuccess {
+			t.Errorf("Response %d = %+v, want success", i, resp)
+		}
+	}
+
+	// JPEG 2000 goes on its own context; Implicit VR is transcoded for the
+	// Explicit VR context rather than sent as-is
+	written := conn.writeBuf.Bytes()
+	if got, want := sentCommandContexts(t, written), []byte{5, 3, 3}; !bytes.Equal(got, want) {
+		t.Errorf("Sent on contexts %v, want %v", got, want)
+	}
+	_, datasets, err := dimse.ReadAllDIMSE(bytes.NewBuffer(written), 3)
+	if err != nil {
+		t.Fatalf("failed to decode C-STORE-RQs: %v", err)
+	}
+	for i, want := range [][]byte{jpeg2000, explicit, explicit} {
+		if data := datasets[i]; !bytes.Equal(data, want) {
+			t.Errorf("Dataset %d = % X, want % X", i, data, want)
+		}
+	}
+}
+
+// firstReadConn records what was written before the first read
+type firstReadConn struct {
+	*mockConn
+	writtenBeforeRead []byte
+}
+
+func (c *firstReadConn) Read(b []byte) (int, error) {
+	if c.writtenBeforeRead == nil {
+		c.writtenBeforeRead = bytes.Clone(c.writeBuf.Bytes())
+	}
+	return c.mockConn.Read(b)
+}
+
+func TestStoreBatchPipelined(t *testing.T) {
+	mock := newMockConn()
+	conn := &firstReadConn{mockConn: mock}
+	assoc := newStoreTestAssociation(mock, types.ExplicitVRLittleEndian)
+	assoc.conn = conn
+	assoc.proposeOperationsInvoked = 3
+	if ui := assoc.addUserInformation(nil); !bytes.Contains(ui, []byte{0x53, 0x00, 0x00, 0x04, 0x00, 0x03, 0x00, 0x01}) {
+		t.Errorf("User Information % X does not propose a window of 3", ui)
+	}
+	assoc.parseUserInformation([]byte{0x53, 0x00, 0x00, 0x04, 0x00, 0x01, 0x00, 0x03})
+	if got := assoc.MaxOperationsInvoked(); got != 3 {
+		t.Fatalf("MaxOperationsInvoked() = %d, want 3", got)
+	}
+
+	var reqs []*CStoreRequest
+	for i := range 5 {
+		reqs = append(reqs, &CStoreRequest{
+			SOPClassUID:    types.CTImageStorage,
+			SOPInstanceUID: "1.2." + string(rune('1'+i)),
+			Data:           []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00},
+		})
+	}
+	// Responses arrive out of order; each names its instance
+	for _, id := range []uint16{2, 1, 3, 5, 4} {
+		mock.readBuf.Write(buildPDataPDU(3, true, true, dimse.EncodeCommandSet(&types.Message{
+			CommandField:              dimse.CStoreRSP,
+			MessageIDBeingRespondedTo: id,
+			CommandDataSetType:        0x0101,
+			Status:                    dimse.StatusSuccess,
+			AffectedSOPClassUID:       types.CTImageStorage,
+			AffectedSOPInstanceUID:    reqs[id-1].SOPInstanceUID,
+		})))
+	}
+
+	responses, err := assoc.StoreBatchPipelined(reqs)
+	if err != nil {
+		t.Fatalf("StoreBatchPipelined() error = %v", err)
+	}
+
+	sent := 0
+	for before := bytes.NewBuffer(conn.writtenBeforeRead); before.Len() > 0; sent++ {
+		if _, _, err := dimse.ReceiveDIMSEMessage(before); err != nil {
+			t.Fatalf("failed to decode C-STORE-RQ: %v", err)
+		}
+	}
+	if sent != 3 {
+		t.Errorf("%d C-STORE-RQs written before the first response was read, want 3", sent)
+	}
+
+	for i, resp := range responses {
+		if resp == nil || resp.MessageID != uint16(i+1) || resp.SOPInstanceUID != reqs[i].SOPInstanceUID {
+			t.Errorf("Response %d = %+v, want the response to message %d", i, resp, i+1)
+		}
+	}
+}
+
+func TestStoreBatchPipelined_PeerRelease(t *testing.T) {
+	mock := newMockConn()
+	assoc := newStoreTestAssociation(mock, types.ExplicitVRLittleEndian)
+	assoc.state = StateEstablished
+	assoc.parseUserInformation([]byte{0x53, 0x00, 0x00, 0x04, 0x00, 0x01, 0x00, 0x03})
+
+	reqs := []*CStoreRequest{
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.1", Data: []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.2", Data: []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	// The SCP releases the association instead of answering
+	mock.readBuf.Write([]byte{pdu.TypeReleaseRQ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00})
+
+	if _, err := assoc.StoreBatchPipelined(reqs); !errors.Is(err, ErrPeerReleased) {
+		t.Fatalf("StoreBatchPipelined() error = %v, want ErrPeerReleased", err)
+	}
+	if state := assoc.State(); state != StateReleased {
+		t.Errorf("State() = %s, want Released", state)
+	}
+}
+
+func TestStoreBatchPipelined_NotNegotiated(t *testing.T) {
+	mock := newMockConn()
+	conn := &firstReadConn{mockConn: mock}
+	assoc := newStoreTestAssociation(mock, types.ExplicitVRLittleEndian)
+	assoc.conn = conn
+	if got := assoc.MaxOperationsInvoked(); got != 1 {
+		t.Fatalf("MaxOperationsInvoked() = %d, want 1", got)
+	}
+
+	reqs := []*CStoreRequest{
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.1", MessageID: 1, Data: []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.2", MessageID: 1, Data: []byte{0x08, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	queueCStoreResponse(mock)
+	queueCStoreResponse(mock)
+
+	responses, err := assoc.StoreBatchPipelined(reqs)
+	if err != nil {
+		t.Fatalf("StoreBatchPipelined() error = %v", err)
+	}
+	if len(responses) != 2 || responses[0] == nil || responses[1] == nil {
+		t.Fatalf("StoreBatchPipelined() responses = %v, want two", responses)
+	}
+	if _, _, err := dimse.ReadAllDIMSE(bytes.NewBuffer(conn.writtenBeforeRead), 1); err != nil {
+		t.Fatalf("failed to decode C-STORE-RQ: %v", err)
+	}
+	if len(conn.writtenBeforeRead) == len(mock.writeBuf.Bytes()) {
+		t.Error("both C-STORE-RQs were written before the first response was read")
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/caio-sobreiro/dicomnet/client"
 	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
 	"github.com/caio-sobreiro/dicomnet/interfaces"
 	"github.com/caio-sobreiro/dicomnet/server"
 	"github.com/caio-sobreiro/dicomnet/types"
@@ -28,18 +29,32 @@ type DicomInstance struct {
 	SeriesUID      string
 	TransferSyntax string // Transfer syntax the data is stored in
 	Data           []byte
+
+	// Patient attributes returned by PATIENT-level queries
+	PatientID        string
+	PatientName      string
+	PatientBirthDate string
+	PatientSex       string
 }
 
+// Patient module attributes
+var (
+	patientNameTag      = dicom.Tag{Group: 0x0010, Element: 0x0010}
+	patientIDTag        = dicom.Tag{Group: 0x0010, Element: 0x0020}
+	patientBirthDateTag = dicom.Tag{Group: 0x0010, Element: 0x0030}
+	patientSexTag       = dicom.Tag{Group: 0x0010, Element: 0x0040}
+)
+
 type sampleHandler struct {
 	instances map[string]*DicomInstance // Key: SOPInstanceUID
 	mu        sync.RWMutex
 }
 
+// responseTransferSyntax answers in the request's transfer syntax; when it is
+// unknown, the empty string lets the responder apply the server's default
+// response transfer syntax
 func responseTransferSyntax(meta interfaces.MessageContext) string {
-	if meta.TransferSyntaxUID != "" {
-		return meta.TransferSyntaxUID
-	}
-	return dicom.TransferSyntaxExplicitVRLittleEndian
+	return meta.TransferSyntaxUID
 }
 
 func (s *sampleHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
@@ -105,33 +120,8 @@ func (s *sampleHandler) HandleDIMSEStreaming(ctx context.Context, msg *types.Mes
 }
 
 func (s *sampleHandler) handleCFindStreaming(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
-	slog.InfoContext(ctx, "Handling C-FIND request", "message_id", msg.MessageID)
+	slog.InfoContext(ctx, "Handling C-FIND request", "message_id", msg.MessageID, "relational", meta.RelationalQueries)
 
-	// Create mock study result
-	dataset := dicom.NewDataset()
-	dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
-	dataset.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0010}, dicom.VR_PN, "DOE^JOHN")
-	dataset.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "123456")
-	dataset.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3.4.5.6.7.8.1")
-	dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0020}, dicom.VR_DA, "20240101")
-	dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0030}, dicom.VR_TM, "120000")
-	dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0050}, dicom.VR_SH, "ACC123")
-	dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x1030}, dicom.VR_LO, "Test Study")
-
-	// Send PENDING response with the match
-	pendingResponse := &types.Message{
-		CommandField:              types.CFindRSP,
-		MessageIDBeingRespondedTo: msg.MessageID,
-		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
-		CommandDataSetType:        0x0000, // Dataset present
-		Status:                    types.StatusPending,
-	}
-	slog.InfoContext(ctx, "Sending C-FIND pending response with match", "message_id", msg.MessageID)
-	if err := responder.SendResponse(pendingResponse, dataset, responseTransferSyntax(meta)); err != nil {
-		return err
-	}
-
-	// Send final SUCCESS response with no dataset
 	finalResponse := &types.Message{
 		CommandField:              types.CFindRSP,
 		MessageIDBeingRespondedTo: msg.MessageID,
@@ -139,7 +129,59 @@ func (s *sampleHandler) handleCFindStreaming(ctx context.Context, msg *types.Mes
 		CommandDataSetType:        0x0101, // No dataset
 		Status:                    types.StatusSuccess,
 	}
-	slog.InfoContext(ctx, "Sending C-FIND final success response", "message_id", msg.MessageID)
+
+	query := meta.Dataset
+	if query == nil {
+		var err error
+		query, err = dicom.ParseDatasetWithTransferSyntax(data, meta.TransferSyntaxUID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to parse C-FIND identifier", "error", err)
+			finalResponse.Status = types.StatusFailure
+			return responder.SendResponse(finalResponse, nil, responseTransferSyntax(meta))
+		}
+	}
+
+	keys := queryKeysFromDataset(query)
+	level, ok := keys.scope(meta.RelationalQueries)
+	if !ok {
+		slog.WarnContext(ctx, "C-FIND identifier has no usable Query/Retrieve Level",
+			"level", keys.level,
+			"relational", meta.RelationalQueries)
+		finalResponse.Status = types.StatusFailure
+		return responder.SendResponse(finalResponse, nil, responseTransferSyntax(meta))
+	}
+	if !types.IsValidQueryLevel(msg.AffectedSOPClassUID, types.QueryLevel(level)) {
+		// e.g. SERIES or IMAGE on the Patient/Study Only model
+		slog.WarnContext(ctx, "C-FIND level not in the information model",
+			"level", level,
+			"sop_class", msg.AffectedSOPClassUID)
+		finalResponse.Status = dimse.StatusDataSetDoesNotMatch
+		finalResponse.ErrorComment = "Query/Retrieve Level " + level + " is not valid for the information model"
+		return responder.SendResponse(finalResponse, nil, responseTransferSyntax(meta))
+	}
+
+	// One response per distinct entity at the query level
+	seen := make(map[string]bool)
+	for _, instance := range s.findMatchingInstances(keys, meta.RelationalQueries) {
+		key := instance.keyAt(level)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		pendingResponse := &types.Message{
+			CommandField:              types.CFindRSP,
+			MessageIDBeingRespondedTo: msg.MessageID,
+			AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+			CommandDataSetType:        0x0000, // Dataset present
+			Status:                    types.StatusPending,
+		}
+		if err := responder.SendResponse(pendingResponse, instance.identifier(level), responseTransferSyntax(meta)); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "Sending C-FIND final success response", "message_id", msg.MessageID, "matches", len(seen))
 	return responder.SendResponse(finalResponse, nil, responseTransferSyntax(meta))
 }
 
@@ -159,12 +201,16 @@ func (s *sampleHandler) handleCMoveStreaming(ctx context.Context, msg *types.Mes
 
 	logCMoveRequest(ctx, msg, dataset)
 
-	// Find matching instances
-	studyUID := dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000D})
-	seriesUID := dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000E})
-	sopUID := dataset.GetString(dicom.Tag{Group: 0x0008, Element: 0x0018})
+	// The server resolves the destination from its AE table
+	address := meta.MoveDestinationAddress
+	if address == "" {
+		slog.WarnContext(ctx, "C-MOVE destination not in AE table", "move_destination", msg.MoveDestination)
+		failure := buildMoveResponse(msg, types.StatusMoveDestinationUnknown, 0, 0, 0, 0)
+		return responder.SendResponse(failure, nil, responseTransferSyntax(meta))
+	}
 
-	matchingInstances := s.findMatchingInstances(studyUID, seriesUID, sopUID)
+	// Find matching instances
+	matchingInstances := s.findMatchingInstances(queryKeysFromDataset(dataset), meta.RelationalQueries)
 	totalInstances := len(matchingInstances)
 
 	slog.InfoContext(ctx, "Found matching instances", "count", totalInstances)
@@ -190,7 +236,7 @@ func (s *sampleHandler) handleCMoveStreaming(ctx context.Context, msg *types.Mes
 		}
 
 		// Perform C-STORE to move destination
-		err := s.performCStore(ctx, msg.MoveDestination, instance)
+		err := s.performCStore(ctx, msg.MoveDestination, address, instance)
 		if err != nil {
 			slog.ErrorContext(ctx, "C-STORE sub-operation failed", "error", err, "sop_instance", instance.SOPInstanceUID)
 			failed++
@@ -222,11 +268,7 @@ func (s *sampleHandler) handleCGetStreaming(ctx context.Context, msg *types.Mess
 	logCGetRequest(ctx, msg, dataset)
 
 	// Find matching instances
-	studyUID := dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000D})
-	seriesUID := dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000E})
-	sopUID := dataset.GetString(dicom.Tag{Group: 0x0008, Element: 0x0018})
-
-	matchingInstances := s.findMatchingInstances(studyUID, seriesUID, sopUID)
+	matchingInstances := s.findMatchingInstances(queryKeysFromDataset(dataset), meta.RelationalQueries)
 	totalInstances := len(matchingInstances)
 
 	slog.InfoContext(ctx, "Found matching instances", "count", totalInstances)
@@ -250,24 +292,47 @@ func (s *sampleHandler) handleCGetStreaming(ctx context.Context, msg *types.Mess
 	failed := uint16(0)
 	warning := uint16(0)
 
-	for i, instance := range matchingInstances {
-		remaining := uint16(totalInstances - i)
+	// Responders reporting sub-operation statuses let warnings be counted;
+	// responders that cannot transcode need instances in the context's
+	// transfer syntax already
+	statusResponder, _ := responder.(interfaces.CGetStatusResponder)
+	transcodingResponder, _ := responder.(interfaces.CGetTranscodingResponder)
 
-		// Send pending status before each transfer
-		pending := buildGetResponse(msg, types.StatusPending, remaining, completed, failed, warning)
-		if err := responder.SendResponse(pending, nil, responseTransferSyntax(meta)); err != nil {
-			return err
+	for i, instance := range matchingInstances {
+		// Perform C-STORE on the same association, converted to the storage
+		// context's transfer syntax; it returns once the C-STORE-RSP has been read
+		status := uint16(types.StatusSuccess)
+		var err error
+		switch {
+		case statusResponder != nil:
+			status, err = statusResponder.SendCStoreStatus(instance.SOPClassUID, instance.SOPInstanceUID, instance.Data, instance.TransferSyntax)
+		case transcodingResponder != nil:
+			err = transcodingResponder.SendCStoreAs(instance.SOPClassUID, instance.SOPInstanceUID, instance.Data, instance.TransferSyntax)
+		default:
+			err = cgetResponder.SendCStore(instance.SOPClassUID, instance.SOPInstanceUID, instance.Data)
 		}
-
-		// Perform C-STORE on the same association
-		err := cgetResponder.SendCStore(instance.SOPClassUID, instance.SOPInstanceUID, instance.Data)
-		if err != nil {
+		switch {
+		case err != nil:
 			slog.ErrorContext(ctx, "C-STORE sub-operation failed", "error", err, "sop_instance", instance.SOPInstanceUID)
 			failed++
-		} else {
+		case status != types.StatusSuccess:
+			slog.WarnContext(ctx, "C-STORE sub-operation completed with a warning", "status", fmt.Sprintf("0x%04x", status), "sop_instance", instance.SOPInstanceUID)
+			warning++
+		default:
 			slog.InfoContext(ctx, "C-STORE sub-operation successful", "sop_instance", instance.SOPInstanceUID)
 			completed++
 		}
+
+		// Report the outcome with a pending status; the final response
+		// covers the last sub-operation
+		remaining := uint16(totalInstances - i - 1)
+		if remaining == 0 {
+			break
+		}
+		pending := buildGetResponse(msg, types.StatusPending, remaining, completed, failed, warning)
+		if err := responder.SendResponse(pending, nil, responseTransferSyntax(meta)); err != nil {
+			return err
+		}
 	}
 
 	// Send final success response
@@ -275,34 +340,133 @@ func (s *sampleHandler) handleCGetStreaming(ctx context.Context, msg *types.Mess
 	return responder.SendResponse(final, nil, responseTransferSyntax(meta))
 }
 
-func (s *sampleHandler) findMatchingInstances(studyUID, seriesUID, sopUID string) []*DicomInstance {
+// queryKeys holds the Query/Retrieve Level and unique keys of an identifier,
+// and the Patient's Name, which PATIENT-level queries may match with wildcards
+type queryKeys struct {
+	level       string
+	patientID   string
+	patientName string
+	studyUID    string
+	seriesUID   string
+	sopUID      string
+}
+
+func queryKeysFromDataset(dataset *dicom.Dataset) queryKeys {
+	return queryKeys{
+		level:       strings.ToUpper(dataset.GetString(dicom.Tag{Group: 0x0008, Element: 0x0052})),
+		patientID:   dataset.GetString(patientIDTag),
+		patientName: dataset.GetString(patientNameTag),
+		studyUID:    dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000D}),
+		seriesUID:   dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000E}),
+		sopUID:      dataset.GetString(dicom.Tag{Group: 0x0008, Element: 0x0018}),
+	}
+}
+
+// scope returns the level the identifier applies to. Hierarchical queries
+// must state the level; when relational queries were negotiated a missing
+// level is inferred from the most specific unique key present.
+func (q queryKeys) scope(relational bool) (string, bool) {
+	switch types.QueryLevel(q.level) {
+	case types.QueryLevelPatient, types.QueryLevelStudy, types.QueryLevelSeries, types.QueryLevelImage:
+		return q.level, true
+	case "":
+		if !relational {
+			return "", false
+		}
+		switch {
+		case q.sopUID != "":
+			return string(types.QueryLevelImage), true
+		case q.seriesUID != "":
+			return string(types.QueryLevelSeries), true
+		case q.studyUID != "":
+			return string(types.QueryLevelStudy), true
+		case q.patientID != "":
+			return string(types.QueryLevelPatient), true
+		}
+	}
+	return "", false
+}
+
+func (s *sampleHandler) findMatchingInstances(keys queryKeys, relational bool) []*DicomInstance {
+	level, ok := keys.scope(relational)
+	if !ok {
+		return nil
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	depth := levelDepth[types.QueryLevel(level)]
+
 	var matches []*DicomInstance
 	for _, instance := range s.instances {
-		// Match based on query level
-		if sopUID != "" {
-			// Instance level query
-			if instance.SOPInstanceUID == sopUID {
-				matches = append(matches, instance)
-			}
-		} else if seriesUID != "" {
-			// Series level query
-			if instance.SeriesUID == seriesUID {
-				matches = append(matches, instance)
-			}
-		} else if studyUID != "" {
-			// Study level query
-			if instance.StudyUID == studyUID {
-				matches = append(matches, instance)
-			}
+		// Empty keys are universal matches; keys at or above the level must
+		// agree, and keys below it are ignored
+		if keys.patientID != "" && !dicom.MatchWildcard(keys.patientID, instance.PatientID) {
+			continue
+		}
+		if keys.patientName != "" && !dicom.MatchWildcard(keys.patientName, instance.PatientName) {
+			continue
 		}
+		if depth >= 1 && keys.studyUID != "" && instance.StudyUID != keys.studyUID {
+			continue
+		}
+		if depth >= 2 && keys.seriesUID != "" && instance.SeriesUID != keys.seriesUID {
+			continue
+		}
+		if depth >= 3 && keys.sopUID != "" && instance.SOPInstanceUID != keys.sopUID {
+			continue
+		}
+		matches = append(matches, instance)
 	}
 	return matches
 }
 
-func (s *sampleHandler) performCStore(ctx context.Context, destination string, instance *DicomInstance) error {
+// levelDepth orders the Query/Retrieve Levels from PATIENT down
+var levelDepth = map[types.QueryLevel]int{
+	types.QueryLevelPatient: 0,
+	types.QueryLevelStudy:   1,
+	types.QueryLevelSeries:  2,
+	types.QueryLevelImage:   3,
+}
+
+// keyAt returns the unique key identifying the instance's entity at level
+func (i *DicomInstance) keyAt(level string) string {
+	switch types.QueryLevel(level) {
+	case types.QueryLevelPatient:
+		return i.PatientID
+	case types.QueryLevelStudy:
+		return i.StudyUID
+	case types.QueryLevelSeries:
+		return i.SeriesUID
+	default:
+		return i.SOPInstanceUID
+	}
+}
+
+// identifier builds a C-FIND response identifier for the instance at level
+func (i *DicomInstance) identifier(level string) *dicom.Dataset {
+	dataset := dicom.NewDataset()
+	dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, level)
+	if level == string(types.QueryLevelPatient) {
+		dataset.AddElement(patientIDTag, dicom.VR_LO, i.PatientID)
+		dataset.AddElement(patientNameTag, dicom.VR_PN, i.PatientName)
+		dataset.AddElement(patientBirthDateTag, dicom.VR_DA, i.PatientBirthDate)
+		dataset.AddElement(patientSexTag, dicom.VR_CS, i.PatientSex)
+		return dataset
+	}
+	dataset.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, i.StudyUID)
+	if level != string(types.QueryLevelStudy) {
+		dataset.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000E}, dicom.VR_UI, i.SeriesUID)
+	}
+	if level == string(types.QueryLevelImage) {
+		dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0016}, dicom.VR_UI, i.SOPClassUID)
+		dataset.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, i.SOPInstanceUID)
+	}
+	return dataset
+}
+
+func (s *sampleHandler) performCStore(ctx context.Context, destination, address string, instance *DicomInstance) error {
 	// Create client connection to move destination
 	// Propose transfer syntaxes with the instance's native transfer syntax first
 	config := client.Config{
@@ -312,7 +476,7 @@ func (s *sampleHandler) performCStore(ctx context.Context, destination string, i
 		PreferredTransferSyntaxes: s.buildTransferSyntaxList(instance.TransferSyntax),
 	}
 
-	assoc, err := client.Connect("orthanc:4242", config)
+	assoc, err := client.Connect(address, config)
 	if err != nil {
 		return fmt.Errorf("failed to connect to destination: %w", err)
 	}
@@ -324,6 +488,7 @@ func (s *sampleHandler) performCStore(ctx context.Context, destination string, i
 		SOPInstanceUID: instance.SOPInstanceUID,
 		Data:           instance.Data,
 		MessageID:      1,
+		TransferSyntax: instance.TransferSyntax,
 	}
 
 	resp, err := assoc.SendCStore(storeReq)
@@ -379,57 +544,47 @@ func (s *sampleHandler) handleCMove(ctx context.Context, msg *types.Message, dat
 	return response, nil, nil
 }
 
-func (s *sampleHandler) loadDicomFile(filepath string) error {
+// loadDicomFile loads a Part 10 file or a raw dataset. Raw datasets carry no
+// File Meta Information, so rawTransferSyntax must say how they are encoded.
+func (s *sampleHandler) loadDicomFile(filepath string, rawTransferSyntax string) error {
 	data, err := os.ReadFile(filepath)
 	if err != nil {
 		return fmt.Errorf("failed to read DICOM file: %w", err)
 	}
 
-	// Parse DICOM file to extract metadata
-	// Skip the 128-byte preamble and "DICM" prefix
-	if len(data) < 132 {
-		return fmt.Errorf("file too small to be valid DICOM")
-	}
-	if string(data[128:132]) != "DICM" {
-		return fmt.Errorf("missing DICM prefix")
+	datasetBytes := data
+	transferSyntax := rawTransferSyntax
+	if dicom.HasPart10Header(data) {
+		var fileTransferSyntax string
+		datasetBytes, fileTransferSyntax, err = dicom.SplitPart10(data)
+		if err != nil {
+			return fmt.Errorf("failed to read Part 10 file: %w", err)
+		}
+		transferSyntax = fileTransferSyntax
+		if transferSyntax == "" {
+			transferSyntax = types.ExplicitVRLittleEndian // Part 10 meta information default
+		}
+	} else if transferSyntax == "" {
+		return fmt.Errorf("%s has no Part 10 header; a transfer syntax must be supplied for raw datasets", filepath)
 	}
 
-	// Parse the dataset starting after preamble
-	dataset, err := dicom.ParseDataset(data[132:])
+	dataset, err := dicom.ParseDatasetWithTransferSyntax(datasetBytes, transferSyntax)
 	if err != nil {
 		return fmt.Errorf("failed to parse DICOM dataset: %w", err)
 	}
 
-	// Extract Transfer Syntax UID (0002,0010) from the file meta information
-	// This is in the first part of the file (before the dataset)
-	transferSyntax := types.ExplicitVRLittleEndian // Default to Explicit VR Little Endian
-	if len(data) > 132 {
-		// Try to find transfer syntax in meta information (group 0x0002)
-		// For now, we'll use a simple approach - in production you'd parse the meta info properly
-		tsTag := []byte{0x02, 0x00, 0x10, 0x00} // (0002,0010) Transfer Syntax UID
-		for i := 132; i < len(data)-20 && i < 300; i++ {
-			if data[i] == tsTag[0] && data[i+1] == tsTag[1] &&
-				data[i+2] == tsTag[2] && data[i+3] == tsTag[3] {
-				// Found transfer syntax tag, read the value
-				vr := string(data[i+4 : i+6])
-				if vr == "UI" {
-					length := binary.LittleEndian.Uint16(data[i+6 : i+8])
-					if i+8+int(length) <= len(data) {
-						transferSyntax = strings.TrimRight(string(data[i+8:i+8+int(length)]), "\x00 ")
-						break
-					}
-				}
-			}
-		}
-	}
-
 	instance := &DicomInstance{
 		SOPClassUID:    dataset.GetString(dicom.Tag{Group: 0x0008, Element: 0x0016}),
 		SOPInstanceUID: dataset.GetString(dicom.Tag{Group: 0x0008, Element: 0x0018}),
 		StudyUID:       dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000D}),
 		SeriesUID:      dataset.GetString(dicom.Tag{Group: 0x0020, Element: 0x000E}),
 		TransferSyntax: transferSyntax,
-		Data:           data[132:], // Store only the dataset, not the preamble
+		Data:           datasetBytes, // Store only the dataset, not the Part 10 header
+
+		PatientID:        dataset.GetString(patientIDTag),
+		PatientName:      dataset.GetString(patientNameTag),
+		PatientBirthDate: dataset.GetString(patientBirthDateTag),
+		PatientSex:       dataset.GetString(patientSexTag),
 	}
 
 	s.mu.Lock()
@@ -442,7 +597,7 @@ func (s *sampleHandler) loadDicomFile(filepath string) error {
 		"study_uid", instance.StudyUID,
 		"series_uid", instance.SeriesUID,
 		"transfer_syntax", instance.TransferSyntax,
-		"size_bytes", len(data))
+		"size_bytes", len(datasetBytes))
 
 	return nil
 }
@@ -519,6 +674,8 @@ func (s *sampleHandler) generateSyntheticInstance(sopInstanceUID, studyUID, seri
 		SeriesUID:      seriesUID,
 		TransferSyntax: types.ImplicitVRLittleEndian, // Implicit VR Little Endian
 		Data:           buf,
+		PatientID:      "12345",
+		PatientName:    "TEST^PATIENT",
 	}
 
 	s.mu.Lock()
@@ -540,6 +697,8 @@ func main() {
 	port := flag.Int("port", 4242, "TCP port to listen on")
 	aeTitle := flag.String("ae", "SAMPLE_SCP", "Server AE Title")
 	dicomFile := flag.String("dicom", "sample.dcm", "Path to sample DICOM file (optional)")
+	rawTransferSyntax := flag.String("transfer-syntax", "", "Transfer syntax UID of --dicom when it is a raw dataset without a Part 10 header")
+	aeTable := flag.String("ae-table", "ORTHANC=orthanc:4242", "Comma-separated AE=host:port entries for C-MOVE destinations")
 	generateSynthetic := flag.Bool("synthetic", false, "Generate synthetic DICOM instances instead of loading from file")
 	flag.Parse()
 
@@ -569,7 +728,7 @@ func main() {
 		}
 	} else if *dicomFile != "" {
 		// Load from file
-		if err := handler.loadDicomFile(*dicomFile); err != nil {
+		if err := handler.loadDicomFile(*dicomFile, *rawTransferSyntax); err != nil {
 			logger.Error("Failed to load DICOM file", "error", err, "file", *dicomFile)
 			os.Exit(1)
 		}
@@ -578,9 +737,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	destinations, err := parseAETable(*aeTable)
+	if err != nil {
+		logger.Error("Invalid AE table", "error", err)
+		os.Exit(1)
+	}
+
 	address := fmt.Sprintf(":%d", *port)
 
-	err := server.ListenAndServe(ctx, address, *aeTitle, handler, server.WithLogger(logger))
+	err = server.ListenAndServe(ctx, address, *aeTitle, handler,
+		server.WithLogger(logger),
+		server.WithRelationalQueries(),
+		server.WithAETable(destinations))
 	switch {
 	case err == nil:
 		logger.Info("Sample server shutdown complete")
@@ -592,6 +760,24 @@ func main() {
 	}
 }
 
+// parseAETable parses comma-separated AE=host:port entries
+func parseAETable(spec string) (map[string]string, error) {
+	table := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		aeTitle, address, ok := strings.Cut(entry, "=")
+		aeTitle = strings.TrimSpace(aeTitle)
+		if !ok || aeTitle == "" || address == "" {
+			return nil, fmt.Errorf("invalid AE table entry %q (want AE=host:port)", entry)
+		}
+		table[aeTitle] = strings.TrimSpace(address)
+	}
+	return table, nil
+}
+
 func buildMoveResponse(req *types.Message, status uint16, remaining, completed, failed, warning uint16) *types.Message {
 	// Helper to create uint16 pointers
 	uint16Ptr := func(v uint16) *uint16 { return &v }
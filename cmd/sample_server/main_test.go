@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/server"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func buildTestDataset(sopInstanceUID string) *dicom.Dataset {
+	ds := dicom.NewDataset()
+	ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0016}, dicom.VR_UI, types.CTImageStorage)
+	ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, sopInstanceUID)
+	ds.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3.1")
+	return ds
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func newTestHandler() *sampleHandler {
+	return &sampleHandler{instances: make(map[string]*DicomInstance)}
+}
+
+func TestLoadDicomFile_Part10(t *testing.T) {
+	datasetBytes, _ := dicom.EncodeDatasetWithTransferSyntax(buildTestDataset("1.2.3.4"), types.ImplicitVRLittleEndian)
+
+	meta := dicom.NewDataset()
+	meta.AddElement(dicom.Tag{Group: 0x0002, Element: 0x0010}, dicom.VR_UI, types.ImplicitVRLittleEndian)
+	file := append(make([]byte, 128), []byte("DICM")...)
+	file = append(file, meta.EncodeDataset()...)
+	file = append(file, datasetBytes...)
+
+	handler := newTestHandler()
+	// The raw transfer syntax is ignored when the file carries its own
+	if err := handler.loadDicomFile(writeTestFile(t, "part10.dcm", file), types.ExplicitVRLittleEndian); err != nil {
+		t.Fatalf("loadDicomFile() error = %v", err)
+	}
+
+	instance, ok := handler.instances["1.2.3.4"]
+	if !ok {
+		t.Fatal("Instance not loaded")
+	}
+	if instance.TransferSyntax != types.ImplicitVRLittleEndian {
+		t.Errorf("TransferSyntax = %s, want Implicit VR Little Endian", instance.TransferSyntax)
+	}
+	if instance.SOPClassUID != types.CTImageStorage {
+		t.Errorf("SOPClassUID = %s, want CT Image Storage", instance.SOPClassUID)
+	}
+	if !bytes.Equal(instance.Data, datasetBytes) {
+		t.Error("Stored data should be the dataset without the Part 10 header")
+	}
+}
+
+func TestLoadDicomFile_RawDataset(t *testing.T) {
+	datasetBytes := buildTestDataset("1.2.3.5").EncodeDataset()
+	path := writeTestFile(t, "raw.dcm", datasetBytes)
+
+	handler := newTestHandler()
+	if err := handler.loadDicomFile(path, ""); err == nil {
+		t.Fatal("Expected error loading a raw dataset without a transfer syntax")
+	}
+
+	if err := handler.loadDicomFile(path, types.ExplicitVRLittleEndian); err != nil {
+		t.Fatalf("loadDicomFile() error = %v", err)
+	}
+
+	instance, ok := handler.instances["1.2.3.5"]
+	if !ok {
+		t.Fatal("Instance not loaded")
+	}
+	if instance.TransferSyntax != types.ExplicitVRLittleEndian {
+		t.Errorf("TransferSyntax = %s, want Explicit VR Little Endian", instance.TransferSyntax)
+	}
+	if instance.StudyUID != "1.2.3.1" {
+		t.Errorf("StudyUID = %s, want 1.2.3.1", instance.StudyUID)
+	}
+	if !bytes.Equal(instance.Data, datasetBytes) {
+		t.Error("Raw dataset should be stored unchanged")
+	}
+}
+
+// recordingResponder captures responses sent by a streaming handler
+type recordingResponder struct {
+	messages []*types.Message
+	datasets []*dicom.Dataset
+}
+
+func (r *recordingResponder) SendResponse(msg *types.Message, dataset *dicom.Dataset, transferSyntaxUID string) error {
+	r.messages = append(r.messages, msg)
+	r.datasets = append(r.datasets, dataset)
+	return nil
+}
+
+func newHierarchyHandler() *sampleHandler {
+	handler := newTestHandler()
+	for _, inst := range []*DicomInstance{
+		{SOPInstanceUID: "1.1.1.1", SeriesUID: "1.1.1", StudyUID: "1.1"},
+		{SOPInstanceUID: "1.1.1.2", SeriesUID: "1.1.1", StudyUID: "1.1"},
+		{SOPInstanceUID: "1.1.2.1", SeriesUID: "1.1.2", StudyUID: "1.1"},
+		{SOPInstanceUID: "2.1.1.1", SeriesUID: "2.1.1", StudyUID: "2.1"},
+	} {
+		handler.instances[inst.SOPInstanceUID] = inst
+	}
+	return handler
+}
+
+func TestFindMatchingInstances_RelationalWithoutLevel(t *testing.T) {
+	handler := newHierarchyHandler()
+	keys := queryKeys{seriesUID: "1.1.1"}
+
+	if matches := handler.findMatchingInstances(keys, false); len(matches) != 0 {
+		t.Errorf("Hierarchical query without level matched %d instances, want 0", len(matches))
+	}
+
+	matches := handler.findMatchingInstances(keys, true)
+	if len(matches) != 2 {
+		t.Fatalf("Relational query matched %d instances, want 2", len(matches))
+	}
+	for _, m := range matches {
+		if m.SeriesUID != "1.1.1" {
+			t.Errorf("Unexpected match in series %s", m.SeriesUID)
+		}
+	}
+}
+
+func TestHandleCFind_RelationalSeriesQuery(t *testing.T) {
+	handler := newHierarchyHandler()
+
+	query := dicom.NewDataset()
+	query.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000E}, dicom.VR_UI, "1.1.1")
+
+	msg := &types.Message{CommandField: types.CFindRQ, MessageID: 7}
+	responder := &recordingResponder{}
+	meta := interfaces.MessageContext{Dataset: query, RelationalQueries: true}
+
+	if err := handler.handleCFindStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+		t.Fatalf("handleCFindStreaming() error = %v", err)
+	}
+
+	// One series-level match followed by the final success
+	if len(responder.messages) != 2 {
+		t.Fatalf("Got %d responses, want 2", len(responder.messages))
+	}
+	if responder.messages[0].Status != types.StatusPending {
+		t.Errorf("First status = 0x%04X, want pending", responder.messages[0].Status)
+	}
+	match := responder.datasets[0]
+	if level := match.GetString(dicom.Tag{Group: 0x0008, Element: 0x0052}); level != "SERIES" {
+		t.Errorf("Match level = %q, want SERIES", level)
+	}
+	if series := match.GetString(dicom.Tag{Group: 0x0020, Element: 0x000E}); series != "1.1.1" {
+		t.Errorf("Match series = %q, want 1.1.1", series)
+	}
+	if responder.messages[1].Status != types.StatusSuccess {
+		t.Errorf("Final status = 0x%04X, want success", responder.messages[1].Status)
+	}
+
+	// Without relational negotiation the same identifier is refused
+	responder = &recordingResponder{}
+	meta.RelationalQueries = false
+	if err := handler.handleCFindStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+		t.Fatalf("handleCFindStreaming() error = %v", err)
+	}
+	if len(responder.messages) != 1 || responder.messages[0].Status != types.StatusFailure {
+		t.Errorf("Expected a single failure response without relational queries")
+	}
+}
+
+func TestHandleCFind_PatientLevel(t *testing.T) {
+	handler := newTestHandler()
+	for _, inst := range []*DicomInstance{
+		{SOPInstanceUID: "1.1.1.1", SeriesUID: "1.1.1", StudyUID: "1.1", PatientID: "P1", PatientName: "DOE^JOHN", PatientBirthDate: "19700101", PatientSex: "M"},
+		{SOPInstanceUID: "1.2.1.1", SeriesUID: "1.2.1", StudyUID: "1.2", PatientID: "P1", PatientName: "DOE^JOHN", PatientBirthDate: "19700101", PatientSex: "M"},
+		{SOPInstanceUID: "2.1.1.1", SeriesUID: "2.1.1", StudyUID: "2.1", PatientID: "P2", PatientName: "DOE^JANE", PatientSex: "F"},
+		{SOPInstanceUID: "3.1.1.1", SeriesUID: "3.1.1", StudyUID: "3.1", PatientID: "P3", PatientName: "ROE^RICHARD", PatientSex: "M"},
+	} {
+		handler.instances[inst.SOPInstanceUID] = inst
+	}
+
+	query := dicom.NewQuery("PATIENT", patientIDTag, patientBirthDateTag, patientSexTag)
+	query.AddElement(patientNameTag, dicom.VR_PN, "DOE^*")
+
+	msg := &types.Message{CommandField: types.CFindRQ, MessageID: 7, AffectedSOPClassUID: types.PatientRootQueryRetrieveInformationModelFind}
+	responder := &recordingResponder{}
+	meta := interfaces.MessageContext{Dataset: query}
+	if err := handler.handleCFindStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+		t.Fatalf("handleCFindStreaming() error = %v", err)
+	}
+
+	// One match per patient, not per study or instance
+	if len(responder.messages) != 3 {
+		t.Fatalf("Got %d responses, want 2 matches and the final response", len(responder.messages))
+	}
+	matches := map[string]*dicom.Dataset{}
+	for _, match := range responder.datasets[:2] {
+		matches[match.GetString(patientIDTag)] = match
+	}
+	john, jane := matches["P1"], matches["P2"]
+	if john == nil || jane == nil {
+		t.Fatalf("Matched patients %v, want P1 and P2", matches)
+	}
+	if got := john.GetString(patientNameTag); got != "DOE^JOHN" {
+		t.Errorf("Patient's Name = %q, want DOE^JOHN", got)
+	}
+	if got := john.GetString(patientBirthDateTag); got != "19700101" {
+		t.Errorf("Patient's Birth Date = %q, want 19700101", got)
+	}
+	if got := jane.GetString(patientSexTag); got != "F" {
+		t.Errorf("Patient's Sex = %q, want F", got)
+	}
+	if _, ok := john.GetElement(dicom.Tag{Group: 0x0020, Element: 0x000D}); ok {
+		t.Error("PATIENT-level match carries a Study Instance UID")
+	}
+	if responder.messages[2].Status != types.StatusSuccess {
+		t.Errorf("Final status = 0x%04X, want success", responder.messages[2].Status)
+	}
+}
+
+func TestHandleCFind_PatientStudyOnlyLevels(t *testing.T) {
+	handler := newTestHandler()
+	handler.instances["1.1.1.1"] = &DicomInstance{SOPInstanceUID: "1.1.1.1", SeriesUID: "1.1.1", StudyUID: "1.1", PatientID: "P1", PatientName: "DOE^JOHN"}
+
+	msg := &types.Message{CommandField: types.CFindRQ, MessageID: 7, AffectedSOPClassUID: types.PatientStudyOnlyQueryRetrieveInformationModelFind}
+
+	// The model has no SERIES level
+	responder := &recordingResponder{}
+	meta := interfaces.MessageContext{Dataset: dicom.NewQuery("SERIES", dicom.Tag{Group: 0x0020, Element: 0x000E})}
+	if err := handler.handleCFindStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+		t.Fatalf("handleCFindStreaming() error = %v", err)
+	}
+	if len(responder.messages) != 1 || responder.messages[0].Status != dimse.StatusDataSetDoesNotMatch {
+		t.Fatalf("SERIES-level query got %d responses, want a single 0xA900 failure", len(responder.messages))
+	}
+	if responder.datasets[0] != nil {
+		t.Error("SERIES-level failure carries an identifier")
+	}
+
+	responder = &recordingResponder{}
+	meta = interfaces.MessageContext{Dataset: dicom.NewQuery("STUDY", patientIDTag, dicom.Tag{Group: 0x0020, Element: 0x000D})}
+	if err := handler.handleCFindStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+		t.Fatalf("handleCFindStreaming() error = %v", err)
+	}
+	if len(responder.messages) != 2 {
+		t.Fatalf("STUDY-level query got %d responses, want a match and the final response", len(responder.messages))
+	}
+	if got := responder.datasets[0].GetString(dicom.Tag{Group: 0x0020, Element: 0x000D}); got != "1.1" {
+		t.Errorf("Match Study Instance UID = %q, want 1.1", got)
+	}
+	if responder.messages[1].Status != types.StatusSuccess {
+		t.Errorf("Final status = 0x%04X, want success", responder.messages[1].Status)
+	}
+}
+
+// storeRecorder is a minimal storage SCP that records received SOP classes
+type storeRecorder struct {
+	mu         sync.Mutex
+	sopClasses []string
+}
+
+func (r *storeRecorder) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	r.mu.Lock()
+	r.sopClasses = append(r.sopClasses, msg.AffectedSOPClassUID)
+	r.mu.Unlock()
+
+	return &types.Message{
+		CommandField:              types.ResponseCommandFor(msg.CommandField),
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+		AffectedSOPInstanceUID:    msg.AffectedSOPInstanceUID,
+		CommandDataSetType:        0x0101,
+		Status:                    types.StatusSuccess,
+	}, nil, nil
+}
+
+func newMoveRequest(destination string) (*types.Message, interfaces.MessageContext) {
+	identifier := dicom.NewDataset()
+	identifier.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	identifier.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3.1")
+
+	msg := &types.Message{
+		CommandField:        types.CMoveRQ,
+		MessageID:           9,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelMove,
+		MoveDestination:     destination,
+	}
+	return msg, interfaces.MessageContext{
+		TransferSyntaxUID: types.ImplicitVRLittleEndian,
+		Dataset:           identifier,
+	}
+}
+
+func TestHandleCMove_Destinations(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	recorder := &storeRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.New("STORE_SCP", recorder, server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))).Serve(ctx, listener)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	table, err := parseAETable("STORE_SCP=" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("parseAETable() error = %v", err)
+	}
+
+	datasetBytes, _ := dicom.EncodeDatasetWithTransferSyntax(buildTestDataset("1.2.3.4"), types.ImplicitVRLittleEndian)
+	handler := newTestHandler()
+	handler.instances["1.2.3.4"] = &DicomInstance{
+		SOPClassUID:    types.CTImageStorage,
+		SOPInstanceUID: "1.2.3.4",
+		StudyUID:       "1.2.3.1",
+		TransferSyntax: types.ImplicitVRLittleEndian,
+		Data:           datasetBytes,
+	}
+
+	t.Run("Known destination", func(t *testing.T) {
+		msg, meta := newMoveRequest("STORE_SCP")
+		meta.MoveDestinationAddress = table[msg.MoveDestination]
+
+		responder := &recordingResponder{}
+		if err := handler.handleCMoveStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+			t.Fatalf("handleCMoveStreaming() error = %v", err)
+		}
+
+		final := responder.messages[len(responder.messages)-1]
+		if final.Status != types.StatusSuccess || *final.NumberOfCompletedSuboperations != 1 {
+			t.Errorf("Final response status 0x%04X with %d completed, want success with 1",
+				final.Status, *final.NumberOfCompletedSuboperations)
+		}
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		if len(recorder.sopClasses) != 1 || recorder.sopClasses[0] != types.CTImageStorage {
+			t.Errorf("Destination received %q, want one CT Image Storage instance", recorder.sopClasses)
+		}
+	})
+
+	t.Run("Unknown destination", func(t *testing.T) {
+		msg, meta := newMoveRequest("NOWHERE")
+		meta.MoveDestinationAddress = table[msg.MoveDestination]
+
+		responder := &recordingResponder{}
+		if err := handler.handleCMoveStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+			t.Fatalf("handleCMoveStreaming() error = %v", err)
+		}
+
+		if len(responder.messages) != 1 {
+			t.Fatalf("Got %d responses, want a single failure", len(responder.messages))
+		}
+		if status := responder.messages[0].Status; status != types.StatusMoveDestinationUnknown {
+			t.Errorf("Status = 0x%04X, want 0xA801", status)
+		}
+	})
+}
+
+func TestParseAETable(t *testing.T) {
+	table, err := parseAETable("ORTHANC=orthanc:4242, STORE_SCP = 10.0.0.5:104")
+	if err != nil {
+		t.Fatalf("parseAETable() error = %v", err)
+	}
+	if table["ORTHANC"] != "orthanc:4242" || table["STORE_SCP"] != "10.0.0.5:104" {
+		t.Errorf("parseAETable() = %v", table)
+	}
+
+	if _, err := parseAETable("ORTHANC"); err == nil {
+		t.Error("Expected error for entry without an address")
+	}
+}
+
+// getRecorder is a C-GET responder logging the order of sub-operations and
+// responses
+type getRecorder struct {
+	recordingResponder
+	events []string
+}
+
+func (r *getRecorder) SendResponse(msg *types.Message, dataset *dicom.Dataset, transferSyntaxUID string) error {
+	r.events = append(r.events, fmt.Sprintf("C-GET-RSP 0x%04X completed=%d", msg.Status, *msg.NumberOfCompletedSuboperations))
+	return r.recordingResponder.SendResponse(msg, dataset, transferSyntaxUID)
+}
+
+func (r *getRecorder) SendCStore(sopClassUID, sopInstanceUID string, data []byte) error {
+	// The real responder returns after reading the C-STORE-RSP
+	r.events = append(r.events, "C-STORE-RQ "+sopInstanceUID, "C-STORE-RSP "+sopInstanceUID)
+	return nil
+}
+
+func (r *getRecorder) SendCStoreAs(sopClassUID, sopInstanceUID string, data []byte, transferSyntax string) error {
+	return r.SendCStore(sopClassUID, sopInstanceUID, data)
+}
+
+func (r *getRecorder) NextMessageID() uint16 { return 1 }
+
+func TestHandleCGet_PendingFollowsSubOperation(t *testing.T) {
+	handler := newTestHandler()
+	for _, uid := range []string{"1.2.3.1", "1.2.3.2"} {
+		handler.instances[uid] = &DicomInstance{
+			SOPClassUID:    types.CTImageStorage,
+			SOPInstanceUID: uid,
+			StudyUID:       "1.2.3",
+		}
+	}
+
+	query := dicom.NewDataset()
+	query.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	query.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+	msg := &types.Message{
+		CommandField:        types.CGetRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+	}
+
+	responder := &getRecorder{}
+	if err := handler.handleCGetStreaming(context.Background(), msg, nil, interfaces.MessageContext{Dataset: query}, responder); err != nil {
+		t.Fatalf("handleCGetStreaming() error = %v", err)
+	}
+
+	if len(responder.events) != 6 {
+		t.Fatalf("Events = %q, want two sub-operations, one pending and a final response", responder.events)
+	}
+	first := responder.events[1][len("C-STORE-RSP "):]
+	second := responder.events[3][len("C-STORE-RQ "):]
+	want := []string{
+		"C-STORE-RQ " + first,
+		"C-STORE-RSP " + first,
+		"C-GET-RSP 0xFF00 completed=1",
+		"C-STORE-RQ " + second,
+		"C-STORE-RSP " + second,
+		"C-GET-RSP 0x0000 completed=2",
+	}
+	for i := range want {
+		if responder.events[i] != want[i] {
+			t.Errorf("Event %d = %q, want %q", i, responder.events[i], want[i])
+		}
+	}
+}
+
+// statusRecorder is a getRecorder reporting a fixed C-STORE-RSP status per
+// SOP Instance
+type statusRecorder struct {
+	getRecorder
+	statuses map[string]uint16
+}
+
+func (r *statusRecorder) SendCStoreStatus(sopClassUID, sopInstanceUID string, data []byte, transferSyntax string) (uint16, error) {
+	if err := r.SendCStore(sopClassUID, sopInstanceUID, data); err != nil {
+		return 0, err
+	}
+	return r.statuses[sopInstanceUID], nil
+}
+
+func TestHandleCGet_CountsWarnings(t *testing.T) {
+	handler := newTestHandler()
+	for _, uid := range []string{"1.2.3.1", "1.2.3.2"} {
+		handler.instances[uid] = &DicomInstance{
+			SOPClassUID:    types.CTImageStorage,
+			SOPInstanceUID: uid,
+			StudyUID:       "1.2.3",
+		}
+	}
+
+	query := dicom.NewDataset()
+	query.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	query.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+	msg := &types.Message{
+		CommandField:        types.CGetRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+	}
+
+	// Coercion of data elements
+	responder := &statusRecorder{statuses: map[string]uint16{"1.2.3.2": 0xB000}}
+	if err := handler.handleCGetStreaming(context.Background(), msg, nil, interfaces.MessageContext{Dataset: query}, responder); err != nil {
+		t.Fatalf("handleCGetStreaming() error = %v", err)
+	}
+
+	final := responder.messages[len(responder.messages)-1]
+	if *final.NumberOfCompletedSuboperations != 1 || *final.NumberOfWarningSuboperations != 1 || *final.NumberOfFailedSuboperations != 0 {
+		t.Errorf("Final counts completed=%d warning=%d failed=%d, want 1, 1 and 0",
+			*final.NumberOfCompletedSuboperations, *final.NumberOfWarningSuboperations, *final.NumberOfFailedSuboperations)
+	}
+}
@@ -0,0 +1,378 @@
+package dicom
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// codeElement is a character set an ISO 2022 escape sequence designates to
+// G0 (bytes 0x21-0x7E) or G1 (bytes 0xA1-0xFE)
+type codeElement int
+
+const (
+	noCodeElement   codeElement = iota
+	codeASCII                   // ISO 646 (ISO-IR 6), G0
+	codeJISRoman                // JIS X 0201 Romaji (ISO-IR 14), G0
+	codeJISKatakana             // JIS X 0201 Katakana (ISO-IR 13), G1
+	codeLatin1                  // ISO 8859-1 right-hand part (ISO-IR 100), G1
+	codeJISX0208                // JIS X 0208 Kanji (ISO-IR 87), G0, two bytes
+)
+
+// escapeSequences designate each code element (PS3.3 C.12.1.1.2)
+var escapeSequences = map[codeElement]string{
+	codeASCII:       "\x1b(B",
+	codeJISRoman:    "\x1b(J",
+	codeJISKatakana: "\x1b)I",
+	codeLatin1:      "\x1b-A",
+	codeJISX0208:    "\x1b$B",
+}
+
+// g1 reports whether the code element is designated to G1
+func (c codeElement) g1() bool {
+	return c == codeJISKatakana || c == codeLatin1
+}
+
+// codeExtensionTerms maps the supported Defined Terms of Specific Character
+// Set with code extensions to the G0 and G1 code elements they declare
+var codeExtensionTerms = map[string][2]codeElement{
+	"":                {codeASCII, noCodeElement},
+	"ISO 2022 IR 6":   {codeASCII, noCodeElement},
+	"ISO 2022 IR 13":  {codeJISRoman, codeJISKatakana},
+	"ISO 2022 IR 100": {codeASCII, codeLatin1},
+	"ISO 2022 IR 87":  {codeJISX0208, noCodeElement},
+}
+
+// codeExtensions is the state declared by a multi-valued Specific Character
+// Set: the code elements active at the start of each value, and every code
+// element escape sequences may switch to
+type codeExtensions struct {
+	initialG0, initialG1 codeElement
+	declared             []codeElement
+}
+
+// parseCodeExtensions reads the code extensions declared by the values of
+// Specific Character Set (0008,0005). It returns nil when the values do not
+// use ISO 2022 code extensions.
+func parseCodeExtensions(terms []string) (*codeExtensions, error) {
+	extended := false
+	for _, term := range terms {
+		extended = extended || strings.HasPrefix(term, "ISO 2022")
+	}
+	if !extended {
+		return nil, nil
+	}
+
+	var ext codeExtensions
+	for i, term := range terms {
+		elements, ok := codeExtensionTerms[term]
+		if !ok {
+			return nil, fmt.Errorf("specific character set %q is not supported", term)
+		}
+		if i == 0 {
+			ext.initialG0, ext.initialG1 = elements[0], elements[1]
+		}
+		for _, element := range elements {
+			if element != noCodeElement {
+				ext.declared = append(ext.declared, element)
+			}
+		}
+	}
+	return &ext, nil
+}
+
+// isDelimiter reports whether r ends a component or value of vr, before which
+// the initial code elements are restored (PS3.5 6.1.2.5.3)
+func isDelimiter(r rune, vr string) bool {
+	switch r {
+	case '\r', '\n', '\f', '\t':
+		return true
+	case '\\':
+		return vr != VR_LT && vr != VR_ST && vr != VR_UT
+	case '^', '=':
+		return vr == VR_PN
+	}
+	return false
+}
+
+// decode converts a value encoded with the code extensions to UTF-8
+func (ext *codeExtensions) decode(value, vr string) (string, error) {
+	var out strings.Builder
+	g0, g1 := ext.initialG0, ext.initialG1
+	for i := 0; i < len(value); {
+		b := value[i]
+		switch {
+		case b == 0x1b:
+			element, ok := ext.designation(value[i:])
+			if !ok {
+				return "", fmt.Errorf("unsupported escape sequence at offset %d", i)
+			}
+			if element.g1() {
+				g1 = element
+			} else {
+				g0 = element
+			}
+			i += len(escapeSequences[element])
+		case b >= 0x80:
+			r, ok := decodeG1(g1, b)
+			if !ok {
+				return "", fmt.Errorf("byte 0x%02x at offset %d is not in the G1 character set", b, i)
+			}
+			out.WriteRune(r)
+			i++
+		case g0 == codeJISX0208 && b > 0x20 && b < 0x7f:
+			if i+1 >= len(value) {
+				return "", fmt.Errorf("truncated JIS X 0208 character at offset %d", i)
+			}
+			r, ok := decodeJISX0208(b, value[i+1])
+			if !ok {
+				return "", fmt.Errorf("bytes 0x%02x%02x at offset %d are not in JIS X 0208", b, value[i+1], i)
+			}
+			out.WriteRune(r)
+			i += 2
+		default:
+			r := rune(b)
+			if g0 == codeJISRoman && b == 0x7e {
+				r = '‾' // Overline
+			}
+			out.WriteRune(r)
+			if isDelimiter(r, vr) {
+				g0, g1 = ext.initialG0, ext.initialG1
+			}
+			i++
+		}
+	}
+	return out.String(), nil
+}
+
+// designation returns the declared code element whose escape sequence starts s
+func (ext *codeExtensions) designation(s string) (codeElement, bool) {
+	for _, element := range ext.declared {
+		if strings.HasPrefix(s, escapeSequences[element]) {
+			return element, true
+		}
+	}
+	return noCodeElement, false
+}
+
+// encode converts a UTF-8 value to the code extensions, switching code
+// elements with escape sequences as needed and restoring the initial ones
+// before each delimiter and at the end of the value
+func (ext *codeExtensions) encode(value, vr string) (string, error) {
+	var out strings.Builder
+	g0, g1 := ext.initialG0, ext.initialG1
+	reset := func() {
+		if g0 != ext.initialG0 {
+			g0 = ext.initialG0
+			out.WriteString(escapeSequences[g0])
+		}
+		if g1 != ext.initialG1 && ext.initialG1 != noCodeElement {
+			g1 = ext.initialG1
+			out.WriteString(escapeSequences[g1])
+		}
+	}
+
+	for _, r := range value {
+		if isDelimiter(r, vr) {
+			reset()
+			out.WriteRune(r)
+			continue
+		}
+		if encoded, ok := encodeRune(g0, r); ok {
+			out.WriteString(encoded)
+			continue
+		}
+		if encoded, ok := encodeRune(g1, r); ok {
+			out.WriteString(encoded)
+			continue
+		}
+
+		element, encoded := noCodeElement, ""
+		for _, candidate := range ext.declared {
+			var ok bool
+			if encoded, ok = encodeRune(candidate, r); ok {
+				element = candidate
+				break
+			}
+		}
+		if element == noCodeElement {
+			return "", fmt.Errorf("character %q cannot be encoded in the specific character set", r)
+		}
+		if element.g1() {
+			g1 = element
+		} else {
+			g0 = element
+		}
+		out.WriteString(escapeSequences[element])
+		out.WriteString(encoded)
+	}
+	reset()
+	return out.String(), nil
+}
+
+// encodeRune returns the bytes of r in a code element
+func encodeRune(element codeElement, r rune) (string, bool) {
+	switch element {
+	case codeASCII:
+		if r < 0x80 {
+			return string(r), true
+		}
+	case codeJISRoman:
+		switch {
+		case r == '‾':
+			return "\x7e", true
+		case r < 0x80 && r != '~':
+			return string(r), true
+		}
+	case codeJISKatakana:
+		if r >= '｡' && r <= 'ﾟ' {
+			return string([]byte{byte(r - '｡' + 0xa1)}), true
+		}
+	case codeLatin1:
+		if r >= 0xa0 && r <= 0xff {
+			return string([]byte{byte(r)}), true
+		}
+	case codeJISX0208:
+		if code, ok := jisX0208Codes()[r]; ok {
+			return string([]byte{byte(code >> 8), byte(code)}), true
+		}
+	}
+	return "", false
+}
+
+// decodeG1 returns the character of byte b in a G1 code element
+func decodeG1(element codeElement, b byte) (rune, bool) {
+	switch element {
+	case codeJISKatakana:
+		if b >= 0xa1 && b <= 0xdf {
+			return rune(b-0xa1) + '｡', true
+		}
+	case codeLatin1:
+		if b >= 0xa0 {
+			return rune(b), true
+		}
+	}
+	return 0, false
+}
+
+var (
+	jisX0208Once    sync.Once
+	jisX0208Runes   [][]rune
+	jisX0208CodeMap map[rune]uint16
+)
+
+// loadJISX0208 expands jisX0208Rows into lookup tables in both directions
+func loadJISX0208() {
+	jisX0208Runes = make([][]rune, len(jisX0208Rows))
+	jisX0208CodeMap = make(map[rune]uint16, 7000)
+	for row, cells := range jisX0208Rows {
+		jisX0208Runes[row] = []rune(cells)
+		for cell, r := range jisX0208Runes[row] {
+			if r != utf8.RuneError {
+				jisX0208CodeMap[r] = uint16(row+0x21)<<8 | uint16(cell+0x21)
+			}
+		}
+	}
+}
+
+// jisX0208Codes maps characters to their two JIS X 0208 bytes
+func jisX0208Codes() map[rune]uint16 {
+	jisX0208Once.Do(loadJISX0208)
+	return jisX0208CodeMap
+}
+
+// decodeJISX0208 returns the character of a two-byte JIS X 0208 code
+func decodeJISX0208(b1, b2 byte) (rune, bool) {
+	jisX0208Once.Do(loadJISX0208)
+	row, cell := int(b1)-0x21, int(b2)-0x21
+	if row < 0 || row >= len(jisX0208Runes) || cell < 0 || cell >= len(jisX0208Runes[row]) {
+		return 0, false
+	}
+	r := jisX0208Runes[row][cell]
+	return r, r != utf8.RuneError
+}
+
+// DecodeCharacterSet returns a copy of dataset whose text values (SH, LO, ST,
+// LT, UC, UT and PN) are converted to UTF-8 from the ISO 2022 code extensions
+// declared by a multi-valued Specific Character Set (0008,0005), such as
+// "\ISO 2022 IR 87" for Japanese. Sequence items use their own Specific
+// Character Set, or their dataset's when they have none. Values of datasets
+// without code extensions are left as they are.
+//
+// The supported Defined Terms are ISO 2022 IR 6, IR 13, IR 87 and IR 100.
+func DecodeCharacterSet(dataset *Dataset) (*Dataset, error) {
+	return convertCharacterSet(dataset, nil, (*codeExtensions).decode)
+}
+
+// EncodeCharacterSet is the inverse of DecodeCharacterSet: it returns a copy
+// of dataset whose UTF-8 text values are encoded in the declared code
+// extensions, with escape sequences switching character sets and the
+// initial ones restored before each delimiter and at the end of each value.
+// A value with a character none of the declared sets contains is an error.
+func EncodeCharacterSet(dataset *Dataset) (*Dataset, error) {
+	return convertCharacterSet(dataset, nil, (*codeExtensions).encode)
+}
+
+// convertCharacterSet applies convert to the text values of dataset and its
+// sequence items, which inherit the enclosing code extensions
+func convertCharacterSet(dataset *Dataset, inherited *codeExtensions, convert func(*codeExtensions, string, string) (string, error)) (*Dataset, error) {
+	if dataset == nil {
+		return nil, nil
+	}
+	ext := inherited
+	if _, ok := dataset.GetElement(specificCharacterSetTag); ok {
+		var err error
+		if ext, err = parseCodeExtensions(dataset.GetStrings(specificCharacterSetTag)); err != nil {
+			return nil, err
+		}
+	}
+
+	converted := &Dataset{Elements: make(map[Tag]*Element, len(dataset.Elements))}
+	for tag, element := range dataset.Elements {
+		value, err := convertElementValue(element, ext, convert)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", tag, err)
+		}
+		if value == nil {
+			converted.Elements[tag] = element
+			continue
+		}
+		converted.Elements[tag] = &Element{Tag: element.Tag, VR: element.VR, Value: value}
+	}
+	return converted, nil
+}
+
+// convertElementValue returns an element's converted value, or nil when the
+// element is left as it is
+func convertElementValue(element *Element, ext *codeExtensions, convert func(*codeExtensions, string, string) (string, error)) (interface{}, error) {
+	switch v := element.Value.(type) {
+	case []*Dataset:
+		items := make([]*Dataset, len(v))
+		for i, item := range v {
+			var err error
+			if items[i], err = convertCharacterSet(item, ext, convert); err != nil {
+				return nil, fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		return items, nil
+	case string:
+		if ext == nil || !isCharacterSetVR(element.VR) {
+			return nil, nil
+		}
+		return convert(ext, v, element.VR)
+	case []string:
+		if ext == nil || !isCharacterSetVR(element.VR) {
+			return nil, nil
+		}
+		values := make([]string, len(v))
+		for i, value := range v {
+			var err error
+			if values[i], err = convert(ext, value, element.VR); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, nil
+}
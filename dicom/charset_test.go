@@ -0,0 +1,99 @@
+package dicom
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCharacterSet_JapaneseRoundTrip(t *testing.T) {
+	patientNameTag := Tag{0x0010, 0x0010}
+	tests := []struct {
+		name    string
+		charset string
+		raw     string // Patient's Name as encoded on the wire
+		want    string
+	}{
+		{
+			// PS3.5 H.3.1
+			name:    "ISO 2022 IR 87",
+			charset: `\ISO 2022 IR 87`,
+			raw: "Yamada^Tarou=\x1b$B;3ED\x1b(B^\x1b$BB@O:\x1b(B=" +
+				"\x1b$B$d$^$@\x1b(B^\x1b$B$?$m$&\x1b(B",
+			want: "Yamada^Tarou=山田^太郎=やまだ^たろう",
+		},
+		{
+			// PS3.5 H.3.2
+			name:    "ISO 2022 IR 13 and IR 87",
+			charset: `ISO 2022 IR 13\ISO 2022 IR 87`,
+			raw: "\xd4\xcf\xc0\xde^\xc0\xdb\xb3=\x1b$B;3ED\x1b(J^\x1b$BB@O:\x1b(J=" +
+				"\x1b$B$d$^$@\x1b(J^\x1b$B$?$m$&\x1b(J",
+			want: "ﾔﾏﾀﾞ^ﾀﾛｳ=山田^太郎=やまだ^たろう",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := NewDataset()
+			original.AddElement(specificCharacterSetTag, VR_CS, tt.charset)
+			original.AddElement(patientNameTag, VR_PN, tt.raw)
+			data := original.EncodeDataset()
+
+			parsed, err := ParseDataset(data)
+			if err != nil {
+				t.Fatalf("ParseDataset() error = %v", err)
+			}
+			decoded, err := DecodeCharacterSet(parsed)
+			if err != nil {
+				t.Fatalf("DecodeCharacterSet() error = %v", err)
+			}
+			if got := decoded.GetString(patientNameTag); got != tt.want {
+				t.Errorf("Decoded Patient's Name = %q, want %q", got, tt.want)
+			}
+
+			encoded, err := EncodeCharacterSet(decoded)
+			if err != nil {
+				t.Fatalf("EncodeCharacterSet() error = %v", err)
+			}
+			if got := encoded.EncodeDataset(); !bytes.Equal(got, data) {
+				t.Errorf("Re-encoded dataset = %q, want %q", got, data)
+			}
+		})
+	}
+}
+
+func TestCharacterSet_SequenceItemsInherit(t *testing.T) {
+	nameTag := Tag{0x0040, 0xA123} // Person Name
+	item := NewDataset()
+	item.AddElement(nameTag, VR_PN, "山田^太郎")
+	dataset := NewDataset()
+	dataset.AddElement(specificCharacterSetTag, VR_CS, `\ISO 2022 IR 87`)
+	dataset.AddElement(Tag{0x0040, 0xA730}, VR_SQ, []*Dataset{item})
+
+	encoded, err := EncodeCharacterSet(dataset)
+	if err != nil {
+		t.Fatalf("EncodeCharacterSet() error = %v", err)
+	}
+	items := encoded.Elements[Tag{0x0040, 0xA730}].Value.([]*Dataset)
+	want := "\x1b$B;3ED\x1b(B^\x1b$BB@O:\x1b(B"
+	if got := items[0].GetString(nameTag); got != want {
+		t.Errorf("Encoded item name = %q, want %q", got, want)
+	}
+	if got := item.GetString(nameTag); got != "山田^太郎" {
+		t.Errorf("EncodeCharacterSet() modified the original item: %q", got)
+	}
+}
+
+func TestCharacterSet_Errors(t *testing.T) {
+	dataset := NewDataset()
+	dataset.AddElement(specificCharacterSetTag, VR_CS, `ISO 2022 IR 6\ISO 2022 IR 100`)
+	dataset.AddElement(Tag{0x0010, 0x0010}, VR_PN, "Müller^山田")
+	if _, err := EncodeCharacterSet(dataset); err == nil || !strings.Contains(err.Error(), "cannot be encoded") {
+		t.Errorf("EncodeCharacterSet() error = %v, want a character that cannot be encoded", err)
+	}
+
+	dataset.AddElement(specificCharacterSetTag, VR_CS, `\ISO 2022 IR 149`)
+	if _, err := DecodeCharacterSet(dataset); err == nil {
+		t.Error("DecodeCharacterSet() accepted an unsupported character set")
+	}
+}
@@ -1,8 +1,12 @@
 package dicom
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 
 	"github.com/caio-sobreiro/dicomnet/types"
@@ -154,14 +158,7 @@ func ParseDataset(data []byte) (*Dataset, error) {
 		var length uint32
 		var valueOffset int
 
-		// Determine if this is a short or long VR
-		// Short VRs: AE, AS, AT, CS, DA, DS, DT, FL, FD, IS, LO, LT, PN, SH, SL, SS, ST, TM, UI, UL, US
-		// Long VRs: OB, OD, OF, OL, OW, SQ, UC, UR, UT, UN, OV, SV, UV
-		isLongVR := vr == "OB" || vr == "OD" || vr == "OF" || vr == "OL" || vr == "OW" ||
-			vr == "SQ" || vr == "UC" || vr == "UR" || vr == "UT" || vr == "UN" ||
-			vr == "OV" || vr == "SV" || vr == "UV"
-
-		if isLongVR {
+		if isLongVR(vr) {
 			// Long VR: Tag (4) + VR (2) + Reserved (2) + Length (4) = 12 bytes header
 			if offset+12 > len(data) {
 				break
@@ -175,14 +172,34 @@ func ParseDataset(data []byte) (*Dataset, error) {
 			valueOffset = offset + 8
 		}
 
+		if tag == pixelDataTag && length == undefinedLength {
+			pixelData, next, err := parseEncapsulatedPixelData(data, valueOffset)
+			if err != nil {
+				return dataset, err
+			}
+			dataset.AddElement(tag, vr, pixelData)
+			offset = next
+			continue
+		}
+
+		if vr == VR_SQ {
+			items, next, err := parseSequence(data, valueOffset, length, true)
+			if err != nil {
+				return dataset, fmt.Errorf("sequence %s: %w", tag, err)
+			}
+			dataset.AddElement(tag, vr, items)
+			offset = next
+			continue
+		}
+
 		// Ensure we have enough data for the value
-		if valueOffset+int(length) > len(data) {
+		if uint64(valueOffset)+uint64(length) > uint64(len(data)) {
 			break
 		}
 
 		// Extract value
 		valueData := data[valueOffset : valueOffset+int(length)]
-		value := parseElementValue(tag, valueData)
+		value := parseElementValue(tag, vr, valueData)
 
 		dataset.AddElement(tag, vr, value)
 
@@ -197,18 +214,87 @@ func ParseDataset(data []byte) (*Dataset, error) {
 	return dataset, nil
 }
 
+// ParseOption configures ParseDatasetWithTransferSyntax.
+type ParseOption func(*parseConfig)
+
+type parseConfig struct {
+	lenient         bool
+	maxInflatedSize int64
+}
+
+// DefaultMaxInflatedSize is the largest a Deflated Explicit VR Little Endian
+// dataset may grow to when inflated, unless WithMaxInflatedSize is given.
+const DefaultMaxInflatedSize = 256 << 20
+
+// WithMaxInflatedSize bounds the size, in bytes, a Deflated Explicit VR
+// Little Endian dataset may inflate to; larger datasets are an error rather
+// than being decompressed without limit. Defaults to DefaultMaxInflatedSize.
+func WithMaxInflatedSize(n int64) ParseOption {
+	return func(c *parseConfig) {
+		c.maxInflatedSize = n
+	}
+}
+
+// WithLenientTransferSyntax parses datasets in unknown or unsupported transfer
+// syntaxes as Explicit VR Little Endian instead of returning an error.
+func WithLenientTransferSyntax() ParseOption {
+	return func(c *parseConfig) {
+		c.lenient = true
+	}
+}
+
 // ParseDatasetWithTransferSyntax parses a dataset using the provided transfer syntax.
-func ParseDatasetWithTransferSyntax(data []byte, transferSyntaxUID string) (*Dataset, error) {
-	switch transferSyntaxUID {
-	case "", TransferSyntaxExplicitVRLittleEndian:
+//
+// Under encapsulated (compressed) syntaxes the metadata elements are parsed
+// as usual and Pixel Data is kept as EncapsulatedPixelData fragments, never
+// decoded. Unknown transfer syntaxes are an error unless
+// WithLenientTransferSyntax is given. Deflated datasets inflating past
+// WithMaxInflatedSize (DefaultMaxInflatedSize) are an error too.
+func ParseDatasetWithTransferSyntax(data []byte, transferSyntaxUID string, opts ...ParseOption) (*Dataset, error) {
+	config := parseConfig{maxInflatedSize: DefaultMaxInflatedSize}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	switch {
+	case transferSyntaxUID == "", transferSyntaxUID == TransferSyntaxExplicitVRLittleEndian:
 		return ParseDataset(data)
-	case TransferSyntaxImplicitVRLittleEndian:
+	case transferSyntaxUID == TransferSyntaxImplicitVRLittleEndian:
 		return parseImplicitVRDataset(data)
+	case transferSyntaxUID == types.DeflatedExplicitVRLittleEndian:
+		inflated, err := inflate(data, config.maxInflatedSize)
+		if err != nil {
+			return nil, err
+		}
+		return ParseDataset(inflated)
+	case types.IsEncapsulated(transferSyntaxUID):
+		// Encapsulated syntaxes keep Explicit VR Little Endian for everything
+		// but Pixel Data, which ParseDataset leaves as fragments
+		return ParseDataset(data)
+	case config.lenient:
+		return ParseDataset(data)
+	case transferSyntaxUID == types.ExplicitVRBigEndian:
+		return nil, fmt.Errorf("transfer syntax %s (Explicit VR Big Endian) is not supported", transferSyntaxUID)
+	case !types.IsKnownTransferSyntax(transferSyntaxUID):
+		return nil, fmt.Errorf("unknown transfer syntax %s", transferSyntaxUID)
 	default:
 		return ParseDataset(data)
 	}
 }
 
+// inflate decompresses a deflated dataset, failing once it grows past limit
+// bytes so that a small input cannot expand without bound
+func inflate(data []byte, limit int64) ([]byte, error) {
+	inflated, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate dataset: %w", err)
+	}
+	if int64(len(inflated)) > limit {
+		return nil, fmt.Errorf("inflated dataset exceeds %d bytes", limit)
+	}
+	return inflated, nil
+}
+
 func parseImplicitVRDataset(data []byte) (*Dataset, error) {
 	dataset := NewDataset()
 
@@ -224,14 +310,25 @@ func parseImplicitVRDataset(data []byte) (*Dataset, error) {
 
 		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
 		valueOffset := offset + 8
+		vr := determineVR(tag)
 
-		if valueOffset+int(length) > len(data) {
+		// Only sequences have an undefined length in Implicit VR
+		if vr == VR_SQ || length == undefinedLength {
+			items, next, err := parseSequence(data, valueOffset, length, false)
+			if err != nil {
+				return dataset, fmt.Errorf("sequence %s: %w", tag, err)
+			}
+			dataset.AddElement(tag, VR_SQ, items)
+			offset = next
+			continue
+		}
+
+		if uint64(valueOffset)+uint64(length) > uint64(len(data)) {
 			break
 		}
 
 		valueData := data[valueOffset : valueOffset+int(length)]
-		vr := determineVR(tag)
-		value := parseElementValue(tag, valueData)
+		value := parseElementValue(tag, vr, valueData)
 
 		dataset.AddElement(tag, vr, value)
 
@@ -245,12 +342,40 @@ func parseImplicitVRDataset(data []byte) (*Dataset, error) {
 	return dataset, nil
 }
 
-// parseElementValue parses the value based on the tag and raw data
-func parseElementValue(tag Tag, data []byte) interface{} {
+// parseElementValue parses the value based on the tag and raw data. Binary
+// VRs keep their raw bytes, single US/UL values are decoded to integers and
+// SS/SL/FL/FD values to numbers (see decodeNumbers); everything else is
+// treated as a string.
+func parseElementValue(tag Tag, vr string, data []byte) interface{} {
 	if len(data) == 0 {
 		return ""
 	}
 
+	switch vr {
+	case VR_OB, VR_OD, VR_OF, VR_OL, VR_OV, VR_OW:
+		return append([]byte(nil), data...)
+	case VR_SS, VR_SL, VR_FL, VR_FD:
+		if value, ok := decodeNumbers(vr, data, binary.LittleEndian); ok {
+			return value
+		}
+		return append([]byte(nil), data...)
+	case VR_US:
+		if len(data) == 2 {
+			return binary.LittleEndian.Uint16(data)
+		}
+	case VR_UL:
+		if len(data) == 4 {
+			return binary.LittleEndian.Uint32(data)
+		}
+	}
+
+	// UIDs are null padded, and senders that pad each value of a multi-valued
+	// UI leave nulls inside it; strip them per value rather than cutting the
+	// element short at the first one
+	if vr == VR_UI {
+		return joinValues(strings.Split(string(data), "\\"), " \x00")
+	}
+
 	// For most query elements, we treat them as strings
 	// Remove null padding
 	value := string(data)
@@ -261,9 +386,114 @@ func parseElementValue(tag Tag, data []byte) interface{} {
 	return strings.TrimSpace(value)
 }
 
+// decodeNumbers decodes the values of an SS, SL, FL or FD element in the
+// given byte order: one value as an int16, int32, float32 or float64, several
+// as a slice of that type. It fails if data is not a whole number of values.
+func decodeNumbers(vr string, data []byte, order binary.ByteOrder) (interface{}, bool) {
+	var values interface{}
+	var count int
+	switch vr {
+	case VR_SS:
+		count = len(data) / 2
+		values = make([]int16, count)
+	case VR_SL:
+		count = len(data) / 4
+		values = make([]int32, count)
+	case VR_FL:
+		count = len(data) / 4
+		values = make([]float32, count)
+	case VR_FD:
+		count = len(data) / 8
+		values = make([]float64, count)
+	default:
+		return nil, false
+	}
+	if count == 0 || binary.Size(values) != len(data) {
+		return nil, false
+	}
+	if _, err := binary.Decode(data, order, values); err != nil {
+		return nil, false
+	}
+	if count > 1 {
+		return values, true
+	}
+
+	switch v := values.(type) {
+	case []int16:
+		return v[0], true
+	case []int32:
+		return v[0], true
+	case []float32:
+		return v[0], true
+	default:
+		return v.([]float64)[0], true
+	}
+}
+
+// encodeNumbers encodes SS, SL, FL and FD values in the given byte order. The
+// Go type must match the VR (int16 for SS, int32 for SL, float32 for FL,
+// float64 for FD, or a slice of it).
+func encodeNumbers(vr string, value interface{}, order binary.ByteOrder) ([]byte, bool) {
+	switch value.(type) {
+	case int16, []int16:
+		if vr != VR_SS {
+			return nil, false
+		}
+	case int32, []int32:
+		if vr != VR_SL {
+			return nil, false
+		}
+	case float32, []float32:
+		if vr != VR_FL {
+			return nil, false
+		}
+	case float64, []float64:
+		if vr != VR_FD {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	result, err := binary.Append(nil, order, value)
+	return result, err == nil
+}
+
+// joinValues joins the values of a multi-valued element with backslashes,
+// trimming cutset from the end of each value so that padding never ends up
+// inside the element.
+func joinValues(values []string, cutset string) string {
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimRight(value, cutset)
+	}
+	return strings.Join(trimmed, "\\")
+}
+
+// paddingByte returns the byte that pads a value of the given VR to even
+// length: UIDs are padded with a null, text with a space.
+func paddingByte(vr string) byte {
+	if vr == VR_UI {
+		return 0x00
+	}
+	return 0x20
+}
+
 // determineVR determines the VR based on the tag (simplified mapping)
 func determineVR(tag Tag) string {
 	// This is a simplified mapping - in practice you'd use a DICOM dictionary
+
+	// Overlay groups (60xx) repeat for up to 16 overlays
+	if tag.Group >= 0x6000 && tag.Group <= 0x601E && tag.Group%2 == 0 {
+		switch tag.Element {
+		case 0x0010, 0x0011: // Overlay Rows, Overlay Columns
+			return VR_US
+		case 0x0050: // Overlay Origin
+			return VR_SS
+		case 0x3000: // Overlay Data
+			return VR_OW
+		}
+	}
+
 	switch tag {
 	case Tag{0x0008, 0x0005}: // Specific Character Set
 		return VR_CS
@@ -299,6 +529,24 @@ func determineVR(tag Tag) string {
 		return VR_PN
 	case Tag{0x0008, 0x1070}: // Operators' Name
 		return VR_PN
+	case Tag{0x0008, 0x1032}: // Procedure Code Sequence
+		return VR_SQ
+	case Tag{0x0008, 0x1110}: // Referenced Study Sequence
+		return VR_SQ
+	case Tag{0x0008, 0x1115}: // Referenced Series Sequence
+		return VR_SQ
+	case Tag{0x0008, 0x1140}: // Referenced Image Sequence
+		return VR_SQ
+	case Tag{0x0008, 0x1150}: // Referenced SOP Class UID
+		return VR_UI
+	case Tag{0x0008, 0x1155}: // Referenced SOP Instance UID
+		return VR_UI
+	case Tag{0x0008, 0x1198}: // Failed SOP Sequence
+		return VR_SQ
+	case Tag{0x0008, 0x1199}: // Referenced SOP Sequence
+		return VR_SQ
+	case Tag{0x0008, 0x1120}: // Referenced Patient Sequence
+		return VR_SQ
 	case Tag{0x0010, 0x0010}: // Patient's Name
 		return VR_PN
 	case Tag{0x0010, 0x0020}: // Patient ID
@@ -323,6 +571,64 @@ func determineVR(tag Tag) string {
 		return VR_IS
 	case Tag{0x0020, 0x0020}: // Patient Orientation
 		return VR_CS
+	case Tag{0x0028, 0x0002}: // Samples per Pixel
+		return VR_US
+	case Tag{0x0028, 0x0004}: // Photometric Interpretation
+		return VR_CS
+	case Tag{0x0028, 0x0008}: // Number of Frames
+		return VR_IS
+	case Tag{0x0028, 0x0010}: // Rows
+		return VR_US
+	case Tag{0x0028, 0x0011}: // Columns
+		return VR_US
+	case Tag{0x0028, 0x0100}: // Bits Allocated
+		return VR_US
+	case Tag{0x0040, 0x0220}: // Referenced Non-Image Composite SOP Instance Sequence
+		return VR_SQ
+	case Tag{0x0040, 0x0241}: // Performed Station AE Title
+		return VR_AE
+	case Tag{0x0040, 0x0244}: // Performed Procedure Step Start Date
+		return VR_DA
+	case Tag{0x0040, 0x0245}: // Performed Procedure Step Start Time
+		return VR_TM
+	case Tag{0x0040, 0x0250}: // Performed Procedure Step End Date
+		return VR_DA
+	case Tag{0x0040, 0x0251}: // Performed Procedure Step End Time
+		return VR_TM
+	case Tag{0x0040, 0x0252}: // Performed Procedure Step Status
+		return VR_CS
+	case Tag{0x0040, 0x0253}: // Performed Procedure Step ID
+		return VR_SH
+	case Tag{0x0040, 0x0254}: // Performed Procedure Step Description
+		return VR_LO
+	case Tag{0x0040, 0x0260}: // Performed Protocol Code Sequence
+		return VR_SQ
+	case Tag{0x0040, 0x0270}: // Scheduled Step Attributes Sequence
+		return VR_SQ
+	case Tag{0x0040, 0x0281}: // Performed Procedure Step Discontinuation Reason Code Sequence
+		return VR_SQ
+	case Tag{0x0040, 0x0340}: // Performed Series Sequence
+		return VR_SQ
+	case Tag{0x0018, 0x6020}: // Reference Pixel X0
+		return VR_SL
+	case Tag{0x0018, 0x6022}: // Reference Pixel Y0
+		return VR_SL
+	case Tag{0x0018, 0x9087}: // Diffusion b-value
+		return VR_FD
+	case Tag{0x0040, 0x9224}: // Real World Value Intercept
+		return VR_FD
+	case Tag{0x0040, 0x9225}: // Real World Value Slope
+		return VR_FD
+	case Tag{0x0070, 0x0010}: // Bounding Box Top Left Hand Corner
+		return VR_FL
+	case Tag{0x0070, 0x0011}: // Bounding Box Bottom Right Hand Corner
+		return VR_FL
+	case Tag{0x0070, 0x0014}: // Anchor Point
+		return VR_FL
+	case Tag{0x0070, 0x0022}: // Graphic Data
+		return VR_FL
+	case Tag{0x7FE0, 0x0010}: // Pixel Data
+		return VR_OW
 	default:
 		return VR_UN // Unknown
 	}
@@ -330,45 +636,130 @@ func determineVR(tag Tag) string {
 
 // EncodeDataset encodes a dataset to bytes (Explicit VR Little Endian)
 func (d *Dataset) EncodeDataset() []byte {
-	var result []byte
+	if d.isEmpty() {
+		return nil
+	}
+	var buf bytes.Buffer
+	_ = encodeExplicitVRDatasetTo(&buf, d) // Writes to a bytes.Buffer cannot fail
+	return buf.Bytes()
+}
+
+// EncodeDatasetWithTransferSyntax encodes a dataset using the provided transfer syntax.
+// A nil or empty dataset encodes to nil, meaning no dataset.
+func EncodeDatasetWithTransferSyntax(dataset *Dataset, transferSyntaxUID string) ([]byte, error) {
+	if dataset.isEmpty() {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeDatasetTo(&buf, dataset, transferSyntaxUID); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isEmpty reports whether a dataset has no elements, which encodes to no
+// bytes at all in every transfer syntax
+func (d *Dataset) isEmpty() bool {
+	return d == nil || len(d.Elements) == 0
+}
+
+// CanEncodeTransferSyntax reports whether datasets can be encoded in the
+// transfer syntax: Implicit or Explicit VR Little Endian, or Deflated
+// Explicit VR Little Endian.
+func CanEncodeTransferSyntax(transferSyntaxUID string) bool {
+	switch transferSyntaxUID {
+	case TransferSyntaxImplicitVRLittleEndian, TransferSyntaxExplicitVRLittleEndian, types.DeflatedExplicitVRLittleEndian:
+		return true
+	}
+	return false
+}
 
-	// Collect tags and sort them (DICOM requires tag ordering)
-	var tags []Tag
+// EncodeDatasetTo writes a dataset to w using the provided transfer syntax.
+// Elements are written one at a time in tag order, so large values such as
+// Pixel Data are not copied into an intermediate buffer; with a buffered
+// writer, memory use stays bounded by the buffer size. Nothing is written for
+// a nil or empty dataset, not even a deflate stream.
+func EncodeDatasetTo(w io.Writer, dataset *Dataset, transferSyntaxUID string) error {
+	if dataset.isEmpty() {
+		return nil
+	}
+
+	switch transferSyntaxUID {
+	case TransferSyntaxImplicitVRLittleEndian:
+		return encodeImplicitVRDatasetTo(w, dataset)
+	case types.DeflatedExplicitVRLittleEndian:
+		return deflateTo(w, dataset)
+	default:
+		return encodeExplicitVRDatasetTo(w, dataset)
+	}
+}
+
+// deflateTo writes a dataset compressed for the Deflated Explicit VR Little
+// Endian transfer syntax (raw RFC 1951, no zlib header)
+func deflateTo(w io.Writer, dataset *Dataset) error {
+	writer, err := flate.NewWriter(w, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if err := encodeExplicitVRDatasetTo(writer, dataset); err != nil {
+		return fmt.Errorf("failed to deflate dataset: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to deflate dataset: %w", err)
+	}
+	return nil
+}
+
+// sortedTags returns the dataset's tags in ascending order, as DICOM
+// requires for encoding
+func (d *Dataset) sortedTags() []Tag {
+	tags := make([]Tag, 0, len(d.Elements))
 	for tag := range d.Elements {
 		tags = append(tags, tag)
 	}
+	sortTags(tags)
+	return tags
+}
 
-	// Sort tags by group, then by element
-	for i := 0; i < len(tags)-1; i++ {
-		for j := i + 1; j < len(tags); j++ {
-			if tags[i].Group > tags[j].Group ||
-				(tags[i].Group == tags[j].Group && tags[i].Element > tags[j].Element) {
-				tags[i], tags[j] = tags[j], tags[i]
-			}
+// sortTags sorts tags in ascending order
+func sortTags(tags []Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Group != tags[j].Group {
+			return tags[i].Group < tags[j].Group
 		}
-	}
-
-	// Add elements in sorted tag order (using Explicit VR Little Endian)
-	for _, tag := range tags {
-		element := d.Elements[tag]
+		return tags[i].Element < tags[j].Element
+	})
+}
 
-		// Tag (4 bytes - Little Endian)
-		tagBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint16(tagBytes[0:2], tag.Group)
-		binary.LittleEndian.PutUint16(tagBytes[2:4], tag.Element)
-		result = append(result, tagBytes...)
+func encodeExplicitVRDatasetTo(w io.Writer, dataset *Dataset) error {
+	header := make([]byte, 0, 12)
+	for _, tag := range dataset.sortedTags() {
+		element := dataset.Elements[tag]
 
-		// VR (2 bytes - ASCII)
-		result = append(result, []byte(element.VR)...)
+		// Tag (4 bytes - Little Endian) and VR (2 bytes - ASCII)
+		header = binary.LittleEndian.AppendUint16(header[:0], tag.Group)
+		header = binary.LittleEndian.AppendUint16(header, tag.Element)
+		header = append(header, element.VR...)
 
-		// Encode value
-		valueBytes := encodeElementValue(element)
+		if pixelData, ok := element.Value.(*EncapsulatedPixelData); ok {
+			if _, err := w.Write(header); err != nil {
+				return err
+			}
+			if err := pixelData.writeTo(w); err != nil {
+				return err
+			}
+			continue
+		}
 
-		// Add padding if odd length (DICOM requires even lengths)
-		if len(valueBytes)%2 == 1 {
-			valueBytes = append(valueBytes, 0x20) // Use space padding for text elements
+		valueBytes, err := encodeValue(element, true)
+		if err != nil {
+			return err
 		}
 
+		// DICOM requires even lengths
+		length := len(valueBytes) + len(valueBytes)%2
+
 		// For Explicit VR, length encoding depends on VR type
 		// Short VRs (most string types): 2-byte length
 		// Long VRs (OB, OW, SQ, UN, UT): 4-byte length with 2 reserved bytes
@@ -379,96 +770,98 @@ func (d *Dataset) EncodeDataset() []byte {
 
 		if isLongVR {
 			// Long VR format: VR (2 bytes) + Reserved (2 bytes) + Length (4 bytes)
-			result = append(result, 0x00, 0x00) // Reserved bytes
-			lengthBytes := make([]byte, 4)
-			binary.LittleEndian.PutUint32(lengthBytes, uint32(len(valueBytes)))
-			result = append(result, lengthBytes...)
+			header = append(header, 0x00, 0x00)
+			header = binary.LittleEndian.AppendUint32(header, uint32(length))
 		} else {
 			// Short VR format: VR (2 bytes) + Length (2 bytes)
-			if len(valueBytes) > 65535 {
-				// Value too long for short VR format - truncate or error
+			if length > 65535 {
+				// Value too long for short VR format - truncate
 				valueBytes = valueBytes[:65535]
+				length = 65535
 			}
-			lengthBytes := make([]byte, 2)
-			binary.LittleEndian.PutUint16(lengthBytes, uint16(len(valueBytes)))
-			result = append(result, lengthBytes...)
+			header = binary.LittleEndian.AppendUint16(header, uint16(length))
 		}
 
-		// Value (already padded)
-		result = append(result, valueBytes...)
+		if err := writeElement(w, header, valueBytes, length, element.VR); err != nil {
+			return err
+		}
 	}
-
-	return result
+	return nil
 }
 
-// EncodeDatasetWithTransferSyntax encodes a dataset using the provided transfer syntax.
-func EncodeDatasetWithTransferSyntax(dataset *Dataset, transferSyntaxUID string) ([]byte, error) {
-	if dataset == nil {
-		return nil, nil
-	}
+func encodeImplicitVRDatasetTo(w io.Writer, dataset *Dataset) error {
+	header := make([]byte, 0, 8)
+	for _, tag := range dataset.sortedTags() {
+		element := dataset.Elements[tag]
 
-	switch transferSyntaxUID {
-	case "", TransferSyntaxExplicitVRLittleEndian:
-		return dataset.EncodeDataset(), nil
-	case TransferSyntaxImplicitVRLittleEndian:
-		return encodeImplicitVRDataset(dataset), nil
-	default:
-		return dataset.EncodeDataset(), nil
-	}
-}
+		valueBytes, err := encodeValue(element, false)
+		if err != nil {
+			return err
+		}
+		length := len(valueBytes) + len(valueBytes)%2
 
-func encodeImplicitVRDataset(dataset *Dataset) []byte {
-	var result []byte
+		header = binary.LittleEndian.AppendUint16(header[:0], tag.Group)
+		header = binary.LittleEndian.AppendUint16(header, tag.Element)
+		header = binary.LittleEndian.AppendUint32(header, uint32(length))
 
-	var tags []Tag
-	for tag := range dataset.Elements {
-		tags = append(tags, tag)
+		if err := writeElement(w, header, valueBytes, length, element.VR); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	for i := 0; i < len(tags)-1; i++ {
-		for j := i + 1; j < len(tags); j++ {
-			if tags[i].Group > tags[j].Group ||
-				(tags[i].Group == tags[j].Group && tags[i].Element > tags[j].Element) {
-				tags[i], tags[j] = tags[j], tags[i]
-			}
+// writeElement writes an element header and its value, padding the value
+// to length. Values are written directly rather than appended to the header,
+// so large ones are not copied.
+func writeElement(w io.Writer, header, value []byte, length int, vr string) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		return err
+	}
+	if length > len(value) {
+		if _, err := w.Write([]byte{paddingByte(vr)}); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	for _, tag := range tags {
-		element := dataset.Elements[tag]
-
-		tagBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint16(tagBytes[0:2], tag.Group)
-		binary.LittleEndian.PutUint16(tagBytes[2:4], tag.Element)
-		result = append(result, tagBytes...)
-
-		valueBytes := encodeElementValue(element)
-		if len(valueBytes)%2 == 1 {
-			valueBytes = append(valueBytes, 0x20)
+// encodeValue encodes an element value to bytes, encoding sequence items
+// with the enclosing dataset's VR encoding
+func encodeValue(element *Element, explicit bool) ([]byte, error) {
+	if items, ok := element.Value.([]*Dataset); ok {
+		value, err := encodeSequence(items, explicit)
+		if err != nil {
+			return nil, fmt.Errorf("sequence %s: %w", element.Tag, err)
 		}
-
-		lengthBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(lengthBytes, uint32(len(valueBytes)))
-		result = append(result, lengthBytes...)
-		result = append(result, valueBytes...)
+		return value, nil
 	}
-
-	return result
+	return encodeElementValue(element), nil
 }
 
 // encodeElementValue encodes an element value to bytes
 func encodeElementValue(element *Element) []byte {
 	switch v := element.Value.(type) {
 	case string:
+		if element.VR == VR_UI {
+			return []byte(joinValues(strings.Split(v, "\\"), "\x00"))
+		}
 		// For string VRs, ensure proper encoding
 		value := v
 		// Remove any existing null terminators and add proper padding
 		value = strings.TrimRight(value, "\x00")
 		return []byte(value)
+	case []byte:
+		// Binary values are padded with a null byte rather than a space
+		if len(v)%2 == 1 {
+			return append(append([]byte(nil), v...), 0x00)
+		}
+		return v
 	case []string:
-		joined := strings.Join(v, "\\")
-		joined = strings.TrimRight(joined, "\x00")
-		return []byte(joined)
+		return []byte(joinValues(v, "\x00"))
 	case int:
 		return []byte(fmt.Sprintf("%d", v))
 	case uint16:
@@ -479,6 +872,11 @@ func encodeElementValue(element *Element) []byte {
 		result := make([]byte, 4)
 		binary.LittleEndian.PutUint32(result, v)
 		return result
+	case int16, int32, float32, float64, []int16, []int32, []float32, []float64:
+		if result, ok := encodeNumbers(element.VR, v, binary.LittleEndian); ok {
+			return result
+		}
+		return []byte(fmt.Sprintf("%v", v))
 	default:
 		return []byte(fmt.Sprintf("%v", v))
 	}
@@ -1,8 +1,12 @@
 package dicom
 
 import (
+	"bytes"
 	"encoding/binary"
+	"reflect"
 	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
 )
 
 func TestTag_String(t *testing.T) {
@@ -466,6 +470,10 @@ func TestDetermineVR(t *testing.T) {
 		{"Study ID", Tag{0x0020, 0x0010}, VR_SH},
 		{"Series Number", Tag{0x0020, 0x0011}, VR_IS},
 		{"Instance Number", Tag{0x0020, 0x0013}, VR_IS},
+		{"Overlay Origin", Tag{0x6002, 0x0050}, VR_SS},
+		{"Reference Pixel X0", Tag{0x0018, 0x6020}, VR_SL},
+		{"Graphic Data", Tag{0x0070, 0x0022}, VR_FL},
+		{"Diffusion b-value", Tag{0x0018, 0x9087}, VR_FD},
 		{"Unknown tag", Tag{0xFFFF, 0xFFFF}, VR_UN},
 	}
 
@@ -479,6 +487,79 @@ func TestDetermineVR(t *testing.T) {
 	}
 }
 
+func TestImplicitVR_NumericRoundTrip(t *testing.T) {
+	overlayOrigin := Tag{0x6000, 0x0050}
+	bValue := Tag{0x0018, 0x9087}
+	referencePixelX0 := Tag{0x0018, 0x6020}
+	graphicData := Tag{0x0070, 0x0022}
+
+	ds := NewDataset()
+	ds.AddElement(overlayOrigin, VR_SS, []int16{1, -5})
+	ds.AddElement(bValue, VR_FD, 1000.5)
+	ds.AddElement(referencePixelX0, VR_SL, int32(-12))
+	ds.AddElement(graphicData, VR_FL, []float32{0.25, -1.5})
+
+	data, err := EncodeDatasetWithTransferSyntax(ds, TransferSyntaxImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	// (0018,9087) UL length 8, then the little endian double
+	wantFD := binary.LittleEndian.AppendUint64([]byte{0x18, 0x00, 0x87, 0x90, 0x08, 0x00, 0x00, 0x00}, 0x408F440000000000)
+	if !bytes.Contains(data, wantFD) {
+		t.Errorf("Encoded dataset % X does not contain the FD element % X", data, wantFD)
+	}
+
+	parsed, err := ParseDatasetWithTransferSyntax(data, TransferSyntaxImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	for tag, want := range map[Tag]interface{}{
+		overlayOrigin:    []int16{1, -5},
+		bValue:           1000.5,
+		referencePixelX0: int32(-12),
+		graphicData:      []float32{0.25, -1.5},
+	} {
+		element, ok := parsed.GetElement(tag)
+		if !ok {
+			t.Errorf("%s missing after round trip", tag)
+			continue
+		}
+		if !reflect.DeepEqual(element.Value, want) {
+			t.Errorf("%s = %#v, want %#v", tag, element.Value, want)
+		}
+	}
+}
+
+func TestDecodeNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		vr    string
+		data  []byte
+		order binary.ByteOrder
+		want  interface{}
+	}{
+		{"SS little endian", VR_SS, []byte{0xFE, 0xFF}, binary.LittleEndian, int16(-2)},
+		{"SS big endian", VR_SS, []byte{0xFF, 0xFE}, binary.BigEndian, int16(-2)},
+		{"SL big endian", VR_SL, []byte{0x00, 0x01, 0x00, 0x00}, binary.BigEndian, int32(65536)},
+		{"FD big endian", VR_FD, []byte{0x40, 0x8F, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00}, binary.BigEndian, 1000.5},
+		{"FL values", VR_FL, []byte{0x00, 0x00, 0x80, 0x3E, 0x00, 0x00, 0xC0, 0xBF}, binary.LittleEndian, []float32{0.25, -1.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeNumbers(tt.vr, tt.data, tt.order)
+			if !ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeNumbers() = %#v, %v; want %#v", got, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := decodeNumbers(VR_FD, []byte{0x00, 0x01, 0x02}, binary.LittleEndian); ok {
+		t.Error("decodeNumbers() accepted a partial FD value")
+	}
+}
+
 func TestEncodeElementValue_VariousTypes(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -585,3 +666,189 @@ func TestEncodeElementValue_VariousTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestMultiValuedUI(t *testing.T) {
+	sopClasses := Tag{0x0008, 0x1150}
+	first := "1.2.840.10008.5.1.4.1.1.2"
+	second := "1.2.840.10008.5.1.4.1.1.4"
+
+	// Each value arrives with its own null padding, as some senders write it
+	ds := NewDataset()
+	ds.AddElement(sopClasses, VR_UI, []string{first + "\x00", second})
+
+	encoded := ds.EncodeDataset()
+	value := encoded[8:]
+	if want := first + "\\" + second + "\x00"; string(value) != want {
+		t.Errorf("Encoded value = %q, want %q", value, want)
+	}
+
+	parsed, err := ParseDataset(encoded)
+	if err != nil {
+		t.Fatalf("ParseDataset() error = %v", err)
+	}
+	got := parsed.GetStrings(sopClasses)
+	if len(got) != 2 || got[0] != first || got[1] != second {
+		t.Errorf("GetStrings() = %q, want [%q %q]", got, first, second)
+	}
+
+	// A null inside the element must not cut the second value off
+	raw := binary.LittleEndian.AppendUint16(nil, sopClasses.Group)
+	raw = binary.LittleEndian.AppendUint16(raw, sopClasses.Element)
+	raw = append(raw, VR_UI...)
+	rawValue := first + "\x00\\" + second
+	raw = binary.LittleEndian.AppendUint16(raw, uint16(len(rawValue)))
+	raw = append(raw, rawValue...)
+
+	parsed, err = ParseDataset(raw)
+	if err != nil {
+		t.Fatalf("ParseDataset() error = %v", err)
+	}
+	if got, want := parsed.GetString(sopClasses), first+"\\"+second; got != want {
+		t.Errorf("GetString() = %q, want %q", got, want)
+	}
+}
+
+func TestEncodeDatasetWithTransferSyntax_Deflated(t *testing.T) {
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0010, 0x0010}, VR_PN, "DOE^JANE")
+	ds.AddElement(Tag{0x0020, 0x000D}, VR_UI, "1.2.3.4")
+
+	encoded, err := EncodeDatasetWithTransferSyntax(ds, types.DeflatedExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+	if bytes.Equal(encoded, ds.EncodeDataset()) {
+		t.Fatal("Deflated encoding is identical to Explicit VR Little Endian")
+	}
+
+	parsed, err := ParseDatasetWithTransferSyntax(encoded, types.DeflatedExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	if got := parsed.GetString(Tag{0x0010, 0x0010}); got != "DOE^JANE" {
+		t.Errorf("Patient Name = %q, want DOE^JANE", got)
+	}
+}
+
+func TestParseDatasetWithTransferSyntax_InflateLimit(t *testing.T) {
+	ds := NewDataset()
+	ds.AddElement(pixelDataTag, VR_OB, make([]byte, 2<<20))
+	encoded, err := EncodeDatasetWithTransferSyntax(ds, types.DeflatedExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	if _, err := ParseDatasetWithTransferSyntax(encoded, types.DeflatedExplicitVRLittleEndian); err != nil {
+		t.Errorf("ParseDatasetWithTransferSyntax() error = %v, want nil under the default limit", err)
+	}
+	if _, err := ParseDatasetWithTransferSyntax(encoded, types.DeflatedExplicitVRLittleEndian, WithMaxInflatedSize(1<<20)); err == nil {
+		t.Error("Expected an error for a dataset inflating past the limit")
+	}
+}
+
+func TestEncodeDataset_Empty(t *testing.T) {
+	one := NewDataset()
+	one.AddElement(Tag{0x0010, 0x0020}, VR_LO, "123")
+
+	tests := []struct {
+		name    string
+		dataset *Dataset
+		wantNil bool
+	}{
+		{"nil", nil, true},
+		{"empty", NewDataset(), true},
+		{"one element", one, false},
+	}
+
+	syntaxes := []string{TransferSyntaxImplicitVRLittleEndian, TransferSyntaxExplicitVRLittleEndian, types.DeflatedExplicitVRLittleEndian}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, ts := range syntaxes {
+				encoded, err := EncodeDatasetWithTransferSyntax(tt.dataset, ts)
+				if err != nil {
+					t.Fatalf("EncodeDatasetWithTransferSyntax(%s) error = %v", ts, err)
+				}
+				if (encoded == nil) != tt.wantNil || (!tt.wantNil && len(encoded) == 0) {
+					t.Errorf("EncodeDatasetWithTransferSyntax(%s) = % X, want nil = %v", ts, encoded, tt.wantNil)
+				}
+			}
+			if encoded := tt.dataset.EncodeDataset(); (encoded == nil) != tt.wantNil {
+				t.Errorf("EncodeDataset() = % X, want nil = %v", encoded, tt.wantNil)
+			}
+		})
+	}
+}
+
+// largestWriteRecorder discards data, remembering the largest single write
+type largestWriteRecorder struct {
+	bytes.Buffer
+	largest int
+}
+
+func (w *largestWriteRecorder) Write(p []byte) (int, error) {
+	w.largest = max(w.largest, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestEncodeDatasetTo_MatchesBufferedEncoding(t *testing.T) {
+	pixels := make([]byte, 8<<20)
+	for i := range pixels {
+		pixels[i] = byte(i * 7)
+	}
+
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0008, 0x0018}, VR_UI, "1.2.3.4.5")
+	ds.AddElement(Tag{0x0010, 0x0010}, VR_PN, "DOE^JOHN")
+	ds.AddElement(Tag{0x0010, 0x0020}, VR_LO, "ODD")
+	ds.AddElement(Tag{0x0028, 0x0010}, VR_US, uint16(2048))
+	ds.AddElement(Tag{0x0028, 0x0011}, VR_US, uint16(2048))
+	ds.AddElement(Tag{0x7FE0, 0x0010}, VR_OW, pixels)
+
+	for _, ts := range []string{
+		types.ExplicitVRLittleEndian,
+		types.ImplicitVRLittleEndian,
+		types.DeflatedExplicitVRLittleEndian,
+	} {
+		t.Run(ts, func(t *testing.T) {
+			buffered, err := EncodeDatasetWithTransferSyntax(ds, ts)
+			if err != nil {
+				t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+			}
+
+			var streamed largestWriteRecorder
+			if err := EncodeDatasetTo(&streamed, ds, ts); err != nil {
+				t.Fatalf("EncodeDatasetTo() error = %v", err)
+			}
+			if !bytes.Equal(streamed.Bytes(), buffered) {
+				t.Fatalf("Streamed encoding (%d bytes) differs from buffered encoding (%d bytes)", streamed.Len(), len(buffered))
+			}
+			if ts != types.DeflatedExplicitVRLittleEndian && streamed.largest > len(pixels) {
+				t.Errorf("Largest write = %d bytes, want the Pixel Data value written on its own", streamed.largest)
+			}
+		})
+	}
+
+	encapsulated := NewDataset()
+	encapsulated.AddElement(Tag{0x7FE0, 0x0010}, VR_OB, &EncapsulatedPixelData{
+		Fragments: [][]byte{{0xFF, 0xD8, 0xFF}, bytes.Repeat([]byte{0x42}, 1000)},
+	})
+	var streamed bytes.Buffer
+	if err := EncodeDatasetTo(&streamed, encapsulated, types.JPEGBaseline8Bit); err != nil {
+		t.Fatalf("EncodeDatasetTo() encapsulated error = %v", err)
+	}
+	if !bytes.Equal(streamed.Bytes(), encapsulated.EncodeDataset()) {
+		t.Error("Streamed encapsulated Pixel Data differs from buffered encoding")
+	}
+	parsed, err := ParseDatasetWithTransferSyntax(streamed.Bytes(), types.JPEGBaseline8Bit)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	element, ok := parsed.GetElement(Tag{0x7FE0, 0x0010})
+	if !ok {
+		t.Fatal("Pixel Data missing after round trip")
+	}
+	pixelData, ok := element.Value.(*EncapsulatedPixelData)
+	if !ok || len(pixelData.Fragments) != 2 || len(pixelData.Fragments[0]) != 4 {
+		t.Errorf("Parsed Pixel Data = %+v, want 2 fragments with the odd one padded", element.Value)
+	}
+}
@@ -0,0 +1,199 @@
+package dicom
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetDate returns the first value of a DA element as a time.Time (UTC, midnight).
+// Legacy ACR-NEMA dates in YYYY.MM.DD form are accepted.
+func (d *Dataset) GetDate(tag Tag) (time.Time, bool) {
+	value, ok := d.firstStringValue(tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseDA(value)
+}
+
+// GetTime returns the first value of a TM element as a time.Time on 0000-01-01 UTC.
+// Partial precision values (HH, HHMM, HHMMSS) and fractional seconds are supported.
+func (d *Dataset) GetTime(tag Tag) (time.Time, bool) {
+	value, ok := d.firstStringValue(tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseTM(value)
+}
+
+// GetDateTime returns the first value of a DT element as a time.Time.
+// Partial precision (down to YYYY), fractional seconds and a &ZZXX UTC offset
+// are supported. Values without an offset are returned in UTC.
+func (d *Dataset) GetDateTime(tag Tag) (time.Time, bool) {
+	value, ok := d.firstStringValue(tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseDT(value)
+}
+
+// FormatDA formats t as a DICOM DA value (YYYYMMDD)
+func FormatDA(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// FormatTM formats t as a DICOM TM value (HHMMSS, with .FFFFFF when sub-second precision is present)
+func FormatTM(t time.Time) string {
+	s := t.Format("150405")
+	if t.Nanosecond() != 0 {
+		s += fmt.Sprintf(".%06d", t.Nanosecond()/1000)
+	}
+	return s
+}
+
+// FormatDT formats t as a DICOM DT value (YYYYMMDDHHMMSS[.FFFFFF]&ZZXX)
+func FormatDT(t time.Time) string {
+	s := FormatDA(t) + FormatTM(t)
+	return s + t.Format("-0700")
+}
+
+// firstStringValue returns the first non-empty value of a string element
+func (d *Dataset) firstStringValue(tag Tag) (string, bool) {
+	values := d.GetStrings(tag)
+	if len(values) == 0 || values[0] == "" {
+		return "", false
+	}
+	return values[0], true
+}
+
+// parseDA parses a DICOM DA value
+func parseDA(value string) (time.Time, bool) {
+	value = strings.ReplaceAll(value, ".", "")
+	if len(value) != 8 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseTM parses a DICOM TM value of the form HH[MM[SS[.F{1-6}]]]
+func parseTM(value string) (time.Time, bool) {
+	// Legacy ACR-NEMA times may use HH:MM:SS
+	value = strings.ReplaceAll(value, ":", "")
+
+	hour, minute, sec, nsec, ok := parseTimeComponents(value)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Date(0, time.January, 1, hour, minute, sec, nsec, time.UTC), true
+}
+
+// parseDT parses a DICOM DT value of the form YYYY[MM[DD[HH[MM[SS[.F{1-6}]]]]]][&ZZXX]
+func parseDT(value string) (time.Time, bool) {
+	loc := time.UTC
+	if i := strings.IndexAny(value, "+-"); i >= 0 {
+		offset := value[i:]
+		value = value[:i]
+		if len(offset) != 5 {
+			return time.Time{}, false
+		}
+		hh, err1 := strconv.Atoi(offset[1:3])
+		mm, err2 := strconv.Atoi(offset[3:5])
+		if err1 != nil || err2 != nil || hh > 14 || mm > 59 {
+			return time.Time{}, false
+		}
+		seconds := hh*3600 + mm*60
+		if offset[0] == '-' {
+			seconds = -seconds
+		}
+		loc = time.FixedZone(offset, seconds)
+	}
+
+	if len(value) < 4 {
+		return time.Time{}, false
+	}
+
+	year, month, day := 0, 1, 1
+	var err error
+	if year, err = strconv.Atoi(value[0:4]); err != nil {
+		return time.Time{}, false
+	}
+	rest := value[4:]
+	for _, field := range []*int{&month, &day} {
+		if len(rest) == 0 {
+			break
+		}
+		if len(rest) < 2 {
+			return time.Time{}, false
+		}
+		if *field, err = strconv.Atoi(rest[:2]); err != nil {
+			return time.Time{}, false
+		}
+		rest = rest[2:]
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+
+	hour, minute, sec, nsec := 0, 0, 0, 0
+	if len(rest) > 0 {
+		var ok bool
+		if hour, minute, sec, nsec, ok = parseTimeComponents(rest); !ok {
+			return time.Time{}, false
+		}
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, minute, sec, nsec, loc)
+	if t.Day() != day {
+		// time.Date normalizes invalid dates such as February 30
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseTimeComponents parses HH[MM[SS[.F{1-6}]]] into its components
+func parseTimeComponents(value string) (hour, minute, sec, nsec int, ok bool) {
+	fraction := ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		fraction = value[i+1:]
+		value = value[:i]
+		// A fraction is only valid after full HHMMSS precision
+		if len(value) != 6 || len(fraction) == 0 || len(fraction) > 6 {
+			return 0, 0, 0, 0, false
+		}
+	}
+
+	if len(value) != 2 && len(value) != 4 && len(value) != 6 {
+		return 0, 0, 0, 0, false
+	}
+
+	fields := []*int{&hour, &minute, &sec}
+	for i := 0; i < len(value)/2; i++ {
+		n, err := strconv.Atoi(value[i*2 : i*2+2])
+		if err != nil {
+			return 0, 0, 0, 0, false
+		}
+		*fields[i] = n
+	}
+	// Seconds may be 60 to allow for leap seconds
+	if hour > 23 || minute > 59 || sec > 60 {
+		return 0, 0, 0, 0, false
+	}
+
+	if fraction != "" {
+		n, err := strconv.Atoi(fraction)
+		if err != nil {
+			return 0, 0, 0, 0, false
+		}
+		for i := len(fraction); i < 9; i++ {
+			n *= 10
+		}
+		nsec = n
+	}
+
+	return hour, minute, sec, nsec, true
+}
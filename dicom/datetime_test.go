@@ -0,0 +1,139 @@
+package dicom
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDataset_GetDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		ok       bool
+	}{
+		{"Full date", "20240101", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Legacy dotted date", "2024.03.15", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), true},
+		{"Multi-valued uses first", "20231231\\20240101", time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), true},
+		{"Partial date", "202401", time.Time{}, false},
+		{"Invalid date", "20240230", time.Time{}, false},
+		{"Empty", "", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataset()
+			tag := Tag{0x0008, 0x0020}
+			ds.AddElement(tag, VR_DA, tt.value)
+
+			got, ok := ds.GetDate(tag)
+			if ok != tt.ok {
+				t.Fatalf("GetDate(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.expected) {
+				t.Errorf("GetDate(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+
+	if _, ok := NewDataset().GetDate(Tag{0x0008, 0x0020}); ok {
+		t.Error("Expected missing tag to return false")
+	}
+}
+
+func TestDataset_GetTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		ok       bool
+	}{
+		{"Full time", "120000", time.Date(0, 1, 1, 12, 0, 0, 0, time.UTC), true},
+		{"Fractional seconds", "235959.123456", time.Date(0, 1, 1, 23, 59, 59, 123456000, time.UTC), true},
+		{"Short fraction", "101010.5", time.Date(0, 1, 1, 10, 10, 10, 500000000, time.UTC), true},
+		{"Hours only", "07", time.Date(0, 1, 1, 7, 0, 0, 0, time.UTC), true},
+		{"Hours and minutes", "0730", time.Date(0, 1, 1, 7, 30, 0, 0, time.UTC), true},
+		{"Legacy colon format", "07:30:15", time.Date(0, 1, 1, 7, 30, 15, 0, time.UTC), true},
+		{"Fraction without seconds", "0730.5", time.Time{}, false},
+		{"Invalid hour", "250000", time.Time{}, false},
+		{"Odd length", "123", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataset()
+			tag := Tag{0x0008, 0x0030}
+			ds.AddElement(tag, VR_TM, tt.value)
+
+			got, ok := ds.GetTime(tag)
+			if ok != tt.ok {
+				t.Fatalf("GetTime(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.expected) {
+				t.Errorf("GetTime(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDataset_GetDateTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		ok       bool
+	}{
+		{"Full precision", "20240101120000", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), true},
+		{"Fraction and offset", "20240101120000.250000+0130", time.Date(2024, 1, 1, 10, 30, 0, 250000000, time.UTC), true},
+		{"Negative offset", "20240101120000-0500", time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC), true},
+		{"Year only", "2024", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Year and month", "202406", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Date and hour", "2024061513", time.Date(2024, 6, 15, 13, 0, 0, 0, time.UTC), true},
+		{"Year with offset", "2024+0000", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Truncated month", "20241", time.Time{}, false},
+		{"Bad offset", "20240101+01", time.Time{}, false},
+		{"Invalid month", "20241301", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataset()
+			tag := Tag{0x0008, 0x002A}
+			ds.AddElement(tag, VR_DT, tt.value)
+
+			got, ok := ds.GetDateTime(tag)
+			if ok != tt.ok {
+				t.Fatalf("GetDateTime(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.expected) {
+				t.Errorf("GetDateTime(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatDateTime(t *testing.T) {
+	ts := time.Date(2024, 3, 5, 7, 8, 9, 0, time.FixedZone("", -3*3600))
+
+	if got := FormatDA(ts); got != "20240305" {
+		t.Errorf("FormatDA() = %q, want 20240305", got)
+	}
+	if got := FormatTM(ts); got != "070809" {
+		t.Errorf("FormatTM() = %q, want 070809", got)
+	}
+	if got := FormatTM(ts.Add(1500 * time.Microsecond)); got != "070809.001500" {
+		t.Errorf("FormatTM() with fraction = %q, want 070809.001500", got)
+	}
+	if got := FormatDT(ts); got != "20240305070809-0300" {
+		t.Errorf("FormatDT() = %q, want 20240305070809-0300", got)
+	}
+
+	// Round trip through the dataset getters
+	ds := NewDataset()
+	tag := Tag{0x0008, 0x002A}
+	ds.AddElement(tag, VR_DT, FormatDT(ts))
+	got, ok := ds.GetDateTime(tag)
+	if !ok || !got.Equal(ts) {
+		t.Errorf("Round trip = %v (ok=%v), want %v", got, ok, ts)
+	}
+}
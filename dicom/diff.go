@@ -0,0 +1,142 @@
+package dicom
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DiffKind says how an element differs between two datasets
+type DiffKind int
+
+const (
+	DiffOnlyInA DiffKind = iota // Present in the first dataset only
+	DiffOnlyInB                 // Present in the second dataset only
+	DiffChanged                 // Present in both with a different VR or value
+)
+
+// TagDiff is one difference found by Diff
+type TagDiff struct {
+	Path string   // Location, with sequence items, e.g. "(0008,1115)[0].(0020,000e)"
+	Tag  Tag      // Tag of the differing element
+	Kind DiffKind // Whether the element is in one dataset only or changed
+	A    *Element // Element in the first dataset, nil if absent
+	B    *Element // Element in the second dataset, nil if absent
+}
+
+// String formats the difference as one line: "-" for elements only in the
+// first dataset, "+" for elements only in the second and "~" for changes
+func (d TagDiff) String() string {
+	switch d.Kind {
+	case DiffOnlyInA:
+		return fmt.Sprintf("- %s %s", d.Path, describeElement(d.A))
+	case DiffOnlyInB:
+		return fmt.Sprintf("+ %s %s", d.Path, describeElement(d.B))
+	default:
+		return fmt.Sprintf("~ %s %s -> %s", d.Path, describeElement(d.A), describeElement(d.B))
+	}
+}
+
+// Diff compares two datasets element by element, in tag order, e.g. to find
+// what an SCP changed between storing and returning an instance. Values are
+// compared as they would be encoded, so "A\B" equals []string{"A", "B"}.
+// Sequences held as []*Dataset items are compared item by item; a different
+// number of items is also reported on the sequence element itself.
+func Diff(a, b *Dataset) []TagDiff {
+	return diffDatasets(a, b, "")
+}
+
+func diffDatasets(a, b *Dataset, prefix string) []TagDiff {
+	if a == nil {
+		a = NewDataset()
+	}
+	if b == nil {
+		b = NewDataset()
+	}
+
+	tags := a.sortedTags()
+	for _, tag := range b.sortedTags() {
+		if _, ok := a.Elements[tag]; !ok {
+			tags = append(tags, tag)
+		}
+	}
+	sortTags(tags)
+
+	var diffs []TagDiff
+	for _, tag := range tags {
+		path := prefix + tag.String()
+		ea, inA := a.Elements[tag]
+		eb, inB := b.Elements[tag]
+		switch {
+		case !inB:
+			diffs = append(diffs, TagDiff{Path: path, Tag: tag, Kind: DiffOnlyInA, A: ea})
+		case !inA:
+			diffs = append(diffs, TagDiff{Path: path, Tag: tag, Kind: DiffOnlyInB, B: eb})
+		default:
+			diffs = append(diffs, diffElements(ea, eb, path)...)
+		}
+	}
+	return diffs
+}
+
+// diffElements compares two elements with the same tag
+func diffElements(a, b *Element, path string) []TagDiff {
+	changed := TagDiff{Path: path, Tag: a.Tag, Kind: DiffChanged, A: a, B: b}
+	if a.VR != b.VR {
+		return []TagDiff{changed}
+	}
+
+	itemsA, seqA := a.Value.([]*Dataset)
+	itemsB, seqB := b.Value.([]*Dataset)
+	if seqA && seqB {
+		var diffs []TagDiff
+		if len(itemsA) != len(itemsB) {
+			diffs = append(diffs, changed)
+		}
+		for i := 0; i < len(itemsA) && i < len(itemsB); i++ {
+			diffs = append(diffs, diffDatasets(itemsA[i], itemsB[i], fmt.Sprintf("%s[%d].", path, i))...)
+		}
+		return diffs
+	}
+
+	if !valuesEqual(a, b) {
+		return []TagDiff{changed}
+	}
+	return nil
+}
+
+// valuesEqual compares values by their encoding, falling back to deep
+// equality for values that are not plain element values
+func valuesEqual(a, b *Element) bool {
+	if isPlainValue(a.Value) && isPlainValue(b.Value) {
+		return bytes.Equal(encodeElementValue(a), encodeElementValue(b))
+	}
+	return reflect.DeepEqual(a.Value, b.Value)
+}
+
+func isPlainValue(value interface{}) bool {
+	switch value.(type) {
+	case string, []string, []byte, int, uint16, uint32:
+		return true
+	}
+	return false
+}
+
+// describeElement formats an element's VR and a short rendering of its value
+func describeElement(e *Element) string {
+	switch v := e.Value.(type) {
+	case []*Dataset:
+		return fmt.Sprintf("%s %d item(s)", e.VR, len(v))
+	case []byte:
+		return fmt.Sprintf("%s %d byte(s)", e.VR, len(v))
+	case *EncapsulatedPixelData:
+		return fmt.Sprintf("%s %d fragment(s)", e.VR, len(v.Fragments))
+	case []string:
+		return fmt.Sprintf("%s %q", e.VR, strings.Join(v, "\\"))
+	case string:
+		return fmt.Sprintf("%s %q", e.VR, v)
+	default:
+		return fmt.Sprintf("%s %v", e.VR, v)
+	}
+}
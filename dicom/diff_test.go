@@ -0,0 +1,69 @@
+package dicom
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	patientName := Tag{0x0010, 0x0010}
+	patientID := Tag{0x0010, 0x0020}
+	studyDescription := Tag{0x0008, 0x1030}
+	modalities := Tag{0x0008, 0x0061}
+	referencedSeries := Tag{0x0008, 0x1115}
+	seriesUID := Tag{0x0020, 0x000E}
+
+	series := func(uid string) *Dataset {
+		item := NewDataset()
+		item.AddElement(seriesUID, VR_UI, uid)
+		return item
+	}
+
+	a := NewDataset()
+	a.AddElement(patientName, VR_PN, "DOE^JOHN")
+	a.AddElement(patientID, VR_LO, "123")
+	a.AddElement(studyDescription, VR_LO, "CHEST")
+	a.AddElement(modalities, VR_CS, "CT\\MR")
+	a.AddElement(referencedSeries, VR_SQ, []*Dataset{series("1.2.1"), series("1.2.2")})
+
+	b := NewDataset()
+	b.AddElement(patientName, VR_PN, "DOE^JANE")
+	b.AddElement(patientID, VR_SH, "123")
+	b.AddElement(Tag{0x0010, 0x0040}, VR_CS, "F")
+	b.AddElement(modalities, VR_CS, []string{"CT", "MR"})
+	b.AddElement(referencedSeries, VR_SQ, []*Dataset{series("1.2.1"), series("1.2.9")})
+
+	want := []string{
+		`- (0008,1030) LO "CHEST"`,
+		`~ (0008,1115)[1].(0020,000e) UI "1.2.2" -> UI "1.2.9"`,
+		`~ (0010,0010) PN "DOE^JOHN" -> PN "DOE^JANE"`,
+		`~ (0010,0020) LO "123" -> SH "123"`,
+		`+ (0010,0040) CS "F"`,
+	}
+	diffs := Diff(a, b)
+	if len(diffs) != len(want) {
+		t.Fatalf("Diff() = %v, want %d differences", diffs, len(want))
+	}
+	for i, d := range diffs {
+		if d.String() != want[i] {
+			t.Errorf("Diff()[%d] = %s, want %s", i, d, want[i])
+		}
+	}
+	if diffs[1].Tag != seriesUID || diffs[1].Kind != DiffChanged {
+		t.Errorf("Nested difference = %+v, want a change of Series Instance UID", diffs[1])
+	}
+
+	if diffs := Diff(a, a); len(diffs) != 0 {
+		t.Errorf("Diff() of a dataset with itself = %v, want none", diffs)
+	}
+}
+
+func TestDiff_SequenceItemCount(t *testing.T) {
+	referencedSeries := Tag{0x0008, 0x1115}
+	a := NewDataset()
+	a.AddElement(referencedSeries, VR_SQ, []*Dataset{NewDataset()})
+	b := NewDataset()
+	b.AddElement(referencedSeries, VR_SQ, []*Dataset{NewDataset(), NewDataset()})
+
+	diffs := Diff(a, b)
+	if len(diffs) != 1 || diffs[0].String() != "~ (0008,1115) SQ 1 item(s) -> SQ 2 item(s)" {
+		t.Errorf("Diff() = %v, want the item count change", diffs)
+	}
+}
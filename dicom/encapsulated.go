@@ -0,0 +1,103 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	pixelDataTag         = Tag{0x7FE0, 0x0010}
+	itemTag              = Tag{0xFFFE, 0xE000}
+	sequenceDelimiterTag = Tag{0xFFFE, 0xE0DD}
+)
+
+// EncapsulatedPixelData holds Pixel Data encoded under an encapsulated
+// (compressed) transfer syntax. Fragments are kept as received; nothing is
+// decompressed.
+type EncapsulatedPixelData struct {
+	BasicOffsetTable []byte   // Value of the first item; may be empty
+	Fragments        [][]byte // Compressed fragments in order
+}
+
+// parseEncapsulatedPixelData reads the items of undefined-length Pixel Data
+// starting at offset, returning the offset after the sequence delimiter.
+func parseEncapsulatedPixelData(data []byte, offset int) (*EncapsulatedPixelData, int, error) {
+	pixelData := &EncapsulatedPixelData{}
+	first := true
+
+	for {
+		if offset+8 > len(data) {
+			return nil, 0, fmt.Errorf("encapsulated pixel data is missing its sequence delimiter")
+		}
+		tag := Tag{
+			Group:   binary.LittleEndian.Uint16(data[offset : offset+2]),
+			Element: binary.LittleEndian.Uint16(data[offset+2 : offset+4]),
+		}
+		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		offset += 8
+
+		switch tag {
+		case sequenceDelimiterTag:
+			return pixelData, offset, nil
+		case itemTag:
+			if length == undefinedLength || uint64(offset)+uint64(length) > uint64(len(data)) {
+				return nil, 0, fmt.Errorf("invalid pixel data fragment length %d", length)
+			}
+			value := data[offset : offset+int(length)]
+			if first {
+				pixelData.BasicOffsetTable = value
+				first = false
+			} else {
+				pixelData.Fragments = append(pixelData.Fragments, value)
+			}
+			offset += int(length)
+		default:
+			return nil, 0, fmt.Errorf("unexpected %s in encapsulated pixel data", tag)
+		}
+	}
+}
+
+// writeTo writes the reserved bytes, undefined length, items and sequence
+// delimiter that follow the Pixel Data tag and VR, one fragment at a time
+func (p *EncapsulatedPixelData) writeTo(w io.Writer) error {
+	header := []byte{0x00, 0x00} // Reserved
+	header = binary.LittleEndian.AppendUint32(header, undefinedLength)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	if err := writeItem(w, p.BasicOffsetTable); err != nil {
+		return err
+	}
+	for _, fragment := range p.Fragments {
+		if err := writeItem(w, fragment); err != nil {
+			return err
+		}
+	}
+
+	delimiter := binary.LittleEndian.AppendUint16(nil, sequenceDelimiterTag.Group)
+	delimiter = binary.LittleEndian.AppendUint16(delimiter, sequenceDelimiterTag.Element)
+	delimiter = binary.LittleEndian.AppendUint32(delimiter, 0)
+	_, err := w.Write(delimiter)
+	return err
+}
+
+// writeItem writes an item, padding odd-length values to even length
+func writeItem(w io.Writer, value []byte) error {
+	length := len(value) + len(value)%2
+	header := binary.LittleEndian.AppendUint16(nil, itemTag.Group)
+	header = binary.LittleEndian.AppendUint16(header, itemTag.Element)
+	header = binary.LittleEndian.AppendUint32(header, uint32(length))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		return err
+	}
+	if length != len(value) {
+		_, err := w.Write([]byte{0x00})
+		return err
+	}
+	return nil
+}
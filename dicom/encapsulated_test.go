@@ -0,0 +1,109 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestParseDatasetWithTransferSyntax_Encapsulated(t *testing.T) {
+	fragments := [][]byte{
+		{0xFF, 0x4F, 0xFF, 0x51, 0x00, 0x2F},
+		{0x01, 0x02, 0x03, 0x04, 0xFF, 0xD9},
+	}
+
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0008, 0x0018}, VR_UI, "1.2.3.4.5")
+	ds.AddElement(Tag{0x0010, 0x0010}, VR_PN, "DOE^JANE")
+	ds.AddElement(Tag{0x0028, 0x0004}, VR_CS, "YBR_ICT")
+	ds.AddElement(pixelDataTag, VR_OB, &EncapsulatedPixelData{Fragments: fragments})
+	// Elements after the pixel data must still be found
+	ds.AddElement(Tag{0x7FE1, 0x0010}, VR_LO, "VENDOR")
+
+	data := ds.EncodeDataset()
+
+	parsed, err := ParseDatasetWithTransferSyntax(data, types.JPEG2000)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	if got := parsed.GetString(Tag{0x0008, 0x0018}); got != "1.2.3.4.5" {
+		t.Errorf("SOP Instance UID = %q", got)
+	}
+	if got := parsed.GetString(Tag{0x0010, 0x0010}); got != "DOE^JANE" {
+		t.Errorf("Patient Name = %q", got)
+	}
+	if got := parsed.GetString(Tag{0x0028, 0x0004}); got != "YBR_ICT" {
+		t.Errorf("Photometric Interpretation = %q", got)
+	}
+	if got := parsed.GetString(Tag{0x7FE1, 0x0010}); got != "VENDOR" {
+		t.Errorf("Element after pixel data = %q", got)
+	}
+
+	element, ok := parsed.GetElement(pixelDataTag)
+	if !ok {
+		t.Fatal("Pixel Data not found")
+	}
+	pixelData, ok := element.Value.(*EncapsulatedPixelData)
+	if !ok {
+		t.Fatalf("Pixel Data value = %T, want *EncapsulatedPixelData", element.Value)
+	}
+	if len(pixelData.BasicOffsetTable) != 0 {
+		t.Errorf("BasicOffsetTable = %x, want empty", pixelData.BasicOffsetTable)
+	}
+	if len(pixelData.Fragments) != len(fragments) {
+		t.Fatalf("Got %d fragments, want %d", len(pixelData.Fragments), len(fragments))
+	}
+	for i := range fragments {
+		if !bytes.Equal(pixelData.Fragments[i], fragments[i]) {
+			t.Errorf("Fragment %d = %x, want %x", i, pixelData.Fragments[i], fragments[i])
+		}
+	}
+
+	// Re-encoding reproduces the original bytes
+	reencoded := parsed.EncodeDataset()
+	if !bytes.Equal(reencoded, data) {
+		t.Errorf("Re-encoded dataset differs:\n got %x\nwant %x", reencoded, data)
+	}
+}
+
+func TestParseDataset_MalformedEncapsulatedPixelData(t *testing.T) {
+	header := []byte{0xE0, 0x7F, 0x10, 0x00, 'O', 'B', 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	t.Run("MissingDelimiter", func(t *testing.T) {
+		data := append(append([]byte(nil), header...), 0xFE, 0xFF, 0x00, 0xE0, 0x00, 0x00, 0x00, 0x00)
+		if _, err := ParseDataset(data); err == nil {
+			t.Error("Expected error for missing sequence delimiter")
+		}
+	})
+
+	t.Run("FragmentOverrun", func(t *testing.T) {
+		data := append(append([]byte(nil), header...), 0xFE, 0xFF, 0x00, 0xE0)
+		data = binary.LittleEndian.AppendUint32(data, 64)
+		if _, err := ParseDataset(data); err == nil {
+			t.Error("Expected error for fragment longer than the dataset")
+		}
+	})
+}
+
+func TestParseDatasetWithTransferSyntax_Unknown(t *testing.T) {
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0010, 0x0010}, VR_PN, "DOE^JOHN")
+	data := ds.EncodeDataset()
+
+	for _, uid := range []string{"1.2.3.999", types.ExplicitVRBigEndian} {
+		if _, err := ParseDatasetWithTransferSyntax(data, uid); err == nil {
+			t.Errorf("ParseDatasetWithTransferSyntax(%s) expected error", uid)
+		}
+
+		parsed, err := ParseDatasetWithTransferSyntax(data, uid, WithLenientTransferSyntax())
+		if err != nil {
+			t.Fatalf("Lenient ParseDatasetWithTransferSyntax(%s) error = %v", uid, err)
+		}
+		if got := parsed.GetString(Tag{0x0010, 0x0010}); got != "DOE^JOHN" {
+			t.Errorf("Lenient parse Patient Name = %q", got)
+		}
+	}
+}
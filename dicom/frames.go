@@ -0,0 +1,242 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// Image Pixel module attributes used to locate frames
+var (
+	samplesPerPixelTag = Tag{0x0028, 0x0002}
+	numberOfFramesTag  = Tag{0x0028, 0x0008}
+	rowsTag            = Tag{0x0028, 0x0010}
+	columnsTag         = Tag{0x0028, 0x0011}
+	bitsAllocatedTag   = Tag{0x0028, 0x0100}
+)
+
+// ExtractFrames splits the Pixel Data of a (multi-frame) instance into
+// frames, e.g. to serve a frame list for a Composite Instance Root retrieve.
+//
+// Native pixel data is sliced into Rows×Columns×SamplesPerPixel×BitsAllocated/8
+// byte frames. Encapsulated pixel data yields one frame per fragment; when
+// there are more fragments than frames they are grouped using the Basic
+// Offset Table. The returned slices share memory with the dataset.
+func ExtractFrames(ds *Dataset, transferSyntax string) ([][]byte, error) {
+	element, ok := ds.GetElement(pixelDataTag)
+	if !ok {
+		return nil, fmt.Errorf("dataset has no pixel data")
+	}
+
+	frames := 1
+	if value, ok := intAttribute(ds, numberOfFramesTag); ok {
+		frames = value
+	}
+	if frames < 1 {
+		return nil, fmt.Errorf("invalid number of frames %d", frames)
+	}
+
+	if pixelData, ok := element.Value.(*EncapsulatedPixelData); ok {
+		return pixelData.frames(frames)
+	}
+	if types.IsEncapsulated(transferSyntax) {
+		return nil, fmt.Errorf("pixel data is not encapsulated as required by %s", transferSyntax)
+	}
+
+	var data []byte
+	switch v := element.Value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return nil, fmt.Errorf("unsupported pixel data value %T", element.Value)
+	}
+
+	frameSize, err := nativeFrameSize(ds)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < frames*frameSize {
+		return nil, fmt.Errorf("pixel data has %d bytes, %d frames of %d bytes need %d", len(data), frames, frameSize, frames*frameSize)
+	}
+
+	result := make([][]byte, frames)
+	for i := range result {
+		result[i] = data[i*frameSize : (i+1)*frameSize : (i+1)*frameSize]
+	}
+	return result, nil
+}
+
+// ValidatePixelData checks that native (uncompressed) Pixel Data holds
+// exactly the bytes the image geometry calls for:
+// NumberOfFrames×Rows×Columns×SamplesPerPixel×BitsAllocated/8, padded to an
+// even length. Datasets without Pixel Data, or under encapsulated transfer
+// syntaxes, are not checked.
+func ValidatePixelData(ds *Dataset, transferSyntax string) error {
+	element, ok := ds.GetElement(pixelDataTag)
+	if !ok || types.IsEncapsulated(transferSyntax) {
+		return nil
+	}
+
+	var length int
+	switch v := element.Value.(type) {
+	case []byte:
+		length = len(v)
+	case string:
+		length = len(v)
+	case *EncapsulatedPixelData:
+		return fmt.Errorf("pixel data is encapsulated but transfer syntax %s is not", transferSyntax)
+	default:
+		return fmt.Errorf("unsupported pixel data value %T", element.Value)
+	}
+
+	frames := 1
+	if value, ok := intAttribute(ds, numberOfFramesTag); ok {
+		frames = value
+	}
+	rows, ok := intAttribute(ds, rowsTag)
+	if !ok {
+		return fmt.Errorf("missing Rows %s", rowsTag)
+	}
+	columns, ok := intAttribute(ds, columnsTag)
+	if !ok {
+		return fmt.Errorf("missing Columns %s", columnsTag)
+	}
+	bitsAllocated, ok := intAttribute(ds, bitsAllocatedTag)
+	if !ok {
+		return fmt.Errorf("missing Bits Allocated %s", bitsAllocatedTag)
+	}
+	samples := 1
+	if value, ok := intAttribute(ds, samplesPerPixelTag); ok {
+		samples = value
+	}
+	if frames < 1 || rows < 1 || columns < 1 || samples < 1 || bitsAllocated < 1 {
+		return fmt.Errorf("invalid image geometry: %d frames of %d×%d×%d at %d bits",
+			frames, rows, columns, samples, bitsAllocated)
+	}
+
+	// Bits Allocated of 1 packs pixels, so round up to whole bytes
+	expected := (frames*rows*columns*samples*bitsAllocated + 7) / 8
+	padded := expected + expected%2
+	if length != expected && length != padded {
+		return fmt.Errorf("pixel data has %d bytes, %d frames of %d×%d×%d at %d bits need %d",
+			length, frames, rows, columns, samples, bitsAllocated, padded)
+	}
+	return nil
+}
+
+// nativeFrameSize returns the size in bytes of one uncompressed frame
+func nativeFrameSize(ds *Dataset) (int, error) {
+	rows, ok := intAttribute(ds, rowsTag)
+	if !ok {
+		return 0, fmt.Errorf("missing Rows %s", rowsTag)
+	}
+	columns, ok := intAttribute(ds, columnsTag)
+	if !ok {
+		return 0, fmt.Errorf("missing Columns %s", columnsTag)
+	}
+	bitsAllocated, ok := intAttribute(ds, bitsAllocatedTag)
+	if !ok {
+		return 0, fmt.Errorf("missing Bits Allocated %s", bitsAllocatedTag)
+	}
+	samples := 1
+	if value, ok := intAttribute(ds, samplesPerPixelTag); ok {
+		samples = value
+	}
+
+	if bitsAllocated <= 0 || bitsAllocated%8 != 0 {
+		return 0, fmt.Errorf("unsupported Bits Allocated %d", bitsAllocated)
+	}
+	size := rows * columns * samples * (bitsAllocated / 8)
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid frame size %d×%d×%d", rows, columns, samples)
+	}
+	return size, nil
+}
+
+// intAttribute reads an integer attribute stored as a binary or string value
+func intAttribute(ds *Dataset, tag Tag) (int, bool) {
+	element, ok := ds.GetElement(tag)
+	if !ok {
+		return 0, false
+	}
+	switch v := element.Value.(type) {
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case int:
+		return v, true
+	case string:
+		// IS, or a DS from senders that encode geometry as decimals ("512.0")
+		v = strings.TrimSpace(v)
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, true
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f != math.Trunc(f) {
+			return 0, false
+		}
+		return int(f), true
+	}
+	return 0, false
+}
+
+// frames groups the fragments into the given number of frames
+func (p *EncapsulatedPixelData) frames(count int) ([][]byte, error) {
+	if len(p.Fragments) == 0 {
+		return nil, fmt.Errorf("encapsulated pixel data has no fragments")
+	}
+	if len(p.Fragments) == count {
+		return p.Fragments, nil
+	}
+	if count == 1 {
+		return [][]byte{joinFragments(p.Fragments)}, nil
+	}
+	if len(p.BasicOffsetTable) != 4*count {
+		return nil, fmt.Errorf("%d fragments for %d frames and no Basic Offset Table to group them", len(p.Fragments), count)
+	}
+
+	// Offsets are relative to the first fragment's item tag; each item adds
+	// an 8-byte header
+	starts := make(map[uint32]int, len(p.Fragments))
+	position := uint32(0)
+	for i, fragment := range p.Fragments {
+		starts[position] = i
+		position += 8 + uint32(len(fragment))
+	}
+
+	bounds := make([]int, count+1)
+	for i := 0; i < count; i++ {
+		offset := binary.LittleEndian.Uint32(p.BasicOffsetTable[4*i:])
+		index, ok := starts[offset]
+		if !ok || (i > 0 && index <= bounds[i-1]) || (i == 0 && index != 0) {
+			return nil, fmt.Errorf("basic offset table entry %d (%d) does not start a fragment", i, offset)
+		}
+		bounds[i] = index
+	}
+	bounds[count] = len(p.Fragments)
+
+	result := make([][]byte, count)
+	for i := range result {
+		result[i] = joinFragments(p.Fragments[bounds[i]:bounds[i+1]])
+	}
+	return result, nil
+}
+
+// joinFragments concatenates fragments, avoiding a copy for a single one
+func joinFragments(fragments [][]byte) []byte {
+	if len(fragments) == 1 {
+		return fragments[0]
+	}
+	var joined []byte
+	for _, fragment := range fragments {
+		joined = append(joined, fragment...)
+	}
+	return joined
+}
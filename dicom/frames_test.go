@@ -0,0 +1,163 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestExtractFrames_Native(t *testing.T) {
+	// 3 frames of 2×3 8-bit monochrome pixels; zero bytes must survive parsing
+	pixels := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05,
+		0x10, 0x00, 0x12, 0x13, 0x14, 0x15,
+		0x20, 0x21, 0x22, 0x23, 0x24, 0x00,
+	}
+
+	ds := NewDataset()
+	ds.AddElement(samplesPerPixelTag, VR_US, uint16(1))
+	ds.AddElement(numberOfFramesTag, VR_IS, "3")
+	ds.AddElement(rowsTag, VR_US, uint16(2))
+	ds.AddElement(columnsTag, VR_US, uint16(3))
+	ds.AddElement(bitsAllocatedTag, VR_US, uint16(8))
+	ds.AddElement(pixelDataTag, VR_OB, pixels)
+
+	for _, ts := range []string{types.ExplicitVRLittleEndian, types.ImplicitVRLittleEndian} {
+		t.Run(ts, func(t *testing.T) {
+			encoded, err := EncodeDatasetWithTransferSyntax(ds, ts)
+			if err != nil {
+				t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+			}
+			parsed, err := ParseDatasetWithTransferSyntax(encoded, ts)
+			if err != nil {
+				t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+			}
+
+			frames, err := ExtractFrames(parsed, ts)
+			if err != nil {
+				t.Fatalf("ExtractFrames() error = %v", err)
+			}
+			if len(frames) != 3 {
+				t.Fatalf("Got %d frames, want 3", len(frames))
+			}
+			for i, frame := range frames {
+				if want := pixels[i*6 : (i+1)*6]; !bytes.Equal(frame, want) {
+					t.Errorf("Frame %d = % x, want % x", i, frame, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Short pixel data", func(t *testing.T) {
+		short := NewDataset()
+		for tag, element := range ds.Elements {
+			short.AddElement(tag, element.VR, element.Value)
+		}
+		short.AddElement(pixelDataTag, VR_OB, pixels[:12])
+		if _, err := ExtractFrames(short, types.ExplicitVRLittleEndian); err == nil {
+			t.Error("Expected error when pixel data is shorter than the frames")
+		}
+	})
+}
+
+func TestExtractFrames_Encapsulated(t *testing.T) {
+	fragments := [][]byte{
+		{0xFF, 0xD8, 0x01, 0x02, 0xFF, 0xD9},
+		{0xFF, 0xD8, 0x03, 0x04, 0x05, 0x06, 0xFF, 0xD9},
+	}
+
+	ds := NewDataset()
+	ds.AddElement(numberOfFramesTag, VR_IS, "2")
+	ds.AddElement(rowsTag, VR_US, uint16(16))
+	ds.AddElement(columnsTag, VR_US, uint16(16))
+	ds.AddElement(bitsAllocatedTag, VR_US, uint16(8))
+	ds.AddElement(pixelDataTag, VR_OB, &EncapsulatedPixelData{Fragments: fragments})
+
+	parsed, err := ParseDatasetWithTransferSyntax(ds.EncodeDataset(), types.JPEGBaseline8Bit)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	frames, err := ExtractFrames(parsed, types.JPEGBaseline8Bit)
+	if err != nil {
+		t.Fatalf("ExtractFrames() error = %v", err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("Got %d frames, want 2", len(frames))
+	}
+	for i := range fragments {
+		if !bytes.Equal(frames[i], fragments[i]) {
+			t.Errorf("Frame %d = % x, want % x", i, frames[i], fragments[i])
+		}
+	}
+
+	t.Run("Basic Offset Table", func(t *testing.T) {
+		// Frame 0 spans the first two fragments, frame 1 the third
+		split := [][]byte{fragments[0][:2], fragments[0][2:], fragments[1]}
+		table := binary.LittleEndian.AppendUint32(nil, 0)
+		table = binary.LittleEndian.AppendUint32(table, uint32(8+len(split[0])+8+len(split[1])))
+		ds.AddElement(pixelDataTag, VR_OB, &EncapsulatedPixelData{BasicOffsetTable: table, Fragments: split})
+
+		frames, err := ExtractFrames(ds, types.JPEGBaseline8Bit)
+		if err != nil {
+			t.Fatalf("ExtractFrames() error = %v", err)
+		}
+		if len(frames) != 2 || !bytes.Equal(frames[0], fragments[0]) || !bytes.Equal(frames[1], fragments[1]) {
+			t.Errorf("ExtractFrames() = % x", frames)
+		}
+
+		ds.AddElement(pixelDataTag, VR_OB, &EncapsulatedPixelData{Fragments: split})
+		if _, err := ExtractFrames(ds, types.JPEGBaseline8Bit); err == nil {
+			t.Error("Expected error grouping fragments without a Basic Offset Table")
+		}
+	})
+}
+
+func TestValidatePixelData(t *testing.T) {
+	geometry := func(rows, columns interface{}, pixels []byte) *Dataset {
+		ds := NewDataset()
+		ds.AddElement(samplesPerPixelTag, VR_US, uint16(1))
+		ds.AddElement(rowsTag, VR_US, rows)
+		ds.AddElement(columnsTag, VR_US, columns)
+		ds.AddElement(bitsAllocatedTag, VR_US, uint16(16))
+		ds.AddElement(pixelDataTag, VR_OW, pixels)
+		return ds
+	}
+
+	tests := []struct {
+		name    string
+		ds      *Dataset
+		wantErr bool
+	}{
+		{"Matching US geometry", geometry(uint16(2), uint16(3), make([]byte, 12)), false},
+		{"Matching IS/DS geometry", geometry("2", "3.0", make([]byte, 12)), false},
+		{"Short pixel data", geometry(uint16(2), uint16(3), make([]byte, 10)), true},
+		{"Long pixel data", geometry(uint16(2), uint16(3), make([]byte, 14)), true},
+		{"Unparseable rows", geometry("two", uint16(3), make([]byte, 12)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePixelData(tt.ds, types.ExplicitVRLittleEndian)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePixelData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Odd length padded to even", func(t *testing.T) {
+		ds := geometry(uint16(1), uint16(3), make([]byte, 4))
+		ds.AddElement(bitsAllocatedTag, VR_US, uint16(8))
+		if err := ValidatePixelData(ds, types.ExplicitVRLittleEndian); err != nil {
+			t.Errorf("ValidatePixelData() error = %v", err)
+		}
+	})
+
+	t.Run("Encapsulated syntaxes are not checked", func(t *testing.T) {
+		ds := geometry(uint16(2), uint16(3), make([]byte, 10))
+		if err := ValidatePixelData(ds, types.JPEGBaseline8Bit); err != nil {
+			t.Errorf("ValidatePixelData() error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package dicom
+
+import "github.com/caio-sobreiro/dicomnet/types"
+
+// fuzzTransferSyntaxes are the syntaxes FuzzParseDataset parses input in
+var fuzzTransferSyntaxes = []string{
+	types.ExplicitVRLittleEndian,
+	types.ImplicitVRLittleEndian,
+	types.DeflatedExplicitVRLittleEndian,
+	types.JPEG2000,
+}
+
+// fuzzInflationRatio is how many times its own size FuzzParseDataset lets
+// a deflated input inflate to
+const fuzzInflationRatio = 16
+
+// FuzzParseDataset parses data as a dataset in each supported transfer
+// syntax, as a Part 10 file and, when it parses, extracts its frames and
+// re-encodes it. Results are discarded. It is the entry point for fuzzers
+// and must not panic, nor allocate more than a small multiple of the input,
+// for any input.
+func FuzzParseDataset(data []byte) {
+	maxInflated := WithMaxInflatedSize(fuzzInflationRatio*int64(len(data)) + 4096)
+	for _, transferSyntax := range fuzzTransferSyntaxes {
+		ds, err := ParseDatasetWithTransferSyntax(data, transferSyntax, maxInflated)
+		if err != nil || ds == nil {
+			continue
+		}
+		_, _ = ExtractFrames(ds, transferSyntax)
+		_ = ds.EncodeDataset()
+	}
+
+	if HasPart10Header(data) {
+		if dataset, transferSyntax, err := SplitPart10(data); err == nil {
+			_, _ = ParseDatasetWithTransferSyntax(dataset, transferSyntax, WithLenientTransferSyntax(), maxInflated)
+		}
+	}
+	_, _ = Transcode(data, types.ExplicitVRLittleEndian, types.ImplicitVRLittleEndian)
+	_, _ = Transcode(data, types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian)
+}
@@ -0,0 +1,64 @@
+package dicom
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// deflateBomb returns a deflate stream inflating to n zero bytes
+func deflateBomb(n int) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(make([]byte, n))
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+// fuzzSeeds are well-formed datasets covering sequences, encapsulated Pixel
+// Data and Part 10 files, plus truncated and oversized variants
+func fuzzSeeds() [][]byte {
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0008, 0x0018}, VR_UI, "1.2.3.4.5")
+	ds.AddElement(Tag{0x0010, 0x0010}, VR_PN, "DOE^JANE")
+	ds.AddElement(Tag{0x0028, 0x0010}, VR_US, uint16(2))
+	item := NewDataset()
+	item.AddElement(Tag{0x0008, 0x1155}, VR_UI, "1.2.3")
+	ds.AddElement(Tag{0x0008, 0x1115}, VR_SQ, []*Dataset{item})
+	explicit := ds.EncodeDataset()
+	implicit, _ := EncodeDatasetWithTransferSyntax(ds, types.ImplicitVRLittleEndian)
+
+	encapsulated := NewDataset()
+	encapsulated.AddElement(pixelDataTag, VR_OB, &EncapsulatedPixelData{Fragments: [][]byte{{0xFF, 0xD8}, {0xFF, 0xD9}}})
+
+	meta := NewDataset()
+	meta.AddElement(transferSyntaxUIDTag, VR_UI, types.ExplicitVRLittleEndian)
+	part10 := append(make([]byte, 128), "DICM"...)
+	part10 = append(part10, meta.EncodeDataset()...)
+	part10 = append(part10, explicit...)
+
+	return [][]byte{
+		explicit,
+		implicit,
+		encapsulated.EncodeDataset(),
+		part10,
+		explicit[:len(explicit)/2],
+		// Long VR element claiming 4 GiB
+		{0x08, 0x00, 0x18, 0x00, 'O', 'B', 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFE},
+		// Undefined-length sequence with no delimiter
+		{0x08, 0x00, 0x15, 0x11, 'S', 'Q', 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE, 0xFF, 0x00, 0xE0},
+		// Deflate stream inflating to 16 MiB
+		deflateBomb(16 << 20),
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzParseDataset(data)
+	})
+}
@@ -0,0 +1,100 @@
+package dicom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MissingAttributeError reports a required attribute that is absent, or a
+// Type 1 attribute that is present but empty
+type MissingAttributeError struct {
+	Tag   Tag
+	Name  string
+	Type  int  // 1 for attributes that must have a value, 2 for attributes that must be present
+	Empty bool // Present without a value
+}
+
+func (e *MissingAttributeError) Error() string {
+	if e.Empty {
+		return fmt.Sprintf("empty Type %d attribute %s %s", e.Type, e.Name, e.Tag)
+	}
+	return fmt.Sprintf("missing Type %d attribute %s %s", e.Type, e.Name, e.Tag)
+}
+
+// iodAttribute is an attribute required by every composite IOD
+type iodAttribute struct {
+	tag      Tag
+	name     string
+	typ      int
+	forImage bool // Only required when the dataset has Pixel Data
+}
+
+// minimalIODAttributes are the attributes of the SOP Common, Patient,
+// General Study, General Series and Image Pixel modules that every
+// composite instance must carry
+var minimalIODAttributes = []iodAttribute{
+	{tag: Tag{0x0008, 0x0016}, name: "SOP Class UID", typ: 1},
+	{tag: Tag{0x0008, 0x0018}, name: "SOP Instance UID", typ: 1},
+	{tag: Tag{0x0008, 0x0060}, name: "Modality", typ: 1},
+	{tag: Tag{0x0010, 0x0010}, name: "Patient's Name", typ: 2},
+	{tag: Tag{0x0010, 0x0020}, name: "Patient ID", typ: 2},
+	{tag: Tag{0x0020, 0x000D}, name: "Study Instance UID", typ: 1},
+	{tag: Tag{0x0020, 0x000E}, name: "Series Instance UID", typ: 1},
+	{tag: rowsTag, name: "Rows", typ: 1, forImage: true},
+	{tag: columnsTag, name: "Columns", typ: 1, forImage: true},
+	{tag: bitsAllocatedTag, name: "Bits Allocated", typ: 1, forImage: true},
+}
+
+// ValidateMinimalIOD checks that a dataset carries the attributes every
+// composite instance requires: Type 1 attributes present with a value and
+// Type 2 attributes present, possibly empty. The Image Pixel attributes are
+// only required when the dataset has Pixel Data. When sopClassUID is given,
+// the dataset's SOP Class UID must also match it.
+//
+// It returns one error per violation, *MissingAttributeError for missing or
+// empty attributes, or nil when the dataset is valid. This is not a full IOD
+// validation; modality-specific modules are not checked.
+func ValidateMinimalIOD(ds *Dataset, sopClassUID string) []error {
+	if ds == nil {
+		ds = NewDataset()
+	}
+	_, hasPixelData := ds.GetElement(pixelDataTag)
+
+	var errs []error
+	for _, attr := range minimalIODAttributes {
+		if attr.forImage && !hasPixelData {
+			continue
+		}
+		element, ok := ds.GetElement(attr.tag)
+		switch {
+		case !ok:
+			errs = append(errs, &MissingAttributeError{Tag: attr.tag, Name: attr.name, Type: attr.typ})
+		case attr.typ == 1 && isEmptyValue(element.Value):
+			errs = append(errs, &MissingAttributeError{Tag: attr.tag, Name: attr.name, Type: attr.typ, Empty: true})
+		}
+	}
+
+	if sopClassUID != "" {
+		if got := ds.GetString(Tag{0x0008, 0x0016}); got != "" && got != sopClassUID {
+			errs = append(errs, fmt.Errorf("SOP Class UID %s does not match %s", got, sopClassUID))
+		}
+	}
+	return errs
+}
+
+// isEmptyValue reports whether an element value holds nothing but padding
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.Trim(v, " \x00") == ""
+	case []string:
+		return len(v) == 0 || strings.Trim(strings.Join(v, ""), " \x00") == ""
+	case []byte:
+		return len(v) == 0
+	case []*Dataset:
+		return len(v) == 0
+	}
+	return false
+}
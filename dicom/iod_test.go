@@ -0,0 +1,85 @@
+package dicom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func minimalCTDataset() *Dataset {
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0008, 0x0016}, VR_UI, types.CTImageStorage)
+	ds.AddElement(Tag{0x0008, 0x0018}, VR_UI, "1.2.3.4")
+	ds.AddElement(Tag{0x0008, 0x0060}, VR_CS, "CT")
+	ds.AddElement(Tag{0x0010, 0x0010}, VR_PN, "") // Type 2: may be empty
+	ds.AddElement(Tag{0x0010, 0x0020}, VR_LO, "PID1")
+	ds.AddElement(Tag{0x0020, 0x000D}, VR_UI, "1.2.3")
+	ds.AddElement(Tag{0x0020, 0x000E}, VR_UI, "1.2.3.1")
+	ds.AddElement(rowsTag, VR_US, uint16(2))
+	ds.AddElement(columnsTag, VR_US, uint16(2))
+	ds.AddElement(bitsAllocatedTag, VR_US, uint16(8))
+	ds.AddElement(pixelDataTag, VR_OB, make([]byte, 4))
+	return ds
+}
+
+func TestValidateMinimalIOD_Complete(t *testing.T) {
+	// Round trip through the codec, as a storage SCP would see it
+	parsed, err := ParseDataset(minimalCTDataset().EncodeDataset())
+	if err != nil {
+		t.Fatalf("ParseDataset() error = %v", err)
+	}
+	if errs := ValidateMinimalIOD(parsed, types.CTImageStorage); errs != nil {
+		t.Errorf("ValidateMinimalIOD() = %v, want no errors", errs)
+	}
+}
+
+func TestValidateMinimalIOD_Incomplete(t *testing.T) {
+	ds := minimalCTDataset()
+	delete(ds.Elements, Tag{0x0020, 0x000E})      // Series Instance UID missing
+	delete(ds.Elements, Tag{0x0010, 0x0010})      // Type 2 Patient's Name missing
+	ds.AddElement(Tag{0x0020, 0x000D}, VR_UI, "") // Study Instance UID empty
+	delete(ds.Elements, rowsTag)                  // Required with Pixel Data
+	ds.AddElement(Tag{0x0008, 0x0016}, VR_UI, types.MRImageStorage)
+
+	errs := ValidateMinimalIOD(ds, types.CTImageStorage)
+
+	want := map[Tag]bool{ // Tag -> empty
+		{0x0010, 0x0010}: false,
+		{0x0020, 0x000D}: true,
+		{0x0020, 0x000E}: false,
+		rowsTag:          false,
+	}
+	var missing []*MissingAttributeError
+	mismatch := 0
+	for _, err := range errs {
+		var m *MissingAttributeError
+		if errors.As(err, &m) {
+			missing = append(missing, m)
+		} else {
+			mismatch++
+		}
+	}
+	if len(missing) != len(want) {
+		t.Fatalf("ValidateMinimalIOD() = %v, want %d missing attributes", errs, len(want))
+	}
+	for _, m := range missing {
+		empty, ok := want[m.Tag]
+		if !ok || m.Empty != empty {
+			t.Errorf("unexpected %v", m)
+		}
+	}
+	if mismatch != 1 {
+		t.Errorf("got %d non-attribute errors, want 1 SOP Class mismatch: %v", mismatch, errs)
+	}
+}
+
+func TestValidateMinimalIOD_NoPixelData(t *testing.T) {
+	ds := minimalCTDataset()
+	for _, tag := range []Tag{rowsTag, columnsTag, bitsAllocatedTag, pixelDataTag} {
+		delete(ds.Elements, tag)
+	}
+	if errs := ValidateMinimalIOD(ds, ""); errs != nil {
+		t.Errorf("ValidateMinimalIOD() without Pixel Data = %v, want no errors", errs)
+	}
+}
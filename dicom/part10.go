@@ -1,9 +1,13 @@
 package dicom
 
 import (
+	"encoding/binary"
 	"fmt"
 	"log/slog"
+	"os"
 	"strings"
+
+	"github.com/caio-sobreiro/dicomnet/types"
 )
 
 // StripPart10Header removes the DICOM Part 10 preamble and File Meta Information
@@ -35,85 +39,83 @@ import (
 //	}
 //	// Now datasetOnly can be sent via C-STORE
 func StripPart10Header(data []byte) ([]byte, error) {
+	dataset, _, err := SplitPart10(data)
+	return dataset, err
+}
+
+// SplitPart10 separates a DICOM Part 10 file into its dataset and the
+// Transfer Syntax UID (0002,0010) recorded in the File Meta Information.
+//
+// The returned transfer syntax is empty if the meta information does not
+// contain one. Use HasPart10Header first when the input may be a raw dataset.
+func SplitPart10(data []byte) ([]byte, string, error) {
+	meta, offset, err := ParseFileMetaInfo(data)
+	if err != nil {
+		return nil, "", err
+	}
+	transferSyntaxUID := meta.GetString(transferSyntaxUIDTag)
+
+	if transferSyntaxUID != "" {
+		slog.Debug("Found Transfer Syntax UID in File Meta Information",
+			"transfer_syntax", transferSyntaxUID,
+			"dataset_start_offset", offset)
+	}
+
+	if offset >= len(data) {
+		return nil, "", fmt.Errorf("failed to find dataset after File Meta Information")
+	}
+
+	return data[offset:], transferSyntaxUID, nil
+}
+
+// transferSyntaxUIDTag is Transfer Syntax UID (0002,0010)
+var transferSyntaxUIDTag = Tag{0x0002, 0x0010}
+
+// ParseFileMetaInfo parses the File Meta Information (group 0002) of a Part
+// 10 file and returns it together with the offset at which the dataset starts.
+//
+// The meta information is always Explicit VR Little Endian. Elements are
+// walked one by one, honouring the 4-byte length of long VRs such as OB, so
+// private or unusual meta elements before (0002,0010) are skipped correctly.
+func ParseFileMetaInfo(data []byte) (*Dataset, int, error) {
 	if len(data) < 132 {
-		return nil, fmt.Errorf("data too short to be DICOM Part 10 (need at least 132 bytes, got %d)", len(data))
+		return nil, 0, fmt.Errorf("data too short to be DICOM Part 10 (need at least 132 bytes, got %d)", len(data))
 	}
 
 	// Check for DICM prefix at offset 128
 	if string(data[128:132]) != "DICM" {
-		return nil, fmt.Errorf("not a valid DICOM Part 10 file (missing DICM prefix at offset 128)")
+		return nil, 0, fmt.Errorf("not a valid DICOM Part 10 file (missing DICM prefix at offset 128)")
 	}
 
-	// Skip preamble (128) + DICM (4) = start at offset 132
+	meta := NewDataset()
 	offset := 132
-
-	var transferSyntaxUID string
-
-	// Skip all group 0x0002 elements (File Meta Information)
 	for offset+8 <= len(data) {
-		group := uint16(data[offset]) | (uint16(data[offset+1]) << 8)
-		element := uint16(data[offset+2]) | (uint16(data[offset+3]) << 8)
-
-		// If we've passed group 0x0002, we're at the dataset
+		group := binary.LittleEndian.Uint16(data[offset : offset+2])
 		if group != 0x0002 {
-			break
+			break // Past the meta information: the dataset starts here
 		}
-
-		// Read VR (2 bytes)
+		tag := Tag{Group: group, Element: binary.LittleEndian.Uint16(data[offset+2 : offset+4])}
 		vr := string(data[offset+4 : offset+6])
 
-		var length uint32
-		var valueOffset int
-
-		// Some VRs use different length encoding
-		if vr == "OB" || vr == "OW" || vr == "OF" || vr == "SQ" || vr == "UN" || vr == "UT" {
-			// Explicit VR with 32-bit length
-			offset += 8 // Skip tag (4) + VR (2) + reserved (2)
-			if offset+4 > len(data) {
-				break
+		var length, valueOffset int
+		if isLongVR(vr) {
+			if offset+12 > len(data) {
+				return nil, 0, fmt.Errorf("file meta element %s truncated", tag)
 			}
-			length = uint32(data[offset]) | (uint32(data[offset+1]) << 8) |
-				(uint32(data[offset+2]) << 16) | (uint32(data[offset+3]) << 24)
-			offset += 4
-			valueOffset = offset
+			length = int(binary.LittleEndian.Uint32(data[offset+8 : offset+12]))
+			valueOffset = offset + 12
 		} else {
-			// Explicit VR with 16-bit length
-			offset += 6 // Skip tag (4) + VR (2)
-			if offset+2 > len(data) {
-				break
-			}
-			length = uint32(data[offset]) | (uint32(data[offset+1]) << 8)
-			offset += 2
-			valueOffset = offset
+			length = int(binary.LittleEndian.Uint16(data[offset+6 : offset+8]))
+			valueOffset = offset + 8
 		}
 
-		// Check if this is Transfer Syntax UID (0002,0010)
-		if group == 0x0002 && element == 0x0010 {
-			if valueOffset+int(length) <= len(data) {
-				transferSyntaxUID = string(data[valueOffset : valueOffset+int(length)])
-				// Remove any padding
-				transferSyntaxUID = strings.TrimRight(transferSyntaxUID, "\x00 ")
-			}
-		}
-
-		// Skip value
-		offset += int(length)
-		if offset > len(data) {
-			break
+		if length < 0 || valueOffset+length > len(data) {
+			return nil, 0, fmt.Errorf("file meta element %s length %d exceeds data", tag, length)
 		}
+		meta.AddElement(tag, vr, parseElementValue(tag, vr, data[valueOffset:valueOffset+length]))
+		offset = valueOffset + length
 	}
-
-	if transferSyntaxUID != "" {
-		slog.Debug("Found Transfer Syntax UID in File Meta Information",
-			"transfer_syntax", transferSyntaxUID,
-			"dataset_start_offset", offset)
-	}
-
-	if offset >= len(data) {
-		return nil, fmt.Errorf("failed to find dataset after File Meta Information")
-	}
-
-	return data[offset:], nil
+	return meta, offset, nil
 }
 
 // HasPart10Header checks if the data starts with a DICOM Part 10 header.
@@ -125,3 +127,135 @@ func HasPart10Header(data []byte) bool {
 	}
 	return string(data[128:132]) == "DICM"
 }
+
+// EncodePart10 wraps a dataset in a Part 10 preamble and File Meta
+// Information recording its SOP class, SOP instance and transfer syntax, so it
+// can be written to disk as a DICOM file.
+func EncodePart10(dataset []byte, sopClassUID, sopInstanceUID, transferSyntaxUID string) []byte {
+	meta := NewDataset()
+	meta.AddElement(Tag{0x0002, 0x0001}, VR_OB, []byte{0x00, 0x01}) // File Meta Information Version
+	meta.AddElement(Tag{0x0002, 0x0002}, VR_UI, sopClassUID)
+	meta.AddElement(Tag{0x0002, 0x0003}, VR_UI, sopInstanceUID)
+	meta.AddElement(Tag{0x0002, 0x0010}, VR_UI, transferSyntaxUID)
+	meta.AddElement(Tag{0x0002, 0x0012}, VR_UI, types.ImplementationClassUID)
+	meta.AddElement(Tag{0x0002, 0x0013}, VR_SH, types.ImplementationVersionName)
+	metaBytes := meta.EncodeDataset()
+
+	groupLength := NewDataset()
+	groupLength.AddElement(Tag{0x0002, 0x0000}, VR_UL, uint32(len(metaBytes)))
+
+	data := make([]byte, 128, 132+12+len(metaBytes)+len(dataset))
+	data = append(data, "DICM"...)
+	data = append(data, groupLength.EncodeDataset()...)
+	data = append(data, metaBytes...)
+	return append(data, dataset...)
+}
+
+// WriteFileOptions controls how WriteFile writes a dataset
+type WriteFileOptions struct {
+	// TransferSyntaxUID encodes the dataset (default: Explicit VR Little
+	// Endian). It must be one CanEncodeTransferSyntax accepts.
+	TransferSyntaxUID string
+
+	// EnsureCharacterSet sets Specific Character Set (0008,0005) to ISO_IR
+	// 192 when any text value is non-ASCII, so the UTF-8 values are read
+	// back correctly. A dataset whose text is all ASCII is left unchanged.
+	EnsureCharacterSet bool
+}
+
+// specificCharacterSetTag is Specific Character Set (0008,0005)
+var specificCharacterSetTag = Tag{0x0008, 0x0005}
+
+// WriteFile writes a dataset to path as a DICOM Part 10 file, with File Meta
+// Information taken from its SOP Class UID (0008,0016) and SOP Instance UID
+// (0008,0018). The dataset itself is not modified.
+func WriteFile(path string, dataset *Dataset, opts WriteFileOptions) error {
+	if dataset == nil {
+		return fmt.Errorf("cannot write a nil dataset to %s", path)
+	}
+	transferSyntaxUID := opts.TransferSyntaxUID
+	if transferSyntaxUID == "" {
+		transferSyntaxUID = TransferSyntaxExplicitVRLittleEndian
+	}
+	if !CanEncodeTransferSyntax(transferSyntaxUID) {
+		return fmt.Errorf("cannot write %s in transfer syntax %s", path, transferSyntaxUID)
+	}
+
+	if opts.EnsureCharacterSet && hasNonASCIIText(dataset) &&
+		dataset.GetString(specificCharacterSetTag) != "ISO_IR 192" {
+		withCharset := &Dataset{Elements: make(map[Tag]*Element, len(dataset.Elements)+1)}
+		for tag, element := range dataset.Elements {
+			withCharset.Elements[tag] = element
+		}
+		withCharset.AddElement(specificCharacterSetTag, VR_CS, "ISO_IR 192")
+		dataset = withCharset
+	}
+
+	data, err := EncodeDatasetWithTransferSyntax(dataset, transferSyntaxUID)
+	if err != nil {
+		return err
+	}
+	file := EncodePart10(data, dataset.GetString(Tag{0x0008, 0x0016}), dataset.GetString(Tag{0x0008, 0x0018}), transferSyntaxUID)
+	return os.WriteFile(path, file, 0o644)
+}
+
+// ReadFile reads a DICOM Part 10 file and parses its dataset in the transfer
+// syntax recorded in the File Meta Information, which is returned with it.
+func ReadFile(path string) (*Dataset, string, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, transferSyntaxUID, err := SplitPart10(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	dataset, err := ParseDatasetWithTransferSyntax(data, transferSyntaxUID)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return dataset, transferSyntaxUID, nil
+}
+
+// hasNonASCIIText reports whether any text value affected by Specific
+// Character Set, including those in sequence items, is non-ASCII
+func hasNonASCIIText(dataset *Dataset) bool {
+	for _, element := range dataset.Elements {
+		switch v := element.Value.(type) {
+		case []*Dataset:
+			for _, item := range v {
+				if item != nil && hasNonASCIIText(item) {
+					return true
+				}
+			}
+		case string:
+			if isCharacterSetVR(element.VR) && !isASCII(v) {
+				return true
+			}
+		case []string:
+			if isCharacterSetVR(element.VR) && !isASCII(strings.Join(v, "")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCharacterSetVR reports whether values of the VR are encoded in the
+// Specific Character Set rather than the default repertoire
+func isCharacterSetVR(vr string) bool {
+	switch vr {
+	case VR_SH, VR_LO, VR_ST, VR_LT, VR_UC, VR_UT, VR_PN:
+		return true
+	}
+	return false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,8 @@ package dicom
 import (
 	"bytes"
 	"encoding/binary"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -253,3 +255,142 @@ func TestHasPart10Header_RawDataset(t *testing.T) {
 		t.Error("Expected HasPart10Header to return false for raw dataset")
 	}
 }
+
+func TestSplitPart10_ReturnsTransferSyntax(t *testing.T) {
+	dataset, transferSyntax, err := SplitPart10(createValidPart10File())
+	if err != nil {
+		t.Fatalf("SplitPart10() error = %v", err)
+	}
+
+	if transferSyntax != TransferSyntaxExplicitVRLittleEndian {
+		t.Errorf("Transfer syntax = %q, want %q", transferSyntax, TransferSyntaxExplicitVRLittleEndian)
+	}
+
+	parsed, err := ParseDatasetWithTransferSyntax(dataset, transferSyntax)
+	if err != nil {
+		t.Fatalf("Failed to parse dataset: %v", err)
+	}
+	if name := parsed.GetString(Tag{0x0010, 0x0010}); name != "TEST^PATIENT" {
+		t.Errorf("Patient name = %q, want TEST^PATIENT", name)
+	}
+}
+
+func TestSplitPart10_RawDataset(t *testing.T) {
+	raw := createValidPart10File()[132:]
+
+	if HasPart10Header(raw) {
+		t.Fatal("Raw dataset should not report a Part 10 header")
+	}
+	if _, _, err := SplitPart10(raw); err == nil {
+		t.Error("Expected error splitting a raw dataset")
+	}
+}
+
+func TestParseFileMetaInfo_LongVRBeforeTransferSyntax(t *testing.T) {
+	data := append(make([]byte, 128), "DICM"...)
+
+	appendShort := func(element uint16, vr, value string) {
+		data = binary.LittleEndian.AppendUint16(data, 0x0002)
+		data = binary.LittleEndian.AppendUint16(data, element)
+		data = append(data, vr...)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(value)))
+		data = append(data, value...)
+	}
+
+	// Private Information (0002,0102) is OB with a 4-byte length. Its value
+	// mimics a Transfer Syntax UID element header to trip byte scanners.
+	private := []byte{0x02, 0x00, 0x10, 0x00, 'U', 'I', 0x04, 0x00, '9', '.', '9', 0x00}
+	private = append(private, make([]byte, 300)...)
+	data = binary.LittleEndian.AppendUint16(data, 0x0002)
+	data = binary.LittleEndian.AppendUint16(data, 0x0102)
+	data = append(data, 'O', 'B', 0x00, 0x00)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(private)))
+	data = append(data, private...)
+
+	appendShort(0x0010, "UI", TransferSyntaxExplicitVRLittleEndian+"\x00")
+	metaEnd := len(data)
+	data = append(data, 0x10, 0x00, 0x10, 0x00, 'P', 'N', 0x04, 0x00, 'T', 'E', 'S', 'T')
+
+	meta, offset, err := ParseFileMetaInfo(data)
+	if err != nil {
+		t.Fatalf("ParseFileMetaInfo() error = %v", err)
+	}
+	if offset != metaEnd {
+		t.Errorf("Dataset offset = %d, want %d", offset, metaEnd)
+	}
+	if got := meta.GetString(Tag{0x0002, 0x0010}); got != TransferSyntaxExplicitVRLittleEndian {
+		t.Errorf("Transfer syntax = %q, want %q", got, TransferSyntaxExplicitVRLittleEndian)
+	}
+
+	_, transferSyntax, err := SplitPart10(data)
+	if err != nil || transferSyntax != TransferSyntaxExplicitVRLittleEndian {
+		t.Errorf("SplitPart10() = %q, %v; want %q", transferSyntax, err, TransferSyntaxExplicitVRLittleEndian)
+	}
+}
+
+func TestParseFileMetaInfo_Truncated(t *testing.T) {
+	data := append(make([]byte, 128), "DICM"...)
+	data = append(data, 0x02, 0x00, 0x01, 0x00, 'O', 'B', 0x00, 0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x01)
+
+	if _, _, err := ParseFileMetaInfo(data); err == nil {
+		t.Error("Expected error for a meta element longer than the data")
+	}
+}
+
+func TestWriteFile_UnsupportedTransferSyntax(t *testing.T) {
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0008, 0x0016}, VR_UI, "1.2.840.10008.5.1.4.1.1.7")
+	ds.AddElement(Tag{0x0008, 0x0018}, VR_UI, "1.2.3.4")
+
+	path := filepath.Join(t.TempDir(), "jpeg.dcm")
+	// JPEG Baseline would need the pixel data encapsulated
+	if err := WriteFile(path, ds, WriteFileOptions{TransferSyntaxUID: "1.2.840.10008.1.2.4.50"}); err == nil {
+		t.Fatal("Expected error for a transfer syntax that cannot be encoded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File written despite the error (stat error %v)", err)
+	}
+}
+
+func TestWriteFile_EnsureCharacterSet(t *testing.T) {
+	patientName := Tag{0x0010, 0x0010}
+	ds := NewDataset()
+	ds.AddElement(Tag{0x0008, 0x0016}, VR_UI, "1.2.840.10008.5.1.4.1.1.7")
+	ds.AddElement(Tag{0x0008, 0x0018}, VR_UI, "1.2.3.4")
+	ds.AddElement(patientName, VR_PN, "Müller^Zoë")
+
+	path := filepath.Join(t.TempDir(), "accented.dcm")
+	if err := WriteFile(path, ds, WriteFileOptions{EnsureCharacterSet: true}); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, ok := ds.GetElement(specificCharacterSetTag); ok {
+		t.Error("WriteFile() modified the caller's dataset")
+	}
+
+	read, transferSyntaxUID, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if transferSyntaxUID != TransferSyntaxExplicitVRLittleEndian {
+		t.Errorf("Transfer syntax = %s, want Explicit VR Little Endian", transferSyntaxUID)
+	}
+	if got := read.GetString(specificCharacterSetTag); got != "ISO_IR 192" {
+		t.Errorf("Specific Character Set = %q, want ISO_IR 192", got)
+	}
+	if got := read.GetString(patientName); got != "Müller^Zoë" {
+		t.Errorf("Patient Name = %q, want Müller^Zoë", got)
+	}
+
+	// ASCII-only datasets stay without a character set
+	ds.AddElement(patientName, VR_PN, "Muller^Zoe")
+	if err := WriteFile(path, ds, WriteFileOptions{EnsureCharacterSet: true}); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	read, _, err = ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if _, ok := read.GetElement(specificCharacterSetTag); ok {
+		t.Errorf("Specific Character Set added to an ASCII-only dataset")
+	}
+}
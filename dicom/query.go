@@ -0,0 +1,125 @@
+package dicom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// queryRetrieveLevelTag is Query/Retrieve Level (0008,0052)
+var queryRetrieveLevelTag = Tag{0x0008, 0x0052}
+
+// Unique keys of the Study Root information model
+var (
+	studyInstanceUIDTag  = Tag{0x0020, 0x000D}
+	seriesInstanceUIDTag = Tag{0x0020, 0x000E}
+	sopInstanceUIDTag    = Tag{0x0008, 0x0018}
+)
+
+// NewQuery builds a C-FIND identifier for the given Query/Retrieve Level.
+// Each return key is added with a zero-length value, which requests universal
+// matching: every match is returned with that attribute filled in. Matching
+// keys can be added afterwards with AddElement.
+func NewQuery(level string, returnKeys ...Tag) *Dataset {
+	query := NewDataset()
+	query.AddElement(queryRetrieveLevelTag, VR_CS, level)
+	for _, tag := range returnKeys {
+		query.AddUniversalKey(tag)
+	}
+	return query
+}
+
+// NewRetrieveIdentifier builds a C-MOVE or C-GET identifier for the STUDY,
+// SERIES or IMAGE Query/Retrieve Level. The unique keys of the level and
+// every level above it are required; keys below the level must be empty
+// (PS3.4 C.4.2.2.1). A key at the retrieve level may list several UIDs
+// separated by backslashes.
+func NewRetrieveIdentifier(level, studyUID, seriesUID, sopUID string) (*Dataset, error) {
+	level = strings.ToUpper(strings.TrimSpace(level))
+
+	var depth int
+	switch level {
+	case "STUDY":
+		depth = 1
+	case "SERIES":
+		depth = 2
+	case "IMAGE":
+		depth = 3
+	default:
+		return nil, fmt.Errorf("unsupported retrieve level %q", level)
+	}
+
+	keys := []struct {
+		tag  Tag
+		name string
+		uid  string
+	}{
+		{studyInstanceUIDTag, "Study Instance UID", studyUID},
+		{seriesInstanceUIDTag, "Series Instance UID", seriesUID},
+		{sopInstanceUIDTag, "SOP Instance UID", sopUID},
+	}
+
+	identifier := NewDataset()
+	identifier.AddElement(queryRetrieveLevelTag, VR_CS, level)
+	for i, key := range keys {
+		if i >= depth {
+			if key.uid != "" {
+				return nil, fmt.Errorf("%s-level identifier cannot specify %s %s", level, key.name, key.tag)
+			}
+			continue
+		}
+		if key.uid == "" {
+			return nil, fmt.Errorf("%s-level identifier requires %s %s", level, key.name, key.tag)
+		}
+		identifier.AddElement(key.tag, VR_UI, key.uid)
+	}
+	return identifier, nil
+}
+
+// AddUniversalKey adds tag as a return key with universal matching (an empty
+// value, encoded with length 0)
+func (d *Dataset) AddUniversalKey(tag Tag) {
+	d.AddElement(tag, determineVR(tag), "")
+}
+
+// IsUniversalKey reports whether tag is present with an empty value, i.e. is
+// a universal matching key
+func (d *Dataset) IsUniversalKey(tag Tag) bool {
+	element, ok := d.GetElement(tag)
+	if !ok {
+		return false
+	}
+	value, ok := element.Value.(string)
+	return ok && value == ""
+}
+
+// MatchWildcard reports whether value matches a C-FIND matching key that may
+// use wildcards (PS3.4 C.2.2.2.4): "*" matches any run of characters,
+// including none, and "?" matches exactly one. Trailing padding of either
+// side is ignored, and matching is case-sensitive.
+func MatchWildcard(pattern, value string) bool {
+	p := []rune(strings.TrimRight(pattern, " \x00"))
+	v := []rune(strings.TrimRight(value, " \x00"))
+
+	// Greedy matching, backtracking to the most recent "*"
+	pi, vi := 0, 0
+	star, starValue := -1, 0
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case pi < len(p) && p[pi] == '*':
+			star, starValue = pi, vi
+			pi++
+		case star >= 0:
+			starValue++
+			pi, vi = star+1, starValue
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
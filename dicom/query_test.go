@@ -0,0 +1,202 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestNewQuery_UniversalKeys(t *testing.T) {
+	patientName := Tag{0x0010, 0x0010}
+	studyDate := Tag{0x0008, 0x0020}
+	modality := Tag{0x0008, 0x0060}
+	studyUID := Tag{0x0020, 0x000D}
+	privateTag := Tag{0x0009, 0x1001}
+
+	query := NewQuery("STUDY", patientName, studyDate, studyUID, privateTag)
+	query.AddElement(modality, VR_CS, "CT")
+
+	for _, ts := range []string{TransferSyntaxExplicitVRLittleEndian, TransferSyntaxImplicitVRLittleEndian} {
+		t.Run(ts, func(t *testing.T) {
+			encoded, err := EncodeDatasetWithTransferSyntax(query, ts)
+			if err != nil {
+				t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+			}
+
+			// Universal keys are emitted with length 0 and no padding
+			var header []byte
+			header = binary.LittleEndian.AppendUint16(header, patientName.Group)
+			header = binary.LittleEndian.AppendUint16(header, patientName.Element)
+			if ts == TransferSyntaxExplicitVRLittleEndian {
+				header = append(header, VR_PN...)
+				header = append(header, 0x00, 0x00)
+			} else {
+				header = append(header, 0x00, 0x00, 0x00, 0x00)
+			}
+			next := binary.LittleEndian.AppendUint16(nil, studyUID.Group) // Study Instance UID follows
+			if !bytes.Contains(encoded, append(header, next...)) {
+				t.Errorf("Patient Name not encoded as a zero-length element: % x", encoded)
+			}
+
+			parsed, err := ParseDatasetWithTransferSyntax(encoded, ts)
+			if err != nil {
+				t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+			}
+
+			if level := parsed.GetString(queryRetrieveLevelTag); level != "STUDY" {
+				t.Errorf("Query/Retrieve Level = %q, want STUDY", level)
+			}
+			for _, tag := range []Tag{patientName, studyDate, studyUID, privateTag} {
+				if !parsed.IsUniversalKey(tag) {
+					element, _ := parsed.GetElement(tag)
+					t.Errorf("%s is not a universal key after round trip: %+v", tag, element)
+				}
+			}
+			if parsed.IsUniversalKey(modality) || parsed.GetString(modality) != "CT" {
+				t.Errorf("Modality = %q, want matching key CT", parsed.GetString(modality))
+			}
+			if parsed.IsUniversalKey(Tag{0x0010, 0x0020}) {
+				t.Error("Absent key reported as universal")
+			}
+		})
+	}
+}
+
+func TestUniversalKeys_ImplicitVRRoundTrip(t *testing.T) {
+	keys := []Tag{
+		{0x0008, 0x0020}, // Study Date (DA)
+		{0x0008, 0x0061}, // Modalities in Study (CS)
+		{0x0010, 0x0010}, // Patient Name (PN)
+		{0x0010, 0x0020}, // Patient ID (LO)
+		{0x0020, 0x000D}, // Study Instance UID (UI)
+		{0x0028, 0x0010}, // Rows (US)
+		{0x0020, 0x1208}, // Number of Study Related Instances, last in tag order
+	}
+	query := NewQuery("STUDY", keys...)
+
+	encoded, err := EncodeDatasetWithTransferSyntax(query, TransferSyntaxImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	// Every key is encoded as an 8-byte header with length 0 and no padding
+	for _, tag := range keys {
+		header := binary.LittleEndian.AppendUint16(nil, tag.Group)
+		header = binary.LittleEndian.AppendUint16(header, tag.Element)
+		header = append(header, 0x00, 0x00, 0x00, 0x00)
+		if !bytes.Contains(encoded, header) {
+			t.Errorf("%s not encoded with length 0: % x", tag, encoded)
+		}
+	}
+	wantLength := 8 + len("STUDY ") + 8*len(keys)
+	if len(encoded) != wantLength {
+		t.Errorf("Encoded length = %d, want %d", len(encoded), wantLength)
+	}
+
+	parsed, err := ParseDatasetWithTransferSyntax(encoded, TransferSyntaxImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	for _, tag := range keys {
+		if !parsed.IsUniversalKey(tag) {
+			element, _ := parsed.GetElement(tag)
+			t.Errorf("%s is not a universal key after round trip: %+v", tag, element)
+		}
+	}
+
+	reencoded, err := EncodeDatasetWithTransferSyntax(parsed, TransferSyntaxImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() re-encode error = %v", err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Errorf("Re-encoded dataset differs:\n got % x\nwant % x", reencoded, encoded)
+	}
+}
+
+func TestNewRetrieveIdentifier(t *testing.T) {
+	tests := []struct {
+		name                     string
+		level                    string
+		studyUID, seriesUID, sop string
+		wantStudy, wantSeries    string
+		wantSOP                  string
+	}{
+		{"study", "STUDY", "1.2", "", "", "1.2", "", ""},
+		{"series", "series", "1.2", "1.2.3", "", "1.2", "1.2.3", ""},
+		{"image", "IMAGE", "1.2", "1.2.3", "1.2.3.4\\1.2.3.5", "1.2", "1.2.3", "1.2.3.4\\1.2.3.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identifier, err := NewRetrieveIdentifier(tt.level, tt.studyUID, tt.seriesUID, tt.sop)
+			if err != nil {
+				t.Fatalf("NewRetrieveIdentifier() error = %v", err)
+			}
+			if got, want := identifier.GetString(queryRetrieveLevelTag), strings.ToUpper(tt.level); got != want {
+				t.Errorf("Query/Retrieve Level = %q, want %q", got, want)
+			}
+			for _, key := range []struct {
+				tag  Tag
+				want string
+			}{
+				{studyInstanceUIDTag, tt.wantStudy},
+				{seriesInstanceUIDTag, tt.wantSeries},
+				{sopInstanceUIDTag, tt.wantSOP},
+			} {
+				_, present := identifier.GetElement(key.tag)
+				if got := identifier.GetString(key.tag); got != key.want || present != (key.want != "") {
+					t.Errorf("%s = %q (present %v), want %q", key.tag, got, present, key.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRetrieveIdentifier_Errors(t *testing.T) {
+	tests := []struct {
+		name                     string
+		level                    string
+		studyUID, seriesUID, sop string
+	}{
+		{"study without study UID", "STUDY", "", "", ""},
+		{"series without series UID", "SERIES", "1.2", "", ""},
+		{"image without study UID", "IMAGE", "", "1.2.3", "1.2.3.4"},
+		{"image without SOP instance UID", "IMAGE", "1.2", "1.2.3", ""},
+		{"key below the level", "STUDY", "1.2", "1.2.3", ""},
+		{"patient level", "PATIENT", "1.2", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRetrieveIdentifier(tt.level, tt.studyUID, tt.seriesUID, tt.sop); err == nil {
+				t.Error("NewRetrieveIdentifier() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"DOE^JOHN", "DOE^JOHN", true},
+		{"DOE^JOHN", "DOE^JANE", false},
+		{"DOE*", "DOE^JOHN", true},
+		{"DOE*", "DO", false},
+		{"*JOHN", "DOE^JOHN", true},
+		{"D?E^*N", "DOE^JOHN", true},
+		{"D?E^*N", "DOE^JOHNNY", false},
+		{"*^J*N*", "DOE^JOHANNA", true},
+		{"*", "", true},
+		{"?", "", false},
+		{"doe*", "DOE^JOHN", false},
+		{"DOE^JOHN ", "DOE^JOHN", true},
+	}
+	for _, tt := range tests {
+		if got := MatchWildcard(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("MatchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
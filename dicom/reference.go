@@ -0,0 +1,59 @@
+package dicom
+
+var (
+	referencedSOPSequenceTag    = Tag{0x0008, 0x1199}
+	referencedSOPClassUIDTag    = Tag{0x0008, 0x1150}
+	referencedSOPInstanceUIDTag = Tag{0x0008, 0x1155}
+)
+
+// SOPReference identifies one SOP instance in a Referenced SOP Sequence, as
+// used by Storage Commitment and Modality Performed Procedure Step
+type SOPReference struct {
+	SOPClassUID    string // Referenced SOP Class UID (0008,1150)
+	SOPInstanceUID string // Referenced SOP Instance UID (0008,1155)
+}
+
+// BuildReferencedSOPSequence builds a Referenced SOP Sequence (0008,1199)
+// with one item per reference, ready to be added to a dataset:
+//
+//	seq := dicom.BuildReferencedSOPSequence(refs)
+//	ds.Elements[seq.Tag] = seq
+func BuildReferencedSOPSequence(refs []SOPReference) *Element {
+	items := make([]*Dataset, 0, len(refs))
+	for _, ref := range refs {
+		item := NewDataset()
+		item.AddElement(referencedSOPClassUIDTag, VR_UI, ref.SOPClassUID)
+		item.AddElement(referencedSOPInstanceUIDTag, VR_UI, ref.SOPInstanceUID)
+		items = append(items, item)
+	}
+	return &Element{Tag: referencedSOPSequenceTag, VR: VR_SQ, Value: items}
+}
+
+// ParseReferencedSOPSequence returns the references in the dataset's
+// Referenced SOP Sequence (0008,1199), in item order. It returns nil when
+// the sequence is absent or was not parsed as a sequence.
+func ParseReferencedSOPSequence(ds *Dataset) []SOPReference {
+	if ds == nil {
+		return nil
+	}
+	element, ok := ds.GetElement(referencedSOPSequenceTag)
+	if !ok {
+		return nil
+	}
+	items, ok := element.Value.([]*Dataset)
+	if !ok {
+		return nil
+	}
+
+	refs := make([]SOPReference, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		refs = append(refs, SOPReference{
+			SOPClassUID:    item.GetString(referencedSOPClassUIDTag),
+			SOPInstanceUID: item.GetString(referencedSOPInstanceUIDTag),
+		})
+	}
+	return refs
+}
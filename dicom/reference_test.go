@@ -0,0 +1,86 @@
+package dicom
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestReferencedSOPSequence_RoundTrip(t *testing.T) {
+	refs := []SOPReference{
+		{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.3.4.5"},
+		{SOPClassUID: types.MRImageStorage, SOPInstanceUID: "1.2.3.4.6"},
+	}
+
+	for _, ts := range []string{TransferSyntaxExplicitVRLittleEndian, TransferSyntaxImplicitVRLittleEndian} {
+		ds := NewDataset()
+		ds.AddElement(Tag{0x0008, 0x1195}, VR_UI, "1.2.3.99") // Transaction UID
+		seq := BuildReferencedSOPSequence(refs)
+		ds.Elements[seq.Tag] = seq
+
+		data, err := EncodeDatasetWithTransferSyntax(ds, ts)
+		if err != nil {
+			t.Fatalf("%s: encode error = %v", ts, err)
+		}
+		parsed, err := ParseDatasetWithTransferSyntax(data, ts)
+		if err != nil {
+			t.Fatalf("%s: parse error = %v", ts, err)
+		}
+
+		if got := ParseReferencedSOPSequence(parsed); !reflect.DeepEqual(got, refs) {
+			t.Errorf("%s: ParseReferencedSOPSequence() = %+v, want %+v", ts, got, refs)
+		}
+		if got := parsed.GetString(Tag{0x0008, 0x1195}); got != "1.2.3.99" {
+			t.Errorf("%s: element after the sequence = %q", ts, got)
+		}
+	}
+}
+
+func TestParseDataset_UndefinedLengthSequence(t *testing.T) {
+	item := NewDataset()
+	item.AddElement(referencedSOPClassUIDTag, VR_UI, types.CTImageStorage)
+	item.AddElement(referencedSOPInstanceUIDTag, VR_UI, "1.2.3")
+	content := item.EncodeDataset()
+
+	le := func(values ...uint16) []byte {
+		var b []byte
+		for _, v := range values {
+			b = append(b, byte(v), byte(v>>8))
+		}
+		return b
+	}
+
+	// (0008,1199) SQ of undefined length holding one undefined-length item,
+	// followed by Patient ID
+	var data []byte
+	data = append(data, le(0x0008, 0x1199)...)
+	data = append(data, 'S', 'Q', 0, 0, 0xFF, 0xFF, 0xFF, 0xFF)
+	data = append(data, le(0xFFFE, 0xE000, 0xFFFF, 0xFFFF)...)
+	data = append(data, content...)
+	data = append(data, le(0xFFFE, 0xE00D, 0, 0)...)
+	data = append(data, le(0xFFFE, 0xE0DD, 0, 0)...)
+	data = append(data, le(0x0010, 0x0020)...)
+	data = append(data, 'L', 'O', 4, 0, 'P', 'I', 'D', '1')
+
+	parsed, err := ParseDataset(data)
+	if err != nil {
+		t.Fatalf("ParseDataset() error = %v", err)
+	}
+	want := []SOPReference{{SOPClassUID: types.CTImageStorage, SOPInstanceUID: "1.2.3"}}
+	if got := ParseReferencedSOPSequence(parsed); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseReferencedSOPSequence() = %+v, want %+v", got, want)
+	}
+	if got := parsed.GetString(Tag{0x0010, 0x0020}); got != "PID1" {
+		t.Errorf("Patient ID = %q, want PID1", got)
+	}
+}
+
+func TestParseReferencedSOPSequence_Absent(t *testing.T) {
+	if refs := ParseReferencedSOPSequence(NewDataset()); refs != nil {
+		t.Errorf("ParseReferencedSOPSequence() = %+v, want nil", refs)
+	}
+	if refs := ParseReferencedSOPSequence(nil); refs != nil {
+		t.Errorf("ParseReferencedSOPSequence(nil) = %+v, want nil", refs)
+	}
+}
@@ -0,0 +1,146 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+var itemDelimiterTag = Tag{0xFFFE, 0xE00D}
+
+// parseSequence reads the items of a sequence whose value starts at offset,
+// returning them with the offset after the sequence. Sequences and items of
+// undefined length are read up to their delimiters.
+func parseSequence(data []byte, offset int, length uint32, explicit bool) ([]*Dataset, int, error) {
+	end, next := offset+int(length), offset+int(length)
+	if length == undefinedLength {
+		delimiter, err := findDelimiter(data, offset, explicit, sequenceDelimiterTag)
+		if err != nil {
+			return nil, 0, err
+		}
+		end, next = delimiter, delimiter+8
+	} else if uint64(offset)+uint64(length) > uint64(len(data)) {
+		return nil, 0, fmt.Errorf("sequence length %d exceeds dataset", length)
+	}
+
+	items := []*Dataset{}
+	value := data[:end]
+	for offset < end {
+		if offset+8 > end {
+			return nil, 0, fmt.Errorf("truncated item header at offset %d", offset)
+		}
+		tag, itemLength := readItemHeader(value, offset)
+		if tag != itemTag {
+			return nil, 0, fmt.Errorf("unexpected %s in sequence", tag)
+		}
+		offset += 8
+
+		itemEnd, itemNext := offset+int(itemLength), offset+int(itemLength)
+		if itemLength == undefinedLength {
+			delimiter, err := findDelimiter(value, offset, explicit, itemDelimiterTag)
+			if err != nil {
+				return nil, 0, err
+			}
+			itemEnd, itemNext = delimiter, delimiter+8
+		} else if uint64(offset)+uint64(itemLength) > uint64(end) {
+			return nil, 0, fmt.Errorf("item length %d exceeds sequence", itemLength)
+		}
+
+		var item *Dataset
+		var err error
+		if explicit {
+			item, err = ParseDataset(value[offset:itemEnd])
+		} else {
+			item, err = parseImplicitVRDataset(value[offset:itemEnd])
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+		offset = itemNext
+	}
+
+	return items, next, nil
+}
+
+// findDelimiter returns the offset of the delimiter closing the
+// undefined-length sequence or item whose content starts at offset, skipping
+// over nested elements, sequences and items
+func findDelimiter(data []byte, offset int, explicit bool, delimiter Tag) (int, error) {
+	for offset+8 <= len(data) {
+		tag, length := readItemHeader(data, offset)
+		if tag == delimiter {
+			return offset, nil
+		}
+
+		valueOffset := offset + 8
+		nested := tag == itemTag
+		if tag.Group != 0xFFFE && explicit {
+			vr := string(data[offset+4 : offset+6])
+			if isLongVR(vr) {
+				if offset+12 > len(data) {
+					break
+				}
+				length = binary.LittleEndian.Uint32(data[offset+8 : offset+12])
+				valueOffset = offset + 12
+			} else {
+				length = uint32(binary.LittleEndian.Uint16(data[offset+6 : offset+8]))
+			}
+		}
+
+		if length == undefinedLength {
+			closing := sequenceDelimiterTag
+			if nested {
+				closing = itemDelimiterTag
+			}
+			end, err := findDelimiter(data, valueOffset, explicit, closing)
+			if err != nil {
+				return 0, err
+			}
+			offset = end + 8
+			continue
+		}
+		if uint64(valueOffset)+uint64(length) > uint64(len(data)) {
+			break
+		}
+		offset = valueOffset + int(length)
+	}
+	return 0, fmt.Errorf("missing %s delimiter", delimiter)
+}
+
+// readItemHeader reads a tag and 4-byte length, as in item and delimiter
+// headers and Implicit VR elements
+func readItemHeader(data []byte, offset int) (Tag, uint32) {
+	tag := Tag{
+		Group:   binary.LittleEndian.Uint16(data[offset : offset+2]),
+		Element: binary.LittleEndian.Uint16(data[offset+2 : offset+4]),
+	}
+	return tag, binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+}
+
+// encodeSequence encodes sequence items as defined-length items, each
+// holding its dataset in the same VR encoding as the enclosing dataset
+func encodeSequence(items []*Dataset, explicit bool) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, item := range items {
+		var content bytes.Buffer
+		if item != nil {
+			var err error
+			if explicit {
+				err = encodeExplicitVRDatasetTo(&content, item)
+			} else {
+				err = encodeImplicitVRDatasetTo(&content, item)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		header := binary.LittleEndian.AppendUint16(nil, itemTag.Group)
+		header = binary.LittleEndian.AppendUint16(header, itemTag.Element)
+		header = binary.LittleEndian.AppendUint32(header, uint32(content.Len()))
+		buf.Write(header)
+		buf.Write(content.Bytes())
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// undefinedLength marks a sequence or item whose end is given by a delimiter
+const undefinedLength = 0xFFFFFFFF
+
+// Transcode re-encodes a dataset between the uncompressed little endian
+// transfer syntaxes (Implicit and Explicit VR). Values are copied as raw
+// bytes rather than through Dataset, so nothing is lost: elements whose VR
+// the dictionary does not know, such as private tags, are written as UN when
+// converting to Explicit VR.
+//
+// Elements and items with undefined length are not supported.
+func Transcode(data []byte, fromTransferSyntax, toTransferSyntax string) ([]byte, error) {
+	fromExplicit, err := isExplicitVRSyntax(fromTransferSyntax)
+	if err != nil {
+		return nil, err
+	}
+	toExplicit, err := isExplicitVRSyntax(toTransferSyntax)
+	if err != nil {
+		return nil, err
+	}
+
+	if fromExplicit == toExplicit {
+		return append([]byte(nil), data...), nil
+	}
+
+	return transcodeElements(make([]byte, 0, len(data)+len(data)/8), data, fromExplicit, toExplicit)
+}
+
+// isExplicitVRSyntax reports whether a transfer syntax Transcode handles uses
+// explicit VRs. An empty UID means Explicit VR Little Endian, as elsewhere in
+// this package.
+func isExplicitVRSyntax(transferSyntaxUID string) (bool, error) {
+	switch transferSyntaxUID {
+	case "", TransferSyntaxExplicitVRLittleEndian:
+		return true, nil
+	case TransferSyntaxImplicitVRLittleEndian:
+		return false, nil
+	default:
+		return false, fmt.Errorf("transcoding %s is not supported", transferSyntaxUID)
+	}
+}
+
+// transcodeElements appends the elements in data, re-encoded, to out
+func transcodeElements(out, data []byte, fromExplicit, toExplicit bool) ([]byte, error) {
+	offset := 0
+	for offset < len(data) {
+		tag, vr, value, next, err := readRawElement(data, offset, fromExplicit)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fromExplicit {
+			vr = determineVR(tag)
+		}
+
+		if vr == VR_SQ {
+			items, err := transcodeItems(value, fromExplicit, toExplicit)
+			if err != nil {
+				return nil, fmt.Errorf("sequence %s: %w", tag, err)
+			}
+			value = items
+		}
+
+		// A value too long for the 2-byte length form can only be carried as UN
+		if toExplicit && !isLongVR(vr) && len(value) > 0xFFFF {
+			vr = VR_UN
+		}
+
+		out = appendRawElement(out, tag, vr, value, toExplicit)
+		offset = next
+	}
+
+	return out, nil
+}
+
+// transcodeItems re-encodes the items of a defined-length sequence value
+func transcodeItems(data []byte, fromExplicit, toExplicit bool) ([]byte, error) {
+	var out []byte
+	offset := 0
+	for offset < len(data) {
+		if offset+8 > len(data) {
+			return nil, fmt.Errorf("truncated item header at offset %d", offset)
+		}
+		tag := Tag{
+			Group:   binary.LittleEndian.Uint16(data[offset : offset+2]),
+			Element: binary.LittleEndian.Uint16(data[offset+2 : offset+4]),
+		}
+		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		if tag != (Tag{0xFFFE, 0xE000}) {
+			return nil, fmt.Errorf("unexpected %s in sequence", tag)
+		}
+		if length == undefinedLength {
+			return nil, fmt.Errorf("undefined-length items are not supported")
+		}
+		if uint64(offset)+8+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("item length %d exceeds sequence", length)
+		}
+		end := offset + 8 + int(length)
+
+		item, err := transcodeElements(nil, data[offset+8:end], fromExplicit, toExplicit)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, data[offset:offset+4]...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(item)))
+		out = append(out, item...)
+		offset = end
+	}
+	return out, nil
+}
+
+// readRawElement reads the element at offset, returning its raw value and the
+// offset of the next element. vr is empty for Implicit VR input.
+func readRawElement(data []byte, offset int, explicit bool) (Tag, string, []byte, int, error) {
+	if offset+8 > len(data) {
+		return Tag{}, "", nil, 0, fmt.Errorf("truncated element header at offset %d", offset)
+	}
+
+	tag := Tag{
+		Group:   binary.LittleEndian.Uint16(data[offset : offset+2]),
+		Element: binary.LittleEndian.Uint16(data[offset+2 : offset+4]),
+	}
+
+	var vr string
+	var length uint32
+	valueOffset := offset + 8
+	if explicit {
+		vr = string(data[offset+4 : offset+6])
+		if isLongVR(vr) {
+			if offset+12 > len(data) {
+				return Tag{}, "", nil, 0, fmt.Errorf("truncated element header for %s", tag)
+			}
+			length = binary.LittleEndian.Uint32(data[offset+8 : offset+12])
+			valueOffset = offset + 12
+		} else {
+			length = uint32(binary.LittleEndian.Uint16(data[offset+6 : offset+8]))
+		}
+	} else {
+		length = binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+	}
+
+	if length == undefinedLength {
+		return Tag{}, "", nil, 0, fmt.Errorf("undefined-length element %s is not supported", tag)
+	}
+
+	if uint64(valueOffset)+uint64(length) > uint64(len(data)) {
+		return Tag{}, "", nil, 0, fmt.Errorf("element %s length %d exceeds dataset", tag, length)
+	}
+	end := valueOffset + int(length)
+
+	return tag, vr, data[valueOffset:end], end, nil
+}
+
+// appendRawElement appends an element with an already-encoded value
+func appendRawElement(out []byte, tag Tag, vr string, value []byte, explicit bool) []byte {
+	out = binary.LittleEndian.AppendUint16(out, tag.Group)
+	out = binary.LittleEndian.AppendUint16(out, tag.Element)
+
+	switch {
+	case !explicit:
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(value)))
+	case isLongVR(vr):
+		out = append(out, vr...)
+		out = append(out, 0x00, 0x00) // Reserved
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(value)))
+	default:
+		out = append(out, vr...)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(value)))
+	}
+
+	return append(out, value...)
+}
+
+// isLongVR reports whether an Explicit VR element uses the 4-byte length form
+func isLongVR(vr string) bool {
+	switch vr {
+	case VR_OB, VR_OD, VR_OF, VR_OL, VR_OV, VR_OW, VR_SQ, VR_SV, VR_UC, VR_UN, VR_UR, VR_UT, VR_UV:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// appendImplicitElement appends an Implicit VR Little Endian element
+func appendImplicitElement(buf []byte, group, element uint16, value []byte) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, group)
+	buf = binary.LittleEndian.AppendUint16(buf, element)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value)))
+	return append(buf, value...)
+}
+
+func TestTranscode_PrivateTagRoundTrip(t *testing.T) {
+	privateValue := []byte{0x00, 0x01, 0xFF, 0x20, 0x00, 0x7F}
+
+	var implicit []byte
+	implicit = appendImplicitElement(implicit, 0x0008, 0x0018, []byte("1.2.3.4\x00"))
+	implicit = appendImplicitElement(implicit, 0x0009, 0x0010, []byte("ACME"))
+	implicit = appendImplicitElement(implicit, 0x0009, 0x1001, privateValue)
+	implicit = appendImplicitElement(implicit, 0x0010, 0x0010, []byte("DOE^JOHN"))
+
+	explicit, err := Transcode(implicit, types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("Transcode(implicit -> explicit) error = %v", err)
+	}
+
+	// The private element is carried as UN with the 4-byte length form
+	var want []byte
+	want = binary.LittleEndian.AppendUint16(want, 0x0009)
+	want = binary.LittleEndian.AppendUint16(want, 0x1001)
+	want = append(want, 'U', 'N', 0x00, 0x00)
+	want = binary.LittleEndian.AppendUint32(want, uint32(len(privateValue)))
+	want = append(want, privateValue...)
+	if !bytes.Contains(explicit, want) {
+		t.Errorf("Explicit output does not contain the private element as UN:\n% X", explicit)
+	}
+
+	ds, err := ParseDataset(explicit)
+	if err != nil {
+		t.Fatalf("ParseDataset() error = %v", err)
+	}
+	if elem, ok := ds.GetElement(Tag{0x0009, 0x1001}); !ok || elem.VR != VR_UN {
+		t.Errorf("Private element = %+v, want VR UN", elem)
+	}
+	if elem, ok := ds.GetElement(Tag{0x0010, 0x0010}); !ok || elem.VR != VR_PN {
+		t.Errorf("Patient's Name = %+v, want VR PN", elem)
+	}
+
+	roundTrip, err := Transcode(explicit, types.ExplicitVRLittleEndian, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("Transcode(explicit -> implicit) error = %v", err)
+	}
+	if !bytes.Equal(roundTrip, implicit) {
+		t.Errorf("Round trip is not byte-exact:\n got  % X\n want % X", roundTrip, implicit)
+	}
+}
+
+func TestTranscode_Sequence(t *testing.T) {
+	item := NewDataset()
+	item.AddElement(Tag{0x0008, 0x1150}, VR_UI, "1.2.840.10008.5.1.4.1.1.2")
+	itemBytes := item.EncodeDataset()
+
+	var sequence []byte
+	sequence = appendImplicitElement(sequence, 0xFFFE, 0xE000, itemBytes)
+
+	var explicit []byte
+	explicit = binary.LittleEndian.AppendUint16(explicit, 0x0008)
+	explicit = binary.LittleEndian.AppendUint16(explicit, 0x1140)
+	explicit = append(explicit, 'S', 'Q', 0x00, 0x00)
+	explicit = binary.LittleEndian.AppendUint32(explicit, uint32(len(sequence)))
+	explicit = append(explicit, sequence...)
+
+	implicit, err := Transcode(explicit, types.ExplicitVRLittleEndian, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("Transcode() error = %v", err)
+	}
+
+	// Items are re-encoded as Implicit VR too, keeping EncodeDataset's padding
+	itemImplicit := appendImplicitElement(nil, 0x0008, 0x1150, []byte("1.2.840.10008.5.1.4.1.1.2\x00"))
+	want := appendImplicitElement(nil, 0x0008, 0x1140, appendImplicitElement(nil, 0xFFFE, 0xE000, itemImplicit))
+	if !bytes.Equal(implicit, want) {
+		t.Errorf("Transcode() =\n % X\nwant\n % X", implicit, want)
+	}
+}
+
+func TestTranscode_Errors(t *testing.T) {
+	if _, err := Transcode(nil, types.JPEGBaseline8Bit, types.ExplicitVRLittleEndian); err == nil {
+		t.Error("Expected error for compressed source transfer syntax")
+	}
+
+	undefined := binary.LittleEndian.AppendUint16(nil, 0x0009)
+	undefined = binary.LittleEndian.AppendUint16(undefined, 0x1002)
+	undefined = binary.LittleEndian.AppendUint32(undefined, undefinedLength)
+	if _, err := Transcode(undefined, types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian); err == nil {
+		t.Error("Expected error for undefined-length element")
+	}
+
+	truncated := appendImplicitElement(nil, 0x0010, 0x0010, []byte("DOE^JOHN"))
+	if _, err := Transcode(truncated[:10], types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian); err == nil {
+		t.Error("Expected error for truncated element")
+	}
+}
@@ -0,0 +1,113 @@
+package dimse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// CommandElement is a single element of a decoded command set
+type CommandElement struct {
+	Tag   types.Tag
+	VR    string // Implied from the command dictionary; UN when the element is not known
+	Value []byte // Raw value bytes as received (Implicit VR Little Endian)
+}
+
+// commandElementVRs maps command group elements (PS3.7 Annex E) to their VR
+var commandElementVRs = map[uint16]string{
+	0x0000: types.VR_UL, // Command Group Length
+	0x0002: types.VR_UI, // Affected SOP Class UID
+	0x0003: types.VR_UI, // Requested SOP Class UID
+	0x0100: types.VR_US, // Command Field
+	0x0110: types.VR_US, // Message ID
+	0x0120: types.VR_US, // Message ID Being Responded To
+	0x0600: types.VR_AE, // Move Destination
+	0x0700: types.VR_US, // Priority
+	0x0800: types.VR_US, // Command Data Set Type
+	0x0900: types.VR_US, // Status
+	0x0901: types.VR_AT, // Offending Element
+	0x0902: types.VR_LO, // Error Comment
+	0x0903: types.VR_US, // Error ID
+	0x1000: types.VR_UI, // Affected SOP Instance UID
+	0x1001: types.VR_UI, // Requested SOP Instance UID
+	0x1002: types.VR_US, // Event Type ID
+	0x1005: types.VR_AT, // Attribute Identifier List
+	0x1008: types.VR_US, // Action Type ID
+	0x1020: types.VR_US, // Number of Remaining Sub-operations
+	0x1021: types.VR_US, // Number of Completed Sub-operations
+	0x1022: types.VR_US, // Number of Failed Sub-operations
+	0x1023: types.VR_US, // Number of Warning Sub-operations
+	0x1030: types.VR_AE, // Move Originator Application Entity Title
+	0x1031: types.VR_US, // Move Originator Message ID
+}
+
+// DecodeCommandElements decodes a command set into its elements in wire order,
+// including elements DecodeCommand does not model.
+func DecodeCommandElements(data []byte) ([]CommandElement, error) {
+	var elements []CommandElement
+	offset := 0
+
+	for offset < len(data) {
+		if offset+8 > len(data) {
+			return elements, fmt.Errorf("truncated element header at offset %d", offset)
+		}
+
+		tag := types.Tag{
+			Group:   binary.LittleEndian.Uint16(data[offset : offset+2]),
+			Element: binary.LittleEndian.Uint16(data[offset+2 : offset+4]),
+		}
+		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+
+		if uint64(length) > uint64(len(data)-offset-8) {
+			return elements, fmt.Errorf("element %s length %d exceeds command set", tag, length)
+		}
+		end := offset + 8 + int(length)
+
+		vr := types.VR_UN
+		if tag.Group == 0x0000 {
+			if known, ok := commandElementVRs[tag.Element]; ok {
+				vr = known
+			}
+		}
+
+		elements = append(elements, CommandElement{
+			Tag:   tag,
+			VR:    vr,
+			Value: data[offset+8 : end],
+		})
+		offset = end
+	}
+
+	return elements, nil
+}
+
+// String renders the element as "(gggg,eeee) VR value" for logs and dumps
+func (e CommandElement) String() string {
+	return fmt.Sprintf("%s %s %s", e.Tag, e.VR, e.formatValue())
+}
+
+// formatValue renders the value according to the element's VR
+func (e CommandElement) formatValue() string {
+	switch e.VR {
+	case types.VR_US:
+		if len(e.Value) == 2 {
+			return fmt.Sprintf("0x%04x", binary.LittleEndian.Uint16(e.Value))
+		}
+	case types.VR_UL:
+		if len(e.Value) == 4 {
+			return fmt.Sprintf("%d", binary.LittleEndian.Uint32(e.Value))
+		}
+	case types.VR_UI, types.VR_AE, types.VR_LO:
+		return strings.TrimRight(string(e.Value), "\x00 ")
+	case types.VR_AT:
+		tags := decodeAttributeTags(e.Value)
+		parts := make([]string, len(tags))
+		for i, tag := range tags {
+			parts[i] = tag.String()
+		}
+		return strings.Join(parts, "\\")
+	}
+	return fmt.Sprintf("% x", e.Value)
+}
@@ -0,0 +1,81 @@
+package dimse
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestDecodeCommandElements(t *testing.T) {
+	data, err := EncodeCommand(&types.Message{
+		CommandField:        types.CEchoRQ,
+		MessageID:           7,
+		CommandDataSetType:  0x0101,
+		AffectedSOPClassUID: types.VerificationSOPClass,
+	})
+	if err != nil {
+		t.Fatalf("EncodeCommand() error = %v", err)
+	}
+
+	// Append an element we don't model (Error ID) and fix up the group length
+	errorID := make([]byte, 2)
+	binary.LittleEndian.PutUint16(errorID, 0x1234)
+	data = AppendImplicitElement(data, 0x0000, 0x0903, errorID)
+	data = AppendImplicitElement(data, 0x0000, 0x4000, []byte{0xAA, 0xBB})
+	binary.LittleEndian.PutUint32(data[8:12], uint32(len(data)-12))
+
+	elements, err := DecodeCommandElements(data)
+	if err != nil {
+		t.Fatalf("DecodeCommandElements() error = %v", err)
+	}
+
+	wantTags := []types.Tag{
+		{Group: 0x0000, Element: 0x0000},
+		{Group: 0x0000, Element: 0x0002},
+		{Group: 0x0000, Element: 0x0100},
+		{Group: 0x0000, Element: 0x0110},
+		{Group: 0x0000, Element: 0x0800},
+		{Group: 0x0000, Element: 0x0903},
+		{Group: 0x0000, Element: 0x4000},
+	}
+	if len(elements) != len(wantTags) {
+		t.Fatalf("Got %d elements, want %d: %v", len(elements), len(wantTags), elements)
+	}
+	for i, tag := range wantTags {
+		if elements[i].Tag != tag {
+			t.Errorf("elements[%d].Tag = %s, want %s", i, elements[i].Tag, tag)
+		}
+	}
+
+	if got := elements[2].String(); got != "(0000,0100) US 0x0030" {
+		t.Errorf("Command Field = %q", got)
+	}
+	if got := elements[5].String(); got != "(0000,0903) US 0x1234" {
+		t.Errorf("Error ID = %q", got)
+	}
+	unknown := elements[6]
+	if unknown.VR != types.VR_UN || string(unknown.Value) != "\xAA\xBB" {
+		t.Errorf("Unrecognized element = %+v, want UN with raw value", unknown)
+	}
+
+	// The typed decode is unaffected by the extra elements
+	msg, err := DecodeCommand(data)
+	if err != nil {
+		t.Fatalf("DecodeCommand() error = %v", err)
+	}
+	if msg.CommandField != types.CEchoRQ || msg.MessageID != 7 {
+		t.Errorf("DecodeCommand() = %+v", msg)
+	}
+}
+
+func TestDecodeCommandElements_Truncated(t *testing.T) {
+	data := AppendImplicitElement(nil, 0x0000, 0x0100, []byte{0x30, 0x00})
+
+	if _, err := DecodeCommandElements(data[:5]); err == nil {
+		t.Error("Expected error for truncated element header")
+	}
+	if _, err := DecodeCommandElements(data[:9]); err == nil {
+		t.Error("Expected error for truncated element value")
+	}
+}
@@ -0,0 +1,22 @@
+package dimse
+
+import (
+	"bytes"
+	"io"
+)
+
+// FuzzDecodeCommand decodes data as a command set and, separately, reads it
+// as a stream of PDUs carrying one DIMSE message. Results are discarded. It
+// is the entry point for fuzzers and must not panic, nor allocate more than
+// a small multiple of the input, for any input.
+func FuzzDecodeCommand(data []byte) {
+	_ = ValidateCommandSet(data)
+	_, _ = DecodeCommand(data)
+	_, _ = DecodeCommandElements(data)
+
+	// Replies such as an A-RELEASE-RP are discarded
+	_, _, _ = ReceiveDIMSEMessage(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(data), io.Discard})
+}
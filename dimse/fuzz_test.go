@@ -0,0 +1,71 @@
+package dimse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func FuzzDecode(f *testing.F) {
+	command := EncodeCommandSet(&types.Message{
+		CommandField:           CStoreRQ,
+		MessageID:              1,
+		Priority:               0x0002,
+		CommandDataSetType:     0x0000,
+		AffectedSOPClassUID:    types.CTImageStorage,
+		AffectedSOPInstanceUID: "1.2.3",
+	})
+	f.Add(command)
+	f.Add(command[:len(command)-3])
+	f.Add(fuzzPDataTF(1, 0x03, command))
+	f.Add(append(fuzzPDataTF(1, 0x03, command), fuzzPDataTF(1, 0x02, []byte{0x08, 0x00, 0x18, 0x00})...))
+	// PDU and PDV lengths beyond the input
+	f.Add([]byte{0x04, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00})
+	f.Add([]byte{0x04, 0x00, 0x00, 0x00, 0x00, 0x06, 0xFF, 0xFF, 0xFF, 0xFF, 0x01, 0x03})
+	f.Add(shortPDV)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzDecodeCommand(data)
+	})
+}
+
+// shortPDV is a P-DATA-TF whose PDV is too short for its message control
+// header, which used to panic ReceiveDIMSEMessage with a slice out of range
+var shortPDV = []byte{0x04, 0x00, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03}
+
+func TestReceiveDIMSEMessage_ShortPDV(t *testing.T) {
+	_, _, err := ReceiveDIMSEMessage(bytes.NewBuffer(bytes.Clone(shortPDV)))
+	if err == nil || !strings.Contains(err.Error(), "malformed PDV") {
+		t.Errorf("ReceiveDIMSEMessage() error = %v, want malformed PDV", err)
+	}
+}
+
+func TestReceiveDIMSEMessage_ForgedPDULength(t *testing.T) {
+	// A 4 GiB PDU length must not be allocated before the data arrives
+	forged := []byte{0x04, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x02, 0x01, 0x03}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, _, err := ReceiveDIMSEMessage(bytes.NewBuffer(bytes.Clone(forged))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReceiveDIMSEMessage() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("ReceiveDIMSEMessage() allocated %d bytes", allocated)
+	}
+}
+
+// fuzzPDataTF wraps a value in a P-DATA-TF PDU with one PDV
+func fuzzPDataTF(contextID, control byte, value []byte) []byte {
+	pdvLength := uint32(len(value) + 2)
+	buf := binary.BigEndian.AppendUint32([]byte{pdu.TypePDataTF, 0x00}, pdvLength+4)
+	buf = binary.BigEndian.AppendUint32(buf, pdvLength)
+	buf = append(buf, contextID, control)
+	return append(buf, value...)
+}
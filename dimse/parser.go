@@ -46,10 +46,10 @@ func parseDIMSECommand(data []byte, logger *slog.Logger) (*types.Message, error)
 		}
 
 		// Ensure we have enough data for the value
-		if offset+8+int(length) > len(data) {
+		if uint64(length) > uint64(len(data)-offset-8) {
 			logger.Debug("Not enough data for element value",
 				"have_bytes", len(data),
-				"need_bytes", offset+8+int(length))
+				"need_bytes", uint64(offset)+8+uint64(length))
 			break
 		}
 
@@ -71,6 +71,10 @@ func parseDIMSECommand(data []byte, logger *slog.Logger) (*types.Message, error)
 				} else {
 					logger.Warn("Message ID has wrong length", "length", length)
 				}
+			case 0x0120: // Message ID Being Responded To
+				if length == 2 {
+					msg.MessageIDBeingRespondedTo = binary.LittleEndian.Uint16(data[valueStart:valueEnd])
+				}
 			case 0x0800: // Command Data Set Type
 				if length == 2 {
 					msg.CommandDataSetType = binary.LittleEndian.Uint16(data[valueStart:valueEnd])
@@ -86,6 +90,8 @@ func parseDIMSECommand(data []byte, logger *slog.Logger) (*types.Message, error)
 					}
 					msg.AffectedSOPClassUID = strings.TrimSpace(sopClassUID)
 				}
+			case 0x0003: // Requested SOP Class UID
+				msg.RequestedSOPClassUID = strings.TrimRight(string(data[valueStart:valueEnd]), "\x00 ")
 			case 0x0600: // Move Destination (for C-MOVE-RQ)
 				if length > 0 {
 					moveDestination := string(data[valueStart:valueEnd])
@@ -95,6 +101,32 @@ func parseDIMSECommand(data []byte, logger *slog.Logger) (*types.Message, error)
 					}
 					msg.MoveDestination = strings.TrimSpace(moveDestination)
 				}
+			case 0x0900: // Status
+				if length == 2 {
+					msg.Status = binary.LittleEndian.Uint16(data[valueStart:valueEnd])
+				}
+			case 0x0901: // Offending Element
+				msg.OffendingElements = decodeAttributeTags(data[valueStart:valueEnd])
+			case 0x0902: // Error Comment
+				msg.ErrorComment = strings.TrimRight(string(data[valueStart:valueEnd]), "\x00 ")
+			case 0x1000: // Affected SOP Instance UID
+				msg.AffectedSOPInstanceUID = strings.TrimRight(string(data[valueStart:valueEnd]), "\x00 ")
+			case 0x1001: // Requested SOP Instance UID (DIMSE-N requests)
+				msg.RequestedSOPInstanceUID = strings.TrimRight(string(data[valueStart:valueEnd]), "\x00 ")
+			case 0x1002: // Event Type ID (N-EVENT-REPORT)
+				if length == 2 {
+					msg.EventTypeID = binary.LittleEndian.Uint16(data[valueStart:valueEnd])
+				}
+			case 0x1008: // Action Type ID (N-ACTION)
+				if length == 2 {
+					msg.ActionTypeID = binary.LittleEndian.Uint16(data[valueStart:valueEnd])
+				}
+			case 0x1030: // Move Originator Application Entity Title (C-STORE sub-operations)
+				msg.MoveOriginatorAETitle = strings.TrimRight(string(data[valueStart:valueEnd]), "\x00 ")
+			case 0x1031: // Move Originator Message ID
+				if length == 2 {
+					msg.MoveOriginatorMessageID = binary.LittleEndian.Uint16(data[valueStart:valueEnd])
+				}
 			default:
 				// Skip unknown command elements silently
 			}
@@ -114,54 +146,3 @@ func parseDIMSECommand(data []byte, logger *slog.Logger) (*types.Message, error)
 		"message_id", msg.MessageID)
 	return msg, nil
 }
-
-// createDIMSECommand creates a DIMSE command as bytes
-func createDIMSECommand(msg *types.Message) []byte {
-	var result []byte
-
-	// Command Field (0000,0100)
-	result = append(result, 0x00, 0x00, 0x00, 0x01) // Tag
-	result = append(result, 0x02, 0x00, 0x00, 0x00) // Length = 2
-	cmdBytes := make([]byte, 2)
-	binary.LittleEndian.PutUint16(cmdBytes, msg.CommandField)
-	result = append(result, cmdBytes...)
-
-	// Message ID Being Responded To (0000,0120)
-	if msg.MessageIDBeingRespondedTo > 0 {
-		result = append(result, 0x00, 0x00, 0x20, 0x01) // Tag
-		result = append(result, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		msgIDBytes := make([]byte, 2)
-		binary.LittleEndian.PutUint16(msgIDBytes, msg.MessageIDBeingRespondedTo)
-		result = append(result, msgIDBytes...)
-	}
-
-	// Command Data Set Type (0000,0800)
-	result = append(result, 0x00, 0x00, 0x00, 0x08) // Tag
-	result = append(result, 0x02, 0x00, 0x00, 0x00) // Length = 2
-	dataSetTypeBytes := make([]byte, 2)
-	binary.LittleEndian.PutUint16(dataSetTypeBytes, msg.CommandDataSetType)
-	result = append(result, dataSetTypeBytes...)
-
-	// Status (0000,0900)
-	result = append(result, 0x00, 0x00, 0x00, 0x09) // Tag
-	result = append(result, 0x02, 0x00, 0x00, 0x00) // Length = 2
-	statusBytes := make([]byte, 2)
-	binary.LittleEndian.PutUint16(statusBytes, msg.Status)
-	result = append(result, statusBytes...)
-
-	// Affected SOP Class UID (0000,0002)
-	if msg.AffectedSOPClassUID != "" {
-		result = append(result, 0x00, 0x00, 0x02, 0x00) // Tag
-		sopClassUIDBytes := []byte(msg.AffectedSOPClassUID)
-		// Ensure even length
-		if len(sopClassUIDBytes)%2 == 1 {
-			sopClassUIDBytes = append(sopClassUIDBytes, 0x00) // Null pad
-		}
-		lengthBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(lengthBytes, uint32(len(sopClassUIDBytes)))
-		result = append(result, lengthBytes...)
-		result = append(result, sopClassUIDBytes...)
-	}
-
-	return result
-}
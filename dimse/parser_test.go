@@ -292,10 +292,10 @@ func TestCreateDIMSECommand(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			data := createDIMSECommand(&tt.msg)
+			data := EncodeCommandSet(&tt.msg)
 
 			if len(data) == 0 {
-				t.Error("createDIMSECommand() returned empty data")
+				t.Error("EncodeCommandSet() returned empty data")
 			}
 
 			// Data should be properly formatted DICOM
@@ -304,7 +304,7 @@ func TestCreateDIMSECommand(t *testing.T) {
 			// But without SOP Class UID, it's only 30 bytes, which is valid
 			minExpected := 12 // At least one element
 			if len(data) < minExpected {
-				t.Errorf("createDIMSECommand() data length = %d, want at least %d", len(data), minExpected)
+				t.Errorf("EncodeCommandSet() data length = %d, want at least %d", len(data), minExpected)
 			}
 		})
 	}
@@ -350,7 +350,7 @@ func TestCreateDIMSECommand_RoundTrip(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create DIMSE command
-			data := createDIMSECommand(&tt.msg)
+			data := EncodeCommandSet(&tt.msg)
 
 			// Parse it back
 			parsed, err := parseDIMSECommand(data, nil)
@@ -363,8 +363,10 @@ func TestCreateDIMSECommand_RoundTrip(t *testing.T) {
 				t.Errorf("Round-trip CommandField = 0x%04x, want 0x%04x",
 					parsed.CommandField, tt.msg.CommandField)
 			}
-			// Note: parseDIMSECommand doesn't read MessageIDBeingRespondedTo (0x0120),
-			// so we don't test round-trip for that field
+			if parsed.MessageIDBeingRespondedTo != tt.msg.MessageIDBeingRespondedTo {
+				t.Errorf("Round-trip MessageIDBeingRespondedTo = %d, want %d",
+					parsed.MessageIDBeingRespondedTo, tt.msg.MessageIDBeingRespondedTo)
+			}
 			if parsed.CommandDataSetType != tt.msg.CommandDataSetType {
 				t.Errorf("Round-trip CommandDataSetType = 0x%04x, want 0x%04x",
 					parsed.CommandDataSetType, tt.msg.CommandDataSetType)
@@ -389,7 +391,7 @@ func TestCreateDIMSECommand_OddLengthUID(t *testing.T) {
 		AffectedSOPClassUID: "1.2.3", // Odd length (5 chars)
 	}
 
-	data := createDIMSECommand(&msg)
+	data := EncodeCommandSet(&msg)
 
 	// Parse it back
 	parsed, err := parseDIMSECommand(data, nil)
@@ -403,3 +405,215 @@ func TestCreateDIMSECommand_OddLengthUID(t *testing.T) {
 			parsed.AffectedSOPClassUID, msg.AffectedSOPClassUID)
 	}
 }
+
+func TestEncodeCommandSet_FailureDetails(t *testing.T) {
+	msg := types.Message{
+		CommandField:       types.CStoreRSP,
+		CommandDataSetType: 0x0101,
+		Status:             0xA900,
+		OffendingElements:  []types.Tag{{Group: 0x0008, Element: 0x0016}},
+		ErrorComment:       "SOP Class mismatch", // Even length
+	}
+	data := EncodeCommandSet(&msg)
+
+	parsed, err := DecodeCommand(data)
+	if err != nil {
+		t.Fatalf("DecodeCommand() error = %v", err)
+	}
+	if len(parsed.OffendingElements) != 1 || parsed.OffendingElements[0] != msg.OffendingElements[0] {
+		t.Errorf("OffendingElements = %v, want %v", parsed.OffendingElements, msg.OffendingElements)
+	}
+	if parsed.ErrorComment != msg.ErrorComment {
+		t.Errorf("ErrorComment = %q, want %q", parsed.ErrorComment, msg.ErrorComment)
+	}
+
+	parsed, err = parseDIMSECommand(data, nil)
+	if err != nil {
+		t.Fatalf("parseDIMSECommand() error = %v", err)
+	}
+	if len(parsed.OffendingElements) != 1 || parsed.ErrorComment != msg.ErrorComment {
+		t.Errorf("parseDIMSECommand() = %v / %q, want %v / %q",
+			parsed.OffendingElements, parsed.ErrorComment, msg.OffendingElements, msg.ErrorComment)
+	}
+}
+
+func TestDecodeCommand_MoveOriginator(t *testing.T) {
+	// C-STORE-RQ sub-operation of a C-MOVE, with a space-padded originator AE title
+	var data []byte
+	data = AppendImplicitElement(data, 0x0000, 0x0002, []byte("1.2.840.10008.5.1.4.1.1.2\x00"))
+	data = AppendImplicitElement(data, 0x0000, 0x0100, []byte{0x01, 0x00})
+	data = AppendImplicitElement(data, 0x0000, 0x0110, []byte{0x07, 0x00})
+	data = AppendImplicitElement(data, 0x0000, 0x0700, []byte{0x00, 0x00})
+	data = AppendImplicitElement(data, 0x0000, 0x0800, []byte{0x00, 0x00})
+	data = AppendImplicitElement(data, 0x0000, 0x1000, []byte("1.2.3.4\x00"))
+	data = AppendImplicitElement(data, 0x0000, 0x1030, []byte("MOVESCU "))
+	data = AppendImplicitElement(data, 0x0000, 0x1031, []byte{0x2A, 0x00})
+
+	decoders := map[string]func([]byte) (*types.Message, error){
+		"DecodeCommand":     DecodeCommand,
+		"parseDIMSECommand": func(data []byte) (*types.Message, error) { return parseDIMSECommand(data, nil) },
+	}
+	for name, decode := range decoders {
+		msg, err := decode(data)
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		if msg.CommandField != types.CStoreRQ || msg.AffectedSOPInstanceUID != "1.2.3.4" {
+			t.Errorf("%s() = %+v, want C-STORE-RQ for 1.2.3.4", name, msg)
+		}
+		if msg.MoveOriginatorAETitle != "MOVESCU" || msg.MoveOriginatorMessageID != 42 {
+			t.Errorf("%s() Move Originator = %q / %d, want MOVESCU / 42",
+				name, msg.MoveOriginatorAETitle, msg.MoveOriginatorMessageID)
+		}
+	}
+
+	encoded := EncodeCommandSet(&types.Message{
+		CommandField:            types.CStoreRQ,
+		MessageID:               7,
+		AffectedSOPClassUID:     types.CTImageStorage,
+		AffectedSOPInstanceUID:  "1.2.3.4",
+		CommandDataSetType:      0x0000,
+		MoveOriginatorAETitle:   "MOVESCU",
+		MoveOriginatorMessageID: 42,
+	})
+	if err := ValidateCommandSet(encoded); err != nil {
+		t.Fatalf("ValidateCommandSet() error = %v", err)
+	}
+	msg, err := DecodeCommand(encoded)
+	if err != nil {
+		t.Fatalf("DecodeCommand() error = %v", err)
+	}
+	if msg.MoveOriginatorAETitle != "MOVESCU" || msg.MoveOriginatorMessageID != 42 {
+		t.Errorf("Round-trip Move Originator = %q / %d, want MOVESCU / 42",
+			msg.MoveOriginatorAETitle, msg.MoveOriginatorMessageID)
+	}
+}
+
+// outOfOrderCommand builds a command set with Command Data Set Type and the
+// other fields ahead of Command Field
+func outOfOrderCommand(commandField, messageID, dataSetType uint16, sopClassUID string) []byte {
+	var data []byte
+	data = AppendImplicitElement(data, 0x0000, 0x0800, []byte{byte(dataSetType), byte(dataSetType >> 8)})
+	data = AppendImplicitElement(data, 0x0000, 0x0002, padUID(sopClassUID))
+	data = AppendImplicitElement(data, 0x0000, 0x0110, []byte{byte(messageID), byte(messageID >> 8)})
+	data = AppendImplicitElement(data, 0x0000, 0x0100, []byte{byte(commandField), byte(commandField >> 8)})
+	return data
+}
+
+func padUID(uid string) []byte {
+	if len(uid)%2 == 1 {
+		return append([]byte(uid), 0x00)
+	}
+	return []byte(uid)
+}
+
+func TestDecodeCommand_OutOfOrderElements(t *testing.T) {
+	tests := []struct {
+		name         string
+		commandField uint16
+		dataSetType  uint16
+		sopClassUID  string
+	}{
+		{"C-ECHO-RQ without dataset", types.CEchoRQ, 0x0101, types.VerificationSOPClass},
+		{"C-FIND-RQ with dataset", types.CFindRQ, 0x0000, types.StudyRootQueryRetrieveInformationModelFind},
+	}
+
+	decoders := map[string]func([]byte) (*types.Message, error){
+		"DecodeCommand":     DecodeCommand,
+		"parseDIMSECommand": func(data []byte) (*types.Message, error) { return parseDIMSECommand(data, nil) },
+	}
+	for _, tt := range tests {
+		data := outOfOrderCommand(tt.commandField, 9, tt.dataSetType, tt.sopClassUID)
+		for name, decode := range decoders {
+			msg, err := decode(data)
+			if err != nil {
+				t.Fatalf("%s: %s() error = %v", tt.name, name, err)
+			}
+			if msg.CommandField != tt.commandField || msg.MessageID != 9 ||
+				msg.CommandDataSetType != tt.dataSetType || msg.AffectedSOPClassUID != tt.sopClassUID {
+				t.Errorf("%s: %s() = %+v", tt.name, name, msg)
+			}
+		}
+	}
+}
+
+func TestDecodeCommand_RequestedSOPClassOnly(t *testing.T) {
+	var data []byte
+	data = AppendImplicitElement(data, 0x0000, 0x0003, padUID(types.VerificationSOPClass))
+	data = AppendImplicitElement(data, 0x0000, 0x0100, []byte{0x30, 0x80})
+	data = AppendImplicitElement(data, 0x0000, 0x0120, []byte{0x05, 0x00})
+	data = AppendImplicitElement(data, 0x0000, 0x0800, []byte{0x01, 0x01})
+	data = AppendImplicitElement(data, 0x0000, 0x0900, []byte{0x00, 0x00})
+
+	decoders := map[string]func([]byte) (*types.Message, error){
+		"DecodeCommand":     DecodeCommand,
+		"parseDIMSECommand": func(data []byte) (*types.Message, error) { return parseDIMSECommand(data, nil) },
+	}
+	for name, decode := range decoders {
+		msg, err := decode(data)
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		if msg.AffectedSOPClassUID != "" || msg.RequestedSOPClassUID != types.VerificationSOPClass {
+			t.Errorf("%s() affected = %q, requested = %q", name, msg.AffectedSOPClassUID, msg.RequestedSOPClassUID)
+		}
+		if got := msg.EffectiveSOPClassUID(); got != types.VerificationSOPClass {
+			t.Errorf("%s() EffectiveSOPClassUID() = %q", name, got)
+		}
+	}
+}
+
+func TestDecodeCommand_NSet(t *testing.T) {
+	encoded := EncodeCommandSet(&types.Message{
+		CommandField:            NSetRQ,
+		MessageID:               4,
+		RequestedSOPClassUID:    types.ModalityPerformedProcedureStepSOPClass,
+		RequestedSOPInstanceUID: "1.2.3.4.5",
+		CommandDataSetType:      0x0000,
+	})
+	if err := ValidateCommandSet(encoded); err != nil {
+		t.Fatalf("ValidateCommandSet() error = %v", err)
+	}
+
+	decoders := map[string]func([]byte) (*types.Message, error){
+		"DecodeCommand":     DecodeCommand,
+		"parseDIMSECommand": func(data []byte) (*types.Message, error) { return parseDIMSECommand(data, nil) },
+	}
+	for name, decode := range decoders {
+		msg, err := decode(encoded)
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		if msg.CommandField != NSetRQ || msg.RequestedSOPInstanceUID != "1.2.3.4.5" ||
+			msg.EffectiveSOPClassUID() != types.ModalityPerformedProcedureStepSOPClass {
+			t.Errorf("%s() = %+v, want N-SET-RQ of MPPS 1.2.3.4.5", name, msg)
+		}
+	}
+}
+
+func TestDecodeCommand_TypeIDs(t *testing.T) {
+	for _, sent := range []*types.Message{
+		{CommandField: NEventReportRQ, MessageID: 5, AffectedSOPClassUID: types.StorageCommitmentPushModelSOPClass, EventTypeID: 2},
+		{CommandField: NActionRSP, MessageIDBeingRespondedTo: 6, AffectedSOPClassUID: types.StorageCommitmentPushModelSOPClass, ActionTypeID: 1, CommandDataSetType: 0x0101},
+	} {
+		encoded := EncodeCommandSet(sent)
+		if err := ValidateCommandSet(encoded); err != nil {
+			t.Fatalf("ValidateCommandSet() error = %v", err)
+		}
+
+		decoders := map[string]func([]byte) (*types.Message, error){
+			"DecodeCommand":     DecodeCommand,
+			"parseDIMSECommand": func(data []byte) (*types.Message, error) { return parseDIMSECommand(data, nil) },
+		}
+		for name, decode := range decoders {
+			msg, err := decode(encoded)
+			if err != nil {
+				t.Fatalf("%s() error = %v", name, err)
+			}
+			if msg.EventTypeID != sent.EventTypeID || msg.ActionTypeID != sent.ActionTypeID {
+				t.Errorf("%s() of 0x%04x: event type %d, action type %d; want %d, %d",
+					name, sent.CommandField, msg.EventTypeID, msg.ActionTypeID, sent.EventTypeID, sent.ActionTypeID)
+			}
+		}
+	}
+}
@@ -2,9 +2,12 @@ package dimse
 
 import (
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/interfaces"
@@ -24,6 +27,20 @@ const (
 	CEchoRQ   = 0x0030
 	CEchoRSP  = 0x8030
 	CCancelRQ = 0x0FFF
+
+	// DIMSE-N commands (PS3.7 9.3)
+	NEventReportRQ  = 0x0100
+	NEventReportRSP = 0x8100
+	NGetRQ          = 0x0110
+	NGetRSP         = 0x8110
+	NSetRQ          = 0x0120
+	NSetRSP         = 0x8120
+	NActionRQ       = 0x0130
+	NActionRSP      = 0x8130
+	NCreateRQ       = 0x0140
+	NCreateRSP      = 0x8140
+	NDeleteRQ       = 0x0150
+	NDeleteRSP      = 0x8150
 )
 
 // Status codes
@@ -31,6 +48,18 @@ const (
 	StatusSuccess = 0x0000
 	StatusPending = 0xFF00
 	StatusFailure = 0xC000
+	StatusCancel  = 0xFE00 // Matching or sub-operations terminated due to Cancel
+
+	StatusPendingOptionalKeysNotSupported = 0xFF01 // Pending: a match, but optional keys were not all supported
+	StatusMoveDestinationUnknown          = 0xA801
+	StatusDuplicateSOPInstance            = 0x0111 // Failure: the SOP Instance already exists
+	StatusCoercionOfDataElements          = 0xB000 // Warning: stored, but not exactly as sent
+	StatusDataSetDoesNotMatch             = 0xA900 // Failure: data set does not match SOP Class
+	StatusSOPClassNotSupported            = 0x0122 // Failure: SOP Class not supported
+	StatusOutOfResources                  = 0xA700 // Refused: Out of Resources
+	StatusInvalidAttributeValue           = 0x0106 // Failure: an attribute value is not valid
+	StatusProcessingFailure               = 0x0110 // Failure: general processing failure
+	StatusNoSuchSOPInstance               = 0x0112 // Failure: the SOP Instance is unknown
 )
 
 // PDULayer interface for sending responses
@@ -40,27 +69,258 @@ type PDULayer interface {
 	GetTransferSyntax(presContextID byte) (string, error)
 }
 
-// Service manages DIMSE operations and message routing
-type Service struct {
-	handler     interfaces.ServiceHandler
+// relationalQueryNegotiator is implemented by PDU layers that support SOP
+// Class Extended Negotiation of relational queries
+type relationalQueryNegotiator interface {
+	RelationalQueriesNegotiated(presContextID byte) bool
+}
+
+// abstractSyntaxResolver is implemented by PDU layers that report the
+// abstract syntax negotiated for a presentation context
+type abstractSyntaxResolver interface {
+	GetAbstractSyntax(presContextID byte) (string, error)
+}
+
+// presentationContextFinder is implemented by PDU layers that can look up
+// the presentation context accepted for an abstract syntax
+type presentationContextFinder interface {
+	FindPresentationContext(abstractSyntax string) (byte, string, error)
+}
+
+// scpRoleNegotiator is implemented by PDU layers that negotiate SCP/SCU Role
+// Selection (0x54), reporting whether the requestor took the SCP role for a
+// SOP class
+type scpRoleNegotiator interface {
+	SCPRoleNegotiated(abstractSyntax string) bool
+}
+
+// asyncOperationsNegotiator is implemented by PDU layers that negotiate an
+// Asynchronous Operations Window
+type asyncOperationsNegotiator interface {
+	AsyncOperationsPerformed() int
+}
+
+// pendingMessage accumulates the fragments of one message. PDVs carry no
+// message ID, so PDVs of different messages can only be told apart by
+// presentation context: fragments of messages on different contexts may be
+// interleaved, but on one context a message must be complete before the next
+// starts. There is at most one pending message per context.
+type pendingMessage struct {
+	contextID   byte
 	commandData []byte
 	datasetData []byte
-	currentMsg  *types.Message
-	logger      *slog.Logger
-	transferUID string
-	contextID   byte
+	msg         *types.Message
+}
+
+// associationAborter is implemented by PDU layers that can abort the
+// association, used when a handler outlives its timeout
+type associationAborter interface {
+	Abort()
+}
+
+// associationEnder is implemented by PDU layers that report when the
+// association is over, so nothing waits for a response that cannot come
+type associationEnder interface {
+	Done() <-chan struct{}
+}
+
+// commandRegistry is implemented by handlers that route by command field,
+// such as services.Registry, and can tell which commands they serve
+type commandRegistry interface {
+	HasHandler(commandField uint16) bool
+}
+
+// Service manages DIMSE operations and message routing
+type Service struct {
+	handler interfaces.ServiceHandler
+	logger  *slog.Logger
+
+	// pending holds partially received messages keyed by presentation context.
+	// It is only touched from HandleDIMSEMessage, which the PDU layer calls
+	// from its read loop.
+	pending map[byte]*pendingMessage
+
+	// operations tracks messages dispatched concurrently when an
+	// Asynchronous Operations Window above 1 was negotiated; operationSlots
+	// bounds them to the window.
+	operations     sync.WaitGroup
+	operationSlots chan struct{}
+
+	// messageIDMu guards nextMessageID, the ID of the next request this side
+	// originates (e.g. C-GET C-STORE sub-operations). It is scoped to the
+	// association so sub-operations of successive C-GETs never collide.
+	messageIDMu   sync.Mutex
+	nextMessageID uint16
+
+	// subOperations routes the C-STORE-RSP to each outstanding C-GET
+	// sub-operation, keyed by the message ID of its C-STORE-RQ
+	subOperationsMu sync.Mutex
+	subOperations   map[uint16]chan *types.Message
+
+	// cancels holds the context cancel function of each operation a
+	// C-CANCEL-RQ may stop, keyed by the operation's message ID
+	cancelsMu sync.Mutex
+	cancels   map[uint16]context.CancelCauseFunc
+
+	// resolveMoveDestination maps a C-MOVE destination AE title to its
+	// network address. Nil leaves resolution to the handler.
+	resolveMoveDestination func(aeTitle string) (string, bool)
+
+	// handlerTimeout bounds each handler invocation. Zero means no limit.
+	handlerTimeout time.Duration
+
+	// aeTitle is the local AE title reported to handlers in MessageContext
+	aeTitle string
+
+	// maxFindMatches caps the pending responses of each C-FIND. Zero means
+	// no limit.
+	maxFindMatches int
+
+	// defaultTransferSyntax encodes response datasets when the presentation
+	// context's transfer syntax is unknown. Empty means Explicit VR Little
+	// Endian.
+	defaultTransferSyntax string
+
+	// unsupportedCommandStatus picks the status of the failure response to
+	// a request the handler has no handler registered for. Nil means
+	// StatusFailure.
+	unsupportedCommandStatus func(msg *types.Message) uint16
 }
 
+// ServiceOption configures optional Service behaviour.
+type ServiceOption func(*Service)
+
+// WithInitialMessageID sets the first message ID used for requests the
+// service originates, such as C-GET C-STORE sub-operations. Defaults to 1.
+func WithInitialMessageID(id uint16) ServiceOption {
+	return func(d *Service) {
+		if id != 0 {
+			d.nextMessageID = id
+		}
+	}
+}
+
+// WithMoveDestinationResolver resolves C-MOVE destination AE titles before the
+// handler is called. The address is passed in MessageContext; unknown
+// destinations are answered with status 0xA801 without reaching the handler.
+func WithMoveDestinationResolver(resolve func(aeTitle string) (string, bool)) ServiceOption {
+	return func(d *Service) {
+		d.resolveMoveDestination = resolve
+	}
+}
+
+// WithHandlerTimeout bounds how long the handler may take to complete an
+// operation. The handler's context is cancelled at the deadline and the
+// operation is answered with a failure status; if the handler has not
+// returned shortly after, the association is aborted.
+func WithHandlerTimeout(timeout time.Duration) ServiceOption {
+	return func(d *Service) {
+		d.handlerTimeout = timeout
+	}
+}
+
+// ErrMaxFindMatches is returned by ResponseSender.SendResponse for C-FIND
+// matches beyond the limit set with WithMaxFindMatches. Handlers should stop
+// sending matches when they see it.
+var ErrMaxFindMatches = errors.New("maximum number of C-FIND matches reached")
+
+// WithMaxFindMatches caps the number of matches a streaming handler may send
+// for one C-FIND. Further matches are dropped, and the operation ends with
+// Success (0x0000): C-FIND defines no status for truncated results, so the
+// truncation is only logged. Zero means no limit.
+func WithMaxFindMatches(n int) ServiceOption {
+	return func(d *Service) {
+		d.maxFindMatches = n
+	}
+}
+
+// WithAETitle sets the local AE title passed to handlers in
+// MessageContext.AETitle.
+func WithAETitle(aeTitle string) ServiceOption {
+	return func(d *Service) {
+		d.aeTitle = aeTitle
+	}
+}
+
+// WithDefaultTransferSyntax sets the transfer syntax response datasets are
+// encoded in when the presentation context's is unknown, instead of Explicit
+// VR Little Endian.
+func WithDefaultTransferSyntax(uid string) ServiceOption {
+	return func(d *Service) {
+		d.defaultTransferSyntax = uid
+	}
+}
+
+// WithUnsupportedCommandHandler sets the status of the failure response sent
+// for requests whose command the handler reports it has no handler for (see
+// services.Registry.HasHandler), e.g. StatusSOPClassNotSupported (0x0122).
+// Such requests never reach the handler, which would fail them and end the
+// association. Defaults to StatusFailure (0xC000), which is also used when
+// status returns a code that is not a failure.
+func WithUnsupportedCommandHandler(status func(msg *types.Message) uint16) ServiceOption {
+	return func(d *Service) {
+		d.unsupportedCommandStatus = status
+	}
+}
+
+// ErrOperationCancelled is returned by ResponseSender.SendResponse for C-FIND
+// matches sent after the SCU cancelled the operation with a C-CANCEL-RQ. The
+// handler's context is cancelled too; handlers should stop sending matches
+// when they see either.
+var ErrOperationCancelled = errors.New("operation cancelled by C-CANCEL-RQ")
+
+// ErrUnexpectedPDV is returned by HandleDIMSEMessage for a PDV that does not
+// continue the message in progress on its presentation context, such as a
+// dataset fragment on a context with no command.
+var ErrUnexpectedPDV = errors.New("unexpected PDV")
+
 // responseHandler implements ResponseSender for streaming responses
 type responseHandler struct {
 	service               *Service
 	presContextID         byte
 	pduLayer              PDULayer
 	defaultTransferSyntax string
+
+	// ctx is the operation's context, cancelled by a C-CANCEL-RQ or when
+	// the handler times out
+	ctx context.Context
+
+	// C-FIND match counting for the maxFindMatches limit
+	matches   int
+	truncated bool // a match beyond the limit was dropped
+	finalSent bool // the handler sent the final C-FIND-RSP
+}
+
+// cancelled reports whether a C-CANCEL-RQ stopped the operation
+func (r *responseHandler) cancelled() bool {
+	return r.ctx != nil && errors.Is(context.Cause(r.ctx), ErrOperationCancelled)
 }
 
 // SendResponse implements ResponseSender interface
 func (r *responseHandler) SendResponse(msg *types.Message, dataset *dicom.Dataset, transferSyntaxUID string) error {
+	if msg.CommandField == CFindRSP {
+		pending := msg.Status == StatusPending || msg.Status == StatusPendingOptionalKeysNotSupported
+		if r.cancelled() {
+			// No match may follow the cancellation, and the operation ends
+			// with Cancel rather than Success (PS3.4 C.4.1.2.3)
+			if pending {
+				return ErrOperationCancelled
+			}
+			if msg.Status == StatusSuccess {
+				msg.Status = StatusCancel
+			}
+		}
+		if pending {
+			if limit := r.service.maxFindMatches; limit > 0 && r.matches >= limit {
+				r.truncated = true
+				return ErrMaxFindMatches
+			}
+			r.matches++
+		} else {
+			r.finalSent = true
+		}
+	}
+
 	tsUID := transferSyntaxUID
 	if tsUID == "" {
 		tsUID = r.defaultTransferSyntax
@@ -84,64 +344,257 @@ func (r *responseHandler) SendResponse(msg *types.Message, dataset *dicom.Datase
 // cGetResponder implements CGetResponder for C-GET operations
 type cGetResponder struct {
 	responseHandler
-	messageIDCounter uint16
+}
+
+// NextMessageID implements CGetResponder interface - returns the message ID
+// the next C-STORE sub-operation will use
+func (c *cGetResponder) NextMessageID() uint16 {
+	return c.service.NextMessageID()
 }
 
 // SendCStore implements CGetResponder interface - sends C-STORE sub-operation on same association
 func (c *cGetResponder) SendCStore(sopClassUID, sopInstanceUID string, data []byte) error {
-	c.messageIDCounter++
+	return c.SendCStoreAs(sopClassUID, sopInstanceUID, data, "")
+}
+
+// SendCStoreAs implements CGetTranscodingResponder interface - sends a C-STORE
+// sub-operation on the storage context accepted for sopClassUID, transcoding
+// data from transferSyntax when the context negotiated another one. An
+// empty transferSyntax sends data unchanged.
+func (c *cGetResponder) SendCStoreAs(sopClassUID, sopInstanceUID string, data []byte, transferSyntax string) error {
+	_, err := c.SendCStoreStatus(sopClassUID, sopInstanceUID, data, transferSyntax)
+	return err
+}
+
+// SendCStoreStatus implements CGetStatusResponder interface - SendCStoreAs
+// returning the status of the C-STORE-RSP, which is zero when none was read
+func (c *cGetResponder) SendCStoreStatus(sopClassUID, sopInstanceUID string, data []byte, transferSyntax string) (uint16, error) {
+	contextID, contextTS, err := c.storageContext(sopClassUID)
+	if err != nil {
+		return 0, fmt.Errorf("cannot send C-STORE sub-operation: %w", err)
+	}
+	if transferSyntax != "" && contextTS != "" && transferSyntax != contextTS {
+		if data, err = dicom.Transcode(data, transferSyntax, contextTS); err != nil {
+			return 0, fmt.Errorf("cannot send C-STORE sub-operation in %s: %w", contextTS, err)
+		}
+	}
+
+	messageID := c.service.allocateMessageID()
+
+	c.service.logger.Debug("Sending C-STORE sub-operation",
+		"message_id", messageID,
+		"sop_instance", sopInstanceUID,
+		"context_id", contextID)
 
 	// Build C-STORE-RQ command
 	command := &types.Message{
 		CommandField:           CStoreRQ,
-		MessageID:              c.messageIDCounter,
+		MessageID:              messageID,
 		Priority:               0x0002, // Medium priority
 		AffectedSOPClassUID:    sopClassUID,
 		AffectedSOPInstanceUID: sopInstanceUID,
 		CommandDataSetType:     0x0000, // Dataset present
 	}
 
-	commandData := c.service.createDIMSECommand(command)
+	commandData := EncodeCommandSet(command)
+	if err := ValidateCommandSet(commandData); err != nil {
+		return 0, fmt.Errorf("refusing to send C-STORE sub-operation: %w", err)
+	}
+
+	// Register before sending so a fast response is not missed
+	responses := c.service.expectSubOperationResponse(messageID)
+	defer c.service.forgetSubOperation(messageID)
 
 	// Send C-STORE-RQ with dataset on the same association
-	if err := c.pduLayer.SendDIMSEResponseWithDataset(c.presContextID, commandData, data); err != nil {
-		return fmt.Errorf("failed to send C-STORE sub-operation: %w", err)
+	if err := c.pduLayer.SendDIMSEResponseWithDataset(contextID, commandData, data); err != nil {
+		return 0, fmt.Errorf("failed to send C-STORE sub-operation: %w", err)
+	}
+
+	var response *types.Message
+	select {
+	case response = <-responses:
+	case <-associationDone(c.pduLayer):
+		return 0, fmt.Errorf("association ended before C-STORE-RSP to sub-operation %d", messageID)
+	case <-c.ctx.Done():
+		return 0, fmt.Errorf("gave up waiting for C-STORE-RSP to sub-operation %d: %w", messageID, context.Cause(c.ctx))
+	}
+
+	c.service.logger.Debug("Received C-STORE sub-operation response",
+		"message_id", messageID,
+		"status", fmt.Sprintf("0x%04x", response.Status))
+
+	// Warnings (0x0001, 0xBxxx) still mean the instance was stored
+	if response.Status != StatusSuccess && response.Status != 0x0001 && response.Status&0xF000 != 0xB000 {
+		if response.ErrorComment != "" {
+			return response.Status, fmt.Errorf("C-STORE sub-operation failed with status 0x%04x (%s): %s",
+				response.Status, StatusText(CStoreRSP, response.Status), response.ErrorComment)
+		}
+		return response.Status, fmt.Errorf("C-STORE sub-operation failed with status 0x%04x (%s)",
+			response.Status, StatusText(CStoreRSP, response.Status))
+	}
+	return response.Status, nil
+}
+
+// storageContext returns the presentation context a C-STORE sub-operation
+// for sopClassUID goes on, and its transfer syntax. The C-GET SCU must have
+// taken the SCP role for the SOP class (PS3.4 C.4.3.1.1) when the PDU layer
+// negotiates role selection. PDU layers that cannot look contexts up fall
+// back to the C-GET's own context.
+func (c *cGetResponder) storageContext(sopClassUID string) (byte, string, error) {
+	pduLayer := unwrapPDULayer(c.pduLayer)
+	if negotiator, ok := pduLayer.(scpRoleNegotiator); ok && !negotiator.SCPRoleNegotiated(sopClassUID) {
+		return 0, "", fmt.Errorf("SCP role not negotiated for %s", sopClassUID)
 	}
+	if finder, ok := pduLayer.(presentationContextFinder); ok {
+		return finder.FindPresentationContext(sopClassUID)
+	}
+	ts, _ := c.pduLayer.GetTransferSyntax(c.presContextID)
+	return c.presContextID, ts, nil
+}
+
+// expectSubOperationResponse registers a C-GET sub-operation whose
+// C-STORE-RSP is to be delivered on the returned channel
+func (d *Service) expectSubOperationResponse(messageID uint16) <-chan *types.Message {
+	d.subOperationsMu.Lock()
+	defer d.subOperationsMu.Unlock()
+
+	if d.subOperations == nil {
+		d.subOperations = make(map[uint16]chan *types.Message)
+	}
+	responses := make(chan *types.Message, 1)
+	d.subOperations[messageID] = responses
+	return responses
+}
+
+// forgetSubOperation stops routing responses to a sub-operation
+func (d *Service) forgetSubOperation(messageID uint16) {
+	d.subOperationsMu.Lock()
+	defer d.subOperationsMu.Unlock()
+	delete(d.subOperations, messageID)
+}
+
+// deliverSubOperationResponse hands a C-STORE-RSP to the sub-operation
+// waiting for it, reporting whether there was one
+func (d *Service) deliverSubOperationResponse(msg *types.Message) bool {
+	if msg.CommandField != CStoreRSP {
+		return false
+	}
+
+	d.subOperationsMu.Lock()
+	defer d.subOperationsMu.Unlock()
+
+	responses, ok := d.subOperations[msg.MessageIDBeingRespondedTo]
+	if !ok {
+		return false
+	}
+	delete(d.subOperations, msg.MessageIDBeingRespondedTo)
+	responses <- msg
+	return true
+}
+
+// trackCancel returns a context for operation messageID that a C-CANCEL-RQ
+// naming it cancels, and the function to call once the operation is over
+func (d *Service) trackCancel(ctx context.Context, messageID uint16) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	d.cancelsMu.Lock()
+	defer d.cancelsMu.Unlock()
+	if d.cancels == nil {
+		d.cancels = make(map[uint16]context.CancelCauseFunc)
+	}
+	d.cancels[messageID] = cancel
 
-	// Note: In a full implementation, we should wait for C-STORE-RSP
-	// For now, we'll assume success
+	return ctx, func() {
+		d.cancelsMu.Lock()
+		delete(d.cancels, messageID)
+		d.cancelsMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelOperation cancels the operation a C-CANCEL-RQ names. No response is
+// sent to the C-CANCEL-RQ itself; the operation's final response answers it.
+func (d *Service) cancelOperation(msg *types.Message) {
+	d.cancelsMu.Lock()
+	cancel, ok := d.cancels[msg.MessageIDBeingRespondedTo]
+	d.cancelsMu.Unlock()
+
+	if !ok {
+		d.logger.Debug("Ignoring C-CANCEL-RQ for no cancellable operation",
+			"message_id_being_responded_to", msg.MessageIDBeingRespondedTo)
+		return
+	}
+	d.logger.Info("Cancelling operation on C-CANCEL-RQ",
+		"message_id_being_responded_to", msg.MessageIDBeingRespondedTo)
+	cancel(ErrOperationCancelled)
+}
+
+// cancellable reports whether a C-CANCEL-RQ can stop msg. Only C-FINDs
+// answered by a streaming handler qualify: a single response leaves nothing
+// to cancel.
+func (d *Service) cancellable(msg *types.Message) bool {
+	if msg.CommandField != CFindRQ {
+		return false
+	}
+	_, ok := d.handler.(interfaces.StreamingServiceHandler)
+	return ok
+}
+
+// associationDone returns a channel closed when the association behind
+// pduLayer ends, or nil if the layer cannot tell
+func associationDone(pduLayer PDULayer) <-chan struct{} {
+	if ender, ok := unwrapPDULayer(pduLayer).(associationEnder); ok {
+		return ender.Done()
+	}
 	return nil
 }
 
 // NewService creates a new DIMSE service with a handler
-func NewService(handler interfaces.ServiceHandler, logger *slog.Logger) *Service {
+func NewService(handler interfaces.ServiceHandler, logger *slog.Logger, opts ...ServiceOption) *Service {
 	if logger == nil {
 		logger = slog.Default()
 	}
-	return &Service{
-		handler: handler,
-		logger:  logger,
+	service := &Service{
+		handler:       handler,
+		logger:        logger,
+		pending:       make(map[byte]*pendingMessage),
+		nextMessageID: 1,
+	}
+	for _, opt := range opts {
+		opt(service)
 	}
+	return service
 }
 
-// HandleDIMSEMessage processes DIMSE messages and routes to appropriate service
-func (d *Service) HandleDIMSEMessage(presContextID byte, msgCtrlHeader byte, data []byte, pduLayer PDULayer) error {
-	// Create context for this message handling
-	ctx := context.Background()
+// NextMessageID returns the message ID the next service-originated request will use
+func (d *Service) NextMessageID() uint16 {
+	d.messageIDMu.Lock()
+	defer d.messageIDMu.Unlock()
+	return d.nextMessageID
+}
+
+// allocateMessageID returns a message ID unique within the association.
+// Zero is skipped on wrap-around since it is not a valid message ID.
+func (d *Service) allocateMessageID() uint16 {
+	d.messageIDMu.Lock()
+	defer d.messageIDMu.Unlock()
 
+	id := d.nextMessageID
+	d.nextMessageID++
+	if d.nextMessageID == 0 {
+		d.nextMessageID = 1
+	}
+	return id
+}
+
+// HandleDIMSEMessage processes DIMSE messages and routes to appropriate service.
+// Messages are reassembled per presentation context; a message started on a
+// context before the previous one on it is complete aborts the association
+// with ErrUnexpectedPDV.
+func (d *Service) HandleDIMSEMessage(presContextID byte, msgCtrlHeader byte, data []byte, pduLayer PDULayer) error {
 	d.logger.Debug("Processing DIMSE message",
 		"context_id", presContextID,
 		"control_header", fmt.Sprintf("0x%02x", msgCtrlHeader))
-	tsUID, err := pduLayer.GetTransferSyntax(presContextID)
-	if err != nil {
-		d.logger.Warn("Failed to retrieve transfer syntax for presentation context",
-			"context_id", presContextID,
-			"error", err)
-	}
-	if tsUID != "" {
-		d.transferUID = tsUID
-	}
-	d.contextID = presContextID
 
 	// Check message control header
 	// 0x01 = command, more fragments
@@ -152,69 +605,196 @@ func (d *Service) HandleDIMSEMessage(presContextID byte, msgCtrlHeader byte, dat
 	isCommand := (msgCtrlHeader & 0x01) != 0
 	isLastFragment := (msgCtrlHeader & 0x02) != 0
 
+	pending, ok := d.pending[presContextID]
+	if !ok {
+		pending = &pendingMessage{contextID: presContextID}
+	}
+
+	// A dataset PDV continues the message whose command completed on the
+	// same presentation context, and a command PDV may not start before that
+	// message's dataset is complete. Anything else would assemble fragments
+	// of different messages, so the association is aborted.
+	if isCommand == (pending.msg != nil) {
+		delete(d.pending, presContextID)
+		d.logger.Warn("Aborting association on a PDV out of sequence",
+			"context_id", presContextID,
+			"command", isCommand)
+		if aborter, ok := pduLayer.(associationAborter); ok {
+			aborter.Abort()
+		}
+		if isCommand {
+			return fmt.Errorf("%w: command on presentation context %d before the dataset of message %d", ErrUnexpectedPDV, presContextID, pending.msg.MessageID)
+		}
+		return fmt.Errorf("%w: dataset on presentation context %d without a command", ErrUnexpectedPDV, presContextID)
+	}
+	d.pending[presContextID] = pending
+
 	if isCommand {
 		// This is command data
 		d.logger.Debug("Received command data", "size_bytes", len(data))
+		pending.commandData = append(pending.commandData, data...)
 		if isLastFragment {
-			// Complete command in one fragment
-			d.commandData = data
-			msg, err := parseDIMSECommand(data, d.logger)
+			if err := ValidateCommandSet(pending.commandData); err != nil {
+				delete(d.pending, presContextID)
+				return fmt.Errorf("invalid DIMSE command: %v", err)
+			}
+			msg, err := parseDIMSECommand(pending.commandData, d.logger)
 			if err != nil {
+				delete(d.pending, presContextID)
 				return fmt.Errorf("failed to parse DIMSE command: %v", err)
 			}
-			d.currentMsg = msg
+			pending.msg = msg
 
 			// If CommandDataSetType indicates no dataset, process immediately
 			if msg.CommandDataSetType == 0x0101 {
-				return d.processCompleteMessage(ctx, presContextID, pduLayer)
+				return d.dispatch(pending, pduLayer)
 			}
-		} else {
-			// Multi-fragment command (accumulate)
-			d.commandData = append(d.commandData, data...)
 		}
 	} else {
 		// This is dataset data
 		d.logger.Debug("Received dataset data", "size_bytes", len(data))
+		pending.datasetData = append(pending.datasetData, data...)
 		if isLastFragment {
-			// Complete dataset received
-			d.datasetData = append(d.datasetData, data...)
-			return d.processCompleteMessage(ctx, presContextID, pduLayer)
-		} else {
-			// Multi-fragment dataset (accumulate)
-			d.datasetData = append(d.datasetData, data...)
+			return d.dispatch(pending, pduLayer)
+		}
+	}
+
+	return nil
+}
+
+// dispatch hands a complete message to the handler. With a negotiated
+// Asynchronous Operations Window above 1 the message is processed in its own
+// goroutine, blocking only while the window is full; otherwise it is
+// processed before the next PDU is read.
+func (d *Service) dispatch(pending *pendingMessage, pduLayer PDULayer) error {
+	delete(d.pending, pending.contextID)
+	ctx := context.Background()
+
+	// Responses to C-GET sub-operations go to the operation awaiting them
+	if d.deliverSubOperationResponse(pending.msg) {
+		return nil
+	}
+	if pending.msg.CommandField == CCancelRQ {
+		d.cancelOperation(pending.msg)
+		return nil
+	}
+
+	// Registered before the operation starts so a C-CANCEL-RQ read right
+	// after it is not missed
+	cancellable := d.cancellable(pending.msg)
+	release := func() {}
+	if cancellable {
+		ctx, release = d.trackCancel(ctx, pending.msg.MessageID)
+	}
+
+	window := 1
+	if negotiator, ok := pduLayer.(asyncOperationsNegotiator); ok {
+		window = negotiator.AsyncOperationsPerformed()
+	}
+	if window <= 1 {
+		if pending.msg.CommandField != CGetRQ && !cancellable {
+			return d.processCompleteMessage(ctx, pending, pduLayer)
 		}
+		// A C-GET waits for the C-STORE-RSP of each sub-operation, and a
+		// cancellable operation for a C-CANCEL-RQ, so the read loop must stay
+		// free to deliver them
+		d.operations.Add(1)
+		go func() {
+			defer d.operations.Done()
+			defer release()
+			d.processConcurrently(ctx, pending, pduLayer)
+		}()
+		return nil
 	}
 
+	if d.operationSlots == nil {
+		d.operationSlots = make(chan struct{}, window)
+	}
+	d.operationSlots <- struct{}{}
+	d.operations.Add(1)
+	go func() {
+		defer func() {
+			release()
+			<-d.operationSlots
+			d.operations.Done()
+		}()
+		d.processConcurrently(ctx, pending, pduLayer)
+	}()
 	return nil
 }
 
+// processConcurrently processes a message off the read loop, where errors
+// can only be logged. A panic aborts the association, as the server's
+// recovery cannot reach this goroutine.
+func (d *Service) processConcurrently(ctx context.Context, pending *pendingMessage, pduLayer PDULayer) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.ErrorContext(ctx, "Panic while processing DIMSE message, aborting association",
+				"context_id", pending.contextID,
+				"message_id", pending.msg.MessageID,
+				"panic", r,
+				"stack", string(debug.Stack()))
+			if aborter, ok := pduLayer.(associationAborter); ok {
+				aborter.Abort()
+			}
+		}
+	}()
+
+	if err := d.processCompleteMessage(ctx, pending, pduLayer); err != nil {
+		d.logger.ErrorContext(ctx, "Failed to process DIMSE message",
+			"context_id", pending.contextID,
+			"message_id", pending.msg.MessageID,
+			"error", err)
+	}
+}
+
+// Wait blocks until all messages dispatched concurrently have been processed
+func (d *Service) Wait() {
+	d.operations.Wait()
+}
+
 // processCompleteMessage processes a complete DIMSE message (command + optional dataset)
-func (d *Service) processCompleteMessage(ctx context.Context, presContextID byte, pduLayer PDULayer) error {
-	if d.currentMsg == nil {
+func (d *Service) processCompleteMessage(ctx context.Context, pending *pendingMessage, pduLayer PDULayer) error {
+	if pending.msg == nil {
 		return fmt.Errorf("no current message to process")
 	}
+	msg := pending.msg
+	presContextID := pending.contextID
 
 	d.logger.InfoContext(ctx, "Processing complete DIMSE message",
-		"command_field", fmt.Sprintf("0x%04x", d.currentMsg.CommandField),
-		"message_id", d.currentMsg.MessageID,
-		"dataset_size", len(d.datasetData))
+		"command_field", fmt.Sprintf("0x%04x", msg.CommandField),
+		"message_id", msg.MessageID,
+		"dataset_size", len(pending.datasetData))
 
-	tsUID := d.transferUID
-	if tsUID == "" {
-		if negotiatedTS, err := pduLayer.GetTransferSyntax(presContextID); err == nil {
-			tsUID = negotiatedTS
-		} else {
-			d.logger.WarnContext(ctx, "Unable to determine transfer syntax for presentation context",
-				"context_id", presContextID,
-				"error", err)
-		}
+	tsUID, err := pduLayer.GetTransferSyntax(presContextID)
+	if err != nil {
+		d.logger.WarnContext(ctx, "Unable to determine transfer syntax for presentation context",
+			"context_id", presContextID,
+			"error", err)
+	}
+	msg.TransferSyntaxUID = tsUID
+
+	var abstractSyntax string
+	if resolver, ok := pduLayer.(abstractSyntaxResolver); ok {
+		abstractSyntax, _ = resolver.GetAbstractSyntax(presContextID)
+	}
+
+	// A Verification context only carries C-ECHO; anything else sent on it
+	// is a client bug and must not reach the handlers
+	if abstractSyntax == types.VerificationSOPClass && expectsResponse(msg.CommandField) && msg.CommandField != CEchoRQ {
+		d.logger.WarnContext(ctx, "Rejecting message sent on a Verification presentation context",
+			"context_id", presContextID,
+			"command_field", fmt.Sprintf("0x%04x", msg.CommandField),
+			"message_id", msg.MessageID)
+		response := failureResponse(msg, "Only C-ECHO is allowed on a Verification context")
+		response.Status = StatusSOPClassNotSupported
+		return d.sendDIMSEResponse(response, nil, presContextID, pduLayer)
 	}
-	d.currentMsg.TransferSyntaxUID = tsUID
 
 	var parsedDataset *dicom.Dataset
-	if len(d.datasetData) > 0 {
+	if len(pending.datasetData) > 0 {
 		var err error
-		parsedDataset, err = dicom.ParseDatasetWithTransferSyntax(d.datasetData, tsUID)
+		parsedDataset, err = dicom.ParseDatasetWithTransferSyntax(pending.datasetData, tsUID)
 		if err != nil {
 			d.logger.WarnContext(ctx, "Failed to parse dataset with negotiated transfer syntax",
 				"transfer_syntax", tsUID,
@@ -229,22 +809,95 @@ func (d *Service) processCompleteMessage(ctx context.Context, presContextID byte
 		PresentationContextID: presContextID,
 		TransferSyntaxUID:     tsUID,
 		Dataset:               parsedDataset,
+		AbstractSyntaxUID:     abstractSyntax,
+		AETitle:               d.aeTitle,
+	}
+	if negotiator, ok := pduLayer.(relationalQueryNegotiator); ok {
+		meta.RelationalQueries = negotiator.RelationalQueriesNegotiated(presContextID)
+	}
+
+	if msg.CommandField == CMoveRQ && d.resolveMoveDestination != nil {
+		address, ok := d.resolveMoveDestination(msg.MoveDestination)
+		if !ok {
+			d.logger.WarnContext(ctx, "Unknown C-MOVE destination",
+				"move_destination", msg.MoveDestination)
+			response := &types.Message{
+				CommandField:              CMoveRSP,
+				MessageIDBeingRespondedTo: msg.MessageID,
+				AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+				CommandDataSetType:        0x0101,
+				Status:                    StatusMoveDestinationUnknown,
+				ErrorComment:              "Move destination unknown",
+			}
+			return d.sendDIMSEResponse(response, nil, presContextID, pduLayer)
+		}
+		meta.MoveDestinationAddress = address
 	}
 
-	defer d.resetState()
+	if registry, ok := d.handler.(commandRegistry); ok && expectsResponse(msg.CommandField) && !registry.HasHandler(msg.CommandField) {
+		d.logger.WarnContext(ctx, "No handler registered for DIMSE command",
+			"command_field", fmt.Sprintf("0x%04x", msg.CommandField),
+			"message_id", msg.MessageID)
+		response := failureResponse(msg, "Unsupported DIMSE command")
+		if d.unsupportedCommandStatus != nil {
+			if status := d.unsupportedCommandStatus(msg); IsFailureStatus(status) {
+				response.Status = status
+			}
+		}
+		return d.sendDIMSEResponse(response, nil, presContextID, pduLayer)
+	}
+
+	invoke := func(ctx context.Context, pduLayer PDULayer) error {
+		return d.invokeHandler(ctx, msg, pending.datasetData, meta, pduLayer)
+	}
+	if d.handlerTimeout > 0 {
+		return d.invokeWithTimeout(ctx, msg, presContextID, pduLayer, invoke)
+	}
+	return invoke(ctx, pduLayer)
+}
+
+// invokeHandler runs the service handler for a message and sends its responses
+func (d *Service) invokeHandler(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, pduLayer PDULayer) error {
+	presContextID := meta.PresentationContextID
+	tsUID := meta.TransferSyntaxUID
+	if tsUID == "" {
+		tsUID = d.defaultTransferSyntax
+	}
 
 	if streamingHandler, ok := d.handler.(interfaces.StreamingServiceHandler); ok {
 		d.logger.DebugContext(ctx, "Using streaming handler for multi-response operation")
 
-		responder := d.buildResponder(presContextID, pduLayer, tsUID)
-		return streamingHandler.HandleDIMSEStreaming(ctx, d.currentMsg, d.datasetData, meta, responder)
+		responder := d.buildResponder(ctx, msg, presContextID, pduLayer, tsUID)
+		err := streamingHandler.HandleDIMSEStreaming(ctx, msg, data, meta, responder)
+		if finder, ok := responder.(*responseHandler); ok && msg.CommandField == CFindRQ {
+			if finder.cancelled() {
+				return d.finishCancelledFind(ctx, msg, finder, err)
+			}
+			if finder.truncated {
+				return d.finishTruncatedFind(ctx, msg, finder, err)
+			}
+		}
+		return err
 	}
 
-	responseMsg, responseDataset, err := d.handler.HandleDIMSE(ctx, d.currentMsg, d.datasetData, meta)
+	responseMsg, responseDataset, err := d.handler.HandleDIMSE(ctx, msg, data, meta)
 	if err != nil {
 		return fmt.Errorf("service handler failed: %w", err)
 	}
 
+	// No response message means nothing to send, which is only valid for
+	// messages that expect no reply
+	if responseMsg == nil {
+		if !expectsResponse(msg.CommandField) {
+			return nil
+		}
+		d.logger.ErrorContext(ctx, "Service handler returned no response",
+			"command_field", fmt.Sprintf("0x%04x", msg.CommandField),
+			"message_id", msg.MessageID)
+		responseMsg = failureResponse(msg, "No response from service handler")
+		responseDataset = nil
+	}
+
 	responseTS := responseMsg.TransferSyntaxUID
 	if responseTS == "" {
 		responseTS = tsUID
@@ -263,150 +916,195 @@ func (d *Service) processCompleteMessage(ctx context.Context, presContextID byte
 	return d.sendDIMSEResponse(responseMsg, encodedDataset, presContextID, pduLayer)
 }
 
-func (d *Service) buildResponder(presContextID byte, pduLayer PDULayer, defaultTS string) interfaces.ResponseSender {
+// finishTruncatedFind completes a C-FIND whose matches were capped by
+// maxFindMatches, sending the final Success response unless the handler did
+func (d *Service) finishTruncatedFind(ctx context.Context, msg *types.Message, responder *responseHandler, err error) error {
+	d.logger.WarnContext(ctx, "C-FIND matches truncated",
+		"message_id", msg.MessageID,
+		"max_matches", d.maxFindMatches)
+
+	if err != nil && !errors.Is(err, ErrMaxFindMatches) {
+		return err
+	}
+	if responder.finalSent {
+		return nil
+	}
+	return responder.SendResponse(&types.Message{
+		CommandField:              CFindRSP,
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+		CommandDataSetType:        0x0101,
+		Status:                    StatusSuccess,
+	}, nil, "")
+}
+
+// finishCancelledFind completes a C-FIND stopped by a C-CANCEL-RQ, sending
+// the final Cancel response unless the handler sent a final response
+func (d *Service) finishCancelledFind(ctx context.Context, msg *types.Message, responder *responseHandler, err error) error {
+	d.logger.InfoContext(ctx, "C-FIND cancelled",
+		"message_id", msg.MessageID,
+		"matches_sent", responder.matches)
+
+	// A handler giving up because of the cancellation has not failed
+	if err != nil && !errors.Is(err, ErrOperationCancelled) && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if responder.finalSent {
+		return nil
+	}
+	return responder.SendResponse(&types.Message{
+		CommandField:              CFindRSP,
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+		CommandDataSetType:        0x0101,
+		Status:                    StatusCancel,
+	}, nil, "")
+}
+
+// expectsResponse reports whether a received message must be answered.
+// Responses (e.g. C-STORE-RSP to a C-GET sub-operation) and C-CANCEL-RQ are not.
+func expectsResponse(commandField uint16) bool {
+	return commandField&0x8000 == 0 && commandField != CCancelRQ
+}
+
+// failureResponse builds a failure response to msg without a dataset
+func failureResponse(msg *types.Message, comment string) *types.Message {
+	return &types.Message{
+		CommandField:              msg.CommandField | 0x8000,
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.EffectiveSOPClassUID(),
+		CommandDataSetType:        0x0101,
+		Status:                    StatusFailure,
+		ErrorComment:              comment,
+	}
+}
+
+// invokeWithTimeout runs invoke with the handler timeout as its context
+// deadline. A handler still running at the deadline gets its operation
+// answered with a failure response; anything it sends afterwards is dropped,
+// and the association is aborted unless the handler returns meanwhile.
+func (d *Service) invokeWithTimeout(ctx context.Context, msg *types.Message, presContextID byte, pduLayer PDULayer, invoke func(context.Context, PDULayer) error) error {
+	ctx, cancel := context.WithTimeout(ctx, d.handlerTimeout)
+	defer cancel()
+
+	guarded := &guardedPDULayer{PDULayer: pduLayer}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				d.logger.ErrorContext(ctx, "Service handler panicked",
+					"message_id", msg.MessageID,
+					"panic", r,
+					"stack", string(debug.Stack()))
+				done <- fmt.Errorf("service handler for message %d panicked: %v", msg.MessageID, r)
+			}
+		}()
+		done <- invoke(ctx, guarded)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	guarded.close()
+	d.logger.WarnContext(ctx, "Service handler timed out",
+		"command_field", fmt.Sprintf("0x%04x", msg.CommandField),
+		"message_id", msg.MessageID,
+		"timeout", d.handlerTimeout)
+
+	response := failureResponse(msg, "Operation timed out")
+	if err := d.sendDIMSEResponse(response, nil, presContextID, pduLayer); err != nil {
+		d.logger.WarnContext(ctx, "Failed to send timeout response", "error", err)
+	}
+
+	// Give handlers honouring their context a moment to return before
+	// giving up on the association
+	grace := time.NewTimer(handlerTimeoutGrace)
+	defer grace.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-grace.C:
+	}
+
+	if aborter, ok := pduLayer.(associationAborter); ok {
+		aborter.Abort()
+	}
+	return fmt.Errorf("service handler for message %d did not return within %v", msg.MessageID, d.handlerTimeout)
+}
+
+// handlerTimeoutGrace is how long a timed-out handler may take to return
+// before the association is aborted
+const handlerTimeoutGrace = 250 * time.Millisecond
+
+// unwrapPDULayer returns the layer behind a guardedPDULayer, whose optional
+// capabilities the guard does not forward
+func unwrapPDULayer(pduLayer PDULayer) PDULayer {
+	if guarded, ok := pduLayer.(*guardedPDULayer); ok {
+		return guarded.PDULayer
+	}
+	return pduLayer
+}
+
+// guardedPDULayer forwards sends to a PDULayer until closed, after which they
+// fail. It keeps a timed out handler from answering an operation twice.
+type guardedPDULayer struct {
+	PDULayer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// errHandlerTimedOut is returned to handlers sending after their timeout
+var errHandlerTimedOut = errors.New("service handler timed out")
+
+func (g *guardedPDULayer) SendDIMSEResponse(presContextID byte, commandData []byte) error {
+	return g.SendDIMSEResponseWithDataset(presContextID, commandData, nil)
+}
+
+func (g *guardedPDULayer) SendDIMSEResponseWithDataset(presContextID byte, commandData []byte, datasetData []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return errHandlerTimedOut
+	}
+	return g.PDULayer.SendDIMSEResponseWithDataset(presContextID, commandData, datasetData)
+}
+
+// close waits for an in-flight send and rejects later ones
+func (g *guardedPDULayer) close() {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+}
+
+func (d *Service) buildResponder(ctx context.Context, msg *types.Message, presContextID byte, pduLayer PDULayer, defaultTS string) interfaces.ResponseSender {
 	base := responseHandler{
 		service:               d,
 		presContextID:         presContextID,
 		pduLayer:              pduLayer,
 		defaultTransferSyntax: defaultTS,
+		ctx:                   ctx,
 	}
 
-	if d.currentMsg != nil && d.currentMsg.CommandField == CGetRQ {
+	if msg.CommandField == CGetRQ {
 		return &cGetResponder{responseHandler: base}
 	}
 
 	return &base
 }
 
-func (d *Service) resetState() {
-	d.commandData = nil
-	d.datasetData = nil
-	d.currentMsg = nil
-	d.transferUID = ""
-	d.contextID = 0
-}
-
-// sendDIMSEResponse sends a DIMSE response
+// sendDIMSEResponse sends a DIMSE response, flagging whether data follows
 func (d *Service) sendDIMSEResponse(msg *types.Message, data []byte, presContextID byte, pduLayer PDULayer) error {
-	commandData := d.createDIMSECommand(msg)
-	return pduLayer.SendDIMSEResponseWithDataset(presContextID, commandData, data)
-}
-
-// createDIMSECommand creates a DIMSE command dataset
-func (d *Service) createDIMSECommand(msg *types.Message) []byte {
-	var elements []byte
+	msg.CommandDataSetType = CommandDataSetTypeFor(data)
 
-	// Affected SOP Class UID (0000,0002)
-	if msg.AffectedSOPClassUID != "" {
-		sopClassUID := msg.AffectedSOPClassUID
-		if len(sopClassUID)%2 == 1 {
-			sopClassUID += "\x00"
-		}
-		elements = append(elements, 0x00, 0x00, 0x02, 0x00) // Tag
-		sopLen := make([]byte, 4)
-		binary.LittleEndian.PutUint32(sopLen, uint32(len(sopClassUID)))
-		elements = append(elements, sopLen...)
-		elements = append(elements, []byte(sopClassUID)...)
-	}
-
-	// Command Field (0000,0100)
-	elements = append(elements, 0x00, 0x00, 0x00, 0x01) // Tag
-	elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-	cmdField := make([]byte, 2)
-	binary.LittleEndian.PutUint16(cmdField, msg.CommandField)
-	elements = append(elements, cmdField...)
-
-	// Message ID (0000,0110) - for requests
-	if msg.MessageID > 0 && msg.MessageIDBeingRespondedTo == 0 {
-		elements = append(elements, 0x00, 0x00, 0x10, 0x01) // Tag
-		elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		msgID := make([]byte, 2)
-		binary.LittleEndian.PutUint16(msgID, msg.MessageID)
-		elements = append(elements, msgID...)
-	}
-
-	// Message ID Being Responded To (0000,0120)
-	if msg.MessageIDBeingRespondedTo > 0 {
-		elements = append(elements, 0x00, 0x00, 0x20, 0x01) // Tag
-		elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		msgID := make([]byte, 2)
-		binary.LittleEndian.PutUint16(msgID, msg.MessageIDBeingRespondedTo)
-		elements = append(elements, msgID...)
-	}
-
-	// Affected SOP Instance UID (0000,1000) - for C-STORE
-	if msg.AffectedSOPInstanceUID != "" {
-		sopInstanceUID := msg.AffectedSOPInstanceUID
-		if len(sopInstanceUID)%2 == 1 {
-			sopInstanceUID += "\x00"
-		}
-		elements = append(elements, 0x00, 0x00, 0x00, 0x10) // Tag
-		sopInstLen := make([]byte, 4)
-		binary.LittleEndian.PutUint32(sopInstLen, uint32(len(sopInstanceUID)))
-		elements = append(elements, sopInstLen...)
-		elements = append(elements, []byte(sopInstanceUID)...)
-	}
-
-	// CommandDataSetType (0000,0800)
-	elements = append(elements, 0x00, 0x00, 0x00, 0x08) // Tag
-	elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-	cmdDataSetType := make([]byte, 2)
-	binary.LittleEndian.PutUint16(cmdDataSetType, msg.CommandDataSetType)
-	elements = append(elements, cmdDataSetType...)
-
-	// Status (0000,0900)
-	elements = append(elements, 0x00, 0x00, 0x00, 0x09) // Tag
-	elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-	status := make([]byte, 2)
-	binary.LittleEndian.PutUint16(status, msg.Status)
-	elements = append(elements, status...)
-
-	// C-MOVE response counters (optional, only for C-MOVE-RSP)
-	if msg.NumberOfRemainingSuboperations != nil {
-		// Number of Remaining Sub-operations (0000,1020)
-		elements = append(elements, 0x00, 0x00, 0x20, 0x10) // Tag
-		elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		remaining := make([]byte, 2)
-		binary.LittleEndian.PutUint16(remaining, *msg.NumberOfRemainingSuboperations)
-		elements = append(elements, remaining...)
-	}
-
-	if msg.NumberOfCompletedSuboperations != nil {
-		// Number of Completed Sub-operations (0000,1021)
-		elements = append(elements, 0x00, 0x00, 0x21, 0x10) // Tag
-		elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		completed := make([]byte, 2)
-		binary.LittleEndian.PutUint16(completed, *msg.NumberOfCompletedSuboperations)
-		elements = append(elements, completed...)
-	}
-
-	if msg.NumberOfFailedSuboperations != nil {
-		// Number of Failed Sub-operations (0000,1022)
-		elements = append(elements, 0x00, 0x00, 0x22, 0x10) // Tag
-		elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		failed := make([]byte, 2)
-		binary.LittleEndian.PutUint16(failed, *msg.NumberOfFailedSuboperations)
-		elements = append(elements, failed...)
-	}
-
-	if msg.NumberOfWarningSuboperations != nil {
-		// Number of Warning Sub-operations (0000,1023)
-		elements = append(elements, 0x00, 0x00, 0x23, 0x10) // Tag
-		elements = append(elements, 0x02, 0x00, 0x00, 0x00) // Length = 2
-		warning := make([]byte, 2)
-		binary.LittleEndian.PutUint16(warning, *msg.NumberOfWarningSuboperations)
-		elements = append(elements, warning...)
-	}
-
-	// Add Group Length (0000,0000) at the beginning
-	groupLengthValue := make([]byte, 4)
-	binary.LittleEndian.PutUint32(groupLengthValue, uint32(len(elements)))
-
-	var commandSet []byte
-	commandSet = append(commandSet, 0x00, 0x00, 0x00, 0x00) // Group Length tag
-	commandSet = append(commandSet, 0x04, 0x00, 0x00, 0x00) // Length = 4
-	commandSet = append(commandSet, groupLengthValue...)    // Value
-	commandSet = append(commandSet, elements...)
-
-	return commandSet
+	// The command set is always Implicit VR Little Endian and never
+	// compressed; only the dataset follows the context's transfer syntax
+	commandData := EncodeCommandSet(msg)
+	if err := ValidateCommandSet(commandData); err != nil {
+		return fmt.Errorf("refusing to send response: %w", err)
+	}
+	return pduLayer.SendDIMSEResponseWithDataset(presContextID, commandData, data)
 }
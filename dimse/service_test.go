@@ -1,9 +1,15 @@
 package dimse
 
 import (
+	"bytes"
+	"compress/flate"
 	"context"
 	"errors"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/interfaces"
@@ -16,6 +22,7 @@ type MockPDULayer struct {
 	SendDIMSEResponseWithDatasetFunc func(presContextID byte, commandData []byte, datasetData []byte) error
 	GetTransferSyntaxFunc            func(presContextID byte) (string, error)
 	TransferSyntaxUID                string
+	AbstractSyntaxUID                string
 }
 
 func (m *MockPDULayer) SendDIMSEResponse(presContextID byte, commandData []byte) error {
@@ -39,6 +46,10 @@ func (m *MockPDULayer) GetTransferSyntax(presContextID byte) (string, error) {
 	return m.TransferSyntaxUID, nil
 }
 
+func (m *MockPDULayer) GetAbstractSyntax(presContextID byte) (string, error) {
+	return m.AbstractSyntaxUID, nil
+}
+
 // MockServiceHandler is a mock implementation of ServiceHandler for testing
 type MockServiceHandler struct {
 	HandleDIMSEFunc func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error)
@@ -104,7 +115,7 @@ func TestService_HandleDIMSEMessage_CEchoNoDataset(t *testing.T) {
 		AffectedSOPClassUID: "1.2.840.10008.1.1",
 		CommandDataSetType:  0x0101, // No dataset
 	}
-	commandData := createDIMSECommand(msg)
+	commandData := EncodeCommandSet(msg)
 
 	// Send command (last fragment, no dataset)
 	err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer)
@@ -152,7 +163,7 @@ func TestService_HandleDIMSEMessage_WithDataset(t *testing.T) {
 		AffectedSOPClassUID: "1.2.840.10008.5.1.4.1.2.1.1",
 		CommandDataSetType:  0x0000, // Has dataset
 	}
-	commandData := createDIMSECommand(msg)
+	commandData := EncodeCommandSet(msg)
 
 	// Send command (last fragment)
 	err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer)
@@ -194,7 +205,7 @@ func TestService_HandleDIMSEMessage_MultiFragment(t *testing.T) {
 		AffectedSOPClassUID: "1.2.840.10008.5.1.4.1.2.1.1",
 		CommandDataSetType:  0x0000,
 	}
-	commandData := createDIMSECommand(msg)
+	commandData := EncodeCommandSet(msg)
 
 	// Send command (last fragment)
 	err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer)
@@ -247,7 +258,7 @@ func TestService_HandleDIMSEMessage_HandlerError(t *testing.T) {
 		AffectedSOPClassUID: "1.2.840.10008.1.1",
 		CommandDataSetType:  0x0101,
 	}
-	commandData := createDIMSECommand(msg)
+	commandData := EncodeCommandSet(msg)
 
 	// Send command
 	err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer)
@@ -286,7 +297,7 @@ func TestService_HandleDIMSEMessage_PDULayerError(t *testing.T) {
 		AffectedSOPClassUID: "1.2.840.10008.1.1",
 		CommandDataSetType:  0x0101,
 	}
-	commandData := createDIMSECommand(msg)
+	commandData := EncodeCommandSet(msg)
 
 	// Send command
 	err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer)
@@ -312,6 +323,10 @@ func TestService_CommandConstants(t *testing.T) {
 		{"C-MOVE-RSP", CMoveRSP, 0x8021},
 		{"C-ECHO-RQ", CEchoRQ, 0x0030},
 		{"C-ECHO-RSP", CEchoRSP, 0x8030},
+		{"N-SET-RQ", NSetRQ, 0x0120},
+		{"N-SET-RSP", NSetRSP, 0x8120},
+		{"N-CREATE-RQ", NCreateRQ, 0x0140},
+		{"N-CREATE-RSP", NCreateRSP, 0x8140},
 	}
 
 	for _, tt := range tests {
@@ -342,3 +357,1258 @@ func TestService_StatusConstants(t *testing.T) {
 		})
 	}
 }
+
+// mockStreamingHandler is a StreamingServiceHandler driven by a callback
+type mockStreamingHandler struct {
+	MockServiceHandler
+	HandleDIMSEStreamingFunc func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error
+}
+
+func (m *mockStreamingHandler) HandleDIMSEStreaming(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+	return m.HandleDIMSEStreamingFunc(ctx, msg, data, meta, responder)
+}
+
+func TestService_CGetSubOperationMessageIDs(t *testing.T) {
+	var reported []uint16
+	handler := &mockStreamingHandler{
+		HandleDIMSEStreamingFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+			getResponder, ok := responder.(interfaces.CGetResponder)
+			if !ok {
+				t.Fatal("Expected C-GET responder")
+			}
+			for i := 0; i < 3; i++ {
+				reported = append(reported, getResponder.NextMessageID())
+				if err := getResponder.SendCStore(types.CTImageStorage, "1.2.3", []byte{0x00}); err != nil {
+					return err
+				}
+			}
+			return responder.SendResponse(&types.Message{
+				CommandField:              CGetRSP,
+				MessageIDBeingRespondedTo: msg.MessageID,
+				CommandDataSetType:        0x0101,
+				Status:                    StatusSuccess,
+			}, nil, "")
+		},
+	}
+
+	var sent []uint16
+	service := NewService(handler, nil, WithInitialMessageID(100))
+	var pduLayer *MockPDULayer
+	pduLayer = &MockPDULayer{
+		TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			msg, err := parseDIMSECommand(commandData, nil)
+			if err != nil {
+				t.Fatalf("Failed to parse sent command: %v", err)
+			}
+			if msg.CommandField == CStoreRQ {
+				sent = append(sent, msg.MessageID)
+				answerSubOperation(t, service, pduLayer, msg.MessageID, StatusSuccess)
+			}
+			return nil
+		},
+	}
+
+	// Two C-GET operations on the same association
+	for _, id := range []uint16{1, 2} {
+		request := EncodeCommandSet(&types.Message{
+			CommandField:        CGetRQ,
+			MessageID:           id,
+			AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+			CommandDataSetType:  0x0000,
+		})
+		if err := service.HandleDIMSEMessage(1, 0x03, request, pduLayer); err != nil {
+			t.Fatalf("HandleDIMSEMessage(command) failed: %v", err)
+		}
+		if err := service.HandleDIMSEMessage(1, 0x02, []byte{}, pduLayer); err != nil {
+			t.Fatalf("HandleDIMSEMessage(dataset) failed: %v", err)
+		}
+		service.Wait()
+	}
+
+	if len(sent) != 6 {
+		t.Fatalf("Expected 6 C-STORE sub-operations, got %d", len(sent))
+	}
+	for i, id := range sent {
+		if id != uint16(100+i) {
+			t.Errorf("Sub-operation %d message ID = %d, want %d", i, id, 100+i)
+		}
+		if reported[i] != id {
+			t.Errorf("NextMessageID() before sub-operation %d = %d, want %d", i, reported[i], id)
+		}
+	}
+	if next := service.NextMessageID(); next != 106 {
+		t.Errorf("NextMessageID() = %d, want 106", next)
+	}
+}
+
+// answerSubOperation feeds the C-STORE-RSP to a sub-operation into the
+// service the way the PDU layer's read loop would
+func answerSubOperation(t *testing.T, service *Service, pduLayer PDULayer, messageID, status uint16) {
+	response := EncodeCommandSet(&types.Message{
+		CommandField:              CStoreRSP,
+		MessageIDBeingRespondedTo: messageID,
+		AffectedSOPClassUID:       types.CTImageStorage,
+		CommandDataSetType:        0x0101,
+		Status:                    status,
+	})
+	go func() {
+		if err := service.HandleDIMSEMessage(1, 0x03, response, pduLayer); err != nil {
+			t.Errorf("HandleDIMSEMessage(C-STORE-RSP) failed: %v", err)
+		}
+	}()
+}
+
+func TestService_CGetWaitsForSubOperationResponses(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	var results []error
+	handler := &mockStreamingHandler{
+		HandleDIMSEStreamingFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+			getResponder := responder.(interfaces.CGetResponder)
+			for i := 0; i < 2; i++ {
+				err := getResponder.SendCStore(types.CTImageStorage, "1.2.3", []byte{0x00})
+				results = append(results, err)
+				record("sub-operation returned")
+			}
+			return nil
+		},
+	}
+
+	service := NewService(handler, nil, WithInitialMessageID(10))
+	var pduLayer *MockPDULayer
+	pduLayer = &MockPDULayer{
+		TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			msg, err := parseDIMSECommand(commandData, nil)
+			if err != nil {
+				t.Fatalf("Failed to parse sent command: %v", err)
+			}
+			record("C-STORE-RQ")
+			// The first sub-operation succeeds, the second is refused
+			status := uint16(StatusSuccess)
+			if msg.MessageID == 11 {
+				status = 0xA700
+			}
+			time.AfterFunc(10*time.Millisecond, func() {
+				record("C-STORE-RSP")
+				answerSubOperation(t, service, pduLayer, msg.MessageID, status)
+			})
+			return nil
+		},
+	}
+
+	request := EncodeCommandSet(&types.Message{
+		CommandField:        CGetRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+		CommandDataSetType:  0x0101,
+	})
+	if err := service.HandleDIMSEMessage(1, 0x03, request, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage() failed: %v", err)
+	}
+	service.Wait()
+
+	want := []string{"C-STORE-RQ", "C-STORE-RSP", "sub-operation returned", "C-STORE-RQ", "C-STORE-RSP", "sub-operation returned"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want) {
+		t.Fatalf("Events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Event %d = %q, want %q (all: %v)", i, events[i], want[i], events)
+		}
+	}
+	if results[0] != nil {
+		t.Errorf("Successful sub-operation returned %v", results[0])
+	}
+	if results[1] == nil {
+		t.Error("Refused sub-operation returned no error")
+	}
+}
+
+func TestService_MessageIDWrapAround(t *testing.T) {
+	service := NewService(&MockServiceHandler{}, nil, WithInitialMessageID(0xFFFF))
+
+	if id := service.allocateMessageID(); id != 0xFFFF {
+		t.Errorf("allocateMessageID() = %d, want 65535", id)
+	}
+	if id := service.allocateMessageID(); id != 1 {
+		t.Errorf("allocateMessageID() after wrap = %d, want 1", id)
+	}
+}
+
+func TestService_MoveDestinationResolver(t *testing.T) {
+	table := map[string]string{"STORE_SCP": "127.0.0.1:11112"}
+	resolve := func(aeTitle string) (string, bool) {
+		address, ok := table[aeTitle]
+		return address, ok
+	}
+
+	tests := []struct {
+		name        string
+		destination string
+		wantAddress string
+		wantHandled bool
+		wantStatus  uint16
+	}{
+		{"Known destination", "STORE_SCP", "127.0.0.1:11112", true, StatusSuccess},
+		{"Unknown destination", "NOWHERE", "", false, StatusMoveDestinationUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			handler := &MockServiceHandler{
+				HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+					handled = true
+					if meta.MoveDestinationAddress != tt.wantAddress {
+						t.Errorf("MoveDestinationAddress = %q, want %q", meta.MoveDestinationAddress, tt.wantAddress)
+					}
+					return &types.Message{
+						CommandField:              CMoveRSP,
+						MessageIDBeingRespondedTo: msg.MessageID,
+						CommandDataSetType:        0x0101,
+						Status:                    StatusSuccess,
+					}, nil, nil
+				},
+			}
+
+			var response *types.Message
+			pduLayer := &MockPDULayer{
+				TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+				SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+					var err error
+					response, err = DecodeCommand(commandData)
+					return err
+				},
+			}
+
+			request, err := EncodeCommand(&types.Message{
+				CommandField:        CMoveRQ,
+				MessageID:           5,
+				AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelMove,
+				MoveDestination:     tt.destination,
+				CommandDataSetType:  0x0000,
+			})
+			if err != nil {
+				t.Fatalf("EncodeCommand() error = %v", err)
+			}
+
+			service := NewService(handler, nil, WithMoveDestinationResolver(resolve))
+			if err := service.HandleDIMSEMessage(1, 0x03, request, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(command) failed: %v", err)
+			}
+			if err := service.HandleDIMSEMessage(1, 0x02, []byte{}, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(dataset) failed: %v", err)
+			}
+
+			if handled != tt.wantHandled {
+				t.Errorf("Handler called = %v, want %v", handled, tt.wantHandled)
+			}
+			if response == nil {
+				t.Fatal("No response sent")
+			}
+			if response.CommandField != CMoveRSP || response.Status != tt.wantStatus {
+				t.Errorf("Response = 0x%04x status 0x%04x, want C-MOVE-RSP status 0x%04x",
+					response.CommandField, response.Status, tt.wantStatus)
+			}
+			if response.MessageIDBeingRespondedTo != 5 {
+				t.Errorf("MessageIDBeingRespondedTo = %d, want 5", response.MessageIDBeingRespondedTo)
+			}
+		})
+	}
+}
+
+// asyncMockPDULayer is a MockPDULayer that negotiated an Asynchronous
+// Operations Window
+type asyncMockPDULayer struct {
+	MockPDULayer
+	window int
+}
+
+func (m *asyncMockPDULayer) AsyncOperationsPerformed() int {
+	return m.window
+}
+
+// TestService_InterleavedMessages interleaves two messages on different
+// presentation contexts; interleaving on one context is refused, see
+// TestService_RejectsPDVOutOfSequence
+func TestService_InterleavedMessages(t *testing.T) {
+	datasets := map[uint16][]byte{
+		1: {0x10, 0x00, 0x10, 0x00, 'P', 'N', 0x04, 0x00, 'O', 'N', 'E', ' '},
+		2: {0x10, 0x00, 0x10, 0x00, 'P', 'N', 0x04, 0x00, 'T', 'W', 'O', ' '},
+	}
+	contexts := map[uint16]byte{1: 1, 2: 3}
+
+	command := func(messageID uint16) []byte {
+		data, err := EncodeCommand(&types.Message{
+			CommandField:        CFindRQ,
+			MessageID:           messageID,
+			AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+			CommandDataSetType:  0x0000,
+		})
+		if err != nil {
+			t.Fatalf("EncodeCommand() error = %v", err)
+		}
+		return data
+	}
+	cmd2 := command(2)
+
+	// Fragments of both messages interleaved; message 2's command is itself split
+	fragments := []struct {
+		contextID byte
+		header    byte
+		data      []byte
+	}{
+		{1, 0x03, command(1)},
+		{3, 0x01, cmd2[:10]},
+		{1, 0x00, datasets[1][:6]},
+		{3, 0x03, cmd2[10:]},
+		{3, 0x00, datasets[2][:3]},
+		{1, 0x02, datasets[1][6:]},
+		{3, 0x02, datasets[2][3:]},
+	}
+
+	tests := []struct {
+		name   string
+		window int
+	}{
+		{"Synchronous", 1},
+		{"Window of 2", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			received := make(map[uint16][]byte)
+			responded := make(map[uint16]byte)
+
+			// With a window of 2, message 1 is held until message 2 is being
+			// handled, which can only happen if they run concurrently
+			secondStarted := make(chan struct{})
+
+			handler := &MockServiceHandler{
+				HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+					if tt.window > 1 {
+						switch msg.MessageID {
+						case 1:
+							select {
+							case <-secondStarted:
+							case <-time.After(2 * time.Second):
+								t.Error("Messages were not handled concurrently")
+							}
+						case 2:
+							close(secondStarted)
+						}
+					}
+					if meta.PresentationContextID != contexts[msg.MessageID] {
+						t.Errorf("Message %d context = %d, want %d", msg.MessageID, meta.PresentationContextID, contexts[msg.MessageID])
+					}
+					mu.Lock()
+					received[msg.MessageID] = append([]byte(nil), data...)
+					mu.Unlock()
+					return &types.Message{
+						CommandField:              CFindRSP,
+						Status:                    StatusSuccess,
+						CommandDataSetType:        0x0101,
+						MessageIDBeingRespondedTo: msg.MessageID,
+					}, nil, nil
+				},
+			}
+
+			pduLayer := &asyncMockPDULayer{window: tt.window}
+			pduLayer.TransferSyntaxUID = dicom.TransferSyntaxExplicitVRLittleEndian
+			pduLayer.SendDIMSEResponseWithDatasetFunc = func(presContextID byte, commandData []byte, datasetData []byte) error {
+				response, err := DecodeCommand(commandData)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				responded[response.MessageIDBeingRespondedTo] = presContextID
+				mu.Unlock()
+				return nil
+			}
+
+			service := NewService(handler, nil)
+			for _, f := range fragments {
+				if err := service.HandleDIMSEMessage(f.contextID, f.header, f.data, pduLayer); err != nil {
+					t.Fatalf("HandleDIMSEMessage() error = %v", err)
+				}
+			}
+			service.Wait()
+
+			for id, want := range datasets {
+				if !bytes.Equal(received[id], want) {
+					t.Errorf("Message %d dataset = %q, want %q", id, received[id], want)
+				}
+				if responded[id] != contexts[id] {
+					t.Errorf("Message %d response sent on context %d, want %d", id, responded[id], contexts[id])
+				}
+			}
+		})
+	}
+}
+
+func TestService_NilResponseMessage(t *testing.T) {
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			return nil, nil, nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		msg       *types.Message
+		wantReply bool
+	}{
+		{
+			name: "C-ECHO request is answered with a failure",
+			msg: &types.Message{
+				CommandField:        CEchoRQ,
+				MessageID:           7,
+				AffectedSOPClassUID: types.VerificationSOPClass,
+				CommandDataSetType:  0x0101,
+			},
+			wantReply: true,
+		},
+		{
+			name: "C-STORE response is not answered",
+			msg: &types.Message{
+				CommandField:              CStoreRSP,
+				MessageIDBeingRespondedTo: 7,
+				AffectedSOPClassUID:       types.CTImageStorage,
+				CommandDataSetType:        0x0101,
+				Status:                    StatusSuccess,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var replies []*types.Message
+			pduLayer := &MockPDULayer{
+				SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+					reply, err := DecodeCommand(commandData)
+					if err != nil {
+						t.Fatalf("DecodeCommand() error = %v", err)
+					}
+					replies = append(replies, reply)
+					return nil
+				},
+			}
+
+			commandData, err := EncodeCommand(tt.msg)
+			if err != nil {
+				t.Fatalf("EncodeCommand() error = %v", err)
+			}
+
+			service := NewService(handler, nil)
+			if err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage() error = %v", err)
+			}
+
+			if !tt.wantReply {
+				if len(replies) != 0 {
+					t.Errorf("Sent %d responses, want none", len(replies))
+				}
+				return
+			}
+			if len(replies) != 1 {
+				t.Fatalf("Sent %d responses, want 1", len(replies))
+			}
+			reply := replies[0]
+			if reply.CommandField != CEchoRSP || reply.Status != StatusFailure || reply.MessageIDBeingRespondedTo != 7 {
+				t.Errorf("Response = 0x%04X status 0x%04X to %d, want C-ECHO-RSP failure to 7",
+					reply.CommandField, reply.Status, reply.MessageIDBeingRespondedTo)
+			}
+		})
+	}
+}
+
+func TestService_FailureResponseToDIMSENRequest(t *testing.T) {
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			return nil, nil, nil
+		},
+	}
+	var replies []*types.Message
+	pduLayer := &MockPDULayer{
+		TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			reply, err := DecodeCommand(commandData)
+			if err != nil {
+				t.Fatalf("DecodeCommand() error = %v", err)
+			}
+			replies = append(replies, reply)
+			return nil
+		},
+	}
+
+	// N-SET names its SOP class in Requested SOP Class UID
+	commandData, err := EncodeCommand(&types.Message{
+		CommandField:            NSetRQ,
+		MessageID:               3,
+		RequestedSOPClassUID:    types.ModalityPerformedProcedureStepSOPClass,
+		RequestedSOPInstanceUID: "1.2.3",
+		CommandDataSetType:      0x0101,
+	})
+	if err != nil {
+		t.Fatalf("EncodeCommand() error = %v", err)
+	}
+	service := NewService(handler, nil)
+	if err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage() error = %v", err)
+	}
+
+	if len(replies) != 1 {
+		t.Fatalf("Sent %d responses, want 1", len(replies))
+	}
+	if reply := replies[0]; reply.CommandField != NSetRSP || reply.AffectedSOPClassUID != types.ModalityPerformedProcedureStepSOPClass {
+		t.Errorf("Response = 0x%04X for SOP class %q, want N-SET-RSP for MPPS", reply.CommandField, reply.AffectedSOPClassUID)
+	}
+}
+
+func TestService_DeflatedContextKeepsCommandUncompressed(t *testing.T) {
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0010}, dicom.VR_PN, "DOE^JANE")
+	match.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "12345678")
+
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			return &types.Message{
+				CommandField:              CFindRSP,
+				MessageIDBeingRespondedTo: msg.MessageID,
+				AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+				CommandDataSetType:        0x0000,
+				Status:                    StatusPending,
+			}, match, nil
+		},
+	}
+
+	var command, dataset []byte
+	pduLayer := &MockPDULayer{
+		TransferSyntaxUID: types.DeflatedExplicitVRLittleEndian,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			command, dataset = commandData, datasetData
+			return nil
+		},
+	}
+
+	service := NewService(handler, nil)
+	request := EncodeCommandSet(&types.Message{
+		CommandField:        CFindRQ,
+		MessageID:           3,
+		AffectedSOPClassUID: types.PatientRootQueryRetrieveInformationModelFind,
+		CommandDataSetType:  0x0101,
+	})
+	if err := service.HandleDIMSEMessage(1, 0x03, request, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage() error = %v", err)
+	}
+
+	// The command PDV is plain Implicit VR Little Endian
+	if err := ValidateCommandSet(command); err != nil {
+		t.Fatalf("Command PDV is not Implicit VR Little Endian: %v", err)
+	}
+	if msg, err := DecodeCommand(command); err != nil || msg.CommandField != CFindRSP || msg.Status != StatusPending {
+		t.Errorf("DecodeCommand() = %+v, %v; want pending C-FIND-RSP", msg, err)
+	}
+
+	// The dataset PDV is deflated
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(dataset)))
+	if err != nil {
+		t.Fatalf("Dataset PDV does not inflate: %v", err)
+	}
+	if !bytes.Equal(inflated, match.EncodeDataset()) {
+		t.Errorf("Inflated dataset = % X, want % X", inflated, match.EncodeDataset())
+	}
+
+	// A deflated command is refused rather than misparsed
+	var deflated bytes.Buffer
+	writer, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	writer.Write(request)
+	writer.Close()
+	if err := service.HandleDIMSEMessage(1, 0x03, deflated.Bytes(), pduLayer); err == nil {
+		t.Error("HandleDIMSEMessage() accepted a deflated command set")
+	}
+}
+
+func TestService_RejectsNonEchoOnVerificationContext(t *testing.T) {
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			t.Errorf("Handler called for command 0x%04X on a Verification context", msg.CommandField)
+			return nil, nil, nil
+		},
+	}
+
+	var replies []*types.Message
+	pduLayer := &MockPDULayer{
+		TransferSyntaxUID: types.ImplicitVRLittleEndian,
+		AbstractSyntaxUID: types.VerificationSOPClass,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			reply, err := DecodeCommand(commandData)
+			if err != nil {
+				t.Fatalf("DecodeCommand() error = %v", err)
+			}
+			if len(datasetData) != 0 {
+				t.Errorf("Failure response carries a %d byte dataset", len(datasetData))
+			}
+			replies = append(replies, reply)
+			return nil
+		},
+	}
+
+	commandData, err := EncodeCommand(&types.Message{
+		CommandField:        CFindRQ,
+		MessageID:           3,
+		Priority:            0x0002,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+		CommandDataSetType:  0x0000,
+	})
+	if err != nil {
+		t.Fatalf("EncodeCommand() error = %v", err)
+	}
+	query := dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D})
+	datasetData, err := dicom.EncodeDatasetWithTransferSyntax(query, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	service := NewService(handler, nil)
+	if err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(command) error = %v", err)
+	}
+	if err := service.HandleDIMSEMessage(1, 0x02, datasetData, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(dataset) error = %v", err)
+	}
+
+	if len(replies) != 1 {
+		t.Fatalf("Sent %d responses, want 1", len(replies))
+	}
+	reply := replies[0]
+	if reply.CommandField != CFindRSP || reply.Status != StatusSOPClassNotSupported || reply.MessageIDBeingRespondedTo != 3 {
+		t.Errorf("Response = 0x%04X status 0x%04X to %d, want C-FIND-RSP 0x0122 to 3",
+			reply.CommandField, reply.Status, reply.MessageIDBeingRespondedTo)
+	}
+
+	// C-ECHO on the same context still reaches the handler
+	handler.HandleDIMSEFunc = nil
+	echo, err := EncodeCommand(&types.Message{
+		CommandField:        CEchoRQ,
+		MessageID:           4,
+		AffectedSOPClassUID: types.VerificationSOPClass,
+		CommandDataSetType:  0x0101,
+	})
+	if err != nil {
+		t.Fatalf("EncodeCommand() error = %v", err)
+	}
+	if err := service.HandleDIMSEMessage(1, 0x03, echo, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(C-ECHO) error = %v", err)
+	}
+	if len(replies) != 2 || replies[1].Status != StatusSuccess {
+		t.Errorf("C-ECHO on the Verification context was not answered with success")
+	}
+}
+
+func TestService_DefaultTransferSyntax(t *testing.T) {
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "123")
+
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			return &types.Message{
+				CommandField:              CFindRSP,
+				MessageIDBeingRespondedTo: msg.MessageID,
+				AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+				CommandDataSetType:        0x0000,
+				Status:                    StatusPending,
+			}, match, nil
+		},
+	}
+
+	commandData := EncodeCommandSet(&types.Message{
+		CommandField:        CFindRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+		CommandDataSetType:  0x0000,
+	})
+	query, err := dicom.EncodeDatasetWithTransferSyntax(dicom.NewQuery("STUDY"), types.ExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []ServiceOption
+		want string
+	}{
+		{"Explicit VR by default", nil, types.ExplicitVRLittleEndian},
+		{"configured Implicit VR", []ServiceOption{WithDefaultTransferSyntax(types.ImplicitVRLittleEndian)}, types.ImplicitVRLittleEndian},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []byte
+			pduLayer := &MockPDULayer{
+				// No transfer syntax is known for the presentation context
+				SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+					sent = datasetData
+					return nil
+				},
+			}
+
+			service := NewService(handler, nil, tt.opts...)
+			if err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(command) error = %v", err)
+			}
+			if err := service.HandleDIMSEMessage(1, 0x02, query, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(dataset) error = %v", err)
+			}
+
+			want, err := dicom.EncodeDatasetWithTransferSyntax(match, tt.want)
+			if err != nil {
+				t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+			}
+			if !bytes.Equal(sent, want) {
+				t.Errorf("Response dataset = % X, want % X (%s)", sent, want, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_OutOfOrderCommandElements(t *testing.T) {
+	var handled []*types.Message
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			handled = append(handled, msg)
+			return &types.Message{
+				CommandField:              msg.CommandField | 0x8000,
+				Status:                    StatusSuccess,
+				CommandDataSetType:        0x0101,
+				MessageIDBeingRespondedTo: msg.MessageID,
+			}, nil, nil
+		},
+	}
+	service := NewService(handler, nil)
+	pduLayer := &MockPDULayer{TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian}
+
+	// No dataset follows, so the request is handled as soon as the command arrives
+	echo := outOfOrderCommand(CEchoRQ, 1, 0x0101, types.VerificationSOPClass)
+	if err := service.HandleDIMSEMessage(1, 0x03, echo, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(C-ECHO) error = %v", err)
+	}
+	if len(handled) != 1 || handled[0].CommandField != CEchoRQ {
+		t.Fatalf("Handled %+v after the C-ECHO command, want the C-ECHO-RQ", handled)
+	}
+
+	// A dataset follows, so the request waits for it
+	find := outOfOrderCommand(CFindRQ, 2, 0x0000, types.StudyRootQueryRetrieveInformationModelFind)
+	if err := service.HandleDIMSEMessage(1, 0x03, find, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(C-FIND) error = %v", err)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("C-FIND-RQ handled before its dataset arrived")
+	}
+	identifier := []byte{0x08, 0x00, 0x52, 0x00, 0x06, 0x00, 0x00, 0x00, 'S', 'T', 'U', 'D', 'Y', ' '}
+	if err := service.HandleDIMSEMessage(1, 0x02, identifier, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(identifier) error = %v", err)
+	}
+	if len(handled) != 2 || handled[1].CommandField != CFindRQ || handled[1].MessageID != 2 {
+		t.Errorf("Handled %+v, want the C-FIND-RQ with message ID 2", handled)
+	}
+}
+
+// contextPDULayer is a MockPDULayer that also knows the accepted
+// presentation contexts, keyed by ID, and the SOP classes the requestor took
+// the SCP role for
+type contextPDULayer struct {
+	*MockPDULayer
+	contexts map[byte][2]string // Abstract syntax and transfer syntax
+	scpRoles map[string]bool
+}
+
+func (m *contextPDULayer) FindPresentationContext(abstractSyntax string) (byte, string, error) {
+	for id := byte(1); id < 255; id += 2 {
+		if ctx, ok := m.contexts[id]; ok && ctx[0] == abstractSyntax {
+			return id, ctx[1], nil
+		}
+	}
+	return 0, "", errors.New("no accepted presentation context for " + abstractSyntax)
+}
+
+func (m *contextPDULayer) SCPRoleNegotiated(abstractSyntax string) bool {
+	return m.scpRoles[abstractSyntax]
+}
+
+func TestService_CGetSubOperationUsesStorageContext(t *testing.T) {
+	instance := dicom.NewDataset()
+	instance.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3")
+	explicit, err := dicom.EncodeDatasetWithTransferSyntax(instance, dicom.TransferSyntaxExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("Failed to encode instance: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []ServiceOption
+	}{
+		{"no handler timeout", nil},
+		// The handler then sees the layer through the timeout guard
+		{"handler timeout", []ServiceOption{WithHandlerTimeout(5 * time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results []error
+			handler := &mockStreamingHandler{
+				HandleDIMSEStreamingFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+					getResponder := responder.(interfaces.CGetResponder)
+					results = append(results,
+						responder.(interfaces.CGetTranscodingResponder).SendCStoreAs(types.CTImageStorage, "1.2.3", explicit, dicom.TransferSyntaxExplicitVRLittleEndian),
+						getResponder.SendCStore(types.MRImageStorage, "1.2.4", explicit),
+						getResponder.SendCStore(types.UltrasoundImageStorage, "1.2.5", explicit))
+					return nil
+				},
+			}
+
+			service := NewService(handler, nil, tt.opts...)
+			var sentContexts []byte
+			var sentData []byte
+			pduLayer := &contextPDULayer{
+				contexts: map[byte][2]string{
+					1: {types.StudyRootQueryRetrieveInformationModelGet, dicom.TransferSyntaxExplicitVRLittleEndian},
+					3: {types.CTImageStorage, dicom.TransferSyntaxImplicitVRLittleEndian},
+					5: {types.UltrasoundImageStorage, dicom.TransferSyntaxImplicitVRLittleEndian},
+				},
+				// No SCP role for Ultrasound, so its context may not be used
+				scpRoles: map[string]bool{types.CTImageStorage: true, types.MRImageStorage: true},
+			}
+			pduLayer.MockPDULayer = &MockPDULayer{
+				TransferSyntaxUID: dicom.TransferSyntaxExplicitVRLittleEndian,
+				SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+					msg, err := parseDIMSECommand(commandData, nil)
+					if err != nil {
+						t.Fatalf("Failed to parse sent command: %v", err)
+					}
+					if msg.CommandField != CStoreRQ {
+						return nil
+					}
+					sentContexts = append(sentContexts, presContextID)
+					sentData = datasetData
+					answerSubOperation(t, service, pduLayer, msg.MessageID, StatusSuccess)
+					return nil
+				},
+			}
+
+			request := EncodeCommandSet(&types.Message{
+				CommandField:        CGetRQ,
+				MessageID:           1,
+				AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+				CommandDataSetType:  0x0101,
+			})
+			if err := service.HandleDIMSEMessage(1, 0x03, request, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage() failed: %v", err)
+			}
+			service.Wait()
+
+			if len(sentContexts) != 1 || sentContexts[0] != 3 {
+				t.Fatalf("C-STORE-RQ sent on contexts %v, want [3]", sentContexts)
+			}
+			if results[0] != nil {
+				t.Errorf("SendCStoreAs() error = %v", results[0])
+			}
+			parsed, err := dicom.ParseDatasetWithTransferSyntax(sentData, dicom.TransferSyntaxImplicitVRLittleEndian)
+			if err != nil {
+				t.Fatalf("Sub-operation data is not Implicit VR: %v", err)
+			}
+			if got := parsed.GetString(dicom.Tag{Group: 0x0008, Element: 0x0018}); got != "1.2.3" {
+				t.Errorf("SOP Instance UID = %q, want 1.2.3", got)
+			}
+			if results[1] == nil {
+				t.Error("SendCStore() without a storage context succeeded, want error")
+			}
+			if results[2] == nil || !strings.Contains(results[2].Error(), "SCP role") {
+				t.Errorf("SendCStore() without the SCP role error = %v, want SCP role error", results[2])
+			}
+		})
+	}
+}
+
+func TestService_CGetSubOperationWaitEndsWithHandlerTimeout(t *testing.T) {
+	result := make(chan error, 1)
+	handler := &mockStreamingHandler{
+		HandleDIMSEStreamingFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+			result <- responder.(interfaces.CGetResponder).SendCStore(types.CTImageStorage, "1.2.3", []byte{0x08, 0x00, 0x18, 0x00, 0x02, 0x00, 0x00, 0x00, '1', 0x00})
+			return nil
+		},
+	}
+
+	// The layer cannot report the end of the association and the
+	// C-STORE-RSP never comes, so only the handler timeout ends the wait
+	pduLayer := &contextPDULayer{
+		MockPDULayer: &MockPDULayer{TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian},
+		contexts:     map[byte][2]string{3: {types.CTImageStorage, dicom.TransferSyntaxImplicitVRLittleEndian}},
+		scpRoles:     map[string]bool{types.CTImageStorage: true},
+	}
+	service := NewService(handler, nil, WithHandlerTimeout(50*time.Millisecond))
+
+	request := EncodeCommandSet(&types.Message{
+		CommandField:        CGetRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelGet,
+		CommandDataSetType:  0x0101,
+	})
+	_ = service.HandleDIMSEMessage(1, 0x03, request, pduLayer)
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("SendCStore() error = %v, want the handler timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendCStore() still waiting for the C-STORE-RSP after the handler timeout")
+	}
+}
+
+func TestService_CancelStreamingFind(t *testing.T) {
+	tests := []struct {
+		name string
+		// final is sent by the handler once it sees the cancellation; nil
+		// leaves the final response to the service
+		final *types.Message
+	}{
+		{"handler stops", nil},
+		{"handler sends Success", &types.Message{CommandField: CFindRSP, MessageIDBeingRespondedTo: 5, CommandDataSetType: 0x0101, Status: StatusSuccess}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := dicom.NewDataset()
+			match.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "123")
+
+			firstMatch := make(chan struct{})
+			handler := &mockStreamingHandler{
+				HandleDIMSEStreamingFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+					for i := 0; ; i++ {
+						err := responder.SendResponse(&types.Message{
+							CommandField:              CFindRSP,
+							MessageIDBeingRespondedTo: msg.MessageID,
+							CommandDataSetType:        0x0000,
+							Status:                    StatusPending,
+						}, match, "")
+						if i == 0 {
+							close(firstMatch)
+						}
+						if err == nil && ctx.Err() == nil {
+							continue
+						}
+						if tt.final != nil {
+							return responder.SendResponse(tt.final, nil, "")
+						}
+						return err
+					}
+				},
+			}
+
+			var mu sync.Mutex
+			var responses []*types.Message
+			pduLayer := &MockPDULayer{
+				TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+				SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+					msg, err := DecodeCommand(commandData)
+					if err != nil {
+						t.Errorf("DecodeCommand() error = %v", err)
+						return err
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					responses = append(responses, msg)
+					return nil
+				},
+			}
+
+			service := NewService(handler, nil)
+			find := EncodeCommandSet(&types.Message{
+				CommandField:        CFindRQ,
+				MessageID:           5,
+				AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+				CommandDataSetType:  0x0101,
+			})
+			if err := service.HandleDIMSEMessage(1, 0x03, find, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(C-FIND) error = %v", err)
+			}
+
+			// The read loop is free while the handler streams matches
+			select {
+			case <-firstMatch:
+			case <-time.After(time.Second):
+				t.Fatal("Handler did not start streaming")
+			}
+			cancel := EncodeCommandSet(&types.Message{
+				CommandField:              CCancelRQ,
+				MessageIDBeingRespondedTo: 5,
+				CommandDataSetType:        0x0101,
+			})
+			if err := service.HandleDIMSEMessage(1, 0x03, cancel, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(C-CANCEL) error = %v", err)
+			}
+			service.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(responses) < 2 {
+				t.Fatalf("Sent %d responses, want matches and a final response", len(responses))
+			}
+			for _, response := range responses[:len(responses)-1] {
+				if response.Status != StatusPending {
+					t.Errorf("Response before the last has status 0x%04X, want Pending", response.Status)
+				}
+			}
+			final := responses[len(responses)-1]
+			if final.CommandField != CFindRSP || final.Status != StatusCancel || final.MessageIDBeingRespondedTo != 5 {
+				t.Errorf("Final response = 0x%04X status 0x%04X to %d, want C-FIND-RSP 0xFE00 to 5",
+					final.CommandField, final.Status, final.MessageIDBeingRespondedTo)
+			}
+		})
+	}
+}
+
+// abortingPDULayer is a MockPDULayer that records A-ABORTs
+type abortingPDULayer struct {
+	MockPDULayer
+	aborted bool
+}
+
+func (m *abortingPDULayer) Abort() {
+	m.aborted = true
+}
+
+func TestService_HandlerTimeoutWaitsForHandlerToReturn(t *testing.T) {
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			// Returns shortly after its context is done, as handlers should
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			return nil, nil, ctx.Err()
+		},
+	}
+	service := NewService(handler, nil, WithHandlerTimeout(20*time.Millisecond))
+	sent := 0
+	pduLayer := &abortingPDULayer{MockPDULayer: MockPDULayer{
+		TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			sent++
+			return nil
+		},
+	}}
+
+	echo := EncodeCommandSet(&types.Message{
+		CommandField:        CEchoRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.VerificationSOPClass,
+		CommandDataSetType:  0x0101,
+	})
+	if err := service.HandleDIMSEMessage(1, 0x03, echo, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage() error = %v", err)
+	}
+	if pduLayer.aborted {
+		t.Error("Association aborted although the handler returned")
+	}
+	if sent != 1 {
+		t.Errorf("Sent %d responses, want the timeout failure only", sent)
+	}
+}
+
+func TestService_RejectsPDVOutOfSequence(t *testing.T) {
+	find := EncodeCommandSet(&types.Message{
+		CommandField:        CFindRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+		CommandDataSetType:  0x0000,
+	})
+	identifier := []byte{0x08, 0x00, 0x52, 0x00, 0x06, 0x00, 0x00, 0x00, 'S', 'T', 'U', 'D', 'Y', ' '}
+
+	tests := []struct {
+		name      string
+		contextID byte
+		header    byte
+		data      []byte
+	}{
+		{"dataset on another context", 3, 0x02, identifier},
+		// Interleaving two messages on one context cannot be reassembled
+		{"second message on the same context", 1, 0x03, find},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &MockServiceHandler{
+				HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+					t.Errorf("Handler called with a misassembled message %+v", msg)
+					return nil, nil, nil
+				},
+			}
+			service := NewService(handler, nil)
+			pduLayer := &abortingPDULayer{MockPDULayer: MockPDULayer{TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian}}
+
+			if err := service.HandleDIMSEMessage(1, 0x03, find, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage(command) error = %v", err)
+			}
+			err := service.HandleDIMSEMessage(tt.contextID, tt.header, tt.data, pduLayer)
+			if !errors.Is(err, ErrUnexpectedPDV) {
+				t.Errorf("HandleDIMSEMessage() error = %v, want ErrUnexpectedPDV", err)
+			}
+			if !pduLayer.aborted {
+				t.Error("Association not aborted")
+			}
+		})
+	}
+}
+
+func TestService_ResponseDataSetType(t *testing.T) {
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "123")
+
+	tests := []struct {
+		name        string
+		dataset     *dicom.Dataset
+		wantType    uint16
+		wantDataset bool
+	}{
+		{"nil dataset", nil, 0x0101, false},
+		{"empty dataset", dicom.NewDataset(), 0x0101, false},
+		{"one element", match, 0x0000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &MockServiceHandler{
+				HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+					// The handler claims a dataset whatever it returns
+					return &types.Message{
+						CommandField:              CFindRSP,
+						MessageIDBeingRespondedTo: msg.MessageID,
+						AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+						CommandDataSetType:        0x0000,
+						Status:                    StatusPending,
+					}, tt.dataset, nil
+				},
+			}
+
+			var response *types.Message
+			var sent []byte
+			pduLayer := &MockPDULayer{
+				TransferSyntaxUID: dicom.TransferSyntaxImplicitVRLittleEndian,
+				SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+					var err error
+					response, err = DecodeCommand(commandData)
+					sent = datasetData
+					return err
+				},
+			}
+
+			request := EncodeCommandSet(&types.Message{
+				CommandField:        CFindRQ,
+				MessageID:           1,
+				AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+				CommandDataSetType:  0x0101,
+			})
+			if err := NewService(handler, nil).HandleDIMSEMessage(1, 0x03, request, pduLayer); err != nil {
+				t.Fatalf("HandleDIMSEMessage() error = %v", err)
+			}
+
+			if response == nil {
+				t.Fatal("No response sent")
+			}
+			if response.CommandDataSetType != tt.wantType {
+				t.Errorf("CommandDataSetType = 0x%04X, want 0x%04X", response.CommandDataSetType, tt.wantType)
+			}
+			if (len(sent) > 0) != tt.wantDataset {
+				t.Errorf("Sent a %d byte dataset, want dataset present = %v", len(sent), tt.wantDataset)
+			}
+		})
+	}
+}
+
+func TestService_NEventReportDataset(t *testing.T) {
+	referencedSOPSeq := dicom.Tag{Group: 0x0008, Element: 0x1199}
+	referencedSOPInstance := dicom.Tag{Group: 0x0008, Element: 0x1155}
+
+	var received *dicom.Dataset
+	var eventType uint16
+	handler := &MockServiceHandler{
+		HandleDIMSEFunc: func(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+			received, eventType = meta.Dataset, msg.EventTypeID
+			return &types.Message{
+				CommandField:              NEventReportRSP,
+				MessageIDBeingRespondedTo: msg.MessageID,
+				AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+				EventTypeID:               msg.EventTypeID,
+				CommandDataSetType:        0x0101,
+				Status:                    StatusSuccess,
+			}, nil, nil
+		},
+	}
+	var reply *types.Message
+	pduLayer := &MockPDULayer{
+		TransferSyntaxUID: types.ImplicitVRLittleEndian,
+		SendDIMSEResponseWithDatasetFunc: func(presContextID byte, commandData []byte, datasetData []byte) error {
+			var err error
+			reply, err = DecodeCommand(commandData)
+			return err
+		},
+	}
+
+	// Storage commitment result: Event Type 1, every instance committed
+	item := dicom.NewDataset()
+	item.AddElement(dicom.Tag{Group: 0x0008, Element: 0x1150}, dicom.VR_UI, types.CTImageStorage)
+	item.AddElement(referencedSOPInstance, dicom.VR_UI, "1.2.3.4.5")
+	eventInfo := dicom.NewDataset()
+	eventInfo.AddElement(dicom.Tag{Group: 0x0008, Element: 0x1195}, dicom.VR_UI, "1.2.3.9")
+	eventInfo.AddElement(referencedSOPSeq, dicom.VR_SQ, []*dicom.Dataset{item})
+	datasetData, err := dicom.EncodeDatasetWithTransferSyntax(eventInfo, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+	commandData := EncodeCommandSet(&types.Message{
+		CommandField:           NEventReportRQ,
+		MessageID:              8,
+		AffectedSOPClassUID:    types.StorageCommitmentPushModelSOPClass,
+		AffectedSOPInstanceUID: "1.2.840.10008.1.20.1.1", // Well-known Storage Commitment SOP Instance
+		EventTypeID:            1,
+		CommandDataSetType:     0x0000,
+	})
+
+	service := NewService(handler, nil)
+	if err := service.HandleDIMSEMessage(1, 0x03, commandData, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(command) error = %v", err)
+	}
+	if err := service.HandleDIMSEMessage(1, 0x02, datasetData, pduLayer); err != nil {
+		t.Fatalf("HandleDIMSEMessage(dataset) error = %v", err)
+	}
+
+	if received == nil {
+		t.Fatal("Handler did not receive the Event Information")
+	}
+	if eventType != 1 {
+		t.Errorf("Event Type ID = %d, want 1", eventType)
+	}
+	items, ok := received.Elements[referencedSOPSeq].Value.([]*dicom.Dataset)
+	if !ok || len(items) != 1 {
+		t.Fatalf("Referenced SOP Sequence = %v, want one item", received.Elements[referencedSOPSeq])
+	}
+	if got := items[0].GetString(referencedSOPInstance); got != "1.2.3.4.5" {
+		t.Errorf("Referenced SOP Instance UID = %q, want 1.2.3.4.5", got)
+	}
+	if reply == nil || reply.CommandField != NEventReportRSP || reply.EventTypeID != 1 {
+		t.Errorf("Response = %+v, want N-EVENT-REPORT-RSP for event type 1", reply)
+	}
+}
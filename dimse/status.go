@@ -0,0 +1,114 @@
+package dimse
+
+// StatusText returns the meaning PS3.4 gives status in responses to
+// commandField, which may be the request or the response command, e.g.
+// "Refused: Out of Resources" for 0xA700 on C-STORE. Codes without a defined
+// meaning for the command are described by their class: "Failure", "Warning"
+// or "Unknown status".
+func StatusText(commandField uint16, status uint16) string {
+	switch commandField &^ 0x8000 {
+	case CStoreRQ:
+		switch {
+		case status&0xFF00 == 0xA700:
+			return "Refused: Out of Resources"
+		case status&0xFF00 == 0xA900:
+			return "Error: Data Set does not match SOP Class"
+		case status&0xF000 == 0xC000:
+			return "Error: Cannot understand"
+		case status == 0xB000:
+			return "Warning: Coercion of Data Elements"
+		case status == 0xB006:
+			return "Warning: Elements Discarded"
+		case status == 0xB007:
+			return "Warning: Data Set does not match SOP Class"
+		}
+	case CFindRQ:
+		switch {
+		case status == 0xA700:
+			return "Refused: Out of Resources"
+		case status == 0xA900:
+			return "Failed: Identifier does not match SOP Class"
+		case status&0xF000 == 0xC000:
+			return "Failed: Unable to process"
+		case status == StatusPendingOptionalKeysNotSupported:
+			return "Pending: Optional Keys not supported"
+		}
+	case CMoveRQ, CGetRQ:
+		switch {
+		case status == 0xA701:
+			return "Refused: Out of Resources - Unable to calculate number of matches"
+		case status == 0xA702:
+			return "Refused: Out of Resources - Unable to perform sub-operations"
+		case status == StatusMoveDestinationUnknown && commandField&^0x8000 == CMoveRQ:
+			return "Refused: Move Destination unknown"
+		case status == 0xA900:
+			return "Failed: Identifier does not match SOP Class"
+		case status&0xF000 == 0xC000:
+			return "Failed: Unable to process"
+		case status == 0xB000:
+			return "Warning: Sub-operations Complete - One or more Failures or Warnings"
+		}
+	}
+
+	// Codes shared by all DIMSE services (PS3.7 Annex C)
+	switch status {
+	case StatusSuccess:
+		return "Success"
+	case StatusPending:
+		return "Pending"
+	case StatusCancel:
+		return "Cancel"
+	case 0x0001:
+		return "Warning: Requested optional Attributes are not supported"
+	case 0x0105:
+		return "Failure: No such attribute"
+	case StatusInvalidAttributeValue:
+		return "Failure: Invalid attribute value"
+	case StatusProcessingFailure:
+		return "Failure: Processing failure"
+	case StatusDuplicateSOPInstance:
+		return "Failure: Duplicate SOP Instance"
+	case StatusNoSuchSOPInstance:
+		return "Failure: No such SOP Instance"
+	case 0x0117:
+		return "Failure: Invalid SOP Instance"
+	case 0x0118:
+		return "Failure: No such SOP Class"
+	case StatusSOPClassNotSupported:
+		return "Refused: SOP Class not supported"
+	case 0x0124:
+		return "Refused: Not authorized"
+	case 0x0210:
+		return "Failure: Duplicate invocation"
+	case 0x0211:
+		return "Failure: Unrecognized operation"
+	case 0x0212:
+		return "Failure: Mistyped argument"
+	}
+
+	switch status & 0xF000 {
+	case 0xA000, 0xC000:
+		return "Failure"
+	case 0xB000:
+		return "Warning"
+	}
+	return "Unknown status"
+}
+
+// IsFailureStatus reports whether status is a failure or refusal (PS3.7
+// Annex C): the 0xA000 and 0xC000 classes and the failure codes shared by
+// all DIMSE services. Success, warnings (0xBxxx, 0x0001, 0x0107, 0x0116),
+// pending and cancel statuses are not failures.
+func IsFailureStatus(status uint16) bool {
+	switch status & 0xF000 {
+	case 0xA000, 0xC000:
+		return true
+	}
+	switch status {
+	case 0x0105, StatusInvalidAttributeValue, StatusProcessingFailure, StatusDuplicateSOPInstance, StatusNoSuchSOPInstance,
+		0x0117, 0x0118, 0x0119, 0x0120, 0x0121, StatusSOPClassNotSupported, 0x0123, 0x0124,
+		0x0210, 0x0211, 0x0212, 0x0213, 0x0214, 0x0215:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package dimse
+
+import "testing"
+
+func TestStatusText(t *testing.T) {
+	tests := []struct {
+		command uint16
+		status  uint16
+		want    string
+	}{
+		{CStoreRSP, 0x0000, "Success"},
+		{CStoreRSP, 0xA700, "Refused: Out of Resources"},
+		{CStoreRSP, 0xA7F3, "Refused: Out of Resources"},
+		{CStoreRSP, 0xA900, "Error: Data Set does not match SOP Class"},
+		{CStoreRSP, 0xC123, "Error: Cannot understand"},
+		{CStoreRSP, 0xB000, "Warning: Coercion of Data Elements"},
+		{CStoreRQ, 0x0111, "Failure: Duplicate SOP Instance"},
+		{CFindRSP, 0xFF00, "Pending"},
+		{CFindRSP, 0xFF01, "Pending: Optional Keys not supported"},
+		{CFindRSP, 0xA900, "Failed: Identifier does not match SOP Class"},
+		{CFindRSP, 0xFE00, "Cancel"},
+		{CMoveRSP, 0xA801, "Refused: Move Destination unknown"},
+		{CMoveRSP, 0xA702, "Refused: Out of Resources - Unable to perform sub-operations"},
+		{CGetRSP, 0xB000, "Warning: Sub-operations Complete - One or more Failures or Warnings"},
+		{CGetRSP, 0xA801, "Failure"},
+		{CEchoRSP, 0x0122, "Refused: SOP Class not supported"},
+		{CEchoRSP, 0xB123, "Warning"},
+		{CEchoRSP, 0x7777, "Unknown status"},
+	}
+
+	for _, tt := range tests {
+		if got := StatusText(tt.command, tt.status); got != tt.want {
+			t.Errorf("StatusText(0x%04X, 0x%04X) = %q, want %q", tt.command, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestIsFailureStatus(t *testing.T) {
+	failures := []uint16{0xA700, 0xA801, 0xA900, 0xC000, 0xCFFF, 0x0110, 0x0111, 0x0122, 0x0124, 0x0211}
+	for _, status := range failures {
+		if !IsFailureStatus(status) {
+			t.Errorf("IsFailureStatus(0x%04X) = false, want true", status)
+		}
+	}
+	others := []uint16{0x0000, 0x0001, 0x0107, 0x0116, 0xB000, 0xB007, 0xFF00, 0xFF01, 0xFE00}
+	for _, status := range others {
+		if IsFailureStatus(status) {
+			t.Errorf("IsFailureStatus(0x%04X) = true, want false", status)
+		}
+	}
+}
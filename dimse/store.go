@@ -2,6 +2,7 @@ package dimse
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -10,20 +11,37 @@ import (
 	"github.com/caio-sobreiro/dicomnet/types"
 )
 
+// ErrPeerReleased is returned when the peer releases the association while a
+// response is awaited. The A-RELEASE-RQ has already been answered.
+var ErrPeerReleased = errors.New("association released by peer")
+
+// ErrInstanceUIDMismatch is returned with the response when a C-STORE-RSP
+// names a different SOP instance than the request, which indicates a
+// misrouted response or a faulty SCP.
+var ErrInstanceUIDMismatch = errors.New("C-STORE-RSP affected SOP instance UID does not match the request")
+
 // CStoreRequest represents a C-STORE request
 type CStoreRequest struct {
 	SOPClassUID    string
 	SOPInstanceUID string
 	Data           []byte
 	MessageID      uint16
+	Progress       ProgressFunc // Optional; reports dataset bytes sent
 }
 
+// ProgressFunc receives the number of bytes sent so far out of total. It is
+// called after each P-DATA-TF PDU is written and, on a buffered connection,
+// flushed.
+type ProgressFunc func(sent, total int)
+
 // CStoreResponse represents a C-STORE response
 type CStoreResponse struct {
-	Status         uint16
-	MessageID      uint16
-	SOPClassUID    string
-	SOPInstanceUID string
+	Status            uint16
+	MessageID         uint16
+	SOPClassUID       string
+	SOPInstanceUID    string
+	OffendingElements []types.Tag // Attributes the SCP reported as offending, if any
+	ErrorComment      string      // Free-text failure description, if any
 }
 
 // Connection interface for sending/receiving DICOM data
@@ -31,8 +49,43 @@ type Connection interface {
 	io.ReadWriter
 }
 
-// SendCStore sends a C-STORE request and waits for response
+// SendCStore sends a C-STORE request and waits for response. If the response
+// names another SOP instance, it is returned along with ErrInstanceUIDMismatch.
 func SendCStore(conn Connection, presContextID byte, maxPDULength uint32, req *CStoreRequest) (*CStoreResponse, error) {
+	if err := SendCStoreRequest(conn, presContextID, maxPDULength, req); err != nil {
+		return nil, err
+	}
+
+	// Receive C-STORE-RSP
+	msg, _, err := ReceiveDIMSEMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive C-STORE-RSP: %w", err)
+	}
+
+	if msg.CommandField != CStoreRSP {
+		return nil, fmt.Errorf("unexpected command: 0x%04x (expected C-STORE-RSP)", msg.CommandField)
+	}
+
+	resp := &CStoreResponse{
+		Status:            msg.Status,
+		MessageID:         msg.MessageIDBeingRespondedTo,
+		SOPClassUID:       msg.EffectiveSOPClassUID(),
+		SOPInstanceUID:    msg.AffectedSOPInstanceUID,
+		OffendingElements: msg.OffendingElements,
+		ErrorComment:      msg.ErrorComment,
+	}
+
+	// The Affected SOP Instance UID is optional in the response
+	if resp.SOPInstanceUID != "" && resp.SOPInstanceUID != req.SOPInstanceUID {
+		return resp, fmt.Errorf("%w: sent %s, response names %s (status 0x%04x)",
+			ErrInstanceUIDMismatch, req.SOPInstanceUID, resp.SOPInstanceUID, resp.Status)
+	}
+	return resp, nil
+}
+
+// SendCStoreRequest sends a C-STORE-RQ and its dataset without waiting for
+// the response, for callers that keep several operations outstanding.
+func SendCStoreRequest(conn Connection, presContextID byte, maxPDULength uint32, req *CStoreRequest) error {
 	// Build C-STORE-RQ command
 	command := &types.Message{
 		CommandField:           CStoreRQ,
@@ -46,34 +99,76 @@ func SendCStore(conn Connection, presContextID byte, maxPDULength uint32, req *C
 	// Encode command
 	commandData, err := EncodeCommand(command)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode command: %w", err)
+		return fmt.Errorf("failed to encode command: %w", err)
+	}
+	if err := ValidateCommandSet(commandData); err != nil {
+		return fmt.Errorf("refusing to send C-STORE: %w", err)
 	}
 
 	// Send C-STORE-RQ with dataset
-	if err := SendDIMSEMessage(conn, presContextID, maxPDULength, commandData, req.Data); err != nil {
-		return nil, fmt.Errorf("failed to send C-STORE: %w", err)
+	if err := SendPDataTF(conn, presContextID, maxPDULength, commandData, true, true); err != nil {
+		return fmt.Errorf("failed to send C-STORE: %w", err)
 	}
+	if len(req.Data) > 0 {
+		if err := SendPDataTFWithProgress(conn, presContextID, maxPDULength, req.Data, false, true, req.Progress); err != nil {
+			return fmt.Errorf("failed to send C-STORE: %w", err)
+		}
+	}
+	return nil
+}
 
-	// Receive C-STORE-RSP
-	msg, _, err := ReceiveDIMSEMessage(conn)
-	if err != nil {
-		return nil, fmt.Errorf("failed to receive C-STORE-RSP: %w", err)
+// ForwardCStore re-emits a received C-STORE-RQ on another connection, e.g.
+// in a router. The command is rebuilt from original, so callers override the
+// Message ID or Move Originator fields by setting them before the call; the
+// dataset bytes are sent as they are, without being decoded. It does not wait
+// for the C-STORE-RSP.
+func ForwardCStore(dst Connection, presContextID byte, maxPDULength uint32, original *types.Message, datasetBytes []byte) error {
+	if original == nil {
+		return fmt.Errorf("no C-STORE-RQ to forward")
+	}
+	if original.CommandField != CStoreRQ {
+		return fmt.Errorf("cannot forward command 0x%04x as a C-STORE-RQ", original.CommandField)
+	}
+	if len(datasetBytes) == 0 {
+		return fmt.Errorf("C-STORE-RQ to forward has no dataset")
 	}
 
-	if msg.CommandField != CStoreRSP {
-		return nil, fmt.Errorf("unexpected command: 0x%04x (expected C-STORE-RSP)", msg.CommandField)
+	command := &types.Message{
+		CommandField:            CStoreRQ,
+		MessageID:               original.MessageID,
+		Priority:                original.Priority,
+		CommandDataSetType:      0x0000, // Dataset present
+		AffectedSOPClassUID:     original.AffectedSOPClassUID,
+		AffectedSOPInstanceUID:  original.AffectedSOPInstanceUID,
+		MoveOriginatorAETitle:   original.MoveOriginatorAETitle,
+		MoveOriginatorMessageID: original.MoveOriginatorMessageID,
 	}
+	commandData, err := EncodeCommand(command)
+	if err != nil {
+		return fmt.Errorf("failed to encode forwarded C-STORE-RQ: %w", err)
+	}
+	if err := SendDIMSEMessage(dst, presContextID, maxPDULength, commandData, datasetBytes); err != nil {
+		return fmt.Errorf("failed to forward C-STORE-RQ: %w", err)
+	}
+	return nil
+}
 
-	return &CStoreResponse{
-		Status:         msg.Status,
-		MessageID:      msg.MessageIDBeingRespondedTo,
-		SOPClassUID:    msg.AffectedSOPClassUID,
-		SOPInstanceUID: msg.AffectedSOPInstanceUID,
-	}, nil
+// CommandDataSetTypeFor returns the Command Data Set Type (0000,0800) of a
+// message carrying the encoded dataset: 0x0101 (no dataset) when it is
+// empty, as only non-empty datasets are sent, and 0x0000 otherwise.
+func CommandDataSetTypeFor(datasetData []byte) uint16 {
+	if len(datasetData) == 0 {
+		return 0x0101
+	}
+	return 0x0000
 }
 
 // SendDIMSEMessage sends a DIMSE message with optional dataset
 func SendDIMSEMessage(conn Connection, presContextID byte, maxPDULength uint32, commandData []byte, datasetData []byte) error {
+	if err := ValidateCommandSet(commandData); err != nil {
+		return fmt.Errorf("refusing to send command: %w", err)
+	}
+
 	// Send command in P-DATA-TF
 	if err := SendPDataTF(conn, presContextID, maxPDULength, commandData, true, true); err != nil {
 		return err
@@ -91,9 +186,20 @@ func SendDIMSEMessage(conn Connection, presContextID byte, maxPDULength uint32,
 
 // SendPDataTF sends a P-DATA-TF PDU
 func SendPDataTF(conn Connection, presContextID byte, maxPDULength uint32, data []byte, isCommand bool, isLast bool) error {
+	return SendPDataTFWithProgress(conn, presContextID, maxPDULength, data, isCommand, isLast, nil)
+}
+
+// SendPDataTFWithProgress is SendPDataTF with a callback invoked after each
+// PDU is written. On a buffered connection each PDU is flushed before the
+// callback, so the count never includes bytes still in the write buffer.
+// progress may be nil.
+func SendPDataTFWithProgress(conn Connection, presContextID byte, maxPDULength uint32, data []byte, isCommand bool, isLast bool, progress ProgressFunc) error {
 	// Calculate max data per PDV (PDU length - PDU header - PDV header)
 	maxPDVData := int(maxPDULength) - 6 - 6
 
+	bufferedConn, buffered := conn.(*pdu.BufferedConn)
+	buffered = buffered && bufferedConn.Buffered()
+
 	offset := 0
 	for offset < len(data) {
 		// Calculate chunk size
@@ -104,18 +210,6 @@ func SendPDataTF(conn Connection, presContextID byte, maxPDULength uint32, data
 			lastFragment = false
 		}
 
-		// Build PDV (Presentation Data Value)
-		pdvLength := uint32(chunkSize + 2) // +2 for PDV header
-		pdv := make([]byte, 0, pdvLength+4)
-
-		// PDV length (4 bytes)
-		pdvLengthBytes := make([]byte, 4)
-		binary.BigEndian.PutUint32(pdvLengthBytes, pdvLength)
-		pdv = append(pdv, pdvLengthBytes...)
-
-		// Presentation Context ID (1 byte)
-		pdv = append(pdv, presContextID)
-
 		// Message Control Header (1 byte)
 		// Bit 0: 0=data, 1=command
 		// Bit 1: 0=not last, 1=last fragment
@@ -126,33 +220,65 @@ func SendPDataTF(conn Connection, presContextID byte, maxPDULength uint32, data
 		if lastFragment && isLast {
 			controlHeader |= 0x02
 		}
-		pdv = append(pdv, controlHeader)
-
-		// Data fragment
-		pdv = append(pdv, data[offset:offset+chunkSize]...)
 
-		// Build P-DATA-TF PDU
-		pduHeader := make([]byte, 6)
-		pduHeader[0] = pdu.TypePDataTF
-		pduHeader[1] = 0x00
-		binary.BigEndian.PutUint32(pduHeader[2:6], uint32(len(pdv)))
-
-		// Combine PDU header and PDV into single write for atomicity
-		fullPDU := append(pduHeader, pdv...)
-
-		// Send complete PDU
-		if _, err := conn.Write(fullPDU); err != nil {
-			return fmt.Errorf("failed to write PDU: %w", err)
+		// P-DATA-TF PDU header (6 bytes) followed by the PDV header (6 bytes)
+		pdvLength := uint32(chunkSize + 2) // +2 for PDV header
+		var header [12]byte
+		header[0] = pdu.TypePDataTF
+		binary.BigEndian.PutUint32(header[2:6], pdvLength+4)
+		binary.BigEndian.PutUint32(header[6:10], pdvLength)
+		header[10] = presContextID
+		header[11] = controlHeader
+
+		fragment := data[offset : offset+chunkSize]
+		if buffered {
+			// The write buffer coalesces header and fragment without copying
+			// the fragment into a per-PDU allocation
+			if _, err := conn.Write(header[:]); err != nil {
+				return fmt.Errorf("failed to write PDU: %w", err)
+			}
+			if _, err := conn.Write(fragment); err != nil {
+				return fmt.Errorf("failed to write PDU: %w", err)
+			}
+		} else {
+			// Combine PDU header and PDV into single write for atomicity
+			fullPDU := make([]byte, 0, len(header)+chunkSize)
+			fullPDU = append(fullPDU, header[:]...)
+			fullPDU = append(fullPDU, fragment...)
+
+			if _, err := conn.Write(fullPDU); err != nil {
+				return fmt.Errorf("failed to write PDU: %w", err)
+			}
 		}
 
 		offset += chunkSize
+
+		if progress != nil {
+			if buffered {
+				if err := pdu.FlushConn(conn); err != nil {
+					return fmt.Errorf("failed to flush PDU: %w", err)
+				}
+			}
+			progress(offset, len(data))
+		}
 	}
 
-	return nil
+	return pdu.FlushConn(conn)
 }
 
-// EncodeCommand encodes a DIMSE command message using Implicit VR Little Endian
+// EncodeCommand encodes a DIMSE command message using Implicit VR Little Endian.
+// It is EncodeCommandSet with an error result, kept for existing callers.
 func EncodeCommand(msg *types.Message) ([]byte, error) {
+	return EncodeCommandSet(msg), nil
+}
+
+// EncodeCommandSet encodes msg as a DIMSE command set: Implicit VR Little
+// Endian elements of group 0000 in ascending tag order, preceded by the
+// Command Group Length. Optional fields are written only when set, except
+// that responses always carry a Status. Both the client and the server
+// encode their commands with it, so tests and tools can use it to produce
+// exactly what goes on the wire.
+func EncodeCommandSet(msg *types.Message) []byte {
 	buf := make([]byte, 0, 256)
 
 	// Command Group Length (0000,0000) - will calculate later
@@ -217,13 +343,16 @@ func EncodeCommand(msg *types.Message) ([]byte, error) {
 	binary.LittleEndian.PutUint16(datasetTypeBytes, msg.CommandDataSetType)
 	buf = AppendImplicitElement(buf, 0x0000, 0x0800, datasetTypeBytes)
 
-	// Status (0000,0900) - optional (in responses)
-	if msg.Status != 0 {
+	// Status (0000,0900) - required in responses, where 0 is success
+	if msg.CommandField&0x8000 != 0 || msg.Status != 0 {
 		statusBytes := make([]byte, 2)
 		binary.LittleEndian.PutUint16(statusBytes, msg.Status)
 		buf = AppendImplicitElement(buf, 0x0000, 0x0900, statusBytes)
 	}
 
+	// Offending Element (0000,0901) and Error Comment (0000,0902) - optional (in failure responses)
+	buf = appendFailureDetails(buf, msg)
+
 	// Affected SOP Instance UID (0000,1000) - optional
 	if msg.AffectedSOPInstanceUID != "" {
 		sopInstBytes := []byte(msg.AffectedSOPInstanceUID)
@@ -233,6 +362,27 @@ func EncodeCommand(msg *types.Message) ([]byte, error) {
 		buf = AppendImplicitElement(buf, 0x0000, 0x1000, sopInstBytes)
 	}
 
+	// Requested SOP Instance UID (0000,1001) - optional (in DIMSE-N requests)
+	if msg.RequestedSOPInstanceUID != "" {
+		sopInstBytes := []byte(msg.RequestedSOPInstanceUID)
+		if len(sopInstBytes)%2 == 1 {
+			sopInstBytes = append(sopInstBytes, 0x00) // Pad to even
+		}
+		buf = AppendImplicitElement(buf, 0x0000, 0x1001, sopInstBytes)
+	}
+
+	// Event Type ID (0000,1002) and Action Type ID (0000,1008) - optional (in N-EVENT-REPORT and N-ACTION)
+	if msg.EventTypeID != 0 {
+		eventType := make([]byte, 2)
+		binary.LittleEndian.PutUint16(eventType, msg.EventTypeID)
+		buf = AppendImplicitElement(buf, 0x0000, 0x1002, eventType)
+	}
+	if msg.ActionTypeID != 0 {
+		actionType := make([]byte, 2)
+		binary.LittleEndian.PutUint16(actionType, msg.ActionTypeID)
+		buf = AppendImplicitElement(buf, 0x0000, 0x1008, actionType)
+	}
+
 	// C-MOVE response counters (optional, only for C-MOVE-RSP)
 	if msg.NumberOfRemainingSuboperations != nil {
 		remaining := make([]byte, 2)
@@ -258,11 +408,102 @@ func EncodeCommand(msg *types.Message) ([]byte, error) {
 		buf = AppendImplicitElement(buf, 0x0000, 0x1023, warning)
 	}
 
+	// Move Originator (0000,1030) and (0000,1031) - optional (C-STORE sub-operations of a C-MOVE)
+	if msg.MoveOriginatorAETitle != "" {
+		originatorBytes := []byte(msg.MoveOriginatorAETitle)
+		if len(originatorBytes)%2 == 1 {
+			originatorBytes = append(originatorBytes, 0x20) // Pad with space
+		}
+		buf = AppendImplicitElement(buf, 0x0000, 0x1030, originatorBytes)
+
+		originatorID := make([]byte, 2)
+		binary.LittleEndian.PutUint16(originatorID, msg.MoveOriginatorMessageID)
+		buf = AppendImplicitElement(buf, 0x0000, 0x1031, originatorID)
+	}
+
 	// Update Command Group Length
 	groupLength := uint32(len(buf) - lengthPos - 4)
 	binary.LittleEndian.PutUint32(buf[lengthPos:lengthPos+4], groupLength)
 
-	return buf, nil
+	return buf
+}
+
+// ValidateCommandSet checks that data is a command set in Implicit VR Little
+// Endian, the only encoding PS3.7 allows for commands whatever the transfer
+// syntax of the presentation context: a sequence of group 0000 elements with
+// 4-byte lengths that exactly fill data, whose Command Group Length, when
+// present, matches. A command encoded with Explicit VR, or deflated or
+// otherwise compressed along with its dataset, fails the check.
+func ValidateCommandSet(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("command set is empty")
+	}
+
+	offset := 0
+	for offset < len(data) {
+		if offset+8 > len(data) {
+			return fmt.Errorf("command set truncated at offset %d", offset)
+		}
+		group := binary.LittleEndian.Uint16(data[offset : offset+2])
+		element := binary.LittleEndian.Uint16(data[offset+2 : offset+4])
+		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+
+		if group != 0x0000 {
+			return fmt.Errorf("element (%04x,%04x) at offset %d is not in the command group; command sets must be Implicit VR Little Endian", group, element, offset)
+		}
+		if uint64(offset)+8+uint64(length) > uint64(len(data)) {
+			return fmt.Errorf("element (0000,%04x) length %d overruns the command set; command sets must be Implicit VR Little Endian", element, length)
+		}
+		if element == 0x0000 && offset == 0 {
+			if length != 4 {
+				return fmt.Errorf("command group length has length %d, want 4", length)
+			}
+			if groupLength := binary.LittleEndian.Uint32(data[8:12]); int(groupLength) != len(data)-12 {
+				return fmt.Errorf("command group length %d does not match %d bytes of command elements", groupLength, len(data)-12)
+			}
+		}
+		offset += 8 + int(length)
+	}
+	return nil
+}
+
+// appendFailureDetails appends the Offending Element (0000,0901) and Error
+// Comment (0000,0902) elements when the message carries them
+func appendFailureDetails(buf []byte, msg *types.Message) []byte {
+	if len(msg.OffendingElements) > 0 {
+		tags := make([]byte, 0, 4*len(msg.OffendingElements))
+		for _, tag := range msg.OffendingElements {
+			tags = binary.LittleEndian.AppendUint16(tags, tag.Group)
+			tags = binary.LittleEndian.AppendUint16(tags, tag.Element)
+		}
+		buf = AppendImplicitElement(buf, 0x0000, 0x0901, tags)
+	}
+
+	if msg.ErrorComment != "" {
+		comment := msg.ErrorComment
+		if len(comment) > 64 {
+			comment = comment[:64] // LO maximum length
+		}
+		commentBytes := []byte(comment)
+		if len(commentBytes)%2 == 1 {
+			commentBytes = append(commentBytes, 0x20) // Pad with space
+		}
+		buf = AppendImplicitElement(buf, 0x0000, 0x0902, commentBytes)
+	}
+
+	return buf
+}
+
+// decodeAttributeTags decodes an AT value (Implicit VR Little Endian) into tags
+func decodeAttributeTags(value []byte) []types.Tag {
+	tags := make([]types.Tag, 0, len(value)/4)
+	for i := 0; i+4 <= len(value); i += 4 {
+		tags = append(tags, types.Tag{
+			Group:   binary.LittleEndian.Uint16(value[i : i+2]),
+			Element: binary.LittleEndian.Uint16(value[i+2 : i+4]),
+		})
+	}
+	return tags
 }
 
 // AppendImplicitElement appends a DICOM element using Implicit VR (no VR field)
@@ -291,7 +532,7 @@ func DecodeCommand(data []byte) (*types.Message, error) {
 		element := binary.LittleEndian.Uint16(data[offset+2 : offset+4])
 		length := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
 
-		if offset+8+int(length) > len(data) {
+		if uint64(length) > uint64(len(data)-offset-8) {
 			break
 		}
 
@@ -328,8 +569,22 @@ func DecodeCommand(data []byte) (*types.Message, error) {
 			if len(value) >= 2 {
 				msg.Status = binary.LittleEndian.Uint16(value[:2])
 			}
+		case group == 0x0000 && element == 0x0901:
+			msg.OffendingElements = decodeAttributeTags(value)
+		case group == 0x0000 && element == 0x0902:
+			msg.ErrorComment = strings.TrimRight(string(value), "\x00 ")
 		case group == 0x0000 && element == 0x1000:
 			msg.AffectedSOPInstanceUID = strings.TrimRight(string(value), "\x00 ")
+		case group == 0x0000 && element == 0x1001:
+			msg.RequestedSOPInstanceUID = strings.TrimRight(string(value), "\x00 ")
+		case group == 0x0000 && element == 0x1002:
+			if len(value) >= 2 {
+				msg.EventTypeID = binary.LittleEndian.Uint16(value[:2])
+			}
+		case group == 0x0000 && element == 0x1008:
+			if len(value) >= 2 {
+				msg.ActionTypeID = binary.LittleEndian.Uint16(value[:2])
+			}
 		case group == 0x0000 && element == 0x1020:
 			if len(value) >= 2 {
 				val := binary.LittleEndian.Uint16(value[:2])
@@ -350,6 +605,12 @@ func DecodeCommand(data []byte) (*types.Message, error) {
 				val := binary.LittleEndian.Uint16(value[:2])
 				msg.NumberOfWarningSuboperations = &val
 			}
+		case group == 0x0000 && element == 0x1030:
+			msg.MoveOriginatorAETitle = strings.TrimRight(string(value), "\x00 ")
+		case group == 0x0000 && element == 0x1031:
+			if len(value) >= 2 {
+				msg.MoveOriginatorMessageID = binary.LittleEndian.Uint16(value[:2])
+			}
 		}
 
 		offset += 8 + int(length)
@@ -378,8 +639,8 @@ func ReceiveDIMSEMessage(conn Connection) (*types.Message, []byte, error) {
 
 		switch pduType {
 		case pdu.TypePDataTF:
-			payload := make([]byte, pduLength)
-			if _, err := io.ReadFull(conn, payload); err != nil {
+			payload, err := pdu.ReadPDUBody(conn, pduLength)
+			if err != nil {
 				return nil, nil, fmt.Errorf("failed to read PDU data: %w", err)
 			}
 
@@ -390,10 +651,13 @@ func ReceiveDIMSEMessage(conn Connection) (*types.Message, []byte, error) {
 				}
 
 				pdvLength := binary.BigEndian.Uint32(payload[offset : offset+4])
-				end := offset + 4 + int(pdvLength)
-				if end > len(payload) {
+				if pdvLength < 2 {
+					return nil, nil, fmt.Errorf("malformed PDV encountered")
+				}
+				if uint64(pdvLength) > uint64(len(payload)-offset-4) {
 					return nil, nil, fmt.Errorf("PDV length exceeds PDU payload")
 				}
+				end := offset + 4 + int(pdvLength)
 
 				controlHeader := payload[offset+5]
 				value := payload[offset+6 : end]
@@ -404,6 +668,9 @@ func ReceiveDIMSEMessage(conn Connection) (*types.Message, []byte, error) {
 					commandData = append(commandData, value...)
 					if isLastFragment {
 						commandComplete = true
+						if err := ValidateCommandSet(commandData); err != nil {
+							return nil, nil, fmt.Errorf("invalid command received: %w", err)
+						}
 						decoded, err := DecodeCommand(commandData)
 						if err != nil {
 							return nil, nil, fmt.Errorf("failed to decode command: %w", err)
@@ -430,8 +697,8 @@ func ReceiveDIMSEMessage(conn Connection) (*types.Message, []byte, error) {
 				offset = end
 			}
 		case 0x07: // A-ABORT
-			abortData := make([]byte, pduLength)
-			if _, err := io.ReadFull(conn, abortData); err != nil {
+			abortData, err := pdu.ReadPDUBody(conn, pduLength)
+			if err != nil {
 				return nil, nil, fmt.Errorf("failed to read ABORT data: %w", err)
 			}
 
@@ -442,10 +709,22 @@ func ReceiveDIMSEMessage(conn Connection) (*types.Message, []byte, error) {
 			}
 
 			return nil, nil, fmt.Errorf("received A-ABORT PDU (source=%d, reason=%d)", source, reason)
+		case pdu.TypeReleaseRQ:
+			if _, err := io.CopyN(io.Discard, conn, int64(pduLength)); err != nil {
+				return nil, nil, fmt.Errorf("failed to read A-RELEASE-RQ data: %w", err)
+			}
+
+			releaseRP := []byte{pdu.TypeReleaseRP, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+			if _, err := conn.Write(releaseRP); err != nil {
+				return nil, nil, fmt.Errorf("failed to send A-RELEASE-RP: %w", err)
+			}
+			if err := pdu.FlushConn(conn); err != nil {
+				return nil, nil, fmt.Errorf("failed to send A-RELEASE-RP: %w", err)
+			}
+			return nil, nil, ErrPeerReleased
 		default:
 			// Skip payload for unexpected PDU types to maintain stream alignment
-			discard := make([]byte, pduLength)
-			if _, err := io.ReadFull(conn, discard); err != nil {
+			if _, err := io.CopyN(io.Discard, conn, int64(pduLength)); err != nil {
 				return nil, nil, fmt.Errorf("failed to read unexpected PDU payload: %w", err)
 			}
 			return nil, nil, fmt.Errorf("unexpected PDU type: 0x%02x", pduType)
@@ -456,3 +735,20 @@ func ReceiveDIMSEMessage(conn Connection) (*types.Message, []byte, error) {
 		}
 	}
 }
+
+// ReadAllDIMSE reads n consecutive DIMSE messages from conn, e.g. the pending
+// responses and final response to a C-FIND. It returns the messages and
+// datasets read so far along with the error that stopped it.
+func ReadAllDIMSE(conn Connection, n int) ([]*types.Message, [][]byte, error) {
+	messages := make([]*types.Message, 0, n)
+	datasets := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		msg, data, err := ReceiveDIMSEMessage(conn)
+		if err != nil {
+			return messages, datasets, fmt.Errorf("failed to read DIMSE message %d of %d: %w", i+1, n, err)
+		}
+		messages = append(messages, msg)
+		datasets = append(datasets, data)
+	}
+	return messages, datasets, nil
+}
@@ -0,0 +1,272 @@
+package dimse
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// writeCountingConn is an in-memory net.Conn that counts Write calls,
+// standing in for syscalls on a real socket
+type writeCountingConn struct {
+	net.Conn
+	buf    bytes.Buffer
+	writes int
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes++
+	return c.buf.Write(b)
+}
+
+func TestSendPDataTF_Buffered(t *testing.T) {
+	data := bytes.Repeat([]byte{0xAB}, 100)
+
+	plain := &writeCountingConn{}
+	if err := SendPDataTF(plain, 1, 44, data, false, true); err != nil {
+		t.Fatalf("SendPDataTF(unbuffered) error = %v", err)
+	}
+
+	raw := &writeCountingConn{}
+	buffered := pdu.NewBufferedConn(raw, 0, 4096)
+	if err := SendPDataTF(buffered, 1, 44, data, false, true); err != nil {
+		t.Fatalf("SendPDataTF(buffered) error = %v", err)
+	}
+
+	if !bytes.Equal(plain.buf.Bytes(), raw.buf.Bytes()) {
+		t.Error("Buffered and unbuffered writes produced different PDU streams")
+	}
+	if raw.writes != 1 {
+		t.Errorf("Expected buffered PDUs to be flushed in a single write, got %d", raw.writes)
+	}
+
+	// 100 bytes in 32-byte PDVs: four PDUs, only the last flagged as last fragment
+	stream := raw.buf.Bytes()
+	var controls []byte
+	for len(stream) > 0 {
+		length := binary.BigEndian.Uint32(stream[2:6])
+		controls = append(controls, stream[11])
+		stream = stream[6+length:]
+	}
+	if want := []byte{0x00, 0x00, 0x00, 0x02}; !bytes.Equal(controls, want) {
+		t.Errorf("Control headers = %v, want %v", controls, want)
+	}
+}
+
+func TestSendPDataTFWithProgress_Buffered(t *testing.T) {
+	data := bytes.Repeat([]byte{0xAB}, 100)
+	raw := &writeCountingConn{}
+	buffered := pdu.NewBufferedConn(raw, 0, 4096)
+
+	// Each report must cover only bytes already on the wire: the data sent
+	// so far plus 12 header bytes per 32-byte PDV
+	var reports []int
+	progress := func(sent, total int) {
+		reports = append(reports, sent)
+		pdus := (sent + 31) / 32
+		if onWire := raw.buf.Len(); onWire != sent+12*pdus {
+			t.Errorf("Progress %d/%d reported with %d bytes written, want %d", sent, total, onWire, sent+12*pdus)
+		}
+	}
+	if err := SendPDataTFWithProgress(buffered, 1, 44, data, false, true, progress); err != nil {
+		t.Fatalf("SendPDataTFWithProgress() error = %v", err)
+	}
+	if want := []int{32, 64, 96, 100}; fmt.Sprint(reports) != fmt.Sprint(want) {
+		t.Errorf("Progress reports = %v, want %v", reports, want)
+	}
+}
+
+func BenchmarkSendPDataTF(b *testing.B) {
+	const size = 50 << 20
+	data := make([]byte, size)
+
+	for _, bufferSize := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", bufferSize), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+
+			writes := 0
+			for i := 0; i < b.N; i++ {
+				raw := &writeCountingConn{}
+				raw.buf.Grow(size + size/1024)
+				var conn Connection = raw
+				if bufferSize > 0 {
+					conn = pdu.NewBufferedConn(raw, 0, bufferSize)
+				}
+				if err := SendPDataTF(conn, 1, pdu.DefaultMaxPDULength, data, false, true); err != nil {
+					b.Fatalf("SendPDataTF() error = %v", err)
+				}
+				writes += raw.writes
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestEncodeCommandSet(t *testing.T) {
+	remaining := uint16(2)
+	data := EncodeCommandSet(&types.Message{
+		CommandField:                   CGetRSP,
+		MessageIDBeingRespondedTo:      5,
+		AffectedSOPClassUID:            types.StudyRootQueryRetrieveInformationModelGet,
+		AffectedSOPInstanceUID:         "1.2.3",
+		CommandDataSetType:             0x0101,
+		Status:                         StatusSuccess,
+		NumberOfRemainingSuboperations: &remaining,
+	})
+
+	elements, err := DecodeCommandElements(data)
+	if err != nil {
+		t.Fatalf("DecodeCommandElements() error = %v", err)
+	}
+
+	var tags []types.Tag
+	for _, element := range elements {
+		tags = append(tags, element.Tag)
+	}
+	want := []types.Tag{
+		{Group: 0x0000, Element: 0x0000},
+		{Group: 0x0000, Element: 0x0002},
+		{Group: 0x0000, Element: 0x0100},
+		{Group: 0x0000, Element: 0x0120},
+		{Group: 0x0000, Element: 0x0800},
+		{Group: 0x0000, Element: 0x0900}, // Success is still encoded in a response
+		{Group: 0x0000, Element: 0x1000},
+		{Group: 0x0000, Element: 0x1020},
+	}
+	if fmt.Sprint(tags) != fmt.Sprint(want) {
+		t.Errorf("Encoded tags = %v, want %v", tags, want)
+	}
+
+	if groupLength := binary.LittleEndian.Uint32(elements[0].Value); int(groupLength) != len(data)-12 {
+		t.Errorf("Command Group Length = %d, want %d", groupLength, len(data)-12)
+	}
+}
+
+func TestValidateCommandSet(t *testing.T) {
+	valid := EncodeCommandSet(&types.Message{
+		CommandField:        CEchoRQ,
+		MessageID:           1,
+		AffectedSOPClassUID: types.VerificationSOPClass,
+		CommandDataSetType:  0x0101,
+	})
+	if err := ValidateCommandSet(valid); err != nil {
+		t.Fatalf("ValidateCommandSet(encoded command) error = %v", err)
+	}
+
+	// The same group length element in Explicit VR: 'UL' and a 2-byte length
+	explicit := []byte{0x00, 0x00, 0x00, 0x00, 'U', 'L', 0x04, 0x00, 0x08, 0x00, 0x00, 0x00}
+	explicit = append(explicit, 0x00, 0x00, 0x00, 0x01, 'U', 'S', 0x02, 0x00, 0x30, 0x00)
+
+	wrongLength := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(wrongLength[8:12], uint32(len(valid)))
+
+	dataset := append(append([]byte(nil), valid...), 0x10, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	var deflated bytes.Buffer
+	writer, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	writer.Write(valid)
+	writer.Close()
+
+	for name, data := range map[string][]byte{
+		"Empty":                 nil,
+		"Explicit VR":           explicit,
+		"Deflated":              deflated.Bytes(),
+		"Group length mismatch": wrongLength,
+		"Dataset element":       dataset,
+		"Truncated":             valid[:len(valid)-1],
+	} {
+		if err := ValidateCommandSet(data); err == nil {
+			t.Errorf("ValidateCommandSet(%s) succeeded, want error", name)
+		}
+	}
+}
+
+func TestReadAllDIMSE(t *testing.T) {
+	var buf bytes.Buffer
+	statuses := []uint16{StatusPending, StatusPending, StatusSuccess}
+	for i, status := range statuses {
+		msg := &types.Message{
+			CommandField:              CFindRSP,
+			MessageIDBeingRespondedTo: 1,
+			AffectedSOPClassUID:       types.StudyRootQueryRetrieveInformationModelFind,
+			CommandDataSetType:        0x0101,
+			Status:                    status,
+		}
+		var data []byte
+		if status == StatusPending {
+			msg.CommandDataSetType = 0x0000
+			data = []byte{byte(i), 0x00}
+		}
+		if err := SendDIMSEMessage(&buf, 1, 16384, EncodeCommandSet(msg), data); err != nil {
+			t.Fatalf("SendDIMSEMessage() error = %v", err)
+		}
+	}
+
+	messages, datasets, err := ReadAllDIMSE(&buf, 3)
+	if err != nil {
+		t.Fatalf("ReadAllDIMSE() error = %v", err)
+	}
+	if len(messages) != 3 || len(datasets) != 3 {
+		t.Fatalf("ReadAllDIMSE() read %d messages and %d datasets, want 3", len(messages), len(datasets))
+	}
+	for i, msg := range messages {
+		if msg.CommandField != CFindRSP || msg.Status != statuses[i] {
+			t.Errorf("Message %d = 0x%04X status 0x%04X, want C-FIND-RSP status 0x%04X", i, msg.CommandField, msg.Status, statuses[i])
+		}
+	}
+	if !bytes.Equal(datasets[1], []byte{0x01, 0x00}) || datasets[2] != nil {
+		t.Errorf("Datasets = %v, want the pending matches only", datasets)
+	}
+
+	if _, _, err := ReadAllDIMSE(&buf, 1); err == nil {
+		t.Error("ReadAllDIMSE() past the end succeeded, want error")
+	}
+}
+
+func TestForwardCStore(t *testing.T) {
+	received := &types.Message{
+		CommandField:           CStoreRQ,
+		MessageID:              7,
+		Priority:               0x0001,
+		CommandDataSetType:     0x0000,
+		AffectedSOPClassUID:    types.CTImageStorage,
+		AffectedSOPInstanceUID: "1.2.3.4",
+	}
+	dataset := bytes.Repeat([]byte{0x08, 0x00, 0x18, 0x00, 0x55, 0x49, 0x02, 0x00, '1', 0x00}, 3000)
+
+	// The router's own Message ID and the original C-MOVE's originator
+	forwarded := *received
+	forwarded.MessageID = 101
+	forwarded.MoveOriginatorAETitle = "MOVESCU"
+	forwarded.MoveOriginatorMessageID = 42
+
+	var wire bytes.Buffer
+	if err := ForwardCStore(&wire, 5, 4096, &forwarded, dataset); err != nil {
+		t.Fatalf("ForwardCStore() error = %v", err)
+	}
+
+	msg, data, err := ReceiveDIMSEMessage(&wire)
+	if err != nil {
+		t.Fatalf("ReceiveDIMSEMessage() error = %v", err)
+	}
+	if msg.CommandField != CStoreRQ || msg.MessageID != 101 || msg.AffectedSOPInstanceUID != "1.2.3.4" {
+		t.Errorf("Forwarded command = %+v, want C-STORE-RQ 101 for 1.2.3.4", msg)
+	}
+	if msg.MoveOriginatorAETitle != "MOVESCU" || msg.MoveOriginatorMessageID != 42 {
+		t.Errorf("Move originator = %q/%d, want MOVESCU/42", msg.MoveOriginatorAETitle, msg.MoveOriginatorMessageID)
+	}
+	if !bytes.Equal(data, dataset) {
+		t.Error("Forwarded dataset differs from the original bytes")
+	}
+
+	if err := ForwardCStore(&wire, 5, 4096, &types.Message{CommandField: CEchoRQ}, dataset); err == nil {
+		t.Error("ForwardCStore() of a C-ECHO-RQ succeeded, want error")
+	}
+}
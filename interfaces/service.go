@@ -13,6 +13,25 @@ type MessageContext struct {
 	PresentationContextID byte
 	TransferSyntaxUID     string
 	Dataset               *dicom.Dataset
+
+	// AbstractSyntaxUID is the SOP class negotiated for the presentation
+	// context, e.g. the Query/Retrieve information model of a C-FIND. Unlike
+	// the request's Affected SOP Class UID it is set by the association, so a
+	// handler serving several SOP classes for one command can rely on it.
+	AbstractSyntaxUID string
+
+	// RelationalQueries is true when relational queries/retrieval were agreed
+	// for this presentation context via SOP Class Extended Negotiation, in
+	// which case identifiers may omit the Query/Retrieve Level.
+	RelationalQueries bool
+
+	// MoveDestinationAddress is the host:port of a C-MOVE destination, when
+	// the server resolved the request's Move Destination from its AE table.
+	MoveDestinationAddress string
+
+	// AETitle is the AE title of the server handling the message, e.g. to
+	// answer a C-FIND with the Retrieve AE Title it can be retrieved from.
+	AETitle string
 }
 
 // ServiceHandler interface for handling DIMSE operations
@@ -33,8 +52,33 @@ type ResponseSender interface {
 // CGetResponder interface for C-GET operations that need to send C-STORE sub-operations
 type CGetResponder interface {
 	ResponseSender
-	// SendCStore sends a C-STORE sub-operation on the same association
+	// SendCStore sends a C-STORE sub-operation on the same association and
+	// waits for its C-STORE-RSP, returning an error if the peer reports failure.
+	// It is sent on the presentation context accepted for sopClassUID, for
+	// which the SCU must have taken the SCP role through SCP/SCU Role
+	// Selection, and data must already be in that context's transfer syntax.
 	SendCStore(sopClassUID, sopInstanceUID string, data []byte) error
+	// NextMessageID returns the message ID the next sub-operation will use.
+	// IDs are unique and increasing across the whole association.
+	NextMessageID() uint16
+}
+
+// CGetTranscodingResponder is implemented by CGetResponders that convert
+// instances to the storage context's transfer syntax. It is kept apart from
+// CGetResponder so that existing implementations still satisfy it.
+type CGetTranscodingResponder interface {
+	// SendCStoreAs is SendCStore for data encoded in transferSyntax, which is
+	// transcoded to the storage context's transfer syntax when they differ
+	SendCStoreAs(sopClassUID, sopInstanceUID string, data []byte, transferSyntax string) error
+}
+
+// CGetStatusResponder is implemented by CGetResponders that report the status
+// of each C-STORE sub-operation, so that warnings (0x0001, 0xBxxx), for which
+// SendCStoreAs returns nil, can be counted apart from successes
+type CGetStatusResponder interface {
+	// SendCStoreStatus is SendCStoreAs returning the C-STORE-RSP status, or
+	// zero when no response was read
+	SendCStoreStatus(sopClassUID, sopInstanceUID string, data []byte, transferSyntax string) (uint16, error)
 }
 
 // DIMSEHandler interface for PDU layer to communicate with DIMSE layer
@@ -0,0 +1,172 @@
+package pdu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultKeepAlive is the TCP keep-alive period applied to associations when
+// none is configured.
+const DefaultKeepAlive = 30 * time.Second
+
+// ConfigureConn applies TCP keep-alive and TCP_NODELAY settings to an
+// association's transport. A positive keepAlive enables keep-alive probes with
+// that period, a negative value disables them and zero leaves the OS setting
+// untouched. Connections that are not TCP (e.g. net.Pipe) are left unchanged.
+func ConfigureConn(conn net.Conn, keepAlive time.Duration, noDelay bool) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case keepAlive > 0:
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return fmt.Errorf("failed to enable keep-alive: %w", err)
+		}
+		if err := tcpConn.SetKeepAlivePeriod(keepAlive); err != nil {
+			return fmt.Errorf("failed to set keep-alive period: %w", err)
+		}
+	case keepAlive < 0:
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return fmt.Errorf("failed to disable keep-alive: %w", err)
+		}
+	}
+
+	if err := tcpConn.SetNoDelay(noDelay); err != nil {
+		return fmt.Errorf("failed to set TCP_NODELAY: %w", err)
+	}
+
+	return nil
+}
+
+// BufferedConn wraps an association's transport with buffered reads and
+// writes to cut per-PDU syscalls on high-throughput transfers.
+//
+// Writes are held until Flush is called (or the buffer fills), so writers
+// must flush at PDU boundaries; FlushConn does this for any io.Writer.
+// Deadlines still apply: they are enforced on the underlying connection
+// whenever the buffers are filled or flushed.
+type BufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// NewBufferedConn wraps conn with read and write buffers of the given sizes.
+// A size of zero or less leaves that direction unbuffered.
+func NewBufferedConn(conn net.Conn, readBufferSize, writeBufferSize int) *BufferedConn {
+	c := &BufferedConn{Conn: conn}
+	if readBufferSize > 0 {
+		c.reader = bufio.NewReaderSize(conn, readBufferSize)
+	}
+	if writeBufferSize > 0 {
+		c.writer = bufio.NewWriterSize(conn, writeBufferSize)
+	}
+	return c
+}
+
+// Read reads through the read buffer when one is configured
+func (c *BufferedConn) Read(b []byte) (int, error) {
+	if c.reader != nil {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// Write writes into the write buffer when one is configured
+func (c *BufferedConn) Write(b []byte) (int, error) {
+	if c.writer != nil {
+		return c.writer.Write(b)
+	}
+	return c.Conn.Write(b)
+}
+
+// Flush sends any buffered data to the underlying connection
+func (c *BufferedConn) Flush() error {
+	if c.writer != nil {
+		return c.writer.Flush()
+	}
+	return nil
+}
+
+// Buffered reports whether writes are buffered and must be flushed
+func (c *BufferedConn) Buffered() bool {
+	return c.writer != nil
+}
+
+// ConnStats holds the bytes carried by an association's transport, PDU
+// headers included
+type ConnStats struct {
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// CountingConn counts the bytes read from and written to a connection, for
+// per-association accounting. It is safe for concurrent use.
+type CountingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+// NewCountingConn wraps conn with read and write byte counters
+func NewCountingConn(conn net.Conn) *CountingConn {
+	return &CountingConn{Conn: conn}
+}
+
+// Read reads from the connection and counts the bytes read
+func (c *CountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// Write writes to the connection and counts the bytes written
+func (c *CountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Stats returns the bytes read and written so far
+func (c *CountingConn) Stats() ConnStats {
+	return ConnStats{BytesRead: c.read.Load(), BytesWritten: c.written.Load()}
+}
+
+// FlushConn flushes w if it buffers writes, and is a no-op otherwise
+func FlushConn(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("failed to flush connection: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadPDUBody reads the length bytes of a PDU following its header. The
+// buffer grows as data arrives rather than being allocated from the length
+// field up front, so a forged length cannot make the reader allocate more
+// than the peer actually sends.
+func ReadPDUBody(r io.Reader, length uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	if length <= pduBodyPrealloc {
+		buf.Grow(int(length))
+	}
+	n, err := io.CopyN(&buf, r, int64(length))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read %d of %d PDU bytes: %w", n, length, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// pduBodyPrealloc is the largest PDU body ReadPDUBody allocates up front
+const pduBodyPrealloc = 1 << 20
@@ -0,0 +1,219 @@
+package pdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConfigureConn(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	serverConn, ok := <-accepted
+	if !ok {
+		t.Fatal("Failed to accept connection")
+	}
+	defer serverConn.Close()
+
+	tests := []struct {
+		name      string
+		keepAlive time.Duration
+		noDelay   bool
+	}{
+		{"Default keep-alive with no-delay", DefaultKeepAlive, true},
+		{"Custom keep-alive with Nagle", 5 * time.Second, false},
+		{"Keep-alive disabled", -1, true},
+		{"OS default keep-alive", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ConfigureConn(clientConn, tt.keepAlive, tt.noDelay); err != nil {
+				t.Errorf("ConfigureConn(client) error = %v", err)
+			}
+			if err := ConfigureConn(serverConn, tt.keepAlive, tt.noDelay); err != nil {
+				t.Errorf("ConfigureConn(server) error = %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigureConn_NonTCP(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if err := ConfigureConn(a, DefaultKeepAlive, true); err != nil {
+		t.Errorf("ConfigureConn(pipe) error = %v, want nil", err)
+	}
+}
+
+// countingConn is an in-memory net.Conn that counts Read and Write calls,
+// standing in for syscalls on a real socket
+type countingConn struct {
+	net.Conn
+	r      *bytes.Reader
+	w      bytes.Buffer
+	reads  int
+	writes int
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	c.reads++
+	return c.r.Read(b)
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes++
+	return c.w.Write(b)
+}
+
+func TestBufferedConn(t *testing.T) {
+	conn := &countingConn{r: bytes.NewReader([]byte("abcdefgh"))}
+	buffered := NewBufferedConn(conn, 64, 64)
+
+	if !buffered.Buffered() {
+		t.Fatal("Expected writes to be buffered")
+	}
+
+	if _, err := buffered.Write([]byte("header")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := buffered.Write([]byte("payload")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if conn.writes != 0 {
+		t.Errorf("Expected no writes before flush, got %d", conn.writes)
+	}
+	if err := FlushConn(buffered); err != nil {
+		t.Fatalf("FlushConn() error = %v", err)
+	}
+	if conn.writes != 1 || conn.w.String() != "headerpayload" {
+		t.Errorf("After flush: writes = %d, data = %q; want 1 write of %q", conn.writes, conn.w.String(), "headerpayload")
+	}
+
+	first := make([]byte, 2)
+	second := make([]byte, 6)
+	if _, err := io.ReadFull(buffered, first); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if _, err := io.ReadFull(buffered, second); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if string(first)+string(second) != "abcdefgh" {
+		t.Errorf("Read %q%q, want abcdefgh", first, second)
+	}
+	if conn.reads != 1 {
+		t.Errorf("Expected a single underlying read, got %d", conn.reads)
+	}
+
+	unbuffered := NewBufferedConn(conn, 0, 0)
+	if unbuffered.Buffered() {
+		t.Error("Expected writes to be unbuffered with a zero size")
+	}
+}
+
+func TestBufferedConn_Deadline(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	buffered := NewBufferedConn(a, 4096, 4096)
+	if err := buffered.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline() error = %v", err)
+	}
+
+	_, err := buffered.Read(make([]byte, 1))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Read() error = %v, want timeout", err)
+	}
+}
+
+// benchmarkTransferSize is the size of the dataset streamed by the buffering
+// benchmarks
+const benchmarkTransferSize = 50 << 20
+
+// buildPDataStream encodes size bytes of payload as P-DATA-TF PDUs of at most
+// maxPDULength bytes
+func buildPDataStream(size int, maxPDULength int) []byte {
+	stream := make([]byte, 0, size+size/maxPDULength*12+12)
+	for remaining := size; remaining > 0; {
+		chunk := min(remaining, maxPDULength-12)
+		header := make([]byte, 12)
+		header[0] = TypePDataTF
+		binary.BigEndian.PutUint32(header[2:6], uint32(chunk+6))
+		binary.BigEndian.PutUint32(header[6:10], uint32(chunk+2))
+		header[10] = 1
+		stream = append(stream, header...)
+		stream = append(stream, make([]byte, chunk)...)
+		remaining -= chunk
+	}
+	return stream
+}
+
+func BenchmarkReadPDU(b *testing.B) {
+	stream := buildPDataStream(benchmarkTransferSize, DefaultMaxPDULength)
+
+	for _, bufferSize := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", bufferSize), func(b *testing.B) {
+			b.SetBytes(benchmarkTransferSize)
+			b.ReportAllocs()
+
+			reads := 0
+			for i := 0; i < b.N; i++ {
+				conn := &countingConn{r: bytes.NewReader(stream)}
+				layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)),
+					WithReadBufferSize(bufferSize))
+				for {
+					if _, err := layer.readPDU(); err != nil {
+						if err != io.EOF {
+							b.Fatalf("readPDU() error = %v", err)
+						}
+						break
+					}
+				}
+				reads += conn.reads
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestReadPDUBody(t *testing.T) {
+	body, err := ReadPDUBody(bytes.NewReader([]byte{0x01, 0x02, 0x03, 0x04}), 3)
+	if err != nil || !bytes.Equal(body, []byte{0x01, 0x02, 0x03}) {
+		t.Errorf("ReadPDUBody() = % X, %v, want 01 02 03", body, err)
+	}
+
+	// A forged length fails once the data runs out
+	if _, err := ReadPDUBody(bytes.NewReader([]byte{0x01, 0x02}), 0xFFFFFFFF); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadPDUBody() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,23 @@
+package pdu
+
+import (
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func FuzzParseAssociationRequest(f *testing.F) {
+	rq := buildAssociateRQ("TEST_SCP", "TEST_SCU", []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ExplicitVRLittleEndian, types.JPEG2000}},
+	}, appendItem(nil, 0x53, []byte{0x00, 0x05, 0x00, 0x05}))
+	f.Add(rq.Data)
+	f.Add(rq.Data[:len(rq.Data)-5])
+	f.Add(rq.Data[:70])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		layer := newTestLayer()
+		_ = layer.parseAssociationRequest(&PDU{Type: TypeAssociateRQ, Length: uint32(len(data)), Data: data})
+		_, _ = parseUserInformation(data)
+	})
+}
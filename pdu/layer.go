@@ -1,13 +1,21 @@
 package pdu
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/types"
 )
 
@@ -36,6 +44,150 @@ type Layer struct {
 	dimseHandler   DIMSEHandler
 	serverAETitle  string
 	logger         *slog.Logger
+
+	// abstractSyntaxes restricts negotiation to an explicit set of SOP classes.
+	// When nil, the package defaults (plus all storage SOP classes) are used.
+	abstractSyntaxes map[string]bool
+
+	// relationalQueries enables accepting relational queries/retrieval when
+	// proposed through SOP Class Extended Negotiation.
+	relationalQueries bool
+
+	// unifiedProcedureStep adds the UPS SOP classes to the default set
+	unifiedProcedureStep bool
+
+	// transferSyntaxPreferences orders the transfer syntaxes accepted for
+	// individual abstract syntaxes
+	transferSyntaxPreferences map[string][]string
+
+	readBufferSize  int
+	writeBufferSize int
+
+	// calledAEPolicy decides which Called AE Titles are accepted
+	calledAEPolicy CalledAEPolicy
+
+	// noContextsPolicy decides how associations without any acceptable
+	// presentation context are answered
+	noContextsPolicy NoContextsPolicy
+
+	// readTimeout and writeTimeout bound each PDU read and write; deadline,
+	// when set, is an absolute limit on the whole association after which it
+	// is aborted.
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	deadline         time.Time
+	deadlineExceeded atomic.Bool
+
+	// maxOperationsPerformed is the most outstanding operations the layer
+	// agrees to perform when the requestor proposes an Asynchronous
+	// Operations Window. Values below 2 keep the default synchronous mode.
+	maxOperationsPerformed uint16
+
+	// writeMu serialises writes so PDUs from concurrent senders (e.g. C-GET
+	// responses and C-STORE sub-operations) never interleave on the wire.
+	writeMu sync.Mutex
+
+	// done is closed once the layer stops reading PDUs
+	done chan struct{}
+
+	// associationHandler, when set, observes each negotiated association
+	associationHandler AssociationHandler
+
+	// maxPresentationContexts and maxTransferSyntaxes bound what an
+	// A-ASSOCIATE-RQ may propose; zero means the defaults
+	maxPresentationContexts int
+	maxTransferSyntaxes     int
+
+	// disableAutoWorkarounds applies legacyWorkarounds to every peer
+	// instead of those of the identified implementation
+	disableAutoWorkarounds bool
+}
+
+// LayerOption configures optional Layer behaviour.
+type LayerOption func(*Layer)
+
+// WithSupportedAbstractSyntaxes restricts the abstract syntaxes the layer will
+// accept during association negotiation. Any other proposed context is
+// rejected with "abstract syntax not supported".
+func WithSupportedAbstractSyntaxes(uids ...string) LayerOption {
+	return func(p *Layer) {
+		p.abstractSyntaxes = make(map[string]bool, len(uids))
+		for _, uid := range uids {
+			p.abstractSyntaxes[uid] = true
+		}
+	}
+}
+
+// CalledAEPolicy controls how the Called AE Title of an A-ASSOCIATE-RQ is
+// checked against the layer's own AE title.
+type CalledAEPolicy int
+
+const (
+	// CalledAEIgnore accepts associations addressed to any AE title (default)
+	CalledAEIgnore CalledAEPolicy = iota
+	// CalledAEStrict rejects associations whose Called AE Title differs from
+	// the layer's AE title
+	CalledAEStrict
+	// CalledAEAny is CalledAEStrict, except that an empty or blank Called AE
+	// Title is taken as addressed to this AE
+	CalledAEAny
+)
+
+// WithCalledAEPolicy sets how the Called AE Title is checked. Rejected
+// associations receive an A-ASSOCIATE-RJ with reason "called AE title not
+// recognized".
+func WithCalledAEPolicy(policy CalledAEPolicy) LayerOption {
+	return func(p *Layer) {
+		p.calledAEPolicy = policy
+	}
+}
+
+// AssociationHandler is called with the negotiated association context before
+// the A-ASSOCIATE-AC is sent. It runs on the connection's goroutine and must
+// not retain or modify the context.
+type AssociationHandler func(ctx *AssociationContext)
+
+// WithAssociationHandler registers a callback invoked once presentation
+// contexts have been negotiated, e.g. to inspect rejected contexts.
+func WithAssociationHandler(handler AssociationHandler) LayerOption {
+	return func(p *Layer) {
+		p.associationHandler = handler
+	}
+}
+
+// NoContextsPolicy controls how an A-ASSOCIATE-RQ is answered when none of
+// its presentation contexts can be accepted.
+type NoContextsPolicy int
+
+const (
+	// NoContextsReject answers with an A-ASSOCIATE-RJ (default)
+	NoContextsReject NoContextsPolicy = iota
+	// NoContextsAcceptWithResults answers with an A-ASSOCIATE-AC listing
+	// every proposed context with its rejection result, so the requestor
+	// can see why each was refused
+	NoContextsAcceptWithResults
+)
+
+// WithNoContextsPolicy sets how associations without any acceptable
+// presentation context are answered.
+func WithNoContextsPolicy(policy NoContextsPolicy) LayerOption {
+	return func(p *Layer) {
+		p.noContextsPolicy = policy
+	}
+}
+
+// acceptsCalledAE reports whether an association addressed to calledAE is
+// accepted under the layer's policy
+func (p *Layer) acceptsCalledAE(calledAE string) bool {
+	calledAE = strings.TrimSpace(calledAE)
+	switch p.calledAEPolicy {
+	case CalledAEStrict:
+		return calledAE == strings.TrimSpace(p.serverAETitle)
+	case CalledAEAny:
+		return calledAE == "" || calledAE == strings.TrimSpace(p.serverAETitle)
+	default:
+		return true
+	}
 }
 
 // AssociationContext holds association state
@@ -44,6 +196,93 @@ type AssociationContext struct {
 	CallingAETitle   string
 	MaxPDULength     uint32
 	PresentationCtxs map[byte]*PresentationContext
+
+	// CommonExtendedNegotiations holds the SOP Class Common Extended Negotiation
+	// sub-items (0x57) proposed by the requestor, keyed by SOP Class UID.
+	CommonExtendedNegotiations map[string]*CommonExtendedNegotiation
+
+	// ExtendedNegotiations holds the Service-class-application-information of
+	// SOP Class Extended Negotiation sub-items (0x56) proposed by the requestor,
+	// keyed by SOP Class UID.
+	ExtendedNegotiations map[string][]byte
+
+	// RelationalQueries records, per SOP Class UID, whether relational
+	// queries/retrieval were agreed during extended negotiation.
+	RelationalQueries map[string]bool
+
+	// RoleSelections holds the SCP/SCU Role Selection sub-items (0x54)
+	// proposed by the requestor, keyed by SOP Class UID. They are accepted
+	// as proposed.
+	RoleSelections map[string]RoleSelection
+
+	// MaxOperationsPerformed is the negotiated Asynchronous Operations Window
+	// (0x53) for operations this side performs. Zero means the window was not
+	// negotiated, i.e. one operation at a time.
+	MaxOperationsPerformed uint16
+
+	// ImplementationClassUID and ImplementationVersionName identify the
+	// requestor's implementation (0x52, 0x55), and PeerImplementation names
+	// it when it is a known one, e.g. "DCMTK".
+	ImplementationClassUID    string
+	ImplementationVersionName string
+	PeerImplementation        string
+
+	// Workarounds are the non-standard behaviours applied for this peer
+	Workarounds Workarounds
+}
+
+// AssociationContext returns the context of the current association, or nil
+// before an A-ASSOCIATE-RQ has been received.
+func (p *Layer) AssociationContext() *AssociationContext {
+	return p.associationCtx
+}
+
+// AcceptedContexts returns copies of the accepted presentation contexts,
+// ordered by context ID.
+func (c *AssociationContext) AcceptedContexts() []PresentationContext {
+	var accepted []PresentationContext
+	for _, ctx := range c.PresentationCtxs {
+		if ctx.Result == PresentationResultAcceptance {
+			accepted = append(accepted, *ctx)
+		}
+	}
+	sort.Slice(accepted, func(i, j int) bool {
+		return accepted[i].ID < accepted[j].ID
+	})
+	return accepted
+}
+
+// RejectedContexts returns copies of the rejected presentation contexts,
+// ordered by context ID.
+func (c *AssociationContext) RejectedContexts() []PresentationContext {
+	var rejected []PresentationContext
+	for _, ctx := range c.PresentationCtxs {
+		if ctx.Result != PresentationResultAcceptance {
+			rejected = append(rejected, *ctx)
+		}
+	}
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].ID < rejected[j].ID
+	})
+	return rejected
+}
+
+// RoleSelection is the roles the requestor takes for a SOP class, as
+// negotiated through SCP/SCU Role Selection (0x54). Without it the requestor
+// is only an SCU; a C-GET SCU must also take the SCP role for the storage SOP
+// classes it retrieves.
+type RoleSelection struct {
+	SCU bool
+	SCP bool
+}
+
+// CommonExtendedNegotiation represents a SOP Class Common Extended Negotiation
+// sub-item (PS3.7 Annex D.3.3.6). It is only sent by the association requestor,
+// so it is stored for handlers but never echoed in the A-ASSOCIATE-AC.
+type CommonExtendedNegotiation struct {
+	SOPClassUID              string
+	ServiceClassUID          string
+	RelatedGeneralSOPClasses []string
 }
 
 // PresentationContext represents a negotiated presentation context
@@ -52,12 +291,17 @@ type PresentationContext struct {
 	Result         byte
 	AbstractSyntax string
 	TransferSyntax string
+
+	// ProposedTransferSyntaxes lists every transfer syntax the requestor
+	// proposed, in order, e.g. to diagnose transfer syntax rejections.
+	ProposedTransferSyntaxes []string
 }
 
+// Presentation context results (PS3.8 Section 9.3.3.2)
 const (
-	presentationResultAcceptance           byte = 0x00
-	presentationResultRejectAbstractSyntax byte = 0x03
-	presentationResultRejectTransferSyntax byte = 0x04
+	PresentationResultAcceptance           byte = 0x00
+	PresentationResultRejectAbstractSyntax byte = 0x03
+	PresentationResultRejectTransferSyntax byte = 0x04
 )
 
 var supportedAbstractSyntaxes = map[string]bool{
@@ -78,6 +322,30 @@ var supportedTransferSyntaxes = map[string]bool{
 	types.ExplicitVRLittleEndian: true, // Explicit VR Little Endian
 }
 
+// DefaultMaxPDULength is the maximum PDU length advertised in the A-ASSOCIATE-AC
+const DefaultMaxPDULength = 16384
+
+// DefaultAbstractSyntaxes returns the non-storage abstract syntaxes accepted
+// when no explicit set is configured. All storage SOP classes are accepted in
+// addition to these.
+func DefaultAbstractSyntaxes() []string {
+	return sortedKeys(supportedAbstractSyntaxes)
+}
+
+// SupportedTransferSyntaxes returns the transfer syntaxes the layer can accept
+func SupportedTransferSyntaxes() []string {
+	return sortedKeys(supportedTransferSyntaxes)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func normalizeUID(raw []byte) string {
 	value := string(raw)
 	value = strings.TrimRight(value, "\x00 ")
@@ -99,7 +367,167 @@ func supportsTransferSyntax(uid string) bool {
 	return supportedTransferSyntaxes[uid]
 }
 
-func parsePresentationContext(data []byte, logger *slog.Logger) (*PresentationContext, error) {
+// WithRelationalQueries makes the layer agree to relational queries and
+// relational retrieval for Query/Retrieve SOP classes whose requestor proposes
+// them through SOP Class Extended Negotiation (0x56). The handler must then
+// support C-FIND identifiers without a Query/Retrieve Level.
+func WithRelationalQueries() LayerOption {
+	return func(p *Layer) {
+		p.relationalQueries = true
+	}
+}
+
+// WithUnifiedProcedureStep accepts the Unified Procedure Step Push, Watch,
+// Pull, Event and Query SOP classes in addition to the default abstract
+// syntaxes. It has no effect with WithSupportedAbstractSyntaxes, which should
+// list the UPS classes itself. The handler serves them with N-service commands.
+func WithUnifiedProcedureStep() LayerOption {
+	return func(p *Layer) {
+		p.unifiedProcedureStep = true
+	}
+}
+
+// WithTransferSyntaxPreference sets the order in which transfer syntaxes are
+// accepted for one abstract syntax: the first in order that the requestor
+// proposed is selected, even one the layer does not otherwise accept, such
+// as a compressed syntax whose datasets the handler stores as received. When
+// the requestor proposed none of them, the default selection applies.
+// Syntaxes that fail CanCarryDatasets for the abstract syntax are skipped.
+func WithTransferSyntaxPreference(abstractSyntax string, order []string) LayerOption {
+	return func(p *Layer) {
+		if p.transferSyntaxPreferences == nil {
+			p.transferSyntaxPreferences = make(map[string][]string)
+		}
+		p.transferSyntaxPreferences[abstractSyntax] = order
+	}
+}
+
+// DefaultMaxPresentationContexts is the most presentation contexts an
+// A-ASSOCIATE-RQ may propose, which is also the most its odd context IDs allow
+const DefaultMaxPresentationContexts = 128
+
+// DefaultMaxTransferSyntaxes is the most transfer syntaxes one presentation
+// context may propose
+const DefaultMaxTransferSyntaxes = 64
+
+// errProposalLimitExceeded marks an A-ASSOCIATE-RQ proposing more
+// presentation contexts or transfer syntaxes than the layer accepts
+var errProposalLimitExceeded = errors.New("association request exceeds proposal limits")
+
+// WithMaxPresentationContexts sets the most presentation contexts an
+// A-ASSOCIATE-RQ may propose (default DefaultMaxPresentationContexts).
+// Larger requests receive an A-ASSOCIATE-RJ with reason "local limit
+// exceeded".
+func WithMaxPresentationContexts(n int) LayerOption {
+	return func(p *Layer) {
+		p.maxPresentationContexts = n
+	}
+}
+
+// WithMaxTransferSyntaxes sets the most transfer syntaxes one presentation
+// context may propose (default DefaultMaxTransferSyntaxes). Requests with a
+// larger context are rejected like those with too many contexts.
+func WithMaxTransferSyntaxes(n int) LayerOption {
+	return func(p *Layer) {
+		p.maxTransferSyntaxes = n
+	}
+}
+
+// WithAsyncOperationsWindow agrees to perform up to n operations concurrently
+// when the requestor proposes an Asynchronous Operations Window (0x53). The
+// window actually used is the smaller of n and the requestor's proposal.
+func WithAsyncOperationsWindow(n int) LayerOption {
+	return func(p *Layer) {
+		if n > 0xFFFF {
+			n = 0xFFFF
+		}
+		if n > 1 {
+			p.maxOperationsPerformed = uint16(n)
+		}
+	}
+}
+
+// WithReadTimeout limits how long the layer waits for each PDU
+func WithReadTimeout(d time.Duration) LayerOption {
+	return func(p *Layer) {
+		p.readTimeout = d
+	}
+}
+
+// WithWriteTimeout limits how long writing each PDU may take
+func WithWriteTimeout(d time.Duration) LayerOption {
+	return func(p *Layer) {
+		p.writeTimeout = d
+	}
+}
+
+// WithDeadline sets an absolute deadline for the association. Once it passes
+// the layer sends an A-ABORT and closes the connection, whatever the activity
+// on it. Per-PDU timeouts still apply; whichever expires first wins.
+func WithDeadline(deadline time.Time) LayerOption {
+	return func(p *Layer) {
+		p.deadline = deadline
+	}
+}
+
+// WithReadBufferSize buffers reads from the connection with a buffer of n bytes
+func WithReadBufferSize(n int) LayerOption {
+	return func(p *Layer) {
+		p.readBufferSize = n
+	}
+}
+
+// WithWriteBufferSize buffers writes to the connection with a buffer of n
+// bytes. The buffer is flushed after every PDU.
+func WithWriteBufferSize(n int) LayerOption {
+	return func(p *Layer) {
+		p.writeBufferSize = n
+	}
+}
+
+// supportsAbstractSyntax reports whether this layer accepts the given abstract syntax
+func (p *Layer) supportsAbstractSyntax(uid string) bool {
+	if p.abstractSyntaxes != nil {
+		return p.abstractSyntaxes[uid]
+	}
+	if p.unifiedProcedureStep && types.IsUPSSOPClass(uid) {
+		return true
+	}
+	return supportsAbstractSyntax(uid)
+}
+
+// CanCarryDatasets reports whether datasets of the given abstract syntax can
+// be exchanged in transferSyntax. Storage SOP classes accept any syntax, as
+// their datasets are passed through; other services, such as C-FIND, need
+// one the dicom codec can both decode and encode.
+func CanCarryDatasets(abstractSyntax, transferSyntax string) bool {
+	return types.IsStorageSOPClass(abstractSyntax) || dicom.CanEncodeTransferSyntax(transferSyntax)
+}
+
+// selectTransferSyntax picks the transfer syntax to accept among those
+// proposed for an abstract syntax, or "" when none is acceptable
+func (p *Layer) selectTransferSyntax(abstractSyntax string, proposed []string) string {
+	for _, ts := range p.transferSyntaxPreferences[abstractSyntax] {
+		if !slices.Contains(proposed, ts) {
+			continue
+		}
+		if !CanCarryDatasets(abstractSyntax, ts) {
+			p.logger.Warn("Not accepting preferred transfer syntax: its datasets cannot be encoded",
+				"abstract_syntax", abstractSyntax,
+				"transfer_syntax", ts)
+			continue
+		}
+		return ts
+	}
+	for _, ts := range proposed {
+		if supportsTransferSyntax(ts) {
+			return ts
+		}
+	}
+	return ""
+}
+
+func parsePresentationContext(data []byte, maxTransferSyntaxes int, acceptAbstractSyntax func(string) bool, selectTransferSyntax func(string, []string) string, logger *slog.Logger) (*PresentationContext, error) {
 	if len(data) < 4 {
 		return nil, fmt.Errorf("presentation context too short: %d", len(data))
 	}
@@ -123,6 +551,9 @@ func parsePresentationContext(data []byte, logger *slog.Logger) (*PresentationCo
 		case 0x30: // Abstract Syntax
 			abstractSyntax = normalizeUID(value)
 		case 0x40: // Transfer Syntax
+			if maxTransferSyntaxes > 0 && len(transferSyntaxes) == maxTransferSyntaxes {
+				return nil, fmt.Errorf("%w: presentation context %d proposes more than %d transfer syntaxes", errProposalLimitExceeded, ctxID, maxTransferSyntaxes)
+			}
 			transferSyntaxes = append(transferSyntaxes, normalizeUID(value))
 		}
 
@@ -141,20 +572,23 @@ func parsePresentationContext(data []byte, logger *slog.Logger) (*PresentationCo
 			"num_proposed", len(transferSyntaxes))
 	}
 
-	result := presentationResultRejectAbstractSyntax
+	result := PresentationResultRejectAbstractSyntax
 	selectedTransfer := ""
 
-	if supportsAbstractSyntax(abstractSyntax) {
-		for _, ts := range transferSyntaxes {
-			if supportsTransferSyntax(ts) {
-				selectedTransfer = ts
-				result = presentationResultAcceptance
-				break
+	if acceptAbstractSyntax(abstractSyntax) {
+		selectedTransfer = selectTransferSyntax(abstractSyntax, transferSyntaxes)
+		if selectedTransfer != "" {
+			result = PresentationResultAcceptance
+		} else {
+			result = PresentationResultRejectTransferSyntax
+			if logger != nil {
+				logger.Warn("Rejecting presentation context: no supported transfer syntax",
+					"context_id", ctxID,
+					"abstract_syntax", abstractSyntax,
+					"proposed_transfer_syntaxes", transferSyntaxes,
+					"supported_transfer_syntaxes", SupportedTransferSyntaxes())
 			}
 		}
-		if result != presentationResultAcceptance {
-			result = presentationResultRejectTransferSyntax
-		}
 	}
 
 	if logger != nil {
@@ -166,23 +600,39 @@ func parsePresentationContext(data []byte, logger *slog.Logger) (*PresentationCo
 	}
 
 	// Validation: accepted contexts MUST have a transfer syntax
-	if result == presentationResultAcceptance && selectedTransfer == "" {
+	if result == PresentationResultAcceptance && selectedTransfer == "" {
 		// This should never happen - it means we accepted but didn't select a transfer syntax
 		// Force rejection to avoid protocol violation
-		result = presentationResultRejectTransferSyntax
+		result = PresentationResultRejectTransferSyntax
 	}
 
 	return &PresentationContext{
-		ID:             ctxID,
-		Result:         result,
-		AbstractSyntax: abstractSyntax,
-		TransferSyntax: selectedTransfer,
+		ID:                       ctxID,
+		Result:                   result,
+		AbstractSyntax:           abstractSyntax,
+		TransferSyntax:           selectedTransfer,
+		ProposedTransferSyntaxes: transferSyntaxes,
 	}, nil
 }
 
-func parseUserInformation(data []byte) (uint32, error) {
+// userInformation holds the sub-items extracted from a User Information item
+type userInformation struct {
+	maxPDULength               uint32
+	implementationClassUID     string
+	implementationVersionName  string
+	commonExtendedNegotiations []*CommonExtendedNegotiation
+	extendedNegotiations       map[string][]byte
+	roleSelections             map[string]RoleSelection
+
+	// asyncWindow is set when an Asynchronous Operations Window was proposed
+	asyncWindow            bool
+	maxOperationsInvoked   uint16
+	maxOperationsPerformed uint16
+}
+
+func parseUserInformation(data []byte) (*userInformation, error) {
 	offset := 0
-	var maxPDULength uint32
+	info := &userInformation{}
 
 	for offset+4 <= len(data) {
 		subItemType := data[offset]
@@ -190,17 +640,112 @@ func parseUserInformation(data []byte) (uint32, error) {
 		valueStart := offset + 4
 		valueEnd := valueStart + int(subItemLength)
 		if valueEnd > len(data) {
-			return 0, fmt.Errorf("user information sub-item exceeds length")
+			return nil, fmt.Errorf("user information sub-item exceeds length")
 		}
 
-		if subItemType == 0x51 && subItemLength == 4 {
-			maxPDULength = binary.BigEndian.Uint32(data[valueStart:valueEnd])
+		switch subItemType {
+		case 0x51: // Maximum Length
+			if subItemLength == 4 {
+				info.maxPDULength = binary.BigEndian.Uint32(data[valueStart:valueEnd])
+			}
+		case 0x52: // Implementation Class UID
+			info.implementationClassUID = normalizeUID(data[valueStart:valueEnd])
+		case 0x55: // Implementation Version Name
+			info.implementationVersionName = strings.TrimRight(string(data[valueStart:valueEnd]), " \x00")
+		case 0x53: // Asynchronous Operations Window
+			if subItemLength == 4 {
+				info.asyncWindow = true
+				info.maxOperationsInvoked = binary.BigEndian.Uint16(data[valueStart : valueStart+2])
+				info.maxOperationsPerformed = binary.BigEndian.Uint16(data[valueStart+2 : valueEnd])
+			}
+		case 0x57: // SOP Class Common Extended Negotiation
+			// A malformed optional sub-item must not invalidate the rest of
+			// the user information, so it is dropped rather than reported.
+			if neg, err := parseCommonExtendedNegotiation(data[valueStart:valueEnd]); err == nil {
+				info.commonExtendedNegotiations = append(info.commonExtendedNegotiations, neg)
+			}
+		case 0x54: // SCP/SCU Role Selection
+			value := data[valueStart:valueEnd]
+			if len(value) >= 2 {
+				uidLength := int(binary.BigEndian.Uint16(value[0:2]))
+				if 2+uidLength+2 == len(value) {
+					if info.roleSelections == nil {
+						info.roleSelections = make(map[string]RoleSelection)
+					}
+					uid := normalizeUID(value[2 : 2+uidLength])
+					info.roleSelections[uid] = RoleSelection{SCU: value[2+uidLength] == 1, SCP: value[3+uidLength] == 1}
+				}
+			}
+		case 0x56: // SOP Class Extended Negotiation
+			value := data[valueStart:valueEnd]
+			if len(value) >= 2 {
+				uidLength := int(binary.BigEndian.Uint16(value[0:2]))
+				if 2+uidLength <= len(value) {
+					if info.extendedNegotiations == nil {
+						info.extendedNegotiations = make(map[string][]byte)
+					}
+					uid := normalizeUID(value[2 : 2+uidLength])
+					info.extendedNegotiations[uid] = value[2+uidLength:]
+				}
+			}
 		}
 
 		offset = valueEnd
 	}
 
-	return maxPDULength, nil
+	return info, nil
+}
+
+// parseCommonExtendedNegotiation decodes the value of a 0x57 sub-item. Every
+// field is prefixed by its own 2-byte length, and the related general SOP
+// classes form a nested list of length-prefixed UIDs.
+func parseCommonExtendedNegotiation(data []byte) (*CommonExtendedNegotiation, error) {
+	readField := func(offset int) (string, int, error) {
+		if offset+2 > len(data) {
+			return "", 0, fmt.Errorf("common extended negotiation truncated at offset %d", offset)
+		}
+		length := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+		end := offset + 2 + length
+		if end > len(data) {
+			return "", 0, fmt.Errorf("common extended negotiation field exceeds sub-item length")
+		}
+		return normalizeUID(data[offset+2 : end]), end, nil
+	}
+
+	neg := &CommonExtendedNegotiation{}
+
+	var offset int
+	var err error
+	if neg.SOPClassUID, offset, err = readField(0); err != nil {
+		return nil, err
+	}
+	if neg.ServiceClassUID, offset, err = readField(offset); err != nil {
+		return nil, err
+	}
+
+	// Related General SOP Class Identification is optional
+	if offset+2 > len(data) {
+		return neg, nil
+	}
+	relatedLength := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	relatedEnd := offset + relatedLength
+	if relatedEnd > len(data) {
+		return nil, fmt.Errorf("related general SOP class list exceeds sub-item length")
+	}
+
+	for offset < relatedEnd {
+		var uid string
+		if uid, offset, err = readField(offset); err != nil {
+			return nil, err
+		}
+		if offset > relatedEnd {
+			return nil, fmt.Errorf("related general SOP class exceeds list length")
+		}
+		neg.RelatedGeneralSOPClasses = append(neg.RelatedGeneralSOPClasses, uid)
+	}
+
+	return neg, nil
 }
 
 // DIMSEHandler interface for handling DIMSE messages
@@ -208,22 +753,122 @@ type DIMSEHandler interface {
 	HandleDIMSEMessage(presContextID byte, msgCtrlHeader byte, data []byte, pduLayer *Layer) error
 }
 
+// operationWaiter is implemented by DIMSE handlers that perform operations
+// concurrently; the layer waits for them before releasing the association
+type operationWaiter interface {
+	Wait()
+}
+
+// waitForOperations blocks until the DIMSE handler's in-flight operations finish
+func (p *Layer) waitForOperations() {
+	if waiter, ok := p.dimseHandler.(operationWaiter); ok {
+		waiter.Wait()
+	}
+}
+
 // NewLayer creates a new PDU layer handler
-func NewLayer(conn net.Conn, dimseHandler DIMSEHandler, serverAETitle string, logger *slog.Logger) *Layer {
+func NewLayer(conn net.Conn, dimseHandler DIMSEHandler, serverAETitle string, logger *slog.Logger, opts ...LayerOption) *Layer {
 	if logger == nil {
 		logger = slog.Default()
 	}
-	return &Layer{
+	layer := &Layer{
 		conn:          conn,
 		dimseHandler:  dimseHandler,
 		serverAETitle: serverAETitle,
 		logger:        logger,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(layer)
+	}
+	if layer.maxPresentationContexts <= 0 {
+		layer.maxPresentationContexts = DefaultMaxPresentationContexts
+	}
+	if layer.maxTransferSyntaxes <= 0 {
+		layer.maxTransferSyntaxes = DefaultMaxTransferSyntaxes
+	}
+	if layer.readBufferSize > 0 || layer.writeBufferSize > 0 {
+		layer.conn = NewBufferedConn(conn, layer.readBufferSize, layer.writeBufferSize)
+	}
+	return layer
+}
+
+// writePDU writes a complete PDU and flushes any write buffer
+func (p *Layer) writePDU(data []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.writePDULocked(data)
+}
+
+// writePDULocked is writePDU for callers already holding writeMu
+func (p *Layer) writePDULocked(data []byte) error {
+	if deadline := p.ioDeadline(p.writeTimeout); !deadline.IsZero() {
+		if err := p.conn.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if _, err := p.conn.Write(data); err != nil {
+		return err
 	}
+	return FlushConn(p.conn)
+}
+
+// ioDeadline returns the deadline for an I/O operation bounded by timeout
+// and by the association deadline, or the zero time when neither is set
+func (p *Layer) ioDeadline(timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if !p.deadline.IsZero() && (deadline.IsZero() || p.deadline.Before(deadline)) {
+		deadline = p.deadline
+	}
+	return deadline
+}
+
+// abortOnDeadline aborts the association once its deadline has passed
+func (p *Layer) abortOnDeadline() {
+	p.deadlineExceeded.Store(true)
+	p.logger.Warn("Association deadline exceeded, aborting",
+		"remote_addr", p.conn.RemoteAddr())
+	p.Abort()
+}
+
+// Abort sends an A-ABORT and closes the connection, ending the association
+// without waiting for outstanding operations. It is safe to call from any
+// goroutine.
+func (p *Layer) Abort() {
+	// Don't let a stalled peer or an in-flight write hold up the abort
+	_ = p.conn.SetWriteDeadline(time.Now().Add(time.Second))
+
+	// A-ABORT, source: service-user, reason: not specified
+	abort := []byte{TypeAbort, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+	p.writeMu.Lock()
+	if _, err := p.conn.Write(abort); err == nil {
+		_ = FlushConn(p.conn)
+	}
+	p.writeMu.Unlock()
+
+	_ = p.conn.Close()
+}
+
+// Done returns a channel that is closed when the layer stops reading PDUs,
+// after which no further messages from the peer will arrive.
+func (p *Layer) Done() <-chan struct{} {
+	return p.done
 }
 
 // HandleConnection manages the complete DICOM connection lifecycle
 func (p *Layer) HandleConnection() error {
 	defer p.conn.Close()
+	defer p.waitForOperations()
+	// Before waiting, so operations awaiting a peer response give up
+	defer close(p.done)
+
+	if !p.deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(p.deadline), p.abortOnDeadline)
+		defer timer.Stop()
+	}
 	p.logger.Info("New DICOM connection", "remote_addr", p.conn.RemoteAddr())
 
 	// Handle association establishment
@@ -247,15 +892,27 @@ func (p *Layer) HandleConnection() error {
 			if err == io.EOF {
 				break // Normal termination
 			}
+			if p.deadlineExceeded.Load() {
+				return fmt.Errorf("association deadline exceeded: %v", err)
+			}
 			return fmt.Errorf("error handling PDU: %v", err)
 		}
 	}
 
+	if p.deadlineExceeded.Load() {
+		return fmt.Errorf("association deadline exceeded")
+	}
 	return nil
 }
 
 // readPDU reads a complete PDU from the connection
 func (p *Layer) readPDU() (*PDU, error) {
+	if deadline := p.ioDeadline(p.readTimeout); !deadline.IsZero() {
+		if err := p.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
 	// Read PDU header (6 bytes)
 	header := make([]byte, 6)
 	if _, err := io.ReadFull(p.conn, header); err != nil {
@@ -266,8 +923,8 @@ func (p *Layer) readPDU() (*PDU, error) {
 	pduLength := binary.BigEndian.Uint32(header[2:6])
 
 	// Read PDU data
-	pduData := make([]byte, pduLength)
-	if _, err := io.ReadFull(p.conn, pduData); err != nil {
+	pduData, err := ReadPDUBody(p.conn, pduLength)
+	if err != nil {
 		return nil, fmt.Errorf("failed to read PDU data: %v", err)
 	}
 
@@ -321,14 +978,40 @@ func (p *Layer) handleAssociateRequest(pdu *PDU) error {
 	p.associationCtx = &AssociationContext{
 		CalledAETitle:    p.serverAETitle, // Use configured server AE title
 		CallingAETitle:   "UNKNOWN",       // Default, will be updated from request
-		MaxPDULength:     16384,
+		MaxPDULength:     DefaultMaxPDULength,
 		PresentationCtxs: make(map[byte]*PresentationContext),
+
+		CommonExtendedNegotiations: make(map[string]*CommonExtendedNegotiation),
+		ExtendedNegotiations:       make(map[string][]byte),
+		RelationalQueries:          make(map[string]bool),
+		RoleSelections:             make(map[string]RoleSelection),
 	}
 
 	// Parse the incoming association request to get the presentation contexts
-	if err := p.parseAssociationRequest(pdu); err != nil {
+	if err := p.parseAssociationRequest(pdu); errors.Is(err, errProposalLimitExceeded) {
+		p.logger.Warn("Rejecting association exceeding proposal limits",
+			"calling_ae", p.associationCtx.CallingAETitle,
+			"error", err)
+		// Rejected-permanent, service-provider (presentation), local limit exceeded
+		if err := p.writePDU(BuildAssociateReject(0x01, 0x03, 0x02)); err != nil {
+			return fmt.Errorf("failed to send A-ASSOCIATE-RJ: %v", err)
+		}
+		return err
+	} else if err != nil {
 		p.logger.Debug("Using default presentation contexts", "reason", err)
 		// Fall back to accepting common contexts
+		p.applyWorkarounds()
+	}
+
+	if !p.acceptsCalledAE(p.associationCtx.CalledAETitle) {
+		p.logger.Warn("Rejecting association for unrecognized called AE title",
+			"called_ae", p.associationCtx.CalledAETitle,
+			"calling_ae", p.associationCtx.CallingAETitle)
+		// Rejected-permanent, service-user, called AE title not recognized
+		if err := p.writePDU(BuildAssociateReject(0x01, 0x01, 0x07)); err != nil {
+			return fmt.Errorf("failed to send A-ASSOCIATE-RJ: %v", err)
+		}
+		return fmt.Errorf("called AE title %q not recognized", p.associationCtx.CalledAETitle)
 	}
 
 	// If no contexts were parsed, add default supported contexts
@@ -338,7 +1021,22 @@ func (p *Layer) handleAssociateRequest(pdu *PDU) error {
 
 	// Send A-ASSOCIATE-AC
 	response := p.createAssociateAccept()
-	if _, err := p.conn.Write(response); err != nil {
+	if p.associationHandler != nil {
+		p.associationHandler(p.associationCtx)
+	}
+
+	if len(p.associationCtx.AcceptedContexts()) == 0 && p.noContextsPolicy == NoContextsReject {
+		p.logger.Warn("Rejecting association: no presentation context accepted",
+			"calling_ae", p.associationCtx.CallingAETitle,
+			"proposed_contexts", len(p.associationCtx.PresentationCtxs))
+		// Rejected-permanent, service-user, no reason given
+		if err := p.writePDU(BuildAssociateReject(0x01, 0x01, 0x01)); err != nil {
+			return fmt.Errorf("failed to send A-ASSOCIATE-RJ: %v", err)
+		}
+		return fmt.Errorf("no presentation context accepted")
+	}
+
+	if err := p.writePDU(response); err != nil {
 		return fmt.Errorf("failed to send A-ASSOCIATE-AC: %v", err)
 	}
 
@@ -346,6 +1044,28 @@ func (p *Layer) handleAssociateRequest(pdu *PDU) error {
 	return nil
 }
 
+// BuildAssociateReject builds an A-ASSOCIATE-RJ PDU with the given result
+// (1 permanent, 2 transient), source (1 service user, 2 service provider ACSE,
+// 3 service provider presentation) and reason (PS3.8 Table 9-21)
+func BuildAssociateReject(result, source, reason byte) []byte {
+	return []byte{TypeAssociateRJ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, result, source, reason}
+}
+
+// ParseAssociateReject returns the result, source and reason of an
+// A-ASSOCIATE-RJ PDU, including its 6-byte header
+func ParseAssociateReject(data []byte) (result, source, reason byte, err error) {
+	if len(data) < 10 {
+		return 0, 0, 0, fmt.Errorf("A-ASSOCIATE-RJ too short: %d bytes", len(data))
+	}
+	if data[0] != TypeAssociateRJ {
+		return 0, 0, 0, fmt.Errorf("PDU type 0x%02x is not A-ASSOCIATE-RJ", data[0])
+	}
+	if length := binary.BigEndian.Uint32(data[2:6]); length != 4 {
+		return 0, 0, 0, fmt.Errorf("A-ASSOCIATE-RJ length is %d, want 4", length)
+	}
+	return data[7], data[8], data[9], nil
+}
+
 // handlePDataTF processes P-DATA-TF PDUs and forwards to DIMSE layer
 func (p *Layer) handlePDataTF(pdu *PDU) error {
 	p.logger.Debug("Processing P-DATA-TF")
@@ -355,37 +1075,52 @@ func (p *Layer) handlePDataTF(pdu *PDU) error {
 		return fmt.Errorf("P-DATA-TF too short")
 	}
 
-	// Parse PDV
-	pdvLength := binary.BigEndian.Uint32(pdu.Data[0:4])
-	if len(pdu.Data) < int(4+pdvLength) {
-		return fmt.Errorf("incomplete PDV data")
-	}
+	// A P-DATA-TF may carry several PDVs, possibly for different
+	// presentation contexts; each is forwarded in order
+	offset := 0
+	for offset < len(pdu.Data) {
+		if offset+4 > len(pdu.Data) {
+			return fmt.Errorf("incomplete PDV header")
+		}
+		pdvLength := binary.BigEndian.Uint32(pdu.Data[offset : offset+4])
+		if uint64(len(pdu.Data)-offset-4) < uint64(pdvLength) {
+			return fmt.Errorf("incomplete PDV data")
+		}
 
-	pdvData := pdu.Data[4 : 4+pdvLength]
-	if len(pdvData) < 2 {
-		return fmt.Errorf("PDV data too short")
-	}
+		pdvData := pdu.Data[offset+4 : offset+4+int(pdvLength)]
+		if len(pdvData) < 2 {
+			return fmt.Errorf("PDV data too short")
+		}
+		offset += 4 + int(pdvLength)
+
+		presContextID := pdvData[0]
+		msgCtrlHeader := pdvData[1]
+		dimseData := pdvData[2:]
 
-	presContextID := pdvData[0]
-	msgCtrlHeader := pdvData[1]
-	dimseData := pdvData[2:]
+		p.logger.Debug("Processing DIMSE message",
+			"presentation_context_id", presContextID,
+			"message_control_header", fmt.Sprintf("0x%02x", msgCtrlHeader))
 
-	p.logger.Debug("Processing DIMSE message",
-		"presentation_context_id", presContextID,
-		"message_control_header", fmt.Sprintf("0x%02x", msgCtrlHeader))
+		// Forward to DIMSE layer
+		if err := p.dimseHandler.HandleDIMSEMessage(presContextID, msgCtrlHeader, dimseData, p); err != nil {
+			return err
+		}
+	}
 
-	// Forward to DIMSE layer
-	return p.dimseHandler.HandleDIMSEMessage(presContextID, msgCtrlHeader, dimseData, p)
+	return nil
 }
 
 // handleReleaseRequest processes A-RELEASE-RQ and sends A-RELEASE-RP
 func (p *Layer) handleReleaseRequest() error {
 	p.logger.Debug("Processing A-RELEASE-RQ")
 
+	// Responses to outstanding operations must precede the release
+	p.waitForOperations()
+
 	// Send A-RELEASE-RP
 	response := []byte{0x06, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
 
-	if _, err := p.conn.Write(response); err != nil {
+	if err := p.writePDU(response); err != nil {
 		return fmt.Errorf("failed to send A-RELEASE-RP: %v", err)
 	}
 
@@ -400,57 +1135,64 @@ func (p *Layer) SendDIMSEResponse(presContextID byte, commandData []byte) error
 
 // SendDIMSEResponseWithDataset sends a DIMSE response with optional dataset via P-DATA-TF
 func (p *Layer) SendDIMSEResponseWithDataset(presContextID byte, commandData []byte, datasetData []byte) error {
-	// First, send the command PDV as a separate P-DATA-TF PDU
-	commandPDVHeader := []byte{presContextID, 0x03} // Message Control Header = 0x03 (command, last fragment)
-	commandPDVData := append(commandPDVHeader, commandData...)
-
-	// PDV Length for command
-	commandPDVLength := make([]byte, 4)
-	binary.BigEndian.PutUint32(commandPDVLength, uint32(len(commandPDVData)))
-
-	// Create command P-DATA-TF PDU
-	commandPDUHeader := []byte{TypePDataTF, 0x00} // P-DATA-TF PDU type
-	commandPDULength := make([]byte, 4)
-	binary.BigEndian.PutUint32(commandPDULength, uint32(len(commandPDVLength)+len(commandPDVData)))
-
-	// Assemble command PDU: PDU header + PDU length + command PDV
-	commandResponse := append(commandPDUHeader, commandPDULength...)
-	commandResponse = append(commandResponse, commandPDVLength...)
-	commandResponse = append(commandResponse, commandPDVData...)
-
-	// Send command PDU
-	if _, err := p.conn.Write(commandResponse); err != nil {
-		return fmt.Errorf("failed to send command PDU: %v", err)
-	}
-
-	// If there's dataset data, send it as a separate P-DATA-TF PDU
-	if len(datasetData) > 0 {
-		datasetPDVHeader := []byte{presContextID, 0x02} // Message Control Header = 0x02 (dataset, last fragment)
-		datasetPDVData := append(datasetPDVHeader, datasetData...)
+	commandPDV := appendPDV(nil, presContextID, 0x03, commandData) // command, last fragment
 
-		// PDV Length for dataset
-		datasetPDVLength := make([]byte, 4)
-		binary.BigEndian.PutUint32(datasetPDVLength, uint32(len(datasetPDVData)))
+	// Hold the write lock across command and dataset so the fragments of
+	// concurrent messages are not interleaved either
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
 
-		// Create dataset P-DATA-TF PDU
-		datasetPDUHeader := []byte{TypePDataTF, 0x00} // P-DATA-TF PDU type
-		datasetPDULength := make([]byte, 4)
-		binary.BigEndian.PutUint32(datasetPDULength, uint32(len(datasetPDVLength)+len(datasetPDVData)))
+	if len(datasetData) == 0 {
+		if err := p.writePDULocked(pDataTF(commandPDV)); err != nil {
+			return fmt.Errorf("failed to send command PDU: %v", err)
+		}
+		return nil
+	}
 
-		// Assemble dataset PDU: PDU header + PDU length + dataset PDV
-		datasetResponse := append(datasetPDUHeader, datasetPDULength...)
-		datasetResponse = append(datasetResponse, datasetPDVLength...)
-		datasetResponse = append(datasetResponse, datasetPDVData...)
+	datasetPDV := appendPDV(nil, presContextID, 0x02, datasetData) // dataset, last fragment
 
-		// Send dataset PDU
-		if _, err := p.conn.Write(datasetResponse); err != nil {
-			return fmt.Errorf("failed to send dataset PDU: %v", err)
+	// Small messages, such as C-FIND matches, go out as one PDU carrying
+	// both PDVs when the peer's maximum PDU length allows
+	if p.fitsInPDU(len(commandPDV) + len(datasetPDV)) {
+		if err := p.writePDULocked(pDataTF(append(commandPDV, datasetPDV...))); err != nil {
+			return fmt.Errorf("failed to send response PDU: %v", err)
 		}
+		return nil
 	}
 
+	if err := p.writePDULocked(pDataTF(commandPDV)); err != nil {
+		return fmt.Errorf("failed to send command PDU: %v", err)
+	}
+	if err := p.writePDULocked(pDataTF(datasetPDV)); err != nil {
+		return fmt.Errorf("failed to send dataset PDU: %v", err)
+	}
 	return nil
 }
 
+// fitsInPDU reports whether a P-DATA-TF payload of n bytes is within the
+// maximum PDU length the peer announced (zero meaning no limit)
+func (p *Layer) fitsInPDU(n int) bool {
+	if p.associationCtx == nil || p.associationCtx.MaxPDULength == 0 {
+		return true
+	}
+	return n <= int(p.associationCtx.MaxPDULength)
+}
+
+// appendPDV appends a presentation data value item to buf
+func appendPDV(buf []byte, presContextID, controlHeader byte, data []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)+2))
+	buf = append(buf, presContextID, controlHeader)
+	return append(buf, data...)
+}
+
+// pDataTF wraps PDV items in a P-DATA-TF PDU
+func pDataTF(pdvs []byte) []byte {
+	pdu := make([]byte, 0, 6+len(pdvs))
+	pdu = append(pdu, TypePDataTF, 0x00)
+	pdu = binary.BigEndian.AppendUint32(pdu, uint32(len(pdvs)))
+	return append(pdu, pdvs...)
+}
+
 // GetTransferSyntax returns the negotiated transfer syntax for the given presentation context.
 func (p *Layer) GetTransferSyntax(presContextID byte) (string, error) {
 	if p.associationCtx == nil {
@@ -469,6 +1211,41 @@ func (p *Layer) GetTransferSyntax(presContextID byte) (string, error) {
 	return ctx.TransferSyntax, nil
 }
 
+// GetAbstractSyntax returns the abstract syntax of an accepted presentation
+// context
+func (p *Layer) GetAbstractSyntax(presContextID byte) (string, error) {
+	if p.associationCtx == nil {
+		return "", fmt.Errorf("association context not initialized")
+	}
+
+	ctx, ok := p.associationCtx.PresentationCtxs[presContextID]
+	if !ok || ctx.Result != PresentationResultAcceptance {
+		return "", fmt.Errorf("presentation context %d not accepted", presContextID)
+	}
+	return ctx.AbstractSyntax, nil
+}
+
+// FindPresentationContext returns the ID and transfer syntax of the accepted
+// presentation context with the lowest ID for an abstract syntax, e.g. the
+// storage context a C-GET sub-operation must be sent on
+func (p *Layer) FindPresentationContext(abstractSyntax string) (byte, string, error) {
+	if p.associationCtx == nil {
+		return 0, "", fmt.Errorf("association context not initialized")
+	}
+
+	found := false
+	var id byte
+	for ctxID, ctx := range p.associationCtx.PresentationCtxs {
+		if ctx.Result == PresentationResultAcceptance && ctx.AbstractSyntax == abstractSyntax && (!found || ctxID < id) {
+			id, found = ctxID, true
+		}
+	}
+	if !found {
+		return 0, "", fmt.Errorf("no accepted presentation context for %s", abstractSyntax)
+	}
+	return id, p.associationCtx.PresentationCtxs[id].TransferSyntax, nil
+}
+
 // createAssociateAccept creates a proper A-ASSOCIATE-AC PDU
 func (p *Layer) createAssociateAccept() []byte {
 	// Fixed fields (68 bytes)
@@ -514,6 +1291,11 @@ func (p *Layer) createAssociateAccept() []byte {
 		}
 	}
 
+	// With nothing accepted and NoContextsAcceptWithResults, the rejected
+	// contexts are all there is to tell the requestor
+	includeRejected := p.noContextsPolicy == NoContextsAcceptWithResults &&
+		len(p.associationCtx.AcceptedContexts()) == 0
+
 	var allPresContextItems []byte
 	for _, id := range contextIDs {
 		ctx := p.associationCtx.PresentationCtxs[id]
@@ -521,8 +1303,8 @@ func (p *Layer) createAssociateAccept() []byte {
 		// WORKAROUND: Some DICOM implementations (e.g., DCMTK/Orthanc) incorrectly reject
 		// A-ASSOCIATE-AC PDUs that include rejected presentation contexts, even though
 		// DICOM PS3.8 Section 9.3.3.3 requires including all contexts from the RQ.
-		// Skip rejected contexts to maintain compatibility.
-		if ctx.Result != presentationResultAcceptance {
+		// Skip rejected contexts for those peers to maintain compatibility.
+		if ctx.Result != PresentationResultAcceptance && !includeRejected && p.associationCtx.Workarounds.SkipRejectedContexts {
 			p.logger.Debug("Skipping rejected context (compatibility workaround)",
 				"context_id", ctx.ID,
 				"result", ctx.Result)
@@ -534,14 +1316,14 @@ func (p *Layer) createAssociateAccept() []byte {
 		// According to DICOM Part 8, Section 9.3.3.3:
 		// - For accepted contexts (Result == 0x00): include ONLY Transfer Syntax
 		// - For rejected contexts (Result != 0x00): include NO sub-items
-		if ctx.Result == presentationResultAcceptance {
+		if ctx.Result == PresentationResultAcceptance {
 			// CRITICAL: Accepted contexts MUST have a transfer syntax
 			if ctx.TransferSyntax == "" {
 				p.logger.Error("Accepted presentation context missing transfer syntax",
 					"context_id", ctx.ID,
 					"abstract_syntax", ctx.AbstractSyntax)
 				// This should never happen - reject the context instead
-				ctx.Result = presentationResultRejectTransferSyntax
+				ctx.Result = PresentationResultRejectTransferSyntax
 			} else {
 				// Transfer Syntax only for accepted contexts
 				transferSyntaxItem := []byte{0x40, 0x00} // Item type
@@ -559,7 +1341,7 @@ func (p *Layer) createAssociateAccept() []byte {
 		presContextLen := make([]byte, 2)
 		binary.BigEndian.PutUint16(presContextLen, uint16(4+len(presContextData)))
 		presContextItem = append(presContextItem, presContextLen...)
-		presContextItem = append(presContextItem, ctx.ID, ctx.Result, 0x00, 0x00)
+		presContextItem = append(presContextItem, ctx.ID, 0x00, ctx.Result, 0x00) // ID, reserved, result, reserved
 		presContextItem = append(presContextItem, presContextData...)
 
 		allPresContextItems = append(allPresContextItems, presContextItem...)
@@ -568,7 +1350,7 @@ func (p *Layer) createAssociateAccept() []byte {
 	// User Information Item
 	maxPDUItem := []byte{0x51, 0x00, 0x00, 0x04}
 	maxPDUValue := make([]byte, 4)
-	binary.BigEndian.PutUint32(maxPDUValue, 16384)
+	binary.BigEndian.PutUint32(maxPDUValue, DefaultMaxPDULength)
 	maxPDUItem = append(maxPDUItem, maxPDUValue...)
 
 	implClassUID := "1.2.3.4.5.6.7.8.9"
@@ -587,6 +1369,9 @@ func (p *Layer) createAssociateAccept() []byte {
 
 	userInfoData := append(maxPDUItem, implClassItem...)
 	userInfoData = append(userInfoData, implVersionItem...)
+	userInfoData = append(userInfoData, p.asyncOperationsWindowReply()...)
+	userInfoData = append(userInfoData, p.roleSelectionReplies()...)
+	userInfoData = append(userInfoData, p.extendedNegotiationReplies()...)
 	userInfoItem := []byte{0x50, 0x00}
 	userInfoLen := make([]byte, 2)
 	binary.BigEndian.PutUint16(userInfoLen, uint16(len(userInfoData)))
@@ -607,11 +1392,189 @@ func (p *Layer) createAssociateAccept() []byte {
 	return append(pduHeader, pduData...)
 }
 
+// extendedNegotiationReplies builds the SOP Class Extended Negotiation (0x56)
+// sub-items for the A-ASSOCIATE-AC and records the agreed relational support.
+// Only relational queries/retrieval (byte 1 of the application information)
+// is negotiated; a reply is omitted entirely when the feature is disabled,
+// which the requestor must treat as "not supported". Replies are ordered by
+// presentation context ID.
+func (p *Layer) extendedNegotiationReplies() []byte {
+	if !p.relationalQueries {
+		return nil
+	}
+
+	var items []byte
+	for _, ctx := range p.associationCtx.AcceptedContexts() {
+		appInfo, ok := p.associationCtx.ExtendedNegotiations[ctx.AbstractSyntax]
+		if !ok || !types.IsQueryRetrieveSOPClass(ctx.AbstractSyntax) {
+			continue
+		}
+		if _, done := p.associationCtx.RelationalQueries[ctx.AbstractSyntax]; done {
+			continue
+		}
+
+		relational := len(appInfo) > 0 && appInfo[0] == 1
+		p.associationCtx.RelationalQueries[ctx.AbstractSyntax] = relational
+
+		reply := byte(0)
+		if relational {
+			reply = 1
+		}
+
+		uid := []byte(ctx.AbstractSyntax)
+		value := binary.BigEndian.AppendUint16(nil, uint16(len(uid)))
+		value = append(value, uid...)
+		value = append(value, reply)
+
+		items = append(items, 0x56, 0x00)
+		items = binary.BigEndian.AppendUint16(items, uint16(len(value)))
+		items = append(items, value...)
+	}
+	return items
+}
+
+// roleSelectionReplies builds the SCP/SCU Role Selection (0x54) sub-items for
+// the A-ASSOCIATE-AC, accepting every proposed role, ordered by SOP Class UID
+func (p *Layer) roleSelectionReplies() []byte {
+	uids := make([]string, 0, len(p.associationCtx.RoleSelections))
+	for uid := range p.associationCtx.RoleSelections {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	var items []byte
+	for _, uid := range uids {
+		roles := p.associationCtx.RoleSelections[uid]
+		value := binary.BigEndian.AppendUint16(nil, uint16(len(uid)))
+		value = append(value, uid...)
+		value = append(value, roleByte(roles.SCU), roleByte(roles.SCP))
+
+		items = append(items, 0x54, 0x00)
+		items = binary.BigEndian.AppendUint16(items, uint16(len(value)))
+		items = append(items, value...)
+	}
+	return items
+}
+
+// roleByte encodes a role of a Role Selection sub-item
+func roleByte(taken bool) byte {
+	if taken {
+		return 1
+	}
+	return 0
+}
+
+// negotiateOperationsWindow returns how many of the requestor's invoked
+// operations this side agrees to perform. Zero in the proposal means
+// unlimited, so the configured limit applies.
+func (p *Layer) negotiateOperationsWindow(proposedInvoked uint16) uint16 {
+	if p.maxOperationsPerformed < 2 {
+		return 0
+	}
+	if proposedInvoked == 0 || proposedInvoked > p.maxOperationsPerformed {
+		return p.maxOperationsPerformed
+	}
+	if proposedInvoked < 2 {
+		return 0
+	}
+	return proposedInvoked
+}
+
+// asyncOperationsWindowReply builds the Asynchronous Operations Window (0x53)
+// sub-item for the A-ASSOCIATE-AC. It is omitted unless more than one
+// operation was agreed, which the requestor reads as the default window of 1.
+func (p *Layer) asyncOperationsWindowReply() []byte {
+	performed := p.associationCtx.MaxOperationsPerformed
+	if performed < 2 {
+		return nil
+	}
+
+	// This side only invokes C-GET sub-operations, one at a time
+	value := binary.BigEndian.AppendUint16(nil, 1)
+	value = binary.BigEndian.AppendUint16(value, performed)
+
+	item := []byte{0x53, 0x00}
+	item = binary.BigEndian.AppendUint16(item, uint16(len(value)))
+	return append(item, value...)
+}
+
+// AsyncOperationsPerformed returns how many operations the requestor may have
+// outstanding at once: the negotiated Asynchronous Operations Window, or 1.
+func (p *Layer) AsyncOperationsPerformed() int {
+	if p.associationCtx == nil || p.associationCtx.MaxOperationsPerformed < 2 {
+		return 1
+	}
+	return int(p.associationCtx.MaxOperationsPerformed)
+}
+
+// AcceptedContexts returns copies of the presentation contexts accepted for
+// the current association, or nil before negotiation.
+func (p *Layer) AcceptedContexts() []PresentationContext {
+	if p.associationCtx == nil {
+		return nil
+	}
+	return p.associationCtx.AcceptedContexts()
+}
+
+// SCPRoleNegotiated reports whether the requestor took the SCP role for an
+// abstract syntax through SCP/SCU Role Selection, as a C-GET SCU must for the
+// storage SOP classes it retrieves.
+func (p *Layer) SCPRoleNegotiated(abstractSyntax string) bool {
+	if p.associationCtx == nil {
+		return false
+	}
+	return p.associationCtx.RoleSelections[abstractSyntax].SCP
+}
+
+// RelationalQueriesNegotiated reports whether relational queries/retrieval
+// were agreed for the abstract syntax of the given presentation context.
+func (p *Layer) RelationalQueriesNegotiated(presContextID byte) bool {
+	if p.associationCtx == nil {
+		return false
+	}
+	ctx, ok := p.associationCtx.PresentationCtxs[presContextID]
+	if !ok {
+		return false
+	}
+	return p.associationCtx.RelationalQueries[ctx.AbstractSyntax]
+}
+
+// associateRQFixedFieldsLength is the size of the A-ASSOCIATE-RQ fields
+// before the variable items: protocol version (2), reserved (2), Called AE
+// Title (16), Calling AE Title (16) and reserved (32)
+const associateRQFixedFieldsLength = 2 + 2 + 16 + 16 + 32
+
+// variableItemsOffset returns where the variable items of an A-ASSOCIATE-RQ
+// start. When the byte after the fixed fields is not an Application Context,
+// Presentation Context or User Information item, as with peers that get the
+// reserved fields wrong, it resynchronizes on the Application Context item.
+func (p *Layer) variableItemsOffset(data []byte) int {
+	offset := associateRQFixedFieldsLength
+	if offset >= len(data) {
+		return offset
+	}
+	switch data[offset] {
+	case 0x10, 0x20, 0x50:
+		return offset
+	}
+
+	if idx := bytes.Index(data, []byte(types.ApplicationContextUID)); idx >= 4 && data[idx-4] == 0x10 {
+		p.logger.Warn("A-ASSOCIATE-RQ variable items misaligned, resynchronized on the application context",
+			"expected_offset", offset,
+			"found_offset", idx-4)
+		return idx - 4
+	}
+	p.logger.Warn("A-ASSOCIATE-RQ variable items misaligned and no application context found",
+		"offset", offset,
+		"item_type", fmt.Sprintf("0x%02x", data[offset]))
+	return offset
+}
+
 // parseAssociationRequest parses an A-ASSOCIATE-RQ PDU to extract presentation contexts and AE titles
 func (p *Layer) parseAssociationRequest(pdu *PDU) error {
 	p.logger.Debug("Parsing association request", "pdu_length", len(pdu.Data))
 
-	if len(pdu.Data) < 68 { // Minimum size for a basic association request
+	if len(pdu.Data) < associateRQFixedFieldsLength { // Minimum size for a basic association request
 		return fmt.Errorf("association request too short")
 	}
 
@@ -639,14 +1602,18 @@ func (p *Layer) parseAssociationRequest(pdu *PDU) error {
 		p.associationCtx.CalledAETitle = calledAE
 		p.associationCtx.CallingAETitle = callingAE
 		p.associationCtx.PresentationCtxs = make(map[byte]*PresentationContext)
+		p.associationCtx.CommonExtendedNegotiations = make(map[string]*CommonExtendedNegotiation)
+		p.associationCtx.ExtendedNegotiations = make(map[string][]byte)
+		p.associationCtx.RelationalQueries = make(map[string]bool)
+		p.associationCtx.MaxOperationsPerformed = 0
 	}
 
 	p.logger.Info("Extracted AE titles from association request",
 		"calling_ae", callingAE,
 		"called_ae", calledAE)
 
-	// Parse variable items starting from offset 68
-	offset := 68
+	// Parse variable items following the fixed fields
+	offset := p.variableItemsOffset(data)
 	var proposedContexts int
 	var acceptedContexts int
 
@@ -674,27 +1641,75 @@ func (p *Layer) parseAssociationRequest(pdu *PDU) error {
 		case 0x20: // Presentation Context
 			p.logger.Debug("Found presentation context item")
 			proposedContexts++
-			ctx, err := parsePresentationContext(itemData, p.logger)
+			if proposedContexts > p.maxPresentationContexts {
+				return fmt.Errorf("%w: more than %d presentation contexts proposed", errProposalLimitExceeded, p.maxPresentationContexts)
+			}
+			ctx, err := parsePresentationContext(itemData, p.maxTransferSyntaxes, p.supportsAbstractSyntax, p.selectTransferSyntax, p.logger)
+			if errors.Is(err, errProposalLimitExceeded) {
+				return err
+			}
 			if err != nil {
 				p.logger.Warn("Failed to parse presentation context", "error", err)
 			} else if p.associationCtx != nil {
 				p.associationCtx.PresentationCtxs[ctx.ID] = ctx
-				if ctx.Result == presentationResultAcceptance {
+				if ctx.Result == PresentationResultAcceptance {
 					acceptedContexts++
 				}
 			}
 		case 0x50: // User Information
 			p.logger.Debug("Found user information item")
-			if maxPDULength, err := parseUserInformation(itemData); err != nil {
+			userInfo, err := parseUserInformation(itemData)
+			if err != nil {
 				p.logger.Warn("Failed to parse user information", "error", err)
-			} else if maxPDULength > 0 && p.associationCtx != nil {
-				p.associationCtx.MaxPDULength = maxPDULength
+				break
+			}
+			if p.associationCtx == nil {
+				break
+			}
+			if userInfo.maxPDULength > 0 {
+				p.associationCtx.MaxPDULength = userInfo.maxPDULength
+			}
+			p.associationCtx.ImplementationClassUID = userInfo.implementationClassUID
+			p.associationCtx.ImplementationVersionName = userInfo.implementationVersionName
+			for _, neg := range userInfo.commonExtendedNegotiations {
+				p.logger.Debug("Found SOP class common extended negotiation",
+					"sop_class", neg.SOPClassUID,
+					"service_class", neg.ServiceClassUID,
+					"related_sop_classes", neg.RelatedGeneralSOPClasses)
+				p.associationCtx.CommonExtendedNegotiations[neg.SOPClassUID] = neg
+			}
+			if userInfo.asyncWindow {
+				p.associationCtx.MaxOperationsPerformed = p.negotiateOperationsWindow(userInfo.maxOperationsInvoked)
+				p.logger.Debug("Found asynchronous operations window",
+					"max_invoked", userInfo.maxOperationsInvoked,
+					"max_performed", userInfo.maxOperationsPerformed,
+					"agreed_performed", p.associationCtx.MaxOperationsPerformed)
+			}
+			for uid, appInfo := range userInfo.extendedNegotiations {
+				p.logger.Debug("Found SOP class extended negotiation",
+					"sop_class", uid,
+					"application_info", fmt.Sprintf("% x", appInfo))
+				p.associationCtx.ExtendedNegotiations[uid] = appInfo
+			}
+			for uid, roles := range userInfo.roleSelections {
+				p.logger.Debug("Found SCP/SCU role selection",
+					"sop_class", uid,
+					"scu_role", roles.SCU,
+					"scp_role", roles.SCP)
+				if p.associationCtx.RoleSelections == nil {
+					p.associationCtx.RoleSelections = make(map[string]RoleSelection)
+				}
+				p.associationCtx.RoleSelections[uid] = roles
 			}
 		}
 
 		offset = valueEnd
 	}
 
+	if p.associationCtx != nil {
+		p.applyWorkarounds()
+	}
+
 	if proposedContexts == 0 {
 		p.logger.Warn("No presentation contexts found in association request")
 	} else {
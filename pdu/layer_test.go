@@ -1,8 +1,18 @@
 package pdu
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"reflect"
+	"runtime"
+	"sync"
 	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
 )
 
 // MockConn is a mock implementation of net.Conn for testing
@@ -291,3 +301,752 @@ func TestMockConn_RemoteAddr(t *testing.T) {
 		t.Errorf("RemoteAddr().String() = %s, want 10.0.0.1:11112", addr.String())
 	}
 }
+
+// testPresentationContext describes a proposed presentation context for buildAssociateRQ
+type testPresentationContext struct {
+	ID               byte
+	AbstractSyntax   string
+	TransferSyntaxes []string
+}
+
+// appendItem appends a PDU item or sub-item (type, reserved, 2-byte length, value)
+func appendItem(buf []byte, itemType byte, value []byte) []byte {
+	buf = append(buf, itemType, 0x00)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
+
+// buildAssociateRQ builds an A-ASSOCIATE-RQ PDU with the given contexts and
+// user information sub-items (already encoded).
+func buildAssociateRQ(calledAE, callingAE string, contexts []testPresentationContext, userInfoSubItems []byte) *PDU {
+	data := make([]byte, 0, 512)
+	data = append(data, 0x00, 0x01, 0x00, 0x00) // Protocol version + reserved
+	data = append(data, []byte(fmt.Sprintf("%-16s", calledAE))...)
+	data = append(data, []byte(fmt.Sprintf("%-16s", callingAE))...)
+	data = append(data, make([]byte, 32)...)
+
+	data = appendItem(data, 0x10, []byte(types.ApplicationContextUID))
+
+	for _, ctx := range contexts {
+		pc := []byte{ctx.ID, 0x00, 0x00, 0x00}
+		pc = appendItem(pc, 0x30, []byte(ctx.AbstractSyntax))
+		for _, ts := range ctx.TransferSyntaxes {
+			pc = appendItem(pc, 0x40, []byte(ts))
+		}
+		data = appendItem(data, 0x20, pc)
+	}
+
+	if userInfoSubItems != nil {
+		data = appendItem(data, 0x50, userInfoSubItems)
+	}
+
+	return &PDU{Type: TypeAssociateRQ, Length: uint32(len(data)), Data: data}
+}
+
+// buildCommonExtendedNegotiation encodes a 0x57 sub-item value
+func buildCommonExtendedNegotiation(sopClass, serviceClass string, related []string) []byte {
+	var value []byte
+	value = binary.BigEndian.AppendUint16(value, uint16(len(sopClass)))
+	value = append(value, []byte(sopClass)...)
+	value = binary.BigEndian.AppendUint16(value, uint16(len(serviceClass)))
+	value = append(value, []byte(serviceClass)...)
+
+	var relatedList []byte
+	for _, uid := range related {
+		relatedList = binary.BigEndian.AppendUint16(relatedList, uint16(len(uid)))
+		relatedList = append(relatedList, []byte(uid)...)
+	}
+	value = binary.BigEndian.AppendUint16(value, uint16(len(relatedList)))
+	return append(value, relatedList...)
+}
+
+func newTestLayer(opts ...LayerOption) *Layer {
+	layer := NewLayer(&MockConn{}, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)), opts...)
+	layer.associationCtx = &AssociationContext{
+		MaxPDULength:     16384,
+		PresentationCtxs: make(map[byte]*PresentationContext),
+	}
+	return layer
+}
+
+func TestParseAssociationRequest_CommonExtendedNegotiation(t *testing.T) {
+	maxLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(maxLength, 32768)
+
+	var userInfo []byte
+	userInfo = appendItem(userInfo, 0x51, maxLength)
+	userInfo = appendItem(userInfo, 0x52, []byte(types.ImplementationClassUID))
+	userInfo = appendItem(userInfo, 0x57, buildCommonExtendedNegotiation(
+		types.CTImageStorage,
+		"1.2.840.10008.4.2", // Storage Service Class
+		[]string{types.EnhancedCTImageStorage, types.LegacyConvertedEnhancedCTImageStorage},
+	))
+	userInfo = appendItem(userInfo, 0x55, []byte(types.ImplementationVersionName))
+
+	pdu := buildAssociateRQ("TEST_SCP", "TEST_SCU", []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ExplicitVRLittleEndian}},
+	}, userInfo)
+
+	layer := newTestLayer()
+	if err := layer.parseAssociationRequest(pdu); err != nil {
+		t.Fatalf("parseAssociationRequest() error = %v", err)
+	}
+
+	ctx := layer.associationCtx
+	if ctx.MaxPDULength != 32768 {
+		t.Errorf("MaxPDULength = %d, want 32768", ctx.MaxPDULength)
+	}
+
+	if len(ctx.PresentationCtxs) != 2 {
+		t.Fatalf("Expected 2 presentation contexts, got %d", len(ctx.PresentationCtxs))
+	}
+
+	neg, ok := ctx.CommonExtendedNegotiations[types.CTImageStorage]
+	if !ok {
+		t.Fatal("Common extended negotiation for CT Image Storage not stored")
+	}
+	if neg.ServiceClassUID != "1.2.840.10008.4.2" {
+		t.Errorf("ServiceClassUID = %s, want 1.2.840.10008.4.2", neg.ServiceClassUID)
+	}
+	if len(neg.RelatedGeneralSOPClasses) != 2 ||
+		neg.RelatedGeneralSOPClasses[0] != types.EnhancedCTImageStorage ||
+		neg.RelatedGeneralSOPClasses[1] != types.LegacyConvertedEnhancedCTImageStorage {
+		t.Errorf("RelatedGeneralSOPClasses = %v", neg.RelatedGeneralSOPClasses)
+	}
+}
+
+func TestParseUserInformation_MalformedCommonExtendedNegotiation(t *testing.T) {
+	maxLength := make([]byte, 4)
+	binary.BigEndian.PutUint32(maxLength, 65536)
+
+	var userInfo []byte
+	userInfo = appendItem(userInfo, 0x57, []byte{0x00, 0x40, '1', '.', '2'}) // SOP class length overruns
+	userInfo = appendItem(userInfo, 0x51, maxLength)
+
+	info, err := parseUserInformation(userInfo)
+	if err != nil {
+		t.Fatalf("parseUserInformation() error = %v", err)
+	}
+	if info.maxPDULength != 65536 {
+		t.Errorf("maxPDULength = %d, want 65536", info.maxPDULength)
+	}
+	if len(info.commonExtendedNegotiations) != 0 {
+		t.Errorf("Expected malformed sub-item to be skipped, got %d", len(info.commonExtendedNegotiations))
+	}
+}
+
+// buildExtendedNegotiation encodes a 0x56 sub-item value
+func buildExtendedNegotiation(sopClass string, appInfo ...byte) []byte {
+	value := binary.BigEndian.AppendUint16(nil, uint16(len(sopClass)))
+	value = append(value, []byte(sopClass)...)
+	return append(value, appInfo...)
+}
+
+func TestRelationalQueriesNegotiation(t *testing.T) {
+	var userInfo []byte
+	userInfo = appendItem(userInfo, 0x51, []byte{0x00, 0x00, 0x40, 0x00})
+	userInfo = appendItem(userInfo, 0x56, buildExtendedNegotiation(types.StudyRootQueryRetrieveInformationModelFind, 0x01, 0x00, 0x00))
+
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelFind, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.PatientRootQueryRetrieveInformationModelFind, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+	}
+
+	tests := []struct {
+		name       string
+		opts       []LayerOption
+		relational bool
+	}{
+		{"Enabled", []LayerOption{WithRelationalQueries()}, true},
+		{"Disabled by default", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layer := newTestLayer(tt.opts...)
+			if err := layer.parseAssociationRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, userInfo)); err != nil {
+				t.Fatalf("parseAssociationRequest() error = %v", err)
+			}
+
+			if _, ok := layer.associationCtx.ExtendedNegotiations[types.StudyRootQueryRetrieveInformationModelFind]; !ok {
+				t.Fatal("Proposed extended negotiation not stored")
+			}
+
+			ac := layer.createAssociateAccept()
+			reply := appendItem(nil, 0x56, buildExtendedNegotiation(types.StudyRootQueryRetrieveInformationModelFind, 0x01))
+			if got := bytes.Contains(ac, reply); got != tt.relational {
+				t.Errorf("A-ASSOCIATE-AC contains relational reply = %v, want %v", got, tt.relational)
+			}
+
+			if got := layer.RelationalQueriesNegotiated(1); got != tt.relational {
+				t.Errorf("RelationalQueriesNegotiated(1) = %v, want %v", got, tt.relational)
+			}
+			// Not proposed for Patient Root
+			if layer.RelationalQueriesNegotiated(3) {
+				t.Error("RelationalQueriesNegotiated(3) = true for a context without extended negotiation")
+			}
+		})
+	}
+}
+
+func TestRelationalQueriesNegotiation_ReplyOrder(t *testing.T) {
+	sopClasses := []string{
+		types.StudyRootQueryRetrieveInformationModelFind,
+		types.PatientRootQueryRetrieveInformationModelFind,
+		types.StudyRootQueryRetrieveInformationModelMove,
+		types.PatientRootQueryRetrieveInformationModelMove,
+		types.StudyRootQueryRetrieveInformationModelGet,
+	}
+	var userInfo []byte
+	userInfo = appendItem(userInfo, 0x51, []byte{0x00, 0x00, 0x40, 0x00})
+	var contexts []testPresentationContext
+	for i, uid := range sopClasses {
+		userInfo = appendItem(userInfo, 0x56, buildExtendedNegotiation(uid, 0x01))
+		contexts = append(contexts, testPresentationContext{ID: byte(2*i + 1), AbstractSyntax: uid, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}})
+	}
+
+	// Map iteration order differs between runs, so try a few times
+	for range 10 {
+		layer := newTestLayer(WithRelationalQueries())
+		if err := layer.parseAssociationRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, userInfo)); err != nil {
+			t.Fatalf("parseAssociationRequest() error = %v", err)
+		}
+		ac := layer.createAssociateAccept()
+
+		last := -1
+		for _, uid := range sopClasses {
+			at := bytes.Index(ac, appendItem(nil, 0x56, buildExtendedNegotiation(uid, 0x01)))
+			if at <= last {
+				t.Fatalf("Reply for %s at offset %d, want after offset %d in context ID order", uid, at, last)
+			}
+			last = at
+		}
+	}
+}
+
+// wireConn records everything written to it; Write is safe for concurrent use
+// so that any interleaving comes from the layer, not the mock
+type wireConn struct {
+	MockConn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *wireConn) Write(b []byte) (int, error) {
+	runtime.Gosched() // Give other senders a chance to interleave
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(b)
+}
+
+func TestLayer_ConcurrentWrites(t *testing.T) {
+	const senders = 50
+
+	for _, tt := range []struct {
+		name string
+		opts []LayerOption
+	}{
+		{"Unbuffered", nil},
+		{"Buffered", []LayerOption{WithWriteBufferSize(64)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &wireConn{}
+			layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)), tt.opts...)
+
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < senders; i++ {
+				wg.Add(1)
+				go func(id byte) {
+					defer wg.Done()
+					<-start
+					command := bytes.Repeat([]byte{id}, 40)
+					dataset := bytes.Repeat([]byte{id}, 300)
+					if err := layer.SendDIMSEResponseWithDataset(1, command, dataset); err != nil {
+						t.Errorf("SendDIMSEResponseWithDataset(%d) error = %v", id, err)
+					}
+				}(byte(i))
+			}
+			close(start)
+			wg.Wait()
+
+			pdvs, err := readTestPDVs(conn.buf.Bytes())
+			if err != nil {
+				t.Fatalf("Malformed P-DATA-TF stream: %v", err)
+			}
+			if len(pdvs)%2 != 0 {
+				t.Fatalf("Decoded %d PDVs, want a command and dataset per message", len(pdvs))
+			}
+			seen := make(map[byte]bool)
+			for i := 0; i < len(pdvs); i += 2 {
+				command, dataset := pdvs[i], pdvs[i+1]
+
+				if command.control != 0x03 || dataset.control != 0x02 {
+					t.Fatalf("Control headers = 0x%02x, 0x%02x; want a command followed by its dataset", command.control, dataset.control)
+				}
+				id := command.data[0]
+				if !bytes.Equal(command.data, bytes.Repeat([]byte{id}, 40)) || !bytes.Equal(dataset.data, bytes.Repeat([]byte{id}, 300)) {
+					t.Fatalf("PDU contents from sender %d were interleaved with another sender", id)
+				}
+				seen[id] = true
+			}
+			if len(seen) != senders {
+				t.Errorf("Decoded messages from %d senders, want %d", len(seen), senders)
+			}
+		})
+	}
+}
+
+type testPDV struct {
+	control byte
+	data    []byte
+}
+
+func TestSendDIMSEResponseWithDataset_Coalesces(t *testing.T) {
+	command := bytes.Repeat([]byte{0x01}, 40)
+	dataset := bytes.Repeat([]byte{0x02}, 100)
+
+	for _, tt := range []struct {
+		name         string
+		maxPDULength uint32
+		wantPDUs     int
+	}{
+		{"Fits in one PDU", 16384, 1},
+		{"Exceeds max PDU length", 128, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &wireConn{}
+			layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)))
+			layer.associationCtx = &AssociationContext{MaxPDULength: tt.maxPDULength}
+
+			if err := layer.SendDIMSEResponseWithDataset(1, command, dataset); err != nil {
+				t.Fatalf("SendDIMSEResponseWithDataset() error = %v", err)
+			}
+
+			stream := conn.buf.Bytes()
+			pdus := 0
+			for offset := 0; offset+6 <= len(stream); offset += 6 + int(binary.BigEndian.Uint32(stream[offset+2:offset+6])) {
+				pdus++
+			}
+			if pdus != tt.wantPDUs {
+				t.Errorf("Wrote %d PDUs, want %d", pdus, tt.wantPDUs)
+			}
+
+			pdvs, err := readTestPDVs(stream)
+			if err != nil {
+				t.Fatalf("Malformed P-DATA-TF stream: %v", err)
+			}
+			if len(pdvs) != 2 || pdvs[0].control != 0x03 || pdvs[1].control != 0x02 {
+				t.Fatalf("PDVs = %+v, want a command PDV followed by a dataset PDV", pdvs)
+			}
+			if !bytes.Equal(pdvs[0].data, command) || !bytes.Equal(pdvs[1].data, dataset) {
+				t.Error("PDV payloads do not match the command and dataset sent")
+			}
+		})
+	}
+}
+
+// readTestPDVs decodes a stream of P-DATA-TF PDUs into their PDVs, in order
+func readTestPDVs(stream []byte) ([]testPDV, error) {
+	var pdvs []testPDV
+	for len(stream) > 0 {
+		if len(stream) < 6 {
+			return nil, fmt.Errorf("truncated PDU header")
+		}
+		if stream[0] != TypePDataTF {
+			return nil, fmt.Errorf("PDU type 0x%02x, want P-DATA-TF", stream[0])
+		}
+		pduLength := binary.BigEndian.Uint32(stream[2:6])
+		if int(pduLength)+6 > len(stream) {
+			return nil, fmt.Errorf("PDU length %d exceeds stream", pduLength)
+		}
+		payload := stream[6 : 6+pduLength]
+		stream = stream[6+pduLength:]
+
+		for len(payload) > 0 {
+			if len(payload) < 6 {
+				return nil, fmt.Errorf("truncated PDV header")
+			}
+			pdvLength := binary.BigEndian.Uint32(payload[0:4])
+			if pdvLength < 2 || int(pdvLength)+4 > len(payload) {
+				return nil, fmt.Errorf("PDV length %d does not fit PDU length %d", pdvLength, pduLength)
+			}
+			pdvs = append(pdvs, testPDV{control: payload[5], data: payload[6 : 4+pdvLength]})
+			payload = payload[4+pdvLength:]
+		}
+	}
+	return pdvs, nil
+}
+
+func TestAsyncOperationsWindowNegotiation(t *testing.T) {
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+	}
+
+	tests := []struct {
+		name          string
+		opts          []LayerOption
+		proposed      []byte // Max invoked, max performed; nil when not proposed
+		wantPerformed int
+	}{
+		{"Not configured", nil, []byte{0x00, 0x08, 0x00, 0x01}, 1},
+		{"Not proposed", []LayerOption{WithAsyncOperationsWindow(4)}, nil, 1},
+		{"Proposal above limit", []LayerOption{WithAsyncOperationsWindow(4)}, []byte{0x00, 0x08, 0x00, 0x01}, 4},
+		{"Proposal below limit", []LayerOption{WithAsyncOperationsWindow(4)}, []byte{0x00, 0x02, 0x00, 0x01}, 2},
+		{"Unlimited proposal", []LayerOption{WithAsyncOperationsWindow(4)}, []byte{0x00, 0x00, 0x00, 0x00}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userInfo []byte
+			userInfo = appendItem(userInfo, 0x51, []byte{0x00, 0x00, 0x40, 0x00})
+			if tt.proposed != nil {
+				userInfo = appendItem(userInfo, 0x53, tt.proposed)
+			}
+
+			layer := newTestLayer(tt.opts...)
+			if err := layer.parseAssociationRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, userInfo)); err != nil {
+				t.Fatalf("parseAssociationRequest() error = %v", err)
+			}
+
+			if got := layer.AsyncOperationsPerformed(); got != tt.wantPerformed {
+				t.Errorf("AsyncOperationsPerformed() = %d, want %d", got, tt.wantPerformed)
+			}
+
+			ac := layer.createAssociateAccept()
+			hasReply := bytes.Contains(ac, []byte{0x53, 0x00, 0x00, 0x04})
+			if hasReply != (tt.wantPerformed > 1) {
+				t.Errorf("A-ASSOCIATE-AC contains 0x53 reply = %v", hasReply)
+			}
+			if tt.wantPerformed > 1 {
+				reply := appendItem(nil, 0x53, []byte{0x00, 0x01, 0x00, byte(tt.wantPerformed)})
+				if !bytes.Contains(ac, reply) {
+					t.Errorf("A-ASSOCIATE-AC missing window reply % x", reply)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePDataTF_MultiplePDVs(t *testing.T) {
+	type pdv struct {
+		contextID byte
+		header    byte
+		data      string
+	}
+	var got []pdv
+	handler := &MockDIMSEHandler{
+		HandleDIMSEMessageFunc: func(presContextID byte, msgCtrlHeader byte, data []byte, pduLayer *Layer) error {
+			got = append(got, pdv{presContextID, msgCtrlHeader, string(data)})
+			return nil
+		},
+	}
+	layer := NewLayer(&MockConn{}, handler, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	want := []pdv{{1, 0x00, "first"}, {3, 0x03, "second"}, {1, 0x02, "third"}}
+	var data []byte
+	for _, p := range want {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(p.data)+2))
+		data = append(data, p.contextID, p.header)
+		data = append(data, p.data...)
+	}
+
+	if err := layer.handlePDataTF(&PDU{Type: TypePDataTF, Length: uint32(len(data)), Data: data}); err != nil {
+		t.Fatalf("handlePDataTF() error = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Handled %d PDVs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("PDV %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	truncated := append(append([]byte(nil), data...), 0x00, 0x00, 0x00, 0x10, 0x01)
+	if err := layer.handlePDataTF(&PDU{Type: TypePDataTF, Data: truncated}); err == nil {
+		t.Error("Expected error for truncated PDV")
+	}
+}
+
+func TestAcceptedContexts(t *testing.T) {
+	layer := newTestLayer()
+	if layer.AcceptedContexts() != nil {
+		t.Error("Expected no accepted contexts before negotiation")
+	}
+
+	pdu := buildAssociateRQ("TEST_SCP", "TEST_SCU", []testPresentationContext{
+		{ID: 5, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.JPEG2000, types.ExplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: "1.2.3.4.5.6", TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+	}, nil)
+	if err := layer.parseAssociationRequest(pdu); err != nil {
+		t.Fatalf("parseAssociationRequest() error = %v", err)
+	}
+
+	want := []PresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntax: types.ImplicitVRLittleEndian,
+			ProposedTransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 5, AbstractSyntax: types.CTImageStorage, TransferSyntax: types.ExplicitVRLittleEndian,
+			ProposedTransferSyntaxes: []string{types.JPEG2000, types.ExplicitVRLittleEndian}},
+	}
+	got := layer.AcceptedContexts()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("AcceptedContexts() = %+v, want %+v", got, want)
+	}
+
+	rejected := layer.associationCtx.RejectedContexts()
+	if len(rejected) != 1 || rejected[0].ID != 3 || rejected[0].Result != PresentationResultRejectAbstractSyntax {
+		t.Errorf("RejectedContexts() = %+v, want context 3 with result 0x03", rejected)
+	}
+
+	// The result is a copy
+	got[0].TransferSyntax = "modified"
+	if layer.associationCtx.PresentationCtxs[1].TransferSyntax != types.ImplicitVRLittleEndian {
+		t.Error("Modifying the returned slice changed the association")
+	}
+}
+
+func TestNoContextsPolicy(t *testing.T) {
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: "1.2.3.4.5.6", TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.JPEG2000}},
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		conn := &wireConn{}
+		layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+		if err := layer.handleAssociateRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, nil)); err == nil {
+			t.Error("handleAssociateRequest() error = nil, want the association refused")
+		}
+		want := []byte{TypeAssociateRJ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x01, 0x01, 0x01}
+		if got := conn.buf.Bytes(); !bytes.Equal(got, want) {
+			t.Errorf("Response = % x, want A-ASSOCIATE-RJ % x", got, want)
+		}
+	})
+
+	t.Run("AcceptWithResults", func(t *testing.T) {
+		conn := &wireConn{}
+		layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)),
+			WithNoContextsPolicy(NoContextsAcceptWithResults))
+
+		if err := layer.handleAssociateRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, nil)); err != nil {
+			t.Fatalf("handleAssociateRequest() error = %v", err)
+		}
+		written := conn.buf.Bytes()
+		if len(written) < 74 || written[0] != TypeAssociateAC {
+			t.Fatalf("Response = % x, want A-ASSOCIATE-AC", written)
+		}
+
+		// Collect the result of each Presentation Context Item (0x21)
+		results := make(map[byte]byte)
+		for offset := 74; offset+4 <= len(written); {
+			length := int(binary.BigEndian.Uint16(written[offset+2 : offset+4]))
+			if written[offset] == 0x21 {
+				results[written[offset+4]] = written[offset+6]
+			}
+			offset += 4 + length
+		}
+		want := map[byte]byte{
+			1: PresentationResultRejectAbstractSyntax,
+			3: PresentationResultRejectTransferSyntax,
+		}
+		if len(results) != len(want) || results[1] != want[1] || results[3] != want[3] {
+			t.Errorf("Presentation context results = %v, want %v", results, want)
+		}
+	})
+}
+
+func TestCalledAEPolicy(t *testing.T) {
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+	}
+
+	tests := []struct {
+		name     string
+		policy   CalledAEPolicy
+		calledAE string
+		accepted bool
+	}{
+		{"Ignore/other", CalledAEIgnore, "OTHER_SCP", true},
+		{"Strict/match", CalledAEStrict, "TEST_SCP", true},
+		{"Strict/other", CalledAEStrict, "OTHER_SCP", false},
+		{"Strict/empty", CalledAEStrict, "", false},
+		{"Any/empty", CalledAEAny, "", true},
+		{"Any/match", CalledAEAny, "TEST_SCP", true},
+		{"Any/other", CalledAEAny, "OTHER_SCP", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &wireConn{}
+			layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)),
+				WithCalledAEPolicy(tt.policy))
+
+			err := layer.handleAssociateRequest(buildAssociateRQ(tt.calledAE, "TEST_SCU", contexts, nil))
+			if (err == nil) != tt.accepted {
+				t.Errorf("handleAssociateRequest() error = %v, want accepted = %v", err, tt.accepted)
+			}
+
+			written := conn.buf.Bytes()
+			if len(written) == 0 {
+				t.Fatal("No response written")
+			}
+			if tt.accepted {
+				if written[0] != TypeAssociateAC {
+					t.Errorf("Response type = 0x%02x, want A-ASSOCIATE-AC", written[0])
+				}
+				return
+			}
+			want := []byte{TypeAssociateRJ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x01, 0x01, 0x07}
+			if !bytes.Equal(written, want) {
+				t.Errorf("Response = % x, want A-ASSOCIATE-RJ % x", written, want)
+			}
+		})
+	}
+}
+
+func TestAssociateRejectRoundTrip(t *testing.T) {
+	tests := []struct {
+		name                   string
+		result, source, reason byte
+	}{
+		{"no reason given", 0x01, 0x01, 0x01},
+		{"application context not supported", 0x01, 0x01, 0x02},
+		{"calling AE title not recognized", 0x01, 0x01, 0x03},
+		{"called AE title not recognized", 0x01, 0x01, 0x07},
+		{"protocol version not supported", 0x01, 0x02, 0x02},
+		{"temporary congestion", 0x02, 0x03, 0x01},
+		{"local limit exceeded", 0x02, 0x03, 0x02},
+	}
+	for _, tt := range tests {
+		data := BuildAssociateReject(tt.result, tt.source, tt.reason)
+		if len(data) != 10 || data[0] != TypeAssociateRJ {
+			t.Fatalf("%s: BuildAssociateReject() = % X", tt.name, data)
+		}
+		result, source, reason, err := ParseAssociateReject(data)
+		if err != nil {
+			t.Fatalf("%s: ParseAssociateReject() error = %v", tt.name, err)
+		}
+		if result != tt.result || source != tt.source || reason != tt.reason {
+			t.Errorf("%s: ParseAssociateReject() = %d/%d/%d, want %d/%d/%d",
+				tt.name, result, source, reason, tt.result, tt.source, tt.reason)
+		}
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		{TypeAssociateRJ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x01, 0x01},
+		{TypeAssociateAC, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x01, 0x01, 0x01},
+		{TypeAssociateRJ, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x01, 0x01, 0x01, 0x00},
+	} {
+		if _, _, _, err := ParseAssociateReject(data); err == nil {
+			t.Errorf("ParseAssociateReject(% X) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestParseAssociationRequest_MisalignedItems(t *testing.T) {
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ExplicitVRLittleEndian}},
+	}
+
+	tests := []struct {
+		name   string
+		adjust func(data []byte) []byte
+	}{
+		// Two stray bytes after the reserved field
+		{"extra padding", func(data []byte) []byte {
+			return append(append(append([]byte{}, data[:68]...), 0x00, 0x00), data[68:]...)
+		}},
+		// A reserved field two bytes short
+		{"short reserved field", func(data []byte) []byte {
+			return append(append([]byte{}, data[:66]...), data[68:]...)
+		}},
+	}
+
+	for _, tt := range tests {
+		pdu := buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, nil)
+		pdu.Data = tt.adjust(pdu.Data)
+		pdu.Length = uint32(len(pdu.Data))
+
+		layer := newTestLayer()
+		if err := layer.parseAssociationRequest(pdu); err != nil {
+			t.Fatalf("%s: parseAssociationRequest() error = %v", tt.name, err)
+		}
+		ctxs := layer.associationCtx.PresentationCtxs
+		if len(ctxs) != 2 || ctxs[1] == nil || ctxs[3] == nil || ctxs[3].AbstractSyntax != types.CTImageStorage {
+			t.Errorf("%s: presentation contexts = %v, want contexts 1 and 3", tt.name, ctxs)
+		}
+	}
+}
+
+func TestLayer_FindPresentationContext(t *testing.T) {
+	layer := newTestLayer()
+	layer.associationCtx.PresentationCtxs[1] = &PresentationContext{ID: 1, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelGet, TransferSyntax: types.ExplicitVRLittleEndian}
+	layer.associationCtx.PresentationCtxs[5] = &PresentationContext{ID: 5, AbstractSyntax: types.CTImageStorage, TransferSyntax: types.ExplicitVRLittleEndian}
+	layer.associationCtx.PresentationCtxs[3] = &PresentationContext{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntax: types.ImplicitVRLittleEndian}
+	layer.associationCtx.PresentationCtxs[7] = &PresentationContext{ID: 7, Result: PresentationResultRejectAbstractSyntax, AbstractSyntax: types.MRImageStorage}
+
+	id, ts, err := layer.FindPresentationContext(types.CTImageStorage)
+	if err != nil {
+		t.Fatalf("FindPresentationContext() error = %v", err)
+	}
+	if id != 3 || ts != types.ImplicitVRLittleEndian {
+		t.Errorf("FindPresentationContext() = %d, %s, want 3, %s", id, ts, types.ImplicitVRLittleEndian)
+	}
+	if _, _, err := layer.FindPresentationContext(types.MRImageStorage); err == nil {
+		t.Error("FindPresentationContext() for a rejected context succeeded, want error")
+	}
+}
+
+func TestHandleAssociateRequest_RoleSelection(t *testing.T) {
+	// SCU role 0, SCP role 1 for CT, as a C-GET SCU such as DCMTK getscu proposes
+	roleSelection := func(sopClass string, scu, scp byte) []byte {
+		value := binary.BigEndian.AppendUint16(nil, uint16(len(sopClass)))
+		value = append(value, sopClass...)
+		return append(value, scu, scp)
+	}
+	userInfo := appendItem(nil, 0x51, []byte{0x00, 0x00, 0x40, 0x00})
+	userInfo = appendItem(userInfo, 0x54, roleSelection(types.CTImageStorage, 0, 1))
+	userInfo = appendItem(userInfo, 0x54, roleSelection(types.MRImageStorage, 1, 0))
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.StudyRootQueryRetrieveInformationModelGet, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 5, AbstractSyntax: types.MRImageStorage, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+	}
+
+	conn := &wireConn{}
+	layer := NewLayer(conn, &MockDIMSEHandler{}, "TEST_SCP", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := layer.handleAssociateRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, userInfo)); err != nil {
+		t.Fatalf("handleAssociateRequest() error = %v", err)
+	}
+
+	want := map[string]RoleSelection{
+		types.CTImageStorage: {SCU: false, SCP: true},
+		types.MRImageStorage: {SCU: true, SCP: false},
+	}
+	if got := layer.AssociationContext().RoleSelections; !reflect.DeepEqual(got, want) {
+		t.Errorf("RoleSelections = %v, want %v", got, want)
+	}
+	if !layer.SCPRoleNegotiated(types.CTImageStorage) {
+		t.Error("SCPRoleNegotiated(CT) = false, want true")
+	}
+	if layer.SCPRoleNegotiated(types.MRImageStorage) || layer.SCPRoleNegotiated(types.UltrasoundImageStorage) {
+		t.Error("SCPRoleNegotiated() = true for a SOP class without the SCP role")
+	}
+
+	// The A-ASSOCIATE-AC accepts the roles as proposed
+	written := conn.buf.Bytes()
+	for _, reply := range [][]byte{
+		appendItem(nil, 0x54, roleSelection(types.CTImageStorage, 0, 1)),
+		appendItem(nil, 0x54, roleSelection(types.MRImageStorage, 1, 0)),
+	} {
+		if !bytes.Contains(written, reply) {
+			t.Errorf("A-ASSOCIATE-AC lacks role selection reply % x", reply)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package pdu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// RawPDULogConn hex-dumps every PDU read from or written to a connection,
+// in the style of tcpdump -x, for debugging peers at the byte level.
+//
+// PDU boundaries are found from the PDU headers in each direction's byte
+// stream, so the dump is the same however the PDUs are split across reads
+// and writes. Each PDU is written to the log with a single Write call.
+type RawPDULogConn struct {
+	net.Conn
+	received *pduDumper
+	sent     *pduDumper
+}
+
+// NewRawPDULogConn wraps conn so that up to maxBytes of each PDU, header
+// included, are dumped to w. A maxBytes of zero or less dumps whole PDUs.
+// The PDUs read and written are serialised on w; connections sharing a
+// writer should share one SyncWriter.
+func NewRawPDULogConn(conn net.Conn, w io.Writer, maxBytes int) *RawPDULogConn {
+	w = SyncWriter(w)
+	return &RawPDULogConn{
+		Conn:     conn,
+		received: &pduDumper{direction: "received", w: w, maxBytes: maxBytes},
+		sent:     &pduDumper{direction: "sent", w: w, maxBytes: maxBytes},
+	}
+}
+
+// Read reads from the connection and dumps the PDUs it completes
+func (c *RawPDULogConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.received.feed(b[:n])
+	}
+	return n, err
+}
+
+// Write writes to the connection and dumps the PDUs it completes
+func (c *RawPDULogConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.sent.feed(b[:n])
+	}
+	return n, err
+}
+
+// SyncWriter returns a writer serialising Writes to w, so that it may be
+// shared by connections dumping PDUs concurrently. A writer SyncWriter
+// returned is returned as is.
+func SyncWriter(w io.Writer) io.Writer {
+	if sw, ok := w.(*syncWriter); ok {
+		return sw
+	}
+	return &syncWriter{w: w}
+}
+
+// syncWriter serialises Writes to w
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
+
+// pduDumper follows the PDUs in one direction of a byte stream
+type pduDumper struct {
+	mu        sync.Mutex
+	direction string
+	w         io.Writer
+	maxBytes  int
+
+	header    [6]byte
+	headerLen int
+	remaining uint32 // Bytes of the current PDU's body still to come
+	captured  []byte // Leading bytes of the current PDU, up to maxBytes
+	total     int    // Bytes of the current PDU seen so far
+}
+
+func (d *pduDumper) feed(b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(b) > 0 {
+		if d.headerLen < len(d.header) {
+			n := copy(d.header[d.headerLen:], b)
+			d.capture(b[:n])
+			d.headerLen += n
+			b = b[n:]
+			if d.headerLen < len(d.header) {
+				return
+			}
+			d.remaining = binary.BigEndian.Uint32(d.header[2:6])
+		} else {
+			n := min(uint32(len(b)), d.remaining)
+			d.capture(b[:n])
+			d.remaining -= n
+			b = b[n:]
+		}
+
+		if d.remaining == 0 {
+			d.dump()
+		}
+	}
+}
+
+func (d *pduDumper) capture(b []byte) {
+	d.total += len(b)
+	if d.maxBytes > 0 {
+		b = b[:min(len(b), max(d.maxBytes-len(d.captured), 0))]
+	}
+	d.captured = append(d.captured, b...)
+}
+
+// dump writes the completed PDU and resets for the next one
+func (d *pduDumper) dump() {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s, %d bytes", d.direction, pduTypeName(d.header[0]), d.total)
+	if len(d.captured) < d.total {
+		fmt.Fprintf(&sb, " (first %d shown)", len(d.captured))
+	}
+	sb.WriteString("\n")
+	for offset := 0; offset < len(d.captured); offset += 16 {
+		fmt.Fprintf(&sb, "\t0x%04x: ", offset)
+		line := d.captured[offset:min(offset+16, len(d.captured))]
+		for i := 0; i < len(line); i += 2 {
+			fmt.Fprintf(&sb, " %x", line[i:min(i+2, len(line))])
+		}
+		sb.WriteString("\n")
+	}
+	_, _ = io.WriteString(d.w, sb.String())
+
+	d.headerLen = 0
+	d.remaining = 0
+	d.captured = d.captured[:0]
+	d.total = 0
+}
+
+// pduTypeName names a PDU type for logs
+func pduTypeName(pduType byte) string {
+	switch pduType {
+	case TypeAssociateRQ:
+		return "A-ASSOCIATE-RQ"
+	case TypeAssociateAC:
+		return "A-ASSOCIATE-AC"
+	case TypeAssociateRJ:
+		return "A-ASSOCIATE-RJ"
+	case TypePDataTF:
+		return "P-DATA-TF"
+	case TypeReleaseRQ:
+		return "A-RELEASE-RQ"
+	case TypeReleaseRP:
+		return "A-RELEASE-RP"
+	case TypeAbort:
+		return "A-ABORT"
+	}
+	return fmt.Sprintf("PDU type 0x%02x", pduType)
+}
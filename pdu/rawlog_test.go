@@ -0,0 +1,97 @@
+package pdu
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSyncWriter(t *testing.T) {
+	var log bytes.Buffer
+	w := SyncWriter(&log)
+	if SyncWriter(w) != w {
+		t.Error("SyncWriter() wrapped a writer it returned")
+	}
+	// Dumps of several connections do not interleave
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := NewRawPDULogConn(nil, w, 0)
+			for j := 0; j < 100; j++ {
+				conn.sent.feed([]byte{TypeReleaseRQ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00})
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := strings.Repeat("sent A-RELEASE-RQ, 10 bytes\n\t0x0000:  0500 0000 0004 0000 0000\n", 800)
+	if log.String() != want {
+		t.Error("Dumps of concurrent connections interleaved")
+	}
+}
+
+func TestRawPDULogConn(t *testing.T) {
+	releaseRQ := []byte{TypeReleaseRQ, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+	releaseRP := []byte{TypeReleaseRP, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+
+	client, peer := net.Pipe()
+	defer client.Close()
+	defer peer.Close()
+
+	var log bytes.Buffer
+	conn := NewRawPDULogConn(client, &log, 0)
+
+	go func() {
+		request := make([]byte, len(releaseRQ))
+		if _, err := io.ReadFull(peer, request); err == nil {
+			peer.Write(releaseRP)
+		}
+	}()
+
+	// Split inside the header, as unbuffered writers do
+	if _, err := conn.Write(releaseRQ[:4]); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if log.Len() != 0 {
+		t.Fatalf("Dumped an incomplete PDU: %q", log.String())
+	}
+	if _, err := conn.Write(releaseRQ[4:]); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(releaseRP))); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+
+	want := "sent A-RELEASE-RQ, 10 bytes\n" +
+		"\t0x0000:  0500 0000 0004 0000 0000\n" +
+		"received A-RELEASE-RP, 10 bytes\n" +
+		"\t0x0000:  0600 0000 0004 0000 0000\n"
+	if got := log.String(); got != want {
+		t.Errorf("Dump =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRawPDULogConn_MaxBytes(t *testing.T) {
+	data := make([]byte, 40)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	pdataTF := append([]byte{TypePDataTF, 0x00, 0x00, 0x00, 0x00, byte(len(data))}, data...)
+
+	var log bytes.Buffer
+	dumper := &pduDumper{direction: "sent", w: &log, maxBytes: 20}
+	// Two PDUs in one write, as a buffered writer flushes them
+	dumper.feed(append(append([]byte{}, pdataTF...), pdataTF...))
+
+	dump := "sent P-DATA-TF, 46 bytes (first 20 shown)\n" +
+		"\t0x0000:  0400 0000 0028 0001 0203 0405 0607 0809\n" +
+		"\t0x0010:  0a0b 0c0d\n"
+	if got := log.String(); got != dump+dump {
+		t.Errorf("Dump =\n%s\nwant the truncated PDU twice:\n%s", got, dump)
+	}
+}
@@ -0,0 +1,82 @@
+package pdu
+
+import "strings"
+
+// Workarounds toggles non-standard behaviours for peers that need them
+type Workarounds struct {
+	// SkipRejectedContexts leaves rejected presentation contexts out of the
+	// A-ASSOCIATE-AC. PS3.8 9.3.3.3 requires every proposed context to be
+	// answered, but some implementations refuse an A-ASSOCIATE-AC listing
+	// rejected ones.
+	SkipRejectedContexts bool
+}
+
+// legacyWorkarounds are applied to every peer when automatic workarounds
+// are disabled, as before peers were told apart
+var legacyWorkarounds = Workarounds{SkipRejectedContexts: true}
+
+// knownPeer identifies an implementation by its Implementation Class UID
+// prefix or a substring of its Implementation Version Name
+type knownPeer struct {
+	name           string
+	classUIDPrefix string
+	versionName    string // Matched case-insensitively
+	workarounds    Workarounds
+}
+
+// knownPeers lists the implementations told apart during negotiation and the
+// workarounds each needs:
+//
+//   - DCMTK (and tools built on it): SkipRejectedContexts
+//   - Orthanc: SkipRejectedContexts
+//   - dcm4che: none
+var knownPeers = []knownPeer{
+	{name: "Orthanc", versionName: "ORTHANC", workarounds: Workarounds{SkipRejectedContexts: true}},
+	{name: "DCMTK", classUIDPrefix: "1.2.276.0.7230010.3", versionName: "OFFIS_DCMTK", workarounds: Workarounds{SkipRejectedContexts: true}},
+	{name: "dcm4che", classUIDPrefix: "1.2.40.0.13.1", versionName: "DCM4CHE"},
+}
+
+// IdentifyPeer returns the name of the known implementation matching an
+// A-ASSOCIATE-RQ's Implementation Class UID and Version Name, and the
+// workarounds it needs. Unknown peers get an empty name and no workarounds.
+func IdentifyPeer(classUID, versionName string) (string, Workarounds) {
+	versionName = strings.ToUpper(versionName)
+	for _, peer := range knownPeers {
+		if peer.versionName != "" && strings.Contains(versionName, peer.versionName) {
+			return peer.name, peer.workarounds
+		}
+	}
+	for _, peer := range knownPeers {
+		if peer.classUIDPrefix != "" && strings.HasPrefix(classUID, peer.classUIDPrefix) {
+			return peer.name, peer.workarounds
+		}
+	}
+	return "", Workarounds{}
+}
+
+// WithAutoWorkarounds enables or disables per-peer workarounds (default
+// enabled). When disabled, every peer gets the workarounds needed by the
+// strictest known peers, i.e. rejected contexts are never listed in the
+// A-ASSOCIATE-AC.
+func WithAutoWorkarounds(enabled bool) LayerOption {
+	return func(p *Layer) {
+		p.disableAutoWorkarounds = !enabled
+	}
+}
+
+// applyWorkarounds records the peer's identity and workarounds in the
+// association context
+func (p *Layer) applyWorkarounds() {
+	ctx := p.associationCtx
+	ctx.PeerImplementation, ctx.Workarounds = IdentifyPeer(ctx.ImplementationClassUID, ctx.ImplementationVersionName)
+	if p.disableAutoWorkarounds {
+		ctx.Workarounds = legacyWorkarounds
+		return
+	}
+	if ctx.PeerImplementation != "" {
+		p.logger.Debug("Applying workarounds for known peer",
+			"peer", ctx.PeerImplementation,
+			"implementation_version", ctx.ImplementationVersionName,
+			"skip_rejected_contexts", ctx.Workarounds.SkipRejectedContexts)
+	}
+}
@@ -0,0 +1,67 @@
+package pdu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestIdentifyPeer(t *testing.T) {
+	tests := []struct {
+		classUID, versionName string
+		want                  string
+	}{
+		{"1.2.276.0.7230010.3.0.3.6.7", "OFFIS_DCMTK_367", "DCMTK"},
+		{"1.2.276.0.7230010.3.0.3.6.4", "", "DCMTK"},
+		{"1.2.276.0.7230010.3.0.3.6.4", "Orthanc 1.12", "Orthanc"},
+		{"1.2.40.0.13.1.3", "dcm4che-5.31.0", "dcm4che"},
+		{types.ImplementationClassUID, types.ImplementationVersionName, ""},
+	}
+	for _, tt := range tests {
+		if got, _ := IdentifyPeer(tt.classUID, tt.versionName); got != tt.want {
+			t.Errorf("IdentifyPeer(%q, %q) = %q, want %q", tt.classUID, tt.versionName, got, tt.want)
+		}
+	}
+}
+
+func TestAutoWorkarounds_RejectedContexts(t *testing.T) {
+	contexts := []testPresentationContext{
+		{ID: 1, AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+		{ID: 3, AbstractSyntax: types.CTImageStorage, TransferSyntaxes: []string{types.ImplicitVRLittleEndian}},
+	}
+	// Context 3 rejected with "abstract syntax not supported", without sub-items
+	rejected := []byte{0x21, 0x00, 0x00, 0x04, 0x03, 0x00, PresentationResultRejectAbstractSyntax, 0x00}
+
+	tests := []struct {
+		name                  string
+		classUID, versionName string
+		opts                  []LayerOption
+		wantRejected          bool
+	}{
+		{"DCMTK", "1.2.276.0.7230010.3.0.3.6.7", "OFFIS_DCMTK_367", nil, false},
+		{"Unknown peer", types.ImplementationClassUID, types.ImplementationVersionName, nil, true},
+		{"Unknown peer without auto workarounds", types.ImplementationClassUID, types.ImplementationVersionName, []LayerOption{WithAutoWorkarounds(false)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userInfo []byte
+			userInfo = appendItem(userInfo, 0x51, []byte{0x00, 0x00, 0x40, 0x00})
+			userInfo = appendItem(userInfo, 0x52, []byte(tt.classUID))
+			userInfo = appendItem(userInfo, 0x55, []byte(tt.versionName))
+
+			layer := newTestLayer(append(tt.opts, WithSupportedAbstractSyntaxes(types.VerificationSOPClass))...)
+			if err := layer.parseAssociationRequest(buildAssociateRQ("TEST_SCP", "TEST_SCU", contexts, userInfo)); err != nil {
+				t.Fatalf("parseAssociationRequest() error = %v", err)
+			}
+			if got := layer.associationCtx.ImplementationVersionName; got != tt.versionName {
+				t.Errorf("ImplementationVersionName = %q, want %q", got, tt.versionName)
+			}
+
+			ac := layer.createAssociateAccept()
+			if got := bytes.Contains(ac, rejected); got != tt.wantRejected {
+				t.Errorf("A-ASSOCIATE-AC lists the rejected context = %v, want %v", got, tt.wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// ConformanceSummary describes what the SCP negotiates and services, as a
+// starting point for a DICOM Conformance Statement.
+type ConformanceSummary struct {
+	AETitle          string
+	SOPClasses       []SOPClassConformance
+	TransferSyntaxes []string
+	MaxPDULength     uint32
+}
+
+// SOPClassConformance describes a single supported SOP class.
+type SOPClassConformance struct {
+	UID     string
+	Name    string
+	Role    string // Always "SCP"; SCP/SCU role selection is not negotiated
+	Command string // DIMSE service handling the SOP class (e.g. "C-FIND")
+}
+
+// commandLister is implemented by handlers that can report which DIMSE
+// commands they service, such as services.Registry.
+type commandLister interface {
+	RegisteredCommands() []uint16
+}
+
+// queryRetrieveCommands maps query/retrieve information models to the DIMSE
+// request that services them.
+var queryRetrieveCommands = map[string]uint16{
+	types.PatientRootQueryRetrieveInformationModelFind:      dimse.CFindRQ,
+	types.StudyRootQueryRetrieveInformationModelFind:        dimse.CFindRQ,
+	types.PatientStudyOnlyQueryRetrieveInformationModelFind: dimse.CFindRQ,
+	types.ModalityWorklistInformationModelFind:              dimse.CFindRQ,
+	types.PatientRootQueryRetrieveInformationModelMove:      dimse.CMoveRQ,
+	types.StudyRootQueryRetrieveInformationModelMove:        dimse.CMoveRQ,
+	types.PatientStudyOnlyQueryRetrieveInformationModelMove: dimse.CMoveRQ,
+	types.PatientRootQueryRetrieveInformationModelGet:       dimse.CGetRQ,
+	types.StudyRootQueryRetrieveInformationModelGet:         dimse.CGetRQ,
+	types.PatientStudyOnlyQueryRetrieveInformationModelGet:  dimse.CGetRQ,
+}
+
+var commandNames = map[uint16]string{
+	dimse.CEchoRQ:  "C-ECHO",
+	dimse.CStoreRQ: "C-STORE",
+	dimse.CFindRQ:  "C-FIND",
+	dimse.CMoveRQ:  "C-MOVE",
+	dimse.CGetRQ:   "C-GET",
+}
+
+// ConformanceSummary reports the SOP classes, transfer syntaxes and limits the
+// server is configured with. SOP classes come from the supported abstract
+// syntax set; when the handler reports its registered commands (as
+// services.Registry does), classes whose service has no handler are omitted.
+func (s *Server) ConformanceSummary() *ConformanceSummary {
+	candidates := s.SupportedAbstractSyntaxes
+	if len(candidates) == 0 {
+		candidates = append(pdu.DefaultAbstractSyntaxes(), types.StorageSOPClasses()...)
+		if s.UnifiedProcedureStep {
+			candidates = append(candidates, types.UPSSOPClasses()...)
+		}
+	}
+
+	var registered map[uint16]bool
+	if lister, ok := s.Handler.(commandLister); ok {
+		registered = make(map[uint16]bool)
+		for _, cmd := range lister.RegisteredCommands() {
+			registered[cmd] = true
+		}
+	}
+
+	summary := &ConformanceSummary{
+		AETitle:          s.AETitle,
+		TransferSyntaxes: pdu.SupportedTransferSyntaxes(),
+		MaxPDULength:     pdu.DefaultMaxPDULength,
+	}
+
+	for _, uid := range candidates {
+		cmd, known := commandForSOPClass(uid)
+		if registered != nil && (!known || !registered[cmd]) {
+			continue
+		}
+
+		summary.SOPClasses = append(summary.SOPClasses, SOPClassConformance{
+			UID:     uid,
+			Name:    types.GetSOPClassInfo(uid).Name,
+			Role:    "SCP",
+			Command: commandNames[cmd],
+		})
+	}
+
+	return summary
+}
+
+// commandForSOPClass returns the DIMSE request used with a SOP class
+func commandForSOPClass(uid string) (uint16, bool) {
+	if uid == types.VerificationSOPClass {
+		return dimse.CEchoRQ, true
+	}
+	if types.IsStorageSOPClass(uid) {
+		return dimse.CStoreRQ, true
+	}
+	cmd, ok := queryRetrieveCommands[uid]
+	return cmd, ok
+}
+
+// HasSOPClass reports whether the summary lists the given SOP class UID.
+func (c *ConformanceSummary) HasSOPClass(uid string) bool {
+	for _, sc := range c.SOPClasses {
+		if sc.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the summary as human-readable text.
+func (c *ConformanceSummary) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "AE Title: %s\n", c.AETitle)
+	fmt.Fprintf(&b, "Maximum PDU Length: %d\n", c.MaxPDULength)
+
+	b.WriteString("SOP Classes:\n")
+	for _, sc := range c.SOPClasses {
+		command := sc.Command
+		if command == "" {
+			command = "-"
+		}
+		fmt.Fprintf(&b, "  %-8s %-3s %s (%s)\n", command, sc.Role, sc.Name, sc.UID)
+	}
+
+	b.WriteString("Transfer Syntaxes:\n")
+	for _, ts := range c.TransferSyntaxes {
+		fmt.Fprintf(&b, "  %s (%s)\n", types.GetTransferSyntaxInfo(ts).Name, ts)
+	}
+
+	return b.String()
+}
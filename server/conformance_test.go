@@ -0,0 +1,67 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/services"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestServer_ConformanceSummary(t *testing.T) {
+	registry := services.NewRegistry()
+	registry.RegisterHandler(dimse.CEchoRQ, services.NewEchoService())
+	registry.RegisterHandler(dimse.CStoreRQ, services.NewEchoService())
+	registry.RegisterHandler(dimse.CFindRQ, services.NewEchoService())
+
+	srv := New("TEST_SCP", registry)
+	summary := srv.ConformanceSummary()
+
+	for _, uid := range []string{
+		types.VerificationSOPClass,
+		types.CTImageStorage,
+		types.MRImageStorage,
+		types.StudyRootQueryRetrieveInformationModelFind,
+		types.PatientRootQueryRetrieveInformationModelFind,
+	} {
+		if !summary.HasSOPClass(uid) {
+			t.Errorf("Summary missing SOP class %s", uid)
+		}
+	}
+
+	// No C-MOVE or C-GET handler is registered
+	for _, uid := range []string{
+		types.StudyRootQueryRetrieveInformationModelMove,
+		types.StudyRootQueryRetrieveInformationModelGet,
+	} {
+		if summary.HasSOPClass(uid) {
+			t.Errorf("Summary should not list %s without a handler", uid)
+		}
+	}
+
+	if summary.MaxPDULength == 0 {
+		t.Error("Expected a max PDU length")
+	}
+	if len(summary.TransferSyntaxes) == 0 {
+		t.Error("Expected supported transfer syntaxes")
+	}
+
+	text := summary.String()
+	for _, want := range []string{"TEST_SCP", "C-ECHO", "C-STORE", "CT Image Storage", "Study Root Query/Retrieve - FIND", types.ImplicitVRLittleEndian} {
+		if !strings.Contains(text, want) {
+			t.Errorf("String() missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestServer_ConformanceSummary_RestrictedSyntaxes(t *testing.T) {
+	summary := NewEchoServer("ECHO_SCP").ConformanceSummary()
+
+	if len(summary.SOPClasses) != 1 || summary.SOPClasses[0].UID != types.VerificationSOPClass {
+		t.Fatalf("Expected only Verification, got %+v", summary.SOPClasses)
+	}
+	if summary.SOPClasses[0].Command != "C-ECHO" {
+		t.Errorf("Command = %q, want C-ECHO", summary.SOPClasses[0].Command)
+	}
+}
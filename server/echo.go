@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/services"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// NewEchoServer builds a minimal C-ECHO SCP for connectivity probes.
+//
+// Only the Verification SOP class is accepted during association negotiation;
+// every other proposed presentation context is rejected. Additional options
+// are applied after the echo defaults, so callers can still set timeouts or a
+// logger.
+func NewEchoServer(aeTitle string, opts ...Option) *Server {
+	registry := services.NewRegistry()
+	registry.RegisterHandler(dimse.CEchoRQ, services.NewEchoService())
+
+	defaults := []Option{WithSupportedAbstractSyntaxes(types.VerificationSOPClass)}
+	return New(aeTitle, registry, append(defaults, opts...)...)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caio-sobreiro/dicomnet/client"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// startTestServer serves srv on a loopback listener until the test ends and
+// returns the listener address.
+func startTestServer(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx, listener)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("Server did not shut down")
+		}
+	})
+
+	return listener.Addr().String()
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestNewEchoServer(t *testing.T) {
+	srv := NewEchoServer("ECHO_SCP", WithLogger(discardLogger()))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.VerificationSOPClass, types.CTImageStorage},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	if _, err := assoc.GetPresentationContextID(types.CTImageStorage); err == nil {
+		t.Error("Expected CT Image Storage context to be rejected")
+	}
+
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess {
+		t.Errorf("Status = 0x%04X, want success", resp.Status)
+	}
+}
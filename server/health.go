@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/caio-sobreiro/dicomnet/client"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// healthCheckAETitle is the Calling AE Title of health check associations
+const healthCheckAETitle = "HEALTHCHECK"
+
+// HealthCheck verifies that the server listening on address negotiates an
+// association and answers a C-ECHO with Success, for liveness and readiness
+// probes:
+//
+//	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+//		if err := server.HealthCheck(r.Context(), "127.0.0.1:11112"); err != nil {
+//			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+//		}
+//	})
+//
+// ctx bounds the whole check. The association leaves the Called AE Title
+// blank, so servers with CalledAEStrict refuse it.
+func HealthCheck(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("health check: %w", err)
+	}
+	// Closing the connection unblocks negotiation or the C-ECHO when ctx
+	// ends first
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	assoc, err := client.NewAssociation(conn, client.Config{
+		CallingAETitle: healthCheckAETitle,
+		SOPClasses:     []string{types.VerificationSOPClass},
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		return fmt.Errorf("health check: association failed: %w", contextError(ctx, err))
+	}
+
+	response, err := assoc.SendCEcho(1)
+	if err != nil {
+		assoc.Abort()
+		return fmt.Errorf("health check: C-ECHO failed: %w", contextError(ctx, err))
+	}
+	if response.Status != dimse.StatusSuccess {
+		assoc.Abort()
+		return fmt.Errorf("health check: C-ECHO status 0x%04x (%s)",
+			response.Status, dimse.StatusText(dimse.CEchoRSP, response.Status))
+	}
+
+	if err := assoc.Close(); err != nil {
+		return fmt.Errorf("health check: release failed: %w", contextError(ctx, err))
+	}
+	return nil
+}
+
+// contextError reports ctx's error instead of err when ctx ended, since err
+// then only describes the connection closed under the check
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	addr := startTestServer(t, NewEchoServer("ECHO_SCP", WithLogger(discardLogger())))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := HealthCheck(ctx, addr); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+}
+
+func TestHealthCheck_ClosedPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := HealthCheck(ctx, addr); err == nil {
+		t.Error("HealthCheck() against a closed port succeeded")
+	}
+}
+
+func TestHealthCheck_UnresponsivePeer(t *testing.T) {
+	// Accepts connections but never answers the A-ASSOCIATE-RQ
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := HealthCheck(ctx, listener.Addr().String()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("HealthCheck() error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -3,14 +3,20 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
 	"github.com/caio-sobreiro/dicomnet/interfaces"
 	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/types"
 )
 
 // Option configures a Server instance.
@@ -23,27 +29,370 @@ func WithLogger(logger *slog.Logger) Option {
 	}
 }
 
-// WithReadTimeout sets the read timeout for client connections.
+// WithReadTimeout sets how long the server waits for each PDU from a client.
 func WithReadTimeout(timeout time.Duration) Option {
 	return func(s *Server) {
 		s.ReadTimeout = timeout
 	}
 }
 
-// WithWriteTimeout sets the write timeout for client connections.
+// WithWriteTimeout sets how long writing each PDU to a client may take.
 func WithWriteTimeout(timeout time.Duration) Option {
 	return func(s *Server) {
 		s.WriteTimeout = timeout
 	}
 }
 
+// WithAssociationTimeout caps the lifetime of every association, counted from
+// accept. When it expires the server sends an A-ABORT and closes the
+// connection regardless of activity, e.g. to shed slow or stuck peers.
+func WithAssociationTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.AssociationTimeout = timeout
+	}
+}
+
+// WithHandlerTimeout bounds how long the handler may take to complete each
+// operation. Its context is cancelled at the deadline and the operation is
+// answered with a failure status; a handler that has not returned shortly
+// after gets the association aborted.
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.HandlerTimeout = timeout
+	}
+}
+
+// WithKeepAlive sets the TCP keep-alive period for accepted connections.
+// A negative duration disables keep-alive probes.
+func WithKeepAlive(period time.Duration) Option {
+	return func(s *Server) {
+		s.KeepAlive = period
+	}
+}
+
+// WithNoDelay toggles TCP_NODELAY on accepted connections (enabled by default).
+func WithNoDelay(enabled bool) Option {
+	return func(s *Server) {
+		s.DisableNoDelay = !enabled
+	}
+}
+
+// WithRelationalQueries agrees to relational queries and retrieval when an
+// SCU proposes them through SOP Class Extended Negotiation. The handler must
+// then accept identifiers without a Query/Retrieve Level; see
+// interfaces.MessageContext.RelationalQueries.
+func WithRelationalQueries() Option {
+	return func(s *Server) {
+		s.RelationalQueries = true
+	}
+}
+
+// WithUnifiedProcedureStep accepts associations proposing the Unified
+// Procedure Step SOP classes (types.UPSSOPClasses) alongside the default
+// set. The handler must serve the UPS N-service commands itself.
+func WithUnifiedProcedureStep() Option {
+	return func(s *Server) {
+		s.UnifiedProcedureStep = true
+	}
+}
+
+// WithAsyncOperationsWindow lets a requestor that proposes an Asynchronous
+// Operations Window keep up to n operations outstanding. Handlers for
+// messages on different presentation contexts may then run concurrently.
+func WithAsyncOperationsWindow(n int) Option {
+	return func(s *Server) {
+		s.AsyncOperationsWindow = n
+	}
+}
+
+// WithMaxPresentationContexts caps the presentation contexts an association
+// request may propose, rejecting larger requests with an A-ASSOCIATE-RJ
+// (default: pdu.DefaultMaxPresentationContexts).
+func WithMaxPresentationContexts(n int) Option {
+	return func(s *Server) {
+		s.MaxPresentationContexts = n
+	}
+}
+
+// WithMaxTransferSyntaxes caps the transfer syntaxes one proposed
+// presentation context may list, rejecting larger requests with an
+// A-ASSOCIATE-RJ (default: pdu.DefaultMaxTransferSyntaxes).
+func WithMaxTransferSyntaxes(n int) Option {
+	return func(s *Server) {
+		s.MaxTransferSyntaxes = n
+	}
+}
+
+// WithAutoWorkarounds enables or disables per-peer workarounds (default
+// enabled). Peers are identified by their Implementation Class UID and
+// Version Name; DCMTK and Orthanc get A-ASSOCIATE-ACs without rejected
+// presentation contexts, while other peers get every context answered as
+// PS3.8 requires. When disabled, rejected contexts are left out for all peers.
+func WithAutoWorkarounds(enabled bool) Option {
+	return func(s *Server) {
+		s.DisableAutoWorkarounds = !enabled
+	}
+}
+
+// CalledAEPolicy controls how the Called AE Title of incoming associations is
+// checked against the server's AE title.
+type CalledAEPolicy = pdu.CalledAEPolicy
+
+// Called AE Title policies
+const (
+	CalledAEIgnore = pdu.CalledAEIgnore // Accept any Called AE Title (default)
+	CalledAEStrict = pdu.CalledAEStrict // Reject Called AE Titles other than the server's
+	CalledAEAny    = pdu.CalledAEAny    // Strict, but accept an empty or blank Called AE Title
+)
+
+// WithCalledAEPolicy sets how the Called AE Title of incoming associations is
+// checked. Rejected associations receive an A-ASSOCIATE-RJ.
+func WithCalledAEPolicy(policy CalledAEPolicy) Option {
+	return func(s *Server) {
+		s.CalledAEPolicy = policy
+	}
+}
+
+// NoContextsPolicy controls how associations without any acceptable
+// presentation context are answered.
+type NoContextsPolicy = pdu.NoContextsPolicy
+
+// No-contexts policies
+const (
+	NoContextsReject            = pdu.NoContextsReject            // Send A-ASSOCIATE-RJ (default)
+	NoContextsAcceptWithResults = pdu.NoContextsAcceptWithResults // Send A-ASSOCIATE-AC listing each rejected context
+)
+
+// WithNoContextsPolicy sets how associations are answered when none of their
+// presentation contexts can be accepted.
+func WithNoContextsPolicy(policy NoContextsPolicy) Option {
+	return func(s *Server) {
+		s.NoContextsPolicy = policy
+	}
+}
+
+// WithReadBufferSize buffers socket reads with a buffer of n bytes, reducing
+// syscalls when receiving large datasets.
+func WithReadBufferSize(n int) Option {
+	return func(s *Server) {
+		s.ReadBufferSize = n
+	}
+}
+
+// WithWriteBufferSize buffers socket writes with a buffer of n bytes. The
+// buffer is flushed at every PDU boundary.
+func WithWriteBufferSize(n int) Option {
+	return func(s *Server) {
+		s.WriteBufferSize = n
+	}
+}
+
+// WithAETable configures the remote AE titles the server knows, mapped to
+// their host:port. C-MOVE destinations are resolved against it and requests
+// naming any other AE title fail with status 0xA801.
+func WithAETable(table map[string]string) Option {
+	return func(s *Server) {
+		s.AETable = table
+	}
+}
+
+// WithSupportedAbstractSyntaxes restricts the SOP classes the server accepts
+// during association negotiation. Proposed contexts for any other abstract
+// syntax are rejected. By default the server accepts Verification, the
+// Query/Retrieve models and all storage SOP classes.
+func WithSupportedAbstractSyntaxes(uids ...string) Option {
+	return func(s *Server) {
+		s.SupportedAbstractSyntaxes = uids
+	}
+}
+
+// WithTransferSyntaxPreferenceFor sets the transfer syntaxes the server
+// prefers for one abstract syntax, most preferred first, e.g. a compressed
+// syntax for large images and Explicit VR Little Endian for structured
+// reports. Negotiation selects the first in order that the SCU proposed,
+// falling back to the default selection when it proposed none of them.
+// Syntaxes the dicom codec cannot encode are only accepted for storage SOP
+// classes, whose datasets are passed through; the server warns about
+// others at startup.
+func WithTransferSyntaxPreferenceFor(abstractSyntax string, order []string) Option {
+	return func(s *Server) {
+		if s.TransferSyntaxPreferences == nil {
+			s.TransferSyntaxPreferences = make(map[string][]string)
+		}
+		s.TransferSyntaxPreferences[abstractSyntax] = order
+	}
+}
+
+// WithMaxFindMatches caps the matches a streaming handler may return for one
+// C-FIND, protecting the server from unbounded queries. Matches beyond n are
+// dropped (SendResponse returns dimse.ErrMaxFindMatches) and the query ends
+// with Success (0x0000), as C-FIND has no status for truncated results; the
+// truncation is logged.
+func WithMaxFindMatches(n int) Option {
+	return func(s *Server) {
+		s.MaxFindMatches = n
+	}
+}
+
+// WithInitialMessageID sets the first message ID each association uses for
+// requests the server originates, such as C-GET C-STORE sub-operations.
+// Defaults to 1.
+func WithInitialMessageID(id uint16) Option {
+	return func(s *Server) {
+		s.InitialMessageID = id
+	}
+}
+
+// WithDefaultResponseTransferSyntax sets the transfer syntax response
+// datasets are encoded in when no transfer syntax is known for the
+// presentation context, for legacy SCUs that expect Implicit VR Little
+// Endian. It must be one dicom.CanEncodeTransferSyntax accepts.
+func WithDefaultResponseTransferSyntax(uid string) Option {
+	return func(s *Server) {
+		s.DefaultResponseTransferSyntax = uid
+	}
+}
+
+// WithRawPDULog hex-dumps up to maxBytes of every PDU each association reads
+// or writes to w, for debugging interoperability at the byte level. A
+// maxBytes of zero or less dumps whole PDUs. w is shared by all associations,
+// which take turns writing to it, and receives each PDU in one Write call.
+func WithRawPDULog(w io.Writer, maxBytes int) Option {
+	return func(s *Server) {
+		s.RawPDULog = pdu.SyncWriter(w)
+		s.RawPDULogMaxBytes = maxBytes
+	}
+}
+
+// AssociationHandler observes each negotiated association before it is
+// accepted, including the transfer syntaxes proposed for rejected contexts.
+type AssociationHandler = pdu.AssociationHandler
+
+// WithAssociationHandler registers a callback invoked for every association
+// once its presentation contexts have been negotiated.
+func WithAssociationHandler(handler AssociationHandler) Option {
+	return func(s *Server) {
+		s.AssociationHandler = handler
+	}
+}
+
+// AssociationStats describes a finished association, e.g. for billing or
+// capacity planning. The AE titles are empty when no A-ASSOCIATE-RQ was
+// received.
+type AssociationStats struct {
+	RemoteAddr     string
+	CallingAETitle string
+	CalledAETitle  string
+	pdu.ConnStats
+}
+
+// WithAssociationCloseHandler registers a callback invoked with the byte
+// counts of every connection once it has closed.
+func WithAssociationCloseHandler(handler func(AssociationStats)) Option {
+	return func(s *Server) {
+		s.AssociationCloseHandler = handler
+	}
+}
+
+// WithUnsupportedCommandHandler sets the status of the failure response sent
+// for requests the handler has no handler registered for, such as 0x0122 SOP
+// Class Not Supported, instead of the default 0xC000. Codes that are not
+// failures fall back to 0xC000. It applies when the handler is a
+// services.Registry or otherwise reports its commands with HasHandler.
+func WithUnsupportedCommandHandler(status func(msg *types.Message) uint16) Option {
+	return func(s *Server) {
+		s.UnsupportedCommandHandler = status
+	}
+}
+
 // Server exposes a reusable DICOM listener that wires the DIMSE and PDU layers.
 type Server struct {
 	AETitle      string
 	Handler      interfaces.ServiceHandler
 	Logger       *slog.Logger
-	ReadTimeout  time.Duration // Read timeout for connections (default: 60s)
-	WriteTimeout time.Duration // Write timeout for connections (default: 60s)
+	ReadTimeout  time.Duration // Timeout waiting for each PDU (default: none)
+	WriteTimeout time.Duration // Timeout writing each PDU (default: none)
+
+	AssociationTimeout time.Duration // Maximum lifetime of an association (default: none)
+	HandlerTimeout     time.Duration // Maximum time a handler may take per operation (default: none)
+
+	KeepAlive      time.Duration // TCP keep-alive period (default: 30s, negative disables)
+	DisableNoDelay bool          // Re-enable Nagle's algorithm (TCP_NODELAY is set by default)
+
+	ReadBufferSize  int // Socket read buffer size in bytes (default: unbuffered)
+	WriteBufferSize int // Socket write buffer size in bytes (default: unbuffered)
+
+	// SupportedAbstractSyntaxes, when non-empty, replaces the default set of
+	// abstract syntaxes accepted during association negotiation.
+	SupportedAbstractSyntaxes []string
+
+	// RelationalQueries enables relational query/retrieve extended negotiation.
+	RelationalQueries bool
+
+	// UnifiedProcedureStep adds the UPS SOP classes to the default set of
+	// accepted abstract syntaxes.
+	UnifiedProcedureStep bool
+
+	// TransferSyntaxPreferences orders the transfer syntaxes accepted for
+	// individual abstract syntaxes, most preferred first.
+	TransferSyntaxPreferences map[string][]string
+
+	// AETable maps remote AE titles to host:port for C-MOVE destinations.
+	// When nil, destinations are left for the handler to resolve.
+	AETable map[string]string
+
+	// CalledAEPolicy decides which Called AE Titles are accepted
+	// (default: CalledAEIgnore).
+	CalledAEPolicy CalledAEPolicy
+
+	// NoContextsPolicy decides how associations without any acceptable
+	// presentation context are answered (default: NoContextsReject).
+	NoContextsPolicy NoContextsPolicy
+
+	// AsyncOperationsWindow is the most operations performed concurrently
+	// on one association (default: 1, synchronous).
+	AsyncOperationsWindow int
+
+	// MaxPresentationContexts and MaxTransferSyntaxes cap what one
+	// association request may propose (default: the pdu package defaults).
+	MaxPresentationContexts int
+	MaxTransferSyntaxes     int
+
+	// DisableAutoWorkarounds stops adapting to known peer implementations
+	// (default: adapt).
+	DisableAutoWorkarounds bool
+
+	// MaxFindMatches caps the matches returned for one C-FIND (default: no
+	// limit).
+	MaxFindMatches int
+
+	// DefaultResponseTransferSyntax encodes response datasets when the
+	// presentation context's transfer syntax is unknown (default: Explicit
+	// VR Little Endian).
+	DefaultResponseTransferSyntax string
+
+	// RawPDULog, when set, receives a hex dump of up to RawPDULogMaxBytes
+	// of each PDU read or written (default: off). All associations write to
+	// it, so it must be safe for concurrent use, as WithRawPDULog or
+	// pdu.SyncWriter make it.
+	RawPDULog         io.Writer
+	RawPDULogMaxBytes int
+
+	// AssociationHandler, when set, is called with each negotiated
+	// association context before the A-ASSOCIATE-AC is sent.
+	AssociationHandler AssociationHandler
+
+	// AssociationCloseHandler, when set, is called with the byte counts of
+	// each connection once it has closed.
+	AssociationCloseHandler func(AssociationStats)
+
+	// UnsupportedCommandHandler, when set, picks the status of the failure
+	// response to requests without a registered handler (default: 0xC000).
+	UnsupportedCommandHandler func(msg *types.Message) uint16
+
+	// InitialMessageID is the first message ID of server-originated
+	// requests on each association (default: 1).
+	InitialMessageID uint16
 }
 
 // New builds a Server with the provided AE title and handler.
@@ -67,11 +416,43 @@ func ListenAndServe(ctx context.Context, address, aeTitle string, handler interf
 	return srv.Serve(ctx, listener)
 }
 
-// Serve accepts connections from listener until ctx is cancelled or an unrecoverable error occurs.
-func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
-	if listener == nil {
-		return errors.New("dicomserver: listener is required")
+// ServeConn serves a single association over conn, which may be any
+// transport such as a WebSocket or an SSH channel, until the peer releases
+// or aborts it or ctx is cancelled. The connection is closed on return.
+func ServeConn(ctx context.Context, conn net.Conn, aeTitle string, handler interfaces.ServiceHandler, opts ...Option) error {
+	return New(aeTitle, handler, opts...).ServeConn(ctx, conn)
+}
+
+// ServeConn serves a single association over conn instead of accepting it
+// from a listener. It returns the error that ended the association, or nil
+// when it ended normally or ctx was cancelled.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	if conn == nil {
+		return errors.New("dicomserver: connection is required")
 	}
+	if err := s.validate(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	logger := s.logger()
+	s.checkTransferSyntaxPreferences(logger)
+
+	if err := s.handleConnection(ctx, conn, logger); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// validate checks the server is complete enough to serve associations
+func (s *Server) validate() error {
 	if s == nil {
 		return errors.New("dicomserver: server is nil")
 	}
@@ -81,8 +462,37 @@ func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 	if s.AETitle == "" {
 		return errors.New("dicomserver: AE title is required")
 	}
+	if ts := s.DefaultResponseTransferSyntax; ts != "" && !dicom.CanEncodeTransferSyntax(ts) {
+		return fmt.Errorf("dicomserver: cannot encode responses in transfer syntax %s", ts)
+	}
+	return nil
+}
+
+// checkTransferSyntaxPreferences warns about preferred transfer syntaxes
+// that are never accepted because their datasets cannot be encoded
+func (s *Server) checkTransferSyntaxPreferences(logger *slog.Logger) {
+	for abstractSyntax, order := range s.TransferSyntaxPreferences {
+		for _, ts := range order {
+			if !pdu.CanCarryDatasets(abstractSyntax, ts) {
+				logger.Warn("Preferred transfer syntax will not be accepted: its datasets cannot be encoded",
+					"abstract_syntax", abstractSyntax,
+					"transfer_syntax", ts)
+			}
+		}
+	}
+}
+
+// Serve accepts connections from listener until ctx is cancelled or an unrecoverable error occurs.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if listener == nil {
+		return errors.New("dicomserver: listener is required")
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
 
 	logger := s.logger()
+	s.checkTransferSyntaxPreferences(logger)
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -97,8 +507,9 @@ func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 		"ae_title", s.AETitle)
 
 	var (
-		wg       sync.WaitGroup
-		serveErr error
+		wg           sync.WaitGroup
+		serveErr     error
+		associations uint64
 	)
 
 	for {
@@ -116,11 +527,12 @@ func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 			break
 		}
 
+		associations++
 		wg.Add(1)
-		go func(c net.Conn) {
+		go func(c net.Conn, logger *slog.Logger) {
 			defer wg.Done()
-			s.handleConnection(ctx, c, logger)
-		}(conn)
+			_ = s.handleConnection(ctx, c, logger)
+		}(conn, logger.With("association_id", associations))
 	}
 
 	wg.Wait()
@@ -132,26 +544,56 @@ func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 	return ctx.Err()
 }
 
-func (s *Server) handleConnection(ctx context.Context, conn net.Conn, logger *slog.Logger) {
+// handleConnection serves one association, returning the error that ended it
+func (s *Server) handleConnection(ctx context.Context, conn net.Conn, logger *slog.Logger) (err error) {
 	logger.Info("Accepted DICOM connection",
 		"remote_addr", conn.RemoteAddr())
 
-	// Set timeouts if configured
-	if s.ReadTimeout > 0 {
-		if err := conn.SetReadDeadline(time.Now().Add(s.ReadTimeout)); err != nil {
-			logger.Warn("Failed to set read deadline", "error", err)
-		}
+	keepAlive := s.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = pdu.DefaultKeepAlive
 	}
-	if s.WriteTimeout > 0 {
-		if err := conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout)); err != nil {
-			logger.Warn("Failed to set write deadline", "error", err)
-		}
+	if err := pdu.ConfigureConn(conn, keepAlive, !s.DisableNoDelay); err != nil {
+		logger.Warn("Failed to configure TCP options", "error", err)
+	}
+	counter := pdu.NewCountingConn(conn)
+	conn = counter
+	if s.RawPDULog != nil {
+		conn = pdu.NewRawPDULogConn(conn, s.RawPDULog, s.RawPDULogMaxBytes)
 	}
 
-	adapter := &dimseHandlerAdapter{service: dimse.NewService(s.Handler, logger)}
-	layer := pdu.NewLayer(conn, adapter, s.AETitle, logger)
+	layerOptions := s.layerOptions()
+	if s.AssociationTimeout > 0 {
+		layerOptions = append(layerOptions, pdu.WithDeadline(time.Now().Add(s.AssociationTimeout)))
+	}
+
+	adapter := &dimseHandlerAdapter{service: dimse.NewService(s.Handler, logger, s.serviceOptions()...)}
+	layer := pdu.NewLayer(conn, adapter, s.AETitle, logger, layerOptions...)
+	if s.AssociationCloseHandler != nil {
+		defer func() {
+			stats := AssociationStats{RemoteAddr: conn.RemoteAddr().String(), ConnStats: counter.Stats()}
+			if assocCtx := layer.AssociationContext(); assocCtx != nil {
+				stats.CallingAETitle = assocCtx.CallingAETitle
+				stats.CalledAETitle = assocCtx.CalledAETitle
+			}
+			s.AssociationCloseHandler(stats)
+		}()
+	}
+
+	// A panic, e.g. a handler tripping over malformed input, ends only this
+	// association and not the whole server
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Panic while serving association, aborting it",
+				"panic", r,
+				"remote_addr", conn.RemoteAddr(),
+				"stack", string(debug.Stack()))
+			layer.Abort()
+			err = fmt.Errorf("panic while serving association: %v", r)
+		}
+	}()
 
-	if err := layer.HandleConnection(); err != nil && ctx.Err() == nil {
+	if err = layer.HandleConnection(); err != nil && ctx.Err() == nil {
 		logger.Warn("DIMSE connection ended",
 			"error", err,
 			"remote_addr", conn.RemoteAddr())
@@ -159,6 +601,86 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn, logger *sl
 		logger.Info("DIMSE connection closed",
 			"remote_addr", conn.RemoteAddr())
 	}
+	return err
+}
+
+func (s *Server) serviceOptions() []dimse.ServiceOption {
+	opts := []dimse.ServiceOption{dimse.WithAETitle(s.AETitle)}
+	if s.AETable != nil {
+		opts = append(opts, dimse.WithMoveDestinationResolver(s.resolveAETitle))
+	}
+	if s.HandlerTimeout > 0 {
+		opts = append(opts, dimse.WithHandlerTimeout(s.HandlerTimeout))
+	}
+	if s.DefaultResponseTransferSyntax != "" {
+		opts = append(opts, dimse.WithDefaultTransferSyntax(s.DefaultResponseTransferSyntax))
+	}
+	if s.MaxFindMatches > 0 {
+		opts = append(opts, dimse.WithMaxFindMatches(s.MaxFindMatches))
+	}
+	if s.UnsupportedCommandHandler != nil {
+		opts = append(opts, dimse.WithUnsupportedCommandHandler(s.UnsupportedCommandHandler))
+	}
+	if s.InitialMessageID != 0 {
+		opts = append(opts, dimse.WithInitialMessageID(s.InitialMessageID))
+	}
+	return opts
+}
+
+// resolveAETitle looks up the address of a remote AE in the AE table
+func (s *Server) resolveAETitle(aeTitle string) (string, bool) {
+	address, ok := s.AETable[strings.TrimSpace(aeTitle)]
+	return address, ok
+}
+
+func (s *Server) layerOptions() []pdu.LayerOption {
+	var opts []pdu.LayerOption
+	if len(s.SupportedAbstractSyntaxes) > 0 {
+		opts = append(opts, pdu.WithSupportedAbstractSyntaxes(s.SupportedAbstractSyntaxes...))
+	}
+	if s.RelationalQueries {
+		opts = append(opts, pdu.WithRelationalQueries())
+	}
+	if s.UnifiedProcedureStep {
+		opts = append(opts, pdu.WithUnifiedProcedureStep())
+	}
+	for abstractSyntax, order := range s.TransferSyntaxPreferences {
+		opts = append(opts, pdu.WithTransferSyntaxPreference(abstractSyntax, order))
+	}
+	if s.ReadBufferSize > 0 {
+		opts = append(opts, pdu.WithReadBufferSize(s.ReadBufferSize))
+	}
+	if s.WriteBufferSize > 0 {
+		opts = append(opts, pdu.WithWriteBufferSize(s.WriteBufferSize))
+	}
+	if s.ReadTimeout > 0 {
+		opts = append(opts, pdu.WithReadTimeout(s.ReadTimeout))
+	}
+	if s.WriteTimeout > 0 {
+		opts = append(opts, pdu.WithWriteTimeout(s.WriteTimeout))
+	}
+	if s.CalledAEPolicy != CalledAEIgnore {
+		opts = append(opts, pdu.WithCalledAEPolicy(s.CalledAEPolicy))
+	}
+	if s.NoContextsPolicy != NoContextsReject {
+		opts = append(opts, pdu.WithNoContextsPolicy(s.NoContextsPolicy))
+	}
+	if s.AsyncOperationsWindow > 1 {
+		opts = append(opts, pdu.WithAsyncOperationsWindow(s.AsyncOperationsWindow))
+	}
+	if s.DisableAutoWorkarounds {
+		opts = append(opts, pdu.WithAutoWorkarounds(false))
+	}
+	if s.MaxPresentationContexts > 0 {
+		opts = append(opts, pdu.WithMaxPresentationContexts(s.MaxPresentationContexts))
+	}
+	if s.MaxTransferSyntaxes > 0 {
+		opts = append(opts, pdu.WithMaxTransferSyntaxes(s.MaxTransferSyntaxes))
+	}
+	if s.AssociationHandler != nil {
+		opts = append(opts, pdu.WithAssociationHandler(s.AssociationHandler))
+	}
+	return opts
 }
 
 func (s *Server) logger() *slog.Logger {
@@ -175,3 +697,8 @@ type dimseHandlerAdapter struct {
 func (a *dimseHandlerAdapter) HandleDIMSEMessage(presContextID byte, msgCtrlHeader byte, data []byte, layer *pdu.Layer) error {
 	return a.service.HandleDIMSEMessage(presContextID, msgCtrlHeader, data, layer)
 }
+
+// Wait lets the PDU layer wait for concurrently dispatched operations
+func (a *dimseHandlerAdapter) Wait() {
+	a.service.Wait()
+}
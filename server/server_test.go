@@ -0,0 +1,1026 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caio-sobreiro/dicomnet/client"
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/pdu"
+	"github.com/caio-sobreiro/dicomnet/services"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func TestServer_TCPOptions(t *testing.T) {
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithKeepAlive(10*time.Second),
+		WithNoDelay(false))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.VerificationSOPClass},
+		KeepAlive:      15 * time.Second,
+		DisableNoDelay: true,
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess {
+		t.Errorf("Status = 0x%04X, want success", resp.Status)
+	}
+}
+
+func TestServer_BufferedConnections(t *testing.T) {
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithReadBufferSize(64*1024),
+		WithWriteBufferSize(64*1024))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle:  "TEST_SCU",
+		CalledAETitle:   "ECHO_SCP",
+		ConnectTimeout:  5 * time.Second,
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    5 * time.Second,
+		Logger:          discardLogger(),
+		SOPClasses:      []string{types.VerificationSOPClass},
+		ReadBufferSize:  64 * 1024,
+		WriteBufferSize: 64 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	for i := uint16(1); i <= 3; i++ {
+		resp, err := assoc.SendCEcho(i)
+		if err != nil {
+			t.Fatalf("SendCEcho(%d) error = %v", i, err)
+		}
+		if resp.Status != dimse.StatusSuccess {
+			t.Errorf("Status = 0x%04X, want success", resp.Status)
+		}
+	}
+}
+
+func TestServer_CalledAEPolicy(t *testing.T) {
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithCalledAEPolicy(CalledAEAny))
+	addr := startTestServer(t, srv)
+
+	connect := func(calledAE string) (*client.Association, error) {
+		return client.Connect(addr, client.Config{
+			CallingAETitle: "TEST_SCU",
+			CalledAETitle:  calledAE,
+			ConnectTimeout: 5 * time.Second,
+			ReadTimeout:    5 * time.Second,
+			WriteTimeout:   5 * time.Second,
+			Logger:         discardLogger(),
+			SOPClasses:     []string{types.VerificationSOPClass},
+		})
+	}
+
+	// Scanners without a configured remote AE title send an empty one
+	assoc, err := connect("")
+	if err != nil {
+		t.Fatalf("Connect() with empty called AE error = %v", err)
+	}
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess {
+		t.Errorf("Status = 0x%04X, want success", resp.Status)
+	}
+	assoc.Close()
+
+	if assoc, err := connect("OTHER_SCP"); err == nil {
+		assoc.Close()
+		t.Error("Expected association addressed to another AE title to be rejected")
+	}
+}
+
+func TestServer_AssociationHandlerSeesRejectedTransferSyntaxes(t *testing.T) {
+	contexts := make(chan []pdu.PresentationContext, 1)
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithSupportedAbstractSyntaxes(types.VerificationSOPClass, types.CTImageStorage),
+		WithAssociationHandler(func(ctx *pdu.AssociationContext) {
+			contexts <- ctx.RejectedContexts()
+		}))
+	addr := startTestServer(t, srv)
+
+	// The server only supports the uncompressed transfer syntaxes
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle:            "TEST_SCU",
+		CalledAETitle:             "ECHO_SCP",
+		ConnectTimeout:            5 * time.Second,
+		ReadTimeout:               5 * time.Second,
+		WriteTimeout:              5 * time.Second,
+		Logger:                    discardLogger(),
+		SOPClasses:                []string{types.CTImageStorage},
+		PreferredTransferSyntaxes: []string{types.JPEG2000},
+	})
+	if err == nil {
+		assoc.Close()
+	}
+
+	var rejected []pdu.PresentationContext
+	select {
+	case rejected = <-contexts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Association handler was not called")
+	}
+
+	if len(rejected) != 1 {
+		t.Fatalf("Rejected contexts = %+v, want the CT Image Storage context", rejected)
+	}
+	pc := rejected[0]
+	if pc.AbstractSyntax != types.CTImageStorage || pc.Result != pdu.PresentationResultRejectTransferSyntax {
+		t.Errorf("Rejected context = %+v, want CT Image Storage with result 0x04", pc)
+	}
+	if len(pc.ProposedTransferSyntaxes) != 1 || pc.ProposedTransferSyntaxes[0] != types.JPEG2000 {
+		t.Errorf("ProposedTransferSyntaxes = %v, want [%s]", pc.ProposedTransferSyntaxes, types.JPEG2000)
+	}
+}
+
+// modelRecordingFindHandler answers every C-FIND with success, recording the
+// information model each query arrived on
+type modelRecordingFindHandler struct {
+	models chan [2]string // negotiated abstract syntax, Affected SOP Class UID
+}
+
+func (h modelRecordingFindHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	h.models <- [2]string{meta.AbstractSyntaxUID, msg.AffectedSOPClassUID}
+	return &types.Message{
+		CommandField:              types.CFindRSP,
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+		CommandDataSetType:        0x0101,
+		Status:                    types.StatusSuccess,
+	}, nil, nil
+}
+
+func TestServer_OneFindHandlerServesBothModels(t *testing.T) {
+	handler := modelRecordingFindHandler{models: make(chan [2]string, 2)}
+	registry := services.NewRegistry()
+	registry.RegisterHandler(dimse.CFindRQ, handler)
+
+	srv := New("QR_SCP", registry, WithLogger(discardLogger()))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.ConnectQR(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "QR_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+	})
+	if err != nil {
+		t.Fatalf("ConnectQR() error = %v", err)
+	}
+	defer assoc.Close()
+
+	models := []string{
+		types.StudyRootQueryRetrieveInformationModelFind,
+		types.PatientRootQueryRetrieveInformationModelFind,
+	}
+	for i, model := range models {
+		query := dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D})
+		query.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "PID-1")
+		responses, err := assoc.SendCFind(&client.CFindRequest{SOPClassUID: model, MessageID: uint16(i + 1), Dataset: query})
+		if err != nil {
+			t.Fatalf("SendCFind(%s) error = %v", model, err)
+		}
+		if last := responses[len(responses)-1]; last.Status != types.StatusSuccess {
+			t.Fatalf("SendCFind(%s) status = 0x%04X, want success", model, last.Status)
+		}
+
+		got := <-handler.models
+		if got[0] != model || got[1] != model {
+			t.Errorf("Query on %s reached the handler as abstract syntax %s, Affected SOP Class %s", model, got[0], got[1])
+		}
+	}
+}
+
+// endlessFindHandler streams pending C-FIND responses until the association
+// goes away.
+type endlessFindHandler struct{}
+
+func (endlessFindHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	return nil, nil, errors.New("streaming only")
+}
+
+func (endlessFindHandler) HandleDIMSEStreaming(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+
+	deadline := time.After(10 * time.Second)
+	for {
+		resp := &types.Message{
+			CommandField:              types.CFindRSP,
+			MessageIDBeingRespondedTo: msg.MessageID,
+			AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+			CommandDataSetType:        0x0000,
+			Status:                    types.StatusPending,
+		}
+		if err := responder.SendResponse(resp, match, meta.TransferSyntaxUID); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return errors.New("association was not aborted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_AssociationTimeout(t *testing.T) {
+	srv := New("FIND_SCP", endlessFindHandler{},
+		WithLogger(discardLogger()),
+		WithReadTimeout(5*time.Second),
+		WithAssociationTimeout(200*time.Millisecond))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "FIND_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.StudyRootQueryRetrieveInformationModelFind},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	query := dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D})
+
+	start := time.Now()
+	_, err = assoc.SendCFind(&client.CFindRequest{Dataset: query})
+	if err == nil {
+		t.Fatal("Expected C-FIND to fail when the association is aborted")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Association aborted after %v, want about 200ms", elapsed)
+	}
+}
+
+// sleepingHandler ignores its context and takes too long to answer
+type sleepingHandler struct{}
+
+func (sleepingHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	time.Sleep(2 * time.Second)
+	return &types.Message{
+		CommandField:              dimse.CEchoRSP,
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+		CommandDataSetType:        0x0101,
+		Status:                    dimse.StatusSuccess,
+	}, nil, nil
+}
+
+func TestServer_HandlerTimeout(t *testing.T) {
+	srv := New("ECHO_SCP", sleepingHandler{},
+		WithLogger(discardLogger()),
+		WithHandlerTimeout(100*time.Millisecond))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.VerificationSOPClass},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	start := time.Now()
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if resp.Status != dimse.StatusFailure {
+		t.Errorf("Status = 0x%04X, want 0x%04X", resp.Status, dimse.StatusFailure)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Response took %v, want about 100ms", elapsed)
+	}
+
+	// The handler is still running, so the association has been aborted
+	if _, err := assoc.SendCEcho(2); err == nil {
+		t.Error("Expected association to be terminated after the handler timed out")
+	}
+}
+
+// panickingHandler answers C-ECHO but trips over a crafted message, as a
+// handler indexing past malformed input would
+type panickingHandler struct{}
+
+func (panickingHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	if msg.MessageID == 666 {
+		_ = data[msg.MessageID] // C-ECHO has no dataset
+	}
+	return services.NewEchoService().HandleDIMSE(ctx, msg, data, meta)
+}
+
+func TestServer_RecoversFromHandlerPanic(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"on the connection goroutine", nil},
+		{"on a handler goroutine", []Option{WithHandlerTimeout(5 * time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New("ECHO_SCP", panickingHandler{}, append(tt.opts, WithLogger(discardLogger()))...)
+			addr := startTestServer(t, srv)
+
+			connect := func() *client.Association {
+				assoc, err := client.Connect(addr, client.Config{
+					CallingAETitle: "TEST_SCU",
+					CalledAETitle:  "ECHO_SCP",
+					ConnectTimeout: 5 * time.Second,
+					ReadTimeout:    5 * time.Second,
+					WriteTimeout:   5 * time.Second,
+					Logger:         discardLogger(),
+					SOPClasses:     []string{types.VerificationSOPClass},
+				})
+				if err != nil {
+					t.Fatalf("Connect() error = %v", err)
+				}
+				return assoc
+			}
+
+			assoc := connect()
+			if _, err := assoc.SendCEcho(666); err == nil {
+				t.Error("SendCEcho() of the crafted message succeeded, want the association aborted")
+			}
+			assoc.Close()
+
+			assoc = connect()
+			defer assoc.Close()
+			resp, err := assoc.SendCEcho(1)
+			if err != nil {
+				t.Fatalf("SendCEcho() after the panic error = %v", err)
+			}
+			if resp.Status != dimse.StatusSuccess {
+				t.Errorf("Status = 0x%04X, want success", resp.Status)
+			}
+		})
+	}
+}
+
+func TestServer_DefaultResponseTransferSyntaxValidation(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithDefaultResponseTransferSyntax(types.JPEGBaseline8Bit))
+	if err := srv.Serve(context.Background(), listener); err == nil {
+		t.Error("Serve() with a JPEG Baseline default response transfer syntax succeeded, want an error")
+	}
+
+	srv = NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithDefaultResponseTransferSyntax(types.ImplicitVRLittleEndian))
+	addr := startTestServer(t, srv)
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.VerificationSOPClass},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+	if _, err := assoc.SendCEcho(1); err != nil {
+		t.Errorf("SendCEcho() error = %v", err)
+	}
+}
+
+// studyFindHandler answers every C-FIND with one study-level match
+type studyFindHandler struct{}
+
+func (studyFindHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	return nil, nil, errors.New("streaming only")
+}
+
+func (studyFindHandler) HandleDIMSEStreaming(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+	if err := responder.SendResponse(services.NewCFindMatchResponse(msg, match, meta), match, meta.TransferSyntaxUID); err != nil {
+		return err
+	}
+	return responder.SendResponse(services.NewCFindSuccessResponse(msg), nil, meta.TransferSyntaxUID)
+}
+
+func TestServer_FindMatchesCarryRetrieveAETitle(t *testing.T) {
+	srv := New("QR_SCP", studyFindHandler{}, WithLogger(discardLogger()))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.ConnectQR(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "QR_SCP",
+		Logger:         discardLogger(),
+	})
+	if err != nil {
+		t.Fatalf("ConnectQR() error = %v", err)
+	}
+	defer assoc.Close()
+
+	query := dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.Tag{Group: 0x0008, Element: 0x0054})
+	responses, err := assoc.SendCFind(&client.CFindRequest{
+		SOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+		MessageID:   1,
+		Dataset:     query,
+	})
+	if err != nil {
+		t.Fatalf("SendCFind() error = %v", err)
+	}
+	if len(responses) != 2 || responses[0].Status != types.StatusPending {
+		t.Fatalf("SendCFind() returned %d responses, want one match and the final response", len(responses))
+	}
+	if titles := responses[0].RetrieveAETitles(); len(titles) != 1 || titles[0] != "QR_SCP" {
+		t.Errorf("RetrieveAETitles() = %v, want [QR_SCP]", titles)
+	}
+}
+
+// hundredMatchesFindHandler answers every C-FIND with 100 matches
+type hundredMatchesFindHandler struct {
+	ignoreErrors bool // keep sending past the limit, as a careless handler would
+}
+
+func (hundredMatchesFindHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	return nil, nil, errors.New("streaming only")
+}
+
+func (h hundredMatchesFindHandler) HandleDIMSEStreaming(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+	for i := 0; i < 100; i++ {
+		match := dicom.NewDataset()
+		match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, fmt.Sprintf("1.2.%d", i))
+		if err := responder.SendResponse(services.NewCFindPendingResponse(msg), match, meta.TransferSyntaxUID); err != nil && !h.ignoreErrors {
+			return err
+		}
+	}
+	return responder.SendResponse(services.NewCFindSuccessResponse(msg), nil, meta.TransferSyntaxUID)
+}
+
+func TestServer_InitialMessageID(t *testing.T) {
+	srv := New("GET_SCP", services.NewEchoService(), WithInitialMessageID(1000))
+	service := dimse.NewService(srv.Handler, discardLogger(), srv.serviceOptions()...)
+	if got := service.NextMessageID(); got != 1000 {
+		t.Errorf("NextMessageID() = %d, want 1000", got)
+	}
+}
+
+func TestServer_MaxFindMatches(t *testing.T) {
+	for _, handler := range []hundredMatchesFindHandler{{}, {ignoreErrors: true}} {
+		srv := New("QR_SCP", handler, WithLogger(discardLogger()), WithMaxFindMatches(10))
+		addr := startTestServer(t, srv)
+
+		assoc, err := client.ConnectQR(addr, client.Config{
+			CallingAETitle: "TEST_SCU",
+			CalledAETitle:  "QR_SCP",
+			Logger:         discardLogger(),
+		})
+		if err != nil {
+			t.Fatalf("ConnectQR() error = %v", err)
+		}
+
+		responses, err := assoc.SendCFind(&client.CFindRequest{
+			SOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+			MessageID:   1,
+			Dataset:     dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D}),
+		})
+		assoc.Close()
+		if err != nil {
+			t.Fatalf("SendCFind() (ignoreErrors %v) error = %v", handler.ignoreErrors, err)
+		}
+
+		if len(responses) != 11 {
+			t.Fatalf("SendCFind() (ignoreErrors %v) returned %d responses, want 10 matches and the final response",
+				handler.ignoreErrors, len(responses))
+		}
+		for i, resp := range responses[:10] {
+			if resp.Status != types.StatusPending {
+				t.Errorf("Response %d status = 0x%04X, want pending", i, resp.Status)
+			}
+		}
+		if final := responses[10]; final.Status != types.StatusSuccess {
+			t.Errorf("Final status = 0x%04X, want success", final.Status)
+		}
+	}
+}
+
+// failingFindHandler ends every C-FIND with a failure that explains itself
+type failingFindHandler struct{}
+
+func (failingFindHandler) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	response := services.NewCFindErrorResponse(msg, 0xC001)
+	response.ErrorComment = "Query too broad"
+	return response, nil, nil
+}
+
+func TestServer_FindFailureWithErrorComment(t *testing.T) {
+	srv := New("QR_SCP", failingFindHandler{}, WithLogger(discardLogger()))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.ConnectQR(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "QR_SCP",
+		Logger:         discardLogger(),
+	})
+	if err != nil {
+		t.Fatalf("ConnectQR() error = %v", err)
+	}
+	defer assoc.Close()
+
+	responses, err := assoc.SendCFind(&client.CFindRequest{
+		SOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+		MessageID:   1,
+		Dataset:     dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D}),
+	})
+	if err != nil {
+		t.Fatalf("SendCFind() error = %v", err)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("SendCFind() returned %d responses, want only the final failure", len(responses))
+	}
+
+	final := responses[0]
+	if final.Status != 0xC001 || dimse.StatusText(dimse.CFindRSP, final.Status) != "Failed: Unable to process" {
+		t.Errorf("Final status = 0x%04X, want 0xC001 (Failed: Unable to process)", final.Status)
+	}
+	if final.ErrorComment != "Query too broad" {
+		t.Errorf("Final error comment = %q, want %q", final.ErrorComment, "Query too broad")
+	}
+	if final.Dataset != nil {
+		t.Errorf("Final response carried a dataset: %v", final.Dataset)
+	}
+}
+
+func TestServer_UnsupportedCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		status uint16
+	}{
+		{"default", nil, types.StatusFailure},
+		{"callback", []Option{WithUnsupportedCommandHandler(func(msg *types.Message) uint16 {
+			return dimse.StatusSOPClassNotSupported
+		})}, dimse.StatusSOPClassNotSupported},
+		{"non-failure callback", []Option{WithUnsupportedCommandHandler(func(msg *types.Message) uint16 {
+			return types.StatusSuccess
+		})}, types.StatusFailure},
+		{"pending callback", []Option{WithUnsupportedCommandHandler(func(msg *types.Message) uint16 {
+			return types.StatusPending
+		})}, types.StatusFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only C-ECHO is registered, so C-FIND has no handler
+			registry := services.NewRegistry()
+			registry.RegisterHandler(dimse.CEchoRQ, services.NewEchoService())
+			srv := New("QR_SCP", registry, append([]Option{WithLogger(discardLogger())}, tt.opts...)...)
+			addr := startTestServer(t, srv)
+
+			assoc, err := client.ConnectQR(addr, client.Config{
+				CallingAETitle: "TEST_SCU",
+				CalledAETitle:  "QR_SCP",
+				ReadTimeout:    5 * time.Second,
+				Logger:         discardLogger(),
+			})
+			if err != nil {
+				t.Fatalf("ConnectQR() error = %v", err)
+			}
+			defer assoc.Close()
+
+			responses, err := assoc.SendCFind(&client.CFindRequest{
+				SOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+				MessageID:   1,
+				Dataset:     dicom.NewQuery("STUDY", dicom.Tag{Group: 0x0020, Element: 0x000D}),
+			})
+			if err != nil {
+				t.Fatalf("SendCFind() error = %v", err)
+			}
+			if len(responses) != 1 || responses[0].Status != tt.status {
+				t.Fatalf("SendCFind() = %d responses, want a single 0x%04X failure", len(responses), tt.status)
+			}
+
+			// The association survives the unsupported request
+			if echo, err := assoc.SendCEcho(2); err != nil || echo.Status != types.StatusSuccess {
+				t.Errorf("SendCEcho() after the failure = %v, %v; want success", echo, err)
+			}
+		})
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for the server's goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServer_RawPDULog(t *testing.T) {
+	var log lockedBuffer
+	srv := NewEchoServer("ECHO_SCP", WithLogger(discardLogger()), WithRawPDULog(&log, 16))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		SOPClasses:     []string{types.VerificationSOPClass},
+		Logger:         discardLogger(),
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if _, err := assoc.SendCEcho(1); err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	assoc.Close()
+
+	// The client has received the responses, so the server has dumped them
+	dump := log.String()
+	for _, want := range []string{"received A-ASSOCIATE-RQ", "sent A-ASSOCIATE-AC", "received P-DATA-TF", "sent P-DATA-TF", "(first 16 shown)"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("Raw PDU log has no %q:\n%s", want, dump)
+		}
+	}
+}
+
+func TestServer_UnifiedProcedureStep(t *testing.T) {
+	connect := func(srv *Server) (*client.Association, error) {
+		return client.Connect(startTestServer(t, srv), client.Config{
+			CallingAETitle: "TEST_SCU",
+			CalledAETitle:  "UPS_SCP",
+			ConnectTimeout: 5 * time.Second,
+			ReadTimeout:    5 * time.Second,
+			WriteTimeout:   5 * time.Second,
+			Logger:         discardLogger(),
+			SOPClasses:     []string{types.UnifiedProcedureStepPushSOPClass},
+		})
+	}
+
+	assoc, err := connect(New("UPS_SCP", services.NewRegistry(), WithLogger(discardLogger()), WithUnifiedProcedureStep()))
+	if err != nil {
+		t.Fatalf("Connect() with UPS enabled error = %v", err)
+	}
+	defer assoc.Close()
+	if _, err := assoc.GetPresentationContext(types.UnifiedProcedureStepPushSOPClass); err != nil {
+		t.Errorf("UPS Push context not accepted: %v", err)
+	}
+
+	if assoc, err := connect(New("UPS_SCP", services.NewRegistry(), WithLogger(discardLogger()))); err == nil {
+		defer assoc.Close()
+		if _, err := assoc.GetPresentationContext(types.UnifiedProcedureStepPushSOPClass); err == nil {
+			t.Error("UPS Push context accepted without UPS support enabled")
+		}
+	}
+}
+
+func TestServeConn_EchoOverPipe(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	registry := services.NewRegistry()
+	registry.RegisterHandler(dimse.CEchoRQ, services.NewEchoService())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeConn(context.Background(), serverConn, "PIPE_SCP", registry, WithLogger(discardLogger()))
+	}()
+
+	assoc, err := client.NewAssociation(clientConn, client.Config{
+		CallingAETitle: "PIPE_SCU",
+		CalledAETitle:  "PIPE_SCP",
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.VerificationSOPClass},
+	})
+	if err != nil {
+		t.Fatalf("NewAssociation() error = %v", err)
+	}
+
+	resp, err := assoc.SendCEcho(1)
+	if err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess {
+		t.Errorf("C-ECHO status = 0x%04X, want success", resp.Status)
+	}
+
+	if err := assoc.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeConn() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn() did not return after release")
+	}
+}
+
+func TestServer_TransferSyntaxPreferenceFor(t *testing.T) {
+	srv := New("STORE_SCP", services.NewRegistry(),
+		WithLogger(discardLogger()),
+		WithTransferSyntaxPreferenceFor(types.CTImageStorage, []string{types.JPEG2000, types.ExplicitVRLittleEndian}),
+		WithTransferSyntaxPreferenceFor(types.BasicTextSRStorage, []string{types.ExplicitVRLittleEndian, types.ImplicitVRLittleEndian}))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle:            "TEST_SCU",
+		CalledAETitle:             "STORE_SCP",
+		ConnectTimeout:            5 * time.Second,
+		ReadTimeout:               5 * time.Second,
+		WriteTimeout:              5 * time.Second,
+		Logger:                    discardLogger(),
+		SOPClasses:                []string{types.CTImageStorage, types.BasicTextSRStorage, types.MRImageStorage},
+		PreferredTransferSyntaxes: []string{types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian, types.JPEG2000},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	want := map[string]string{
+		types.CTImageStorage:     types.JPEG2000,
+		types.BasicTextSRStorage: types.ExplicitVRLittleEndian,
+		types.MRImageStorage:     types.ImplicitVRLittleEndian, // No preference: first supported proposal
+	}
+	for sopClass, ts := range want {
+		pc, err := assoc.GetPresentationContext(sopClass)
+		if err != nil {
+			t.Errorf("%s: %v", sopClass, err)
+			continue
+		}
+		if pc.TransferSyntax != ts {
+			t.Errorf("%s: accepted transfer syntax %s, want %s", sopClass, pc.TransferSyntax, ts)
+		}
+	}
+}
+
+func TestServer_TransferSyntaxPreferenceRequiresCodecForQueries(t *testing.T) {
+	var logs lockedBuffer
+	srv := New("QR_SCP", services.NewRegistry(),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithTransferSyntaxPreferenceFor(types.StudyRootQueryRetrieveInformationModelFind, []string{types.JPEG2000}),
+		WithTransferSyntaxPreferenceFor(types.CTImageStorage, []string{types.JPEG2000}))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle:            "TEST_SCU",
+		CalledAETitle:             "QR_SCP",
+		ConnectTimeout:            5 * time.Second,
+		ReadTimeout:               5 * time.Second,
+		WriteTimeout:              5 * time.Second,
+		Logger:                    discardLogger(),
+		SOPClasses:                []string{types.StudyRootQueryRetrieveInformationModelFind, types.CTImageStorage},
+		PreferredTransferSyntaxes: []string{types.JPEG2000},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	if pc, err := assoc.GetPresentationContext(types.StudyRootQueryRetrieveInformationModelFind); err == nil {
+		t.Errorf("Q/R context accepted on %s", pc.TransferSyntax)
+	}
+	if pc, err := assoc.GetPresentationContext(types.CTImageStorage); err != nil || pc.TransferSyntax != types.JPEG2000 {
+		t.Errorf("Storage context on JPEG 2000 not accepted: %+v, %v", pc, err)
+	}
+	if !strings.Contains(logs.String(), "Preferred transfer syntax will not be accepted") {
+		t.Errorf("Expected a startup warning, got logs:\n%s", logs.String())
+	}
+}
+
+type staticWorklist []*dicom.Dataset
+
+func (w staticWorklist) FindWorklistItems(context.Context, *dicom.Dataset) ([]*dicom.Dataset, error) {
+	return w, nil
+}
+
+func TestServer_WorklistOptionalKeysNotSupported(t *testing.T) {
+	patientName := dicom.Tag{Group: 0x0010, Element: 0x0010}
+	patientID := dicom.Tag{Group: 0x0010, Element: 0x0020}
+	scheduledStep := dicom.Tag{Group: 0x0040, Element: 0x0100}
+	modality := dicom.Tag{Group: 0x0008, Element: 0x0060}
+
+	var items staticWorklist
+	for _, id := range []string{"PID1", "PID2"} {
+		item := dicom.NewDataset()
+		item.AddElement(patientID, dicom.VR_LO, id)
+		items = append(items, item)
+	}
+
+	registry := services.NewRegistry()
+	registry.RegisterHandler(dimse.CFindRQ, services.NewWorklistService(items, patientName, patientID, scheduledStep, modality))
+	srv := New("MWL_SCP", registry,
+		WithLogger(discardLogger()),
+		WithSupportedAbstractSyntaxes(types.ModalityWorklistInformationModelFind))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "MODALITY",
+		CalledAETitle:  "MWL_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.ModalityWorklistInformationModelFind},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer assoc.Close()
+
+	// Requested Procedure Priority (0040,1003) in the scheduled step is an
+	// optional key the provider does not support
+	step := dicom.NewDataset()
+	step.AddElement(modality, dicom.VR_CS, "CT")
+	step.AddUniversalKey(dicom.Tag{Group: 0x0040, Element: 0x1003})
+	query := dicom.NewDataset()
+	query.AddUniversalKey(patientName)
+	query.AddUniversalKey(patientID)
+	query.AddElement(scheduledStep, dicom.VR_SQ, []*dicom.Dataset{step})
+
+	responses, err := assoc.SendCFind(&client.CFindRequest{
+		SOPClassUID: types.ModalityWorklistInformationModelFind,
+		MessageID:   1,
+		Dataset:     query,
+	})
+	if err != nil {
+		t.Fatalf("SendCFind() error = %v", err)
+	}
+
+	want := []uint16{dimse.StatusPendingOptionalKeysNotSupported, dimse.StatusPendingOptionalKeysNotSupported, dimse.StatusSuccess}
+	if len(responses) != len(want) {
+		t.Fatalf("got %d responses, want %d", len(responses), len(want))
+	}
+	for i, resp := range responses {
+		if resp.Status != want[i] {
+			t.Errorf("response %d status = 0x%04X, want 0x%04X", i, resp.Status, want[i])
+		}
+	}
+	if got := responses[1].Dataset.GetString(patientID); got != "PID2" {
+		t.Errorf("second match Patient ID = %q, want PID2", got)
+	}
+}
+
+func TestServer_AssociationByteCounts(t *testing.T) {
+	closed := make(chan AssociationStats, 1)
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithAssociationCloseHandler(func(stats AssociationStats) { closed <- stats }))
+	addr := startTestServer(t, srv)
+
+	assoc, err := client.Connect(addr, client.Config{
+		CallingAETitle: "TEST_SCU",
+		CalledAETitle:  "ECHO_SCP",
+		ConnectTimeout: 5 * time.Second,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		Logger:         discardLogger(),
+		SOPClasses:     []string{types.VerificationSOPClass},
+	})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	afterConnect := assoc.Stats()
+	if _, err := assoc.SendCEcho(1); err != nil {
+		t.Fatalf("SendCEcho() error = %v", err)
+	}
+	if err := assoc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	clientStats := assoc.Stats()
+	if clientStats.BytesRead <= afterConnect.BytesRead || clientStats.BytesWritten <= afterConnect.BytesWritten {
+		t.Errorf("Stats() after C-ECHO = %+v, want more than %+v", clientStats, afterConnect)
+	}
+
+	select {
+	case serverStats := <-closed:
+		if serverStats.CallingAETitle != "TEST_SCU" || serverStats.CalledAETitle != "ECHO_SCP" {
+			t.Errorf("AE titles = %q, %q, want TEST_SCU, ECHO_SCP", serverStats.CallingAETitle, serverStats.CalledAETitle)
+		}
+		if serverStats.BytesRead != clientStats.BytesWritten || serverStats.BytesWritten != clientStats.BytesRead {
+			t.Errorf("Server counted %+v, client counted %+v", serverStats.ConnStats, clientStats)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Association close handler was not called")
+	}
+}
+
+func TestServer_ProposalLimits(t *testing.T) {
+	srv := NewEchoServer("ECHO_SCP",
+		WithLogger(discardLogger()),
+		WithMaxPresentationContexts(2),
+		WithMaxTransferSyntaxes(2))
+	addr := startTestServer(t, srv)
+
+	verification := func(transferSyntaxes ...string) client.PresentationContextProposal {
+		return client.PresentationContextProposal{AbstractSyntax: types.VerificationSOPClass, TransferSyntaxes: transferSyntaxes}
+	}
+	for name, tt := range map[string]struct {
+		contexts []client.PresentationContextProposal
+		wantErr  bool
+	}{
+		"within limits": {
+			contexts: []client.PresentationContextProposal{verification(types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian), verification(types.ImplicitVRLittleEndian)},
+		},
+		"too many contexts": {
+			contexts: []client.PresentationContextProposal{verification(types.ImplicitVRLittleEndian), verification(types.ImplicitVRLittleEndian), verification(types.ImplicitVRLittleEndian)},
+			wantErr:  true,
+		},
+		"too many transfer syntaxes": {
+			contexts: []client.PresentationContextProposal{verification(types.ImplicitVRLittleEndian, types.ExplicitVRLittleEndian, types.JPEG2000)},
+			wantErr:  true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assoc, err := client.Connect(addr, client.Config{
+				CallingAETitle:       "TEST_SCU",
+				CalledAETitle:        "ECHO_SCP",
+				ConnectTimeout:       5 * time.Second,
+				ReadTimeout:          5 * time.Second,
+				WriteTimeout:         5 * time.Second,
+				Logger:               discardLogger(),
+				PresentationContexts: tt.contexts,
+			})
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Connect() error = %v", err)
+				}
+				assoc.Close()
+				return
+			}
+			if err == nil {
+				assoc.Close()
+				t.Fatal("Connect() succeeded, want the association rejected")
+			}
+			if !strings.Contains(err.Error(), "rejected by peer (result 1, source 3, reason 2)") {
+				t.Errorf("Connect() error = %v, want a local-limit-exceeded rejection", err)
+			}
+		})
+	}
+}
@@ -57,14 +57,8 @@ func (s *EchoService) HandleDIMSE(ctx context.Context, msg *types.Message, data
 		"message_id", msg.MessageID,
 		"affected_sop_class", msg.AffectedSOPClassUID)
 
-	// Create C-ECHO-RSP according to DICOM PS3.7
-	response := &types.Message{
-		CommandField:              dimse.CEchoRSP,
-		MessageIDBeingRespondedTo: msg.MessageID,
-		AffectedSOPClassUID:       types.VerificationSOPClass, // Verification SOP Class UID
-		CommandDataSetType:        0x0101,                     // No Data Set Present
-		Status:                    dimse.StatusSuccess,
-	}
+	// Create C-ECHO-RSP according to DICOM PS3.7, echoing the request's SOP class
+	response := NewCEchoResponse(msg, dimse.StatusSuccess)
 
 	slog.InfoContext(ctx, "C-ECHO request successful",
 		"message_id", msg.MessageID)
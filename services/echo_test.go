@@ -22,9 +22,10 @@ func TestEchoService_HandleDIMSE(t *testing.T) {
 	ctx := context.Background()
 
 	tests := []struct {
-		name           string
-		msg            *types.Message
-		expectedStatus uint16
+		name             string
+		msg              *types.Message
+		expectedStatus   uint16
+		expectedSOPClass string
 	}{
 		{
 			name: "Basic C-ECHO request",
@@ -34,7 +35,8 @@ func TestEchoService_HandleDIMSE(t *testing.T) {
 				AffectedSOPClassUID: types.VerificationSOPClass,
 				CommandDataSetType:  0x0101,
 			},
-			expectedStatus: dimse.StatusSuccess,
+			expectedStatus:   dimse.StatusSuccess,
+			expectedSOPClass: types.VerificationSOPClass,
 		},
 		{
 			name: "C-ECHO with different message ID",
@@ -44,7 +46,29 @@ func TestEchoService_HandleDIMSE(t *testing.T) {
 				AffectedSOPClassUID: types.VerificationSOPClass,
 				CommandDataSetType:  0x0101,
 			},
-			expectedStatus: dimse.StatusSuccess,
+			expectedStatus:   dimse.StatusSuccess,
+			expectedSOPClass: types.VerificationSOPClass,
+		},
+		{
+			name: "C-ECHO with a non-Verification SOP class",
+			msg: &types.Message{
+				CommandField:        dimse.CEchoRQ,
+				MessageID:           7,
+				AffectedSOPClassUID: "1.2.826.0.1.3680043.2.1143.1",
+				CommandDataSetType:  0x0101,
+			},
+			expectedStatus:   dimse.StatusSuccess,
+			expectedSOPClass: "1.2.826.0.1.3680043.2.1143.1",
+		},
+		{
+			name: "C-ECHO without a SOP class",
+			msg: &types.Message{
+				CommandField:       dimse.CEchoRQ,
+				MessageID:          8,
+				CommandDataSetType: 0x0101,
+			},
+			expectedStatus:   dimse.StatusSuccess,
+			expectedSOPClass: types.VerificationSOPClass,
 		},
 	}
 
@@ -78,9 +102,9 @@ func TestEchoService_HandleDIMSE(t *testing.T) {
 					respMsg.MessageIDBeingRespondedTo, tt.msg.MessageID)
 			}
 
-			if respMsg.AffectedSOPClassUID != types.VerificationSOPClass {
+			if respMsg.AffectedSOPClassUID != tt.expectedSOPClass {
 				t.Errorf("AffectedSOPClassUID = %s, want %s",
-					respMsg.AffectedSOPClassUID, types.VerificationSOPClass)
+					respMsg.AffectedSOPClassUID, tt.expectedSOPClass)
 			}
 
 			if respMsg.CommandDataSetType != 0x0101 {
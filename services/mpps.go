@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// ppsStatusTag is Performed Procedure Step Status (0040,0252)
+var ppsStatusTag = dicom.Tag{Group: 0x0040, Element: 0x0252}
+
+// Performed Procedure Step Status values
+const (
+	PPSInProgress   = "IN PROGRESS"
+	PPSCompleted    = "COMPLETED"
+	PPSDiscontinued = "DISCONTINUED"
+)
+
+// MPPSStore persists Modality Performed Procedure Steps. Create receives the
+// attributes of a new step; Set receives the step's attributes with an
+// N-SET's modifications merged in. An error fails the operation with
+// Processing Failure (0x0110).
+type MPPSStore interface {
+	Create(sopInstanceUID string, ds *dicom.Dataset) error
+	Set(sopInstanceUID string, ds *dicom.Dataset) error
+}
+
+// MPPSService handles Modality Performed Procedure Step N-CREATE and N-SET
+// requests (PS3.4 Annex F.7). Register it for both commands:
+//
+//	mpps := services.NewMPPSService(store)
+//	registry.RegisterHandler(dimse.NCreateRQ, mpps)
+//	registry.RegisterHandler(dimse.NSetRQ, mpps)
+//
+// A step is created IN PROGRESS and updated until an N-SET makes it
+// COMPLETED or DISCONTINUED, after which further N-SETs are refused. The
+// service keeps the attributes of the most recently created steps in
+// progress (see WithInProgressLimit) in memory to merge updates; the most
+// recently finished steps (see WithFinishedLimit) are only remembered by UID.
+type MPPSService struct {
+	store MPPSStore
+
+	mu         sync.Mutex
+	inProgress map[string]*dicom.Dataset
+	// inProgressOrder holds the UIDs in inProgress, oldest first, to
+	// forget the oldest once inProgressLimit is reached
+	inProgressOrder []string
+	inProgressLimit int
+	finished        map[string]bool
+	// finishedOrder holds the UIDs in finished, oldest first, to forget
+	// the oldest once finishedLimit is reached
+	finishedOrder []string
+	finishedLimit int
+}
+
+// DefaultMPPSFinishedLimit is how many finished steps an MPPSService
+// remembers unless WithFinishedLimit says otherwise.
+const DefaultMPPSFinishedLimit = 10000
+
+// DefaultMPPSInProgressLimit is how many steps in progress an MPPSService
+// keeps unless WithInProgressLimit says otherwise.
+const DefaultMPPSInProgressLimit = 1000
+
+// MPPSOption configures an MPPSService.
+type MPPSOption func(*MPPSService)
+
+// WithFinishedLimit sets how many finished steps are remembered to refuse
+// further N-SETs with Processing Failure (0x0110). Older steps are forgotten:
+// an N-SET for one is answered No Such SOP Instance (0x0112) and an N-CREATE
+// reusing its UID is left to the MPPSStore to refuse. Defaults to
+// DefaultMPPSFinishedLimit.
+func WithFinishedLimit(n int) MPPSOption {
+	return func(s *MPPSService) {
+		if n > 0 {
+			s.finishedLimit = n
+		}
+	}
+}
+
+// WithInProgressLimit sets how many steps in progress are kept to merge
+// N-SETs into. Creating a step beyond the limit forgets the oldest step in
+// progress, which modalities that never finish their steps would otherwise
+// pile up: an N-SET for it is answered No Such SOP Instance (0x0112).
+// Defaults to DefaultMPPSInProgressLimit.
+func WithInProgressLimit(n int) MPPSOption {
+	return func(s *MPPSService) {
+		if n > 0 {
+			s.inProgressLimit = n
+		}
+	}
+}
+
+// NewMPPSService creates an MPPS service backed by store.
+func NewMPPSService(store MPPSStore, opts ...MPPSOption) *MPPSService {
+	s := &MPPSService{
+		store:           store,
+		inProgress:      make(map[string]*dicom.Dataset),
+		inProgressLimit: DefaultMPPSInProgressLimit,
+		finished:        make(map[string]bool),
+		finishedLimit:   DefaultMPPSFinishedLimit,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// HandleDIMSE answers an N-CREATE or N-SET on the MPPS SOP class.
+func (s *MPPSService) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	builder := NewResponseBuilder(msg)
+	if sopClass := msg.EffectiveSOPClassUID(); sopClass != types.ModalityPerformedProcedureStepSOPClass {
+		response := builder.NResponse(dimse.StatusSOPClassNotSupported, "")
+		response.ErrorComment = truncateComment("SOP class " + sopClass + " is not MPPS")
+		return response, nil, nil
+	}
+
+	ds := meta.Dataset
+	if ds == nil {
+		var err error
+		if ds, err = dicom.ParseDatasetWithTransferSyntax(data, meta.TransferSyntaxUID); err != nil {
+			response := builder.NResponse(dimse.StatusProcessingFailure, "")
+			response.ErrorComment = "Invalid MPPS attribute list"
+			return response, nil, nil
+		}
+	}
+
+	switch msg.CommandField {
+	case dimse.NCreateRQ:
+		return s.create(msg, ds), nil, nil
+	case dimse.NSetRQ:
+		return s.set(msg, ds), nil, nil
+	}
+	return nil, nil, fmt.Errorf("MPPS service cannot handle command 0x%04x", msg.CommandField)
+}
+
+// create records a new step, assigning its UID when the SCU left it to the SCP
+func (s *MPPSService) create(msg *types.Message, ds *dicom.Dataset) *types.Message {
+	builder := NewResponseBuilder(msg)
+	uid := msg.AffectedSOPInstanceUID
+	if uid == "" {
+		var err error
+		if uid, err = newUID(); err != nil {
+			response := builder.NResponse(dimse.StatusProcessingFailure, "")
+			response.ErrorComment = truncateComment(err.Error())
+			return response
+		}
+	}
+
+	if status := ds.GetString(ppsStatusTag); status != PPSInProgress {
+		response := builder.NResponse(dimse.StatusInvalidAttributeValue, uid)
+		response.OffendingElements = []types.Tag{{Group: ppsStatusTag.Group, Element: ppsStatusTag.Element}}
+		response.ErrorComment = truncateComment(fmt.Sprintf("Status %q must be %s on N-CREATE", status, PPSInProgress))
+		return response
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.inProgress[uid]; exists || s.finished[uid] {
+		return builder.NResponse(dimse.StatusDuplicateSOPInstance, uid)
+	}
+	if err := s.store.Create(uid, ds); err != nil {
+		response := builder.NResponse(dimse.StatusProcessingFailure, uid)
+		response.ErrorComment = truncateComment(err.Error())
+		return response
+	}
+	s.start(uid, ds)
+	return builder.NResponse(dimse.StatusSuccess, uid)
+}
+
+// set merges an N-SET's modifications into a step in progress
+func (s *MPPSService) set(msg *types.Message, modifications *dicom.Dataset) *types.Message {
+	builder := NewResponseBuilder(msg)
+	uid := msg.RequestedSOPInstanceUID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.inProgress[uid]
+	if !ok {
+		if s.finished[uid] {
+			// PS3.4 F.7.2.2.2: a finished step may no longer be updated
+			response := builder.NResponse(dimse.StatusProcessingFailure, uid)
+			response.ErrorComment = "Performed Procedure Step may no longer be updated"
+			return response
+		}
+		return builder.NResponse(dimse.StatusNoSuchSOPInstance, uid)
+	}
+
+	status := current.GetString(ppsStatusTag)
+	if _, ok := modifications.GetElement(ppsStatusTag); ok {
+		status = modifications.GetString(ppsStatusTag)
+	}
+	if status != PPSInProgress && status != PPSCompleted && status != PPSDiscontinued {
+		response := builder.NResponse(dimse.StatusInvalidAttributeValue, uid)
+		response.OffendingElements = []types.Tag{{Group: ppsStatusTag.Group, Element: ppsStatusTag.Element}}
+		response.ErrorComment = truncateComment(fmt.Sprintf("Invalid status %q", status))
+		return response
+	}
+
+	// Each attribute of the modification list, sequences included, replaces
+	// the step's attribute of the same tag
+	merged := dicom.NewDataset()
+	for tag, element := range current.Elements {
+		merged.Elements[tag] = element
+	}
+	for tag, element := range modifications.Elements {
+		merged.Elements[tag] = element
+	}
+
+	if err := s.store.Set(uid, merged); err != nil {
+		response := builder.NResponse(dimse.StatusProcessingFailure, uid)
+		response.ErrorComment = truncateComment(err.Error())
+		return response
+	}
+
+	if status == PPSInProgress {
+		s.inProgress[uid] = merged
+	} else {
+		s.finish(uid)
+	}
+	return builder.NResponse(dimse.StatusSuccess, uid)
+}
+
+// start keeps a new step in progress, forgetting the oldest step in progress
+// once inProgressLimit is reached. The caller holds s.mu.
+func (s *MPPSService) start(uid string, ds *dicom.Dataset) {
+	if len(s.inProgressOrder) >= s.inProgressLimit {
+		delete(s.inProgress, s.inProgressOrder[0])
+		s.inProgressOrder = s.inProgressOrder[1:]
+	}
+	s.inProgress[uid] = ds
+	s.inProgressOrder = append(s.inProgressOrder, uid)
+}
+
+// finish moves uid from the steps in progress to the finished ones,
+// forgetting the oldest finished step once finishedLimit is reached. The
+// caller holds s.mu.
+func (s *MPPSService) finish(uid string) {
+	delete(s.inProgress, uid)
+	for i, inProgress := range s.inProgressOrder {
+		if inProgress == uid {
+			s.inProgressOrder = append(s.inProgressOrder[:i], s.inProgressOrder[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.finishedOrder) >= s.finishedLimit {
+		delete(s.finished, s.finishedOrder[0])
+		s.finishedOrder = s.finishedOrder[1:]
+	}
+	s.finished[uid] = true
+	s.finishedOrder = append(s.finishedOrder, uid)
+}
+
+// uidRandom is the source of the random numbers newUID derives UIDs from
+var uidRandom io.Reader = rand.Reader
+
+// newUID returns a UID derived from a random UUID (PS3.5 B.2)
+func newUID() (string, error) {
+	n, err := rand.Int(uidRandom, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate UID: %w", err)
+	}
+	return "2.25." + n.String(), nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+var (
+	ppsEndDateTag           = dicom.Tag{Group: 0x0040, Element: 0x0250}
+	performedSeriesSeqTag   = dicom.Tag{Group: 0x0040, Element: 0x0340}
+	seriesInstanceUIDTag    = dicom.Tag{Group: 0x0020, Element: 0x000E}
+	scheduledStepAttrSeqTag = dicom.Tag{Group: 0x0040, Element: 0x0270}
+)
+
+// recordingMPPSStore records the datasets passed to each callback
+type recordingMPPSStore struct {
+	created map[string]*dicom.Dataset
+	set     map[string]*dicom.Dataset
+	err     error
+}
+
+func newRecordingMPPSStore() *recordingMPPSStore {
+	return &recordingMPPSStore{
+		created: make(map[string]*dicom.Dataset),
+		set:     make(map[string]*dicom.Dataset),
+	}
+}
+
+func (s *recordingMPPSStore) Create(uid string, ds *dicom.Dataset) error {
+	s.created[uid] = ds
+	return s.err
+}
+
+func (s *recordingMPPSStore) Set(uid string, ds *dicom.Dataset) error {
+	s.set[uid] = ds
+	return s.err
+}
+
+// sendMPPS encodes ds in Implicit VR, as it arrives on the wire, and passes
+// it to the service
+func sendMPPS(t *testing.T, service *MPPSService, msg *types.Message, ds *dicom.Dataset) *types.Message {
+	t.Helper()
+	data, err := dicom.EncodeDatasetWithTransferSyntax(ds, types.ImplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("failed to encode attribute list: %v", err)
+	}
+	response, responseDataset, err := service.HandleDIMSE(context.Background(), msg, data, interfaces.MessageContext{
+		TransferSyntaxUID: types.ImplicitVRLittleEndian,
+	})
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if responseDataset != nil {
+		t.Errorf("HandleDIMSE() returned a dataset")
+	}
+	return response
+}
+
+func nCreate(uid string) *types.Message {
+	return &types.Message{
+		CommandField:           dimse.NCreateRQ,
+		MessageID:              1,
+		AffectedSOPClassUID:    types.ModalityPerformedProcedureStepSOPClass,
+		AffectedSOPInstanceUID: uid,
+		CommandDataSetType:     0x0000,
+	}
+}
+
+func nSet(uid string) *types.Message {
+	return &types.Message{
+		CommandField:            dimse.NSetRQ,
+		MessageID:               2,
+		RequestedSOPClassUID:    types.ModalityPerformedProcedureStepSOPClass,
+		RequestedSOPInstanceUID: uid,
+		CommandDataSetType:      0x0000,
+	}
+}
+
+func inProgressStep() *dicom.Dataset {
+	scheduled := dicom.NewDataset()
+	scheduled.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0050}, dicom.VR_SH, "ACC1")
+	ds := dicom.NewDataset()
+	ds.AddElement(patientIDTag, dicom.VR_LO, "PID1")
+	ds.AddElement(modalityTag, dicom.VR_CS, "CT")
+	ds.AddElement(ppsStatusTag, dicom.VR_CS, PPSInProgress)
+	ds.AddElement(scheduledStepAttrSeqTag, dicom.VR_SQ, []*dicom.Dataset{scheduled})
+	ds.AddElement(performedSeriesSeqTag, dicom.VR_SQ, []*dicom.Dataset{})
+	return ds
+}
+
+func TestMPPSService_CreateThenSet(t *testing.T) {
+	store := newRecordingMPPSStore()
+	service := NewMPPSService(store)
+	const uid = "1.2.3.4"
+
+	response := sendMPPS(t, service, nCreate(uid), inProgressStep())
+	if response.CommandField != dimse.NCreateRSP || response.Status != dimse.StatusSuccess {
+		t.Fatalf("N-CREATE response = 0x%04x status 0x%04x, want N-CREATE-RSP Success", response.CommandField, response.Status)
+	}
+	if response.AffectedSOPInstanceUID != uid || response.AffectedSOPClassUID != types.ModalityPerformedProcedureStepSOPClass {
+		t.Errorf("N-CREATE response names %s / %s", response.AffectedSOPClassUID, response.AffectedSOPInstanceUID)
+	}
+	created := store.created[uid]
+	if created == nil || created.GetString(ppsStatusTag) != PPSInProgress {
+		t.Fatalf("Create() dataset = %v, want the IN PROGRESS step", created)
+	}
+	if items, _ := created.Elements[scheduledStepAttrSeqTag].Value.([]*dicom.Dataset); len(items) != 1 {
+		t.Errorf("Scheduled Step Attributes Sequence = %v, want one item", created.Elements[scheduledStepAttrSeqTag].Value)
+	}
+
+	series := dicom.NewDataset()
+	series.AddElement(seriesInstanceUIDTag, dicom.VR_UI, "1.2.3.4.1")
+	modifications := dicom.NewDataset()
+	modifications.AddElement(ppsStatusTag, dicom.VR_CS, PPSCompleted)
+	modifications.AddElement(ppsEndDateTag, dicom.VR_DA, "20250101")
+	modifications.AddElement(performedSeriesSeqTag, dicom.VR_SQ, []*dicom.Dataset{series})
+
+	response = sendMPPS(t, service, nSet(uid), modifications)
+	if response.CommandField != dimse.NSetRSP || response.Status != dimse.StatusSuccess {
+		t.Fatalf("N-SET response = 0x%04x status 0x%04x, want N-SET-RSP Success", response.CommandField, response.Status)
+	}
+	if response.AffectedSOPInstanceUID != uid || response.AffectedSOPClassUID != types.ModalityPerformedProcedureStepSOPClass {
+		t.Errorf("N-SET response names %s / %s", response.AffectedSOPClassUID, response.AffectedSOPInstanceUID)
+	}
+
+	merged := store.set[uid]
+	if merged == nil {
+		t.Fatal("Set() was not called")
+	}
+	for tag, want := range map[dicom.Tag]string{
+		patientIDTag:  "PID1",
+		modalityTag:   "CT",
+		ppsStatusTag:  PPSCompleted,
+		ppsEndDateTag: "20250101",
+	} {
+		if got := merged.GetString(tag); got != want {
+			t.Errorf("Set() dataset %s = %q, want %q", tag, got, want)
+		}
+	}
+	items, _ := merged.Elements[performedSeriesSeqTag].Value.([]*dicom.Dataset)
+	if len(items) != 1 || items[0].GetString(seriesInstanceUIDTag) != "1.2.3.4.1" {
+		t.Errorf("Performed Series Sequence = %v, want the series from the N-SET", merged.Elements[performedSeriesSeqTag].Value)
+	}
+
+	// A completed step may no longer be updated
+	response = sendMPPS(t, service, nSet(uid), modifications)
+	if response.Status != dimse.StatusProcessingFailure {
+		t.Errorf("N-SET after COMPLETED status = 0x%04x, want 0x0110", response.Status)
+	}
+}
+
+func TestMPPSService_Failures(t *testing.T) {
+	store := newRecordingMPPSStore()
+	service := NewMPPSService(store)
+
+	completed := inProgressStep()
+	completed.AddElement(ppsStatusTag, dicom.VR_CS, PPSCompleted)
+	if response := sendMPPS(t, service, nCreate("1.1"), completed); response.Status != dimse.StatusInvalidAttributeValue {
+		t.Errorf("N-CREATE COMPLETED status = 0x%04x, want 0x0106", response.Status)
+	}
+
+	if response := sendMPPS(t, service, nSet("9.9"), inProgressStep()); response.Status != dimse.StatusNoSuchSOPInstance {
+		t.Errorf("N-SET of unknown step status = 0x%04x, want 0x0112", response.Status)
+	}
+
+	sendMPPS(t, service, nCreate("1.2"), inProgressStep())
+	if response := sendMPPS(t, service, nCreate("1.2"), inProgressStep()); response.Status != dimse.StatusDuplicateSOPInstance {
+		t.Errorf("duplicate N-CREATE status = 0x%04x, want 0x0111", response.Status)
+	}
+
+	invalid := dicom.NewDataset()
+	invalid.AddElement(ppsStatusTag, dicom.VR_CS, "SCHEDULED")
+	if response := sendMPPS(t, service, nSet("1.2"), invalid); response.Status != dimse.StatusInvalidAttributeValue {
+		t.Errorf("N-SET SCHEDULED status = 0x%04x, want 0x0106", response.Status)
+	}
+
+	store.err = errors.New("database unavailable")
+	response := sendMPPS(t, service, nCreate("1.3"), inProgressStep())
+	if response.Status != dimse.StatusProcessingFailure || !strings.Contains(response.ErrorComment, "database unavailable") {
+		t.Errorf("N-CREATE with failing store = 0x%04x %q, want 0x0110 with the error", response.Status, response.ErrorComment)
+	}
+}
+
+func TestMPPSService_AssignsInstanceUID(t *testing.T) {
+	store := newRecordingMPPSStore()
+	response := sendMPPS(t, NewMPPSService(store), nCreate(""), inProgressStep())
+	if response.Status != dimse.StatusSuccess || !strings.HasPrefix(response.AffectedSOPInstanceUID, "2.25.") {
+		t.Fatalf("N-CREATE response = 0x%04x %q, want Success with a generated UID", response.Status, response.AffectedSOPInstanceUID)
+	}
+	if store.created[response.AffectedSOPInstanceUID] == nil {
+		t.Errorf("Create() not called with the generated UID %s", response.AffectedSOPInstanceUID)
+	}
+}
+
+func TestMPPSService_FinishedLimit(t *testing.T) {
+	service := NewMPPSService(newRecordingMPPSStore(), WithFinishedLimit(2))
+	discontinued := dicom.NewDataset()
+	discontinued.AddElement(ppsStatusTag, dicom.VR_CS, PPSDiscontinued)
+	for _, uid := range []string{"1.1", "1.2", "1.3"} {
+		sendMPPS(t, service, nCreate(uid), inProgressStep())
+		if response := sendMPPS(t, service, nSet(uid), discontinued); response.Status != dimse.StatusSuccess {
+			t.Fatalf("N-SET DISCONTINUED %s status = 0x%04x, want Success", uid, response.Status)
+		}
+	}
+
+	if len(service.finished) != 2 || len(service.finishedOrder) != 2 {
+		t.Errorf("Remembered %d finished steps, want 2", len(service.finished))
+	}
+	// The oldest step is forgotten, the others are still refused
+	if response := sendMPPS(t, service, nSet("1.1"), discontinued); response.Status != dimse.StatusNoSuchSOPInstance {
+		t.Errorf("N-SET of forgotten step status = 0x%04x, want 0x0112", response.Status)
+	}
+	for _, uid := range []string{"1.2", "1.3"} {
+		if response := sendMPPS(t, service, nSet(uid), discontinued); response.Status != dimse.StatusProcessingFailure {
+			t.Errorf("N-SET of finished step %s status = 0x%04x, want 0x0110", uid, response.Status)
+		}
+	}
+}
+
+func TestMPPSService_InProgressLimit(t *testing.T) {
+	service := NewMPPSService(newRecordingMPPSStore(), WithInProgressLimit(2))
+	completed := dicom.NewDataset()
+	completed.AddElement(ppsStatusTag, dicom.VR_CS, PPSCompleted)
+
+	sendMPPS(t, service, nCreate("1.1"), inProgressStep())
+	sendMPPS(t, service, nCreate("1.2"), inProgressStep())
+	// Finishing a step makes room for another
+	if response := sendMPPS(t, service, nSet("1.2"), completed); response.Status != dimse.StatusSuccess {
+		t.Fatalf("N-SET COMPLETED status = 0x%04x, want Success", response.Status)
+	}
+	sendMPPS(t, service, nCreate("1.3"), inProgressStep())
+	// The oldest step in progress is forgotten
+	sendMPPS(t, service, nCreate("1.4"), inProgressStep())
+
+	if len(service.inProgress) != 2 || len(service.inProgressOrder) != 2 {
+		t.Errorf("Kept %d steps in progress, want 2", len(service.inProgress))
+	}
+	if response := sendMPPS(t, service, nSet("1.1"), completed); response.Status != dimse.StatusNoSuchSOPInstance {
+		t.Errorf("N-SET of forgotten step status = 0x%04x, want 0x0112", response.Status)
+	}
+	for _, uid := range []string{"1.3", "1.4"} {
+		if response := sendMPPS(t, service, nSet(uid), completed); response.Status != dimse.StatusSuccess {
+			t.Errorf("N-SET of step %s in progress status = 0x%04x, want Success", uid, response.Status)
+		}
+	}
+}
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy exhausted")
+}
+
+func TestMPPSService_UIDGenerationFailure(t *testing.T) {
+	uidRandom = failingReader{}
+	defer func() { uidRandom = rand.Reader }()
+
+	store := newRecordingMPPSStore()
+	response := sendMPPS(t, NewMPPSService(store), nCreate(""), inProgressStep())
+	if response.Status != dimse.StatusProcessingFailure || !strings.Contains(response.ErrorComment, "entropy exhausted") {
+		t.Errorf("N-CREATE response = 0x%04x %q, want 0x0110 with the error", response.Status, response.ErrorComment)
+	}
+	if len(store.created) != 0 {
+		t.Errorf("Create() called without a UID: %v", store.created)
+	}
+}
@@ -16,6 +16,10 @@ import (
 // service handler based on the command field. It supports both single-response
 // and streaming (multi-response) operations.
 //
+// Handlers are keyed by command field, not SOP class, so one C-FIND handler
+// serves every Query/Retrieve information model the server accepts. Handlers
+// tell the models apart by MessageContext.AbstractSyntaxUID.
+//
 // Example usage:
 //
 //	registry := services.NewRegistry()
@@ -58,7 +62,8 @@ func (r *Registry) RegisterHandler(commandField uint16, handler interfaces.Servi
 // UnregisterHandler removes a service handler for a specific DIMSE command.
 //
 // After unregistering, messages with this command field will result in
-// an "unsupported command" error.
+// an "unsupported command" error. A dimse.Service in front of the registry
+// answers them with a failure response instead of calling it.
 func (r *Registry) UnregisterHandler(commandField uint16) {
 	delete(r.handlers, commandField)
 }
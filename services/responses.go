@@ -1,10 +1,28 @@
 package services
 
 import (
+	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
 	"github.com/caio-sobreiro/dicomnet/types"
 )
 
+// retrieveAETitleTag is Retrieve AE Title (0008,0054)
+var retrieveAETitleTag = dicom.Tag{Group: 0x0008, Element: 0x0054}
+
+// SetRetrieveAETitle sets the Retrieve AE Title (0008,0054) of a C-FIND
+// match, telling the SCU which AE to C-MOVE or C-GET it from. A Retrieve AE
+// Title the match already has is kept, as is the match when aeTitle is empty.
+func SetRetrieveAETitle(match *dicom.Dataset, aeTitle string) {
+	if match == nil || aeTitle == "" {
+		return
+	}
+	if _, ok := match.GetElement(retrieveAETitleTag); ok {
+		return
+	}
+	match.AddElement(retrieveAETitleTag, dicom.VR_AE, aeTitle)
+}
+
 // ResponseBuilder provides convenient methods for creating standard DIMSE response messages.
 //
 // These builders ensure that response messages are properly formatted according to the
@@ -26,13 +44,18 @@ func NewResponseBuilder(request *types.Message) *ResponseBuilder {
 // Parameters:
 //   - status: The response status (typically dimse.StatusSuccess)
 //
-// Returns a C-ECHO-RSP message with no dataset.
+// Returns a C-ECHO-RSP message with no dataset, echoing the request's
+// Affected SOP Class UID, or Verification if the request had none.
 func (b *ResponseBuilder) CEchoResponse(status uint16) *types.Message {
+	sopClassUID := b.request.AffectedSOPClassUID
+	if sopClassUID == "" {
+		sopClassUID = types.VerificationSOPClass
+	}
 	return &types.Message{
 		CommandField:              dimse.CEchoRSP,
 		MessageIDBeingRespondedTo: b.request.MessageID,
-		AffectedSOPClassUID:       types.VerificationSOPClass, // Verification SOP Class
-		CommandDataSetType:        0x0101,                     // No Data Set Present
+		AffectedSOPClassUID:       sopClassUID,
+		CommandDataSetType:        0x0101, // No Data Set Present
 		Status:                    status,
 	}
 }
@@ -60,6 +83,13 @@ func (b *ResponseBuilder) CFindResponse(status uint16, hasDataset bool) *types.M
 	}
 }
 
+// CFindMatch creates a pending C-FIND-RSP for match, first setting its
+// Retrieve AE Title to retrieveAETitle unless it has one.
+func (b *ResponseBuilder) CFindMatch(match *dicom.Dataset, retrieveAETitle string) *types.Message {
+	SetRetrieveAETitle(match, retrieveAETitle)
+	return b.CFindResponse(dimse.StatusPending, true)
+}
+
 // CMoveResponse creates a C-MOVE-RSP message with sub-operation counts.
 //
 // Parameters:
@@ -131,6 +161,36 @@ func (b *ResponseBuilder) CStoreResponse(status uint16, sopInstanceUID string) *
 	}
 }
 
+// NResponse creates the response to a DIMSE-N request (N-CREATE, N-SET,
+// N-GET, N-ACTION, N-DELETE or N-EVENT-REPORT).
+//
+// Parameters:
+//   - status: The response status
+//   - sopInstanceUID: The Affected SOP Instance UID (optional, will use the request's if empty)
+//
+// The Affected SOP Class UID echoes the request's Affected or Requested SOP
+// Class UID, and the Event or Action Type ID the request's. The response has
+// no dataset.
+func (b *ResponseBuilder) NResponse(status uint16, sopInstanceUID string) *types.Message {
+	if sopInstanceUID == "" {
+		sopInstanceUID = b.request.AffectedSOPInstanceUID
+	}
+	if sopInstanceUID == "" {
+		sopInstanceUID = b.request.RequestedSOPInstanceUID
+	}
+
+	return &types.Message{
+		CommandField:              b.request.CommandField | 0x8000,
+		MessageIDBeingRespondedTo: b.request.MessageID,
+		AffectedSOPClassUID:       b.request.EffectiveSOPClassUID(),
+		AffectedSOPInstanceUID:    sopInstanceUID,
+		EventTypeID:               b.request.EventTypeID,
+		ActionTypeID:              b.request.ActionTypeID,
+		CommandDataSetType:        0x0101, // No Data Set Present
+		Status:                    status,
+	}
+}
+
 // Helper functions for creating responses without a builder instance
 
 // NewCEchoResponse creates a C-ECHO-RSP message from a request.
@@ -143,6 +203,12 @@ func NewCFindPendingResponse(request *types.Message) *types.Message {
 	return NewResponseBuilder(request).CFindResponse(dimse.StatusPending, true)
 }
 
+// NewCFindMatchResponse creates a pending C-FIND-RSP for match, setting its
+// Retrieve AE Title to the server's AE title from meta unless it has one.
+func NewCFindMatchResponse(request *types.Message, match *dicom.Dataset, meta interfaces.MessageContext) *types.Message {
+	return NewResponseBuilder(request).CFindMatch(match, meta.AETitle)
+}
+
 // NewCFindSuccessResponse creates a final success C-FIND-RSP message (no dataset).
 func NewCFindSuccessResponse(request *types.Message) *types.Message {
 	return NewResponseBuilder(request).CFindResponse(dimse.StatusSuccess, false)
@@ -3,7 +3,9 @@ package services
 import (
 	"testing"
 
+	"github.com/caio-sobreiro/dicomnet/dicom"
 	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
 	"github.com/caio-sobreiro/dicomnet/types"
 )
 
@@ -342,3 +344,31 @@ func TestNewCStoreResponse(t *testing.T) {
 		t.Errorf("Status = 0x%04x, want success", response.Status)
 	}
 }
+
+func TestNewCFindMatchResponse(t *testing.T) {
+	request := &types.Message{
+		CommandField:        dimse.CFindRQ,
+		MessageID:           4,
+		AffectedSOPClassUID: types.StudyRootQueryRetrieveInformationModelFind,
+	}
+	retrieveAETitle := dicom.Tag{Group: 0x0008, Element: 0x0054}
+
+	match := dicom.NewDataset()
+	match.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0052}, dicom.VR_CS, "STUDY")
+	match.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2.3")
+
+	response := NewCFindMatchResponse(request, match, interfaces.MessageContext{AETitle: "QR_SCP"})
+	if response.Status != dimse.StatusPending || response.CommandDataSetType != 0x0000 {
+		t.Errorf("Response = %+v, want pending with a dataset", response)
+	}
+	if got := match.GetString(retrieveAETitle); got != "QR_SCP" {
+		t.Errorf("Retrieve AE Title = %q, want QR_SCP", got)
+	}
+
+	// A Retrieve AE Title set by the handler is kept
+	match.AddElement(retrieveAETitle, dicom.VR_AE, "ARCHIVE")
+	NewCFindMatchResponse(request, match, interfaces.MessageContext{AETitle: "QR_SCP"})
+	if got := match.GetString(retrieveAETitle); got != "ARCHIVE" {
+		t.Errorf("Retrieve AE Title = %q, want the handler's ARCHIVE", got)
+	}
+}
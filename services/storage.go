@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// DuplicatePolicy decides what StorageService does when it receives an
+// instance whose SOP Instance UID it already holds.
+type DuplicatePolicy int
+
+const (
+	// DuplicateOverwrite replaces the stored instance (default).
+	DuplicateOverwrite DuplicatePolicy = iota
+	// DuplicateSkip keeps the stored instance and answers with a warning
+	// (0xB000), so the SCU treats the instance as stored.
+	DuplicateSkip
+	// DuplicateReject keeps the stored instance and answers with a
+	// Cannot Understand failure (0xC000) whose Error Comment names the
+	// duplicate; Duplicate SOP Instance (0x0111) is a DIMSE-N status that
+	// C-STORE does not define.
+	DuplicateReject
+)
+
+// StorageOption configures a StorageService.
+type StorageOption func(*StorageService)
+
+// WithDuplicatePolicy sets how instances that are already stored are handled.
+func WithDuplicatePolicy(policy DuplicatePolicy) StorageOption {
+	return func(s *StorageService) {
+		s.duplicatePolicy = policy
+	}
+}
+
+// WithPixelDataValidation makes the service parse each instance and reject
+// those whose native Pixel Data length does not match the image geometry
+// with 0xA900, instead of storing a corrupt instance.
+func WithPixelDataValidation() StorageOption {
+	return func(s *StorageService) {
+		s.validatePixelData = true
+	}
+}
+
+// WithIODValidation makes the service parse each instance and reject those
+// missing attributes every composite instance requires, such as the Study
+// and Series Instance UIDs (see dicom.ValidateMinimalIOD), with 0xA900.
+func WithIODValidation() StorageOption {
+	return func(s *StorageService) {
+		s.validateIOD = true
+	}
+}
+
+// WithCoercion makes the service parse each instance and pass it to coerce,
+// e.g. to rewrite Patient ID into the local namespace. When coerce reports
+// that it changed the dataset, the modified dataset is stored and the SCU is
+// answered with Coercion of Data Elements (0xB000).
+func WithCoercion(coerce func(ds *dicom.Dataset) (coerced bool)) StorageOption {
+	return func(s *StorageService) {
+		s.coerce = coerce
+	}
+}
+
+// WithReject makes the service consult reject before storing each instance,
+// e.g. to quarantine UIDs from a known-corrupt source. A rejected instance is
+// not written and is answered with the returned status, or with Refused: Out
+// of Resources (0xA700) if that status is not a failure.
+func WithReject(reject func(sopInstanceUID, sopClassUID string) (reject bool, status uint16)) StorageOption {
+	return func(s *StorageService) {
+		s.reject = reject
+	}
+}
+
+// StorageService handles C-STORE requests by writing each instance to a
+// directory as a Part 10 file named after its SOP Instance UID.
+type StorageService struct {
+	dir               string
+	duplicatePolicy   DuplicatePolicy
+	validatePixelData bool
+	validateIOD       bool
+	coerce            func(ds *dicom.Dataset) bool
+	reject            func(sopInstanceUID, sopClassUID string) (bool, uint16)
+
+	// mu serialises the existence check and write of each instance
+	mu sync.Mutex
+}
+
+// NewStorageService creates a C-STORE service storing instances in dir,
+// which must exist.
+func NewStorageService(dir string, opts ...StorageOption) *StorageService {
+	s := &StorageService{dir: dir}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Path returns the file an instance with the given SOP Instance UID is
+// stored in.
+func (s *StorageService) Path(sopInstanceUID string) string {
+	return filepath.Join(s.dir, sopInstanceUID+".dcm")
+}
+
+// HandleDIMSE stores the dataset of a C-STORE request.
+//
+// Storage problems are reported to the SCU through the response status
+// rather than as an error, so the association stays usable.
+//
+// This method implements the interfaces.ServiceHandler interface.
+func (s *StorageService) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	if msg.CommandField != dimse.CStoreRQ {
+		return nil, nil, fmt.Errorf("storage service cannot handle command 0x%04x", msg.CommandField)
+	}
+
+	response := &types.Message{
+		CommandField:              dimse.CStoreRSP,
+		MessageIDBeingRespondedTo: msg.MessageID,
+		AffectedSOPClassUID:       msg.AffectedSOPClassUID,
+		AffectedSOPInstanceUID:    msg.AffectedSOPInstanceUID,
+		CommandDataSetType:        0x0101,
+		Status:                    dimse.StatusSuccess,
+	}
+
+	sopClassUID, sopInstanceUID := msg.AffectedSOPClassUID, msg.AffectedSOPInstanceUID
+	if sopClassUID == "" || sopInstanceUID == "" {
+		sopClassUID, sopInstanceUID = datasetUIDs(data, meta.TransferSyntaxUID, sopClassUID, sopInstanceUID)
+		if sopInstanceUID == "" {
+			response.Status = dimse.StatusDataSetDoesNotMatch
+			response.ErrorComment = "No SOP Instance UID in command or dataset"
+			return response, nil, nil
+		}
+		slog.WarnContext(ctx, "C-STORE command omits SOP UIDs, using the dataset's",
+			"sop_instance", sopInstanceUID,
+			"sop_class", sopClassUID)
+		response.AffectedSOPClassUID = sopClassUID
+		response.AffectedSOPInstanceUID = sopInstanceUID
+	}
+	if strings.ContainsAny(sopInstanceUID, `/\`) || strings.Trim(sopInstanceUID, ".") == "" {
+		response.Status = dimse.StatusFailure
+		response.ErrorComment = "Invalid SOP Instance UID"
+		return response, nil, nil
+	}
+
+	if s.reject != nil {
+		if reject, status := s.reject(sopInstanceUID, sopClassUID); reject {
+			if !dimse.IsFailureStatus(status) {
+				status = dimse.StatusOutOfResources
+			}
+			slog.WarnContext(ctx, "Rejecting blocked instance",
+				"sop_instance", sopInstanceUID,
+				"sop_class", sopClassUID,
+				"status", fmt.Sprintf("0x%04x", status))
+			response.Status = status
+			response.ErrorComment = "SOP Instance rejected"
+			return response, nil, nil
+		}
+	}
+
+	if s.validatePixelData {
+		if err := validatePixelData(data, meta.TransferSyntaxUID); err != nil {
+			slog.WarnContext(ctx, "Rejecting instance with invalid pixel data",
+				"sop_instance", sopInstanceUID,
+				"error", err)
+			response.Status = dimse.StatusDataSetDoesNotMatch
+			response.ErrorComment = truncateComment(err.Error())
+			return response, nil, nil
+		}
+	}
+
+	if s.validateIOD {
+		if errs := validateIOD(data, meta.TransferSyntaxUID, sopClassUID); len(errs) > 0 {
+			slog.WarnContext(ctx, "Rejecting instance missing required attributes",
+				"sop_instance", sopInstanceUID,
+				"errors", errors.Join(errs...))
+			response.Status = dimse.StatusDataSetDoesNotMatch
+			response.ErrorComment = truncateComment(errs[0].Error())
+			response.OffendingElements = offendingElements(errs)
+			return response, nil, nil
+		}
+	}
+
+	coerced := false
+	if s.coerce != nil {
+		var err error
+		data, coerced, err = s.coerceDataset(data, meta.TransferSyntaxUID)
+		if err != nil {
+			slog.WarnContext(ctx, "Rejecting instance that could not be coerced",
+				"sop_instance", sopInstanceUID,
+				"error", err)
+			response.Status = dimse.StatusFailure
+			response.ErrorComment = truncateComment(err.Error())
+			return response, nil, nil
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.Path(sopInstanceUID)
+	if _, err := os.Stat(path); err == nil {
+		switch s.duplicatePolicy {
+		case DuplicateSkip:
+			slog.InfoContext(ctx, "Instance already stored, keeping existing copy",
+				"sop_instance", sopInstanceUID)
+			response.Status = dimse.StatusCoercionOfDataElements
+			response.ErrorComment = "Duplicate SOP Instance not stored again"
+			return response, nil, nil
+		case DuplicateReject:
+			slog.WarnContext(ctx, "Rejecting duplicate instance",
+				"sop_instance", sopInstanceUID)
+			response.Status = dimse.StatusFailure
+			response.ErrorComment = "Duplicate SOP Instance"
+			return response, nil, nil
+		}
+	}
+
+	file := dicom.EncodePart10(data, sopClassUID, sopInstanceUID, meta.TransferSyntaxUID)
+	if err := writeFileAtomic(path, file); err != nil {
+		slog.ErrorContext(ctx, "Failed to store instance",
+			"sop_instance", sopInstanceUID,
+			"error", err)
+		response.Status = dimse.StatusFailure
+		response.ErrorComment = "Failed to store instance"
+		return response, nil, nil
+	}
+
+	if coerced {
+		response.Status = dimse.StatusCoercionOfDataElements
+		response.ErrorComment = "Data elements coerced"
+	}
+
+	slog.InfoContext(ctx, "Stored instance",
+		"sop_instance", sopInstanceUID,
+		"path", path,
+		"coerced", coerced)
+	return response, nil, nil
+}
+
+// datasetUIDs fills in the SOP Class UID (0008,0016) and SOP Instance UID
+// (0008,0018) from the dataset when the command left them empty
+func datasetUIDs(data []byte, transferSyntax, sopClassUID, sopInstanceUID string) (string, string) {
+	ds, err := dicom.ParseDatasetWithTransferSyntax(data, transferSyntax)
+	if err != nil {
+		return sopClassUID, sopInstanceUID
+	}
+	if sopClassUID == "" {
+		sopClassUID = ds.GetString(dicom.Tag{Group: 0x0008, Element: 0x0016})
+	}
+	if sopInstanceUID == "" {
+		sopInstanceUID = ds.GetString(dicom.Tag{Group: 0x0008, Element: 0x0018})
+	}
+	return sopClassUID, sopInstanceUID
+}
+
+// coerceDataset applies the coercion function to a received dataset,
+// re-encoding it only if it was changed
+func (s *StorageService) coerceDataset(data []byte, transferSyntax string) ([]byte, bool, error) {
+	ds, err := dicom.ParseDatasetWithTransferSyntax(data, transferSyntax)
+	if err != nil {
+		return nil, false, err
+	}
+	if !s.coerce(ds) {
+		return data, false, nil
+	}
+	encoded, err := dicom.EncodeDatasetWithTransferSyntax(ds, transferSyntax)
+	if err != nil {
+		return nil, false, err
+	}
+	return encoded, true, nil
+}
+
+// validatePixelData parses a received dataset and checks its pixel data
+// against the image geometry
+func validatePixelData(data []byte, transferSyntax string) error {
+	ds, err := dicom.ParseDatasetWithTransferSyntax(data, transferSyntax)
+	if err != nil {
+		return err
+	}
+	return dicom.ValidatePixelData(ds, transferSyntax)
+}
+
+func validateIOD(data []byte, transferSyntax, sopClassUID string) []error {
+	ds, err := dicom.ParseDatasetWithTransferSyntax(data, transferSyntax)
+	if err != nil {
+		return []error{err}
+	}
+	return dicom.ValidateMinimalIOD(ds, sopClassUID)
+}
+
+// offendingElements lists the tags of missing or empty attributes
+func offendingElements(errs []error) []types.Tag {
+	var tags []types.Tag
+	for _, err := range errs {
+		var missing *dicom.MissingAttributeError
+		if errors.As(err, &missing) {
+			tags = append(tags, types.Tag{Group: missing.Tag.Group, Element: missing.Tag.Element})
+		}
+	}
+	return tags
+}
+
+// truncateComment fits a message into an Error Comment, which is an LO of at
+// most 64 characters
+func truncateComment(comment string) string {
+	if len(comment) > 64 {
+		return comment[:64]
+	}
+	return comment
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it
+// into place, so a failed write never leaves a partial instance behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".store-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
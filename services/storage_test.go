@@ -0,0 +1,336 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+func storeRequest(messageID uint16, sopInstanceUID string) *types.Message {
+	return &types.Message{
+		CommandField:           dimse.CStoreRQ,
+		MessageID:              messageID,
+		AffectedSOPClassUID:    types.CTImageStorage,
+		AffectedSOPInstanceUID: sopInstanceUID,
+		CommandDataSetType:     0x0000,
+	}
+}
+
+func storeMeta() interfaces.MessageContext {
+	return interfaces.MessageContext{
+		PresentationContextID: 1,
+		TransferSyntaxUID:     types.ExplicitVRLittleEndian,
+	}
+}
+
+func TestStorageService_StoresPart10File(t *testing.T) {
+	service := NewStorageService(t.TempDir())
+
+	data := []byte{0x08, 0x00, 0x18, 0x00, 'U', 'I', 0x04, 0x00, '1', '.', '2', 0x00}
+	resp, _, err := service.HandleDIMSE(context.Background(), storeRequest(1, "1.2"), data, storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess || resp.AffectedSOPInstanceUID != "1.2" {
+		t.Fatalf("Response = %+v, want success for 1.2", resp)
+	}
+
+	file, err := os.ReadFile(service.Path("1.2"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	dataset, transferSyntax, err := dicom.SplitPart10(file)
+	if err != nil {
+		t.Fatalf("SplitPart10() error = %v", err)
+	}
+	if transferSyntax != types.ExplicitVRLittleEndian || !bytes.Equal(dataset, data) {
+		t.Errorf("Stored %s % X, want %s % X", transferSyntax, dataset, types.ExplicitVRLittleEndian, data)
+	}
+}
+
+func TestStorageService_DuplicatePolicies(t *testing.T) {
+	first := []byte{0x08, 0x00, 0x18, 0x00, 'U', 'I', 0x02, 0x00, '1', 0x00}
+	second := []byte{0x08, 0x00, 0x18, 0x00, 'U', 'I', 0x02, 0x00, '2', 0x00}
+
+	tests := []struct {
+		name       string
+		opts       []StorageOption
+		wantStatus uint16
+		wantStored []byte
+	}{
+		{"overwrite by default", nil, dimse.StatusSuccess, second},
+		{"skip with warning", []StorageOption{WithDuplicatePolicy(DuplicateSkip)}, dimse.StatusCoercionOfDataElements, first},
+		{"reject", []StorageOption{WithDuplicatePolicy(DuplicateReject)}, dimse.StatusFailure, first},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewStorageService(t.TempDir(), tt.opts...)
+			ctx := context.Background()
+
+			if resp, _, err := service.HandleDIMSE(ctx, storeRequest(1, "1.2.3"), first, storeMeta()); err != nil || resp.Status != dimse.StatusSuccess {
+				t.Fatalf("First store = %+v, %v; want success", resp, err)
+			}
+			resp, _, err := service.HandleDIMSE(ctx, storeRequest(2, "1.2.3"), second, storeMeta())
+			if err != nil {
+				t.Fatalf("HandleDIMSE() error = %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("Second store status = 0x%04X, want 0x%04X", resp.Status, tt.wantStatus)
+			}
+
+			file, err := os.ReadFile(service.Path("1.2.3"))
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			stored, _, err := dicom.SplitPart10(file)
+			if err != nil {
+				t.Fatalf("SplitPart10() error = %v", err)
+			}
+			if !bytes.Equal(stored, tt.wantStored) {
+				t.Errorf("Stored dataset = % X, want % X", stored, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestStorageService_InvalidSOPInstanceUID(t *testing.T) {
+	service := NewStorageService(t.TempDir())
+
+	for _, uid := range []string{"..", "../1.2"} {
+		resp, _, err := service.HandleDIMSE(context.Background(), storeRequest(1, uid), nil, storeMeta())
+		if err != nil {
+			t.Fatalf("HandleDIMSE(%q) error = %v", uid, err)
+		}
+		if resp.Status != dimse.StatusFailure {
+			t.Errorf("HandleDIMSE(%q) status = 0x%04X, want failure", uid, resp.Status)
+		}
+	}
+
+	// Neither the command nor the dataset names the instance
+	resp, _, err := service.HandleDIMSE(context.Background(), storeRequest(1, ""), nil, storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE(\"\") error = %v", err)
+	}
+	if resp.Status != dimse.StatusDataSetDoesNotMatch {
+		t.Errorf("HandleDIMSE(\"\") status = 0x%04X, want 0xA900", resp.Status)
+	}
+}
+
+func TestStorageService_UIDsFromDataset(t *testing.T) {
+	instance := dicom.NewDataset()
+	instance.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0016}, dicom.VR_UI, types.MRImageStorage)
+	instance.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.840.99.7")
+	data, err := dicom.EncodeDatasetWithTransferSyntax(instance, types.ExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	// A non-conformant SCU that leaves both command UIDs out
+	request := storeRequest(1, "")
+	request.AffectedSOPClassUID = ""
+
+	service := NewStorageService(t.TempDir())
+	resp, _, err := service.HandleDIMSE(context.Background(), request, data, storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if resp.Status != dimse.StatusSuccess {
+		t.Fatalf("Status = 0x%04X, want success", resp.Status)
+	}
+	if resp.AffectedSOPInstanceUID != "1.2.840.99.7" || resp.AffectedSOPClassUID != types.MRImageStorage {
+		t.Errorf("Response UIDs = %q / %q, want the dataset's", resp.AffectedSOPClassUID, resp.AffectedSOPInstanceUID)
+	}
+
+	file, err := os.ReadFile(service.Path("1.2.840.99.7"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	meta, _, err := dicom.ParseFileMetaInfo(file)
+	if err != nil {
+		t.Fatalf("ParseFileMetaInfo() error = %v", err)
+	}
+	if got := meta.GetString(dicom.Tag{Group: 0x0002, Element: 0x0002}); got != types.MRImageStorage {
+		t.Errorf("Media Storage SOP Class UID = %q, want %q", got, types.MRImageStorage)
+	}
+}
+
+func TestStorageService_PixelDataValidation(t *testing.T) {
+	image := func(pixels int) []byte {
+		ds := dicom.NewDataset()
+		ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3")
+		ds.AddElement(dicom.Tag{Group: 0x0028, Element: 0x0002}, dicom.VR_US, uint16(1))
+		ds.AddElement(dicom.Tag{Group: 0x0028, Element: 0x0010}, dicom.VR_US, uint16(4))
+		ds.AddElement(dicom.Tag{Group: 0x0028, Element: 0x0011}, dicom.VR_US, uint16(4))
+		ds.AddElement(dicom.Tag{Group: 0x0028, Element: 0x0100}, dicom.VR_US, uint16(8))
+		ds.AddElement(dicom.Tag{Group: 0x7FE0, Element: 0x0010}, dicom.VR_OB, make([]byte, pixels))
+		return ds.EncodeDataset()
+	}
+
+	service := NewStorageService(t.TempDir(), WithPixelDataValidation())
+	ctx := context.Background()
+
+	resp, _, err := service.HandleDIMSE(ctx, storeRequest(1, "1.2.3"), image(10), storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if resp.Status != dimse.StatusDataSetDoesNotMatch {
+		t.Errorf("Status for truncated pixel data = 0x%04X, want 0xA900", resp.Status)
+	}
+	if _, err := os.Stat(service.Path("1.2.3")); !os.IsNotExist(err) {
+		t.Errorf("Rejected instance was stored (stat error %v)", err)
+	}
+
+	resp, _, err = service.HandleDIMSE(ctx, storeRequest(2, "1.2.3"), image(16), storeMeta())
+	if err != nil || resp.Status != dimse.StatusSuccess {
+		t.Errorf("Valid instance = %+v, %v; want success", resp, err)
+	}
+}
+
+func TestStorageService_Coercion(t *testing.T) {
+	patientID := dicom.Tag{Group: 0x0010, Element: 0x0020}
+	instance := dicom.NewDataset()
+	instance.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3")
+	instance.AddElement(patientID, dicom.VR_LO, "EXT-42")
+	data, err := dicom.EncodeDatasetWithTransferSyntax(instance, types.ExplicitVRLittleEndian)
+	if err != nil {
+		t.Fatalf("EncodeDatasetWithTransferSyntax() error = %v", err)
+	}
+
+	service := NewStorageService(t.TempDir(), WithCoercion(func(ds *dicom.Dataset) bool {
+		if id := ds.GetString(patientID); strings.HasPrefix(id, "EXT-") {
+			ds.AddElement(patientID, dicom.VR_LO, "LOCAL-"+strings.TrimPrefix(id, "EXT-"))
+			return true
+		}
+		return false
+	}))
+
+	resp, _, err := service.HandleDIMSE(context.Background(), storeRequest(1, "1.2.3"), data, storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if resp.Status != dimse.StatusCoercionOfDataElements {
+		t.Errorf("Status = 0x%04X, want 0xB000", resp.Status)
+	}
+
+	file, err := os.ReadFile(service.Path("1.2.3"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	dataset, transferSyntax, err := dicom.SplitPart10(file)
+	if err != nil {
+		t.Fatalf("SplitPart10() error = %v", err)
+	}
+	stored, err := dicom.ParseDatasetWithTransferSyntax(dataset, transferSyntax)
+	if err != nil {
+		t.Fatalf("ParseDatasetWithTransferSyntax() error = %v", err)
+	}
+	if got := stored.GetString(patientID); got != "LOCAL-42" {
+		t.Errorf("Stored Patient ID = %q, want LOCAL-42", got)
+	}
+}
+
+func TestStorageService_Reject(t *testing.T) {
+	blocked := map[string]bool{"1.2.666": true}
+	service := NewStorageService(t.TempDir(), WithReject(func(sopInstanceUID, sopClassUID string) (bool, uint16) {
+		return blocked[sopInstanceUID], 0
+	}))
+	ctx := context.Background()
+	data := []byte{0x08, 0x00, 0x18, 0x00, 'U', 'I', 0x02, 0x00, '1', 0x00}
+
+	resp, _, err := service.HandleDIMSE(ctx, storeRequest(1, "1.2.666"), data, storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if resp.Status != dimse.StatusOutOfResources {
+		t.Errorf("Status for blocked instance = 0x%04X, want 0xA700", resp.Status)
+	}
+	if _, err := os.Stat(service.Path("1.2.666")); !os.IsNotExist(err) {
+		t.Errorf("Blocked instance was stored (stat error %v)", err)
+	}
+
+	resp, _, err = service.HandleDIMSE(ctx, storeRequest(2, "1.2.7"), data, storeMeta())
+	if err != nil || resp.Status != dimse.StatusSuccess {
+		t.Errorf("Allowed instance = %+v, %v; want success", resp, err)
+	}
+}
+
+func TestStorageService_RejectNonFailureStatus(t *testing.T) {
+	data := []byte{0x08, 0x00, 0x18, 0x00, 'U', 'I', 0x02, 0x00, '1', 0x00}
+	tests := []struct {
+		status uint16
+		want   uint16
+	}{
+		{0x0000, dimse.StatusOutOfResources},
+		{0x0001, dimse.StatusOutOfResources},
+		{0x0107, dimse.StatusOutOfResources},
+		{0xB000, dimse.StatusOutOfResources},
+		{0xB007, dimse.StatusOutOfResources},
+		{0xFF00, dimse.StatusOutOfResources},
+		{0xFF01, dimse.StatusOutOfResources},
+		{0xFE00, dimse.StatusOutOfResources},
+		{0xA900, 0xA900},
+		{0xC001, 0xC001},
+		{dimse.StatusSOPClassNotSupported, dimse.StatusSOPClassNotSupported},
+	}
+	for _, tt := range tests {
+		service := NewStorageService(t.TempDir(), WithReject(func(string, string) (bool, uint16) {
+			return true, tt.status
+		}))
+		resp, _, err := service.HandleDIMSE(context.Background(), storeRequest(1, "1.2.666"), data, storeMeta())
+		if err != nil {
+			t.Fatalf("HandleDIMSE() error = %v", err)
+		}
+		if resp.Status != tt.want {
+			t.Errorf("Reject status 0x%04X answered with 0x%04X, want 0x%04X", tt.status, resp.Status, tt.want)
+		}
+		if _, err := os.Stat(service.Path("1.2.666")); !os.IsNotExist(err) {
+			t.Errorf("Rejected instance with status 0x%04X was stored", tt.status)
+		}
+	}
+}
+
+func TestStorageService_IODValidation(t *testing.T) {
+	instance := func(seriesUID string) []byte {
+		ds := dicom.NewDataset()
+		ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0016}, dicom.VR_UI, types.CTImageStorage)
+		ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0018}, dicom.VR_UI, "1.2.3")
+		ds.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0060}, dicom.VR_CS, "CT")
+		ds.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0010}, dicom.VR_PN, "DOE^JANE")
+		ds.AddElement(dicom.Tag{Group: 0x0010, Element: 0x0020}, dicom.VR_LO, "PID1")
+		ds.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000D}, dicom.VR_UI, "1.2")
+		if seriesUID != "" {
+			ds.AddElement(dicom.Tag{Group: 0x0020, Element: 0x000E}, dicom.VR_UI, seriesUID)
+		}
+		return ds.EncodeDataset()
+	}
+
+	service := NewStorageService(t.TempDir(), WithIODValidation())
+	ctx := context.Background()
+
+	resp, _, err := service.HandleDIMSE(ctx, storeRequest(1, "1.2.3"), instance(""), storeMeta())
+	if err != nil {
+		t.Fatalf("HandleDIMSE() error = %v", err)
+	}
+	if resp.Status != dimse.StatusDataSetDoesNotMatch {
+		t.Errorf("Status without Series Instance UID = 0x%04X, want 0xA900", resp.Status)
+	}
+	if len(resp.OffendingElements) != 1 || resp.OffendingElements[0] != (types.Tag{Group: 0x0020, Element: 0x000E}) {
+		t.Errorf("OffendingElements = %v, want [(0020,000E)]", resp.OffendingElements)
+	}
+	if _, err := os.Stat(service.Path("1.2.3")); !os.IsNotExist(err) {
+		t.Errorf("Rejected instance was stored (stat error %v)", err)
+	}
+
+	resp, _, err = service.HandleDIMSE(ctx, storeRequest(2, "1.2.3"), instance("1.2.1"), storeMeta())
+	if err != nil || resp.Status != dimse.StatusSuccess {
+		t.Errorf("Complete instance = %+v, %v; want success", resp, err)
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/interfaces"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+// specificCharacterSetTag is Specific Character Set (0008,0005), which
+// describes the query rather than being a matching or return key
+var specificCharacterSetTag = dicom.Tag{Group: 0x0008, Element: 0x0005}
+
+// WorklistProvider finds the scheduled procedure steps matching a Modality
+// Worklist query.
+type WorklistProvider interface {
+	FindWorklistItems(ctx context.Context, query *dicom.Dataset) ([]*dicom.Dataset, error)
+}
+
+// WorklistService handles Modality Worklist C-FIND requests, answering each
+// item from its provider with a pending response and ending with Success.
+//
+// When the service is given the keys its provider supports, matches of a
+// query that contains any other key are returned with status 0xFF01
+// (Pending: optional keys not supported), so the SCU knows those keys were
+// neither matched nor returned.
+type WorklistService struct {
+	provider      WorklistProvider
+	supportedKeys map[dicom.Tag]bool
+}
+
+// NewWorklistService creates a worklist service backed by provider.
+// supportedKeys lists the matching and return keys the provider handles,
+// including those nested in sequences such as the Scheduled Procedure Step
+// Sequence; when empty, every key is taken as supported.
+func NewWorklistService(provider WorklistProvider, supportedKeys ...dicom.Tag) *WorklistService {
+	s := &WorklistService{provider: provider}
+	if len(supportedKeys) > 0 {
+		s.supportedKeys = make(map[dicom.Tag]bool, len(supportedKeys))
+		for _, tag := range supportedKeys {
+			s.supportedKeys[tag] = true
+		}
+	}
+	return s
+}
+
+// UnsupportedKeys returns the keys of query, at any sequence depth, that
+// the provider does not support.
+func (s *WorklistService) UnsupportedKeys(query *dicom.Dataset) []dicom.Tag {
+	if s.supportedKeys == nil || query == nil {
+		return nil
+	}
+
+	tags := make([]dicom.Tag, 0, len(query.Elements))
+	for tag := range query.Elements {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Group != tags[j].Group {
+			return tags[i].Group < tags[j].Group
+		}
+		return tags[i].Element < tags[j].Element
+	})
+
+	var unsupported []dicom.Tag
+	for _, tag := range tags {
+		if tag == specificCharacterSetTag {
+			continue
+		}
+		if !s.supportedKeys[tag] {
+			unsupported = append(unsupported, tag)
+			continue
+		}
+		if items, ok := query.Elements[tag].Value.([]*dicom.Dataset); ok {
+			for _, item := range items {
+				unsupported = append(unsupported, s.UnsupportedKeys(item)...)
+			}
+		}
+	}
+	return unsupported
+}
+
+// HandleDIMSE answers a worklist C-FIND that reached the service without a
+// streaming responder; matches can only be returned through
+// HandleDIMSEStreaming.
+func (s *WorklistService) HandleDIMSE(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext) (*types.Message, *dicom.Dataset, error) {
+	response := NewCFindErrorResponse(msg, dimse.StatusFailure)
+	response.ErrorComment = "Worklist queries require a streaming responder"
+	return response, nil, nil
+}
+
+// HandleDIMSEStreaming queries the provider and sends one pending response
+// per worklist item, followed by the final Success response.
+func (s *WorklistService) HandleDIMSEStreaming(ctx context.Context, msg *types.Message, data []byte, meta interfaces.MessageContext, responder interfaces.ResponseSender) error {
+	query := meta.Dataset
+	if query == nil {
+		var err error
+		if query, err = dicom.ParseDatasetWithTransferSyntax(data, meta.TransferSyntaxUID); err != nil {
+			response := NewCFindErrorResponse(msg, dimse.StatusDataSetDoesNotMatch)
+			response.ErrorComment = "Invalid worklist identifier"
+			return responder.SendResponse(response, nil, meta.TransferSyntaxUID)
+		}
+	}
+
+	items, err := s.provider.FindWorklistItems(ctx, query)
+	if err != nil {
+		response := NewCFindErrorResponse(msg, dimse.StatusFailure)
+		response.ErrorComment = truncateComment(err.Error())
+		return responder.SendResponse(response, nil, meta.TransferSyntaxUID)
+	}
+
+	status := uint16(dimse.StatusPending)
+	if len(s.UnsupportedKeys(query)) > 0 {
+		status = dimse.StatusPendingOptionalKeysNotSupported
+	}
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := responder.SendResponse(NewResponseBuilder(msg).CFindResponse(status, true), item, meta.TransferSyntaxUID); err != nil {
+			return fmt.Errorf("failed to send worklist match: %w", err)
+		}
+	}
+
+	return responder.SendResponse(NewCFindSuccessResponse(msg), nil, meta.TransferSyntaxUID)
+}
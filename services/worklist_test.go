@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/caio-sobreiro/dicomnet/dicom"
+	"github.com/caio-sobreiro/dicomnet/dimse"
+	"github.com/caio-sobreiro/dicomnet/types"
+)
+
+var (
+	patientNameTag           = dicom.Tag{Group: 0x0010, Element: 0x0010}
+	patientIDTag             = dicom.Tag{Group: 0x0010, Element: 0x0020}
+	patientWeightTag         = dicom.Tag{Group: 0x0010, Element: 0x1030}
+	scheduledStepSequenceTag = dicom.Tag{Group: 0x0040, Element: 0x0100}
+	modalityTag              = dicom.Tag{Group: 0x0008, Element: 0x0060}
+	stationAETitleTag        = dicom.Tag{Group: 0x0040, Element: 0x0001}
+)
+
+type worklistProviderFunc func(ctx context.Context, query *dicom.Dataset) ([]*dicom.Dataset, error)
+
+func (f worklistProviderFunc) FindWorklistItems(ctx context.Context, query *dicom.Dataset) ([]*dicom.Dataset, error) {
+	return f(ctx, query)
+}
+
+func twoWorklistItems(context.Context, *dicom.Dataset) ([]*dicom.Dataset, error) {
+	var items []*dicom.Dataset
+	for _, id := range []string{"PID1", "PID2"} {
+		item := dicom.NewDataset()
+		item.AddElement(patientIDTag, dicom.VR_LO, id)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func worklistQuery(extra ...dicom.Tag) *dicom.Dataset {
+	step := dicom.NewDataset()
+	step.AddElement(modalityTag, dicom.VR_CS, "CT")
+	query := dicom.NewDataset()
+	query.AddElement(patientNameTag, dicom.VR_PN, "")
+	query.AddElement(patientIDTag, dicom.VR_LO, "")
+	query.AddElement(scheduledStepSequenceTag, dicom.VR_SQ, []*dicom.Dataset{step})
+	for _, tag := range extra {
+		query.AddUniversalKey(tag)
+	}
+	return query
+}
+
+func runWorklistQuery(t *testing.T, service *WorklistService, query *dicom.Dataset) *mockResponder {
+	t.Helper()
+	msg := &types.Message{
+		CommandField:        dimse.CFindRQ,
+		MessageID:           3,
+		AffectedSOPClassUID: types.ModalityWorklistInformationModelFind,
+	}
+	meta := testMeta()
+	meta.Dataset = query
+	responder := &mockResponder{}
+	if err := service.HandleDIMSEStreaming(context.Background(), msg, nil, meta, responder); err != nil {
+		t.Fatalf("HandleDIMSEStreaming() error = %v", err)
+	}
+	return responder
+}
+
+func TestWorklistService_OptionalKeysNotSupported(t *testing.T) {
+	service := NewWorklistService(worklistProviderFunc(twoWorklistItems),
+		patientNameTag, patientIDTag, scheduledStepSequenceTag, modalityTag)
+
+	tests := []struct {
+		name        string
+		query       *dicom.Dataset
+		wantPending uint16
+	}{
+		{"all keys supported", worklistQuery(), dimse.StatusPending},
+		{"unsupported top-level key", worklistQuery(patientWeightTag), dimse.StatusPendingOptionalKeysNotSupported},
+	}
+
+	nested := worklistQuery()
+	nested.Elements[scheduledStepSequenceTag].Value.([]*dicom.Dataset)[0].AddUniversalKey(stationAETitleTag)
+	tests = append(tests, struct {
+		name        string
+		query       *dicom.Dataset
+		wantPending uint16
+	}{"unsupported key in sequence", nested, dimse.StatusPendingOptionalKeysNotSupported})
+
+	for _, tt := range tests {
+		responder := runWorklistQuery(t, service, tt.query)
+		if len(responder.responses) != 3 {
+			t.Fatalf("%s: got %d responses, want 2 matches and a final", tt.name, len(responder.responses))
+		}
+		for i, resp := range responder.responses[:2] {
+			if resp.Status != tt.wantPending || responder.datasets[i] == nil {
+				t.Errorf("%s: match %d status = 0x%04X, want 0x%04X with a dataset", tt.name, i, resp.Status, tt.wantPending)
+			}
+		}
+		if final := responder.responses[2]; final.Status != dimse.StatusSuccess || responder.datasets[2] != nil {
+			t.Errorf("%s: final status = 0x%04X, want success without a dataset", tt.name, final.Status)
+		}
+	}
+}
+
+func TestWorklistService_UnsupportedKeys(t *testing.T) {
+	service := NewWorklistService(worklistProviderFunc(twoWorklistItems), patientNameTag, patientIDTag, scheduledStepSequenceTag)
+
+	query := worklistQuery(patientWeightTag)
+	query.AddElement(dicom.Tag{Group: 0x0008, Element: 0x0005}, dicom.VR_CS, "ISO_IR 192")
+
+	got := service.UnsupportedKeys(query)
+	want := []dicom.Tag{patientWeightTag, modalityTag} // In tag order, sequence items in place
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("UnsupportedKeys() = %v, want %v", got, want)
+	}
+
+	if keys := NewWorklistService(worklistProviderFunc(twoWorklistItems)).UnsupportedKeys(query); keys != nil {
+		t.Errorf("UnsupportedKeys() without supported keys = %v, want nil", keys)
+	}
+}
+
+func TestWorklistService_ProviderError(t *testing.T) {
+	service := NewWorklistService(worklistProviderFunc(func(context.Context, *dicom.Dataset) ([]*dicom.Dataset, error) {
+		return nil, errors.New("worklist database unavailable")
+	}))
+
+	responder := runWorklistQuery(t, service, worklistQuery())
+	if len(responder.responses) != 1 {
+		t.Fatalf("got %d responses, want a single failure", len(responder.responses))
+	}
+	if resp := responder.responses[0]; resp.Status != dimse.StatusFailure || resp.ErrorComment != "worklist database unavailable" {
+		t.Errorf("response = status 0x%04X, comment %q", resp.Status, resp.ErrorComment)
+	}
+}
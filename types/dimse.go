@@ -13,6 +13,20 @@ const (
 	CEchoRQ   = 0x0030
 	CEchoRSP  = 0x8030
 	CCancelRQ = 0x0FFF
+
+	// DIMSE-N commands (PS3.7 9.3)
+	NEventReportRQ  = 0x0100
+	NEventReportRSP = 0x8100
+	NGetRQ          = 0x0110
+	NGetRSP         = 0x8110
+	NSetRQ          = 0x0120
+	NSetRSP         = 0x8120
+	NActionRQ       = 0x0130
+	NActionRSP      = 0x8130
+	NCreateRQ       = 0x0140
+	NCreateRSP      = 0x8140
+	NDeleteRQ       = 0x0150
+	NDeleteRSP      = 0x8150
 )
 
 // DIMSE Status codes
@@ -20,6 +34,8 @@ const (
 	StatusSuccess = 0x0000
 	StatusPending = 0xFF00
 	StatusFailure = 0xC000
+
+	StatusMoveDestinationUnknown = 0xA801
 )
 
 // Message represents a parsed DIMSE command
@@ -29,6 +45,9 @@ type Message struct {
 	AffectedSOPClassUID       string
 	AffectedSOPInstanceUID    string
 	RequestedSOPClassUID      string
+	RequestedSOPInstanceUID   string // For N-SET, N-GET, N-ACTION and N-DELETE requests
+	EventTypeID               uint16 // For N-EVENT-REPORT requests and responses (0000,1002)
+	ActionTypeID              uint16 // For N-ACTION requests and responses (0000,1008)
 	Priority                  uint16
 	CommandDataSetType        uint16
 	Status                    uint16
@@ -41,6 +60,23 @@ type Message struct {
 	NumberOfCompletedSuboperations *uint16
 	NumberOfFailedSuboperations    *uint16
 	NumberOfWarningSuboperations   *uint16
+
+	// C-STORE sub-operations of a C-MOVE carry the originating request
+	MoveOriginatorAETitle   string // Move Originator Application Entity Title (0000,1030)
+	MoveOriginatorMessageID uint16 // Move Originator Message ID (0000,1031)
+
+	// Failure details reported in responses
+	OffendingElements []Tag  // Offending Element (0000,0901)
+	ErrorComment      string // Error Comment (0000,0902)
+}
+
+// EffectiveSOPClassUID returns the Affected SOP Class UID (0000,0002), or
+// the Requested SOP Class UID (0000,0003) for peers that send only that one
+func (m *Message) EffectiveSOPClassUID() string {
+	if m.AffectedSOPClassUID != "" {
+		return m.AffectedSOPClassUID
+	}
+	return m.RequestedSOPClassUID
 }
 
 // ResponseCommandFor maps a DIMSE request command to its corresponding response command.
@@ -16,6 +16,10 @@ func TestDIMSECommandConstants(t *testing.T) {
 		{"C-MOVE-RSP", CMoveRSP, 0x8021},
 		{"C-ECHO-RQ", CEchoRQ, 0x0030},
 		{"C-ECHO-RSP", CEchoRSP, 0x8030},
+		{"N-SET-RQ", NSetRQ, 0x0120},
+		{"N-SET-RSP", NSetRSP, 0x8120},
+		{"N-CREATE-RQ", NCreateRQ, 0x0140},
+		{"N-CREATE-RSP", NCreateRSP, 0x8140},
 	}
 
 	for _, tt := range tests {
@@ -168,3 +172,23 @@ func TestMessage_ZeroValues(t *testing.T) {
 		t.Errorf("Zero Message Status = 0x%04x, want 0x0000", msg.Status)
 	}
 }
+
+func TestMessage_EffectiveSOPClassUID(t *testing.T) {
+	tests := []struct {
+		name      string
+		affected  string
+		requested string
+		want      string
+	}{
+		{"affected only", CTImageStorage, "", CTImageStorage},
+		{"requested only", "", MRImageStorage, MRImageStorage},
+		{"affected preferred", CTImageStorage, MRImageStorage, CTImageStorage},
+		{"neither", "", "", ""},
+	}
+	for _, tt := range tests {
+		msg := &Message{AffectedSOPClassUID: tt.affected, RequestedSOPClassUID: tt.requested}
+		if got := msg.EffectiveSOPClassUID(); got != tt.want {
+			t.Errorf("%s: EffectiveSOPClassUID() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
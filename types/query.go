@@ -1,5 +1,7 @@
 package types
 
+import "slices"
+
 // QueryLevel represents the level of C-FIND query
 type QueryLevel string
 
@@ -10,6 +12,40 @@ const (
 	QueryLevelImage   QueryLevel = "IMAGE"
 )
 
+var (
+	patientRootLevels      = []QueryLevel{QueryLevelPatient, QueryLevelStudy, QueryLevelSeries, QueryLevelImage}
+	studyRootLevels        = []QueryLevel{QueryLevelStudy, QueryLevelSeries, QueryLevelImage}
+	patientStudyOnlyLevels = []QueryLevel{QueryLevelPatient, QueryLevelStudy}
+)
+
+// modelLevels lists the levels of each hierarchical Query/Retrieve
+// information model (PS3.4 C.6)
+var modelLevels = map[string][]QueryLevel{
+	PatientRootQueryRetrieveInformationModelFind:      patientRootLevels,
+	PatientRootQueryRetrieveInformationModelMove:      patientRootLevels,
+	PatientRootQueryRetrieveInformationModelGet:       patientRootLevels,
+	StudyRootQueryRetrieveInformationModelFind:        studyRootLevels,
+	StudyRootQueryRetrieveInformationModelMove:        studyRootLevels,
+	StudyRootQueryRetrieveInformationModelGet:         studyRootLevels,
+	PatientStudyOnlyQueryRetrieveInformationModelFind: patientStudyOnlyLevels,
+	PatientStudyOnlyQueryRetrieveInformationModelMove: patientStudyOnlyLevels,
+	PatientStudyOnlyQueryRetrieveInformationModelGet:  patientStudyOnlyLevels,
+}
+
+// QueryLevelsForModel returns the Query/Retrieve Levels of a Patient Root,
+// Study Root or Patient/Study Only SOP class, from the top down, or nil for
+// other SOP classes.
+func QueryLevelsForModel(sopClassUID string) []QueryLevel {
+	return modelLevels[sopClassUID]
+}
+
+// IsValidQueryLevel returns true if level exists in the information model of
+// a hierarchical Q/R SOP class. SOP classes without levels accept any level.
+func IsValidQueryLevel(sopClassUID string, level QueryLevel) bool {
+	levels, ok := modelLevels[sopClassUID]
+	return !ok || slices.Contains(levels, level)
+}
+
 // QueryRequest represents a parsed C-FIND query
 type QueryRequest struct {
 	Level              QueryLevel
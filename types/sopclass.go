@@ -1,5 +1,7 @@
 package types
 
+import "sort"
+
 // DICOM Application Context UID
 // The Application Context defines the DICOM application-level message exchange rules.
 const ApplicationContextUID = "1.2.840.10008.3.1.1.1"
@@ -112,6 +114,12 @@ const (
 	OphthalmicOpticalCoherenceTomographyEnFaceImageStorage            = "1.2.840.10008.5.1.4.1.1.77.1.5.8"
 	OphthalmicOpticalCoherenceTomographyBscanVolumeAnalysisStorage    = "1.2.840.10008.5.1.4.1.1.77.1.5.9"
 
+	// Structured Reporting
+	BasicTextSRStorage        = "1.2.840.10008.5.1.4.1.1.88.11"
+	EnhancedSRStorage         = "1.2.840.10008.5.1.4.1.1.88.22"
+	ComprehensiveSRStorage    = "1.2.840.10008.5.1.4.1.1.88.33"
+	KeyObjectSelectionStorage = "1.2.840.10008.5.1.4.1.1.88.59"
+
 	// Encapsulated Documents
 	EncapsulatedPDFStorage = "1.2.840.10008.5.1.4.1.1.104.1"
 	EncapsulatedCDAStorage = "1.2.840.10008.5.1.4.1.1.104.2"
@@ -245,6 +253,94 @@ func IsQueryRetrieveSOPClass(uid string) bool {
 	return info.Category == "Query/Retrieve"
 }
 
+// IsUPSSOPClass returns true if the UID is a Unified Procedure Step SOP class
+func IsUPSSOPClass(uid string) bool {
+	info := GetSOPClassInfo(uid)
+	return info.Category == "UPS"
+}
+
+// nonPatientModels maps the storage SOP classes of non-patient objects to
+// the FIND, MOVE and GET models of their own information model; they are
+// not in the Patient/Study hierarchy of the Q/R models (PS3.4 Annex U, X, BB)
+var nonPatientModels = map[string][3]string{
+	HangingProtocolStorage: {HangingProtocolInformationModelFind, HangingProtocolInformationModelMove, HangingProtocolInformationModelGet},
+	ColorPaletteStorage:    {ColorPaletteInformationModelFind, ColorPaletteInformationModelMove, ColorPaletteInformationModelGet},
+	GenericImplantTemplateStorage: {
+		GenericImplantTemplateInformationModelFind, GenericImplantTemplateInformationModelMove, GenericImplantTemplateInformationModelGet,
+	},
+	ImplantAssemblyTemplateStorage: {
+		ImplantAssemblyTemplateInformationModelFind, ImplantAssemblyTemplateInformationModelMove, ImplantAssemblyTemplateInformationModelGet,
+	},
+	ImplantTemplateGroupStorage: {
+		ImplantTemplateGroupInformationModelFind, ImplantTemplateGroupInformationModelMove, ImplantTemplateGroupInformationModelGet,
+	},
+}
+
+// retrieveModels holds the C-MOVE and C-GET SOP classes
+var retrieveModels = map[string]bool{
+	StudyRootQueryRetrieveInformationModelMove:        true,
+	StudyRootQueryRetrieveInformationModelGet:         true,
+	PatientRootQueryRetrieveInformationModelMove:      true,
+	PatientRootQueryRetrieveInformationModelGet:       true,
+	PatientStudyOnlyQueryRetrieveInformationModelMove: true,
+	PatientStudyOnlyQueryRetrieveInformationModelGet:  true,
+	CompositeInstanceRootRetrieveMove:                 true,
+	CompositeInstanceRootRetrieveGet:                  true,
+	CompositeInstanceRetrieveWithoutBulkDataGet:       true,
+	DefinedProcedureProtocolInformationModelMove:      true,
+	DefinedProcedureProtocolInformationModelGet:       true,
+	HangingProtocolInformationModelMove:               true,
+	HangingProtocolInformationModelGet:                true,
+	ColorPaletteInformationModelMove:                  true,
+	ColorPaletteInformationModelGet:                   true,
+	GenericImplantTemplateInformationModelMove:        true,
+	GenericImplantTemplateInformationModelGet:         true,
+	ImplantAssemblyTemplateInformationModelMove:       true,
+	ImplantAssemblyTemplateInformationModelGet:        true,
+	ImplantTemplateGroupInformationModelMove:          true,
+	ImplantTemplateGroupInformationModelGet:           true,
+}
+
+// QRModelForStorage returns the FIND, MOVE and GET SOP classes to query and
+// retrieve instances of a storage SOP class with. Composite instances, and
+// SOP classes not known here, use the Study Root models; non-patient objects
+// such as Hanging Protocols, Color Palettes and Implant Templates use their
+// own information models.
+func QRModelForStorage(sopClassUID string) (findModel, moveModel, getModel string) {
+	if models, ok := nonPatientModels[sopClassUID]; ok {
+		return models[0], models[1], models[2]
+	}
+	return StudyRootQueryRetrieveInformationModelFind,
+		StudyRootQueryRetrieveInformationModelMove,
+		StudyRootQueryRetrieveInformationModelGet
+}
+
+// IsRetrieveModel returns true if the UID is a C-MOVE or C-GET SOP class
+func IsRetrieveModel(uid string) bool {
+	return retrieveModels[uid]
+}
+
+// StorageSOPClasses returns the UIDs of all known storage SOP classes, sorted
+func StorageSOPClasses() []string {
+	return sopClassesInCategory("Storage")
+}
+
+// UPSSOPClasses returns the UIDs of the Unified Procedure Step SOP classes, sorted
+func UPSSOPClasses() []string {
+	return sopClassesInCategory("UPS")
+}
+
+func sopClassesInCategory(category string) []string {
+	var uids []string
+	for uid, info := range sopClassRegistry {
+		if info.Category == category {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	return uids
+}
+
 // sopClassRegistry maps SOP Class UIDs to their information
 var sopClassRegistry = map[string]SOPClassInfo{
 	// Verification
@@ -379,12 +475,61 @@ var sopClassRegistry = map[string]SOPClassInfo{
 		Category: "Query/Retrieve",
 	},
 
+	// Query/Retrieve - Patient/Study Only (retired)
+	PatientStudyOnlyQueryRetrieveInformationModelFind: {
+		UID:      PatientStudyOnlyQueryRetrieveInformationModelFind,
+		Name:     "Patient/Study Only Query/Retrieve - FIND",
+		Category: "Query/Retrieve",
+	},
+	PatientStudyOnlyQueryRetrieveInformationModelMove: {
+		UID:      PatientStudyOnlyQueryRetrieveInformationModelMove,
+		Name:     "Patient/Study Only Query/Retrieve - MOVE",
+		Category: "Query/Retrieve",
+	},
+	PatientStudyOnlyQueryRetrieveInformationModelGet: {
+		UID:      PatientStudyOnlyQueryRetrieveInformationModelGet,
+		Name:     "Patient/Study Only Query/Retrieve - GET",
+		Category: "Query/Retrieve",
+	},
+
 	// Worklist
 	ModalityWorklistInformationModelFind: {
 		UID:      ModalityWorklistInformationModelFind,
 		Name:     "Modality Worklist - FIND",
 		Category: "Worklist",
 	},
+	GeneralPurposeWorklistInformationModelFind: {
+		UID:      GeneralPurposeWorklistInformationModelFind,
+		Name:     "General Purpose Worklist - FIND",
+		Category: "Worklist",
+	},
+
+	// Unified Procedure Step
+	UnifiedProcedureStepPushSOPClass: {
+		UID:      UnifiedProcedureStepPushSOPClass,
+		Name:     "Unified Procedure Step - Push",
+		Category: "UPS",
+	},
+	UnifiedProcedureStepWatchSOPClass: {
+		UID:      UnifiedProcedureStepWatchSOPClass,
+		Name:     "Unified Procedure Step - Watch",
+		Category: "UPS",
+	},
+	UnifiedProcedureStepPullSOPClass: {
+		UID:      UnifiedProcedureStepPullSOPClass,
+		Name:     "Unified Procedure Step - Pull",
+		Category: "UPS",
+	},
+	UnifiedProcedureStepEventSOPClass: {
+		UID:      UnifiedProcedureStepEventSOPClass,
+		Name:     "Unified Procedure Step - Event",
+		Category: "UPS",
+	},
+	UnifiedProcedureStepQuerySOPClass: {
+		UID:      UnifiedProcedureStepQuerySOPClass,
+		Name:     "Unified Procedure Step - Query",
+		Category: "UPS",
+	},
 
 	// MPPS
 	ModalityPerformedProcedureStepSOPClass: {
@@ -400,6 +545,28 @@ var sopClassRegistry = map[string]SOPClassInfo{
 		Category: "Storage Commitment",
 	},
 
+	// Structured Reporting
+	BasicTextSRStorage: {
+		UID:      BasicTextSRStorage,
+		Name:     "Basic Text SR Storage",
+		Category: "Storage",
+	},
+	EnhancedSRStorage: {
+		UID:      EnhancedSRStorage,
+		Name:     "Enhanced SR Storage",
+		Category: "Storage",
+	},
+	ComprehensiveSRStorage: {
+		UID:      ComprehensiveSRStorage,
+		Name:     "Comprehensive SR Storage",
+		Category: "Storage",
+	},
+	KeyObjectSelectionStorage: {
+		UID:      KeyObjectSelectionStorage,
+		Name:     "Key Object Selection Document Storage",
+		Category: "Storage",
+	},
+
 	// Encapsulated Documents
 	EncapsulatedPDFStorage: {
 		UID:      EncapsulatedPDFStorage,
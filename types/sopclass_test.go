@@ -146,3 +146,101 @@ func TestSOPClassConstants(t *testing.T) {
 		})
 	}
 }
+
+func TestUPSSOPClasses(t *testing.T) {
+	uids := UPSSOPClasses()
+	if len(uids) != 5 {
+		t.Fatalf("UPSSOPClasses() = %v, want the 5 UPS SOP classes", uids)
+	}
+	for _, uid := range uids {
+		if !IsUPSSOPClass(uid) || GetSOPClassInfo(uid).Category != "UPS" {
+			t.Errorf("%s not categorized as UPS", uid)
+		}
+	}
+	if IsUPSSOPClass(GeneralPurposeWorklistInformationModelFind) || IsUPSSOPClass(CTImageStorage) {
+		t.Error("IsUPSSOPClass() true for a non-UPS SOP class")
+	}
+	if info := GetSOPClassInfo(GeneralPurposeWorklistInformationModelFind); info.Category != "Worklist" {
+		t.Errorf("General Purpose Worklist category = %q, want Worklist", info.Category)
+	}
+}
+
+func TestQRModelForStorage(t *testing.T) {
+	tests := []struct {
+		name                        string
+		sopClassUID                 string
+		wantFind, wantMove, wantGet string
+	}{
+		{"CT Image Storage", CTImageStorage,
+			StudyRootQueryRetrieveInformationModelFind, StudyRootQueryRetrieveInformationModelMove, StudyRootQueryRetrieveInformationModelGet},
+		{"Unknown SOP class", "1.2.3.4.5.6.7.8.9",
+			StudyRootQueryRetrieveInformationModelFind, StudyRootQueryRetrieveInformationModelMove, StudyRootQueryRetrieveInformationModelGet},
+		{"Hanging Protocol", HangingProtocolStorage,
+			HangingProtocolInformationModelFind, HangingProtocolInformationModelMove, HangingProtocolInformationModelGet},
+		{"Implant Template Group", ImplantTemplateGroupStorage,
+			ImplantTemplateGroupInformationModelFind, ImplantTemplateGroupInformationModelMove, ImplantTemplateGroupInformationModelGet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			find, move, get := QRModelForStorage(tt.sopClassUID)
+			if find != tt.wantFind || move != tt.wantMove || get != tt.wantGet {
+				t.Errorf("QRModelForStorage(%s) = %s, %s, %s; want %s, %s, %s",
+					tt.sopClassUID, find, move, get, tt.wantFind, tt.wantMove, tt.wantGet)
+			}
+			if !IsRetrieveModel(move) || !IsRetrieveModel(get) || IsRetrieveModel(find) {
+				t.Errorf("IsRetrieveModel() misclassifies the models for %s", tt.sopClassUID)
+			}
+		})
+	}
+}
+
+func TestIsRetrieveModel(t *testing.T) {
+	tests := []struct {
+		name string
+		uid  string
+		want bool
+	}{
+		{"Study Root MOVE", StudyRootQueryRetrieveInformationModelMove, true},
+		{"Patient Root GET", PatientRootQueryRetrieveInformationModelGet, true},
+		{"Composite Instance Root MOVE", CompositeInstanceRootRetrieveMove, true},
+		{"Without Bulk Data GET", CompositeInstanceRetrieveWithoutBulkDataGet, true},
+		{"Study Root FIND", StudyRootQueryRetrieveInformationModelFind, false},
+		{"Modality Worklist", ModalityWorklistInformationModelFind, false},
+		{"CT Image Storage", CTImageStorage, false},
+		{"Unknown", "1.2.3.4.5.6.7.8.9", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetrieveModel(tt.uid); got != tt.want {
+				t.Errorf("IsRetrieveModel(%s) = %v, want %v", tt.uid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidQueryLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		uid   string
+		level QueryLevel
+		want  bool
+	}{
+		{"Patient Root IMAGE", PatientRootQueryRetrieveInformationModelFind, QueryLevelImage, true},
+		{"Study Root PATIENT", StudyRootQueryRetrieveInformationModelMove, QueryLevelPatient, false},
+		{"Study Root SERIES", StudyRootQueryRetrieveInformationModelGet, QueryLevelSeries, true},
+		{"Patient/Study Only STUDY", PatientStudyOnlyQueryRetrieveInformationModelFind, QueryLevelStudy, true},
+		{"Patient/Study Only SERIES", PatientStudyOnlyQueryRetrieveInformationModelFind, QueryLevelSeries, false},
+		{"Patient/Study Only IMAGE", PatientStudyOnlyQueryRetrieveInformationModelGet, QueryLevelImage, false},
+		{"Modality Worklist", ModalityWorklistInformationModelFind, QueryLevelSeries, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidQueryLevel(tt.uid, tt.level); got != tt.want {
+				t.Errorf("IsValidQueryLevel(%s, %s) = %v, want %v", tt.uid, tt.level, got, tt.want)
+			}
+		})
+	}
+}
@@ -149,13 +149,13 @@ const (
 
 // TransferSyntaxInfo provides metadata about a transfer syntax
 type TransferSyntaxInfo struct {
-	UID                string
-	Name               string
-	IsCompressed       bool
-	IsLossless         bool
-	IsRetired          bool
+	UID                  string
+	Name                 string
+	IsCompressed         bool
+	IsLossless           bool
+	IsRetired            bool
 	SupportsEncapsulated bool
-	Description        string
+	Description          string
 }
 
 // GetTransferSyntaxInfo returns information about a transfer syntax UID
@@ -192,6 +192,19 @@ func IsRetired(uid string) bool {
 	return info.IsRetired
 }
 
+// IsEncapsulated returns true if pixel data is encoded as encapsulated
+// fragments under the transfer syntax
+func IsEncapsulated(uid string) bool {
+	info := GetTransferSyntaxInfo(uid)
+	return info.SupportsEncapsulated
+}
+
+// IsKnownTransferSyntax returns true if the UID is in the transfer syntax registry
+func IsKnownTransferSyntax(uid string) bool {
+	_, ok := transferSyntaxRegistry[uid]
+	return ok
+}
+
 // transferSyntaxRegistry maps transfer syntax UIDs to their information
 var transferSyntaxRegistry = map[string]TransferSyntaxInfo{
 	// Uncompressed
@@ -220,153 +233,153 @@ var transferSyntaxRegistry = map[string]TransferSyntaxInfo{
 		Description:  "Explicit VR encoding with big endian byte order (retired)",
 	},
 	DeflatedExplicitVRLittleEndian: {
-		UID:                DeflatedExplicitVRLittleEndian,
-		Name:               "Deflated Explicit VR Little Endian",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  DeflatedExplicitVRLittleEndian,
+		Name:                 "Deflated Explicit VR Little Endian",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: false,
-		Description:        "Deflate/zlib compression with explicit VR encoding",
+		Description:          "Deflate/zlib compression with explicit VR encoding",
 	},
 
 	// JPEG Lossy
 	JPEGBaseline8Bit: {
-		UID:                JPEGBaseline8Bit,
-		Name:               "JPEG Baseline (Process 1)",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  JPEGBaseline8Bit,
+		Name:                 "JPEG Baseline (Process 1)",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG lossy compression, 8-bit samples",
+		Description:          "JPEG lossy compression, 8-bit samples",
 	},
 	JPEGExtended12Bit: {
-		UID:                JPEGExtended12Bit,
-		Name:               "JPEG Extended (Process 2 & 4)",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  JPEGExtended12Bit,
+		Name:                 "JPEG Extended (Process 2 & 4)",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG lossy compression, 8-12 bit samples",
+		Description:          "JPEG lossy compression, 8-12 bit samples",
 	},
 
 	// JPEG Lossless
 	JPEGLossless: {
-		UID:                JPEGLossless,
-		Name:               "JPEG Lossless (Process 14)",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  JPEGLossless,
+		Name:                 "JPEG Lossless (Process 14)",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG lossless compression",
+		Description:          "JPEG lossless compression",
 	},
 	JPEGLosslessSV1: {
-		UID:                JPEGLosslessSV1,
-		Name:               "JPEG Lossless, Non-Hierarchical, First-Order Prediction",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  JPEGLosslessSV1,
+		Name:                 "JPEG Lossless, Non-Hierarchical, First-Order Prediction",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG lossless compression with prediction (most common)",
+		Description:          "JPEG lossless compression with prediction (most common)",
 	},
 
 	// JPEG 2000
 	JPEG2000Lossless: {
-		UID:                JPEG2000Lossless,
-		Name:               "JPEG 2000 Lossless Only",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  JPEG2000Lossless,
+		Name:                 "JPEG 2000 Lossless Only",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG 2000 lossless compression",
+		Description:          "JPEG 2000 lossless compression",
 	},
 	JPEG2000: {
-		UID:                JPEG2000,
-		Name:               "JPEG 2000",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  JPEG2000,
+		Name:                 "JPEG 2000",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG 2000 lossy or lossless compression",
+		Description:          "JPEG 2000 lossy or lossless compression",
 	},
 
 	// JPEG-LS
 	JPEGLSLossless: {
-		UID:                JPEGLSLossless,
-		Name:               "JPEG-LS Lossless",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  JPEGLSLossless,
+		Name:                 "JPEG-LS Lossless",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG-LS lossless compression",
+		Description:          "JPEG-LS lossless compression",
 	},
 	JPEGLSNearLossless: {
-		UID:                JPEGLSNearLossless,
-		Name:               "JPEG-LS Near-Lossless",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  JPEGLSNearLossless,
+		Name:                 "JPEG-LS Near-Lossless",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "JPEG-LS near-lossless compression with bounded error",
+		Description:          "JPEG-LS near-lossless compression with bounded error",
 	},
 
 	// RLE
 	RLELossless: {
-		UID:                RLELossless,
-		Name:               "RLE Lossless",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  RLELossless,
+		Name:                 "RLE Lossless",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "Run-Length Encoding lossless compression",
+		Description:          "Run-Length Encoding lossless compression",
 	},
 
 	// MPEG
 	MPEG2MainProfile: {
-		UID:                MPEG2MainProfile,
-		Name:               "MPEG2 Main Profile @ Main Level",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  MPEG2MainProfile,
+		Name:                 "MPEG2 Main Profile @ Main Level",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "MPEG-2 video compression",
+		Description:          "MPEG-2 video compression",
 	},
 	MPEG4AVCH264HighProfile: {
-		UID:                MPEG4AVCH264HighProfile,
-		Name:               "MPEG-4 AVC/H.264 High Profile",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  MPEG4AVCH264HighProfile,
+		Name:                 "MPEG-4 AVC/H.264 High Profile",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "H.264 video compression",
+		Description:          "H.264 video compression",
 	},
 	HEVCH265MainProfileLevel51: {
-		UID:                HEVCH265MainProfileLevel51,
-		Name:               "HEVC/H.265 Main Profile",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  HEVCH265MainProfileLevel51,
+		Name:                 "HEVC/H.265 Main Profile",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "H.265/HEVC video compression",
+		Description:          "H.265/HEVC video compression",
 	},
 
 	// High-Throughput JPEG 2000
 	HTJ2KLossless: {
-		UID:                HTJ2KLossless,
-		Name:               "High-Throughput JPEG 2000 Lossless",
-		IsCompressed:       true,
-		IsLossless:         true,
-		IsRetired:          false,
+		UID:                  HTJ2KLossless,
+		Name:                 "High-Throughput JPEG 2000 Lossless",
+		IsCompressed:         true,
+		IsLossless:           true,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "HTJ2K lossless compression (fast JPEG 2000 variant)",
+		Description:          "HTJ2K lossless compression (fast JPEG 2000 variant)",
 	},
 	HTJ2K: {
-		UID:                HTJ2K,
-		Name:               "High-Throughput JPEG 2000",
-		IsCompressed:       true,
-		IsLossless:         false,
-		IsRetired:          false,
+		UID:                  HTJ2K,
+		Name:                 "High-Throughput JPEG 2000",
+		IsCompressed:         true,
+		IsLossless:           false,
+		IsRetired:            false,
 		SupportsEncapsulated: true,
-		Description:        "HTJ2K lossy or lossless compression",
+		Description:          "HTJ2K lossy or lossless compression",
 	},
 }
 